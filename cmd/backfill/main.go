@@ -0,0 +1,309 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command backfill replays archived metrics into a MetricSink, for migrating
+// historical data when a new sink is adopted. It reads Cloud Logging entries
+// written by the metrics server's LogSink, either exported to Cloud Storage
+// (one entry per line) or from "gcloud logging read --format=json", and
+// newline delimited MetricRecords, as POSTed by HTTPSink. Entries which appear
+// in more than one input, e.g. from overlapping exports, are replayed once.
+//
+// Example:
+//
+//	backfill -sink-url https://collector.example.com/metrics logs/*.json
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+	"github.com/abcxyz/abc-updater/pkg/server"
+	"github.com/abcxyz/pkg/logging"
+)
+
+var (
+	sinkURL = flag.String("sink-url", "", "URL to POST each metric to, as with the server's shadow sink. Required unless -dry-run is set.")
+	dryRun  = flag.Bool("dry-run", false, "Parse and deduplicate the inputs without writing any metrics.")
+)
+
+// maxLineBytes limits the size of a single log entry.
+const maxLineBytes = 1 << 20
+
+// logEntry is the subset of a Cloud Logging LogEntry read by backfill.
+type logEntry struct {
+	LogName     string      `json:"logName"`
+	InsertID    string      `json:"insertId"`
+	Timestamp   time.Time   `json:"timestamp"`
+	JSONPayload *logPayload `json:"jsonPayload"`
+}
+
+// logPayload is the payload of an entry logged by server.LogSink.
+type logPayload struct {
+	Message string     `json:"message"`
+	Metric  *logMetric `json:"metric"`
+}
+
+// logMetric is the "metric" group of attributes logged by server.LogSink.
+type logMetric struct {
+	AppID          string  `json:"app_id"`
+	AppVersion     string  `json:"app_version"`
+	InstallID      string  `json:"install_id"`
+	InstallCohort  string  `json:"install_cohort"`
+	UpgradedFrom   string  `json:"upgraded_from"`
+	DowngradedFrom string  `json:"downgraded_from"`
+	Owner          string  `json:"owner"`
+	Retired        bool    `json:"retired"`
+	Name           string  `json:"name"`
+	Count          int64   `json:"count"`
+	Sink           string  `json:"sink"`
+	SampleRate     float64 `json:"sample_rate"`
+	BuildCommit    string  `json:"build_commit"`
+	BuildDate      string  `json:"build_date"`
+	GoVersion      string  `json:"go_version"`
+	Builder        string  `json:"builder"`
+	Sequence       int64   `json:"sequence"`
+
+	// EventTime is only logged if it differs from the entry's timestamp.
+	EventTime time.Time `json:"event_time"`
+}
+
+// record converts m, logged at receivedAt, to the MetricRecord it was logged
+// from.
+func (m *logMetric) record(receivedAt time.Time) *server.MetricRecord {
+	r := &server.MetricRecord{
+		AppID:          m.AppID,
+		Owner:          m.Owner,
+		AppVersion:     m.AppVersion,
+		InstallID:      m.InstallID,
+		InstallCohort:  m.InstallCohort,
+		UpgradedFrom:   m.UpgradedFrom,
+		DowngradedFrom: m.DowngradedFrom,
+		Name:           m.Name,
+		Count:          m.Count,
+		Sink:           m.Sink,
+		SampleRate:     m.SampleRate,
+		Retired:        m.Retired,
+		Sequence:       m.Sequence,
+		ReceivedAt:     receivedAt,
+		EventTime:      m.EventTime,
+	}
+	if r.EventTime.IsZero() {
+		r.EventTime = receivedAt
+	}
+	if m.BuildCommit != "" || m.BuildDate != "" || m.GoVersion != "" || m.Builder != "" {
+		r.BuildInfo = &api.BuildInfo{
+			Commit:    m.BuildCommit,
+			Date:      m.BuildDate,
+			GoVersion: m.GoVersion,
+			Builder:   m.Builder,
+		}
+	}
+	return r
+}
+
+// parseEntry parses a single Cloud Logging entry or MetricRecord. It returns
+// a key identifying the entry for deduplication, and the metric, or nil if
+// the entry is not a metric.
+func parseEntry(b []byte) (string, *server.MetricRecord, error) {
+	var entry logEntry
+	if err := json.Unmarshal(b, &entry); err != nil {
+		return "", nil, fmt.Errorf("failed to parse entry: %w", err)
+	}
+	if entry.InsertID != "" {
+		p := entry.JSONPayload
+		if p == nil || p.Message != "metric received" || p.Metric == nil {
+			return "", nil, nil
+		}
+		return entry.LogName + "/" + entry.InsertID, p.Metric.record(entry.Timestamp), nil
+	}
+
+	var m server.MetricRecord
+	if err := json.Unmarshal(b, &m); err != nil {
+		return "", nil, fmt.Errorf("failed to parse metric: %w", err)
+	}
+	if m.AppID == "" || m.Name == "" {
+		return "", nil, nil
+	}
+	// Records have no ID, so identical lines are assumed to be duplicates.
+	sum := sha256.Sum256(bytes.TrimSpace(b))
+	return hex.EncodeToString(sum[:]), &m, nil
+}
+
+// replayer writes metrics to a sink, skipping duplicates.
+type replayer struct {
+	sink server.MetricSink
+	seen map[string]struct{}
+
+	replayed   int
+	duplicates int
+	skipped    int
+}
+
+// replay writes each metric in r, named name in errors, to the sink.
+func (p *replayer) replay(ctx context.Context, name string, r io.Reader) error {
+	br := bufio.NewReader(r)
+	first, err := firstByte(br)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	if first == '[' {
+		return p.replayArray(ctx, name, br)
+	}
+
+	s := bufio.NewScanner(br)
+	s.Buffer(make([]byte, 0, 64*1024), maxLineBytes)
+	for line := 1; s.Scan(); line++ {
+		if len(bytes.TrimSpace(s.Bytes())) == 0 {
+			continue
+		}
+		if err := p.replayEntry(ctx, s.Bytes()); err != nil {
+			return fmt.Errorf("%s:%d: %w", name, line, err)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	return nil
+}
+
+// replayArray replays a JSON array of entries.
+func (p *replayer) replayArray(ctx context.Context, name string, r io.Reader) error {
+	d := json.NewDecoder(r)
+	if _, err := d.Token(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	for i := 0; d.More(); i++ {
+		var raw json.RawMessage
+		if err := d.Decode(&raw); err != nil {
+			return fmt.Errorf("%s: entry %d: %w", name, i, err)
+		}
+		if err := p.replayEntry(ctx, raw); err != nil {
+			return fmt.Errorf("%s: entry %d: %w", name, i, err)
+		}
+	}
+	return nil
+}
+
+// replayEntry writes the metric in b to the sink, unless it is not a metric or
+// was already replayed.
+func (p *replayer) replayEntry(ctx context.Context, b []byte) error {
+	key, m, err := parseEntry(b)
+	if err != nil {
+		return err
+	}
+	if m == nil {
+		p.skipped++
+		return nil
+	}
+	if _, ok := p.seen[key]; ok {
+		p.duplicates++
+		return nil
+	}
+	if p.sink != nil {
+		if err := p.sink.WriteMetric(ctx, m); err != nil {
+			return fmt.Errorf("failed to write metric: %w", err)
+		}
+	}
+	p.seen[key] = struct{}{}
+	p.replayed++
+	return nil
+}
+
+// firstByte returns the first non-whitespace byte of r without consuming it,
+// or 0 if r is empty.
+func firstByte(r *bufio.Reader) (byte, error) {
+	for {
+		b, err := r.Peek(1)
+		if err == io.EOF {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err //nolint:wrapcheck // Wrapped by caller.
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			_, _ = r.ReadByte()
+		default:
+			return b[0], nil
+		}
+	}
+}
+
+func realMain(ctx context.Context) error {
+	logger := logging.FromContext(ctx)
+
+	if *sinkURL == "" && !*dryRun {
+		return fmt.Errorf("-sink-url is required unless -dry-run is set")
+	}
+	if flag.NArg() == 0 {
+		return fmt.Errorf("at least one input file is required")
+	}
+
+	p := &replayer{seen: make(map[string]struct{})}
+	if !*dryRun {
+		p.sink = &server.HTTPSink{
+			URL:    *sinkURL,
+			Client: &http.Client{Timeout: 10 * time.Second},
+		}
+	}
+	for _, name := range flag.Args() {
+		if err := replayFile(ctx, p, name); err != nil {
+			logger.InfoContext(ctx, "replay stopped", "replayed", p.replayed)
+			return err
+		}
+	}
+	logger.InfoContext(ctx, "replayed metrics",
+		"replayed", p.replayed,
+		"duplicates", p.duplicates,
+		"skipped", p.skipped,
+		"dry_run", *dryRun)
+	return nil
+}
+
+// replayFile replays the metrics in the file at path.
+func replayFile(ctx context.Context, p *replayer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open input: %w", err)
+	}
+	defer f.Close()
+	return p.replay(ctx, path, f)
+}
+
+func main() {
+	ctx, done := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer done()
+	ctx = logging.WithLogger(ctx, logging.NewFromEnv("ABC_UPDATER_BACKFILL_"))
+	logger := logging.FromContext(ctx)
+
+	flag.Parse()
+	if err := realMain(ctx); err != nil {
+		done()
+		logger.ErrorContext(ctx, err.Error())
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+	"github.com/abcxyz/abc-updater/pkg/server"
+	"github.com/abcxyz/pkg/logging"
+	"github.com/abcxyz/pkg/testutil"
+)
+
+// recordingSink is a MetricSink which records the metrics written to it.
+type recordingSink struct {
+	got []*server.MetricRecord
+	err error
+}
+
+func (s *recordingSink) WriteMetric(ctx context.Context, m *server.MetricRecord) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.got = append(s.got, m)
+	return nil
+}
+
+// loggedEntry returns m as logged by server.LogSink and exported from Cloud
+// Logging with insertID.
+func loggedEntry(t *testing.T, insertID string, m *server.MetricRecord) string {
+	t.Helper()
+
+	var b bytes.Buffer
+	ctx := logging.WithLogger(context.Background(), logging.New(&b, slog.LevelDebug, logging.FormatJSON, false))
+	if err := (&server.LogSink{}).WriteMetric(ctx, m); err != nil {
+		t.Fatalf("failed to log metric: %s", err.Error())
+	}
+	return fmt.Sprintf(`{"logName":"projects/p/logs/run","insertId":%q,"jsonPayload":%s}`, insertID, strings.TrimSpace(b.String()))
+}
+
+func TestReplay(t *testing.T) {
+	t.Parallel()
+
+	logged := &server.MetricRecord{
+		AppID:         "foo",
+		AppVersion:    "1.0.0",
+		InstallID:     "id1",
+		InstallCohort: "2024-01",
+		Name:          "run",
+		Count:         2,
+		SampleRate:    0.5,
+		BuildInfo:     &api.BuildInfo{Commit: "abc123", GoVersion: "go1.22.0", Builder: "gc linux/amd64"},
+	}
+	posted := &server.MetricRecord{AppID: "bar", AppVersion: "2.0.0", InstallID: "id2", Name: "init", Count: 1}
+	entry := loggedEntry(t, "a1", logged)
+	record := `{"appId":"bar","appVersion":"2.0.0","installId":"id2","name":"init","count":1}`
+
+	cases := []struct {
+		name           string
+		inputs         []string
+		sinkErr        error
+		want           []*server.MetricRecord
+		wantDuplicates int
+		wantSkipped    int
+		wantErr        string
+	}{
+		{
+			name:        "storage_export",
+			inputs:      []string{entry + "\n" + `{"insertId":"a2","jsonPayload":{"message":"server started"}}` + "\n"},
+			want:        []*server.MetricRecord{logged},
+			wantSkipped: 1,
+		},
+		{
+			name:           "gcloud_array",
+			inputs:         []string{"[\n" + entry + ",\n" + entry + "\n]"},
+			want:           []*server.MetricRecord{logged},
+			wantDuplicates: 1,
+		},
+		{
+			name:           "ndjson_records_across_files",
+			inputs:         []string{record + "\n\n", entry + "\n" + record + "\n"},
+			want:           []*server.MetricRecord{posted, logged},
+			wantDuplicates: 1,
+		},
+		{
+			name:    "invalid_line",
+			inputs:  []string{record + "\n{\n"},
+			want:    []*server.MetricRecord{posted},
+			wantErr: "input-0:2: failed to parse entry",
+		},
+		{
+			name:    "sink_error",
+			inputs:  []string{record},
+			sinkErr: fmt.Errorf("sink down"),
+			wantErr: "input-0:1: failed to write metric: sink down",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			sink := &recordingSink{err: tc.sinkErr}
+			p := &replayer{sink: sink, seen: make(map[string]struct{})}
+			var err error
+			for i, in := range tc.inputs {
+				if err = p.replay(context.Background(), fmt.Sprintf("input-%d", i), strings.NewReader(in)); err != nil {
+					break
+				}
+			}
+			if diff := testutil.DiffErrString(err, tc.wantErr); diff != "" {
+				t.Error(diff)
+			}
+			if diff := cmp.Diff(sink.got, tc.want); diff != "" {
+				t.Errorf("unexpected metrics replayed (-got,+want): %s", diff)
+			}
+			if got, want := p.duplicates, tc.wantDuplicates; got != want {
+				t.Errorf("unexpected duplicates. got %d want %d", got, want)
+			}
+			if got, want := p.skipped, tc.wantSkipped; got != want {
+				t.Errorf("unexpected skipped entries. got %d want %d", got, want)
+			}
+		})
+	}
+}
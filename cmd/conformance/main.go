@@ -0,0 +1,72 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command conformance runs the conformance.Server, for the test suites of
+// abc-updater clients written in other languages. Point the client's
+// UPDATER_URL and METRICS_URL at it, exercise the client, then fetch
+// /conformance/report and fail if it lists any violations.
+//
+// Example:
+//
+//	conformance -port 8080
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/abcxyz/abc-updater/pkg/conformance"
+	"github.com/abcxyz/pkg/logging"
+	"github.com/abcxyz/pkg/serving"
+)
+
+var port = flag.String("port", "8080", "Port to listen on. Use 0 to pick a free port.")
+
+func realMain(ctx context.Context) error {
+	s, err := conformance.NewServer(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create conformance server: %w", err)
+	}
+	server, err := serving.New(*port)
+	if err != nil {
+		return fmt.Errorf("error creating server: %w", err)
+	}
+	logging.FromContext(ctx).InfoContext(ctx, "starting conformance server",
+		"addr", server.Addr(),
+		"app_id", conformance.AppID)
+
+	// This will block until the provided context is cancelled.
+	if err := server.StartHTTPHandler(ctx, s); err != nil {
+		return fmt.Errorf("error starting server: %w", err)
+	}
+	return nil
+}
+
+func main() {
+	ctx, done := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer done()
+	ctx = logging.WithLogger(ctx, logging.NewFromEnv("ABC_UPDATER_CONFORMANCE_"))
+	logger := logging.FromContext(ctx)
+
+	flag.Parse()
+	if err := realMain(ctx); err != nil {
+		done()
+		logger.ErrorContext(ctx, err.Error())
+		os.Exit(1)
+	}
+}
@@ -17,40 +17,230 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
+	"io"
+	"io/fs"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/sethvargo/go-envconfig"
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 
 	"github.com/abcxyz/abc-updater/pkg/server"
+	"github.com/abcxyz/abc-updater/pkg/server/firestorestore"
+	"github.com/abcxyz/abc-updater/pkg/server/redisstore"
+	"github.com/abcxyz/abc-updater/static"
 	"github.com/abcxyz/pkg/logging"
 	"github.com/abcxyz/pkg/renderer"
 	"github.com/abcxyz/pkg/serving"
 )
 
+var validateConfig = flag.Bool("validate-config", false,
+	"check the config and metadata source, print a report, and exit without serving")
+
 type metricsServerConfig struct {
 	ServerURL               string        `env:"ABC_UPDATER_METRICS_METADATA_URL, default=https://abc-updater.tycho.joonix.net"`
 	MetadataUpdateFrequency time.Duration `env:"ABC_UPDATER_METRICS_METADATA_UPDATE_FREQUENCY, default=1m"`
 	Port                    string        `env:"ABC_UPDATER_METRICS_SERVER_PORT, default=8080"`
+	// ListenAddr is the host and port to listen on, e.g. "127.0.0.1:8443".
+	// Overrides Port if set. Port listens on all interfaces.
+	ListenAddr string `env:"ABC_UPDATER_METRICS_LISTEN_ADDR"`
+	// TLSCertFile and TLSKeyFile are paths to a PEM certificate and key to
+	// serve TLS with, for running without a TLS-terminating proxy. Either both
+	// or neither must be set.
+	TLSCertFile string `env:"ABC_UPDATER_METRICS_TLS_CERT_FILE"`
+	TLSKeyFile  string `env:"ABC_UPDATER_METRICS_TLS_KEY_FILE"`
+	// AutocertDomains are domains to serve TLS for with certificates obtained
+	// automatically from Let's Encrypt, as an alternative to TLSCertFile.
+	AutocertDomains []string `env:"ABC_UPDATER_METRICS_AUTOCERT_DOMAINS"`
+	// AutocertCacheDir is where automatic certificates are stored between
+	// restarts.
+	AutocertCacheDir string `env:"ABC_UPDATER_METRICS_AUTOCERT_CACHE_DIR, default=autocert-cache"`
+	// H2C serves HTTP/2 over cleartext, e.g. behind a load balancer or mesh
+	// which speaks HTTP/2 to backends. Not allowed with TLS, which negotiates
+	// HTTP/2 itself.
+	H2C bool `env:"ABC_UPDATER_METRICS_H2C, default=false"`
+	// PIDFile is a path to write the server's process ID to while running,
+	// for service managers. Not written if empty.
+	PIDFile string `env:"ABC_UPDATER_METRICS_PID_FILE"`
+	// MetadataParallelism is the most apps whose metadata is fetched at once
+	// during a refresh.
+	MetadataParallelism int `env:"ABC_UPDATER_METRICS_METADATA_PARALLELISM, default=8"`
+	// MetadataChangesSince requests only the apps changed since the last
+	// refresh, from metadata sources which support the "changes-since" query
+	// parameter.
+	MetadataChangesSince bool `env:"ABC_UPDATER_METRICS_METADATA_CHANGES_SINCE, default=false"`
+	// AdminToken enables /admin endpoints for requests bearing it. Admin
+	// endpoints are disabled if empty.
+	AdminToken string `env:"ABC_UPDATER_METRICS_ADMIN_TOKEN"`
+	// OwnerTokens map owner names from the manifest to tokens which can use
+	// admin endpoints for only that owner's apps, e.g.
+	// "team-a:token1,team-b:token2".
+	OwnerTokens map[string]string `env:"ABC_UPDATER_METRICS_OWNER_TOKENS"`
+	// CORSOrigins are the browser origins allowed to send metrics, e.g.
+	// "https://docs.example.com". "*" allows any origin. Cross-origin
+	// requests are not allowed if empty.
+	CORSOrigins []string `env:"ABC_UPDATER_METRICS_CORS_ORIGINS"`
+	// MaxInFlight is the maximum number of concurrent metric and app data
+	// requests. Requests over the limit are rejected with a 503. Zero means
+	// no limit.
+	MaxInFlight int `env:"ABC_UPDATER_METRICS_MAX_IN_FLIGHT, default=0"`
+	// RollcallRate is the maximum average number of rollcall requests per
+	// second across all clients, with bursts of up to RollcallBurst. Requests
+	// over the rate are rejected with a 429. Zero means no limit.
+	RollcallRate  float64 `env:"ABC_UPDATER_METRICS_ROLLCALL_RATE, default=50"`
+	RollcallBurst int     `env:"ABC_UPDATER_METRICS_ROLLCALL_BURST, default=100"`
+	// MinClientVersion is the oldest abc-updater library version supported.
+	// Older clients are sent a header telling them to update. Disabled if
+	// empty.
+	MinClientVersion string `env:"ABC_UPDATER_METRICS_MIN_CLIENT_VERSION"`
+	// ClientSunset is the RFC 3339 time after which clients older than
+	// MinClientVersion are no longer served.
+	ClientSunset time.Time `env:"ABC_UPDATER_METRICS_CLIENT_SUNSET"`
+	// DefinitionStoreURL optionally shares metrics definitions across
+	// replicas, e.g. "redis://host:6379/0" or
+	// "firestore://<project>/<collection>/<document>".
+	DefinitionStoreURL string `env:"ABC_UPDATER_METRICS_DEFINITION_STORE_URL"`
+	// ShadowSinkURL optionally enables dual writes: each accepted metric is
+	// also POSTed to this URL, without affecting the primary log sink.
+	ShadowSinkURL string `env:"ABC_UPDATER_METRICS_SHADOW_SINK_URL"`
+	// ReleaseWebhooks are notified when an app's current version changes.
+	// Prefix a URL with "slack:" for Slack incoming webhooks.
+	ReleaseWebhooks []string `env:"ABC_UPDATER_METRICS_RELEASE_WEBHOOKS"`
+	// StatsStoreURL optionally shares aggregated stats across replicas, e.g.
+	// "redis://host:6379/0". Stats are kept in memory if empty.
+	StatsStoreURL string `env:"ABC_UPDATER_METRICS_STATS_STORE_URL"`
+	// StatsTokens maps app IDs to tokens which app owners can use to read
+	// their app's stats, e.g. "app1:token1,app2:token2". The admin token can
+	// read every app's stats.
+	StatsTokens map[string]string `env:"ABC_UPDATER_METRICS_STATS_TOKENS"`
+	// AnomalyMaxVersions and AnomalyMaxPlatforms are the most distinct app
+	// versions and platforms an install can report in a day before it is
+	// flagged in the app's anomaly report.
+	AnomalyMaxVersions  int `env:"ABC_UPDATER_METRICS_ANOMALY_MAX_VERSIONS, default=5"`
+	AnomalyMaxPlatforms int `env:"ABC_UPDATER_METRICS_ANOMALY_MAX_PLATFORMS, default=2"`
+	// Chaos injects faults into responses, for testing client resilience.
+	// Never set in production. E.g.
+	// "error_rate:0.1,delay:3s,delay_rate:0.2,truncate_rate:0.05".
+	Chaos map[string]string `env:"ABC_UPDATER_METRICS_CHAOS"`
+	// ScrubRules mask or reject metrics containing personal data before they
+	// are logged or sunk, e.g. "mask:email,reject:re:secret-[0-9]+". Set to
+	// "none" to disable scrubbing. IP addresses aren't masked by default, since
+	// four-part versions such as "1.2.3.4" look like IPv4 addresses.
+	ScrubRules []string `env:"ABC_UPDATER_METRICS_SCRUB_RULES, default=mask:email,mask:path"`
+	// Dashboard shows each app's metric volume, active versions, and the last
+	// metadata refresh on the homepage. The homepage is public, so this is
+	// disabled by default.
+	Dashboard bool `env:"ABC_UPDATER_METRICS_DASHBOARD, default=false"`
+	// StaticDir overrides the homepage template and assets embedded in the
+	// binary with those in a directory, e.g. for a customized homepage.
+	StaticDir string `env:"ABC_UPDATER_METRICS_STATIC_DIR"`
+}
+
+// closableStore is a server.DefinitionStore holding a connection.
+type closableStore interface {
+	server.DefinitionStore
+	io.Closer
+}
+
+// newDefinitionStore creates a DefinitionStore based on the scheme of url.
+func newDefinitionStore(ctx context.Context, url string) (closableStore, error) {
+	switch {
+	case strings.HasPrefix(url, "redis://"), strings.HasPrefix(url, "rediss://"):
+		return redisstore.NewFromURL(url) //nolint:wrapcheck // Already descriptive.
+	case strings.HasPrefix(url, "firestore://"):
+		return firestorestore.NewFromURL(ctx, url) //nolint:wrapcheck // Already descriptive.
+	default:
+		return nil, fmt.Errorf("unsupported definition store url %q", url)
+	}
+}
+
+// validateServingConfig checks the listen and TLS settings of c.
+func validateServingConfig(c *metricsServerConfig) error {
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if c.TLSCertFile != "" && len(c.AutocertDomains) > 0 {
+		return fmt.Errorf("TLS_CERT_FILE and AUTOCERT_DOMAINS cannot both be set")
+	}
+	useTLS := c.TLSCertFile != "" || len(c.AutocertDomains) > 0
+	if useTLS && c.H2C {
+		return fmt.Errorf("H2C cannot be used with TLS")
+	}
+	return nil
+}
+
+// newListener listens on the socket passed by systemd socket activation, or
+// else on the configured address. If TLS is configured, the listener serves
+// TLS and srv's TLSConfig is set, so HTTP/2 is negotiated.
+func newListener(c *metricsServerConfig, srv *http.Server) (net.Listener, error) {
+	addr := c.ListenAddr
+	if addr == "" {
+		addr = ":" + c.Port
+	}
+
+	var tlsConfig *tls.Config
+	switch {
+	case c.TLSCertFile != "":
+		cert, err := tls.LoadX509KeyPair(c.TLSCertFile, c.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load tls certificate: %w", err)
+		}
+		tlsConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}
+	case len(c.AutocertDomains) > 0:
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(c.AutocertDomains...),
+			Cache:      autocert.DirCache(c.AutocertCacheDir),
+		}
+		// Includes the ALPN protocol for tls-alpn-01 challenges, so no
+		// separate port 80 listener is needed.
+		tlsConfig = m.TLSConfig()
+		tlsConfig.MinVersion = tls.VersionTLS12
+	}
+
+	listener, activated, err := systemdListener()
+	if err != nil {
+		return nil, err
+	}
+	if !activated {
+		listener, err = net.Listen("tcp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+		}
+	}
+	if tlsConfig == nil {
+		return listener, nil
+	}
+	if !slices.Contains(tlsConfig.NextProtos, "h2") {
+		tlsConfig.NextProtos = append([]string{"h2", "http/1.1"}, tlsConfig.NextProtos...)
+	}
+	srv.TLSConfig = tlsConfig
+	return tls.NewListener(listener, tlsConfig), nil
 }
 
 // realMain creates an example backend HTTP server.
 // This server supports graceful stopping and cancellation.
 func realMain(ctx context.Context) error {
 	logger := logging.FromContext(ctx)
-	// Make a new renderer for rendering json.
-	// Don't provide filesystem as we don't have templates to render.
-	h, err := renderer.New(ctx, nil,
-		renderer.WithOnError(func(err error) {
+	// The API only renders JSON, so it doesn't need a full renderer.
+	h := &server.JSONResponder{
+		OnError: func(err error) {
 			logger.ErrorContext(ctx, "failed to render", "error", err)
-		}))
-	if err != nil {
-		return fmt.Errorf("failed to create renderer for main server: %w", err)
+		},
 	}
 
 	var c metricsServerConfig
@@ -60,60 +250,186 @@ func realMain(ctx context.Context) error {
 	}); err != nil {
 		return fmt.Errorf("failed to process envconfig: %w", err)
 	}
-	if c.MetadataUpdateFrequency.Milliseconds() < 100 {
-		return fmt.Errorf("invalid config: METADATA_UPDATE_FREQUENCY must be at least 100ms")
+	if *validateConfig {
+		return checkConfig(ctx, &c, os.Stdout)
+	}
+	if err := c.validate(); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
 	}
 
 	dbUpdateParams := &server.MetricsLoadParams{
-		ServerURL: c.ServerURL,
-		Client:    &http.Client{Timeout: 2 * time.Second},
+		ServerURL:    c.ServerURL,
+		Client:       &http.Client{Timeout: 2 * time.Second},
+		Parallelism:  c.MetadataParallelism,
+		ChangesSince: c.MetadataChangesSince,
 	}
 
 	db := &server.MetricsDB{}
-	if err := db.Update(ctx, dbUpdateParams); err != nil {
+	if c.DefinitionStoreURL != "" {
+		store, err := newDefinitionStore(ctx, c.DefinitionStoreURL)
+		if err != nil {
+			return fmt.Errorf("failed to create definition store: %w", err)
+		}
+		defer store.Close()
+		db.Store = store
+	}
+
+	// Set before the first refresh, so current versions are recorded without
+	// being announced.
+	var publisher *server.Publisher
+	if len(c.ReleaseWebhooks) > 0 {
+		publisher = &server.Publisher{Client: &http.Client{Timeout: 5 * time.Second}}
+		for _, s := range c.ReleaseWebhooks {
+			wh, err := server.ParseWebhook(s)
+			if err != nil {
+				return fmt.Errorf("invalid config: %w", err)
+			}
+			publisher.Webhooks = append(publisher.Webhooks, wh)
+		}
+		db.OnVersionChange = publisher.Observe
+	}
+	refresher := server.NewRefresher(db, dbUpdateParams, c.MetadataUpdateFrequency)
+	if err := refresher.Refresh(ctx); err != nil {
 		return fmt.Errorf("failed to load metrics definitions on startup: %w", err)
 	}
+	for _, p := range db.MetadataProblems() {
+		logger.WarnContext(ctx, "Problem found in app metadata.", "app_id", p.AppID, "problem", p.Message)
+	}
 
 	// Fetch new metadata for DB occasionally.
-	done := make(chan bool)
-	ticker := time.NewTicker(c.MetadataUpdateFrequency)
-	defer ticker.Stop()
-	defer func() { done <- true }()
-	go func() {
-		for {
-			select {
-			case <-done:
-				return
-			case <-ticker.C:
-				logger.DebugContext(ctx, "Updating metrics definitions.")
-				if err = db.Update(ctx, dbUpdateParams); err != nil {
-					logger.WarnContext(ctx, "Error updating metrics definitions, will use cached definition if available.", "err", err.Error())
-				}
-			}
+	refreshCtx, stopRefresh := context.WithCancel(ctx)
+	defer stopRefresh()
+	go refresher.Run(refreshCtx)
+
+	var stats server.StatsStore = server.NewMemoryStats()
+	if c.StatsStoreURL != "" {
+		redisStats, err := redisstore.NewStatsFromURL(c.StatsStoreURL)
+		if err != nil {
+			return fmt.Errorf("failed to create stats store: %w", err)
 		}
-	}()
+		defer redisStats.Close()
+		stats = redisStats
+	}
+
+	var sink server.MetricSink = &server.LogSink{}
+	var shadow *server.ShadowSink
+	if c.ShadowSinkURL != "" {
+		shadow = &server.ShadowSink{
+			Primary: sink,
+			Shadow: &server.HTTPSink{
+				URL:    c.ShadowSinkURL,
+				Client: &http.Client{Timeout: 2 * time.Second},
+			},
+		}
+		defer shadow.Close()
+		sink = shadow
+	}
+
+	sink = &server.StatsSink{Next: sink, Store: stats}
+	anomalies := &server.AnomalySink{
+		Next:         sink,
+		MaxVersions:  c.AnomalyMaxVersions,
+		MaxPlatforms: c.AnomalyMaxPlatforms,
+	}
+	sink = anomalies
+	scrubRules, err := c.scrubRules()
+	if err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	sink = &server.ScrubSink{Next: sink, Rules: scrubRules}
+
+	var staticFS fs.FS = static.FS
+	if c.StaticDir != "" {
+		staticFS = os.DirFS(c.StaticDir)
+	}
+	pages, err := renderer.New(ctx, staticFS,
+		renderer.WithOnError(func(err error) {
+			logger.ErrorContext(ctx, "failed to render page", "error", err)
+		}))
+	if err != nil {
+		return fmt.Errorf("failed to create renderer for pages: %w", err)
+	}
 
-	mux := http.NewServeMux()
-	mux.Handle("POST /sendMetrics", server.HandleMetric(h, db))
-	staticServer := http.FileServer(http.Dir("./static"))
-	// Static homepage. Don't handle /* as we want 405 rather than 404 on POST
-	// /sendMetrics and would rather not implement ourselves.
-	mux.Handle("/{$}", staticServer)
-	mux.Handle("/index.html", staticServer)
-	mux.Handle("/assets/", staticServer)
+	mux := server.NewMux(&server.MuxConfig{
+		DB:              db,
+		Refresher:       refresher,
+		Sink:            sink,
+		JSON:            h,
+		Pages:           pages,
+		Static:          staticFS,
+		Stats:           stats,
+		Dashboard:       c.Dashboard,
+		Anomalies:       anomalies,
+		Shadow:          shadow,
+		Publisher:       publisher,
+		LoadShedder:     server.NewLoadShedder(c.MaxInFlight),
+		RollcallLimiter: server.NewRateLimiter(c.RollcallRate, c.RollcallBurst),
+		CORSOrigins:     c.CORSOrigins,
+		AdminToken:      c.AdminToken,
+		OwnerTokens:     c.OwnerTokens,
+		StatsTokens:     c.StatsTokens,
+	})
 
+	handler, err := server.RequireMinClientVersion(c.MinClientVersion, c.ClientSunset, mux)
+	if err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	chaos, err := server.ParseChaos(c.Chaos)
+	if err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	if chaos != nil {
+		logger.WarnContext(ctx, "Injecting faults into responses, never use in production.",
+			"error_rate", chaos.ErrorRate,
+			"delay", chaos.Delay.String(),
+			"delay_rate", chaos.DelayRate,
+			"truncate_rate", chaos.TruncateRate)
+		handler = chaos.Wrap(handler)
+	}
+
+	if c.H2C {
+		handler = h2c.NewHandler(handler, &http2.Server{})
+	}
 	httpServer := &http.Server{
-		Addr:              c.Port,
-		Handler:           mux,
+		Handler:           handler,
 		ReadHeaderTimeout: 2 * time.Second,
 	}
 
-	logger.InfoContext(ctx, "starting server", "port", c.Port)
-	server, err := serving.New(c.Port)
+	listener, err := newListener(&c, httpServer)
+	if err != nil {
+		return fmt.Errorf("error creating listener: %w", err)
+	}
+	logger.InfoContext(ctx, "starting server",
+		"addr", listener.Addr().String(),
+		"tls", httpServer.TLSConfig != nil,
+		"h2c", c.H2C)
+	server, err := serving.NewFromListener(listener)
 	if err != nil {
+		listener.Close()
 		return fmt.Errorf("error creating server: %w", err)
 	}
 
+	if c.PIDFile != "" {
+		removePIDFile, err := writePIDFile(c.PIDFile)
+		if err != nil {
+			listener.Close()
+			return err
+		}
+		defer removePIDFile()
+	}
+
+	// The listener is already accepting connections, so it is safe to report
+	// readiness before serving.
+	if err := systemdNotify("READY=1"); err != nil {
+		logger.WarnContext(ctx, "failed to notify systemd", "error", err.Error())
+	}
+	go func() {
+		<-ctx.Done()
+		if err := systemdNotify("STOPPING=1"); err != nil {
+			logger.WarnContext(ctx, "failed to notify systemd", "error", err.Error())
+		}
+	}()
+
 	// This will block until the provided context is cancelled.
 	if err := server.StartHTTP(ctx, httpServer); err != nil {
 		return fmt.Errorf("error starting server: %w", err)
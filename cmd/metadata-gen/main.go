@@ -0,0 +1,601 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command metadata-gen generates the static JSON files read by the updater
+// and metrics server from a single YAML definition of apps.
+//
+// Example:
+//
+//	metadata-gen -config apps.yaml -out ./out -upload gs://my-bucket
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/go-version"
+	"gopkg.in/yaml.v3"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+	"github.com/abcxyz/abc-updater/pkg/events"
+	"github.com/abcxyz/abc-updater/pkg/localstore"
+	"github.com/abcxyz/pkg/logging"
+)
+
+var (
+	configPath = flag.String("config", "", "Path to YAML app definitions. Required.")
+	outDir     = flag.String("out", "", "Directory to write generated files to. Required.")
+	uploadURL  = flag.String("upload", "", "Optional gs:// URL to upload generated files to using gcloud.")
+)
+
+// appsConfig is the YAML definition of all apps.
+type appsConfig struct {
+	Apps []*appConfig `yaml:"apps"`
+
+	// Owners are the teams which apps may name as their owner, keyed by
+	// owner name.
+	Owners map[string]*ownerConfig `yaml:"owners"`
+}
+
+// ownerConfig is the YAML definition of api.Owner, whose apps are those
+// naming it as their owner.
+type ownerConfig struct {
+	Logging *loggingConfig `yaml:"logging"`
+}
+
+// appConfig is the YAML definition of a single app.
+type appConfig struct {
+	AppID          string   `yaml:"appId"`
+	AppName        string   `yaml:"appName"`
+	AppRepoURL     string   `yaml:"appRepoUrl"`
+	CurrentVersion string   `yaml:"currentVersion"`
+	Severity       string   `yaml:"severity"`
+	Metrics        []string `yaml:"metrics"`
+
+	// EventSchema is the path, relative to the config file, of the app's
+	// checked-in events.Schema. The metrics its events may record are added
+	// to Metrics.
+	EventSchema string `yaml:"eventSchema"`
+
+	// Advisories lists known security advisories for the app.
+	Advisories []*advisoryConfig `yaml:"advisories"`
+
+	// Components lists separately versioned plugins or templates of the app.
+	Components []*componentConfig `yaml:"components"`
+
+	// Logging optionally configures how the server emits the app's metrics.
+	Logging *loggingConfig `yaml:"logging"`
+
+	// ClientConfig optionally changes how deployed clients behave.
+	ClientConfig *clientConfig `yaml:"clientConfig"`
+
+	// KillSwitches disable telemetry from client versions with known problems.
+	KillSwitches []*killSwitchConfig `yaml:"killSwitches"`
+
+	// Owner optionally names the owner, in Owners, of an app with metrics.
+	Owner string `yaml:"owner"`
+
+	// Retired optionally deprecates an app with metrics.
+	Retired *retirementConfig `yaml:"retired"`
+
+	// AllowBuildInfo records the build info sent with the app's metrics.
+	AllowBuildInfo bool `yaml:"allowBuildInfo"`
+
+	// Latency maps latency metrics to the upper bounds of their buckets, or
+	// to an empty list for the default buckets.
+	Latency map[string][]string `yaml:"latency"`
+
+	// RetiredMetrics maps metrics to their retirement.
+	RetiredMetrics map[string]*retirementConfig `yaml:"retiredMetrics"`
+}
+
+// advisoryConfig is the YAML definition of api.Advisory.
+type advisoryConfig struct {
+	ID               string `yaml:"id"`
+	Summary          string `yaml:"summary"`
+	Severity         string `yaml:"severity"`
+	AffectedVersions string `yaml:"affectedVersions"`
+	FixedVersion     string `yaml:"fixedVersion"`
+	URL              string `yaml:"url"`
+}
+
+// componentConfig is the YAML definition of api.Component.
+type componentConfig struct {
+	ID             string `yaml:"id"`
+	Name           string `yaml:"name"`
+	RepoURL        string `yaml:"repoUrl"`
+	CurrentVersion string `yaml:"currentVersion"`
+	Severity       string `yaml:"severity"`
+}
+
+// loggingConfig is the YAML definition of api.MetricLogging.
+type loggingConfig struct {
+	Level      string  `yaml:"level"`
+	Sink       string  `yaml:"sink"`
+	SampleRate float64 `yaml:"sampleRate"`
+}
+
+// clientConfig is the YAML definition of api.ClientConfig.
+type clientConfig struct {
+	Versions          string  `yaml:"versions"`
+	CheckInterval     string  `yaml:"checkInterval"`
+	DisableMetrics    bool    `yaml:"disableMetrics"`
+	MetricsSampleRate float64 `yaml:"metricsSampleRate"`
+}
+
+// retirementConfig is the YAML definition of api.Retirement.
+type retirementConfig struct {
+	DropAfter time.Time `yaml:"dropAfter"`
+	Message   string    `yaml:"message"`
+}
+
+// killSwitchConfig is the YAML definition of api.KillSwitch.
+type killSwitchConfig struct {
+	Versions string `yaml:"versions"`
+	Message  string `yaml:"message"`
+}
+
+func loadConfig(path string) (*appsConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open config: %w", err)
+	}
+	defer f.Close()
+
+	d := yaml.NewDecoder(f)
+	// Reject unknown fields so typos don't silently ship.
+	d.KnownFields(true)
+
+	var c appsConfig
+	if err := d.Decode(&c); err != nil {
+		return nil, fmt.Errorf("failed to decode config: %w", err)
+	}
+	for _, app := range c.Apps {
+		if app == nil || app.EventSchema == "" {
+			continue
+		}
+		schemaPath := app.EventSchema
+		if !filepath.IsAbs(schemaPath) {
+			schemaPath = filepath.Join(filepath.Dir(path), schemaPath)
+		}
+		s, err := events.LoadSchema(schemaPath)
+		if err != nil {
+			return nil, fmt.Errorf("app %q: %w", app.AppID, err)
+		}
+		app.Metrics = append(app.Metrics, s.Metrics()...)
+	}
+	return &c, nil
+}
+
+// validate returns all problems found in the config.
+func (c *appsConfig) validate() error {
+	var merr error
+	seen := make(map[string]struct{}, len(c.Apps))
+	for i, app := range c.Apps {
+		if app == nil || app.AppID == "" {
+			merr = errors.Join(merr, fmt.Errorf("apps[%d]: appId is required", i))
+			continue
+		}
+		if !api.ValidAppID(app.AppID) {
+			merr = errors.Join(merr, fmt.Errorf("app %q: appId must be at most %d letters, digits, '_', '-', and '.', and must not start with '.' or '-'", app.AppID, api.MaxAppIDLength))
+			continue
+		}
+		if _, ok := seen[app.AppID]; ok {
+			merr = errors.Join(merr, fmt.Errorf("app %q: duplicate appId", app.AppID))
+		}
+		seen[app.AppID] = struct{}{}
+
+		if app.CurrentVersion != "" {
+			if _, err := version.NewVersion(app.CurrentVersion); err != nil {
+				merr = errors.Join(merr, fmt.Errorf("app %q: invalid currentVersion %q: %w", app.AppID, app.CurrentVersion, err))
+			}
+		}
+		if !api.Severity(app.Severity).Valid() {
+			merr = errors.Join(merr, fmt.Errorf("app %q: invalid severity %q", app.AppID, app.Severity))
+		}
+		for i, a := range app.Advisories {
+			if a == nil || a.ID == "" {
+				merr = errors.Join(merr, fmt.Errorf("app %q: advisories[%d]: id is required", app.AppID, i))
+				continue
+			}
+			if _, err := version.NewConstraint(a.AffectedVersions); err != nil {
+				merr = errors.Join(merr, fmt.Errorf("app %q: advisory %q: invalid affectedVersions %q: %w", app.AppID, a.ID, a.AffectedVersions, err))
+			}
+			if !api.Severity(a.Severity).Valid() {
+				merr = errors.Join(merr, fmt.Errorf("app %q: advisory %q: invalid severity %q", app.AppID, a.ID, a.Severity))
+			}
+		}
+		componentSet := make(map[string]struct{}, len(app.Components))
+		for i, comp := range app.Components {
+			if comp == nil || comp.ID == "" {
+				merr = errors.Join(merr, fmt.Errorf("app %q: components[%d]: id is required", app.AppID, i))
+				continue
+			}
+			if _, ok := componentSet[comp.ID]; ok {
+				merr = errors.Join(merr, fmt.Errorf("app %q: duplicate component %q", app.AppID, comp.ID))
+			}
+			componentSet[comp.ID] = struct{}{}
+			if _, err := version.NewVersion(comp.CurrentVersion); err != nil {
+				merr = errors.Join(merr, fmt.Errorf("app %q: component %q: invalid currentVersion %q: %w", app.AppID, comp.ID, comp.CurrentVersion, err))
+			}
+			if !api.Severity(comp.Severity).Valid() {
+				merr = errors.Join(merr, fmt.Errorf("app %q: component %q: invalid severity %q", app.AppID, comp.ID, comp.Severity))
+			}
+		}
+
+		metricSet := make(map[string]struct{}, len(app.Metrics))
+		for _, m := range app.Metrics {
+			if m == "" {
+				merr = errors.Join(merr, fmt.Errorf("app %q: metric names must not be empty", app.AppID))
+			}
+			if _, ok := metricSet[m]; ok {
+				merr = errors.Join(merr, fmt.Errorf("app %q: duplicate metric %q", app.AppID, m))
+			}
+			metricSet[m] = struct{}{}
+		}
+
+		for metric, bounds := range app.Latency {
+			if metric == "" {
+				merr = errors.Join(merr, fmt.Errorf("app %q: latency metric names must not be empty", app.AppID))
+				continue
+			}
+			if _, err := api.ParseLatencyBuckets(bounds); err != nil {
+				merr = errors.Join(merr, fmt.Errorf("app %q: latency metric %q: %w", app.AppID, metric, err))
+			}
+		}
+		for metric, r := range app.RetiredMetrics {
+			if r == nil || r.DropAfter.IsZero() {
+				merr = errors.Join(merr, fmt.Errorf("app %q: retired metric %q: dropAfter is required", app.AppID, metric))
+			}
+		}
+
+		merr = errors.Join(merr, app.Logging.validate(fmt.Sprintf("app %q", app.AppID)))
+
+		if app.Owner != "" {
+			if _, ok := c.Owners[app.Owner]; !ok {
+				merr = errors.Join(merr, fmt.Errorf("app %q: unknown owner %q", app.AppID, app.Owner))
+			}
+			if !app.hasMetrics() {
+				merr = errors.Join(merr, fmt.Errorf("app %q: owner requires metrics", app.AppID))
+			}
+		}
+		if r := app.Retired; r != nil {
+			if r.DropAfter.IsZero() {
+				merr = errors.Join(merr, fmt.Errorf("app %q: retired: dropAfter is required", app.AppID))
+			}
+			if !app.hasMetrics() {
+				merr = errors.Join(merr, fmt.Errorf("app %q: retired requires metrics", app.AppID))
+			}
+		}
+
+		if cc := app.ClientConfig; cc != nil {
+			if cc.Versions != "" {
+				if _, err := version.NewConstraint(cc.Versions); err != nil {
+					merr = errors.Join(merr, fmt.Errorf("app %q: invalid clientConfig versions %q: %w", app.AppID, cc.Versions, err))
+				}
+			}
+			if cc.CheckInterval != "" {
+				if d, err := time.ParseDuration(cc.CheckInterval); err != nil || d <= 0 {
+					merr = errors.Join(merr, fmt.Errorf("app %q: invalid clientConfig checkInterval %q", app.AppID, cc.CheckInterval))
+				}
+			}
+			if cc.MetricsSampleRate < 0 || cc.MetricsSampleRate > 1 {
+				merr = errors.Join(merr, fmt.Errorf("app %q: clientConfig metricsSampleRate %g is not between 0 and 1", app.AppID, cc.MetricsSampleRate))
+			}
+		}
+
+		for i, k := range app.KillSwitches {
+			if k.Versions == "" {
+				merr = errors.Join(merr, fmt.Errorf("app %q: killSwitches[%d] missing versions", app.AppID, i))
+			} else if _, err := version.NewConstraint(k.Versions); err != nil {
+				merr = errors.Join(merr, fmt.Errorf("app %q: invalid killSwitches[%d] versions %q: %w", app.AppID, i, k.Versions, err))
+			}
+		}
+	}
+
+	owners := make([]string, 0, len(c.Owners))
+	for name := range c.Owners {
+		owners = append(owners, name)
+	}
+	sort.Strings(owners)
+	for _, name := range owners {
+		if name == "" {
+			merr = errors.Join(merr, fmt.Errorf("owner names must not be empty"))
+		}
+		if o := c.Owners[name]; o != nil {
+			merr = errors.Join(merr, o.Logging.validate(fmt.Sprintf("owner %q", name)))
+		}
+	}
+	return merr
+}
+
+// validate returns all problems found in l, prefixed with what it configures.
+// A nil l is valid.
+func (l *loggingConfig) validate(prefix string) error {
+	if l == nil {
+		return nil
+	}
+	var merr error
+	if l.Level != "" {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(l.Level)); err != nil {
+			merr = errors.Join(merr, fmt.Errorf("%s: invalid logging level %q", prefix, l.Level))
+		}
+	}
+	if l.SampleRate < 0 || l.SampleRate > 1 {
+		merr = errors.Join(merr, fmt.Errorf("%s: logging sampleRate %g is not between 0 and 1", prefix, l.SampleRate))
+	}
+	return merr
+}
+
+// metricLogging returns l as written to metrics.json or the manifest.
+func (l *loggingConfig) metricLogging() *api.MetricLogging {
+	if l == nil {
+		return nil
+	}
+	return &api.MetricLogging{
+		Level:      l.Level,
+		Sink:       l.Sink,
+		SampleRate: l.SampleRate,
+	}
+}
+
+// retirement returns r as written to metrics.json or the manifest.
+func (r *retirementConfig) retirement() *api.Retirement {
+	if r == nil {
+		return nil
+	}
+	return &api.Retirement{
+		DropAfter: r.DropAfter,
+		Message:   r.Message,
+	}
+}
+
+// hasMetrics returns true if the app has a metrics.json, and so is listed in
+// the manifest.
+func (app *appConfig) hasMetrics() bool {
+	return len(app.Metrics) > 0 || len(app.Latency) > 0 || len(app.RetiredMetrics) > 0
+}
+
+// retiredMetrics returns the app's retired metrics as written to
+// metrics.json.
+func (app *appConfig) retiredMetrics() map[string]*api.Retirement {
+	if len(app.RetiredMetrics) == 0 {
+		return nil
+	}
+	out := make(map[string]*api.Retirement, len(app.RetiredMetrics))
+	for metric, r := range app.RetiredMetrics {
+		out[metric] = r.retirement()
+	}
+	return out
+}
+
+// advisories returns the app's advisories as written to data.json.
+func (app *appConfig) advisories() []*api.Advisory {
+	var out []*api.Advisory
+	for _, a := range app.Advisories {
+		out = append(out, &api.Advisory{
+			ID:               a.ID,
+			Summary:          a.Summary,
+			Severity:         api.Severity(a.Severity),
+			AffectedVersions: a.AffectedVersions,
+			FixedVersion:     a.FixedVersion,
+			URL:              a.URL,
+		})
+	}
+	return out
+}
+
+// components returns the app's components as written to data.json.
+func (app *appConfig) components() []*api.Component {
+	var out []*api.Component
+	for _, comp := range app.Components {
+		out = append(out, &api.Component{
+			ID:             comp.ID,
+			Name:           comp.Name,
+			RepoURL:        comp.RepoURL,
+			CurrentVersion: comp.CurrentVersion,
+			Severity:       api.Severity(comp.Severity),
+		})
+	}
+	return out
+}
+
+// clientConfig returns the app's client config as written to data.json.
+func (app *appConfig) clientConfig() *api.ClientConfig {
+	cc := app.ClientConfig
+	if cc == nil {
+		return nil
+	}
+	return &api.ClientConfig{
+		Versions:          cc.Versions,
+		CheckInterval:     cc.CheckInterval,
+		DisableMetrics:    cc.DisableMetrics,
+		MetricsSampleRate: cc.MetricsSampleRate,
+	}
+}
+
+// killSwitches returns the app's kill switches as written to data.json.
+func (app *appConfig) killSwitches() []*api.KillSwitch {
+	var out []*api.KillSwitch
+	for _, k := range app.KillSwitches {
+		out = append(out, &api.KillSwitch{
+			Versions: k.Versions,
+			Message:  k.Message,
+		})
+	}
+	return out
+}
+
+// generate writes manifest.json, <app>/data.json, and <app>/metrics.json to
+// dir. data.json is only written for apps with a currentVersion, and
+// metrics.json and the manifest entries, including the app's owner and
+// retirement, only for apps with metrics.
+func generate(c *appsConfig, dir string) error {
+	var manifest api.ManifestResponse
+	for _, app := range c.Apps {
+		var data *api.AppResponse
+		if app.CurrentVersion != "" {
+			data = &api.AppResponse{
+				AppID:          app.AppID,
+				AppName:        app.AppName,
+				AppRepoURL:     app.AppRepoURL,
+				CurrentVersion: app.CurrentVersion,
+				Severity:       api.Severity(app.Severity),
+				Advisories:     app.advisories(),
+				Components:     app.components(),
+				ClientConfig:   app.clientConfig(),
+				KillSwitches:   app.killSwitches(),
+			}
+			if err := localstore.StoreJSONFile(filepath.Join(dir, app.AppID, "data.json"), data); err != nil {
+				return fmt.Errorf("failed to write data for app %q: %w", app.AppID, err)
+			}
+		}
+
+		if app.hasMetrics() {
+			allowed := &api.AllowedMetricsResponse{
+				Metrics:        app.Metrics,
+				Logging:        app.Logging.metricLogging(),
+				AllowBuildInfo: app.AllowBuildInfo,
+				Latency:        app.Latency,
+				Retired:        app.retiredMetrics(),
+			}
+			if err := localstore.StoreJSONFile(filepath.Join(dir, app.AppID, "metrics.json"), allowed); err != nil {
+				return fmt.Errorf("failed to write metrics for app %q: %w", app.AppID, err)
+			}
+			manifest.MetricsApps = append(manifest.MetricsApps, app.AppID)
+			if app.Owner != "" {
+				if manifest.Owners == nil {
+					manifest.Owners = make(map[string]*api.Owner)
+				}
+				o, ok := manifest.Owners[app.Owner]
+				if !ok {
+					o = &api.Owner{}
+					if oc := c.Owners[app.Owner]; oc != nil {
+						o.Logging = oc.Logging.metricLogging()
+					}
+					manifest.Owners[app.Owner] = o
+				}
+				o.Apps = append(o.Apps, app.AppID)
+			}
+			if app.Retired != nil {
+				if manifest.Retired == nil {
+					manifest.Retired = make(map[string]*api.Retirement)
+				}
+				manifest.Retired[app.AppID] = app.Retired.retirement()
+			}
+
+			hash, err := contentHash(allowed, data)
+			if err != nil {
+				return fmt.Errorf("failed to hash app %q: %w", app.AppID, err)
+			}
+			if manifest.Hashes == nil {
+				manifest.Hashes = make(map[string]string)
+			}
+			manifest.Hashes[app.AppID] = hash
+		}
+	}
+
+	sort.Strings(manifest.MetricsApps)
+	for _, o := range manifest.Owners {
+		sort.Strings(o.Apps)
+	}
+	if err := localstore.StoreJSONFile(filepath.Join(dir, "manifest.json"), &manifest); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
+
+// contentHash returns a hex SHA-256 hash of the JSON encoding of allowed and
+// data, for the manifest, so servers only refetch apps which changed.
+func contentHash(allowed *api.AllowedMetricsResponse, data *api.AppResponse) (string, error) {
+	h := sha256.New()
+	enc := json.NewEncoder(h)
+	if err := enc.Encode(allowed); err != nil {
+		return "", fmt.Errorf("failed to encode metrics: %w", err)
+	}
+	if err := enc.Encode(data); err != nil {
+		return "", fmt.Errorf("failed to encode data: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func upload(ctx context.Context, dir, dest string) error {
+	if !strings.HasPrefix(dest, "gs://") {
+		return fmt.Errorf("upload destination %q must be a gs:// URL", dest)
+	}
+	cmd := exec.CommandContext(ctx, "gcloud", "storage", "cp", "--recursive", filepath.Join(dir, "*"), dest)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to upload to %s: %w", dest, err)
+	}
+	return nil
+}
+
+func realMain(ctx context.Context) error {
+	logger := logging.FromContext(ctx)
+
+	if *configPath == "" || *outDir == "" {
+		return fmt.Errorf("-config and -out are required")
+	}
+
+	c, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	if err := c.validate(); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	if err := generate(c, *outDir); err != nil {
+		return err
+	}
+	logger.InfoContext(ctx, "generated metadata", "dir", *outDir, "apps", len(c.Apps))
+
+	if *uploadURL != "" {
+		if err := upload(ctx, *outDir, *uploadURL); err != nil {
+			return err
+		}
+		logger.InfoContext(ctx, "uploaded metadata", "destination", *uploadURL)
+	}
+	return nil
+}
+
+func main() {
+	ctx, done := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer done()
+	ctx = logging.WithLogger(ctx, logging.NewFromEnv("ABC_UPDATER_METADATA_GEN_"))
+	logger := logging.FromContext(ctx)
+
+	flag.Parse()
+	if err := realMain(ctx); err != nil {
+		done()
+		logger.ErrorContext(ctx, err.Error())
+		os.Exit(1)
+	}
+}
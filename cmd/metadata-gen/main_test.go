@@ -0,0 +1,412 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+	"github.com/abcxyz/abc-updater/pkg/localstore"
+	"github.com/abcxyz/pkg/testutil"
+)
+
+func TestLoadConfig(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name      string
+		contents  string
+		want      *appsConfig
+		wantError string
+	}{
+		{
+			name: "happy",
+			contents: `apps:
+- appId: foo
+  appName: Foo
+  appRepoUrl: https://github.com/abcxyz/foo
+  currentVersion: 1.2.3
+  metrics: [a, b]
+`,
+			want: &appsConfig{Apps: []*appConfig{{
+				AppID:          "foo",
+				AppName:        "Foo",
+				AppRepoURL:     "https://github.com/abcxyz/foo",
+				CurrentVersion: "1.2.3",
+				Metrics:        []string{"a", "b"},
+			}}},
+		},
+		{
+			name: "owner_and_retirement",
+			contents: `owners:
+  team-a:
+    logging: {sink: team-a}
+apps:
+- appId: foo
+  owner: team-a
+  metrics: [a]
+  retired:
+    dropAfter: 2025-01-01
+    message: Use bar.
+`,
+			want: &appsConfig{
+				Owners: map[string]*ownerConfig{"team-a": {Logging: &loggingConfig{Sink: "team-a"}}},
+				Apps: []*appConfig{{
+					AppID:   "foo",
+					Owner:   "team-a",
+					Metrics: []string{"a"},
+					Retired: &retirementConfig{
+						DropAfter: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+						Message:   "Use bar.",
+					},
+				}},
+			},
+		},
+		{
+			name: "unknown_field_rejected",
+			contents: `apps:
+- appId: foo
+  curentVersion: 1.2.3
+`,
+			wantError: "field curentVersion not found",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(t.TempDir(), "apps.yaml")
+			if err := os.WriteFile(path, []byte(tc.contents), 0o600); err != nil {
+				t.Fatalf("test setup failed: %s", err.Error())
+			}
+
+			got, err := loadConfig(path)
+			if diff := testutil.DiffErrString(err, tc.wantError); diff != "" {
+				t.Error(diff)
+			}
+			if diff := cmp.Diff(got, tc.want); diff != "" {
+				t.Errorf("unexpected config. Diff (-got +want): %s", diff)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_EventSchema(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	schema := `{"events": [{"name": "command_run", "labels": [{"name": "success", "values": ["false", "true"]}]}]}`
+	if err := os.WriteFile(filepath.Join(dir, "events.json"), []byte(schema), 0o600); err != nil {
+		t.Fatalf("test setup failed: %s", err.Error())
+	}
+	config := `apps:
+- appId: foo
+  metrics: [a]
+  eventSchema: events.json
+`
+	path := filepath.Join(dir, "apps.yaml")
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatalf("test setup failed: %s", err.Error())
+	}
+
+	got, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	want := []string{"a", "command_run.false", "command_run.true"}
+	if diff := cmp.Diff(got.Apps[0].Metrics, want); diff != "" {
+		t.Errorf("unexpected metrics. Diff (-got +want): %s", diff)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "events.json"), []byte(`{"events": [{"name": "*"}]}`), 0o600); err != nil {
+		t.Fatalf("test setup failed: %s", err.Error())
+	}
+	_, err = loadConfig(path)
+	if diff := testutil.DiffErrString(err, `app "foo": invalid event schema`); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name      string
+		config    *appsConfig
+		wantError string
+	}{
+		{
+			name: "happy",
+			config: &appsConfig{Apps: []*appConfig{
+				{AppID: "foo", CurrentVersion: "1.0.0", Metrics: []string{"a"}},
+				{AppID: "bar", Metrics: []string{"a"}},
+			}},
+		},
+		{
+			name: "duplicate_app",
+			config: &appsConfig{Apps: []*appConfig{
+				{AppID: "foo"},
+				{AppID: "foo"},
+			}},
+			wantError: `app "foo": duplicate appId`,
+		},
+		{
+			name: "invalid_version",
+			config: &appsConfig{Apps: []*appConfig{
+				{AppID: "foo", CurrentVersion: "one"},
+			}},
+			wantError: `app "foo": invalid currentVersion "one"`,
+		},
+		{
+			name: "invalid_severity",
+			config: &appsConfig{Apps: []*appConfig{
+				{AppID: "foo", CurrentVersion: "1.0.0", Severity: "urgent"},
+			}},
+			wantError: `app "foo": invalid severity "urgent"`,
+		},
+		{
+			name: "invalid_advisory",
+			config: &appsConfig{Apps: []*appConfig{
+				{AppID: "foo", Advisories: []*advisoryConfig{{ID: "CVE-1", AffectedVersions: "soon"}}},
+			}},
+			wantError: `app "foo": advisory "CVE-1": invalid affectedVersions "soon"`,
+		},
+		{
+			name: "invalid_component",
+			config: &appsConfig{Apps: []*appConfig{
+				{AppID: "foo", Components: []*componentConfig{{ID: "tmpl", CurrentVersion: "latest"}}},
+			}},
+			wantError: `app "foo": component "tmpl": invalid currentVersion "latest"`,
+		},
+		{
+			name: "missing_app_id",
+			config: &appsConfig{Apps: []*appConfig{
+				{AppName: "Foo"},
+			}},
+			wantError: "apps[0]: appId is required",
+		},
+		{
+			name: "dot_app_id",
+			config: &appsConfig{Apps: []*appConfig{
+				{AppID: "..", CurrentVersion: "1.0.0"},
+			}},
+			wantError: `app "..": appId must be at most 128 letters, digits`,
+		},
+		{
+			name: "path_app_id",
+			config: &appsConfig{Apps: []*appConfig{
+				{AppID: "foo/../../bar", CurrentVersion: "1.0.0"},
+			}},
+			wantError: `app "foo/../../bar": appId must be at most 128 letters, digits`,
+		},
+		{
+			name: "unknown_owner",
+			config: &appsConfig{Apps: []*appConfig{
+				{AppID: "foo", Metrics: []string{"a"}, Owner: "team-a"},
+			}},
+			wantError: `app "foo": unknown owner "team-a"`,
+		},
+		{
+			name: "invalid_owner_logging",
+			config: &appsConfig{
+				Owners: map[string]*ownerConfig{"team-a": {Logging: &loggingConfig{Level: "LOUD"}}},
+				Apps:   []*appConfig{{AppID: "foo", Metrics: []string{"a"}, Owner: "team-a"}},
+			},
+			wantError: `owner "team-a": invalid logging level "LOUD"`,
+		},
+		{
+			name: "retired_without_metrics",
+			config: &appsConfig{Apps: []*appConfig{
+				{AppID: "foo", CurrentVersion: "1.0.0", Retired: &retirementConfig{DropAfter: time.Now()}},
+			}},
+			wantError: `app "foo": retired requires metrics`,
+		},
+		{
+			name: "invalid_latency",
+			config: &appsConfig{Apps: []*appConfig{
+				{AppID: "foo", Latency: map[string][]string{"build": {"1s", "100ms"}}},
+			}},
+			wantError: `app "foo": latency metric "build"`,
+		},
+		{
+			name: "retired_metric_without_drop_after",
+			config: &appsConfig{Apps: []*appConfig{
+				{AppID: "foo", RetiredMetrics: map[string]*retirementConfig{"old": {Message: "gone"}}},
+			}},
+			wantError: `app "foo": retired metric "old": dropAfter is required`,
+		},
+		{
+			name: "duplicate_metric",
+			config: &appsConfig{Apps: []*appConfig{
+				{AppID: "foo", Metrics: []string{"a", "a"}},
+			}},
+			wantError: `app "foo": duplicate metric "a"`,
+		},
+		{
+			name: "invalid_logging",
+			config: &appsConfig{Apps: []*appConfig{
+				{AppID: "foo", Metrics: []string{"a"}, Logging: &loggingConfig{Level: "LOUD", SampleRate: 2}},
+			}},
+			wantError: `app "foo": invalid logging level "LOUD"`,
+		},
+		{
+			name: "invalid_client_config",
+			config: &appsConfig{Apps: []*appConfig{
+				{AppID: "foo", ClientConfig: &clientConfig{Versions: "old", CheckInterval: "-1h"}},
+			}},
+			wantError: `app "foo": invalid clientConfig checkInterval "-1h"`,
+		},
+		{
+			name: "invalid_kill_switches",
+			config: &appsConfig{Apps: []*appConfig{
+				{AppID: "foo", KillSwitches: []*killSwitchConfig{{Message: "bug"}, {Versions: "newest"}}},
+			}},
+			wantError: `app "foo": killSwitches[0] missing versions`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			if diff := testutil.DiffErrString(tc.config.validate(), tc.wantError); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	dropAfter := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := &appsConfig{Owners: map[string]*ownerConfig{"team-a": {Logging: &loggingConfig{Sink: "team-a"}}}, Apps: []*appConfig{
+		{
+			AppID:          "foo",
+			AppName:        "Foo",
+			AppRepoURL:     "https://github.com/abcxyz/foo",
+			CurrentVersion: "1.2.3",
+			Metrics:        []string{"a", "b"},
+			Logging:        &loggingConfig{Level: "DEBUG", SampleRate: 0.5},
+			Advisories: []*advisoryConfig{
+				{ID: "CVE-2024-0001", Summary: "bug", AffectedVersions: "< 1.2.3", FixedVersion: "1.2.3"},
+			},
+			ClientConfig:   &clientConfig{Versions: "< 1.2.0", DisableMetrics: true},
+			KillSwitches:   []*killSwitchConfig{{Versions: "= 1.1.0", Message: "1.1.0 double counts builds."}},
+			Owner:          "team-a",
+			AllowBuildInfo: true,
+			Latency:        map[string][]string{"build": {"100ms", "1s"}},
+			RetiredMetrics: map[string]*retirementConfig{"old": {DropAfter: dropAfter}},
+		},
+		{
+			AppID:          "bar",
+			CurrentVersion: "0.1.0",
+		},
+		{
+			AppID:   "baz",
+			Metrics: []string{"a"},
+			Owner:   "team-a",
+			Retired: &retirementConfig{DropAfter: dropAfter, Message: "Use foo."},
+		},
+	}}
+
+	if err := generate(c, dir); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	var manifest api.ManifestResponse
+	if err := localstore.LoadJSONFile(filepath.Join(dir, "manifest.json"), &manifest); err != nil {
+		t.Fatalf("failed to load manifest: %s", err.Error())
+	}
+	if diff := cmp.Diff(manifest, api.ManifestResponse{
+		MetricsApps: []string{"baz", "foo"},
+		Owners: map[string]*api.Owner{
+			"team-a": {Apps: []string{"baz", "foo"}, Logging: &api.MetricLogging{Sink: "team-a"}},
+		},
+		Retired: map[string]*api.Retirement{"baz": {DropAfter: dropAfter, Message: "Use foo."}},
+	}, cmpopts.IgnoreFields(api.ManifestResponse{}, "Hashes")); diff != "" {
+		t.Errorf("unexpected manifest. Diff (-got +want): %s", diff)
+	}
+	if got := manifest.Hashes["foo"]; len(got) != 64 {
+		t.Errorf("expected a sha256 hash for foo, got %q", got)
+	}
+
+	var data api.AppResponse
+	if err := localstore.LoadJSONFile(filepath.Join(dir, "foo", "data.json"), &data); err != nil {
+		t.Fatalf("failed to load data: %s", err.Error())
+	}
+	if diff := cmp.Diff(data, api.AppResponse{
+		AppID:          "foo",
+		AppName:        "Foo",
+		AppRepoURL:     "https://github.com/abcxyz/foo",
+		CurrentVersion: "1.2.3",
+		Advisories: []*api.Advisory{
+			{ID: "CVE-2024-0001", Summary: "bug", AffectedVersions: "< 1.2.3", FixedVersion: "1.2.3"},
+		},
+		ClientConfig: &api.ClientConfig{Versions: "< 1.2.0", DisableMetrics: true},
+		KillSwitches: []*api.KillSwitch{{Versions: "= 1.1.0", Message: "1.1.0 double counts builds."}},
+	}); diff != "" {
+		t.Errorf("unexpected app data. Diff (-got +want): %s", diff)
+	}
+
+	var allowed api.AllowedMetricsResponse
+	if err := localstore.LoadJSONFile(filepath.Join(dir, "foo", "metrics.json"), &allowed); err != nil {
+		t.Fatalf("failed to load metrics: %s", err.Error())
+	}
+	if diff := cmp.Diff(allowed, api.AllowedMetricsResponse{
+		Metrics:        []string{"a", "b"},
+		Logging:        &api.MetricLogging{Level: "DEBUG", SampleRate: 0.5},
+		AllowBuildInfo: true,
+		Latency:        map[string][]string{"build": {"100ms", "1s"}},
+		Retired:        map[string]*api.Retirement{"old": {DropAfter: dropAfter}},
+	}); diff != "" {
+		t.Errorf("unexpected metrics. Diff (-got +want): %s", diff)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "bar", "metrics.json")); !os.IsNotExist(err) {
+		t.Errorf("expected no metrics.json for app without metrics, got err: %v", err)
+	}
+
+	// Hashes only change when an app's files do.
+	c.Apps[1].CurrentVersion = "0.2.0"
+	if err := generate(c, dir); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	var unchanged api.ManifestResponse
+	if err := localstore.LoadJSONFile(filepath.Join(dir, "manifest.json"), &unchanged); err != nil {
+		t.Fatalf("failed to load manifest: %s", err.Error())
+	}
+	if got, want := unchanged.Hashes["foo"], manifest.Hashes["foo"]; got != want {
+		t.Errorf("hash changed for unchanged app. got %q want %q", got, want)
+	}
+	c.Apps[0].CurrentVersion = "1.2.4"
+	if err := generate(c, dir); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	var changed api.ManifestResponse
+	if err := localstore.LoadJSONFile(filepath.Join(dir, "manifest.json"), &changed); err != nil {
+		t.Fatalf("failed to load manifest: %s", err.Error())
+	}
+	if changed.Hashes["foo"] == manifest.Hashes["foo"] {
+		t.Errorf("expected hash to change after app data changed")
+	}
+}
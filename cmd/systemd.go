@@ -0,0 +1,88 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// systemdListenFD is the first file descriptor passed by systemd socket
+// activation.
+const systemdListenFD = 3
+
+// systemdListener returns the listening socket passed by systemd socket
+// activation, if any. Returns false if the process was not socket activated.
+// Exactly one socket must be passed.
+func systemdListener() (net.Listener, bool, error) {
+	return systemdListenerFD(systemdListenFD)
+}
+
+// systemdListenerFD is systemdListener, with the socket passed as fd.
+func systemdListenerFD(fd uintptr) (net.Listener, bool, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, false, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid LISTEN_FDS %q: %w", os.Getenv("LISTEN_FDS"), err)
+	}
+	// Don't pass the sockets on to child processes.
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if n != 1 {
+		return nil, false, fmt.Errorf("expected 1 socket from systemd, got %d", n)
+	}
+
+	syscall.CloseOnExec(int(fd))
+	f := os.NewFile(fd, "systemd-socket")
+	defer f.Close()
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to use socket from systemd: %w", err)
+	}
+	return l, true, nil
+}
+
+// systemdNotify sends state, e.g. "READY=1", to the service manager if it
+// asked for notifications with NOTIFY_SOCKET. It is a noop otherwise.
+func systemdNotify(state string) error {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return nil
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("failed to connect to NOTIFY_SOCKET: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("failed to notify systemd: %w", err)
+	}
+	return nil
+}
+
+// writePIDFile writes the process ID to path, returning a function which
+// removes it.
+func writePIDFile(path string) (func(), error) {
+	if err := os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o644); err != nil { //nolint:gosec // PID files are meant to be world readable.
+		return nil, fmt.Errorf("failed to write pid file: %w", err)
+	}
+	return func() { os.Remove(path) }, nil
+}
@@ -0,0 +1,188 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/abcxyz/pkg/testutil"
+)
+
+// Not parallel, as it sets the LISTEN_* environment variables.
+func TestSystemdListener(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+
+	cases := []struct {
+		name    string
+		env     map[string]string
+		wantOK  bool
+		wantErr string
+	}{
+		{
+			name: "not_activated",
+			env:  map[string]string{"LISTEN_PID": "", "LISTEN_FDS": ""},
+		},
+		{
+			name: "other_process",
+			env:  map[string]string{"LISTEN_PID": strconv.Itoa(os.Getpid() + 1), "LISTEN_FDS": "1"},
+		},
+		{
+			name:    "invalid_fds",
+			env:     map[string]string{"LISTEN_PID": pid, "LISTEN_FDS": "one"},
+			wantErr: `invalid LISTEN_FDS "one"`,
+		},
+		{
+			name:    "multiple_sockets",
+			env:     map[string]string{"LISTEN_PID": pid, "LISTEN_FDS": "2"},
+			wantErr: "expected 1 socket from systemd, got 2",
+		},
+		{
+			name:   "activated",
+			env:    map[string]string{"LISTEN_PID": pid, "LISTEN_FDS": "1", "LISTEN_FDNAMES": "http"},
+			wantOK: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+
+			// Stands in for the socket systemd passes.
+			want, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { want.Close() })
+			f, err := want.(*net.TCPListener).File()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			// systemdListenerFD takes ownership of the descriptor once
+			// activated, so it is given a copy.
+			fd, err := syscall.Dup(int(f.Fd()))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, ok, err := systemdListenerFD(uintptr(fd))
+			if diff := testutil.DiffErrString(err, tc.wantErr); diff != "" {
+				t.Error(diff)
+			}
+			if ok != tc.wantOK {
+				t.Errorf("got activated %t, want %t", ok, tc.wantOK)
+			}
+			if !ok {
+				syscall.Close(fd)
+				return
+			}
+			defer got.Close()
+
+			if got, want := got.Addr().String(), want.Addr().String(); got != want {
+				t.Errorf("got listener on %s, want %s", got, want)
+			}
+			for _, k := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+				if v, ok := os.LookupEnv(k); ok {
+					t.Errorf("expected %s to be unset, got %q", k, v)
+				}
+			}
+		})
+	}
+}
+
+// Not parallel, as it sets NOTIFY_SOCKET.
+func TestSystemdNotify(t *testing.T) {
+	t.Run("no_socket", func(t *testing.T) {
+		t.Setenv("NOTIFY_SOCKET", "")
+		if err := systemdNotify("READY=1"); err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+		}
+	})
+
+	t.Run("missing_socket", func(t *testing.T) {
+		t.Setenv("NOTIFY_SOCKET", filepath.Join(t.TempDir(), "notify.sock"))
+		err := systemdNotify("READY=1")
+		if diff := testutil.DiffErrString(err, "failed to connect to NOTIFY_SOCKET"); diff != "" {
+			t.Error(diff)
+		}
+	})
+
+	t.Run("notifies", func(t *testing.T) {
+		addr := filepath.Join(t.TempDir(), "notify.sock")
+		conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: addr, Net: "unixgram"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		t.Setenv("NOTIFY_SOCKET", addr)
+
+		if err := systemdNotify("READY=1"); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+
+		if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 64)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := string(buf[:n]), "READY=1"; got != want {
+			t.Errorf("got state %q, want %q", got, want)
+		}
+	})
+}
+
+func TestWritePIDFile(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "server.pid")
+	remove, err := writePIDFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strconv.Itoa(os.Getpid()) + "\n"; string(got) != want {
+		t.Errorf("got pid file %q, want %q", got, want)
+	}
+
+	remove()
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected pid file to be removed, got err: %v", err)
+	}
+}
+
+func TestWritePIDFile_Error(t *testing.T) {
+	t.Parallel()
+
+	_, err := writePIDFile(filepath.Join(t.TempDir(), "missing", "server.pid"))
+	if diff := testutil.DiffErrString(err, "failed to write pid file"); diff != "" {
+		t.Error(diff)
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/abcxyz/abc-updater/pkg/server"
+)
+
+// validate checks c for invalid or conflicting settings, returning every
+// problem found rather than only the first.
+func (c *metricsServerConfig) validate() error {
+	var errs []error
+	if c.MetadataUpdateFrequency.Milliseconds() < 100 {
+		errs = append(errs, fmt.Errorf("METADATA_UPDATE_FREQUENCY must be at least 100ms"))
+	}
+	if c.MetadataParallelism < 1 {
+		errs = append(errs, fmt.Errorf("METADATA_PARALLELISM must be at least 1"))
+	}
+	if c.AnomalyMaxVersions < 1 {
+		errs = append(errs, fmt.Errorf("ANOMALY_MAX_VERSIONS must be at least 1"))
+	}
+	if c.AnomalyMaxPlatforms < 1 {
+		errs = append(errs, fmt.Errorf("ANOMALY_MAX_PLATFORMS must be at least 1"))
+	}
+	if err := validateServingConfig(c); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := server.RequireMinClientVersion(c.MinClientVersion, c.ClientSunset, http.NotFoundHandler()); err != nil {
+		errs = append(errs, err)
+	}
+	for _, s := range c.ReleaseWebhooks {
+		if _, err := server.ParseWebhook(s); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if _, err := server.ParseChaos(c.Chaos); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := c.scrubRules(); err != nil {
+		errs = append(errs, err)
+	}
+	if u := c.DefinitionStoreURL; u != "" && !hasAnyPrefix(u, "redis://", "rediss://", "firestore://") {
+		errs = append(errs, fmt.Errorf("unsupported definition store url %q", u))
+	}
+	if u := c.StatsStoreURL; u != "" && !hasAnyPrefix(u, "redis://", "rediss://") {
+		errs = append(errs, fmt.Errorf("unsupported stats store url %q", u))
+	}
+	return errors.Join(errs...)
+}
+
+// scrubRules parses c.ScrubRules. A single "none" disables scrubbing.
+func (c *metricsServerConfig) scrubRules() ([]*server.ScrubRule, error) {
+	if len(c.ScrubRules) == 1 && c.ScrubRules[0] == "none" {
+		return nil, nil
+	}
+	rules := make([]*server.ScrubRule, 0, len(c.ScrubRules))
+	for _, s := range c.ScrubRules {
+		r, err := server.ParseScrubRule(s)
+		if err != nil {
+			return nil, err //nolint:wrapcheck // Already describes the rule.
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// hasAnyPrefix returns true if s starts with any of prefixes.
+func hasAnyPrefix(s string, prefixes ...string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkConfig validates c, loads app metadata from its source, and writes a
+// report of each check to w, for catching mistakes in deployment configs
+// before rollout. Returns an error if any check failed.
+func checkConfig(ctx context.Context, c *metricsServerConfig, w io.Writer) error {
+	failed := 0
+	report := func(name string, err error) {
+		if err == nil {
+			fmt.Fprintf(w, "ok    %s\n", name)
+			return
+		}
+		failed++
+		fmt.Fprintf(w, "FAIL  %s\n", name)
+		for _, line := range strings.Split(err.Error(), "\n") {
+			fmt.Fprintf(w, "      %s\n", line)
+		}
+	}
+
+	report("config", c.validate())
+
+	if c.TLSCertFile != "" {
+		_, err := tls.LoadX509KeyPair(c.TLSCertFile, c.TLSKeyFile)
+		report("tls certificate", err)
+	}
+
+	db := &server.MetricsDB{}
+	err := db.Update(ctx, &server.MetricsLoadParams{
+		ServerURL:   c.ServerURL,
+		Client:      &http.Client{Timeout: 5 * time.Second},
+		Parallelism: max(c.MetadataParallelism, 1),
+	})
+	report("metadata source "+c.ServerURL, err)
+	if err == nil {
+		var problems []error
+		for _, p := range db.MetadataProblems() {
+			if p.AppID == "" {
+				problems = append(problems, fmt.Errorf("manifest: %s", p.Message))
+				continue
+			}
+			problems = append(problems, fmt.Errorf("app %q: %s", p.AppID, p.Message))
+		}
+		report("definitions", errors.Join(problems...))
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d config checks failed", failed)
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/sethvargo/go-envconfig"
+
+	"github.com/abcxyz/pkg/testutil"
+)
+
+// testConfig returns the server config for env, with defaults for anything
+// unset.
+func testConfig(tb testing.TB, env map[string]string) *metricsServerConfig {
+	tb.Helper()
+
+	var c metricsServerConfig
+	if err := envconfig.ProcessWith(context.Background(), &envconfig.Config{
+		Target:   &c,
+		Lookuper: envconfig.MapLookuper(env),
+	}); err != nil {
+		tb.Fatalf("failed to process envconfig: %s", err.Error())
+	}
+	return &c
+}
+
+func TestMetricsServerConfig_Validate(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		env      map[string]string
+		wantErrs []string
+	}{
+		{
+			name: "defaults",
+		},
+		{
+			name:     "update_frequency_too_short",
+			env:      map[string]string{"ABC_UPDATER_METRICS_METADATA_UPDATE_FREQUENCY": "10ms"},
+			wantErrs: []string{"METADATA_UPDATE_FREQUENCY must be at least 100ms"},
+		},
+		{
+			name: "reports_every_problem",
+			env: map[string]string{
+				"ABC_UPDATER_METRICS_METADATA_PARALLELISM":  "0",
+				"ABC_UPDATER_METRICS_ANOMALY_MAX_VERSIONS":  "0",
+				"ABC_UPDATER_METRICS_ANOMALY_MAX_PLATFORMS": "0",
+			},
+			wantErrs: []string{
+				"METADATA_PARALLELISM must be at least 1",
+				"ANOMALY_MAX_VERSIONS must be at least 1",
+				"ANOMALY_MAX_PLATFORMS must be at least 1",
+			},
+		},
+		{
+			name:     "serving_config",
+			env:      map[string]string{"ABC_UPDATER_METRICS_TLS_CERT_FILE": "cert.pem"},
+			wantErrs: []string{"TLS_CERT_FILE and TLS_KEY_FILE must be set together"},
+		},
+		{
+			name:     "scrub_rule",
+			env:      map[string]string{"ABC_UPDATER_METRICS_SCRUB_RULES": "email"},
+			wantErrs: []string{`scrub rule "email" must be of the form <action>:<pattern>`},
+		},
+		{
+			name: "scrub_rules_disabled",
+			env:  map[string]string{"ABC_UPDATER_METRICS_SCRUB_RULES": "none"},
+		},
+		{
+			name: "store_urls",
+			env: map[string]string{
+				"ABC_UPDATER_METRICS_DEFINITION_STORE_URL": "mysql://host/db",
+				"ABC_UPDATER_METRICS_STATS_STORE_URL":      "firestore://project/stats/doc",
+			},
+			wantErrs: []string{
+				`unsupported definition store url "mysql://host/db"`,
+				`unsupported stats store url "firestore://project/stats/doc"`,
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := testConfig(t, tc.env).validate()
+			if len(tc.wantErrs) == 0 {
+				if err != nil {
+					t.Errorf("unexpected error: %s", err.Error())
+				}
+				return
+			}
+			for _, want := range tc.wantErrs {
+				if diff := testutil.DiffErrString(err, want); diff != "" {
+					t.Error(diff)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateServingConfig(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		c       *metricsServerConfig
+		wantErr string
+	}{
+		{
+			name: "plain_http",
+			c:    &metricsServerConfig{},
+		},
+		{
+			name: "h2c",
+			c:    &metricsServerConfig{H2C: true},
+		},
+		{
+			name: "tls_files",
+			c:    &metricsServerConfig{TLSCertFile: "cert.pem", TLSKeyFile: "key.pem"},
+		},
+		{
+			name: "autocert",
+			c:    &metricsServerConfig{AutocertDomains: []string{"example.com"}},
+		},
+		{
+			name:    "key_without_cert",
+			c:       &metricsServerConfig{TLSKeyFile: "key.pem"},
+			wantErr: "TLS_CERT_FILE and TLS_KEY_FILE must be set together",
+		},
+		{
+			name: "tls_files_and_autocert",
+			c: &metricsServerConfig{
+				TLSCertFile:     "cert.pem",
+				TLSKeyFile:      "key.pem",
+				AutocertDomains: []string{"example.com"},
+			},
+			wantErr: "TLS_CERT_FILE and AUTOCERT_DOMAINS cannot both be set",
+		},
+		{
+			name:    "h2c_with_tls",
+			c:       &metricsServerConfig{AutocertDomains: []string{"example.com"}, H2C: true},
+			wantErr: "H2C cannot be used with TLS",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if diff := testutil.DiffErrString(validateServingConfig(tc.c), tc.wantErr); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestCheckConfig(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		env      map[string]string
+		manifest string
+		// wantReport has "SERVER_URL" in place of the metadata source.
+		wantReport string
+		wantErr    string
+	}{
+		{
+			name:     "ok",
+			manifest: `{"metricsApps":["foo"]}`,
+			wantReport: `ok    config
+ok    metadata source SERVER_URL
+ok    definitions
+`,
+		},
+		{
+			name:     "bad_config",
+			env:      map[string]string{"ABC_UPDATER_METRICS_METADATA_PARALLELISM": "0"},
+			manifest: `{"metricsApps":["foo"]}`,
+			wantReport: `FAIL  config
+      METADATA_PARALLELISM must be at least 1
+ok    metadata source SERVER_URL
+ok    definitions
+`,
+			wantErr: "1 config checks failed",
+		},
+		{
+			name:     "metadata_problems",
+			manifest: `{"metricsApps":["foo","foo",""]}`,
+			wantReport: `ok    config
+ok    metadata source SERVER_URL
+FAIL  definitions
+      app "foo": app is listed more than once in manifest
+      manifest: manifest contains an empty app ID
+`,
+			wantErr: "1 config checks failed",
+		},
+		{
+			name: "missing_tls_certificate",
+			env: map[string]string{
+				"ABC_UPDATER_METRICS_TLS_CERT_FILE": "/nonexistent/cert.pem",
+				"ABC_UPDATER_METRICS_TLS_KEY_FILE":  "/nonexistent/key.pem",
+			},
+			manifest: `{"metricsApps":["foo"]}`,
+			wantReport: `ok    config
+FAIL  tls certificate
+      open /nonexistent/cert.pem: no such file or directory
+ok    metadata source SERVER_URL
+ok    definitions
+`,
+			wantErr: "1 config checks failed",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.URL.Path == "/manifest.json":
+					fmt.Fprint(w, tc.manifest)
+				case strings.HasSuffix(r.URL.Path, "/metrics.json"):
+					fmt.Fprint(w, `{"metrics":["metric1"]}`)
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			t.Cleanup(ts.Close)
+
+			env := map[string]string{"ABC_UPDATER_METRICS_METADATA_URL": ts.URL}
+			for k, v := range tc.env {
+				env[k] = v
+			}
+
+			var got bytes.Buffer
+			err := checkConfig(context.Background(), testConfig(t, env), &got)
+			if diff := testutil.DiffErrString(err, tc.wantErr); diff != "" {
+				t.Error(diff)
+			}
+			want := strings.ReplaceAll(tc.wantReport, "SERVER_URL", ts.URL)
+			if diff := cmp.Diff(got.String(), want); diff != "" {
+				t.Errorf("unexpected report (-got,+want): %s", diff)
+			}
+		})
+	}
+}
+
+func TestCheckConfig_UnreachableSource(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(ts.Close)
+
+	var got bytes.Buffer
+	err := checkConfig(context.Background(), testConfig(t, map[string]string{
+		"ABC_UPDATER_METRICS_METADATA_URL": ts.URL,
+	}), &got)
+	if diff := testutil.DiffErrString(err, "1 config checks failed"); diff != "" {
+		t.Error(diff)
+	}
+	if want := "FAIL  metadata source " + ts.URL + "\n"; !strings.Contains(got.String(), want) {
+		t.Errorf("expected report to contain %q, got:\n%s", want, got.String())
+	}
+	if strings.Contains(got.String(), "definitions") {
+		t.Errorf("expected definitions not to be checked without metadata, got:\n%s", got.String())
+	}
+}
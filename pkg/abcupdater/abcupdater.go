@@ -0,0 +1,83 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package abcupdater carries an app's identity in a context, so the updater
+// and metrics clients can both be configured from it without inventing
+// context keys.
+package abcupdater
+
+import (
+	"context"
+
+	"github.com/abcxyz/pkg/logging"
+
+	"github.com/abcxyz/abc-updater/pkg/localstore"
+	"github.com/abcxyz/abc-updater/pkg/metrics"
+	"github.com/abcxyz/abc-updater/pkg/updater"
+)
+
+// App identifies the app using abc-updater.
+type App struct {
+	// ID is the app ID, as in the server's manifest.
+	ID string
+	// Version is the running version of the app.
+	Version string
+}
+
+// appKey is the context key for the App.
+type appKey struct{}
+
+// WithApp returns a copy of ctx carrying the app's identity, for FromContext
+// and CheckParams, and a metrics client for it, for metrics.FromContext. The
+// client is created with metrics.New and opt. If that fails, the error is
+// logged at debug level and metrics.FromContext returns a NoopWriter. Close
+// the client before exiting, as with metrics.New.
+func WithApp(ctx context.Context, appID, version string, opt ...metrics.Option) context.Context {
+	mw, err := metrics.New(ctx, appID, version, opt...)
+	if err != nil {
+		logging.FromContext(ctx).DebugContext(ctx, "failed to create metrics client",
+			"app_id", appID, "error", err.Error())
+		mw = metrics.NoopWriter()
+	}
+	ctx = metrics.WithClient(ctx, mw)
+	return context.WithValue(ctx, appKey{}, &App{ID: appID, Version: version})
+}
+
+// FromContext returns the App set by WithApp, or nil if there is none.
+func FromContext(ctx context.Context) *App {
+	a, _ := ctx.Value(appKey{}).(*App)
+	return a
+}
+
+// CheckParams returns new CheckVersionParams for the App set by WithApp, for
+// the updater package, or nil if there is none. Callers may set other fields
+// before using them.
+func CheckParams(ctx context.Context) *updater.CheckVersionParams {
+	a := FromContext(ctx)
+	if a == nil {
+		return nil
+	}
+	return &updater.CheckVersionParams{
+		AppID:   a.ID,
+		Version: a.Version,
+	}
+}
+
+// PurgeLocalData removes everything abc-updater stores locally for appID, as
+// localstore.PurgeLocalData: the version cache and skipped versions, the
+// install ID, and persisted metrics state. Use it to honor a user's request to
+// remove their data. Close the app's metrics client first.
+func PurgeLocalData(appID string) error {
+	return localstore.PurgeLocalData(appID) //nolint:wrapcheck // Already descriptive.
+}
@@ -0,0 +1,96 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package abcupdater
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/sethvargo/go-envconfig"
+
+	"github.com/abcxyz/abc-updater/pkg/localstore"
+	"github.com/abcxyz/abc-updater/pkg/metrics"
+	"github.com/abcxyz/abc-updater/pkg/updater"
+)
+
+func TestWithApp(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	if got := FromContext(ctx); got != nil {
+		t.Errorf("expected no app, got %#v", got)
+	}
+	if got := CheckParams(ctx); got != nil {
+		t.Errorf("expected no params, got %#v", got)
+	}
+
+	ctx = WithApp(ctx, "sample_app_1", "1.0.0",
+		metrics.WithLookuper(envconfig.MapLookuper(map[string]string{"METRICS_URL": "https://example.com"})),
+		metrics.WithInstallIDFileOverride(filepath.Join(t.TempDir(), "id.json")))
+
+	if diff := cmp.Diff(FromContext(ctx), &App{ID: "sample_app_1", Version: "1.0.0"}); diff != "" {
+		t.Errorf("unexpected app (-got,+want): %s", diff)
+	}
+	want := &updater.CheckVersionParams{AppID: "sample_app_1", Version: "1.0.0"}
+	if diff := cmp.Diff(CheckParams(ctx), want); diff != "" {
+		t.Errorf("unexpected params (-got,+want): %s", diff)
+	}
+	if _, ok := metrics.FromContext(ctx).(metrics.LifecycleWriter).InstallAge(); !ok {
+		t.Errorf("expected a metrics client for the app")
+	}
+}
+
+func TestWithApp_MetricsError(t *testing.T) {
+	t.Parallel()
+
+	ctx := WithApp(context.Background(), "sample_app_1", "1.0.0",
+		metrics.WithLookuper(envconfig.MapLookuper(map[string]string{"METRICS_URL": "not a url"})))
+
+	if FromContext(ctx) == nil {
+		t.Errorf("expected app identity even without a metrics client")
+	}
+	if _, ok := metrics.FromContext(ctx).(metrics.LifecycleWriter).InstallAge(); ok {
+		t.Errorf("expected a NoopWriter")
+	}
+}
+
+// Not parallel, as it sets HOME, and PATH so the OS keychain isn't used.
+func TestPurgeLocalData(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("PATH", t.TempDir())
+
+	dir, err := localstore.DefaultDir("sample_app_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := localstore.StoreJSONFile(filepath.Join(dir, "data.json"), map[string]string{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := PurgeLocalData("sample_app_1"); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if _, err := os.Stat(dir); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected %s to be removed, got err: %v", dir, err)
+	}
+
+	if err := PurgeLocalData(""); err == nil {
+		t.Errorf("expected error for empty app ID")
+	}
+}
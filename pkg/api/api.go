@@ -0,0 +1,475 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package api defines the wire types shared by abc-updater clients, the
+// metrics server, and metadata tooling. It is the single source of truth for
+// these types; other packages alias them rather than redefining them, so
+// clients and servers cannot drift apart.
+//
+// This package must not import any other package in this module.
+package api
+
+import (
+	"regexp"
+	"time"
+)
+
+// HTTP headers used for client library version negotiation.
+const (
+	// HeaderClientVersion is sent by clients with the version of the
+	// abc-updater library making the request.
+	HeaderClientVersion = "Abc-Updater-Version"
+
+	// HeaderMinClientVersion is sent by servers to clients older than the
+	// oldest library version they support.
+	HeaderMinClientVersion = "Abc-Updater-Min-Version"
+
+	// HeaderSunset is sent with HeaderMinClientVersion, with the date (an
+	// HTTP-date, see RFC 8594) after which older clients are no longer
+	// served.
+	HeaderSunset = "Sunset"
+)
+
+// MaxAppIDLength is the longest valid app ID.
+const MaxAppIDLength = 128
+
+// appIDPattern matches valid app IDs. They name directories on the metadata
+// server, so must not be "." or "..", or contain path separators.
+var appIDPattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._-]*$`)
+
+// ValidAppID returns true if id is a valid app ID: at most MaxAppIDLength
+// letters, digits, '_', '-', and '.', not starting with '.' or '-'.
+func ValidAppID(id string) bool {
+	return len(id) <= MaxAppIDLength && appIDPattern.MatchString(id)
+}
+
+// AppResponse is the data.json file for an app. It contains information about
+// the most recent version of the app.
+type AppResponse struct {
+	AppID          string `json:"appId"`
+	AppName        string `json:"appName"`
+	AppRepoURL     string `json:"appRepoUrl"`
+	CurrentVersion string `json:"currentVersion"`
+
+	// Severity is how important upgrading to CurrentVersion is. Empty means
+	// SeverityInfo.
+	Severity Severity `json:"severity,omitempty"`
+
+	// Advisories lists known security advisories for the app.
+	Advisories []*Advisory `json:"advisories,omitempty"`
+
+	// Retired is set if the app is deprecated, so clients can tell users.
+	Retired *Retirement `json:"retired,omitempty"`
+
+	// Components lists separately versioned parts of the app's ecosystem,
+	// such as plugins or templates, with their own latest versions.
+	Components []*Component `json:"components,omitempty"`
+
+	// ClientConfig optionally changes how deployed clients behave, so owners
+	// can quiet a misbehaving version without shipping a new one.
+	ClientConfig *ClientConfig `json:"clientConfig,omitempty"`
+
+	// KillSwitches disable telemetry from versions with a known problem.
+	KillSwitches []*KillSwitch `json:"killSwitches,omitempty"`
+}
+
+// KillSwitch disables telemetry from app versions with a known problem, e.g.
+// a bug which double counts a metric. Servers reject their metrics, and the
+// updater tells their users to upgrade.
+type KillSwitch struct {
+	// Versions is a constraint matching the affected versions, e.g.
+	// "= 1.3.0".
+	Versions string `json:"versions"`
+
+	// Message is shown to users of the affected versions, e.g. describing
+	// the problem.
+	Message string `json:"message,omitempty"`
+}
+
+// ClientConfigFileName is the file, in the app's local data directory, where
+// the updater stores the ClientConfig which applies to the running version,
+// for the metrics client, as a StoredClientConfig.
+const ClientConfigFileName = "client_config.json"
+
+// StoredClientConfig is the ClientConfig stored in ClientConfigFileName.
+type StoredClientConfig struct {
+	// AppVersion is the app version the config was checked for. It does not
+	// apply to other versions, e.g. after the user upgrades off a killed
+	// version.
+	AppVersion string `json:"appVersion"`
+
+	ClientConfig
+}
+
+// ClientConfig is server-driven configuration for the updater and metrics
+// clients of an app.
+type ClientConfig struct {
+	// Versions limits the config to app versions matching this constraint,
+	// e.g. ">= 1.2.0, < 1.2.3". Empty applies to every version.
+	Versions string `json:"versions,omitempty"`
+
+	// CheckInterval is how often the updater checks for new versions, e.g.
+	// "72h", instead of daily. Intervals under an hour are raised to an hour.
+	CheckInterval string `json:"checkInterval,omitempty"`
+
+	// DisableMetrics stops the metrics client from sending metrics.
+	DisableMetrics bool `json:"disableMetrics,omitempty"`
+
+	// MetricsSampleRate is the fraction of metric requests the metrics
+	// client sends. Zero sends all of them.
+	MetricsSampleRate float64 `json:"metricsSampleRate,omitempty"`
+}
+
+// Component is a separately versioned part of an app's ecosystem, such as a
+// plugin or template.
+type Component struct {
+	// ID identifies the component, e.g. a template name. IDs are unique within
+	// an app.
+	ID string `json:"id"`
+
+	// Name is a human readable name. Defaults to ID.
+	Name string `json:"name,omitempty"`
+
+	// RepoURL links to where the component can be updated from.
+	RepoURL string `json:"repoUrl,omitempty"`
+
+	// CurrentVersion is the latest version of the component.
+	CurrentVersion string `json:"currentVersion"`
+
+	// Severity is how important upgrading to CurrentVersion is. Empty means
+	// SeverityInfo.
+	Severity Severity `json:"severity,omitempty"`
+}
+
+// TemplateFreshnessResponse reports whether a newer release of a template
+// exists than the ref it is pinned to. Templates are listed as components of
+// an app, identified by their repo URL.
+type TemplateFreshnessResponse struct {
+	// Source and Ref are the template source URL and pinned ref checked.
+	Source string `json:"source"`
+	Ref    string `json:"ref"`
+
+	// ComponentID is the ID of the component listing the template.
+	ComponentID string `json:"componentId"`
+
+	// LatestVersion is the latest tagged release of the template.
+	LatestVersion string `json:"latestVersion"`
+
+	// UpdateAvailable is true if LatestVersion is newer than Ref.
+	UpdateAvailable bool `json:"updateAvailable"`
+
+	// Severity is how important upgrading to LatestVersion is. Empty means
+	// SeverityInfo.
+	Severity Severity `json:"severity,omitempty"`
+}
+
+// Retirement marks an app as deprecated. Servers keep accepting its metrics,
+// tagged as retired, until DropAfter, and then stop serving it.
+type Retirement struct {
+	// DropAfter is when the app stops being served.
+	DropAfter time.Time `json:"dropAfter"`
+
+	// Message is shown to users, e.g. naming a replacement.
+	Message string `json:"message,omitempty"`
+}
+
+// Dropped returns true if the grace period of r has ended at now.
+func (r *Retirement) Dropped(now time.Time) bool {
+	return r != nil && !now.Before(r.DropAfter)
+}
+
+// Advisory is a security advisory affecting some versions of an app.
+type Advisory struct {
+	// ID identifies the advisory, e.g. a CVE or GHSA ID.
+	ID string `json:"id"`
+
+	// Summary is a short, human readable description.
+	Summary string `json:"summary"`
+
+	// Severity is how important upgrading is. Empty means SeveritySecurity.
+	Severity Severity `json:"severity,omitempty"`
+
+	// AffectedVersions is a version constraint matching affected versions,
+	// e.g. ">= 1.0.0, < 1.2.3".
+	AffectedVersions string `json:"affectedVersions"`
+
+	// FixedVersion is the first version with a fix, if any.
+	FixedVersion string `json:"fixedVersion,omitempty"`
+
+	// URL links to details of the advisory.
+	URL string `json:"url,omitempty"`
+}
+
+// Severity is the importance of upgrading to a release.
+type Severity string
+
+const (
+	// SeverityInfo is a routine release.
+	SeverityInfo Severity = "info"
+
+	// SeveritySecurity is a release which fixes a security issue.
+	SeveritySecurity Severity = "security"
+
+	// SeverityCritical is a release which fixes a critical security issue.
+	// Users cannot silence it with version constraints alone.
+	SeverityCritical Severity = "critical"
+)
+
+// Valid returns true if s is empty or a known severity.
+func (s Severity) Valid() bool {
+	switch s {
+	case "", SeverityInfo, SeveritySecurity, SeverityCritical:
+		return true
+	}
+	return false
+}
+
+// ManifestResponse is the json file served to list all apps which have metrics.
+type ManifestResponse struct {
+	MetricsApps []string `json:"metricsApps"`
+
+	// Owners optionally groups apps by the team which owns them, keyed by
+	// owner name, so one server can host many teams. Each app has at most
+	// one owner.
+	Owners map[string]*Owner `json:"owners,omitempty"`
+
+	// Retired optionally marks apps in MetricsApps as deprecated, keyed by
+	// app ID.
+	Retired map[string]*Retirement `json:"retired,omitempty"`
+
+	// Hashes optionally identify the content of each app's metrics.json and
+	// data.json, keyed by app ID. Servers only refetch the files of apps
+	// whose hash changed since they were last fetched. Apps without a hash
+	// are always refetched.
+	Hashes map[string]string `json:"hashes,omitempty"`
+
+	// Generation optionally identifies this version of the manifest. Servers
+	// configured to request deltas send it back as the "changes-since" query
+	// parameter on their next manifest request.
+	Generation string `json:"generation,omitempty"`
+
+	// Delta is set by metadata sources which honored a "changes-since"
+	// query. Only the apps in Changed are refetched; MetricsApps, Owners,
+	// Retired and Hashes must still be complete.
+	Delta bool `json:"delta,omitempty"`
+
+	// Changed are the apps whose files changed since the requested
+	// generation, if Delta is set.
+	Changed []string `json:"changed,omitempty"`
+}
+
+// Owner is a team owning some of the apps in the manifest.
+type Owner struct {
+	// Apps are the IDs of the owner's apps, which must also be in
+	// MetricsApps.
+	Apps []string `json:"apps"`
+
+	// Logging optionally configures the owner's apps. Fields set in an app's
+	// own metrics.json take precedence.
+	Logging *MetricLogging `json:"logging,omitempty"`
+}
+
+// AllowedMetricsResponse is the per-app metrics.json file which lists the metrics
+// which can be recorded.
+type AllowedMetricsResponse struct {
+	Metrics []string `json:"metrics"`
+
+	// Logging optionally configures how the app's metrics are emitted.
+	Logging *MetricLogging `json:"logging,omitempty"`
+
+	// AllowBuildInfo records the BuildInfo sent with the app's metrics. By
+	// default it is discarded.
+	AllowBuildInfo bool `json:"allowBuildInfo,omitempty"`
+
+	// Latency maps latency metrics to the upper bounds of their buckets,
+	// e.g. {"build": ["100ms", "1s", "10s"]}, or to an empty list for
+	// DefaultLatencyBuckets. The bucket metrics named by LatencyBucketMetric
+	// are allowed, not the latency metric itself.
+	Latency map[string][]string `json:"latency,omitempty"`
+
+	// Retired maps metrics to their retirement. Retired metrics are accepted,
+	// even if not listed in Metrics, with a warning until DropAfter, and then
+	// rejected.
+	Retired map[string]*Retirement `json:"retired,omitempty"`
+}
+
+// MetricLogging configures how an app's metrics are emitted, so high-volume
+// apps can be logged separately or sampled to control cost.
+type MetricLogging struct {
+	// Level is the log level, e.g. "DEBUG" or "INFO". Defaults to INFO.
+	Level string `json:"level,omitempty"`
+
+	// Sink is logged with each metric, for use in log router filters.
+	Sink string `json:"sink,omitempty"`
+
+	// SampleRate is the fraction of metrics logged, in (0, 1]. Defaults to
+	// logging all metrics.
+	SampleRate float64 `json:"sampleRate,omitempty"`
+}
+
+// SendMetricRequest is the body of a request to the metrics server's
+// /sendMetrics endpoint.
+type SendMetricRequest struct {
+	// The ID of the application to check.
+	AppID string `json:"appId"`
+
+	// The version of the app to check for updates.
+	// Should be of form vMAJOR[.MINOR[.PATCH[-PRERELEASE][+BUILD]]] (e.g., v1.0.1)
+	AppVersion string `json:"appVersion"`
+
+	// Only single item is used now, map used for flexibility in the future.
+	Metrics map[string]int64 `json:"metrics"`
+
+	// InstallID. Expected to be a random base64 value.
+	InstallID string `json:"installId"`
+
+	// InstallCohort is the ISO week the install ID was generated, e.g.
+	// "2024-W05". The precise install time is never sent.
+	InstallCohort string `json:"installCohort,omitempty"`
+
+	// UpgradedFrom is the previously run version of the app. Only set for the
+	// upgrade metric.
+	UpgradedFrom string `json:"upgradedFrom,omitempty"`
+
+	// DowngradedFrom is the previously run, newer, version of the app. Only
+	// set for the downgrade metric.
+	DowngradedFrom string `json:"downgradedFrom,omitempty"`
+
+	// IncludeDispositions requests a per-metric MetricDisposition in the
+	// response. Off by default, so responses to older clients are unchanged.
+	IncludeDispositions bool `json:"includeDispositions,omitempty"`
+
+	// Dropped is the number of metrics the client dropped, without sending,
+	// because it exceeded its request budget since the last request sent.
+	Dropped int64 `json:"dropped,omitempty"`
+
+	// BuildInfo optionally identifies the build of the app sending the
+	// metric. It is only recorded for apps which allow it.
+	BuildInfo *BuildInfo `json:"buildInfo,omitempty"`
+
+	// EventTime is when the metrics occurred, in Unix milliseconds by the
+	// client's clock. Set by clients which buffer metrics before sending them,
+	// such as counters. If unset, the metrics occurred when received.
+	EventTime int64 `json:"eventTime,omitempty"`
+
+	// SentTime is when the request was sent, in Unix milliseconds by the
+	// client's clock. The server compares it to when the request was received
+	// to correct EventTime for clock skew.
+	SentTime int64 `json:"sentTime,omitempty"`
+
+	// Sequence numbers the requests sent by an install, starting at 1, if the
+	// client enables it. The server counts gaps to estimate how many requests
+	// are lost.
+	Sequence int64 `json:"sequence,omitempty"`
+}
+
+// BuildInfo identifies a specific build of an app, so owners can correlate
+// issues with builds rather than just version strings.
+type BuildInfo struct {
+	// Commit is the VCS revision the app was built from, with a "-dirty"
+	// suffix if the working tree was modified.
+	Commit string `json:"commit,omitempty"`
+
+	// Date is when the commit was made, in RFC 3339 format.
+	Date string `json:"date,omitempty"`
+
+	// GoVersion is the version of Go the app was built with, e.g. "go1.22.1".
+	GoVersion string `json:"goVersion,omitempty"`
+
+	// Builder is the compiler and target platform, e.g. "gc linux/amd64".
+	Builder string `json:"builder,omitempty"`
+}
+
+// MetricDisposition is what the server did with a single metric in a
+// SendMetricRequest.
+type MetricDisposition string
+
+const (
+	// DispositionAccepted means the metric is allowed for the app and was
+	// recorded, possibly subject to the app's sample rate.
+	DispositionAccepted MetricDisposition = "accepted"
+
+	// DispositionUnknownMetric means the metric is not in the app's allowlist
+	// and was dropped.
+	DispositionUnknownMetric MetricDisposition = "unknown_metric"
+
+	// DispositionRateLimited means the metric was dropped because the server
+	// is limiting the rate of metrics it records.
+	DispositionRateLimited MetricDisposition = "rate_limited"
+
+	// DispositionSensitiveData means the metric was dropped because its name
+	// or a label value looked like personal data, such as an email address.
+	DispositionSensitiveData MetricDisposition = "sensitive_data"
+
+	// DispositionRetired means the metric was dropped because the app's
+	// metrics.json retired it.
+	DispositionRetired MetricDisposition = "retired"
+)
+
+// SendMetricResponse is the body of a successful response from the metrics
+// server's /sendMetrics endpoint.
+type SendMetricResponse struct {
+	Message string `json:"message"`
+
+	// Warnings describe problems with an accepted request, such as metrics
+	// which are not allowed and were dropped, or deprecated fields. Clients
+	// should only log these for debugging.
+	Warnings []string `json:"warnings,omitempty"`
+
+	// Dispositions maps each metric name in the request to what the server
+	// did with it. Only set if the request set IncludeDispositions.
+	Dispositions map[string]MetricDisposition `json:"dispositions,omitempty"`
+}
+
+// BulkSendMetricResponse is the response to a bulk request of newline
+// delimited SendMetricRequests, with one result per non-empty line.
+type BulkSendMetricResponse struct {
+	// Accepted and Rejected are the number of lines with each outcome.
+	Accepted int `json:"accepted"`
+	Rejected int `json:"rejected"`
+
+	// Results are in the same order as the lines of the request.
+	Results []*BulkSendMetricResult `json:"results"`
+}
+
+// BulkSendMetricResult is the outcome of a single line of a bulk request.
+type BulkSendMetricResult struct {
+	// Line is the line number in the request body, starting at 1.
+	Line int `json:"line"`
+
+	// Status is the HTTP status the line would have received if sent on its
+	// own, e.g. 202 if accepted.
+	Status int `json:"status"`
+
+	// Code and Message describe why the line was rejected, as in an error
+	// response. Empty if accepted.
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+
+	// Warnings and Dispositions are as in SendMetricResponse, if accepted.
+	Warnings     []string                     `json:"warnings,omitempty"`
+	Dispositions map[string]MetricDisposition `json:"dispositions,omitempty"`
+}
+
+// RollcallRequest is the body of a request to the metrics server's /rollcall
+// endpoint, sent at most daily by each install to count the active install
+// base. It deliberately has no install ID.
+type RollcallRequest struct {
+	// AppID and AppVersion are as in SendMetricRequest.
+	AppID      string `json:"appId"`
+	AppVersion string `json:"appVersion"`
+
+	// InstallCohort is the ISO week the install was created, e.g. "2024-W05".
+	InstallCohort string `json:"installCohort,omitempty"`
+}
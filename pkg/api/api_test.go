@@ -0,0 +1,92 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// TestWireFormat pins the JSON encoding of each wire type. Deployed clients
+// and servers depend on these names, so changing them is a breaking change.
+func TestWireFormat(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		v    any
+		want string
+	}{
+		{
+			name: "app_response",
+			v:    &AppResponse{AppID: "a", AppName: "A", AppRepoURL: "u", CurrentVersion: "1.0.0"},
+			want: `{"appId":"a","appName":"A","appRepoUrl":"u","currentVersion":"1.0.0"}`,
+		},
+		{
+			name: "manifest_response",
+			v:    &ManifestResponse{MetricsApps: []string{"a"}},
+			want: `{"metricsApps":["a"]}`,
+		},
+		{
+			name: "allowed_metrics_response",
+			v: &AllowedMetricsResponse{
+				Metrics: []string{"m"},
+				Logging: &MetricLogging{Level: "DEBUG", Sink: "s", SampleRate: 0.5},
+			},
+			want: `{"metrics":["m"],"logging":{"level":"DEBUG","sink":"s","sampleRate":0.5}}`,
+		},
+		{
+			name: "allowed_metrics_response_minimal",
+			v:    &AllowedMetricsResponse{Metrics: []string{"m"}},
+			want: `{"metrics":["m"]}`,
+		},
+		{
+			name: "allowed_metrics_response_latency",
+			v:    &AllowedMetricsResponse{Metrics: []string{"m"}, Latency: map[string][]string{"build": {"100ms", "1s"}}},
+			want: `{"metrics":["m"],"latency":{"build":["100ms","1s"]}}`,
+		},
+		{
+			name: "send_metric_request",
+			v: &SendMetricRequest{
+				AppID:         "a",
+				AppVersion:    "1.0.0",
+				Metrics:       map[string]int64{"m": 1},
+				InstallID:     "i",
+				InstallCohort: "2024-W05",
+				UpgradedFrom:  "0.9.0",
+			},
+			want: `{"appId":"a","appVersion":"1.0.0","metrics":{"m":1},"installId":"i","installCohort":"2024-W05","upgradedFrom":"0.9.0"}`,
+		},
+		{
+			name: "send_metric_request_minimal",
+			v:    &SendMetricRequest{AppID: "a", AppVersion: "1.0.0", Metrics: map[string]int64{"m": 1}, InstallID: "i"},
+			want: `{"appId":"a","appVersion":"1.0.0","metrics":{"m":1},"installId":"i"}`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			b, err := json.Marshal(tc.v)
+			if err != nil {
+				t.Fatalf("failed to marshal: %s", err.Error())
+			}
+			if got := string(b); got != tc.want {
+				t.Errorf("unexpected encoding.\ngot:  %s\nwant: %s", got, tc.want)
+			}
+		})
+	}
+}
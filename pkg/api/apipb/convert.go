@@ -0,0 +1,91 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package apipb contains protobuf encodings of the wire types in package api,
+// generated from metrics.proto, for clients which prefer a smaller and
+// stricter encoding than JSON.
+package apipb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative metrics.proto
+
+import (
+	"github.com/abcxyz/abc-updater/pkg/api"
+)
+
+// ContentType is the Content-Type of protobuf encoded requests.
+const ContentType = "application/x-protobuf"
+
+// FromSendMetricRequest converts r to its protobuf encoding.
+func FromSendMetricRequest(r *api.SendMetricRequest) *SendMetricRequest {
+	return &SendMetricRequest{
+		AppId:               r.AppID,
+		AppVersion:          r.AppVersion,
+		Metrics:             r.Metrics,
+		InstallId:           r.InstallID,
+		InstallCohort:       r.InstallCohort,
+		UpgradedFrom:        r.UpgradedFrom,
+		DowngradedFrom:      r.DowngradedFrom,
+		IncludeDispositions: r.IncludeDispositions,
+		Dropped:             r.Dropped,
+		BuildInfo:           fromBuildInfo(r.BuildInfo),
+		EventTime:           r.EventTime,
+		SentTime:            r.SentTime,
+		Sequence:            r.Sequence,
+	}
+}
+
+// fromBuildInfo converts b to its protobuf encoding.
+func fromBuildInfo(b *api.BuildInfo) *BuildInfo {
+	if b == nil {
+		return nil
+	}
+	return &BuildInfo{
+		Commit:    b.Commit,
+		Date:      b.Date,
+		GoVersion: b.GoVersion,
+		Builder:   b.Builder,
+	}
+}
+
+// ToAPI converts x to the JSON wire type.
+func (x *SendMetricRequest) ToAPI() *api.SendMetricRequest {
+	return &api.SendMetricRequest{
+		AppID:               x.GetAppId(),
+		AppVersion:          x.GetAppVersion(),
+		Metrics:             x.GetMetrics(),
+		InstallID:           x.GetInstallId(),
+		InstallCohort:       x.GetInstallCohort(),
+		UpgradedFrom:        x.GetUpgradedFrom(),
+		DowngradedFrom:      x.GetDowngradedFrom(),
+		IncludeDispositions: x.GetIncludeDispositions(),
+		Dropped:             x.GetDropped(),
+		BuildInfo:           x.GetBuildInfo().toAPI(),
+		EventTime:           x.GetEventTime(),
+		SentTime:            x.GetSentTime(),
+		Sequence:            x.GetSequence(),
+	}
+}
+
+// toAPI converts x to the JSON wire type.
+func (x *BuildInfo) toAPI() *api.BuildInfo {
+	if x == nil {
+		return nil
+	}
+	return &api.BuildInfo{
+		Commit:    x.GetCommit(),
+		Date:      x.GetDate(),
+		GoVersion: x.GetGoVersion(),
+		Builder:   x.GetBuilder(),
+	}
+}
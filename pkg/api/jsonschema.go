@@ -0,0 +1,137 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// JSONSchemaDialect is the JSON Schema version of the schemas returned by
+// JSONSchemas.
+const JSONSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// JSONSchema is the subset of a JSON Schema document needed to describe the
+// types in this package.
+type JSONSchema struct {
+	Schema      string `json:"$schema,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+
+	Type   string   `json:"type,omitempty"`
+	Format string   `json:"format,omitempty"`
+	Enum   []string `json:"enum,omitempty"`
+
+	// Properties and Required describe objects with fixed fields, and
+	// AdditionalProperties the values of objects used as maps.
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *JSONSchema            `json:"additionalProperties,omitempty"`
+
+	// Items describes the elements of arrays.
+	Items *JSONSchema `json:"items,omitempty"`
+}
+
+// JSONSchemas returns the schemas of the documents clients exchange with
+// abc-updater, keyed by name: "sendMetrics.json" for SendMetricRequest,
+// "data.json" for AppResponse, "manifest.json" for ManifestResponse, and
+// "metrics.json" for AllowedMetricsResponse. They are generated from the
+// types in this package, so they cannot drift from what the Go client and
+// server use, and let clients in other languages validate their payloads.
+func JSONSchemas() map[string]*JSONSchema {
+	return map[string]*JSONSchema{
+		"sendMetrics.json": newDocument(&SendMetricRequest{}, "SendMetricRequest",
+			"A request to POST /sendMetrics."),
+		"data.json": newDocument(&AppResponse{}, "AppResponse",
+			"The version data for an app, served as <app>/data.json."),
+		"manifest.json": newDocument(&ManifestResponse{}, "ManifestResponse",
+			"The list of apps with metrics, served as manifest.json."),
+		"metrics.json": newDocument(&AllowedMetricsResponse{}, "AllowedMetricsResponse",
+			"The metrics allowed for an app, served as <app>/metrics.json."),
+	}
+}
+
+// newDocument returns the schema of v as a top-level document.
+func newDocument(v any, title, description string) *JSONSchema {
+	s := NewJSONSchema(v)
+	s.Schema = JSONSchemaDialect
+	s.Title = title
+	s.Description = description
+	return s
+}
+
+// schemaEnums are the allowed values of string types in this package which
+// are enums. Empty values are always omitted, so are not listed.
+var schemaEnums = map[reflect.Type][]string{
+	reflect.TypeOf(Severity("")): {string(SeverityInfo), string(SeveritySecurity), string(SeverityCritical)},
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// NewJSONSchema returns the schema of the JSON encoding of v, following the
+// encoding/json rules for field names and omitempty. Fields which are always
+// encoded are required.
+func NewJSONSchema(v any) *JSONSchema {
+	return typeSchema(reflect.TypeOf(v))
+}
+
+// typeSchema returns the schema of the JSON encoding of t.
+func typeSchema(t reflect.Type) *JSONSchema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return &JSONSchema{Type: "string", Format: "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &JSONSchema{Type: "string", Enum: schemaEnums[t]}
+	case reflect.Bool:
+		return &JSONSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &JSONSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &JSONSchema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &JSONSchema{Type: "array", Items: typeSchema(t.Elem())}
+	case reflect.Map:
+		return &JSONSchema{Type: "object", AdditionalProperties: typeSchema(t.Elem())}
+	case reflect.Struct:
+		s := &JSONSchema{Type: "object", Properties: make(map[string]*JSONSchema)}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			s.Properties[name] = typeSchema(f.Type)
+			if !strings.Contains(","+opts+",", ",omitempty,") {
+				s.Required = append(s.Required, name)
+			}
+		}
+		return s
+	}
+	// Not used by the types in this package.
+	return &JSONSchema{}
+}
@@ -0,0 +1,96 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNewJSONSchema(t *testing.T) {
+	t.Parallel()
+
+	type nested struct {
+		When time.Time `json:"when"`
+	}
+	type sample struct {
+		Name     string           `json:"name"`
+		Severity Severity         `json:"severity,omitempty"`
+		Count    int64            `json:"count"`
+		Rate     float64          `json:"rate,omitempty"`
+		Enabled  bool             `json:"enabled,omitempty"`
+		Tags     []string         `json:"tags"`
+		Counts   map[string]int64 `json:"counts,omitempty"`
+		Nested   *nested          `json:"nested,omitempty"`
+		Skipped  string           `json:"-"`
+		Untagged string
+		private  string
+	}
+
+	got := NewJSONSchema(&sample{private: "unused"})
+	want := &JSONSchema{
+		Type: "object",
+		Properties: map[string]*JSONSchema{
+			"name":     {Type: "string"},
+			"severity": {Type: "string", Enum: []string{"info", "security", "critical"}},
+			"count":    {Type: "integer"},
+			"rate":     {Type: "number"},
+			"enabled":  {Type: "boolean"},
+			"tags":     {Type: "array", Items: &JSONSchema{Type: "string"}},
+			"counts":   {Type: "object", AdditionalProperties: &JSONSchema{Type: "integer"}},
+			"nested": {
+				Type:       "object",
+				Properties: map[string]*JSONSchema{"when": {Type: "string", Format: "date-time"}},
+				Required:   []string{"when"},
+			},
+			"Untagged": {Type: "string"},
+		},
+		Required: []string{"name", "count", "tags", "Untagged"},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("unexpected schema (-got,+want): %s", diff)
+	}
+}
+
+func TestJSONSchemas(t *testing.T) {
+	t.Parallel()
+
+	schemas := JSONSchemas()
+	names := make([]string, 0, len(schemas))
+	for name, s := range schemas {
+		names = append(names, name)
+		if s.Schema != JSONSchemaDialect || s.Title == "" || s.Type != "object" {
+			t.Errorf("schema %q is not a titled top-level object schema: %+v", name, s)
+		}
+	}
+	sort.Strings(names)
+	if diff := cmp.Diff(names, []string{"data.json", "manifest.json", "metrics.json", "sendMetrics.json"}); diff != "" {
+		t.Errorf("unexpected schema names (-got,+want): %s", diff)
+	}
+
+	send := schemas["sendMetrics.json"]
+	if diff := cmp.Diff(send.Required, []string{"appId", "appVersion", "metrics", "installId"}); diff != "" {
+		t.Errorf("unexpected required fields (-got,+want): %s", diff)
+	}
+	if got, want := send.Properties["buildInfo"].Properties["commit"].Type, "string"; got != want {
+		t.Errorf("unexpected buildInfo.commit type. got %q want %q", got, want)
+	}
+	if got, want := schemas["data.json"].Properties["retired"].Properties["dropAfter"].Format, "date-time"; got != want {
+		t.Errorf("unexpected retired.dropAfter format. got %q want %q", got, want)
+	}
+}
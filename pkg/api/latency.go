@@ -0,0 +1,79 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// MaxLatencyBuckets is the most buckets a latency metric may have, not
+// counting the overflow bucket.
+const MaxLatencyBuckets = 20
+
+// DefaultLatencyBuckets are the upper bounds of the buckets of latency
+// metrics which do not list their own.
+var DefaultLatencyBuckets = []time.Duration{
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+	30 * time.Second,
+	time.Minute,
+}
+
+// LatencyBucketMetric returns the name of the metric counting durations of
+// the latency metric up to le, e.g. "build.le_250ms". If le is zero, it
+// returns the overflow bucket for durations longer than every bound, e.g.
+// "build.le_inf".
+func LatencyBucketMetric(metric string, le time.Duration) string {
+	if le <= 0 {
+		return metric + ".le_inf"
+	}
+	return metric + ".le_" + strconv.FormatInt(le.Milliseconds(), 10) + "ms"
+}
+
+// ParseLatencyBuckets parses the bucket bounds of a latency metric, such as
+// "100ms" or "2.5s", which must be whole milliseconds and increasing. An
+// empty list returns DefaultLatencyBuckets.
+func ParseLatencyBuckets(bounds []string) ([]time.Duration, error) {
+	if len(bounds) == 0 {
+		return DefaultLatencyBuckets, nil
+	}
+	if len(bounds) > MaxLatencyBuckets {
+		return nil, fmt.Errorf("%d buckets is more than the maximum of %d", len(bounds), MaxLatencyBuckets)
+	}
+	buckets := make([]time.Duration, 0, len(bounds))
+	for _, b := range bounds {
+		d, err := time.ParseDuration(b)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket %q: %w", b, err)
+		}
+		if d < time.Millisecond || d%time.Millisecond != 0 {
+			return nil, fmt.Errorf("bucket %q is not a whole number of milliseconds", b)
+		}
+		if len(buckets) > 0 && d <= buckets[len(buckets)-1] {
+			return nil, fmt.Errorf("bucket %q is not greater than the previous bucket", b)
+		}
+		buckets = append(buckets, d)
+	}
+	return buckets, nil
+}
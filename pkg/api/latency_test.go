@@ -0,0 +1,90 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/abcxyz/pkg/testutil"
+)
+
+func TestParseLatencyBuckets(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		bounds  []string
+		want    []time.Duration
+		wantErr string
+	}{
+		{
+			name: "default",
+			want: DefaultLatencyBuckets,
+		},
+		{
+			name:   "custom",
+			bounds: []string{"100ms", "2.5s", "1m"},
+			want:   []time.Duration{100 * time.Millisecond, 2500 * time.Millisecond, time.Minute},
+		},
+		{
+			name:    "invalid",
+			bounds:  []string{"soon"},
+			wantErr: `invalid bucket "soon"`,
+		},
+		{
+			name:    "sub_millisecond",
+			bounds:  []string{"1500us"},
+			wantErr: `bucket "1500us" is not a whole number of milliseconds`,
+		},
+		{
+			name:    "not_increasing",
+			bounds:  []string{"1s", "1000ms"},
+			wantErr: `bucket "1000ms" is not greater than the previous bucket`,
+		},
+		{
+			name:    "too_many",
+			bounds:  make([]string, MaxLatencyBuckets+1),
+			wantErr: "21 buckets is more than the maximum of 20",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := ParseLatencyBuckets(tc.bounds)
+			if diff := testutil.DiffErrString(err, tc.wantErr); diff != "" {
+				t.Fatal(diff)
+			}
+			if diff := cmp.Diff(got, tc.want); diff != "" {
+				t.Errorf("unexpected buckets (-got,+want): %s", diff)
+			}
+		})
+	}
+}
+
+func TestLatencyBucketMetric(t *testing.T) {
+	t.Parallel()
+
+	if got, want := LatencyBucketMetric("build", 2500*time.Millisecond), "build.le_2500ms"; got != want {
+		t.Errorf("unexpected bucket metric. got %q want %q", got, want)
+	}
+	if got, want := LatencyBucketMetric("build", 0), "build.le_inf"; got != want {
+		t.Errorf("unexpected overflow metric. got %q want %q", got, want)
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"fmt"
+	"time"
+)
+
+// Lifecycle metrics, sent by clients when an app is upgraded, downgraded, or
+// uninstalled. The server records them for every app, whether or not its
+// metrics.json lists them.
+const (
+	UpgradeMetric   = "upgrade"
+	DowngradeMetric = "downgrade"
+	UninstallMetric = "uninstall"
+)
+
+// CohortForInstallTime returns the ISO week of installTime (UTC epoch
+// seconds), e.g. "2024-W05". Returns empty string if installTime is unknown.
+// Clients send it as SendMetricRequest.InstallCohort, and the server derives
+// it for older clients which sent the install time.
+func CohortForInstallTime(installTime int64) string {
+	if installTime <= 0 {
+		return ""
+	}
+	year, week := time.Unix(installTime, 0).UTC().ISOWeek()
+	return fmt.Sprintf("%d-W%02d", year, week)
+}
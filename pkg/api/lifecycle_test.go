@@ -0,0 +1,55 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCohortForInstallTime(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name        string
+		installTime int64
+		want        string
+	}{
+		{
+			name:        "unknown",
+			installTime: 0,
+			want:        "",
+		},
+		{
+			name:        "mid_year",
+			installTime: time.Date(2024, 6, 12, 8, 0, 0, 0, time.UTC).Unix(),
+			want:        "2024-W24",
+		},
+		{
+			name:        "iso_year_differs_from_calendar_year",
+			installTime: time.Date(2024, 12, 30, 8, 0, 0, 0, time.UTC).Unix(),
+			want:        "2025-W01",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			if got := CohortForInstallTime(tc.installTime); got != tc.want {
+				t.Errorf("unexpected cohort. got %q want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package apierror defines the JSON error envelope returned by abc-updater
+// servers, and helpers for clients to turn error responses into typed errors.
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxErrorResponseBytes limits how much of an error response body is read.
+const maxErrorResponseBytes = 2048
+
+// Code is a stable, machine readable identifier for an error response.
+type Code string
+
+const (
+	// CodeUnknown is used by clients when a response did not contain a
+	// recognizable error envelope, e.g. when returned by a proxy or static
+	// file host.
+	CodeUnknown Code = "UNKNOWN"
+
+	CodeMalformedRequest     Code = "MALFORMED_REQUEST"
+	CodeUnsupportedMediaType Code = "UNSUPPORTED_MEDIA_TYPE"
+	CodeRequestTooLarge      Code = "REQUEST_TOO_LARGE"
+	CodeUnknownApp           Code = "UNKNOWN_APP"
+	CodeAppRetired           Code = "APP_RETIRED"
+	CodeMetricsRetired       Code = "METRICS_RETIRED"
+	CodeVersionDisabled      Code = "VERSION_DISABLED"
+	CodeNotFound             Code = "NOT_FOUND"
+	CodeUnauthorized         Code = "UNAUTHORIZED"
+	CodeForbidden            Code = "FORBIDDEN"
+	CodeOverloaded           Code = "OVERLOADED"
+	CodeRateLimited          Code = "RATE_LIMITED"
+	CodeUnknownTemplate      Code = "UNKNOWN_TEMPLATE"
+
+	CodeTooManyMetrics    Code = "TOO_MANY_METRICS"
+	CodeMetricNameTooLong Code = "METRIC_NAME_TOO_LONG"
+	CodeCountOutOfRange   Code = "COUNT_OUT_OF_RANGE"
+	CodeInvalidString     Code = "INVALID_STRING"
+	CodeInvalidVersion    Code = "INVALID_VERSION"
+	CodeInvalidEventTime  Code = "INVALID_EVENT_TIME"
+
+	// Codes for request bodies which could not be decoded, by cause. Bodies
+	// rejected for other reasons use CodeMalformedRequest.
+	CodeMalformedJSON       Code = "MALFORMED_JSON"
+	CodeMalformedGzip       Code = "MALFORMED_GZIP"
+	CodeMalformedProtobuf   Code = "MALFORMED_PROTOBUF"
+	CodeInvalidFieldType    Code = "INVALID_FIELD_TYPE"
+	CodeEmptyBody           Code = "EMPTY_BODY"
+	CodeTrailingData        Code = "TRAILING_DATA"
+	CodeUnsupportedEncoding Code = "UNSUPPORTED_ENCODING"
+)
+
+// Response is the JSON body of an error response. It deliberately does not
+// implement error, so renderers encode it as-is.
+type Response struct {
+	Code    Code   `json:"code"`
+	Message string `json:"message"`
+}
+
+// New returns a Response with the given code and formatted message.
+func New(code Code, format string, args ...any) *Response {
+	return &Response{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// Error is a client side error produced from a non-successful response.
+type Error struct {
+	StatusCode int
+	Code       Code
+	Message    string
+	// RetryAfter is how long the server asked clients to wait before trying
+	// again, from the Retry-After header of a 429 or 503 response. Zero if
+	// not given.
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("received %d response (%s): %s", e.StatusCode, e.Code, e.Message)
+}
+
+// FromResponse reads the body of a non-successful response and returns a typed
+// error. Bodies which are not an error envelope are not included in the error,
+// to avoid leaking backend details; the status text is used instead.
+func FromResponse(resp *http.Response) *Error {
+	apiErr := &Error{
+		StatusCode: resp.StatusCode,
+		Code:       CodeUnknown,
+		Message:    http.StatusText(resp.StatusCode),
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		apiErr.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	}
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorResponseBytes))
+	if err != nil {
+		return apiErr
+	}
+	var r Response
+	if err := json.Unmarshal(b, &r); err != nil || r.Code == "" {
+		return apiErr
+	}
+	apiErr.Code = r.Code
+	apiErr.Message = r.Message
+	return apiErr
+}
+
+// parseRetryAfter parses a Retry-After header value, either a number of
+// seconds or an HTTP date, into the duration to wait from now. Returns zero if
+// v is empty, invalid, or in the past.
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return max(time.Duration(secs)*time.Second, 0)
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(t.Sub(now), 0)
+	}
+	return 0
+}
+
+// HasCode reports whether err wraps an *Error with the given code.
+func HasCode(err error, code Code) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.Code == code
+}
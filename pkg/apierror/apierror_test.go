@@ -0,0 +1,150 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apierror
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFromResponse(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		status int
+		header http.Header
+		body   string
+		want   *Error
+	}{
+		{
+			name:   "envelope",
+			status: http.StatusNotFound,
+			body:   `{"code":"UNKNOWN_APP","message":"unknown app \"foo\""}`,
+			want: &Error{
+				StatusCode: http.StatusNotFound,
+				Code:       CodeUnknownApp,
+				Message:    `unknown app "foo"`,
+			},
+		},
+		{
+			name:   "non_json_body_not_leaked",
+			status: http.StatusInternalServerError,
+			body:   "panic: backend.internal:5432 connection refused",
+			want: &Error{
+				StatusCode: http.StatusInternalServerError,
+				Code:       CodeUnknown,
+				Message:    "Internal Server Error",
+			},
+		},
+		{
+			name:   "json_without_code",
+			status: http.StatusBadRequest,
+			body:   `{"errors":["bad"]}`,
+			want: &Error{
+				StatusCode: http.StatusBadRequest,
+				Code:       CodeUnknown,
+				Message:    "Bad Request",
+			},
+		},
+		{
+			name:   "rate_limited_retry_after",
+			status: http.StatusTooManyRequests,
+			header: http.Header{"Retry-After": {"30"}},
+			body:   `{"code":"RATE_LIMITED","message":"slow down"}`,
+			want: &Error{
+				StatusCode: http.StatusTooManyRequests,
+				Code:       CodeRateLimited,
+				Message:    "slow down",
+				RetryAfter: 30 * time.Second,
+			},
+		},
+		{
+			name:   "retry_after_ignored_for_other_status",
+			status: http.StatusBadGateway,
+			header: http.Header{"Retry-After": {"30"}},
+			want: &Error{
+				StatusCode: http.StatusBadGateway,
+				Code:       CodeUnknown,
+				Message:    "Bad Gateway",
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			resp := &http.Response{
+				StatusCode: tc.status,
+				Header:     tc.header,
+				Body:       io.NopCloser(strings.NewReader(tc.body)),
+			}
+			got := FromResponse(resp)
+			if diff := cmp.Diff(got, tc.want); diff != "" {
+				t.Errorf("unexpected error (-got,+want): %s", diff)
+			}
+		})
+	}
+}
+
+func TestHasCode(t *testing.T) {
+	t.Parallel()
+
+	err := fmt.Errorf("wrapped: %w", &Error{StatusCode: 400, Code: CodeTooManyMetrics})
+	if !HasCode(err, CodeTooManyMetrics) {
+		t.Errorf("HasCode(%v, %q) = false, want true", err, CodeTooManyMetrics)
+	}
+	if HasCode(err, CodeUnknownApp) {
+		t.Errorf("HasCode(%v, %q) = true, want false", err, CodeUnknownApp)
+	}
+	if HasCode(fmt.Errorf("plain"), CodeUnknown) {
+		t.Errorf("HasCode on plain error = true, want false")
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name  string
+		value string
+		want  time.Duration
+	}{
+		{name: "empty"},
+		{name: "seconds", value: "120", want: 2 * time.Minute},
+		{name: "negative_seconds", value: "-5"},
+		{name: "http_date", value: "Wed, 01 May 2024 12:01:30 GMT", want: 90 * time.Second},
+		{name: "past_date", value: "Wed, 01 May 2024 11:00:00 GMT"},
+		{name: "invalid", value: "soon"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := parseRetryAfter(tc.value, now); got != tc.want {
+				t.Errorf("unexpected duration. got %s want %s", got, tc.want)
+			}
+		})
+	}
+}
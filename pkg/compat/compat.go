@@ -0,0 +1,77 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package compat implements the client side of library version negotiation:
+// clients report their library version, and warn if the server says it is no
+// longer supported.
+package compat
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-version"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+	"github.com/abcxyz/abc-updater/pkg/useragent"
+	"github.com/abcxyz/pkg/logging"
+)
+
+// warned records warnings already logged, so each is logged once per process.
+var warned sync.Map
+
+// SetVersionHeader adds the library version to request headers h.
+func SetVersionHeader(h http.Header) {
+	h.Set(api.HeaderClientVersion, useragent.LibraryVersion())
+}
+
+// WarnIfUnsupported logs a warning, once per process, if response headers h
+// indicate the server no longer supports this library version.
+func WarnIfUnsupported(ctx context.Context, h http.Header) {
+	msg := unsupportedMessage(useragent.LibraryVersion(), h)
+	if msg == "" {
+		return
+	}
+	if _, loaded := warned.LoadOrStore(msg, struct{}{}); loaded {
+		return
+	}
+	logging.FromContext(ctx).WarnContext(ctx, msg)
+}
+
+// unsupportedMessage returns the warning for clientVersion given response
+// headers h, or an empty string if there is nothing to warn about.
+func unsupportedMessage(clientVersion string, h http.Header) string {
+	minVersion := h.Get(api.HeaderMinClientVersion)
+	if minVersion == "" {
+		return ""
+	}
+	minimum, err := version.NewVersion(minVersion)
+	if err != nil {
+		return ""
+	}
+	// Development builds have no version to compare.
+	current, err := version.NewVersion(clientVersion)
+	if err != nil || !current.LessThan(minimum) {
+		return ""
+	}
+
+	msg := fmt.Sprintf("abc-updater library version %s is no longer supported by the server, which requires at least %s", current, minimum)
+	if sunset, err := http.ParseTime(h.Get(api.HeaderSunset)); err == nil {
+		msg += fmt.Sprintf(" and will stop serving older versions after %s", sunset.UTC().Format(time.DateOnly))
+	}
+	return msg + "; update github.com/abcxyz/abc-updater"
+}
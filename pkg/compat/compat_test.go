@@ -0,0 +1,80 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package compat
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestUnsupportedMessage(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name          string
+		clientVersion string
+		headers       map[string]string
+		want          string
+	}{
+		{
+			name:          "no_header",
+			clientVersion: "0.1.0",
+		},
+		{
+			name:          "supported",
+			clientVersion: "0.2.0",
+			headers:       map[string]string{"Abc-Updater-Min-Version": "0.2.0"},
+		},
+		{
+			name:          "unsupported",
+			clientVersion: "0.1.0",
+			headers:       map[string]string{"Abc-Updater-Min-Version": "0.2.0"},
+			want:          "abc-updater library version 0.1.0 is no longer supported by the server, which requires at least 0.2.0; update github.com/abcxyz/abc-updater",
+		},
+		{
+			name:          "unsupported_with_sunset",
+			clientVersion: "0.1.0",
+			headers: map[string]string{
+				"Abc-Updater-Min-Version": "0.2.0",
+				"Sunset":                  "Sat, 01 Mar 2025 00:00:00 GMT",
+			},
+			want: "abc-updater library version 0.1.0 is no longer supported by the server, which requires at least 0.2.0 and will stop serving older versions after 2025-03-01; update github.com/abcxyz/abc-updater",
+		},
+		{
+			name:          "devel_client",
+			clientVersion: "devel",
+			headers:       map[string]string{"Abc-Updater-Min-Version": "0.2.0"},
+		},
+		{
+			name:          "invalid_min_version",
+			clientVersion: "0.1.0",
+			headers:       map[string]string{"Abc-Updater-Min-Version": "latest"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			h := make(http.Header)
+			for k, v := range tc.headers {
+				h.Set(k, v)
+			}
+			if got := unsupportedMessage(tc.clientVersion, h); got != tc.want {
+				t.Errorf("unexpected message.\ngot:  %q\nwant: %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package conformance checks abc-updater clients written in other languages
+// against the wire contract. It provides golden request and response
+// fixtures, and a Server which plays both the metadata host and the metrics
+// server for an SDK's test suite, reporting every way the SDK's requests
+// break the contract.
+package conformance
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"sort"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+)
+
+// The app served by Server.
+const (
+	// AppID is the only app Server knows. Requests for any other app are
+	// rejected with UNKNOWN_APP.
+	AppID = "conformance_app"
+
+	// CurrentVersion is the latest version of AppID in its data.json.
+	CurrentVersion = "1.2.0"
+
+	// KilledVersion is a version of AppID whose metrics are rejected with
+	// VERSION_DISABLED.
+	KilledVersion = "1.0.0"
+)
+
+// AllowedMetrics are the metrics AppID may send.
+var AllowedMetrics = []string{"builds", "runs"}
+
+// Manifest returns the manifest.json served by Server.
+func Manifest() *api.ManifestResponse {
+	return &api.ManifestResponse{MetricsApps: []string{AppID}}
+}
+
+// AppData returns the data.json for AppID served by Server.
+func AppData() *api.AppResponse {
+	return &api.AppResponse{
+		AppID:          AppID,
+		AppName:        "Conformance App",
+		AppRepoURL:     "https://github.com/abcxyz/abc-updater",
+		CurrentVersion: CurrentVersion,
+		KillSwitches: []*api.KillSwitch{
+			{Versions: "= " + KilledVersion, Message: "Telemetry from this version is broken."},
+		},
+	}
+}
+
+// Metrics returns the metrics.json for AppID served by Server.
+func Metrics() *api.AllowedMetricsResponse {
+	return &api.AllowedMetricsResponse{Metrics: AllowedMetrics}
+}
+
+// Fixture is a golden exchange with Server. SDKs can replay the request and
+// compare their decoding of the response, or check that they encode the same
+// request. The fixtures are also in the fixtures directory of this package,
+// one JSON file each, for SDKs which do not run Go.
+type Fixture struct {
+	// Name identifies the fixture, and is its file name without ".json".
+	Name        string `json:"name"`
+	Description string `json:"description"`
+
+	Request  *FixtureRequest  `json:"request"`
+	Response *FixtureResponse `json:"response"`
+}
+
+// FixtureRequest is the request of a Fixture.
+type FixtureRequest struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+// FixtureResponse is the expected response of a Fixture. JSON bodies are
+// compared by value, ignoring formatting.
+type FixtureResponse struct {
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+//go:embed fixtures/*.json
+var fixturesFS embed.FS
+
+// Fixtures returns the golden fixtures, sorted by name.
+func Fixtures() ([]*Fixture, error) {
+	names, err := fs.Glob(fixturesFS, "fixtures/*.json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list fixtures: %w", err)
+	}
+	sort.Strings(names)
+
+	fixtures := make([]*Fixture, 0, len(names))
+	for _, name := range names {
+		b, err := fs.ReadFile(fixturesFS, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read fixture %q: %w", name, err)
+		}
+		var f Fixture
+		if err := json.Unmarshal(b, &f); err != nil {
+			return nil, fmt.Errorf("failed to parse fixture %q: %w", name, err)
+		}
+		fixtures = append(fixtures, &f)
+	}
+	return fixtures, nil
+}
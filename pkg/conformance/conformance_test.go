@@ -0,0 +1,219 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/sethvargo/go-envconfig"
+
+	"github.com/abcxyz/abc-updater/pkg/metrics"
+	"github.com/abcxyz/abc-updater/pkg/updater"
+)
+
+func TestFixtures(t *testing.T) {
+	t.Parallel()
+
+	s, err := NewServer(context.Background())
+	if err != nil {
+		t.Fatalf("failed to create server: %s", err.Error())
+	}
+	fixtures, err := Fixtures()
+	if err != nil {
+		t.Fatalf("failed to load fixtures: %s", err.Error())
+	}
+	if len(fixtures) == 0 {
+		t.Fatalf("expected fixtures")
+	}
+
+	for _, f := range fixtures {
+		t.Run(f.Name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(f.Request.Method, f.Request.Path, bytes.NewReader(f.Request.Body))
+			for k, v := range f.Request.Headers {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, req)
+
+			if got, want := w.Code, f.Response.Status; got != want {
+				t.Errorf("unexpected status. got %d want %d: %s", got, want, w.Body.String())
+			}
+			var got, want any
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatalf("failed to decode response %q: %s", w.Body.String(), err.Error())
+			}
+			if err := json.Unmarshal(f.Response.Body, &want); err != nil {
+				t.Fatalf("failed to decode fixture response: %s", err.Error())
+			}
+			if diff := cmp.Diff(got, want); diff != "" {
+				t.Errorf("unexpected response body (-got,+want): %s", diff)
+			}
+		})
+	}
+}
+
+func TestServer_Report(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name           string
+		headers        map[string]string
+		body           string
+		wantViolations []string
+	}{
+		{
+			name: "conforming",
+			headers: map[string]string{
+				"Content-Type":        "application/json",
+				"User-Agent":          "sdk",
+				"Abc-Updater-Version": "1.0.0",
+			},
+			body: `{"appId":"conformance_app","appVersion":"1.2.0","metrics":{"runs":1},"installId":"i"}`,
+		},
+		{
+			name: "missing_headers_and_fields",
+			headers: map[string]string{
+				"Content-Type": "application/json",
+			},
+			body: `{"appId":"conformance_app","appVersion":"latest","metrics":{}}`,
+			wantViolations: []string{
+				"request 1: missing User-Agent header",
+				"request 1: missing Abc-Updater-Version header",
+				`request 1: appVersion "latest" is not a version`,
+				"request 1: missing installId",
+				"request 1: no metrics",
+			},
+		},
+		{
+			name: "unknown_field",
+			headers: map[string]string{
+				"Content-Type":        "application/json",
+				"User-Agent":          "sdk",
+				"Abc-Updater-Version": "1.0.0",
+			},
+			body: `{"appId":"conformance_app","appVersion":"1.2.0","metrics":{"runs":1},"installId":"i","install_id":"i"}`,
+			wantViolations: []string{
+				`request 1: body does not match the SendMetricRequest schema: json: unknown field "install_id"`,
+			},
+		},
+		{
+			name: "wrong_content_type",
+			headers: map[string]string{
+				"Content-Type":        "text/plain",
+				"User-Agent":          "sdk",
+				"Abc-Updater-Version": "1.0.0",
+			},
+			body: `{}`,
+			wantViolations: []string{
+				`request 1: Content-Type "text/plain" is not application/json or application/x-protobuf`,
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			s, err := NewServer(context.Background())
+			if err != nil {
+				t.Fatalf("failed to create server: %s", err.Error())
+			}
+			req := httptest.NewRequest(http.MethodPost, "/sendMetrics", bytes.NewBufferString(tc.body))
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			s.ServeHTTP(httptest.NewRecorder(), req)
+
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/conformance/report", nil))
+			var report Report
+			if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+				t.Fatalf("failed to decode report: %s", err.Error())
+			}
+			if diff := cmp.Diff(report.Violations, tc.wantViolations, cmpEmpty); diff != "" {
+				t.Errorf("unexpected violations (-got,+want): %s", diff)
+			}
+
+			s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/conformance/reset", nil))
+			if got := s.Report(); len(got.Requests) != 0 || len(got.Violations) != 0 {
+				t.Errorf("expected empty report after reset, got %+v", got)
+			}
+		})
+	}
+}
+
+// TestGoClients checks that this module's own clients conform.
+func TestGoClients(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	s, err := NewServer(ctx)
+	if err != nil {
+		t.Fatalf("failed to create server: %s", err.Error())
+	}
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+
+	got, err := updater.CheckAppVersionSync(ctx, &updater.CheckVersionParams{
+		AppID:                  AppID,
+		Version:                "1.1.0",
+		Lookuper:               envconfig.MapLookuper(map[string]string{"UPDATER_URL": ts.URL}),
+		CacheFileOverride:      filepath.Join(t.TempDir(), "data.json"),
+		AllowInsecureLocalhost: true,
+	})
+	if err != nil {
+		t.Fatalf("unexpected version check error: %s", err.Error())
+	}
+	if want := "Conformance App version 1.2.0 is available"; !strings.Contains(got, want) {
+		t.Errorf("expected version check output %q to contain %q", got, want)
+	}
+
+	for _, opt := range []metrics.Option{metrics.WithMetricDispositions(), metrics.WithProtobufEncoding()} {
+		mw, err := metrics.New(ctx, AppID, CurrentVersion,
+			metrics.WithLookuper(envconfig.MapLookuper(map[string]string{"METRICS_URL": ts.URL})),
+			metrics.WithInstallIDFileOverride(filepath.Join(t.TempDir(), "id.json")),
+			metrics.WithAllowInsecureLocalhost(),
+			opt)
+		if err != nil {
+			t.Fatalf("failed to create metrics client: %s", err.Error())
+		}
+		if err := mw.WriteMetric(ctx, "runs", 1); err != nil {
+			t.Errorf("unexpected error writing metric: %s", err.Error())
+		}
+	}
+
+	report := s.Report()
+	if got, want := len(report.Requests), 2; got != want {
+		t.Errorf("unexpected number of metrics requests. got %d want %d", got, want)
+	}
+	if len(report.Violations) > 0 {
+		t.Errorf("unexpected violations: %q", report.Violations)
+	}
+}
+
+// cmpEmpty treats nil and empty slices as equal.
+var cmpEmpty = cmp.FilterValues(func(x, y []string) bool {
+	return len(x) == 0 && len(y) == 0
+}, cmp.Ignore())
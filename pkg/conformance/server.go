@@ -0,0 +1,262 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conformance
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/go-version"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+	"github.com/abcxyz/abc-updater/pkg/api/apipb"
+	"github.com/abcxyz/abc-updater/pkg/apierror"
+	"github.com/abcxyz/abc-updater/pkg/server"
+)
+
+// maxRequestBytes limits the metrics request bodies Server reads.
+const maxRequestBytes = 1 << 20
+
+// Server is an http.Handler for an SDK's test suite. It serves the metadata
+// for AppID, as UPDATER_URL does, and the metrics server, using the same
+// handlers as the real server. Each metrics request is also checked against
+// the wire contract, beyond what the real server enforces, and the results
+// are kept in a Report.
+//
+// Its routes are:
+//
+//	GET  /manifest.json, /<app>/data.json, /<app>/metrics.json
+//	POST /sendMetrics
+//	GET  /conformance/report   the Report so far
+//	POST /conformance/reset    clears the Report
+//	GET  /conformance/fixtures the Fixtures
+type Server struct {
+	mux *http.ServeMux
+
+	mu     sync.Mutex
+	report Report
+}
+
+// Report is what Server observed of a client's metrics requests.
+type Report struct {
+	// Requests are the metrics requests received, as decoded.
+	Requests []*api.SendMetricRequest `json:"requests"`
+
+	// Violations describe how the requests broke the contract. A conforming
+	// client has none.
+	Violations []string `json:"violations"`
+}
+
+// NewServer returns a Server for AppID.
+func NewServer(ctx context.Context) (*Server, error) {
+	h := &server.JSONResponder{}
+	metadata := metadataHandler(h)
+
+	// Load the metrics server's definitions from the metadata, in process.
+	db := &server.MetricsDB{}
+	if err := db.Update(ctx, &server.MetricsLoadParams{
+		ServerURL: "http://conformance",
+		Client:    &http.Client{Transport: handlerTransport{metadata}},
+	}); err != nil {
+		return nil, fmt.Errorf("failed to load metrics definitions: %w", err)
+	}
+
+	fixtures, err := Fixtures()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{mux: http.NewServeMux()}
+	s.mux.Handle("GET /", metadata)
+	s.mux.Handle("POST /sendMetrics", s.check(server.HandleMetricWithSink(h, db, discardSink{})))
+	s.mux.Handle("GET /conformance/report", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.RenderJSON(w, http.StatusOK, s.Report())
+	}))
+	s.mux.Handle("POST /conformance/reset", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.Reset()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	s.mux.Handle("GET /conformance/fixtures", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.RenderJSON(w, http.StatusOK, fixtures)
+	}))
+	return s, nil
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Report returns a copy of the report so far.
+func (s *Server) Report() *Report {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &Report{
+		Requests:   append([]*api.SendMetricRequest{}, s.report.Requests...),
+		Violations: append([]string{}, s.report.Violations...),
+	}
+}
+
+// Reset clears the report, e.g. between test cases.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.report = Report{}
+}
+
+// check returns next, checking each request against the contract first.
+func (s *Server) check(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(io.LimitReader(r.Body, maxRequestBytes))
+		r.Body.Close()
+		if err != nil {
+			h := &server.JSONResponder{}
+			h.RenderJSON(w, http.StatusBadRequest, apierror.New(apierror.CodeMalformedRequest, "failed to read request body: %s", err))
+			return
+		}
+		req, violations := checkRequest(r.Header, b)
+
+		s.mu.Lock()
+		n := len(s.report.Requests) + 1
+		if req != nil {
+			s.report.Requests = append(s.report.Requests, req)
+		}
+		for _, v := range violations {
+			s.report.Violations = append(s.report.Violations, fmt.Sprintf("request %d: %s", n, v))
+		}
+		s.mu.Unlock()
+
+		r.Body = io.NopCloser(bytes.NewReader(b))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkRequest decodes the metrics request with headers hdr and body b, and
+// returns how it breaks the contract. The request is nil if it could not be
+// decoded.
+func checkRequest(hdr http.Header, b []byte) (*api.SendMetricRequest, []string) {
+	var violations []string
+	if hdr.Get("User-Agent") == "" {
+		violations = append(violations, "missing User-Agent header")
+	}
+	// Development builds send a version such as "devel", which the server
+	// treats as current.
+	if hdr.Get(api.HeaderClientVersion) == "" {
+		violations = append(violations, fmt.Sprintf("missing %s header", api.HeaderClientVersion))
+	}
+
+	switch enc := hdr.Get("Content-Encoding"); enc {
+	case "", "identity":
+	case "gzip":
+		zr, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, append(violations, "body is not valid gzip")
+		}
+		if b, err = io.ReadAll(zr); err != nil {
+			return nil, append(violations, "body is not valid gzip")
+		}
+	default:
+		return nil, append(violations, fmt.Sprintf("unsupported Content-Encoding %q", enc))
+	}
+
+	var req api.SendMetricRequest
+	switch contentType := hdr.Get("Content-Type"); {
+	case strings.HasPrefix(contentType, "application/json"):
+		d := json.NewDecoder(bytes.NewReader(b))
+		// Unknown fields are tolerated by the server, but are likely typos.
+		d.DisallowUnknownFields()
+		if err := d.Decode(&req); err != nil {
+			return nil, append(violations, fmt.Sprintf("body does not match the SendMetricRequest schema: %s", err))
+		}
+	case strings.HasPrefix(contentType, apipb.ContentType):
+		var pb apipb.SendMetricRequest
+		if err := proto.Unmarshal(b, &pb); err != nil {
+			return nil, append(violations, "body is not a SendMetricRequest protobuf")
+		}
+		req = *pb.ToAPI()
+	default:
+		return nil, append(violations, fmt.Sprintf("Content-Type %q is not application/json or %s", contentType, apipb.ContentType))
+	}
+
+	if req.AppID == "" {
+		violations = append(violations, "missing appId")
+	}
+	if req.AppVersion == "" {
+		violations = append(violations, "missing appVersion")
+	} else if _, err := version.NewVersion(req.AppVersion); err != nil {
+		violations = append(violations, fmt.Sprintf("appVersion %q is not a version", req.AppVersion))
+	}
+	if req.InstallID == "" {
+		violations = append(violations, "missing installId")
+	}
+	if len(req.Metrics) == 0 {
+		violations = append(violations, "no metrics")
+	}
+	return &req, violations
+}
+
+// metadataHandler returns a handler serving the metadata for AppID, laid out
+// as UPDATER_URL and the metrics server's metadata source expect.
+func metadataHandler(h server.JSONRenderer) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("GET /manifest.json", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.RenderJSON(w, http.StatusOK, Manifest())
+	}))
+	mux.Handle("GET /{app}/data.json", appHandler(h, func() any { return AppData() }))
+	mux.Handle("GET /{app}/metrics.json", appHandler(h, func() any { return Metrics() }))
+	return mux
+}
+
+// appHandler returns a handler serving file() for AppID, and UNKNOWN_APP for
+// other apps.
+func appHandler(h server.JSONRenderer, file func() any) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app := r.PathValue("app"); app != AppID {
+			h.RenderJSON(w, http.StatusNotFound, apierror.New(apierror.CodeUnknownApp, "unknown app %q", app))
+			return
+		}
+		h.RenderJSON(w, http.StatusOK, file())
+	})
+}
+
+// handlerTransport is an http.RoundTripper which serves requests with a
+// handler, in process.
+type handlerTransport struct {
+	h http.Handler
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t handlerTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	w := httptest.NewRecorder()
+	t.h.ServeHTTP(w, r)
+	return w.Result(), nil
+}
+
+// discardSink is a server.MetricSink which drops metrics.
+type discardSink struct{}
+
+// WriteMetric implements server.MetricSink.
+func (discardSink) WriteMetric(context.Context, *server.MetricRecord) error {
+	return nil
+}
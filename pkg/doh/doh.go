@@ -0,0 +1,248 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package doh resolves host names with DNS over HTTPS (RFC 8484), for
+// networks where DNS for abc-updater servers is blocked but HTTPS egress is
+// allowed. It is opt-in; clients use the system resolver by default.
+package doh
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// Timeout bounds each request to the DNS over HTTPS server.
+	Timeout = 3 * time.Second
+
+	// contentType is the media type of DNS messages, as sent and received.
+	contentType = "application/dns-message"
+
+	// maxResponseBytes limits the size of DNS responses read.
+	maxResponseBytes = 64 << 10
+
+	// maxCacheTTL bounds how long answers are cached, whatever their TTL.
+	maxCacheTTL = 10 * time.Minute
+)
+
+// Resolver looks up host addresses with a DNS over HTTPS server. It satisfies
+// reachability.Resolver. Answers are cached for their TTL. The zero value is
+// not usable; use New.
+type Resolver struct {
+	serverURL *url.URL
+	client    *http.Client
+	now       func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]*cacheEntry
+}
+
+// cacheEntry is a cached answer.
+type cacheEntry struct {
+	addrs   []net.IPAddr
+	expires time.Time
+}
+
+// New returns a Resolver which queries the DNS over HTTPS server at
+// serverURL, e.g. "https://1.1.1.1/dns-query". The server's own host name is
+// resolved with the system resolver, so a URL with an IP address avoids
+// depending on DNS at all.
+func New(serverURL string) (*Resolver, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse DNS over HTTPS URL: %w", err)
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("DNS over HTTPS URL %q must be an https URL", serverURL)
+	}
+	return &Resolver{
+		serverURL: u,
+		client:    &http.Client{Timeout: Timeout},
+		now:       time.Now,
+		cache:     make(map[string]*cacheEntry),
+	}, nil
+}
+
+// LookupIPAddr looks up the IPv4 and IPv6 addresses of host, with the same
+// semantics as net.Resolver.LookupIPAddr.
+func (r *Resolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if addrs, ok := r.cached(host); ok {
+		return addrs, nil
+	}
+
+	type result struct {
+		addrs []net.IPAddr
+		ttl   time.Duration
+		err   error
+	}
+	types := []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA}
+	results := make([]result, len(types))
+	var wg sync.WaitGroup
+	for i, t := range types {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			addrs, ttl, err := r.query(ctx, host, t)
+			results[i] = result{addrs: addrs, ttl: ttl, err: err}
+		}()
+	}
+	wg.Wait()
+
+	var addrs []net.IPAddr
+	var merr error
+	ttl := maxCacheTTL
+	for _, res := range results {
+		if res.err != nil {
+			merr = errors.Join(merr, res.err)
+			continue
+		}
+		addrs = append(addrs, res.addrs...)
+		if len(res.addrs) > 0 {
+			ttl = min(ttl, res.ttl)
+		}
+	}
+	if len(addrs) == 0 {
+		if merr == nil {
+			merr = fmt.Errorf("no addresses for %s", host)
+		}
+		return nil, merr
+	}
+
+	r.mu.Lock()
+	r.cache[host] = &cacheEntry{addrs: addrs, expires: r.now().Add(ttl)}
+	r.mu.Unlock()
+	return addrs, nil
+}
+
+// cached returns the cached addresses of host, if they have not expired.
+func (r *Resolver) cached(host string) ([]net.IPAddr, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, ok := r.cache[host]
+	if !ok || !r.now().Before(e.expires) {
+		return nil, false
+	}
+	return e.addrs, true
+}
+
+// query looks up the records of type t for host, returning their addresses
+// and the shortest TTL among them.
+func (r *Resolver) query(ctx context.Context, host string, t dnsmessage.Type) ([]net.IPAddr, time.Duration, error) {
+	name, err := dnsmessage.NewName(host + ".")
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid host name %q: %w", host, err)
+	}
+	// The ID is zero, as RFC 8484 recommends, so responses can be cached by
+	// HTTP caches.
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: name, Type: t, Class: dnsmessage.ClassINET}},
+	}
+	packed, err := msg.Pack()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to pack DNS query: %w", err)
+	}
+
+	u := *r.serverURL
+	q := u.Query()
+	q.Set("dns", base64.RawURLEncoding.EncodeToString(packed))
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create DNS over HTTPS request: %w", err)
+	}
+	req.Header.Set("Accept", contentType)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to make DNS over HTTPS request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("DNS over HTTPS server returned %s", resp.Status)
+	}
+	if got := resp.Header.Get("Content-Type"); got != contentType {
+		return nil, 0, fmt.Errorf("DNS over HTTPS server returned Content-Type %q, want %q", got, contentType)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read DNS over HTTPS response: %w", err)
+	}
+	return parseAnswers(b)
+}
+
+// parseAnswers returns the addresses in the DNS response b, and the shortest
+// TTL among them. Other records, such as the CNAMEs leading to the
+// addresses, are skipped.
+func parseAnswers(b []byte) ([]net.IPAddr, time.Duration, error) {
+	var p dnsmessage.Parser
+	hdr, err := p.Start(b)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to parse DNS response: %w", err)
+	}
+	if hdr.RCode != dnsmessage.RCodeSuccess {
+		return nil, 0, fmt.Errorf("DNS lookup failed: %s", hdr.RCode)
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return nil, 0, fmt.Errorf("failed to parse DNS response: %w", err)
+	}
+
+	var addrs []net.IPAddr
+	var ttl uint32
+	for {
+		h, err := p.AnswerHeader()
+		if errors.Is(err, dnsmessage.ErrSectionDone) {
+			break
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to parse DNS response: %w", err)
+		}
+		var ip net.IP
+		switch h.Type {
+		case dnsmessage.TypeA:
+			a, err := p.AResource()
+			if err != nil {
+				return nil, 0, fmt.Errorf("failed to parse DNS response: %w", err)
+			}
+			ip = net.IP(a.A[:])
+		case dnsmessage.TypeAAAA:
+			aaaa, err := p.AAAAResource()
+			if err != nil {
+				return nil, 0, fmt.Errorf("failed to parse DNS response: %w", err)
+			}
+			ip = net.IP(aaaa.AAAA[:])
+		default:
+			if err := p.SkipAnswer(); err != nil {
+				return nil, 0, fmt.Errorf("failed to parse DNS response: %w", err)
+			}
+			continue
+		}
+		if len(addrs) == 0 || h.TTL < ttl {
+			ttl = h.TTL
+		}
+		addrs = append(addrs, net.IPAddr{IP: ip})
+	}
+	return addrs, time.Duration(ttl) * time.Second, nil
+}
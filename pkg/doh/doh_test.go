@@ -0,0 +1,212 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package doh
+
+import (
+	"context"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/abcxyz/pkg/testutil"
+)
+
+// testServer answers DNS over HTTPS queries: "updater.example.test" is a
+// CNAME for "cdn.example.test", which has one IPv4 and one IPv6 address, and
+// other names do not exist.
+func testServer(t *testing.T, queries *atomic.Int64) *httptest.Server {
+	t.Helper()
+
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries.Add(1)
+		b, err := base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var q dnsmessage.Message
+		if err := q.Unpack(b); err != nil || len(q.Questions) != 1 {
+			http.Error(w, "bad query", http.StatusBadRequest)
+			return
+		}
+		question := q.Questions[0]
+
+		resp := dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: q.ID, Response: true, RecursionAvailable: true},
+			Questions: q.Questions,
+		}
+		if question.Name.String() != "updater.example.test." {
+			resp.Header.RCode = dnsmessage.RCodeNameError
+		} else {
+			cdn := dnsmessage.MustNewName("cdn.example.test.")
+			resp.Answers = append(resp.Answers, dnsmessage.Resource{
+				Header: dnsmessage.ResourceHeader{Name: question.Name, Type: dnsmessage.TypeCNAME, Class: dnsmessage.ClassINET, TTL: 30},
+				Body:   &dnsmessage.CNAMEResource{CNAME: cdn},
+			})
+			hdr := dnsmessage.ResourceHeader{Name: cdn, Type: question.Type, Class: dnsmessage.ClassINET, TTL: 60}
+			switch question.Type {
+			case dnsmessage.TypeA:
+				resp.Answers = append(resp.Answers, dnsmessage.Resource{Header: hdr, Body: &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}}})
+			case dnsmessage.TypeAAAA:
+				hdr.TTL = 120
+				var ip [16]byte
+				copy(ip[:], net.ParseIP("2001:db8::1"))
+				resp.Answers = append(resp.Answers, dnsmessage.Resource{Header: hdr, Body: &dnsmessage.AAAAResource{AAAA: ip}})
+			}
+		}
+		out, err := resp.Pack()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Write(out) //nolint:errcheck // Nothing to do if the client went away.
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// newTestResolver returns a Resolver for ts, at the time returned by now.
+func newTestResolver(t *testing.T, ts *httptest.Server, now func() time.Time) *Resolver {
+	t.Helper()
+
+	r, err := New(ts.URL + "/dns-query")
+	if err != nil {
+		t.Fatalf("failed to create resolver: %s", err.Error())
+	}
+	r.client = ts.Client()
+	r.now = now
+	return r
+}
+
+func TestResolver_LookupIPAddr(t *testing.T) {
+	t.Parallel()
+
+	var queries atomic.Int64
+	ts := testServer(t, &queries)
+	now := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+	r := newTestResolver(t, ts, func() time.Time { return now })
+
+	got, err := r.LookupIPAddr(context.Background(), "Updater.Example.Test.")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	want := []net.IPAddr{{IP: net.ParseIP("192.0.2.1")}, {IP: net.ParseIP("2001:db8::1")}}
+	if diff := cmp.Diff(got, want, cmp.Comparer(func(a, b net.IP) bool { return a.Equal(b) })); diff != "" {
+		t.Errorf("unexpected addresses (-got,+want): %s", diff)
+	}
+
+	// Cached for the shortest TTL of the addresses, not of the CNAME.
+	now = now.Add(59 * time.Second)
+	if _, err := r.LookupIPAddr(context.Background(), "updater.example.test"); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if got, want := queries.Load(), int64(2); got != want {
+		t.Errorf("unexpected queries while cached. got %d want %d", got, want)
+	}
+	now = now.Add(time.Second)
+	if _, err := r.LookupIPAddr(context.Background(), "updater.example.test"); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if got, want := queries.Load(), int64(4); got != want {
+		t.Errorf("unexpected queries after expiry. got %d want %d", got, want)
+	}
+}
+
+func TestResolver_LookupIPAddr_Errors(t *testing.T) {
+	t.Parallel()
+
+	var queries atomic.Int64
+	ts := testServer(t, &queries)
+	wrongType := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+	}))
+	t.Cleanup(wrongType.Close)
+
+	cases := []struct {
+		name    string
+		server  *httptest.Server
+		host    string
+		wantErr string
+	}{
+		{
+			name:    "nxdomain",
+			server:  ts,
+			host:    "missing.example.test",
+			wantErr: "DNS lookup failed: RCodeNameError",
+		},
+		{
+			name:    "content_type",
+			server:  wrongType,
+			host:    "updater.example.test",
+			wantErr: `DNS over HTTPS server returned Content-Type "text/html"`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := newTestResolver(t, tc.server, time.Now)
+			_, err := r.LookupIPAddr(context.Background(), tc.host)
+			if diff := testutil.DiffErrString(err, tc.wantErr); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		url     string
+		wantErr string
+	}{
+		{
+			name: "https",
+			url:  "https://1.1.1.1/dns-query",
+		},
+		{
+			name:    "http",
+			url:     "http://1.1.1.1/dns-query",
+			wantErr: "must be an https URL",
+		},
+		{
+			name:    "invalid",
+			url:     "https://%zz",
+			wantErr: "failed to parse DNS over HTTPS URL",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := New(tc.url)
+			if diff := testutil.DiffErrString(err, tc.wantErr); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
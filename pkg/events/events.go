@@ -0,0 +1,226 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package events records metrics from typed event structs rather than metric
+// name strings, so the names an app sends cannot drift from the allowlist the
+// server enforces.
+//
+// An event is a struct whose fields tagged with `event:"<label>"` are its
+// labels. Labels must be strings or bools. String labels may list their
+// allowed values with `values:"a,b,c"`; otherwise any single metric name
+// segment is accepted. The event's name is its type name in snake_case, or
+// the result of an EventName method:
+//
+//	type CommandRun struct {
+//		Command string `event:"command" values:"init,render,upgrade"`
+//		Success bool   `event:"success"`
+//	}
+//
+//	commandRun, err := events.New[CommandRun]()
+//	if err != nil {
+//		return err
+//	}
+//	err = commandRun.Record(ctx, mw, CommandRun{Command: "init", Success: true})
+//
+// records the metric "command_run.init.true". Event types and label values
+// are checked at run time, by New and Record, not by the compiler. See Schema
+// for checking events against the app's checked-in schema, from a test, and
+// generating its metrics.json.
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/abcxyz/abc-updater/pkg/metrics"
+)
+
+// maxEventMetrics is the most allowlist entries a single event may expand to.
+// Events with more should leave some labels open rather than enumerate them.
+const maxEventMetrics = 1000
+
+// anyValue is the allowlist wildcard for labels without listed values.
+const anyValue = "*"
+
+// ErrInvalidLabel is returned by Type.Metric and Type.Record for an event
+// whose label value is not allowed.
+var ErrInvalidLabel = errors.New("invalid label value")
+
+// segmentPattern is what a name or label value may be: a single metric name
+// segment, as matched by wildcards on the server.
+var segmentPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// boolValues are the values of bool labels.
+var boolValues = []string{"false", "true"}
+
+// Namer is implemented by events which are not named for their type.
+type Namer interface {
+	EventName() string
+}
+
+// Type is a declared event type, which converts events of type T to metric
+// names.
+type Type[T any] struct {
+	name   string
+	labels []*label
+}
+
+// label is a tagged field of an event struct.
+type label struct {
+	name   string
+	index  int
+	kind   reflect.Kind
+	values []string
+}
+
+// New declares the event type T, returning an error if T is not a struct, has
+// labels which are not strings or bools, or has invalid names or values.
+// Declare each event type once, e.g. at startup.
+func New[T any]() (*Type[T], error) {
+	var zero T
+	rt := reflect.TypeOf(zero)
+	if rt == nil || rt.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("event type %T is not a struct", zero)
+	}
+
+	name := snakeCase(rt.Name())
+	if n, ok := any(zero).(Namer); ok {
+		name = n.EventName()
+	}
+	if name == "" || strings.ContainsAny(name, "*{}") {
+		return nil, fmt.Errorf("event type %s has invalid name %q", rt, name)
+	}
+
+	t := &Type[T]{name: name}
+	seen := make(map[string]struct{}, rt.NumField())
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		tag, ok := f.Tag.Lookup("event")
+		if !ok {
+			continue
+		}
+		if !f.IsExported() {
+			return nil, fmt.Errorf("event %q: label field %s is not exported", name, f.Name)
+		}
+		if !segmentPattern.MatchString(tag) {
+			return nil, fmt.Errorf("event %q: field %s has invalid label name %q", name, f.Name, tag)
+		}
+		if _, ok := seen[tag]; ok {
+			return nil, fmt.Errorf("event %q: duplicate label %q", name, tag)
+		}
+		seen[tag] = struct{}{}
+
+		l := &label{name: tag, index: i, kind: f.Type.Kind()}
+		switch l.kind {
+		case reflect.Bool:
+			l.values = boolValues
+		case reflect.String:
+			if v := f.Tag.Get("values"); v != "" {
+				l.values = strings.Split(v, ",")
+				for _, value := range l.values {
+					if !segmentPattern.MatchString(value) {
+						return nil, fmt.Errorf("event %q: label %q has invalid value %q", name, tag, value)
+					}
+				}
+			}
+		default:
+			return nil, fmt.Errorf("event %q: label %q is a %s, not a string or bool", name, tag, f.Type)
+		}
+		t.labels = append(t.labels, l)
+	}
+
+	if n := t.schemaEvent().metricCount(); n > maxEventMetrics {
+		return nil, fmt.Errorf("event %q expands to %d metrics, more than the maximum of %d", name, n, maxEventMetrics)
+	}
+	return t, nil
+}
+
+// Name returns the name of the event, which prefixes its metric names.
+func (t *Type[T]) Name() string {
+	return t.name
+}
+
+// Metric returns the metric name recording e: the event's name followed by
+// each label's value, in field order, separated by ".". It returns an error
+// wrapping ErrInvalidLabel if a value is not allowed.
+func (t *Type[T]) Metric(e T) (string, error) {
+	v := reflect.ValueOf(e)
+	parts := make([]string, 0, len(t.labels)+1)
+	parts = append(parts, t.name)
+	for _, l := range t.labels {
+		var value string
+		if l.kind == reflect.Bool {
+			value = strconv.FormatBool(v.Field(l.index).Bool())
+		} else {
+			value = v.Field(l.index).String()
+		}
+		if !segmentPattern.MatchString(value) || (l.values != nil && !slices.Contains(l.values, value)) {
+			return "", fmt.Errorf("event %q: label %q: %w %q", t.name, l.name, ErrInvalidLabel, value)
+		}
+		parts = append(parts, value)
+	}
+	return strings.Join(parts, "."), nil
+}
+
+// Record writes a count of 1 for the metric recording e to mw.
+func (t *Type[T]) Record(ctx context.Context, mw metrics.MetricWriter, e T) error {
+	name, err := t.Metric(e)
+	if err != nil {
+		return err
+	}
+	if err := mw.WriteMetric(ctx, name, 1); err != nil {
+		return fmt.Errorf("failed to record event %q: %w", t.name, err)
+	}
+	return nil
+}
+
+// Metrics returns the allowlist entries for every metric the event may
+// record, with "*" for labels without listed values, sorted.
+func (t *Type[T]) Metrics() []string {
+	return t.schemaEvent().metrics()
+}
+
+// schemaEvent returns the schema entry for the event.
+func (t *Type[T]) schemaEvent() *SchemaEvent {
+	e := &SchemaEvent{Name: t.name}
+	for _, l := range t.labels {
+		e.Labels = append(e.Labels, &SchemaLabel{Name: l.name, Values: slices.Clone(l.values)})
+	}
+	return e
+}
+
+// snakeCase converts a Go identifier such as "HTTPRequestSent" to
+// "http_request_sent".
+func snakeCase(s string) string {
+	runes := []rune(s)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
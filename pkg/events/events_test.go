@@ -0,0 +1,210 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/abcxyz/abc-updater/pkg/metrics"
+	"github.com/abcxyz/pkg/testutil"
+)
+
+type CommandRun struct {
+	Command string `event:"command" values:"init,render"`
+	Success bool   `event:"success"`
+	Note    string
+}
+
+type HTTPRequestSent struct {
+	Host string `event:"host"`
+}
+
+type renamed struct{}
+
+func (renamed) EventName() string { return "custom.name" }
+
+type badKind struct {
+	Count int `event:"count"`
+}
+
+type badValue struct {
+	Command string `event:"command" values:"init,a.b"`
+}
+
+type duplicateLabel struct {
+	A string `event:"x"`
+	B string `event:"x"`
+}
+
+type tooMany struct {
+	A string `event:"a" values:"0,1,2,3,4,5,6,7,8,9"`
+	B string `event:"b" values:"0,1,2,3,4,5,6,7,8,9"`
+	C string `event:"c" values:"0,1,2,3,4,5,6,7,8,9"`
+	D bool   `event:"d"`
+}
+
+// mustNew declares the event type T, failing the test on error.
+func mustNew[T any](tb testing.TB) *Type[T] {
+	tb.Helper()
+
+	et, err := New[T]()
+	if err != nil {
+		tb.Fatalf("failed to declare event type: %s", err.Error())
+	}
+	return et
+}
+
+var _ metrics.MetricWriter = (*recordingWriter)(nil)
+
+// recordingWriter is a MetricWriter which records metric names written.
+type recordingWriter struct {
+	names []string
+}
+
+func (w *recordingWriter) WriteMetric(ctx context.Context, name string, count int64) error {
+	w.names = append(w.names, name)
+	return nil
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name        string
+		new         func() (Declared, error)
+		wantName    string
+		wantMetrics []string
+		wantErr     string
+	}{
+		{
+			name:     "enumerated",
+			new:      func() (Declared, error) { return New[CommandRun]() },
+			wantName: "command_run",
+			wantMetrics: []string{
+				"command_run.init.false",
+				"command_run.init.true",
+				"command_run.render.false",
+				"command_run.render.true",
+			},
+		},
+		{
+			name:        "open",
+			new:         func() (Declared, error) { return New[HTTPRequestSent]() },
+			wantName:    "http_request_sent",
+			wantMetrics: []string{"http_request_sent.*"},
+		},
+		{
+			name:        "event_name",
+			new:         func() (Declared, error) { return New[renamed]() },
+			wantName:    "custom.name",
+			wantMetrics: []string{"custom.name"},
+		},
+		{
+			name:    "not_struct",
+			new:     func() (Declared, error) { return New[string]() },
+			wantErr: "event type string is not a struct",
+		},
+		{
+			name:    "bad_kind",
+			new:     func() (Declared, error) { return New[badKind]() },
+			wantErr: `event "bad_kind": label "count" is a int, not a string or bool`,
+		},
+		{
+			name:    "bad_value",
+			new:     func() (Declared, error) { return New[badValue]() },
+			wantErr: `event "bad_value": label "command" has invalid value "a.b"`,
+		},
+		{
+			name:    "duplicate_label",
+			new:     func() (Declared, error) { return New[duplicateLabel]() },
+			wantErr: `event "duplicate_label": duplicate label "x"`,
+		},
+		{
+			name:    "too_many",
+			new:     func() (Declared, error) { return New[tooMany]() },
+			wantErr: `event "too_many" expands to 2000 metrics, more than the maximum of 1000`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			d, err := tc.new()
+			if diff := testutil.DiffErrString(err, tc.wantErr); diff != "" {
+				t.Fatal(diff)
+			}
+			if err != nil {
+				return
+			}
+			e := d.schemaEvent()
+			if got, want := e.Name, tc.wantName; got != want {
+				t.Errorf("unexpected name. got %q want %q", got, want)
+			}
+			if diff := cmp.Diff(e.metrics(), tc.wantMetrics); diff != "" {
+				t.Errorf("unexpected metrics (-got,+want): %s", diff)
+			}
+		})
+	}
+}
+
+func TestType_Record(t *testing.T) {
+	t.Parallel()
+
+	commandRun := mustNew[CommandRun](t)
+	httpRequest := mustNew[HTTPRequestSent](t)
+	w := &recordingWriter{}
+	ctx := context.Background()
+
+	if err := commandRun.Record(ctx, w, CommandRun{Command: "init", Success: true, Note: "ignored"}); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if err := httpRequest.Record(ctx, w, HTTPRequestSent{Host: "example-com"}); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	for _, e := range []HTTPRequestSent{{Host: ""}, {Host: "example.com"}} {
+		if err := httpRequest.Record(ctx, w, e); !errors.Is(err, ErrInvalidLabel) {
+			t.Errorf("Record(%+v) got error %v want %v", e, err, ErrInvalidLabel)
+		}
+	}
+	if err := commandRun.Record(ctx, w, CommandRun{Command: "upgrade"}); !errors.Is(err, ErrInvalidLabel) {
+		t.Errorf("got error %v want %v", err, ErrInvalidLabel)
+	}
+
+	want := []string{"command_run.init.true", "http_request_sent.example-com"}
+	if diff := cmp.Diff(w.names, want); diff != "" {
+		t.Errorf("unexpected metrics written (-got,+want): %s", diff)
+	}
+}
+
+func TestSnakeCase(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]string{
+		"CommandRun":      "command_run",
+		"HTTPRequestSent": "http_request_sent",
+		"Upload2GCS":      "upload2_gcs",
+		"init":            "init",
+	}
+	for in, want := range cases {
+		if got := snakeCase(in); got != want {
+			t.Errorf("snakeCase(%q) got %q want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// Schema is an app's events, checked in to its repo next to the code
+// declaring them. Tests call CheckSchema, so changing an event without
+// updating the schema fails the build, and cmd/metadata-gen generates the
+// app's metrics.json from it.
+type Schema struct {
+	Events []*SchemaEvent `json:"events"`
+}
+
+// SchemaEvent is an event in a Schema.
+type SchemaEvent struct {
+	Name   string         `json:"name"`
+	Labels []*SchemaLabel `json:"labels,omitempty"`
+}
+
+// SchemaLabel is a label of a SchemaEvent. Labels without Values accept any
+// single metric name segment.
+type SchemaLabel struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values,omitempty"`
+}
+
+// Declared is an event type declared with New. It is implemented by *Type.
+type Declared interface {
+	schemaEvent() *SchemaEvent
+}
+
+// NewSchema returns the schema of the given event types, sorted by name. It
+// returns an error if two types have the same name.
+func NewSchema(types ...Declared) (*Schema, error) {
+	s := &Schema{Events: make([]*SchemaEvent, 0, len(types))}
+	for _, t := range types {
+		s.Events = append(s.Events, t.schemaEvent())
+	}
+	slices.SortFunc(s.Events, func(a, b *SchemaEvent) int { return strings.Compare(a.Name, b.Name) })
+	for i := 1; i < len(s.Events); i++ {
+		if s.Events[i].Name == s.Events[i-1].Name {
+			return nil, fmt.Errorf("duplicate event %q", s.Events[i].Name)
+		}
+	}
+	return s, nil
+}
+
+// LoadSchema reads and validates the schema at path.
+func LoadSchema(path string) (*Schema, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read event schema: %w", err)
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	// Reject unknown fields so typos don't silently ship.
+	d.DisallowUnknownFields()
+	var s Schema
+	if err := d.Decode(&s); err != nil {
+		return nil, fmt.Errorf("failed to parse event schema %s: %w", path, err)
+	}
+	if err := s.validate(); err != nil {
+		return nil, fmt.Errorf("invalid event schema %s: %w", path, err)
+	}
+	return &s, nil
+}
+
+// WriteSchema writes the schema of the given event types to path, for
+// creating or updating the file checked by CheckSchema.
+func WriteSchema(path string, types ...Declared) error {
+	s, err := NewSchema(types...)
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode event schema: %w", err)
+	}
+	if err := os.WriteFile(path, append(b, '\n'), 0o644); err != nil { //nolint:gosec // The schema is checked in.
+		return fmt.Errorf("failed to write event schema: %w", err)
+	}
+	return nil
+}
+
+// CheckSchema returns an error describing every difference between the given
+// event types and the schema at path. Call it from a test, so events cannot
+// drift from the schema the server's allowlist is generated from:
+//
+//	func TestEventSchema(t *testing.T) {
+//		if err := events.CheckSchema("events.json", commandRun); err != nil {
+//			t.Fatal(err)
+//		}
+//	}
+func CheckSchema(path string, types ...Declared) error {
+	want, err := LoadSchema(path)
+	if err != nil {
+		return err
+	}
+	got, err := NewSchema(types...)
+	if err != nil {
+		return err
+	}
+
+	wantEvents := make(map[string]*SchemaEvent, len(want.Events))
+	for _, e := range want.Events {
+		wantEvents[e.Name] = e
+	}
+	var merr error
+	for _, e := range got.Events {
+		w, ok := wantEvents[e.Name]
+		delete(wantEvents, e.Name)
+		switch {
+		case !ok:
+			merr = errors.Join(merr, fmt.Errorf("event %q is not in the schema", e.Name))
+		case !reflect.DeepEqual(e, w):
+			merr = errors.Join(merr, fmt.Errorf("event %q does not match the schema", e.Name))
+		}
+	}
+	for _, e := range want.Events {
+		if _, ok := wantEvents[e.Name]; ok {
+			merr = errors.Join(merr, fmt.Errorf("event %q is in the schema but not declared", e.Name))
+		}
+	}
+	if merr != nil {
+		return fmt.Errorf("event schema %s is out of date, update it with events.WriteSchema: %w", path, merr)
+	}
+	return nil
+}
+
+// Metrics returns the allowlist entries for every event in the schema, for
+// the app's metrics.json.
+func (s *Schema) Metrics() []string {
+	var out []string
+	for _, e := range s.Events {
+		out = append(out, e.metrics()...)
+	}
+	return out
+}
+
+// validate returns all problems with the schema's names and values.
+func (s *Schema) validate() error {
+	var merr error
+	seen := make(map[string]struct{}, len(s.Events))
+	for i, e := range s.Events {
+		if e == nil || e.Name == "" || strings.ContainsAny(e.Name, "*{}") {
+			merr = errors.Join(merr, fmt.Errorf("events[%d]: invalid name", i))
+			continue
+		}
+		if _, ok := seen[e.Name]; ok {
+			merr = errors.Join(merr, fmt.Errorf("duplicate event %q", e.Name))
+		}
+		seen[e.Name] = struct{}{}
+		for j, l := range e.Labels {
+			if l == nil || !segmentPattern.MatchString(l.Name) {
+				merr = errors.Join(merr, fmt.Errorf("event %q: labels[%d]: invalid name", e.Name, j))
+				continue
+			}
+			for _, v := range l.Values {
+				if !segmentPattern.MatchString(v) {
+					merr = errors.Join(merr, fmt.Errorf("event %q: label %q has invalid value %q", e.Name, l.Name, v))
+				}
+			}
+		}
+		if n := e.metricCount(); n > maxEventMetrics {
+			merr = errors.Join(merr, fmt.Errorf("event %q expands to %d metrics, more than the maximum of %d", e.Name, n, maxEventMetrics))
+		}
+	}
+	return merr
+}
+
+// metricCount returns the number of allowlist entries the event expands to.
+func (e *SchemaEvent) metricCount() int {
+	n := 1
+	for _, l := range e.Labels {
+		if l != nil {
+			n *= max(len(l.Values), 1)
+		}
+	}
+	return n
+}
+
+// metrics returns the allowlist entries for every metric the event may
+// record, with "*" for labels without listed values, sorted.
+func (e *SchemaEvent) metrics() []string {
+	out := []string{e.Name}
+	for _, l := range e.Labels {
+		values := l.Values
+		if len(values) == 0 {
+			values = []string{anyValue}
+		}
+		next := make([]string, 0, len(out)*len(values))
+		for _, prefix := range out {
+			for _, value := range values {
+				next = append(next, prefix+"."+value)
+			}
+		}
+		out = next
+	}
+	slices.Sort(out)
+	return out
+}
@@ -0,0 +1,125 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/abcxyz/pkg/testutil"
+)
+
+func TestSchema_RoundTrip(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "events.json")
+	commandRun := mustNew[CommandRun](t)
+	httpRequest := mustNew[HTTPRequestSent](t)
+	if err := WriteSchema(path, httpRequest, commandRun); err != nil {
+		t.Fatalf("failed to write schema: %s", err.Error())
+	}
+	if err := CheckSchema(path, commandRun, httpRequest); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+
+	s, err := LoadSchema(path)
+	if err != nil {
+		t.Fatalf("failed to load schema: %s", err.Error())
+	}
+	want := []string{
+		"command_run.init.false",
+		"command_run.init.true",
+		"command_run.render.false",
+		"command_run.render.true",
+		"http_request_sent.*",
+	}
+	if diff := cmp.Diff(s.Metrics(), want); diff != "" {
+		t.Errorf("unexpected metrics (-got,+want): %s", diff)
+	}
+}
+
+func TestCheckSchema(t *testing.T) {
+	t.Parallel()
+
+	schema := `{"events": [
+		{"name": "command_run", "labels": [{"name": "command", "values": ["init"]}, {"name": "success", "values": ["false", "true"]}]},
+		{"name": "removed"}
+	]}`
+	path := filepath.Join(t.TempDir(), "events.json")
+	if err := os.WriteFile(path, []byte(schema), 0o600); err != nil {
+		t.Fatalf("failed to write schema: %s", err.Error())
+	}
+
+	err := CheckSchema(path, mustNew[CommandRun](t), mustNew[HTTPRequestSent](t))
+	for _, want := range []string{
+		"is out of date",
+		`event "command_run" does not match the schema`,
+		`event "http_request_sent" is not in the schema`,
+		`event "removed" is in the schema but not declared`,
+	} {
+		if diff := testutil.DiffErrString(err, want); diff != "" {
+			t.Error(diff)
+		}
+	}
+}
+
+func TestLoadSchema_Errors(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		schema  string
+		wantErr string
+	}{
+		{
+			name:    "unknown_field",
+			schema:  `{"events": [{"name": "a", "label": []}]}`,
+			wantErr: `unknown field "label"`,
+		},
+		{
+			name:    "invalid_name",
+			schema:  `{"events": [{"name": "a*"}]}`,
+			wantErr: "events[0]: invalid name",
+		},
+		{
+			name:    "duplicate",
+			schema:  `{"events": [{"name": "a"}, {"name": "a"}]}`,
+			wantErr: `duplicate event "a"`,
+		},
+		{
+			name:    "invalid_value",
+			schema:  `{"events": [{"name": "a", "labels": [{"name": "l", "values": ["x/y"]}]}]}`,
+			wantErr: `event "a": label "l" has invalid value "x/y"`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(t.TempDir(), "events.json")
+			if err := os.WriteFile(path, []byte(tc.schema), 0o600); err != nil {
+				t.Fatalf("failed to write schema: %s", err.Error())
+			}
+			_, err := LoadSchema(path)
+			if diff := testutil.DiffErrString(err, tc.wantErr); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,257 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package failover tries an ordered list of servers, falling back to the next
+// when one fails. Servers which failed recently are backed off, servers which
+// asked clients to wait with Retry-After are skipped until then, and this
+// state is persisted so short-lived processes, such as CLIs, share it across
+// runs.
+package failover
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/abcxyz/abc-updater/pkg/apierror"
+	"github.com/abcxyz/abc-updater/pkg/localstore"
+)
+
+const (
+	// minBackoff is the backoff after a host's first failure. It doubles with
+	// each consecutive failure, up to maxBackoff.
+	minBackoff = time.Minute
+	maxBackoff = time.Hour
+
+	// maxCooldown limits how long a Retry-After header can stop requests to
+	// a host, in case of a misconfigured server.
+	maxCooldown = time.Hour
+)
+
+// ErrCoolingDown is returned (wrapped) without sending a request if every
+// server asked clients to wait, with Retry-After, and the wait has not
+// elapsed.
+var ErrCoolingDown = errors.New("server asked clients to wait before retrying")
+
+// hostState is the backoff state of a single host.
+type hostState struct {
+	// Consecutive failures.
+	Failures int `json:"failures"`
+	// Time until which the host is backed off, in UTC epoch seconds.
+	RetryAfter int64 `json:"retryAfter"`
+	// Time until which the host asked not to be sent requests, in UTC epoch
+	// seconds.
+	CooldownUntil int64 `json:"cooldownUntil,omitempty"`
+}
+
+// state defines the json file that persists backoff state.
+type state struct {
+	Hosts map[string]*hostState `json:"hosts"`
+}
+
+// Tracker tracks per-host backoff state in a local JSON file. A nil *Tracker
+// is valid, and tries servers in order without backoff.
+type Tracker struct {
+	path string
+	now  func() time.Time
+
+	// mu serializes reads and updates of the state file within a process,
+	// but is not held while requests are made. Concurrent processes may
+	// overwrite each other's updates, which only affects ordering.
+	mu sync.Mutex
+}
+
+// NewTracker returns a Tracker which persists backoff state to the JSON file
+// at path.
+func NewTracker(path string) *Tracker {
+	return &Tracker{path: path, now: time.Now}
+}
+
+// Do calls fn with each of urls in turn until it succeeds, and returns nil,
+// or the errors from every attempt if none did. Hosts which are backed off are
+// tried last, rather than skipped, so an outage of every server still retries
+// them.
+//
+// Errors which would be the same from any server, such as a 404, are returned
+// immediately without trying further servers, as is a canceled ctx.
+//
+// Hosts which responded with a Retry-After header are skipped until it
+// elapses. If every host is skipped, an error wrapping ErrCoolingDown is
+// returned without calling fn.
+func (t *Tracker) Do(ctx context.Context, urls []string, fn func(ctx context.Context, url string) error) error {
+	if t == nil {
+		return do(ctx, urls, fn, func(string, error) {})
+	}
+
+	// fn is called without holding mu, so concurrent calls, e.g. async
+	// metric writes, are not serialized behind each other's requests.
+	t.mu.Lock()
+	s := t.load()
+	now := t.now()
+	urls, cooling := s.available(urls, now)
+	if len(urls) > 0 {
+		urls = s.order(urls, now)
+	}
+	t.mu.Unlock()
+	if len(urls) == 0 {
+		return cooling
+	}
+
+	type attempt struct {
+		url string
+		err error
+	}
+	var attempts []attempt
+	err := do(ctx, urls, fn, func(u string, err error) {
+		attempts = append(attempts, attempt{url: u, err: err})
+	})
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// Reloaded, so updates made by concurrent calls are kept.
+	s = t.load()
+	var changed bool
+	for _, a := range attempts {
+		changed = s.record(hostKey(a.url), a.err, now) || changed
+	}
+	if changed {
+		// Backoff state is best effort.
+		_ = localstore.StoreJSONFile(t.path, s)
+	}
+	return err
+}
+
+// do tries urls in order, calling record with the result of each attempt.
+func do(ctx context.Context, urls []string, fn func(ctx context.Context, url string) error, record func(string, error)) error {
+	var merr error
+	for _, u := range urls {
+		err := fn(ctx, u)
+		if err == nil {
+			record(u, nil)
+			return nil
+		}
+		if len(urls) > 1 {
+			err = fmt.Errorf("%s: %w", u, err)
+		}
+		merr = errors.Join(merr, err)
+
+		switch {
+		case ctx.Err() != nil:
+			// Not the server's fault.
+			return merr
+		case !Retryable(err):
+			// The server is up, it just said no.
+			record(u, nil)
+			return merr
+		}
+		record(u, err)
+	}
+	return merr
+}
+
+// Retryable returns true if a different server might not return err, i.e. it
+// is not an error response other than a 429 or 5xx. Network errors, including
+// timeouts, are retryable.
+func Retryable(err error) bool {
+	var apiErr *apierror.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= 500
+	}
+	return true
+}
+
+// load returns the persisted state, or an empty state if it cannot be loaded.
+func (t *Tracker) load() *state {
+	var s state
+	if err := localstore.LoadJSONFile(t.path, &s); err != nil || s.Hosts == nil {
+		s.Hosts = make(map[string]*hostState)
+	}
+	return &s
+}
+
+// available returns the urls whose hosts are not cooling down at now, and an
+// error wrapping ErrCoolingDown for each which is.
+func (s *state) available(urls []string, now time.Time) ([]string, error) {
+	var available []string
+	var merr error
+	for _, u := range urls {
+		if h, ok := s.Hosts[hostKey(u)]; ok && h.CooldownUntil > now.Unix() {
+			until := time.Unix(h.CooldownUntil, 0).UTC().Format(time.RFC3339)
+			merr = errors.Join(merr, fmt.Errorf("%w: %s, skipping until %s", ErrCoolingDown, hostKey(u), until))
+			continue
+		}
+		available = append(available, u)
+	}
+	return available, merr
+}
+
+// order returns urls with hosts that are backed off at now moved to the end,
+// soonest retry first.
+func (s *state) order(urls []string, now time.Time) []string {
+	var available, backedOff []string
+	for _, u := range urls {
+		if h, ok := s.Hosts[hostKey(u)]; ok && h.RetryAfter > now.Unix() {
+			backedOff = append(backedOff, u)
+			continue
+		}
+		available = append(available, u)
+	}
+	slices.SortStableFunc(backedOff, func(a, b string) int {
+		return cmp.Compare(s.Hosts[hostKey(a)].RetryAfter, s.Hosts[hostKey(b)].RetryAfter)
+	})
+	return append(available, backedOff...)
+}
+
+// record updates the state of host after an attempt which returned err, and
+// returns true if the state changed.
+func (s *state) record(host string, err error, now time.Time) bool {
+	if err == nil {
+		_, ok := s.Hosts[host]
+		delete(s.Hosts, host)
+		return ok
+	}
+	h, ok := s.Hosts[host]
+	if !ok {
+		h = &hostState{}
+		s.Hosts[host] = h
+	}
+	h.Failures++
+	backoff := maxBackoff
+	if h.Failures < 8 {
+		backoff = min(minBackoff<<(h.Failures-1), maxBackoff)
+	}
+	h.RetryAfter = now.Add(backoff).Unix()
+
+	var apiErr *apierror.Error
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+		h.CooldownUntil = now.Add(min(apiErr.RetryAfter, maxCooldown)).Unix()
+		h.RetryAfter = max(h.RetryAfter, h.CooldownUntil)
+	}
+	return true
+}
+
+// hostKey returns the key for the host of u.
+func hostKey(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil || parsed.Host == "" {
+		return u
+	}
+	return parsed.Host
+}
@@ -0,0 +1,349 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package failover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/abcxyz/abc-updater/pkg/apierror"
+	"github.com/abcxyz/pkg/testutil"
+)
+
+const (
+	primary = "https://primary.example.com"
+	mirror  = "https://mirror.example.com/updates"
+)
+
+func TestTracker_Do(t *testing.T) {
+	t.Parallel()
+
+	errDown := errors.New("connection refused")
+
+	cases := []struct {
+		name       string
+		results    map[string]error
+		nilTracker bool
+		wantTried  []string
+		wantErr    string
+	}{
+		{
+			name:      "primary_succeeds",
+			results:   map[string]error{},
+			wantTried: []string{primary},
+		},
+		{
+			name:      "falls_back",
+			results:   map[string]error{primary: errDown},
+			wantTried: []string{primary, mirror},
+		},
+		{
+			name: "falls_back_on_5xx",
+			results: map[string]error{
+				primary: &apierror.Error{StatusCode: http.StatusServiceUnavailable},
+			},
+			wantTried: []string{primary, mirror},
+		},
+		{
+			name: "no_fallback_on_4xx",
+			results: map[string]error{
+				primary: &apierror.Error{StatusCode: http.StatusNotFound, Code: apierror.CodeUnknownApp},
+			},
+			wantTried: []string{primary},
+			wantErr:   "received 404 response",
+		},
+		{
+			name:      "all_fail",
+			results:   map[string]error{primary: errDown, mirror: errDown},
+			wantTried: []string{primary, mirror},
+			wantErr:   mirror + ": connection refused",
+		},
+		{
+			name:       "nil_tracker",
+			results:    map[string]error{primary: errDown},
+			nilTracker: true,
+			wantTried:  []string{primary, mirror},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var tracker *Tracker
+			if !tc.nilTracker {
+				tracker = NewTracker(filepath.Join(t.TempDir(), "backoff.json"))
+			}
+
+			var tried []string
+			err := tracker.Do(context.Background(), []string{primary, mirror}, func(ctx context.Context, u string) error {
+				tried = append(tried, u)
+				return tc.results[u]
+			})
+			if diff := testutil.DiffErrString(err, tc.wantErr); diff != "" {
+				t.Error(diff)
+			}
+			if diff := cmp.Diff(tried, tc.wantTried); diff != "" {
+				t.Errorf("unexpected servers tried (-got,+want): %s", diff)
+			}
+		})
+	}
+}
+
+func TestTracker_Backoff(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "backoff.json")
+	now := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+	newTracker := func() *Tracker {
+		// A new Tracker for each call, as if from separate runs of a CLI.
+		tracker := NewTracker(path)
+		tracker.now = func() time.Time { return now }
+		return tracker
+	}
+
+	primaryUp := false
+	do := func() []string {
+		t.Helper()
+		var tried []string
+		if err := newTracker().Do(context.Background(), []string{primary, mirror}, func(ctx context.Context, u string) error {
+			tried = append(tried, u)
+			if u == primary && !primaryUp {
+				return fmt.Errorf("connection refused")
+			}
+			return nil
+		}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return tried
+	}
+
+	if diff := cmp.Diff(do(), []string{primary, mirror}); diff != "" {
+		t.Errorf("first run: unexpected servers tried (-got,+want): %s", diff)
+	}
+
+	// The primary is backed off for a minute.
+	now = now.Add(30 * time.Second)
+	if diff := cmp.Diff(do(), []string{mirror}); diff != "" {
+		t.Errorf("during backoff: unexpected servers tried (-got,+want): %s", diff)
+	}
+
+	// After the backoff, the primary fails again, doubling the backoff.
+	now = now.Add(time.Minute)
+	if diff := cmp.Diff(do(), []string{primary, mirror}); diff != "" {
+		t.Errorf("after backoff: unexpected servers tried (-got,+want): %s", diff)
+	}
+	now = now.Add(90 * time.Second)
+	if diff := cmp.Diff(do(), []string{mirror}); diff != "" {
+		t.Errorf("during doubled backoff: unexpected servers tried (-got,+want): %s", diff)
+	}
+
+	// Once the primary recovers, its backoff is cleared.
+	now = now.Add(time.Minute)
+	primaryUp = true
+	if diff := cmp.Diff(do(), []string{primary}); diff != "" {
+		t.Errorf("after recovery: unexpected servers tried (-got,+want): %s", diff)
+	}
+	now = now.Add(time.Second)
+	if diff := cmp.Diff(do(), []string{primary}); diff != "" {
+		t.Errorf("after recovery: unexpected servers tried (-got,+want): %s", diff)
+	}
+}
+
+func TestTracker_BackedOffTriedLast(t *testing.T) {
+	t.Parallel()
+
+	tracker := NewTracker(filepath.Join(t.TempDir(), "backoff.json"))
+
+	fail := func(ctx context.Context, u string) error { return fmt.Errorf("connection refused") }
+	_ = tracker.Do(context.Background(), []string{primary, mirror}, fail)
+
+	// Both servers are backed off, but are still tried, soonest retry first.
+	var tried []string
+	err := tracker.Do(context.Background(), []string{mirror, primary}, func(ctx context.Context, u string) error {
+		tried = append(tried, u)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(tried, []string{mirror}); diff != "" {
+		t.Errorf("unexpected servers tried (-got,+want): %s", diff)
+	}
+}
+
+func TestTracker_RetryAfter(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "backoff.json")
+	now := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+	var tried []string
+	do := func() error {
+		// A new Tracker for each call, as if from separate runs of a CLI.
+		tracker := NewTracker(path)
+		tracker.now = func() time.Time { return now }
+		return tracker.Do(context.Background(), []string{primary}, func(ctx context.Context, u string) error {
+			tried = append(tried, u)
+			return &apierror.Error{StatusCode: http.StatusTooManyRequests, Code: apierror.CodeRateLimited, RetryAfter: 2 * time.Minute}
+		})
+	}
+
+	if err := do(); !apierror.HasCode(err, apierror.CodeRateLimited) {
+		t.Errorf("expected rate limited error, got %v", err)
+	}
+
+	// The only server asked to wait, so nothing is sent.
+	now = now.Add(time.Minute)
+	if err := do(); !errors.Is(err, ErrCoolingDown) {
+		t.Errorf("during cooldown: expected ErrCoolingDown, got %v", err)
+	}
+	if diff := cmp.Diff(tried, []string{primary}); diff != "" {
+		t.Errorf("during cooldown: unexpected servers tried (-got,+want): %s", diff)
+	}
+
+	now = now.Add(time.Minute)
+	if err := do(); !apierror.HasCode(err, apierror.CodeRateLimited) {
+		t.Errorf("after cooldown: expected rate limited error, got %v", err)
+	}
+	if diff := cmp.Diff(tried, []string{primary, primary}); diff != "" {
+		t.Errorf("after cooldown: unexpected servers tried (-got,+want): %s", diff)
+	}
+}
+
+func TestTracker_RetryAfterFallsBack(t *testing.T) {
+	t.Parallel()
+
+	tracker := NewTracker(filepath.Join(t.TempDir(), "backoff.json"))
+	_ = tracker.Do(context.Background(), []string{primary, mirror}, func(ctx context.Context, u string) error {
+		if u == primary {
+			return &apierror.Error{StatusCode: http.StatusServiceUnavailable, RetryAfter: time.Hour}
+		}
+		return nil
+	})
+
+	// The cooling down primary is skipped, rather than tried last.
+	var tried []string
+	if err := tracker.Do(context.Background(), []string{primary, mirror}, func(ctx context.Context, u string) error {
+		tried = append(tried, u)
+		return fmt.Errorf("connection refused")
+	}); err == nil {
+		t.Fatalf("expected error")
+	}
+	if diff := cmp.Diff(tried, []string{mirror}); diff != "" {
+		t.Errorf("unexpected servers tried (-got,+want): %s", diff)
+	}
+}
+
+func TestTracker_DoCanceled(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "backoff.json")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var tried []string
+	err := NewTracker(path).Do(ctx, []string{primary, mirror}, func(ctx context.Context, u string) error {
+		tried = append(tried, u)
+		return ctx.Err()
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if diff := cmp.Diff(tried, []string{primary}); diff != "" {
+		t.Errorf("unexpected servers tried (-got,+want): %s", diff)
+	}
+	// A canceled request is not the server's fault, so it is not backed off.
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected no backoff state, got err: %v", err)
+	}
+}
+
+func TestTracker_DoConcurrent(t *testing.T) {
+	t.Parallel()
+
+	tracker := NewTracker(filepath.Join(t.TempDir(), "backoff.json"))
+
+	// Each call blocks until all of them are in flight, which only happens
+	// if the tracker does not hold its lock while calling fn.
+	const calls = 4
+	var inFlight atomic.Int32
+	allStarted := make(chan struct{})
+
+	var wg sync.WaitGroup
+	errs := make([]error, calls)
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = tracker.Do(context.Background(), []string{primary, mirror}, func(ctx context.Context, u string) error {
+				if inFlight.Add(1) == calls {
+					close(allStarted)
+				}
+				select {
+				case <-allStarted:
+					return nil
+				case <-time.After(5 * time.Second):
+					return fmt.Errorf("timed out waiting for concurrent calls")
+				}
+			})
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("call %d: %v", i, err)
+		}
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "network", err: errors.New("connection refused"), want: true},
+		{name: "server_error", err: &apierror.Error{StatusCode: http.StatusBadGateway}, want: true},
+		{name: "too_many_requests", err: &apierror.Error{StatusCode: http.StatusTooManyRequests}, want: true},
+		{name: "not_found", err: fmt.Errorf("wrapped: %w", &apierror.Error{StatusCode: http.StatusNotFound}), want: false},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := Retryable(tc.err); got != tc.want {
+				t.Errorf("Retryable(%v) = %t, want %t", tc.err, got, tc.want)
+			}
+		})
+	}
+}
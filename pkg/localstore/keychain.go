@@ -0,0 +1,106 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package localstore
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// KeychainService is the OS keychain service abc-updater stores items under.
+// Items are named by app ID.
+const KeychainService = "abcupdater"
+
+// ErrKeychainUnsupported is returned (wrapped) by Keychain methods on
+// platforms without a supported OS credential store.
+var ErrKeychainUnsupported = errors.New("OS keychain not supported on this platform")
+
+// ErrKeychainUnavailable is returned (wrapped) by Keychain methods if the
+// platform's credential store cannot be used on this machine, e.g. secret-tool
+// is not installed or no Secret Service is running on Linux.
+var ErrKeychainUnavailable = errors.New("OS keychain unavailable")
+
+// Keychain stores small JSON values in the OS credential store rather than in
+// plaintext files: the login keychain on macOS, the Secret Service (via
+// secret-tool) on Linux, and Credential Manager on Windows.
+type Keychain struct {
+	// Service namespaces the values, e.g. "abcupdater".
+	Service string
+
+	// get, set, and del access the credential store. Overridden in tests.
+	get func(service, account string) ([]byte, error)
+	set func(service, account string, secret []byte) error
+	del func(service, account string) error
+}
+
+// NewKeychain returns a Keychain storing values under service.
+func NewKeychain(service string) *Keychain {
+	return &Keychain{
+		Service: service,
+		get:     keychainGet,
+		set:     keychainSet,
+		del:     keychainDelete,
+	}
+}
+
+// LoadJSON unmarshals the value stored for account into data. data cannot be
+// nil. errors.Is(err, os.ErrNotExist) will return true if no value is stored.
+func (k *Keychain) LoadJSON(account string, data any) error {
+	encoded, err := k.get(k.Service, account)
+	if err != nil {
+		return fmt.Errorf("failed to read keychain item %s/%s: %w", k.Service, account, err)
+	}
+	b, err := base64.StdEncoding.DecodeString(string(encoded))
+	if err != nil {
+		return fmt.Errorf("failed to decode keychain item %s/%s: %w", k.Service, account, err)
+	}
+	if err := json.Unmarshal(b, data); err != nil {
+		return fmt.Errorf("failed to load json from keychain item %s/%s: %w", k.Service, account, err)
+	}
+	return nil
+}
+
+// StoreJSON marshals data and stores it for account, replacing any existing
+// value. data cannot be nil.
+func (k *Keychain) StoreJSON(account string, data any) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode JSON: %w", err)
+	}
+	// Base64 keeps the stored value free of characters the platform tools
+	// would need quoted.
+	encoded := []byte(base64.StdEncoding.EncodeToString(b))
+	if err := k.set(k.Service, account, encoded); err != nil {
+		return fmt.Errorf("failed to save keychain item %s/%s: %w", k.Service, account, err)
+	}
+	return nil
+}
+
+// Delete removes the value stored for account. It returns nil if no value is
+// stored, including if the platform has no supported or available credential
+// store.
+func (k *Keychain) Delete(account string) error {
+	if err := k.del(k.Service, account); err != nil && !errors.Is(err, os.ErrNotExist) &&
+		!errors.Is(err, ErrKeychainUnsupported) && !errors.Is(err, ErrKeychainUnavailable) {
+		return fmt.Errorf("failed to delete keychain item %s/%s: %w", k.Service, account, err)
+	}
+	return nil
+}
+
+// errItemNotFound is returned by keychainGet if no value is stored.
+var errItemNotFound = fmt.Errorf("keychain item not found: %w", os.ErrNotExist)
@@ -0,0 +1,75 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build darwin
+
+package localstore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// securityItemNotFound is the exit code of security(1) when no item matches.
+const securityItemNotFound = 44
+
+func keychainGet(service, account string) ([]byte, error) {
+	out, err := exec.Command("security", "find-generic-password", "-s", service, "-a", account, "-w").Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == securityItemNotFound {
+			return nil, errItemNotFound
+		}
+		return nil, fmt.Errorf("security find-generic-password failed: %w", err)
+	}
+	return bytes.TrimSpace(out), nil
+}
+
+func keychainSet(service, account string, secret []byte) error {
+	// The secret is passed on stdin, in interactive mode, so it is not
+	// visible in the process list.
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %s -a %s -w %s\n",
+		quote(service), quote(account), quote(string(secret))))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("security add-generic-password failed: %w: %s", err, stderr.String())
+	}
+	// Interactive mode exits zero even if the command failed.
+	if stderr.Len() > 0 {
+		return fmt.Errorf("security add-generic-password failed: %s", strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func keychainDelete(service, account string) error {
+	err := exec.Command("security", "delete-generic-password", "-s", service, "-a", account).Run()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == securityItemNotFound {
+			return errItemNotFound
+		}
+		return fmt.Errorf("security delete-generic-password failed: %w", err)
+	}
+	return nil
+}
+
+// quote quotes s for a security(1) interactive mode command line.
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,78 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package localstore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+func keychainGet(service, account string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("secret-tool", "lookup", "service", service, "account", account)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		// secret-tool exits 1 with no output if no item matches.
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 && len(out) == 0 && stderr.Len() == 0 {
+			return nil, errItemNotFound
+		}
+		return nil, secretToolError("lookup", err, stderr.String())
+	}
+	return bytes.TrimSpace(out), nil
+}
+
+func keychainSet(service, account string, secret []byte) error {
+	// The secret is passed on stdin, so it is not visible in the process
+	// list.
+	var stderr bytes.Buffer
+	cmd := exec.Command("secret-tool", "store", "--label", service+" "+account,
+		"service", service, "account", account)
+	cmd.Stdin = bytes.NewReader(secret)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return secretToolError("store", err, stderr.String())
+	}
+	return nil
+}
+
+func keychainDelete(service, account string) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command("secret-tool", "clear", "service", service, "account", account)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return secretToolError("clear", err, stderr.String())
+	}
+	return nil
+}
+
+// secretToolError describes a failed secret-tool command. It wraps
+// ErrKeychainUnavailable if secret-tool is not installed or could not reach a
+// Secret Service, e.g. on a headless machine without a D-Bus session.
+func secretToolError(command string, err error, stderr string) error {
+	if errors.Is(err, exec.ErrNotFound) {
+		return fmt.Errorf("secret-tool not found: %w", ErrKeychainUnavailable)
+	}
+	if strings.Contains(stderr, "org.freedesktop.secrets") || strings.Contains(stderr, "D-Bus") {
+		return fmt.Errorf("secret-tool %s failed: %w: %s", command, ErrKeychainUnavailable, stderr)
+	}
+	return fmt.Errorf("secret-tool %s failed: %w: %s", command, err, stderr)
+}
@@ -0,0 +1,39 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package localstore
+
+import (
+	"errors"
+	"testing"
+)
+
+// Not parallel, as it sets PATH.
+func TestKeychain_SecretToolNotFound(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+
+	k := NewKeychain("test")
+	var data testObj
+	if err := k.LoadJSON("acct", &data); !errors.Is(err, ErrKeychainUnavailable) {
+		t.Errorf("LoadJSON: expected ErrKeychainUnavailable, got %v", err)
+	}
+	if err := k.StoreJSON("acct", &testObj{Foo: "foo"}); !errors.Is(err, ErrKeychainUnavailable) {
+		t.Errorf("StoreJSON: expected ErrKeychainUnavailable, got %v", err)
+	}
+	if err := k.Delete("acct"); err != nil {
+		t.Errorf("Delete: unexpected error: %s", err.Error())
+	}
+}
@@ -0,0 +1,29 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !darwin && !linux && !windows
+
+package localstore
+
+func keychainGet(service, account string) ([]byte, error) {
+	return nil, ErrKeychainUnsupported
+}
+
+func keychainSet(service, account string, secret []byte) error {
+	return ErrKeychainUnsupported
+}
+
+func keychainDelete(service, account string) error {
+	return ErrKeychainUnsupported
+}
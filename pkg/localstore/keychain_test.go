@@ -0,0 +1,96 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package localstore
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func newFakeKeychain(items map[string][]byte) *Keychain {
+	return &Keychain{
+		Service: "test",
+		get: func(service, account string) ([]byte, error) {
+			b, ok := items[service+"/"+account]
+			if !ok {
+				return nil, errItemNotFound
+			}
+			return b, nil
+		},
+		set: func(service, account string, secret []byte) error {
+			items[service+"/"+account] = secret
+			return nil
+		},
+		del: func(service, account string) error {
+			if _, ok := items[service+"/"+account]; !ok {
+				return errItemNotFound
+			}
+			delete(items, service+"/"+account)
+			return nil
+		},
+	}
+}
+
+func TestKeychain_RoundTrip(t *testing.T) {
+	t.Parallel()
+
+	items := make(map[string][]byte)
+	k := newFakeKeychain(items)
+
+	want := testObj{Foo: "foo\"bar", Bar: 15}
+	if err := k.StoreJSON("acct", &want); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if diff := cmp.Diff(string(items["test/acct"]), "eyJmb28iOiJmb29cImJhciIsImJhciI6MTV9"); diff != "" {
+		t.Errorf("unexpected stored value (-got,+want): %s", diff)
+	}
+
+	var got testObj
+	if err := k.LoadJSON("acct", &got); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("unexpected value (-got,+want): %s", diff)
+	}
+}
+
+func TestKeychain_LoadJSON_NotFound(t *testing.T) {
+	t.Parallel()
+
+	var got testObj
+	err := newFakeKeychain(make(map[string][]byte)).LoadJSON("missing", &got)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected os.ErrNotExist, got %v", err)
+	}
+}
+
+func TestKeychain_Delete(t *testing.T) {
+	t.Parallel()
+
+	items := map[string][]byte{"test/acct": []byte("e30=")}
+	k := newFakeKeychain(items)
+
+	for i := 0; i < 2; i++ {
+		if err := k.Delete("acct"); err != nil {
+			t.Fatalf("delete %d: unexpected error: %s", i, err.Error())
+		}
+	}
+	if len(items) != 0 {
+		t.Errorf("expected item to be deleted, got %v", items)
+	}
+}
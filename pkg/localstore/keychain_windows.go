@@ -0,0 +1,118 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build windows
+
+package localstore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	advapi32        = syscall.NewLazyDLL("advapi32.dll")
+	procCredReadW   = advapi32.NewProc("CredReadW")
+	procCredWriteW  = advapi32.NewProc("CredWriteW")
+	procCredFree    = advapi32.NewProc("CredFree")
+	procCredDeleteW = advapi32.NewProc("CredDeleteW")
+)
+
+const (
+	credTypeGeneric         = 1
+	credPersistLocalMachine = 2
+
+	// errorNotFound is ERROR_NOT_FOUND, returned by CredReadW if no
+	// credential matches.
+	errorNotFound = syscall.Errno(1168)
+)
+
+// credential is the Win32 CREDENTIALW struct.
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        syscall.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+func keychainGet(service, account string) ([]byte, error) {
+	target, err := syscall.UTF16PtrFromString(service + ":" + account)
+	if err != nil {
+		return nil, fmt.Errorf("invalid credential name: %w", err)
+	}
+
+	var cred *credential
+	ret, _, err := procCredReadW.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred)))
+	if ret == 0 {
+		if errors.Is(err, errorNotFound) {
+			return nil, errItemNotFound
+		}
+		return nil, fmt.Errorf("CredReadW failed: %w", err)
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred))) //nolint:errcheck // Nothing to do on failure.
+
+	return bytes.Clone(unsafe.Slice(cred.CredentialBlob, cred.CredentialBlobSize)), nil
+}
+
+func keychainSet(service, account string, secret []byte) error {
+	target, err := syscall.UTF16PtrFromString(service + ":" + account)
+	if err != nil {
+		return fmt.Errorf("invalid credential name: %w", err)
+	}
+	user, err := syscall.UTF16PtrFromString(account)
+	if err != nil {
+		return fmt.Errorf("invalid credential name: %w", err)
+	}
+	if len(secret) == 0 {
+		return fmt.Errorf("secret cannot be empty")
+	}
+
+	cred := credential{
+		Type:               credTypeGeneric,
+		TargetName:         target,
+		CredentialBlobSize: uint32(len(secret)),
+		CredentialBlob:     &secret[0],
+		Persist:            credPersistLocalMachine,
+		UserName:           user,
+	}
+	if ret, _, err := procCredWriteW.Call(uintptr(unsafe.Pointer(&cred)), 0); ret == 0 {
+		return fmt.Errorf("CredWriteW failed: %w", err)
+	}
+	return nil
+}
+
+func keychainDelete(service, account string) error {
+	target, err := syscall.UTF16PtrFromString(service + ":" + account)
+	if err != nil {
+		return fmt.Errorf("invalid credential name: %w", err)
+	}
+	if ret, _, err := procCredDeleteW.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0); ret == 0 {
+		if errors.Is(err, errorNotFound) {
+			return errItemNotFound
+		}
+		return fmt.Errorf("CredDeleteW failed: %w", err)
+	}
+	return nil
+}
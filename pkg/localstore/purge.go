@@ -0,0 +1,54 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package localstore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// PurgeLocalData removes everything abc-updater stores locally for appID: the
+// version cache and skipped versions, the install ID (from its file and the OS
+// keychain), and persisted metrics state such as dropped metric counts. Use it
+// to honor a user's request to remove their data, or when uninstalling.
+//
+// Only the default locations are purged; files moved with an override option
+// must be removed by the caller. Clients already created for the app keep
+// their in-memory state, so close them first.
+func PurgeLocalData(appID string) error {
+	if appID == "" {
+		return fmt.Errorf("appID cannot be empty")
+	}
+	return purgeLocalData(appID, NewKeychain(KeychainService))
+}
+
+// purgeLocalData implements PurgeLocalData with the given keychain.
+func purgeLocalData(appID string, keychain *Keychain) error {
+	var merr error
+	dir, err := DefaultDir(appID)
+	if err != nil {
+		merr = errors.Join(merr, err)
+	} else if err := os.RemoveAll(dir); err != nil {
+		merr = errors.Join(merr, fmt.Errorf("failed to remove %s: %w", dir, err))
+	}
+	if err := keychain.Delete(appID); err != nil {
+		merr = errors.Join(merr, err)
+	}
+	if merr != nil {
+		return fmt.Errorf("failed to purge local data: %w", merr)
+	}
+	return nil
+}
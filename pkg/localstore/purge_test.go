@@ -0,0 +1,72 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package localstore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Not parallel, as it sets HOME.
+func TestPurgeLocalData(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	dir, err := DefaultDir("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherDir, err := DefaultDir("bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{
+		filepath.Join(dir, "data.json"),
+		filepath.Join(dir, "id.json"),
+		filepath.Join(otherDir, "data.json"),
+	} {
+		if err := StoreJSONFile(path, &testObj{Foo: "foo"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	items := map[string][]byte{
+		"test/foo": []byte("e30="),
+		"test/bar": []byte("e30="),
+	}
+
+	if err := purgeLocalData("foo", newFakeKeychain(items)); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed, got %v", dir, err)
+	}
+	if _, ok := items["test/foo"]; ok {
+		t.Errorf("expected keychain item to be removed")
+	}
+	// Other apps are untouched.
+	if _, err := os.Stat(filepath.Join(otherDir, "data.json")); err != nil {
+		t.Errorf("expected other app's data to remain, got %v", err)
+	}
+	if _, ok := items["test/bar"]; !ok {
+		t.Errorf("expected other app's keychain item to remain")
+	}
+
+	// Purging again is a no-op.
+	if err := purgeLocalData("foo", newFakeKeychain(items)); err != nil {
+		t.Errorf("unexpected error purging again: %s", err.Error())
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package localstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// schemaVersionKey is the JSON field storing a file's schema version.
+const schemaVersionKey = "schemaVersion"
+
+// Migration upgrades the fields of a stored JSON object by one schema version,
+// modifying them in place.
+type Migration func(fields map[string]json.RawMessage) error
+
+// Schema versions stored JSON objects of one kind, so a format change upgrades
+// old files rather than failing to load them. The version is stored in the
+// object's "schemaVersion" field; objects written before versioning have no
+// such field and are version 0.
+type Schema struct {
+	version    int
+	migrations map[int]Migration
+}
+
+// NewSchema returns a Schema whose current version is version. A Migration
+// must be registered from each earlier version.
+func NewSchema(version int) *Schema {
+	return &Schema{
+		version:    version,
+		migrations: make(map[int]Migration, version),
+	}
+}
+
+// Register registers m to upgrade objects from version from to from+1, and
+// returns s. Schemas are defined statically, so it panics if from is not an
+// earlier version or is already registered.
+func (s *Schema) Register(from int, m Migration) *Schema {
+	if from < 0 || from >= s.version {
+		panic(fmt.Sprintf("localstore: migration from version %d outside schema version %d", from, s.version))
+	}
+	if _, ok := s.migrations[from]; ok {
+		panic(fmt.Sprintf("localstore: migration from version %d already registered", from))
+	}
+	s.migrations[from] = m
+	return s
+}
+
+// Version returns the current schema version.
+func (s *Schema) Version() int {
+	return s.version
+}
+
+// Encode marshals data, which must encode as a JSON object, with the current
+// schema version.
+func (s *Schema) Encode(data any) ([]byte, error) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode JSON: %w", err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, fmt.Errorf("versioned data must be a JSON object: %w", err)
+	}
+	return s.encodeFields(fields)
+}
+
+// Decode upgrades the JSON object b to the current schema version and
+// unmarshals it into data. It returns the upgraded object, or nil if b was
+// already current, so callers can store it in place. Objects with a newer
+// version than s are decoded as they are, for forward compatibility.
+func (s *Schema) Decode(b []byte, data any) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, fmt.Errorf("failed to decode JSON: %w", err)
+	}
+
+	var version int
+	if raw, ok := fields[schemaVersionKey]; ok {
+		if err := json.Unmarshal(raw, &version); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", schemaVersionKey, err)
+		}
+	}
+
+	var upgraded []byte
+	if version < s.version {
+		for v := version; v < s.version; v++ {
+			m, ok := s.migrations[v]
+			if !ok {
+				return nil, fmt.Errorf("no migration from schema version %d", v)
+			}
+			if err := m(fields); err != nil {
+				return nil, fmt.Errorf("failed to migrate from schema version %d: %w", v, err)
+			}
+		}
+		var err error
+		if upgraded, err = s.encodeFields(fields); err != nil {
+			return nil, err
+		}
+		b = upgraded
+	}
+
+	if err := json.Unmarshal(b, data); err != nil {
+		return nil, fmt.Errorf("failed to decode JSON: %w", err)
+	}
+	return upgraded, nil
+}
+
+// encodeFields marshals fields with the current schema version.
+func (s *Schema) encodeFields(fields map[string]json.RawMessage) ([]byte, error) {
+	if fields == nil {
+		fields = make(map[string]json.RawMessage, 1)
+	}
+	fields[schemaVersionKey] = json.RawMessage(fmt.Sprint(s.version))
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode JSON: %w", err)
+	}
+	return b, nil
+}
+
+// LoadJSONFile is like LoadJSONFile, but upgrades the file to the current
+// schema version. An upgraded file is rewritten in place, best effort.
+// errors.Is(err, os.ErrNotExist) will return true if file doesn't exist.
+func (s *Schema) LoadJSONFile(path string, data any) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to open json file: %w", err)
+	}
+	upgraded, err := s.Decode(b, data)
+	if err != nil {
+		return fmt.Errorf("failed to load json file: %w", err)
+	}
+	if upgraded != nil {
+		// The data loaded either way, so a failure is retried next load.
+		_ = StoreJSONFile(path, json.RawMessage(upgraded))
+	}
+	return nil
+}
+
+// StoreJSONFile is like StoreJSONFile, but records the current schema version.
+func (s *Schema) StoreJSONFile(path string, data any) error {
+	b, err := s.Encode(data)
+	if err != nil {
+		return err
+	}
+	return StoreJSONFile(path, json.RawMessage(b))
+}
@@ -0,0 +1,180 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package localstore
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/abcxyz/pkg/testutil"
+)
+
+// testSchema is at version 2. Version 0 named the foo field "oldFoo", and
+// version 1 stored bar as a string.
+func testSchema() *Schema {
+	return NewSchema(2).
+		Register(0, func(fields map[string]json.RawMessage) error {
+			if v, ok := fields["oldFoo"]; ok {
+				fields["foo"] = v
+				delete(fields, "oldFoo")
+			}
+			return nil
+		}).
+		Register(1, func(fields map[string]json.RawMessage) error {
+			if v, ok := fields["bar"]; ok {
+				var s string
+				if err := json.Unmarshal(v, &s); err != nil {
+					return err //nolint:wrapcheck // Test migration.
+				}
+				fields["bar"] = json.RawMessage(s)
+			}
+			return nil
+		})
+}
+
+func TestSchema_Decode(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name         string
+		schema       *Schema
+		in           string
+		want         testObj
+		wantUpgraded string
+		wantError    string
+	}{
+		{
+			name:         "unversioned",
+			schema:       testSchema(),
+			in:           `{"oldFoo":"foo","bar":"15"}`,
+			want:         testObj{Foo: "foo", Bar: 15},
+			wantUpgraded: `{"bar":15,"foo":"foo","schemaVersion":2}`,
+		},
+		{
+			name:         "older",
+			schema:       testSchema(),
+			in:           `{"foo":"foo","bar":"15","schemaVersion":1}`,
+			want:         testObj{Foo: "foo", Bar: 15},
+			wantUpgraded: `{"bar":15,"foo":"foo","schemaVersion":2}`,
+		},
+		{
+			name:   "current",
+			schema: testSchema(),
+			in:     `{"foo":"foo","bar":15,"schemaVersion":2}`,
+			want:   testObj{Foo: "foo", Bar: 15},
+		},
+		{
+			name:   "newer",
+			schema: testSchema(),
+			in:     `{"foo":"foo","bar":15,"extra":true,"schemaVersion":3}`,
+			want:   testObj{Foo: "foo", Bar: 15},
+		},
+		{
+			name:      "missing_migration",
+			schema:    NewSchema(1),
+			in:        `{"foo":"foo"}`,
+			wantError: "no migration from schema version 0",
+		},
+		{
+			name:      "migration_fails",
+			schema:    testSchema(),
+			in:        `{"bar":15,"schemaVersion":1}`,
+			wantError: "failed to migrate from schema version 1",
+		},
+		{
+			name:      "not_object",
+			schema:    testSchema(),
+			in:        `[]`,
+			wantError: "failed to decode JSON",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var got testObj
+			upgraded, err := tc.schema.Decode([]byte(tc.in), &got)
+			if diff := testutil.DiffErrString(err, tc.wantError); diff != "" {
+				t.Errorf("unexpected err: %s", diff)
+			}
+			if diff := cmp.Diff(got, tc.want); diff != "" {
+				t.Errorf("unexpected data (-got,+want): %s", diff)
+			}
+			if diff := cmp.Diff(string(upgraded), tc.wantUpgraded); diff != "" {
+				t.Errorf("unexpected upgraded data (-got,+want): %s", diff)
+			}
+		})
+	}
+}
+
+func TestSchema_LoadJSONFile_UpgradesInPlace(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "data.json")
+	if err := os.WriteFile(path, []byte(`{"oldFoo":"foo","bar":"15"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var got testObj
+	if err := testSchema().LoadJSONFile(path, &got); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if diff := cmp.Diff(got, testObj{Foo: "foo", Bar: 15}); diff != "" {
+		t.Errorf("unexpected data (-got,+want): %s", diff)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(string(b), "{\"bar\":15,\"foo\":\"foo\",\"schemaVersion\":2}\n"); diff != "" {
+		t.Errorf("unexpected file contents (-got,+want): %s", diff)
+	}
+}
+
+func TestSchema_StoreJSONFile(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "data.json")
+	if err := testSchema().StoreJSONFile(path, &testObj{Foo: "foo"}); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(string(b), "{\"foo\":\"foo\",\"schemaVersion\":2}\n"); diff != "" {
+		t.Errorf("unexpected file contents (-got,+want): %s", diff)
+	}
+}
+
+func TestSchema_Register_Panics(t *testing.T) {
+	t.Parallel()
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected panic registering migration from current version")
+		}
+	}()
+	NewSchema(1).Register(1, func(map[string]json.RawMessage) error { return nil })
+}
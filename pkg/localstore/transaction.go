@@ -0,0 +1,201 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package localstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// stagingDirPrefix prefixes the directories transactions are staged in.
+	stagingDirPrefix = ".txn-staging-"
+	// commitDirName is the name a staging directory is renamed to when its
+	// transaction commits. Its files are then moved into place.
+	commitDirName = ".txn-commit"
+	// abandonedAge is how old a staging directory must be before Recover
+	// removes it, so transactions being staged by other processes are kept.
+	abandonedAge = time.Hour
+)
+
+// Transaction stages writes to several JSON files in one directory, such as
+// the version cache and the client config derived from it, and commits them
+// atomically.
+// Files are written to a temporary directory, which is renamed into place as
+// the commit point, then moved into the directory. If the process crashes
+// before the commit point, no writes are applied; if it crashes after, the
+// remaining writes are applied by the next call to Recover.
+//
+// Readers of the files should call Recover first to see a consistent state.
+// Only one transaction can commit in a directory at a time; Commit returns an
+// error if another is in progress.
+type Transaction struct {
+	dir    string
+	staged map[string][]byte
+}
+
+// NewTransaction returns an empty Transaction for files in dir.
+func NewTransaction(dir string) *Transaction {
+	return &Transaction{
+		dir:    dir,
+		staged: make(map[string][]byte),
+	}
+}
+
+// StoreJSONFile stages data to be written to the file called name in the
+// transaction's directory. Staging the same name again replaces the earlier
+// data. data cannot be nil.
+func (t *Transaction) StoreJSONFile(name string, data any) error {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(data); err != nil {
+		return fmt.Errorf("failed to encode JSON: %w", err)
+	}
+	return t.stageFile(name, buf.Bytes())
+}
+
+// StoreVersionedJSONFile is like StoreJSONFile, but records the current
+// version of schema s, as Schema.StoreJSONFile does.
+func (t *Transaction) StoreVersionedJSONFile(name string, s *Schema, data any) error {
+	b, err := s.Encode(data)
+	if err != nil {
+		return err
+	}
+	return t.stageFile(name, b)
+}
+
+// stageFile stages b to be written to the file called name.
+func (t *Transaction) stageFile(name string, b []byte) error {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".txn-") {
+		return fmt.Errorf("invalid transaction file name %q", name)
+	}
+	t.staged[name] = b
+	return nil
+}
+
+// Rollback discards all staged writes.
+func (t *Transaction) Rollback() {
+	t.staged = make(map[string][]byte)
+}
+
+// Commit atomically writes all staged files. On error before the commit
+// point, no files are changed and the staged writes are kept, so Commit can be
+// retried.
+func (t *Transaction) Commit() error {
+	if len(t.staged) == 0 {
+		return nil
+	}
+
+	// Finish any earlier transaction first, so its writes do not overwrite
+	// these.
+	if err := Recover(t.dir); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(t.dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create directory for transaction at %s: %w", t.dir, err)
+	}
+	staging, err := os.MkdirTemp(t.dir, stagingDirPrefix+"*")
+	if err != nil {
+		return fmt.Errorf("failed to create transaction staging directory: %w", err)
+	}
+
+	if err := stage(staging, t.staged); err != nil {
+		os.RemoveAll(staging)
+		return err
+	}
+
+	// The commit point. Fails if another transaction is being applied.
+	if err := os.Rename(staging, filepath.Join(t.dir, commitDirName)); err != nil {
+		os.RemoveAll(staging)
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	t.staged = make(map[string][]byte)
+	return Recover(t.dir)
+}
+
+// stage writes files into dir, syncing them so they are durable before the
+// commit point.
+func stage(dir string, files map[string][]byte) error {
+	for name, b := range files {
+		f, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err != nil {
+			return fmt.Errorf("failed to stage %s: %w", name, err)
+		}
+		_, err = f.Write(b)
+		if err == nil {
+			err = f.Sync()
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("failed to stage %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Recover applies the remaining writes of a transaction in dir which committed
+// but was not fully applied, for example because the process crashed, and
+// removes staging directories abandoned before their commit point. It is safe
+// to call concurrently and does nothing if there is nothing to recover.
+func Recover(dir string) error {
+	commitDir := filepath.Join(dir, commitDirName)
+	entries, err := os.ReadDir(commitDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read committed transaction: %w", err)
+	}
+
+	var merr error
+	for _, e := range entries {
+		// Another process may have moved the file already.
+		err := os.Rename(filepath.Join(commitDir, e.Name()), filepath.Join(dir, e.Name()))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			merr = errors.Join(merr, fmt.Errorf("failed to apply %s: %w", e.Name(), err))
+		}
+	}
+	if merr != nil {
+		return fmt.Errorf("failed to recover transaction: %w", merr)
+	}
+	if err == nil {
+		if err := os.Remove(commitDir); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove committed transaction: %w", err)
+		}
+	}
+
+	removeAbandoned(dir)
+	return nil
+}
+
+// removeAbandoned removes staging directories in dir older than abandonedAge.
+// Errors are ignored, as abandoned directories are only untidy.
+func removeAbandoned(dir string) {
+	matches, err := filepath.Glob(filepath.Join(dir, stagingDirPrefix+"*"))
+	if err != nil {
+		return
+	}
+	for _, m := range matches {
+		if fi, err := os.Stat(m); err == nil && time.Since(fi.ModTime()) > abandonedAge {
+			os.RemoveAll(m)
+		}
+	}
+}
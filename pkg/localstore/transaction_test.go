@@ -0,0 +1,247 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package localstore
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/abcxyz/pkg/testutil"
+)
+
+func TestTransaction_Commit(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name      string
+		fs        map[string]string
+		writes    map[string]testObj
+		wantFS    map[string]string
+		wantError string
+	}{
+		{
+			name: "writes_all_files",
+			fs: map[string]string{
+				"a.json":     testToJSON(t, testObj{Foo: "old"}),
+				"other.json": testToJSON(t, testObj{Foo: "untouched"}),
+			},
+			writes: map[string]testObj{
+				"a.json": {Foo: "a"},
+				"b.json": {Foo: "b"},
+			},
+			wantFS: map[string]string{
+				"a.json":     testToJSON(t, testObj{Foo: "a"}),
+				"b.json":     testToJSON(t, testObj{Foo: "b"}),
+				"other.json": testToJSON(t, testObj{Foo: "untouched"}),
+			},
+		},
+		{
+			name: "applies_earlier_transaction_first",
+			fs: map[string]string{
+				commitDirName + "/a.json": testToJSON(t, testObj{Foo: "earlier"}),
+				commitDirName + "/c.json": testToJSON(t, testObj{Foo: "earlier"}),
+			},
+			writes: map[string]testObj{
+				"a.json": {Foo: "a"},
+			},
+			wantFS: map[string]string{
+				"a.json": testToJSON(t, testObj{Foo: "a"}),
+				"c.json": testToJSON(t, testObj{Foo: "earlier"}),
+			},
+		},
+		{
+			name:      "invalid_name",
+			writes:    map[string]testObj{"../a.json": {Foo: "a"}},
+			wantFS:    map[string]string{},
+			wantError: "invalid transaction file name",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			base := t.TempDir()
+			if err := os.MkdirAll(filepath.Join(base, commitDirName), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			testPopulateFiles(t, base, tc.fs)
+
+			txn := NewTransaction(base)
+			var err error
+			for name, data := range tc.writes {
+				data := data
+				if err = txn.StoreJSONFile(name, &data); err != nil {
+					break
+				}
+			}
+			if err == nil {
+				err = txn.Commit()
+			}
+			if diff := testutil.DiffErrString(err, tc.wantError); diff != "" {
+				t.Errorf("unexpected err: %s", diff)
+			}
+
+			if tc.wantError == "" {
+				// Nothing is left to recover.
+				if _, err := os.Stat(filepath.Join(base, commitDirName)); !os.IsNotExist(err) {
+					t.Errorf("expected commit directory to be removed, got %v", err)
+				}
+			}
+			got := loadDirContents(t, base)
+			if diff := cmp.Diff(got, tc.wantFS); diff != "" {
+				t.Errorf("got unexpected file system state:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestTransaction_Rollback(t *testing.T) {
+	t.Parallel()
+
+	base := t.TempDir()
+	txn := NewTransaction(base)
+	if err := txn.StoreJSONFile("a.json", &testObj{Foo: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	txn.Rollback()
+	if err := txn.Commit(); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	if diff := cmp.Diff(loadDirContents(t, base), map[string]string{}); diff != "" {
+		t.Errorf("got unexpected file system state:\n%s", diff)
+	}
+}
+
+func TestTransaction_StoreVersionedJSONFile(t *testing.T) {
+	t.Parallel()
+
+	base := t.TempDir()
+	schema := NewSchema(2).
+		Register(0, func(map[string]json.RawMessage) error { return nil }).
+		Register(1, func(map[string]json.RawMessage) error { return nil })
+
+	txn := NewTransaction(base)
+	if err := txn.StoreVersionedJSONFile("a.json", schema, &testObj{Foo: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Commit(); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	var got struct {
+		testObj
+		SchemaVersion int `json:"schemaVersion"`
+	}
+	if err := LoadJSONFile(filepath.Join(base, "a.json"), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Foo != "a" || got.SchemaVersion != 2 {
+		t.Errorf("got %+v, want foo %q and schema version 2", got, "a")
+	}
+}
+
+func TestRecover(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		fs      map[string]string
+		dirs    []string
+		oldDirs []string
+		wantFS  map[string]string
+	}{
+		{
+			name: "nothing_to_recover",
+			fs:   map[string]string{"a.json": testToJSON(t, testObj{Foo: "a"})},
+			wantFS: map[string]string{
+				"a.json": testToJSON(t, testObj{Foo: "a"}),
+			},
+		},
+		{
+			name: "rolls_forward_committed",
+			dirs: []string{commitDirName},
+			fs: map[string]string{
+				// b.json was already applied before the crash.
+				"a.json":                  testToJSON(t, testObj{Foo: "old"}),
+				"b.json":                  testToJSON(t, testObj{Foo: "b"}),
+				commitDirName + "/a.json": testToJSON(t, testObj{Foo: "a"}),
+			},
+			wantFS: map[string]string{
+				"a.json": testToJSON(t, testObj{Foo: "a"}),
+				"b.json": testToJSON(t, testObj{Foo: "b"}),
+			},
+		},
+		{
+			name:    "discards_abandoned_staging",
+			oldDirs: []string{stagingDirPrefix + "1"},
+			fs: map[string]string{
+				"a.json":                      testToJSON(t, testObj{Foo: "old"}),
+				stagingDirPrefix + "1/a.json": testToJSON(t, testObj{Foo: "a"}),
+			},
+			wantFS: map[string]string{
+				"a.json": testToJSON(t, testObj{Foo: "old"}),
+			},
+		},
+		{
+			name: "keeps_recent_staging",
+			dirs: []string{stagingDirPrefix + "1"},
+			fs: map[string]string{
+				stagingDirPrefix + "1/a.json": testToJSON(t, testObj{Foo: "a"}),
+			},
+			wantFS: map[string]string{
+				stagingDirPrefix + "1/a.json": testToJSON(t, testObj{Foo: "a"}),
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			base := t.TempDir()
+			for _, d := range append(tc.dirs, tc.oldDirs...) {
+				if err := os.MkdirAll(filepath.Join(base, d), 0o755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			testPopulateFiles(t, base, tc.fs)
+			old := time.Now().Add(-2 * abandonedAge)
+			for _, d := range tc.oldDirs {
+				if err := os.Chtimes(filepath.Join(base, d), old, old); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := Recover(base); err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+
+			if diff := cmp.Diff(loadDirContents(t, base), tc.wantFS); diff != "" {
+				t.Errorf("got unexpected file system state:\n%s", diff)
+			}
+		})
+	}
+}
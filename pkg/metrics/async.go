@@ -0,0 +1,225 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/abcxyz/pkg/logging"
+)
+
+// defaultCloseTimeout bounds Close when the provided context has no deadline.
+const defaultCloseTimeout = 2 * time.Second
+
+// pendingWrites tracks in-flight async writes. Unlike sync.WaitGroup, waiting
+// can be abandoned when a context is done, and new writes may be started while
+// another goroutine is waiting.
+type pendingWrites struct {
+	mu     sync.Mutex
+	n      int
+	idle   chan struct{}
+	closed bool
+}
+
+// add registers a new in-flight write. Returns false if closed.
+func (p *pendingWrites) add() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return false
+	}
+	if p.n == 0 {
+		p.idle = make(chan struct{})
+	}
+	p.n++
+	return true
+}
+
+func (p *pendingWrites) done() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.n--
+	if p.n == 0 {
+		close(p.idle)
+	}
+}
+
+// wait returns a channel which is closed once there are no in-flight writes.
+func (p *pendingWrites) wait() <-chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.n == 0 {
+		ch := make(chan struct{})
+		close(ch)
+		return ch
+	}
+	return p.idle
+}
+
+func (p *pendingWrites) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+}
+
+// queuedWrite is a write waiting for an in-flight write of the same metric.
+type queuedWrite struct {
+	ctx   context.Context //nolint:containedctx // Used once the in-flight write finishes.
+	count int64
+}
+
+// coalescer combines async writes of a metric made while one is in flight,
+// so rapid events are sent as one request rather than one each.
+type coalescer struct {
+	mu sync.Mutex
+	// inFlight has an entry for each metric being written, with any write
+	// queued behind it, or nil.
+	inFlight map[string]*queuedWrite
+}
+
+// start returns true if the caller should write the metric. Otherwise a write
+// is in flight, and count is added to the write queued behind it, which is
+// made with ctx.
+func (q *coalescer) start(ctx context.Context, name string, count int64) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.inFlight == nil {
+		q.inFlight = make(map[string]*queuedWrite)
+	}
+	queued, ok := q.inFlight[name]
+	if !ok {
+		q.inFlight[name] = nil
+		return true
+	}
+	if queued == nil {
+		q.inFlight[name] = &queuedWrite{ctx: ctx, count: count}
+		return false
+	}
+	queued.ctx = ctx
+	queued.count += count
+	return false
+}
+
+// next returns the write queued for the metric once the in-flight one is
+// done, or nil if there is none, in which case the metric is no longer in
+// flight.
+func (q *coalescer) next(name string) *queuedWrite {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	queued := q.inFlight[name]
+	if queued == nil {
+		delete(q.inFlight, name)
+		return nil
+	}
+	q.inFlight[name] = nil
+	return queued
+}
+
+// AsyncMetricWriter is a MetricWriter which can also write metrics without
+// blocking. The MetricWriters returned by New and NoopWriter implement it.
+type AsyncMetricWriter interface {
+	MetricWriter
+
+	// WriteMetricAsync calls WriteMetric in a goroutine. Errors are logged at
+	// debug level rather than returned.
+	WriteMetricAsync(ctx context.Context, name string, count int64)
+
+	// Flush blocks until all outstanding WriteMetricAsync calls have finished
+	// or the context is done.
+	Flush(ctx context.Context) error
+
+	// Close stops accepting new async writes and flushes outstanding ones. If
+	// ctx has no deadline, a default bound is applied so Close cannot block
+	// program exit indefinitely.
+	Close(ctx context.Context) error
+}
+
+// writeMetricAsync writes with mw's WriteMetricAsync if it is an
+// AsyncMetricWriter, or else with WriteMetric, logging any error.
+func writeMetricAsync(ctx context.Context, mw MetricWriter, name string, count int64) {
+	if aw, ok := mw.(AsyncMetricWriter); ok {
+		aw.WriteMetricAsync(ctx, name, count)
+		return
+	}
+	if err := mw.WriteMetric(ctx, name, count); err != nil {
+		logging.FromContext(ctx).DebugContext(ctx, "failed to write metric",
+			"name", name, "count", count, "error", err.Error())
+	}
+}
+
+// WriteMetricAsync sends information about application usage without
+// blocking. Noop if the metric is opted out or the client is closed. Use Flush
+// or Close to wait for outstanding writes before the program exits.
+//
+// Writes of a metric made while one is in flight are coalesced: their counts
+// are summed and sent in a single request once it finishes.
+func (c *client) WriteMetricAsync(ctx context.Context, name string, count int64) {
+	if c.OptOut || c.Config.MetricOptedOut(name) {
+		return
+	}
+
+	logger := logging.FromContext(ctx)
+	if !c.pending.add() {
+		logger.DebugContext(ctx, "metrics client closed, dropping metric", "name", name)
+		return
+	}
+	if !c.coalesced.start(ctx, name, count) {
+		// The in-flight write sends it, and is already pending.
+		c.pending.done()
+		return
+	}
+
+	go func() {
+		defer c.pending.done()
+		for w := (&queuedWrite{ctx: ctx, count: count}); w != nil; w = c.coalesced.next(name) {
+			if err := c.WriteMetric(w.ctx, name, w.count); err != nil {
+				logging.FromContext(w.ctx).DebugContext(w.ctx, "failed to write metric",
+					"name", name, "count", w.count, "error", err.Error())
+			}
+		}
+	}()
+}
+
+// Flush sends accumulated counter totals, then blocks until all outstanding
+// WriteMetricAsync calls have finished or the context is done.
+func (c *client) Flush(ctx context.Context) error {
+	countersErr := c.flushCounters(ctx)
+
+	select {
+	case <-c.pending.wait():
+		return countersErr
+	case <-ctx.Done():
+		return fmt.Errorf("timed out waiting for metrics to flush: %w", ctx.Err())
+	}
+}
+
+// Close stops accepting new async writes and counter increments, then flushes
+// outstanding ones. If ctx has no deadline, waiting is bounded to 2 seconds.
+func (c *client) Close(ctx context.Context) error {
+	c.pending.close()
+	c.counters.close()
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel func()
+		ctx, cancel = context.WithTimeout(ctx, defaultCloseTimeout)
+		defer cancel()
+	}
+	return c.Flush(ctx)
+}
@@ -0,0 +1,158 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/abcxyz/pkg/testutil"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWriteMetricAsync_Flush(t *testing.T) {
+	t.Parallel()
+
+	var received atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		received.Add(1)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(ts.Close)
+
+	ctx := context.Background()
+	c := defaultClient()
+	c.Config.ServerURL = ts.URL
+
+	c.WriteMetricAsync(ctx, "foo", 1)
+	c.WriteMetricAsync(ctx, "bar", 1)
+	if err := c.Flush(ctx); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if got, want := received.Load(), int64(2); got != want {
+		t.Errorf("unexpected number of requests after flush. got %d want %d", got, want)
+	}
+
+	if err := c.Close(ctx); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	c.WriteMetricAsync(ctx, "baz", 1)
+	if err := c.Flush(ctx); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if got, want := received.Load(), int64(2); got != want {
+		t.Errorf("write after close was sent. got %d requests want %d", got, want)
+	}
+}
+
+func TestWriteMetricAsync_Coalesces(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var got []map[string]int64
+	started := make(chan struct{}, 10)
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req SendMetricRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("failed to decode request: %s", err.Error())
+		}
+		started <- struct{}{}
+		<-release
+		mu.Lock()
+		got = append(got, req.Metrics)
+		mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(ts.Close)
+
+	ctx := context.Background()
+	c := defaultClient()
+	c.Config.ServerURL = ts.URL
+	c.HTTPClient = &http.Client{}
+
+	// The first write of each metric is sent immediately. The rest are made
+	// while it is in flight, so are sent together once it finishes.
+	c.WriteMetricAsync(ctx, "foo", 1)
+	c.WriteMetricAsync(ctx, "bar", 1)
+	<-started
+	<-started
+	for i := 0; i < 5; i++ {
+		c.WriteMetricAsync(ctx, "foo", 2)
+	}
+	close(release)
+	if err := c.Flush(ctx); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	want := map[string]int64{"foo": 11, "bar": 1}
+	sums := make(map[string]int64)
+	for _, m := range got {
+		for name, count := range m {
+			sums[name] += count
+		}
+	}
+	if diff := cmp.Diff(sums, want); diff != "" {
+		t.Errorf("unexpected totals (-got,+want): %s", diff)
+	}
+	if got, want := len(got), 3; got != want {
+		t.Errorf("unexpected number of requests. got %d want %d", got, want)
+	}
+}
+
+func TestFlush_ContextDone(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(func() {
+		close(release)
+		ts.Close()
+	})
+
+	c := defaultClient()
+	c.Config.ServerURL = ts.URL
+	c.HTTPClient = &http.Client{}
+
+	c.WriteMetricAsync(context.Background(), "foo", 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := c.Close(ctx)
+	if diff := testutil.DiffErrString(err, "timed out waiting for metrics to flush"); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestFlush_OptOutNoop(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	c := NoopWriter().(AsyncMetricWriter)
+	c.WriteMetricAsync(ctx, "foo", 1)
+	if err := c.Close(ctx); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+}
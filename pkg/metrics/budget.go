@@ -0,0 +1,171 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/abcxyz/abc-updater/pkg/localstore"
+)
+
+const (
+	// defaultMaxRequestsPerProcess and defaultMaxRequestsPerDay are the
+	// request budget if not set with WithBudget.
+	defaultMaxRequestsPerProcess = 20
+	defaultMaxRequestsPerDay     = 200
+
+	budgetFileName = "budget.json"
+)
+
+// ErrOverBudget is returned (wrapped) by WriteMetric when the metric was
+// dropped because the client's request budget is exhausted. See WithBudget.
+var ErrOverBudget = errors.New("metrics request budget exhausted")
+
+// WithBudget caps the number of requests sent to the metrics server, per
+// process and per day (UTC, across all runs on the machine), so a runaway loop
+// in an application cannot flood the server. Metrics written over budget are
+// dropped, and the number dropped is reported in the next request sent. Zero
+// removes a limit. Defaults to 20 requests per process and 200 per day.
+//
+// Counter flushes are not limited by the budget, as they already send at most
+// one request per flush interval, so prefer counters for frequent events and
+// in long-running processes.
+func WithBudget(maxPerProcess, maxPerDay int) Option {
+	return func(o *options) *options {
+		o.budgetSet = true
+		o.maxRequestsPerProcess = maxPerProcess
+		o.maxRequestsPerDay = maxPerDay
+		return o
+	}
+}
+
+// budgetState defines the json file that persists the daily budget.
+type budgetState struct {
+	// Day is the UTC date the counts are for, e.g. "2024-01-02".
+	Day string `json:"day"`
+	// Sent is the number of requests sent on Day.
+	Sent int `json:"sent"`
+	// Dropped is the number of metrics dropped and not yet reported, on any
+	// day.
+	Dropped int64 `json:"dropped,omitempty"`
+}
+
+// budget limits the requests a client sends. A nil *budget is unlimited.
+type budget struct {
+	// path persists the daily budget. If empty, only the per-process limit
+	// applies.
+	path          string
+	maxPerProcess int
+	maxPerDay     int
+	now           func() time.Time
+
+	mu   sync.Mutex
+	sent int
+	// dropped is used in place of the persisted count if path is empty.
+	dropped int64
+}
+
+// newBudget returns a budget with the given limits, or nil if neither is set.
+func newBudget(path string, maxPerProcess, maxPerDay int) *budget {
+	if maxPerProcess <= 0 && maxPerDay <= 0 {
+		return nil
+	}
+	return &budget{
+		path:          path,
+		maxPerProcess: maxPerProcess,
+		maxPerDay:     maxPerDay,
+		now:           time.Now,
+	}
+}
+
+// reserve reserves a request, returning false if the budget is exhausted.
+// Otherwise it also returns the number of dropped metrics to report in the
+// request, which must be returned with unreport if the request fails.
+func (b *budget) reserve() (bool, int64) {
+	if b == nil {
+		return true, 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.maxPerProcess > 0 && b.sent >= b.maxPerProcess {
+		return false, 0
+	}
+
+	if b.path == "" {
+		b.sent++
+		dropped := b.dropped
+		b.dropped = 0
+		return true, dropped
+	}
+
+	s := b.load()
+	if b.maxPerDay > 0 && s.Sent >= b.maxPerDay {
+		return false, 0
+	}
+	b.sent++
+	s.Sent++
+	dropped := s.Dropped
+	s.Dropped = 0
+	b.store(s)
+	return true, dropped
+}
+
+// drop records n metrics dropped over budget.
+func (b *budget) drop(n int64) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.path == "" {
+		b.dropped += n
+		return
+	}
+	s := b.load()
+	s.Dropped += n
+	b.store(s)
+}
+
+// unreport returns dropped counts from reserve which were not delivered.
+func (b *budget) unreport(n int64) {
+	if n > 0 {
+		b.drop(n)
+	}
+}
+
+// load returns the persisted budget for today, or a new one if it cannot be
+// loaded or is for an earlier day. Unreported drops carry over.
+func (b *budget) load() *budgetState {
+	today := b.now().UTC().Format(time.DateOnly)
+	var s budgetState
+	if err := localstore.LoadJSONFile(b.path, &s); err != nil {
+		return &budgetState{Day: today}
+	}
+	if s.Day != today {
+		return &budgetState{Day: today, Dropped: s.Dropped}
+	}
+	return &s
+}
+
+// store persists s. The budget is best effort, so errors are ignored.
+func (b *budget) store(s *budgetState) {
+	_ = localstore.StoreJSONFile(b.path, s)
+}
@@ -0,0 +1,278 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/sethvargo/go-envconfig"
+)
+
+func TestBudget_Reserve(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name          string
+		maxPerProcess int
+		maxPerDay     int
+		persist       bool
+		want          []bool
+	}{
+		{
+			name:          "per_process",
+			maxPerProcess: 2,
+			want:          []bool{true, true, false},
+		},
+		{
+			name:      "per_day",
+			maxPerDay: 2,
+			persist:   true,
+			want:      []bool{true, true, false},
+		},
+		{
+			name:          "per_process_before_per_day",
+			maxPerProcess: 1,
+			maxPerDay:     2,
+			persist:       true,
+			want:          []bool{true, false, false},
+		},
+		{
+			name:      "per_day_not_persisted",
+			maxPerDay: 1,
+			want:      []bool{true, true, true},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var path string
+			if tc.persist {
+				path = filepath.Join(t.TempDir(), budgetFileName)
+			}
+			b := newBudget(path, tc.maxPerProcess, tc.maxPerDay)
+
+			got := make([]bool, 0, len(tc.want))
+			for range tc.want {
+				ok, _ := b.reserve()
+				got = append(got, ok)
+			}
+			if diff := cmp.Diff(got, tc.want); diff != "" {
+				t.Errorf("unexpected reservations (-got,+want): %s", diff)
+			}
+		})
+	}
+}
+
+func TestBudget_PerDayAcrossProcesses(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 1, 2, 23, 0, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), budgetFileName)
+	newDayBudget := func() *budget {
+		b := newBudget(path, 0, 1)
+		b.now = func() time.Time { return now }
+		return b
+	}
+
+	if ok, _ := newDayBudget().reserve(); !ok {
+		t.Fatal("expected first request of the day to be allowed")
+	}
+
+	// A later run on the same day is over budget, and its drop is persisted.
+	b := newDayBudget()
+	if ok, _ := b.reserve(); ok {
+		t.Fatal("expected second request of the day to be over budget")
+	}
+	b.drop(3)
+
+	// The next day, the budget resets and the drops are reported once.
+	now = now.Add(2 * time.Hour)
+	b = newDayBudget()
+	ok, dropped := b.reserve()
+	if !ok {
+		t.Fatal("expected request on the next day to be allowed")
+	}
+	if got, want := dropped, int64(3); got != want {
+		t.Errorf("unexpected dropped count. got %d want %d", got, want)
+	}
+
+	// Drops which were not delivered are reported by the next request.
+	b.unreport(dropped)
+	b = newBudget(path, 0, 3)
+	b.now = func() time.Time { return now }
+	for _, want := range []int64{3, 0} {
+		if _, got := b.reserve(); got != want {
+			t.Errorf("unexpected dropped count. got %d want %d", got, want)
+		}
+	}
+}
+
+func TestBudget_PerDayKeepsProcessAllowance(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 1, 2, 23, 0, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), budgetFileName)
+
+	// An earlier run used the day's budget.
+	other := newBudget(path, 0, 1)
+	other.now = func() time.Time { return now }
+	if ok, _ := other.reserve(); !ok {
+		t.Fatal("expected first request of the day to be allowed")
+	}
+
+	b := newBudget(path, 1, 1)
+	b.now = func() time.Time { return now }
+	if ok, _ := b.reserve(); ok {
+		t.Fatal("expected request to be over the daily budget")
+	}
+
+	// The rejected request did not use the process's only request.
+	now = now.Add(2 * time.Hour)
+	if ok, _ := b.reserve(); !ok {
+		t.Error("expected request on the next day to be allowed")
+	}
+}
+
+func TestCounter_DefaultBudget(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(ts.Close)
+
+	mw, err := New(context.Background(), testAppID, testVersion,
+		WithLookuper(envconfig.MapLookuper(map[string]string{"METRICS_URL": ts.URL})),
+		WithInstallIDFileOverride(filepath.Join(t.TempDir(), installIDFileName)),
+		WithAllowInsecureLocalhost())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	cw, ok := mw.(CounterWriter)
+	if !ok {
+		t.Fatalf("expected CounterWriter, got %T", mw)
+	}
+	t.Cleanup(func() { cw.Close(context.Background()) })
+
+	// Many more flushes than the default per-process budget, as from a
+	// long-running process.
+	const flushes = 2 * defaultMaxRequestsPerProcess
+	counter := cw.Counter("foo")
+	for i := 0; i < flushes; i++ {
+		counter.Inc()
+		if err := cw.Flush(context.Background()); err != nil {
+			t.Fatalf("flush %d: unexpected error: %s", i, err.Error())
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if got, want := requests, flushes; got != want {
+		t.Errorf("unexpected number of requests. got %d want %d", got, want)
+	}
+}
+
+func TestBudget_Nil(t *testing.T) {
+	t.Parallel()
+
+	b := newBudget("", 0, 0)
+	if b != nil {
+		t.Fatalf("expected nil budget when unlimited, got %#v", b)
+	}
+	for i := 0; i < 100; i++ {
+		if ok, _ := b.reserve(); !ok {
+			t.Fatalf("request %d: expected nil budget to allow every request", i)
+		}
+	}
+	b.drop(1)
+	b.unreport(1)
+}
+
+func TestWriteMetric_Budget(t *testing.T) {
+	t.Parallel()
+
+	var gotDropped []int64
+	fail := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req SendMetricRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("failed to decode request: %s", err.Error())
+		}
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		gotDropped = append(gotDropped, req.Dropped)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(ts.Close)
+
+	mw, err := New(context.Background(), testAppID, testVersion,
+		WithLookuper(envconfig.MapLookuper(map[string]string{"METRICS_URL": ts.URL})),
+		WithInstallIDFileOverride(filepath.Join(t.TempDir(), installIDFileName)),
+		WithAllowInsecureLocalhost(),
+		WithBudget(3, 0))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	c, ok := mw.(*client)
+	if !ok {
+		t.Fatalf("expected *client, got %T", mw)
+	}
+
+	ctx := context.Background()
+	if err := mw.WriteMetric(ctx, "foo", 1); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	// As if two metrics were dropped by an earlier run.
+	c.Budget.drop(2)
+
+	// Drops are kept for the next request if sending fails.
+	fail = true
+	if err := mw.WriteMetric(ctx, "foo", 1); err == nil {
+		t.Fatal("expected error from failing server")
+	}
+	fail = false
+
+	if err := mw.WriteMetric(ctx, "foo", 1); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if err := mw.WriteMetric(ctx, "foo", 1); !errors.Is(err, ErrOverBudget) {
+		t.Errorf("expected ErrOverBudget, got %v", err)
+	}
+
+	if diff := cmp.Diff(gotDropped, []int64{0, 2}); diff != "" {
+		t.Errorf("unexpected dropped counts (-got,+want): %s", diff)
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"runtime/debug"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+)
+
+// BuildInfo identifies the build of the app sending metrics.
+type BuildInfo = api.BuildInfo
+
+// WithBuildInfo sends the commit, commit date, Go version, and builder of the
+// running binary with each metric, from runtime/debug.ReadBuildInfo, so owners
+// can correlate issues with specific builds. The server only records it for
+// apps which allow build info.
+func WithBuildInfo() Option {
+	return func(o *options) *options {
+		o.buildInfo = true
+		return o
+	}
+}
+
+// readBuildInfo returns the BuildInfo of the running binary, or nil if it is
+// not available.
+func readBuildInfo() *BuildInfo {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return nil
+	}
+	return buildInfoFrom(info)
+}
+
+// buildInfoFrom extracts BuildInfo from the settings recorded by the Go
+// toolchain. VCS settings are only present for binaries built with
+// "go build" in a checkout.
+func buildInfoFrom(info *debug.BuildInfo) *BuildInfo {
+	settings := make(map[string]string, len(info.Settings))
+	for _, s := range info.Settings {
+		settings[s.Key] = s.Value
+	}
+
+	b := &BuildInfo{
+		Commit:    settings["vcs.revision"],
+		Date:      settings["vcs.time"],
+		GoVersion: info.GoVersion,
+	}
+	if b.Commit != "" && settings["vcs.modified"] == "true" {
+		b.Commit += "-dirty"
+	}
+	if compiler := settings["-compiler"]; compiler != "" {
+		b.Builder = compiler + " " + settings["GOOS"] + "/" + settings["GOARCH"]
+	}
+	return b
+}
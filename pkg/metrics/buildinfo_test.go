@@ -0,0 +1,76 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"runtime/debug"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestBuildInfoFrom(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		settings []debug.BuildSetting
+		want     *BuildInfo
+	}{
+		{
+			name: "vcs",
+			settings: []debug.BuildSetting{
+				{Key: "-compiler", Value: "gc"},
+				{Key: "GOARCH", Value: "arm64"},
+				{Key: "GOOS", Value: "darwin"},
+				{Key: "vcs.revision", Value: "0123abcd"},
+				{Key: "vcs.time", Value: "2024-05-01T12:00:00Z"},
+				{Key: "vcs.modified", Value: "false"},
+			},
+			want: &BuildInfo{
+				Commit:    "0123abcd",
+				Date:      "2024-05-01T12:00:00Z",
+				GoVersion: "go1.22.1",
+				Builder:   "gc darwin/arm64",
+			},
+		},
+		{
+			name: "dirty",
+			settings: []debug.BuildSetting{
+				{Key: "vcs.revision", Value: "0123abcd"},
+				{Key: "vcs.modified", Value: "true"},
+			},
+			want: &BuildInfo{
+				Commit:    "0123abcd-dirty",
+				GoVersion: "go1.22.1",
+			},
+		},
+		{
+			name: "no_vcs",
+			want: &BuildInfo{GoVersion: "go1.22.1"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := buildInfoFrom(&debug.BuildInfo{GoVersion: "go1.22.1", Settings: tc.settings})
+			if diff := cmp.Diff(got, tc.want); diff != "" {
+				t.Errorf("unexpected build info (-got,+want): %s", diff)
+			}
+		})
+	}
+}
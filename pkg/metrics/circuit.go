@@ -0,0 +1,178 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/abcxyz/abc-updater/pkg/failover"
+	"github.com/abcxyz/abc-updater/pkg/localstore"
+)
+
+const (
+	// defaultCircuitFailures and defaultCircuitOpenFor configure the circuit
+	// breaker if not set with WithCircuitBreaker.
+	defaultCircuitFailures = 3
+	defaultCircuitOpenFor  = 15 * time.Minute
+
+	// circuitProbeTimeout is how long a half-open probe request is waited for
+	// before another process may probe.
+	circuitProbeTimeout = time.Minute
+
+	circuitFileName = "circuit.json"
+)
+
+// ErrCircuitOpen is returned (wrapped) by WriteMetric when the metric was not
+// sent because recent requests to the metrics server all failed. See
+// WithCircuitBreaker.
+var ErrCircuitOpen = errors.New("metrics server failing, circuit breaker open")
+
+// WithCircuitBreaker stops sending requests after failures consecutive
+// requests fail, e.g. because the server is down or times out, across all
+// runs on the machine. For openFor, metrics are dropped immediately instead.
+// After that a single request probes the server: if it succeeds, sending
+// resumes, otherwise the breaker stays open for another openFor. Error
+// responses other than 429 and 5xx show the server is up, so count as
+// successes. Zero failures disables the breaker. Defaults to 3 failures and
+// 15 minutes.
+func WithCircuitBreaker(failures int, openFor time.Duration) Option {
+	return func(o *options) *options {
+		o.circuitSet = true
+		o.circuitFailures = failures
+		o.circuitOpenFor = openFor
+		return o
+	}
+}
+
+// circuitState defines the json file that persists the circuit breaker.
+type circuitState struct {
+	// Failures is the number of consecutive failed requests.
+	Failures int `json:"failures"`
+	// OpenUntil is when the next request may probe the server, in UTC epoch
+	// seconds.
+	OpenUntil int64 `json:"openUntil,omitempty"`
+	// ProbeUntil is when a pending probe is given up on, in UTC epoch
+	// seconds. No other request is sent until then.
+	ProbeUntil int64 `json:"probeUntil,omitempty"`
+}
+
+// circuit is a circuit breaker for requests to the metrics server. A nil
+// *circuit never opens.
+type circuit struct {
+	// path persists the breaker. If empty, it only applies to this process.
+	path      string
+	threshold int
+	openFor   time.Duration
+	now       func() time.Time
+
+	mu sync.Mutex
+	// state is used in place of the persisted state if path is empty.
+	state circuitState
+}
+
+// newCircuit returns a circuit which opens for openFor after threshold
+// consecutive failures, or nil if threshold is not positive.
+func newCircuit(path string, threshold int, openFor time.Duration) *circuit {
+	if threshold <= 0 {
+		return nil
+	}
+	if openFor <= 0 {
+		openFor = defaultCircuitOpenFor
+	}
+	return &circuit{
+		path:      path,
+		threshold: threshold,
+		openFor:   openFor,
+		now:       time.Now,
+	}
+}
+
+// allow returns an error wrapping ErrCircuitOpen if a request should not be
+// sent. Once the breaker has been open for openFor, the first caller is
+// allowed to probe the server, and the rest are refused until the probe's
+// result is recorded or circuitProbeTimeout passes.
+func (c *circuit) allow() error {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := c.load()
+	if s.Failures < c.threshold {
+		return nil
+	}
+	now := c.now().Unix()
+	if until := max(s.OpenUntil, s.ProbeUntil); now < until {
+		return fmt.Errorf("%w after %d failures, retrying after %s",
+			ErrCircuitOpen, s.Failures, time.Unix(until, 0).UTC().Format(time.RFC3339))
+	}
+	s.ProbeUntil = now + int64(circuitProbeTimeout/time.Second)
+	c.store(s)
+	return nil
+}
+
+// record updates the breaker with the result of a request allowed by allow.
+// Requests which were canceled, or were not sent because servers asked
+// clients to wait, say nothing about the server and are ignored.
+func (c *circuit) record(ctx context.Context, err error) {
+	if c == nil || ctx.Err() != nil || errors.Is(err, failover.ErrCoolingDown) {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := c.load()
+	if err == nil || !failover.Retryable(err) {
+		if *s != (circuitState{}) {
+			c.store(&circuitState{})
+		}
+		return
+	}
+	s.Failures++
+	s.ProbeUntil = 0
+	if s.Failures >= c.threshold {
+		s.OpenUntil = c.now().Add(c.openFor).Unix()
+	}
+	c.store(s)
+}
+
+// load returns the persisted state, or a closed state if it cannot be loaded.
+func (c *circuit) load() *circuitState {
+	if c.path == "" {
+		s := c.state
+		return &s
+	}
+	var s circuitState
+	if err := localstore.LoadJSONFile(c.path, &s); err != nil {
+		return &circuitState{}
+	}
+	return &s
+}
+
+// store saves s. The breaker is best effort, so errors are ignored.
+func (c *circuit) store(s *circuitState) {
+	if c.path == "" {
+		c.state = *s
+		return
+	}
+	_ = localstore.StoreJSONFile(c.path, s)
+}
@@ -0,0 +1,260 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-envconfig"
+
+	"github.com/abcxyz/abc-updater/pkg/apierror"
+	"github.com/abcxyz/abc-updater/pkg/failover"
+)
+
+func TestCircuit(t *testing.T) {
+	t.Parallel()
+
+	errDown := errors.New("connection refused")
+	errBadRequest := &apierror.Error{StatusCode: http.StatusBadRequest}
+	errUnavailable := &apierror.Error{StatusCode: http.StatusServiceUnavailable}
+
+	// step is a request at a time, in minutes from the start, which is
+	// expected to be allowed or not, with the result recorded if allowed.
+	type step struct {
+		minute    int
+		wantAllow bool
+		result    error
+		canceled  bool
+	}
+
+	cases := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "opens_after_consecutive_failures",
+			steps: []step{
+				{minute: 0, wantAllow: true, result: errDown},
+				{minute: 0, wantAllow: true, result: errUnavailable},
+				{minute: 0, wantAllow: true, result: errDown},
+				{minute: 1, wantAllow: false},
+				{minute: 9, wantAllow: false},
+			},
+		},
+		{
+			name: "success_resets",
+			steps: []step{
+				{minute: 0, wantAllow: true, result: errDown},
+				{minute: 0, wantAllow: true, result: errDown},
+				{minute: 0, wantAllow: true},
+				{minute: 0, wantAllow: true, result: errDown},
+				{minute: 0, wantAllow: true, result: errDown},
+				{minute: 0, wantAllow: true},
+			},
+		},
+		{
+			name: "client_errors_count_as_success",
+			steps: []step{
+				{minute: 0, wantAllow: true, result: errDown},
+				{minute: 0, wantAllow: true, result: errDown},
+				{minute: 0, wantAllow: true, result: errBadRequest},
+				{minute: 0, wantAllow: true, result: errDown},
+				{minute: 0, wantAllow: true},
+			},
+		},
+		{
+			name: "ignored_results",
+			steps: []step{
+				{minute: 0, wantAllow: true, result: errDown},
+				{minute: 0, wantAllow: true, result: errDown},
+				{minute: 0, wantAllow: true, result: errDown, canceled: true},
+				{minute: 0, wantAllow: true, result: fmt.Errorf("skipped: %w", failover.ErrCoolingDown)},
+				{minute: 0, wantAllow: true, result: errDown},
+				{minute: 0, wantAllow: false},
+			},
+		},
+		{
+			name: "half_open_probe_succeeds",
+			steps: []step{
+				{minute: 0, wantAllow: true, result: errDown},
+				{minute: 0, wantAllow: true, result: errDown},
+				{minute: 0, wantAllow: true, result: errDown},
+				{minute: 10, wantAllow: true},
+				{minute: 10, wantAllow: true},
+			},
+		},
+		{
+			name: "half_open_probe_fails",
+			steps: []step{
+				{minute: 0, wantAllow: true, result: errDown},
+				{minute: 0, wantAllow: true, result: errDown},
+				{minute: 0, wantAllow: true, result: errDown},
+				{minute: 10, wantAllow: true, result: errDown},
+				{minute: 19, wantAllow: false},
+				{minute: 20, wantAllow: true},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			start := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+			var now time.Time
+			c := newCircuit(filepath.Join(t.TempDir(), circuitFileName), 3, 10*time.Minute)
+			c.now = func() time.Time { return now }
+
+			for i, s := range tc.steps {
+				now = start.Add(time.Duration(s.minute) * time.Minute)
+				err := c.allow()
+				if got := err == nil; got != s.wantAllow {
+					t.Fatalf("step %d: unexpected allow. got %t want %t (%v)", i, got, s.wantAllow, err)
+				}
+				if err != nil {
+					if !errors.Is(err, ErrCircuitOpen) {
+						t.Errorf("step %d: expected ErrCircuitOpen, got %v", i, err)
+					}
+					continue
+				}
+				ctx, cancel := context.WithCancel(context.Background())
+				if s.canceled {
+					cancel()
+				}
+				c.record(ctx, s.result)
+				cancel()
+			}
+		})
+	}
+}
+
+func TestCircuit_ProbeAcrossProcesses(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), circuitFileName)
+	newRunCircuit := func() *circuit {
+		c := newCircuit(path, 1, 10*time.Minute)
+		c.now = func() time.Time { return now }
+		return c
+	}
+
+	c := newRunCircuit()
+	if err := c.allow(); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	c.record(context.Background(), errors.New("timeout"))
+
+	// A later run sees the breaker open.
+	if err := newRunCircuit().allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen, got %v", err)
+	}
+
+	// Once it may probe, only one run does so at a time.
+	now = now.Add(10 * time.Minute)
+	if err := newRunCircuit().allow(); err != nil {
+		t.Fatalf("expected probe to be allowed, got %v", err)
+	}
+	if err := newRunCircuit().allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen during probe, got %v", err)
+	}
+
+	// A probe which never reports back is given up on.
+	now = now.Add(circuitProbeTimeout)
+	if err := newRunCircuit().allow(); err != nil {
+		t.Fatalf("expected a new probe to be allowed, got %v", err)
+	}
+}
+
+func TestCircuit_Nil(t *testing.T) {
+	t.Parallel()
+
+	c := newCircuit("", 0, time.Minute)
+	if c != nil {
+		t.Fatalf("expected nil circuit when disabled, got %#v", c)
+	}
+	for i := 0; i < 10; i++ {
+		if err := c.allow(); err != nil {
+			t.Fatalf("request %d: expected nil circuit to allow every request, got %v", i, err)
+		}
+		c.record(context.Background(), errors.New("timeout"))
+	}
+}
+
+func TestWriteMetric_CircuitBreaker(t *testing.T) {
+	t.Parallel()
+
+	var requests atomic.Int32
+	var fail atomic.Bool
+	fail.Store(true)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if fail.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(ts.Close)
+
+	now := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+	mw, err := New(context.Background(), testAppID, testVersion,
+		WithLookuper(envconfig.MapLookuper(map[string]string{"METRICS_URL": ts.URL})),
+		WithInstallIDFileOverride(filepath.Join(t.TempDir(), installIDFileName)),
+		WithAllowInsecureLocalhost(),
+		WithBudget(0, 0),
+		WithCircuitBreaker(2, time.Hour),
+		WithNowFunc(func() time.Time { return now }))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := mw.WriteMetric(ctx, "foo", 1); err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("request %d: expected server error, got %v", i, err)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		if err := mw.WriteMetric(ctx, "foo", 1); !errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("expected ErrCircuitOpen, got %v", err)
+		}
+	}
+	if got, want := requests.Load(), int32(2); got != want {
+		t.Errorf("unexpected requests while open. got %d want %d", got, want)
+	}
+
+	// After the window, a probe is sent and closes the breaker.
+	fail.Store(false)
+	now = now.Add(time.Hour)
+	for i := 0; i < 2; i++ {
+		if err := mw.WriteMetric(ctx, "foo", 1); err != nil {
+			t.Fatalf("request %d: unexpected error: %s", i, err.Error())
+		}
+	}
+	if got, want := requests.Load(), int32(4); got != want {
+		t.Errorf("unexpected requests after closing. got %d want %d", got, want)
+	}
+}
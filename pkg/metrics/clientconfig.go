@@ -0,0 +1,70 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"context"
+	"math/rand/v2"
+	"path/filepath"
+
+	"github.com/hashicorp/go-version"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+	"github.com/abcxyz/abc-updater/pkg/localstore"
+	"github.com/abcxyz/pkg/logging"
+)
+
+// loadClientConfig returns the server-driven config the updater stored next
+// to the install ID for appVersion, or nil if there is none, or it was stored
+// for another version. The config is only as fresh as the updater's last
+// check.
+func loadClientConfig(ctx context.Context, appID, appVersion, installIDFileOverride string) *api.ClientConfig {
+	path, err := installIDPath(appID, installIDFileOverride)
+	if err != nil {
+		return nil
+	}
+	dir := filepath.Dir(path)
+	// The updater writes the config in a transaction with the version cache,
+	// which may have been interrupted.
+	if err := localstore.Recover(dir); err != nil {
+		logging.FromContext(ctx).DebugContext(ctx, "error recovering local files", "error", err.Error())
+	}
+	var c api.StoredClientConfig
+	if err := localstore.LoadJSONFile(filepath.Join(dir, api.ClientConfigFileName), &c); err != nil {
+		return nil
+	}
+	if !sameVersion(c.AppVersion, appVersion) {
+		return nil
+	}
+	return &c.ClientConfig
+}
+
+// sameVersion reports whether a and b are the same version, e.g. "v1.0.0" and
+// "1.0.0". Versions which cannot be parsed must match exactly.
+func sameVersion(a, b string) bool {
+	va, errA := version.NewVersion(a)
+	vb, errB := version.NewVersion(b)
+	if errA != nil || errB != nil {
+		return a != "" && a == b
+	}
+	return va.Equal(vb)
+}
+
+// sampled reports whether a request should be sent given the sample rate
+// from the app's ClientConfig. Rates of zero, or outside (0, 1), send every
+// request.
+func sampled(rate float64) bool {
+	return rate <= 0 || rate >= 1 || rand.Float64() < rate //nolint:gosec // Sampling does not need a secure source.
+}
@@ -0,0 +1,150 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/sethvargo/go-envconfig"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+	"github.com/abcxyz/abc-updater/pkg/localstore"
+)
+
+func TestNew_ClientConfig(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		config *api.StoredClientConfig
+		// staged leaves config in a committed transaction that was
+		// interrupted before it moved its files into place.
+		staged       bool
+		wantOptOut   bool
+		wantRequests int64
+	}{
+		{
+			name:         "none",
+			wantRequests: 10,
+		},
+		{
+			name: "disable_metrics",
+			config: &api.StoredClientConfig{
+				AppVersion:   testVersion,
+				ClientConfig: api.ClientConfig{DisableMetrics: true},
+			},
+			wantOptOut: true,
+		},
+		{
+			name: "disable_metrics_interrupted_store",
+			config: &api.StoredClientConfig{
+				AppVersion:   testVersion,
+				ClientConfig: api.ClientConfig{DisableMetrics: true},
+			},
+			staged:     true,
+			wantOptOut: true,
+		},
+		{
+			name: "disable_metrics_equivalent_version",
+			config: &api.StoredClientConfig{
+				AppVersion:   "v" + testVersion,
+				ClientConfig: api.ClientConfig{DisableMetrics: true},
+			},
+			wantOptOut: true,
+		},
+		{
+			name: "disable_metrics_other_version",
+			config: &api.StoredClientConfig{
+				AppVersion:   "0.9.0",
+				ClientConfig: api.ClientConfig{DisableMetrics: true},
+			},
+			wantRequests: 10,
+		},
+		{
+			name:         "disable_metrics_without_version",
+			config:       &api.StoredClientConfig{ClientConfig: api.ClientConfig{DisableMetrics: true}},
+			wantRequests: 10,
+		},
+		{
+			name: "sample_rate",
+			config: &api.StoredClientConfig{
+				AppVersion:   testVersion,
+				ClientConfig: api.ClientConfig{MetricsSampleRate: 1e-9},
+			},
+			wantRequests: 0,
+		},
+		{
+			name: "sample_rate_other_version",
+			config: &api.StoredClientConfig{
+				AppVersion:   "0.9.0",
+				ClientConfig: api.ClientConfig{MetricsSampleRate: 1e-9},
+			},
+			wantRequests: 10,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var requests atomic.Int64
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				w.WriteHeader(http.StatusAccepted)
+			}))
+			t.Cleanup(ts.Close)
+
+			dir := t.TempDir()
+			if tc.config != nil {
+				configDir := dir
+				if tc.staged {
+					configDir = filepath.Join(dir, ".txn-commit")
+				}
+				if err := localstore.StoreJSONFile(filepath.Join(configDir, api.ClientConfigFileName), tc.config); err != nil {
+					t.Fatalf("test setup failed: %s", err.Error())
+				}
+			}
+			mw, err := New(context.Background(), testAppID, testVersion,
+				WithLookuper(envconfig.MapLookuper(map[string]string{"METRICS_URL": ts.URL})),
+				WithAllowInsecureLocalhost(),
+				WithInstallIDFileOverride(filepath.Join(dir, installIDFileName)),
+				WithBudget(0, 0))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			c, ok := mw.(*client)
+			if !ok {
+				t.Fatal("Expected New to return client, but cast failed.")
+			}
+			if got, want := c.OptOut, tc.wantOptOut; got != want {
+				t.Errorf("unexpected opt out. got %t want %t", got, want)
+			}
+
+			for i := 0; i < 10; i++ {
+				if err := mw.WriteMetric(context.Background(), "foo", 1); err != nil {
+					t.Fatalf("unexpected error: %s", err.Error())
+				}
+			}
+			if got, want := requests.Load(), tc.wantRequests; got != want {
+				t.Errorf("unexpected number of requests. got %d want %d", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"context"
+)
+
+// clientKey is the context key for the MetricWriter.
+type clientKey struct{}
+
+// WithClient returns a copy of ctx carrying mw, for FromContext.
+func WithClient(ctx context.Context, mw MetricWriter) context.Context {
+	return context.WithValue(ctx, clientKey{}, mw)
+}
+
+// FromContext returns the MetricWriter set by WithClient, or a NoopWriter if
+// there is none, so code deep in an app can record metrics without being
+// passed a client.
+func FromContext(ctx context.Context) MetricWriter {
+	if mw, ok := ctx.Value(clientKey{}).(MetricWriter); ok && mw != nil {
+		return mw
+	}
+	return NoopWriter()
+}
@@ -0,0 +1,34 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"context"
+	"testing"
+)
+
+func TestFromContext(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	if c, ok := FromContext(ctx).(*client); !ok || !c.OptOut {
+		t.Errorf("expected a NoopWriter without a client, got %#v", FromContext(ctx))
+	}
+
+	mw := &client{AppID: testAppID}
+	if got := FromContext(WithClient(ctx, mw)); got != mw {
+		t.Errorf("unexpected client. got %#v want %#v", got, mw)
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/abcxyz/pkg/logging"
+)
+
+const (
+	// defaultFlushInterval is how often counter totals are sent if not set
+	// with WithFlushInterval.
+	defaultFlushInterval = time.Minute
+
+	// maxMetricsPerRequest matches the server's limit on distinct metrics in a
+	// single request.
+	maxMetricsPerRequest = 100
+)
+
+// WithFlushInterval sets how often totals accumulated by counters are sent.
+// Defaults to one minute.
+func WithFlushInterval(d time.Duration) Option {
+	return func(o *options) *options {
+		o.flushInterval = d
+		return o
+	}
+}
+
+// CounterWriter is an AsyncMetricWriter which can also count metrics in
+// memory. The MetricWriters returned by New and NoopWriter implement it.
+type CounterWriter interface {
+	AsyncMetricWriter
+
+	// Counter returns an in-memory Counter for the named metric, whose totals
+	// are sent periodically rather than on every increment.
+	Counter(name string) *Counter
+}
+
+// Counter accumulates increments to a metric in memory, so frequent events do
+// not each cost a network call. Totals from all counters of a CounterWriter are
+// sent together every flush interval, and by Flush and Close. A Counter is
+// safe for concurrent use.
+type Counter struct {
+	name string
+	// agg is nil if the metric is opted out.
+	agg *aggregator
+}
+
+// Add adds n to the counter.
+func (c *Counter) Add(n int64) {
+	if c.agg == nil {
+		return
+	}
+	c.agg.add(c.name, n)
+}
+
+// Inc adds 1 to the counter.
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// aggregator holds counter totals not yet sent. The zero value is ready to
+// use.
+type aggregator struct {
+	mu     sync.Mutex
+	totals map[string]int64
+	// since is when the first of totals was added.
+	since   time.Time
+	started bool
+	closed  bool
+	stop    chan struct{}
+	// now returns the current time. Nil uses time.Now.
+	now func() time.Time
+}
+
+func (a *aggregator) add(name string, n int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return
+	}
+	if a.totals == nil {
+		a.totals = make(map[string]int64)
+		a.since = time.Now()
+		if a.now != nil {
+			a.since = a.now()
+		}
+	}
+	a.totals[name] += n
+}
+
+// take returns and resets the accumulated totals, and when the first of them
+// was added.
+func (a *aggregator) take() (map[string]int64, time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	totals, since := a.totals, a.since
+	a.totals, a.since = nil, time.Time{}
+	return totals, since
+}
+
+// start calls run in a goroutine the first time it is called, unless closed.
+// run should return when the given channel is closed.
+func (a *aggregator) start(run func(stop <-chan struct{})) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.started || a.closed {
+		return
+	}
+	a.started = true
+	a.stop = make(chan struct{})
+	go run(a.stop)
+}
+
+// close stops the goroutine from start, and drops any later increments.
+func (a *aggregator) close() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return
+	}
+	a.closed = true
+	if a.started {
+		close(a.stop)
+	}
+}
+
+// Counter returns a Counter for the named metric. The returned Counter does
+// nothing if metrics or the named metric are opted out.
+func (c *client) Counter(name string) *Counter {
+	if c.OptOut || c.Config.MetricOptedOut(name) {
+		return &Counter{name: name}
+	}
+	c.counters.start(c.flushCountersPeriodically)
+	return &Counter{name: name, agg: &c.counters}
+}
+
+func (c *client) flushCountersPeriodically(stop <-chan struct{}) {
+	interval := cmp.Or(c.FlushInterval, defaultFlushInterval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		if err := c.flushCounters(ctx); err != nil {
+			logging.FromContext(ctx).DebugContext(ctx, "failed to flush counters", "error", err.Error())
+		}
+		cancel()
+	}
+}
+
+// flushCounters sends the accumulated counter totals. Totals which fail to
+// send are dropped. Flushes are not limited by the request budget, as they
+// are already limited by the flush interval.
+func (c *client) flushCounters(ctx context.Context) error {
+	totals, since := c.counters.take()
+	if len(totals) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(totals))
+	for name := range totals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for len(names) > 0 {
+		n := min(len(names), maxMetricsPerRequest)
+		metrics := make(map[string]int64, n)
+		for _, name := range names[:n] {
+			metrics[name] = totals[name]
+		}
+		names = names[n:]
+		if err := c.sendWithin(ctx, &SendMetricRequest{
+			AppID:         c.AppID,
+			AppVersion:    c.AppVersion,
+			Metrics:       metrics,
+			InstallID:     c.InstallID,
+			InstallCohort: CohortForInstallTime(c.InstallTime),
+			EventTime:     since.UnixMilli(),
+		}, nil); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("failed to send counters: %w", err)
+	}
+	return nil
+}
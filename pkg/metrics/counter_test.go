@@ -0,0 +1,282 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// metricsRecorder is a test metrics server which records the metrics of each
+// request.
+type metricsRecorder struct {
+	mu   sync.Mutex
+	reqs []map[string]int64
+}
+
+func (m *metricsRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body := io.Reader(r.Body)
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body = gz
+	}
+
+	var req SendMetricRequest
+	if err := json.NewDecoder(body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reqs = append(m.reqs, req.Metrics)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (m *metricsRecorder) requests() []map[string]int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.reqs
+}
+
+func TestCounter_Flush(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name      string
+		noMetrics []string
+		incs      map[string]int
+		want      []map[string]int64
+	}{
+		{
+			name: "aggregated",
+			incs: map[string]int{"foo": 100, "bar": 3},
+			want: []map[string]int64{{"foo": 100, "bar": 3}},
+		},
+		{
+			name:      "opted_out_metric",
+			noMetrics: []string{"bar"},
+			incs:      map[string]int{"foo": 2, "bar": 3},
+			want:      []map[string]int64{{"foo": 2}},
+		},
+		{
+			name: "nothing_to_send",
+			want: nil,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			rec := &metricsRecorder{}
+			ts := httptest.NewServer(rec)
+			t.Cleanup(ts.Close)
+
+			c := defaultClient()
+			c.Config.ServerURL = ts.URL
+			c.Config.NoMetrics = tc.noMetrics
+
+			var wg sync.WaitGroup
+			for name, n := range tc.incs {
+				counter := c.Counter(name)
+				for range n {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						counter.Inc()
+					}()
+				}
+			}
+			wg.Wait()
+
+			ctx := context.Background()
+			if err := c.Flush(ctx); err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			// Totals are reset after being sent.
+			if err := c.Flush(ctx); err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if diff := cmp.Diff(rec.requests(), tc.want); diff != "" {
+				t.Errorf("unexpected requests (-got,+want): %s", diff)
+			}
+			if err := c.Close(ctx); err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+		})
+	}
+}
+
+func TestCounter_ManyMetrics(t *testing.T) {
+	t.Parallel()
+
+	rec := &metricsRecorder{}
+	ts := httptest.NewServer(rec)
+	t.Cleanup(ts.Close)
+
+	c := defaultClient()
+	c.Config.ServerURL = ts.URL
+	for i := range 150 {
+		c.Counter(fmt.Sprintf("metric_%03d", i)).Add(int64(i))
+	}
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	reqs := rec.requests()
+	if got, want := len(reqs), 2; got != want {
+		t.Fatalf("got %d requests, want %d", got, want)
+	}
+	if got, want := len(reqs[0])+len(reqs[1]), 150; got != want {
+		t.Errorf("got %d metrics, want %d", got, want)
+	}
+}
+
+func TestCounter_Periodic(t *testing.T) {
+	t.Parallel()
+
+	rec := &metricsRecorder{}
+	ts := httptest.NewServer(rec)
+	t.Cleanup(ts.Close)
+
+	c := defaultClient()
+	c.Config.ServerURL = ts.URL
+	c.FlushInterval = 10 * time.Millisecond
+
+	c.Counter("foo").Add(5)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(rec.requests()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for periodic flush")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	// Increments after Close are dropped.
+	c.Counter("foo").Inc()
+	if err := c.Flush(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	want := []map[string]int64{{"foo": 5}}
+	if diff := cmp.Diff(rec.requests(), want); diff != "" {
+		t.Errorf("unexpected requests (-got,+want): %s", diff)
+	}
+}
+
+func TestCounter_OptedOut(t *testing.T) {
+	t.Parallel()
+
+	c := NoopWriter().(CounterWriter)
+	c.Counter("foo").Inc()
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+}
+
+func TestCounter_EventTime(t *testing.T) {
+	t.Parallel()
+
+	reqs := make(chan *SendMetricRequest, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req SendMetricRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		reqs <- &req
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(ts.Close)
+
+	c := defaultClient()
+	c.Config.ServerURL = ts.URL
+
+	before := time.Now().UnixMilli()
+	c.Counter("foo").Inc()
+	time.Sleep(10 * time.Millisecond)
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	req := <-reqs
+	if req.EventTime < before || req.EventTime >= req.SentTime {
+		t.Errorf("expected event time of first increment, before sent time. got event %d sent %d, first increment after %d",
+			req.EventTime, req.SentTime, before)
+	}
+}
+
+func TestCounter_ClientClock(t *testing.T) {
+	t.Parallel()
+
+	reqs := make(chan *SendMetricRequest, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req SendMetricRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		reqs <- &req
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(ts.Close)
+
+	var mu sync.Mutex
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+
+	c := defaultClient()
+	c.Config.ServerURL = ts.URL
+	c.now = clock
+	c.counters.now = clock
+
+	incremented := now
+	c.Counter("foo").Inc()
+	mu.Lock()
+	now = now.Add(5 * time.Second)
+	mu.Unlock()
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	req := <-reqs
+	if got, want := req.EventTime, incremented.UnixMilli(); got != want {
+		t.Errorf("got event time %d, want %d", got, want)
+	}
+	if got, want := req.SentTime, clock().UnixMilli(); got != want {
+		t.Errorf("got sent time %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"context"
+	"sync"
+
+	"github.com/abcxyz/pkg/logging"
+)
+
+// defaultWriter is the client returned by Default.
+var defaultWriter = &lazyWriter{}
+
+// Default returns a process-wide MetricWriter, created by New with appID,
+// version, and opt on the first call. Later calls return the same client and
+// ignore their arguments, so small tools can call Default wherever they
+// record a metric rather than passing a client around. It is safe for
+// concurrent use.
+//
+// If New fails, the error is logged at debug level and Default returns a
+// NoopWriter. Close the client, an AsyncMetricWriter, before exiting, as with
+// New.
+func Default(ctx context.Context, appID, version string, opt ...Option) MetricWriter {
+	return defaultWriter.get(func() MetricWriter {
+		mw, err := New(ctx, appID, version, opt...)
+		if err != nil {
+			logging.FromContext(ctx).DebugContext(ctx, "failed to create default metrics client",
+				"app_id", appID, "error", err.Error())
+			return NoopWriter()
+		}
+		return mw
+	})
+}
+
+// lazyWriter creates a MetricWriter on first use.
+type lazyWriter struct {
+	once sync.Once
+	mw   MetricWriter
+}
+
+// get returns the MetricWriter, calling create if it is the first call.
+func (l *lazyWriter) get(create func() MetricWriter) MetricWriter {
+	l.once.Do(func() {
+		l.mw = create()
+	})
+	return l.mw
+}
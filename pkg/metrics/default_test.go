@@ -0,0 +1,72 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/sethvargo/go-envconfig"
+)
+
+func TestLazyWriter(t *testing.T) {
+	t.Parallel()
+
+	var l lazyWriter
+	var created atomic.Int32
+	want := NoopWriter()
+
+	var wg sync.WaitGroup
+	got := make([]MetricWriter, 10)
+	for i := range got {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got[i] = l.get(func() MetricWriter {
+				created.Add(1)
+				return want
+			})
+		}()
+	}
+	wg.Wait()
+
+	if got, want := created.Load(), int32(1); got != want {
+		t.Errorf("unexpected number of clients created. got %d want %d", got, want)
+	}
+	for i, mw := range got {
+		if mw != want {
+			t.Errorf("call %d: got a different client", i)
+		}
+	}
+}
+
+func TestDefault(t *testing.T) {
+	t.Parallel()
+
+	opts := []Option{
+		WithLookuper(envconfig.MapLookuper(map[string]string{"METRICS_URL": testServerURL})),
+		WithInstallIDFileOverride(filepath.Join(t.TempDir(), installIDFileName)),
+	}
+	first := Default(context.Background(), testAppID, testVersion, opts...)
+	if c, ok := first.(*client); !ok || c.OptOut {
+		t.Fatalf("expected a client which sends metrics, got %#v", first)
+	}
+	if got := Default(context.Background(), "other_app", "2.0.0"); got != first {
+		t.Errorf("expected later calls to return the first client")
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"context"
+	"net"
+	"net/http"
+)
+
+// unixSocketServerURL is the server URL used for requests sent over a unix
+// socket. The host is ignored by the dialer, but is required to build a valid
+// request.
+const unixSocketServerURL = "http://unix"
+
+// DialContextFunc dials a connection, with the same semantics as
+// net.Dialer.DialContext.
+type DialContextFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// WithDialContext instructs the MetricWriter to make all connections with
+// dial, e.g. to route egress through an on-host proxy. If METRICS_URL is a
+// unix:// URL, dial is called with network "unix" and the socket path.
+//
+// The transport of any client provided with WithHTTPClient is replaced.
+func WithDialContext(dial DialContextFunc) Option {
+	return func(o *options) *options {
+		o.dialContext = dial
+		return o
+	}
+}
+
+// withDialer returns a copy of client which dials with dial, or connects to
+// socketPath if non-empty. client is returned unmodified if neither is set.
+func withDialer(client *http.Client, dial DialContextFunc, socketPath string) *http.Client {
+	if dial == nil && socketPath == "" {
+		return client
+	}
+	if dial == nil {
+		var d net.Dialer
+		dial = d.DialContext
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone() //nolint:forcetypeassert // Always *http.Transport.
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		if socketPath != "" {
+			return dial(ctx, "unix", socketPath)
+		}
+		return dial(ctx, network, address)
+	}
+	if socketPath != "" {
+		// Proxies don't apply to local sockets.
+		transport.Proxy = nil
+	}
+
+	wrapped := *client
+	wrapped.Transport = transport
+	return &wrapped
+}
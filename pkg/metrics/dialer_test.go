@@ -0,0 +1,100 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/sethvargo/go-envconfig"
+)
+
+func TestNew_UnixSocket(t *testing.T) {
+	t.Parallel()
+
+	// Socket paths have a short length limit, so avoid t.TempDir().
+	dir, err := os.MkdirTemp("", "metrics")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err.Error())
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	socketPath := filepath.Join(dir, "telemetry.sock")
+
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("failed to listen on unix socket: %s", err.Error())
+	}
+	var calls atomic.Int64
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	ts.Listener = l
+	ts.Start()
+	t.Cleanup(ts.Close)
+
+	mw, err := New(context.Background(), testAppID, testVersion,
+		WithLookuper(envconfig.MapLookuper(map[string]string{"METRICS_URL": "unix://" + socketPath})),
+		WithInstallIDFileOverride(filepath.Join(t.TempDir(), "id.json")))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if err := mw.WriteMetric(context.Background(), "foo", 1); err != nil {
+		t.Fatalf("unexpected error writing metric: %s", err.Error())
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("unexpected number of requests to socket server. got %d want 1", got)
+	}
+}
+
+func TestNew_WithDialContext(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(ts.Close)
+
+	// Send requests for the public server to the local one, as an on-host
+	// proxy would.
+	var dialed atomic.Value
+	dial := func(ctx context.Context, network, address string) (net.Conn, error) {
+		dialed.Store(address)
+		var d net.Dialer
+		return d.DialContext(ctx, network, ts.Listener.Addr().String())
+	}
+
+	mw, err := New(context.Background(), testAppID, testVersion,
+		WithLookuper(envconfig.MapLookuper(map[string]string{"METRICS_URL": "https://metrics.example.com"})),
+		WithInstallIDFileOverride(filepath.Join(t.TempDir(), "id.json")),
+		WithDialContext(dial))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	// The test server doesn't speak TLS, so the request itself fails.
+	_ = mw.WriteMetric(context.Background(), "foo", 1)
+
+	if got, want := dialed.Load(), "metrics.example.com:443"; got != want {
+		t.Errorf("unexpected dialed address. got %v want %q", got, want)
+	}
+}
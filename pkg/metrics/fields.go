@@ -0,0 +1,123 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// exampleInstallID is a placeholder install ID of the same shape as a real
+// one, so real IDs are never included in generated documentation.
+const exampleInstallID = "AAAAAAAAAAA="
+
+// fieldDescriptions describes each field of SendMetricRequest as sent on the
+// wire. Every sent field must have a description.
+var fieldDescriptions = map[string]string{
+	"appId":               "ID of the application sending the metric.",
+	"appVersion":          "Version of the application sending the metric.",
+	"metrics":             "Names and counts of the metrics being reported.",
+	"installId":           "Random ID generated on first run and stored locally. Not derived from any machine or user information.",
+	"installCohort":       "ISO week the install ID was generated. The precise install time is never sent.",
+	"upgradedFrom":        "Previously run version of the application. Only sent with the upgrade metric.",
+	"downgradedFrom":      "Previously run, newer, version of the application. Only sent with the downgrade metric.",
+	"includeDispositions": "Asks the server to report whether each metric was recorded. Only sent if enabled by the application.",
+	"dropped":             "Number of metrics not sent because the client exceeded its request budget. Only sent after metrics were dropped.",
+	"buildInfo":           "Commit, commit date, Go version, and compiler and platform the application was built with. Only sent if enabled by the application.",
+	"eventTime":           "When the metrics occurred, by the machine's clock. Only sent with counter totals.",
+	"sentTime":            "When the request was sent, by the machine's clock, so the server can correct eventTime for clock skew. Only sent with eventTime.",
+	"sequence":            "Number of requests sent by this install, so the server can estimate how many are lost. Only sent if enabled by the application.",
+}
+
+// SentField describes a field the metrics client transmits.
+type SentField struct {
+	// Name is the JSON field name.
+	Name string `json:"name"`
+	// Example is an example JSON encoded value.
+	Example string `json:"example"`
+	// Description explains what the field contains.
+	Description string `json:"description"`
+}
+
+// FieldsSent returns the exact set of fields a MetricWriter created with the
+// same arguments would transmit, sorted by name, with example values. No
+// fields are returned if all metrics are opted out. Redactors are applied to
+// the examples. It has no side effects, so it is suitable for generating
+// privacy documentation.
+func FieldsSent(ctx context.Context, appID, version string, opt ...Option) ([]*SentField, error) {
+	opts, c, err := loadConfig(ctx, appID, opt)
+	if err != nil {
+		return nil, err
+	}
+	if c.OptOutAllMetrics() {
+		return []*SentField{}, nil
+	}
+
+	// Populate every field the client can set, as WriteMetric,
+	// ReportUpgrade, ReportDowngrade, and counters would. Metrics are only
+	// dropped if a budget is set.
+	var dropped int64
+	if !opts.budgetSet || opts.maxRequestsPerProcess > 0 || opts.maxRequestsPerDay > 0 {
+		dropped = 1
+	}
+	var buildInfo *BuildInfo
+	if opts.buildInfo {
+		buildInfo = readBuildInfo()
+	}
+	example := redact(&SendMetricRequest{
+		AppID:               appID,
+		AppVersion:          version,
+		Metrics:             map[string]int64{UpgradeMetric: 1},
+		InstallID:           exampleInstallID,
+		InstallCohort:       CohortForInstallTime(opts.now().Unix()),
+		UpgradedFrom:        version,
+		DowngradedFrom:      version,
+		IncludeDispositions: opts.dispositions,
+		Dropped:             dropped,
+		BuildInfo:           buildInfo,
+		EventTime:           opts.now().UnixMilli(),
+		SentTime:            opts.now().UnixMilli(),
+	}, opts.redactors)
+	if example == nil {
+		return []*SentField{}, nil
+	}
+	if opts.sequenceNumbers {
+		// Set after redactors, as by send.
+		example.Sequence = 1
+	}
+	b, err := json.Marshal(example)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal example request: %w", err)
+	}
+	var sent map[string]json.RawMessage
+	if err := json.Unmarshal(b, &sent); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal example request: %w", err)
+	}
+
+	fields := make([]*SentField, 0, len(sent))
+	for name, example := range sent {
+		fields = append(fields, &SentField{
+			Name:        name,
+			Example:     string(example),
+			Description: fieldDescriptions[name],
+		})
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].Name < fields[j].Name
+	})
+	return fields, nil
+}
@@ -0,0 +1,101 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/sethvargo/go-envconfig"
+
+	"github.com/abcxyz/abc-updater/pkg/optout"
+)
+
+func TestFieldsSent(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name      string
+		env       map[string]string
+		opts      []Option
+		wantNames []string
+	}{
+		{
+			name: "default",
+			// Adding a field to this list must be a deliberate, reviewed change.
+			wantNames: []string{"appId", "appVersion", "downgradedFrom", "dropped", "eventTime", "installCohort", "installId", "metrics", "sentTime", "upgradedFrom"},
+		},
+		{
+			name:      "dispositions",
+			opts:      []Option{WithMetricDispositions()},
+			wantNames: []string{"appId", "appVersion", "downgradedFrom", "dropped", "eventTime", "includeDispositions", "installCohort", "installId", "metrics", "sentTime", "upgradedFrom"},
+		},
+		{
+			name:      "sequence",
+			opts:      []Option{WithSequenceNumbers()},
+			wantNames: []string{"appId", "appVersion", "downgradedFrom", "dropped", "eventTime", "installCohort", "installId", "metrics", "sentTime", "sequence", "upgradedFrom"},
+		},
+		{
+			name:      "no_budget",
+			opts:      []Option{WithBudget(0, 0)},
+			wantNames: []string{"appId", "appVersion", "downgradedFrom", "eventTime", "installCohort", "installId", "metrics", "sentTime", "upgradedFrom"},
+		},
+		{
+			name: "redacted",
+			opts: []Option{WithRedactor(func(req *SendMetricRequest) *SendMetricRequest {
+				req.InstallCohort = ""
+				return req
+			})},
+			wantNames: []string{"appId", "appVersion", "downgradedFrom", "dropped", "eventTime", "installId", "metrics", "sentTime", "upgradedFrom"},
+		},
+		{
+			name:      "suppressed",
+			opts:      []Option{WithRedactor(func(*SendMetricRequest) *SendMetricRequest { return nil })},
+			wantNames: []string{},
+		},
+		{
+			name:      "opted_out",
+			env:       map[string]string{optout.NoMetricsEnvVar: "all"},
+			wantNames: []string{},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			opts := append([]Option{WithLookuper(envconfig.MapLookuper(tc.env))}, tc.opts...)
+			fields, err := FieldsSent(context.Background(), "test", "1.0.0", opts...)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+
+			gotNames := make([]string, 0, len(fields))
+			for _, f := range fields {
+				gotNames = append(gotNames, f.Name)
+				if f.Description == "" {
+					t.Errorf("field %q has no description", f.Name)
+				}
+				if f.Example == "" {
+					t.Errorf("field %q has no example", f.Name)
+				}
+			}
+			if diff := cmp.Diff(gotNames, tc.wantNames); diff != "" {
+				t.Errorf("unexpected fields (-got,+want): %s", diff)
+			}
+		})
+	}
+}
@@ -15,18 +15,32 @@
 package metrics
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
 	"path/filepath"
+	"time"
 
+	"github.com/abcxyz/abc-updater/pkg/api"
 	"github.com/abcxyz/abc-updater/pkg/localstore"
+	"github.com/abcxyz/abc-updater/pkg/semver"
+	"github.com/abcxyz/pkg/logging"
 )
 
 // InstallIDData defines the json file that defines installation id.
 type InstallIDData struct {
 	// InstallID. Expected to be a hex 8-4-4-4-12 formatted v4 UUID.
 	InstallID string `json:"installId"`
+
+	// Time the install ID was generated, in UTC epoch seconds. Zero if unknown.
+	InstallTime int64 `json:"installTime,omitempty"`
+
+	// LastVersion is the app version most recently run with this install ID.
+	LastVersion string `json:"lastVersion,omitempty"`
 }
 
 // Only check if non-empty for now, as we don't currently have versioned APIs,
@@ -47,18 +61,192 @@ func generateInstallID() (string, error) {
 	return base64.StdEncoding.EncodeToString(b), nil
 }
 
-func loadInstallID(appID, installIDFileOverride string) (*InstallIDData, error) {
-	path := installIDFileOverride
-	if path == "" {
-		dir, err := localstore.DefaultDir(appID)
-		if err != nil {
-			return nil, fmt.Errorf("could not calculate install ID path: %w", err)
+func installIDPath(appID, installIDFileOverride string) (string, error) {
+	if installIDFileOverride != "" {
+		return installIDFileOverride, nil
+	}
+	dir, err := localstore.DefaultDir(appID)
+	if err != nil {
+		return "", fmt.Errorf("could not calculate install ID path: %w", err)
+	}
+	return filepath.Join(dir, installIDFileName), nil
+}
+
+// installIDSchema versions stored InstallIDData. Register a migration here
+// when changing its format, so existing install IDs are kept rather than
+// regenerated.
+var installIDSchema = localstore.NewSchema(1).
+	// Version 0 predates schema versioning, and has the same fields.
+	Register(0, func(map[string]json.RawMessage) error { return nil })
+
+// secretStore stores JSON values by account. Implemented by
+// *localstore.Keychain.
+type secretStore interface {
+	LoadJSON(account string, data any) error
+	StoreJSON(account string, data any) error
+}
+
+// installIDStore is where the install ID is persisted: a JSON file, or the OS
+// keychain if keychain is set.
+type installIDStore struct {
+	// path is the JSON file. If using the keychain, an install ID in a file
+	// written by an earlier run is migrated to the keychain.
+	path string
+	// pathErr is set if path could not be calculated.
+	pathErr error
+	// keychain, if set, stores the install ID instead of the file.
+	keychain secretStore
+	// account is the keychain item for the app, which is named by app ID.
+	account string
+	// now returns the install time of new install IDs. Defaults to time.Now.
+	now func() time.Time
+}
+
+// newInstallIDStore returns the install ID store for appID.
+func newInstallIDStore(appID, installIDFileOverride string, useKeychain bool) *installIDStore {
+	path, err := installIDPath(appID, installIDFileOverride)
+	s := &installIDStore{path: path, pathErr: err}
+	if useKeychain {
+		s.keychain = localstore.NewKeychain(localstore.KeychainService)
+		s.account = appID
+	}
+	return s
+}
+
+// loadOrCreateInstallID loads the stored install ID, or generates and stores a
+// new one if none exists. Install IDs stored before install time was recorded
+// have it backfilled from the file's modification time, which is when the ID
+// was first written. If the keychain is unavailable, s falls back to the file.
+func loadOrCreateInstallID(ctx context.Context, s *installIDStore) (*InstallIDData, error) {
+	logger := logging.FromContext(ctx)
+
+	stored, err := s.load(ctx)
+	if err == nil && stored != nil {
+		if stored.InstallTime == 0 && s.keychain == nil {
+			if fi, err := os.Stat(s.path); err == nil {
+				stored.InstallTime = fi.ModTime().Unix()
+				if err := storeInstallID(s, stored); err != nil {
+					logger.DebugContext(ctx, "error storing InstallID", "error", err.Error())
+				}
+			}
+		}
+		return stored, nil
+	}
+
+	if s.keychain != nil && errors.Is(err, os.ErrNotExist) {
+		if migrated, ok := migrateInstallID(ctx, s); ok {
+			return migrated, nil
+		}
+	}
+
+	installID, err := generateInstallID()
+	if err != nil {
+		return nil, err
+	}
+	data := &InstallIDData{
+		InstallID:   installID,
+		InstallTime: s.installTime().Unix(),
+	}
+	if err := storeInstallID(s, data); err != nil {
+		logger.DebugContext(ctx, "error storing InstallID", "error", err.Error())
+	}
+	return data, nil
+}
+
+// load loads the stored install ID. If the keychain is unavailable, s falls
+// back to the file.
+func (s *installIDStore) load(ctx context.Context) (*InstallIDData, error) {
+	stored, err := loadInstallID(s)
+	if s.keychain != nil && keychainUnavailable(err) {
+		logging.FromContext(ctx).DebugContext(ctx, "keychain unavailable, storing InstallID in file",
+			"error", err.Error())
+		s.keychain = nil
+		stored, err = loadInstallID(s)
+	}
+	return stored, err
+}
+
+// keychainUnavailable returns true if err is from a keychain which cannot be
+// used on this machine, rather than from a missing or unreadable item.
+func keychainUnavailable(err error) bool {
+	return errors.Is(err, localstore.ErrKeychainUnavailable) || errors.Is(err, localstore.ErrKeychainUnsupported)
+}
+
+// installTime returns the install time for a new install ID.
+func (s *installIDStore) installTime() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
+
+// migrateInstallID moves an install ID from the JSON file to the keychain, so
+// switching to the keychain keeps the existing ID. The file is only removed
+// once the ID is stored in the keychain.
+func migrateInstallID(ctx context.Context, s *installIDStore) (*InstallIDData, bool) {
+	stored, err := loadInstallID(&installIDStore{path: s.path, pathErr: s.pathErr})
+	if err != nil {
+		return nil, false
+	}
+	if stored.InstallTime == 0 {
+		if fi, err := os.Stat(s.path); err == nil {
+			stored.InstallTime = fi.ModTime().Unix()
 		}
-		path = filepath.Join(dir, installIDFileName)
 	}
+
+	logger := logging.FromContext(ctx)
+	if err := storeInstallID(s, stored); err != nil {
+		logger.DebugContext(ctx, "error migrating InstallID to keychain", "error", err.Error())
+		return stored, true
+	}
+	if err := os.Remove(s.path); err != nil {
+		logger.DebugContext(ctx, "error removing migrated InstallID file", "error", err.Error())
+	}
+	return stored, true
+}
+
+// recordVersion stores currentVersion as the last run version. Returns the
+// previously run version as upgradedFrom if currentVersion is newer, or as
+// downgradedFrom if it is older. Both are empty if the version is unchanged
+// or either version cannot be parsed.
+func recordVersion(ctx context.Context, s *installIDStore, data *InstallIDData, currentVersion string) (upgradedFrom, downgradedFrom string) {
+	previous := data.LastVersion
+	if previous == currentVersion {
+		return "", ""
+	}
+
+	data.LastVersion = currentVersion
+	if err := storeInstallID(s, data); err != nil {
+		logging.FromContext(ctx).DebugContext(ctx, "error storing last version", "error", err.Error())
+	}
+
+	if previous == "" {
+		return "", ""
+	}
+	c, err := semver.Compare(currentVersion, previous)
+	if err != nil {
+		return "", ""
+	}
+	switch {
+	case c > 0:
+		return previous, ""
+	case c < 0:
+		return "", previous
+	default:
+		return "", ""
+	}
+}
+
+func loadInstallID(s *installIDStore) (*InstallIDData, error) {
 	var stored InstallIDData
 
-	if err := localstore.LoadJSONFile(path, &stored); err != nil {
+	load := func() error { return installIDSchema.LoadJSONFile(s.path, &stored) }
+	if s.keychain != nil {
+		load = func() error { return loadKeychainInstallID(s, &stored) }
+	} else if s.pathErr != nil {
+		return nil, s.pathErr
+	}
+	if err := load(); err != nil {
 		return nil, fmt.Errorf("could not load install id: %w", err)
 	}
 
@@ -69,16 +257,45 @@ func loadInstallID(appID, installIDFileOverride string) (*InstallIDData, error)
 	return &stored, nil
 }
 
-func storeInstallID(appID, installIDFileOverride string, data *InstallIDData) error {
-	if installIDFileOverride == "" {
-		dir, err := localstore.DefaultDir(appID)
-		if err != nil {
-			return fmt.Errorf("could not calculate install ID path: %w", err)
+func storeInstallID(s *installIDStore, data *InstallIDData) error {
+	store := func() error { return installIDSchema.StoreJSONFile(s.path, data) }
+	if s.keychain != nil {
+		store = func() error {
+			b, err := installIDSchema.Encode(data)
+			if err != nil {
+				return err //nolint:wrapcheck // Wrapped by caller.
+			}
+			return s.keychain.StoreJSON(s.account, json.RawMessage(b)) //nolint:wrapcheck // Wrapped by caller.
 		}
-		installIDFileOverride = filepath.Join(dir, installIDFileName)
+	} else if s.pathErr != nil {
+		return s.pathErr
 	}
-	if err := localstore.StoreJSONFile(installIDFileOverride, data); err != nil {
+	if err := store(); err != nil {
 		return fmt.Errorf("could not store install id: %w", err)
 	}
 	return nil
 }
+
+// loadKeychainInstallID loads the install ID from the keychain into data,
+// upgrading the stored item if it has an older schema version.
+func loadKeychainInstallID(s *installIDStore, data *InstallIDData) error {
+	var raw json.RawMessage
+	if err := s.keychain.LoadJSON(s.account, &raw); err != nil {
+		return err //nolint:wrapcheck // Wrapped by caller.
+	}
+	upgraded, err := installIDSchema.Decode(raw, data)
+	if err != nil {
+		return err //nolint:wrapcheck // Wrapped by caller.
+	}
+	if upgraded != nil {
+		// The data loaded either way, so a failure is retried next load.
+		_ = s.keychain.StoreJSON(s.account, json.RawMessage(upgraded))
+	}
+	return nil
+}
+
+// CohortForInstallTime returns the ISO week of installTime (UTC epoch
+// seconds), e.g. "2024-W05". Returns empty string if installTime is unknown.
+func CohortForInstallTime(installTime int64) string {
+	return api.CohortForInstallTime(installTime)
+}
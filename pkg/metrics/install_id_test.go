@@ -15,7 +15,16 @@
 package metrics
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/abcxyz/abc-updater/pkg/localstore"
 )
 
 func Test_generateInstallID(t *testing.T) {
@@ -28,3 +37,304 @@ func Test_generateInstallID(t *testing.T) {
 		t.Errorf("unexpected id length got=%d want=%d", got, want)
 	}
 }
+
+func Test_loadOrCreateInstallID_BackfillsInstallTime(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), installIDFileName)
+	if err := storeInstallID(&installIDStore{path: path}, &InstallIDData{InstallID: "abc"}); err != nil {
+		t.Fatalf("test setup failed: %s", err.Error())
+	}
+	modTime := time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("test setup failed: %s", err.Error())
+	}
+
+	got, err := loadOrCreateInstallID(ctx, &installIDStore{path: path})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if got.InstallID != "abc" {
+		t.Errorf("install id changed. got %q want %q", got.InstallID, "abc")
+	}
+	if got.InstallTime != modTime.Unix() {
+		t.Errorf("unexpected install time. got %d want %d", got.InstallTime, modTime.Unix())
+	}
+
+	stored, err := loadInstallID(&installIDStore{path: path})
+	if err != nil {
+		t.Fatalf("failed to load stored id: %s", err.Error())
+	}
+	if stored.InstallTime != modTime.Unix() {
+		t.Errorf("backfilled install time not stored. got %d want %d", stored.InstallTime, modTime.Unix())
+	}
+}
+
+func Test_recordVersion(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name               string
+		lastVersion        string
+		currentVersion     string
+		want               string
+		wantDowngradedFrom string
+	}{
+		{
+			name:           "first_run",
+			currentVersion: "1.0.0",
+			want:           "",
+		},
+		{
+			name:           "same_version",
+			lastVersion:    "1.0.0",
+			currentVersion: "1.0.0",
+			want:           "",
+		},
+		{
+			name:           "upgraded",
+			lastVersion:    "1.0.0",
+			currentVersion: "1.1.0",
+			want:           "1.0.0",
+		},
+		{
+			name:               "downgraded",
+			lastVersion:        "1.1.0",
+			currentVersion:     "1.0.0",
+			want:               "",
+			wantDowngradedFrom: "1.1.0",
+		},
+		{
+			name:           "equivalent_version",
+			lastVersion:    "v1.0.0",
+			currentVersion: "1.0.0",
+			want:           "",
+		},
+		{
+			name:           "unparseable_version",
+			lastVersion:    "dev",
+			currentVersion: "1.0.0",
+			want:           "",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			path := filepath.Join(t.TempDir(), installIDFileName)
+			data := &InstallIDData{InstallID: "abc", LastVersion: tc.lastVersion}
+
+			got, gotDowngradedFrom := recordVersion(ctx, &installIDStore{path: path}, data, tc.currentVersion)
+			if got != tc.want {
+				t.Errorf("unexpected previous version. got %q want %q", got, tc.want)
+			}
+			if gotDowngradedFrom != tc.wantDowngradedFrom {
+				t.Errorf("unexpected downgraded from version. got %q want %q", gotDowngradedFrom, tc.wantDowngradedFrom)
+			}
+
+			stored, err := loadInstallID(&installIDStore{path: path})
+			if tc.lastVersion == tc.currentVersion {
+				// Nothing to store.
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to load stored id: %s", err.Error())
+			}
+			if stored.LastVersion != tc.currentVersion {
+				t.Errorf("unexpected stored last version. got %q want %q", stored.LastVersion, tc.currentVersion)
+			}
+		})
+	}
+}
+
+// fakeKeychain is an in-memory secretStore.
+type fakeKeychain struct {
+	items map[string][]byte
+	// err is returned by StoreJSON, and by LoadJSON too if unavailable.
+	err         error
+	unavailable bool
+}
+
+func (k *fakeKeychain) LoadJSON(account string, data any) error {
+	if k.unavailable {
+		return k.err
+	}
+	b, ok := k.items[account]
+	if !ok {
+		return fmt.Errorf("not found: %w", os.ErrNotExist)
+	}
+	return json.Unmarshal(b, data)
+}
+
+func (k *fakeKeychain) StoreJSON(account string, data any) error {
+	if k.err != nil {
+		return k.err
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	k.items[account] = b
+	return nil
+}
+
+func Test_loadOrCreateInstallID_Keychain(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name         string
+		fileID       string
+		keychainErr  error
+		wantID       string
+		wantFileKept bool
+	}{
+		{
+			name:   "generates",
+			wantID: "",
+		},
+		{
+			name:   "migrates_file",
+			fileID: "abc",
+			wantID: "abc",
+		},
+		{
+			name:         "keeps_file_if_keychain_fails",
+			fileID:       "abc",
+			keychainErr:  errors.New("keychain locked"),
+			wantID:       "abc",
+			wantFileKept: true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			path := filepath.Join(t.TempDir(), installIDFileName)
+			if tc.fileID != "" {
+				if err := storeInstallID(&installIDStore{path: path}, &InstallIDData{InstallID: tc.fileID}); err != nil {
+					t.Fatalf("test setup failed: %s", err.Error())
+				}
+			}
+			keychain := &fakeKeychain{items: make(map[string][]byte), err: tc.keychainErr}
+			s := &installIDStore{path: path, keychain: keychain, account: "foo"}
+
+			got, err := loadOrCreateInstallID(ctx, s)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if tc.wantID != "" && got.InstallID != tc.wantID {
+				t.Errorf("unexpected install id. got %q want %q", got.InstallID, tc.wantID)
+			}
+			if got.InstallTime == 0 {
+				t.Errorf("install time not set")
+			}
+
+			_, err = os.Stat(path)
+			if fileKept := err == nil; fileKept != tc.wantFileKept {
+				t.Errorf("unexpected install id file existence. got %t want %t", fileKept, tc.wantFileKept)
+			}
+			if tc.keychainErr != nil {
+				return
+			}
+			stored, err := loadInstallID(s)
+			if err != nil {
+				t.Fatalf("failed to load id from keychain: %s", err.Error())
+			}
+			if stored.InstallID != got.InstallID {
+				t.Errorf("unexpected keychain install id. got %q want %q", stored.InstallID, got.InstallID)
+			}
+		})
+	}
+}
+
+func Test_loadOrCreateInstallID_KeychainUnavailable(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		fileID string
+		err    error
+	}{
+		{
+			name: "generates_in_file",
+			err:  fmt.Errorf("secret-tool not found: %w", localstore.ErrKeychainUnavailable),
+		},
+		{
+			name:   "keeps_file",
+			fileID: "abc",
+			err:    fmt.Errorf("secret-tool not found: %w", localstore.ErrKeychainUnavailable),
+		},
+		{
+			name:   "unsupported_platform",
+			fileID: "abc",
+			err:    localstore.ErrKeychainUnsupported,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(t.TempDir(), installIDFileName)
+			if tc.fileID != "" {
+				if err := storeInstallID(&installIDStore{path: path}, &InstallIDData{InstallID: tc.fileID}); err != nil {
+					t.Fatalf("test setup failed: %s", err.Error())
+				}
+			}
+			keychain := &fakeKeychain{items: make(map[string][]byte), err: tc.err, unavailable: true}
+			s := &installIDStore{path: path, keychain: keychain, account: "foo"}
+
+			got, err := loadOrCreateInstallID(context.Background(), s)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if tc.fileID != "" && got.InstallID != tc.fileID {
+				t.Errorf("unexpected install id. got %q want %q", got.InstallID, tc.fileID)
+			}
+
+			stored, err := loadInstallID(&installIDStore{path: path})
+			if err != nil {
+				t.Fatalf("expected install id in file: %s", err.Error())
+			}
+			if stored.InstallID != got.InstallID {
+				t.Errorf("unexpected file install id. got %q want %q", stored.InstallID, got.InstallID)
+			}
+		})
+	}
+}
+
+func Test_loadInstallID_UpgradesUnversioned(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), installIDFileName)
+	if err := os.WriteFile(path, []byte(`{"installId":"abc","installTime":1700000000}`), 0o600); err != nil {
+		t.Fatalf("test setup failed: %s", err.Error())
+	}
+
+	got, err := loadInstallID(&installIDStore{path: path})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if got.InstallID != "abc" || got.InstallTime != 1700000000 {
+		t.Errorf("unexpected install id data %#v", got)
+	}
+
+	var stored map[string]any
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stored["schemaVersion"], float64(installIDSchema.Version()); got != want {
+		t.Errorf("unexpected stored schema version. got %v want %v", got, want)
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package metrics
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/sethvargo/go-envconfig"
+)
+
+// Not parallel, as it sets PATH.
+func TestNew_KeychainWithoutSecretTool(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+
+	path := filepath.Join(t.TempDir(), installIDFileName)
+	mw, err := New(context.Background(), testAppID, testVersion,
+		WithLookuper(envconfig.MapLookuper(map[string]string{"METRICS_URL": testServerURL})),
+		WithInstallIDFileOverride(path),
+		WithKeychainInstallID())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	stored, err := loadInstallID(&installIDStore{path: path})
+	if err != nil {
+		t.Fatalf("expected install id in file: %s", err.Error())
+	}
+	if got, want := stored.InstallID, mw.(*client).InstallID; got != want {
+		t.Errorf("unexpected file install id. got %q want %q", got, want)
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"time"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+)
+
+// DefaultLatencyBuckets are the bucket upper bounds used by LatencyMetric if
+// none are given, and by the server for latency metrics listed in metrics.json
+// without buckets.
+var DefaultLatencyBuckets = api.DefaultLatencyBuckets
+
+// LatencyMetric returns the name of the bucket metric which counts d for the
+// latency metric name, e.g. "build.le_250ms". buckets are the bucket upper
+// bounds, in increasing order, and must match the metric's "latency" entry in
+// the app's metrics.json. If none are given, DefaultLatencyBuckets are used.
+// Durations above every bound are counted in the overflow bucket, e.g.
+// "build.le_inf".
+//
+// Only the bucket is sent, not d, so apps get latency distributions without
+// timing data leaving the machine. Count it like any other metric, e.g. with
+// a CounterWriter:
+//
+//	cw.Counter(metrics.LatencyMetric("build", time.Since(start))).Inc()
+func LatencyMetric(name string, d time.Duration, buckets ...time.Duration) string {
+	if len(buckets) == 0 {
+		buckets = DefaultLatencyBuckets
+	}
+	for _, le := range buckets {
+		if d <= le {
+			return api.LatencyBucketMetric(name, le)
+		}
+	}
+	return api.LatencyBucketMetric(name, 0)
+}
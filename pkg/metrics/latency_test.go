@@ -0,0 +1,47 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencyMetric(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		d       time.Duration
+		buckets []time.Duration
+		want    string
+	}{
+		{name: "default", d: 120 * time.Millisecond, want: "build.le_250ms"},
+		{name: "on_bound", d: time.Second, want: "build.le_1000ms"},
+		{name: "default_overflow", d: time.Hour, want: "build.le_inf"},
+		{name: "custom", d: 3 * time.Second, buckets: []time.Duration{time.Second, 5 * time.Second}, want: "build.le_5000ms"},
+		{name: "custom_overflow", d: 6 * time.Second, buckets: []time.Duration{time.Second, 5 * time.Second}, want: "build.le_inf"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := LatencyMetric("build", tc.d, tc.buckets...); got != tc.want {
+				t.Errorf("unexpected metric. got %q want %q", got, tc.want)
+			}
+		})
+	}
+}
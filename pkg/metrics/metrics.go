@@ -17,31 +17,97 @@ package metrics
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"net/url"
-	"strings"
+	"path/filepath"
+	"slices"
 	"time"
 
 	"github.com/sethvargo/go-envconfig"
-
+	"google.golang.org/protobuf/proto"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+	"github.com/abcxyz/abc-updater/pkg/api/apipb"
+	"github.com/abcxyz/abc-updater/pkg/apierror"
+	"github.com/abcxyz/abc-updater/pkg/compat"
+	"github.com/abcxyz/abc-updater/pkg/doh"
+	"github.com/abcxyz/abc-updater/pkg/failover"
+	"github.com/abcxyz/abc-updater/pkg/observe"
+	"github.com/abcxyz/abc-updater/pkg/optout"
+	"github.com/abcxyz/abc-updater/pkg/reachability"
+	"github.com/abcxyz/abc-updater/pkg/serverurl"
+	"github.com/abcxyz/abc-updater/pkg/useragent"
 	"github.com/abcxyz/pkg/logging"
 )
 
 const (
 	installIDFileName     = "id.json"
-	maxErrorResponseBytes = 2048
+	serverBackoffFileName = "server_backoff.json"
+	// unreachableServersFileName persists servers which could not be reached.
+	unreachableServersFileName = "unreachable_servers.json"
+
+	// UpgradeMetric is the metric name sent by ReportUpgrade. The server
+	// records it, and the other lifecycle metrics, for every app.
+	UpgradeMetric = api.UpgradeMetric
+
+	// DowngradeMetric is the metric name sent by ReportDowngrade. Like the
+	// other lifecycle metrics, the server records it for every app.
+	DowngradeMetric = api.DowngradeMetric
+
+	// UninstallMetric is the metric name sent by ReportUninstall. Like the
+	// other lifecycle metrics, the server records it for every app.
+	UninstallMetric = api.UninstallMetric
+
+	// Request bodies at least this large are gzip compressed before sending.
+	compressionThresholdBytes = 1024
+
+	// maxResponseBytes limits how much of a successful response body is read.
+	maxResponseBytes = 64 * 1024
 )
 
+// ErrInvalidServerURL is returned (wrapped) by New if METRICS_URL is not a
+// valid server URL.
+var ErrInvalidServerURL = serverurl.ErrInvalid
+
+// ErrServerUnreachable is returned (wrapped) by WriteMetric if the server was
+// recently found unreachable, and was skipped. See WithUnreachableTTL.
+var ErrServerUnreachable = reachability.ErrUnreachable
+
+// ErrServerCoolingDown is returned (wrapped) by WriteMetric if the server
+// asked clients to wait, with a Retry-After header, and the metric was not
+// sent.
+var ErrServerCoolingDown = failover.ErrCoolingDown
+
+// ErrMetricNotAccepted is returned (wrapped) by WriteMetric, if
+// WithMetricDispositions is set, when the server did not record a metric, for
+// example because it is not in the app's allowlist.
+var ErrMetricNotAccepted = errors.New("metric not accepted by server")
+
 // Assert client implements MetricWriter.
 var _ MetricWriter = (*client)(nil)
 
+// Assert client implements AsyncMetricWriter.
+var _ AsyncMetricWriter = (*client)(nil)
+
+// Assert client implements LifecycleWriter.
+var _ LifecycleWriter = (*client)(nil)
+
+// Assert client implements CounterWriter.
+var _ CounterWriter = (*client)(nil)
+
 type metricsConfig struct {
+	// ServerURL is a comma-separated list of servers, in order of preference.
+	// After New, it is the first normalized server.
 	ServerURL string `env:"METRICS_URL, default=https://abc-updater-metrics.tycho.joonix.net"`
-	NoMetrics bool   `env:"NO_METRICS"`
+	optout.Config
+
+	// FallbackURLs are the normalized servers to try if ServerURL fails.
+	FallbackURLs []string
 }
 
 type options struct {
@@ -49,7 +115,27 @@ type options struct {
 	lookuper   envconfig.Lookuper
 	// Optional override for install id file location. Mostly intended for testing.
 	// If empty uses default location.
-	installIDFileOverride string
+	installIDFileOverride  string
+	allowInsecureLocalhost bool
+	dialContext            DialContextFunc
+	flushInterval          time.Duration
+	userAgent              string
+	dispositions           bool
+	protobuf               bool
+	unreachableTTL         time.Duration
+	dnsOverHTTPSURL        string
+	budgetSet              bool
+	maxRequestsPerProcess  int
+	maxRequestsPerDay      int
+	circuitSet             bool
+	circuitFailures        int
+	circuitOpenFor         time.Duration
+	redactors              []Redactor
+	keychain               bool
+	buildInfo              bool
+	requestObserver        observe.Func
+	sequenceNumbers        bool
+	now                    func() time.Time
 }
 
 // Option is the MetricWriter option type.
@@ -81,151 +167,666 @@ func WithInstallIDFileOverride(path string) Option {
 	}
 }
 
+// WithKeychainInstallID stores the install ID in the OS keychain (the login
+// keychain on macOS, the Secret Service via secret-tool on Linux, and
+// Credential Manager on Windows) instead of a JSON file in the user's config
+// directory. An install ID already stored in the file is moved to the
+// keychain. If the keychain is unavailable, e.g. secret-tool is not installed
+// or no Secret Service is running, the install ID is stored in the file
+// instead. Other state, such as the request budget, is still stored in files,
+// and contains no identifiers.
+func WithKeychainInstallID() Option {
+	return func(o *options) *options {
+		o.keychain = true
+		return o
+	}
+}
+
+// WithNowFunc overrides the current time used for install times, cohorts, and
+// the daily request budget, so tests can simulate install age and budget
+// resets without sleeping. Defaults to time.Now.
+func WithNowFunc(now func() time.Time) Option {
+	return func(o *options) *options {
+		o.now = now
+		return o
+	}
+}
+
+// WithAllowInsecureLocalhost permits a METRICS_URL of http://localhost, or
+// another loopback address, for local development. Other http URLs are always
+// rejected.
+func WithAllowInsecureLocalhost() Option {
+	return func(o *options) *options {
+		o.allowInsecureLocalhost = true
+		return o
+	}
+}
+
+// WithUnreachableTTL sets how long to skip the server after several
+// consecutive attempts cannot reach it, so commands on restricted networks do
+// not wait on timeouts. Defaults to five minutes. Servers are only skipped when
+// using the default HTTP client and dialer.
+func WithUnreachableTTL(ttl time.Duration) Option {
+	return func(o *options) *options {
+		o.unreachableTTL = ttl
+		return o
+	}
+}
+
+// WithDNSOverHTTPS resolves the server's host name with the DNS over HTTPS
+// server at url, e.g. "https://1.1.1.1/dns-query", when the system resolver
+// fails. This is for networks where DNS for the server is blocked but HTTPS
+// egress is allowed. A url with an IP address avoids resolving the DNS over
+// HTTPS server itself. Like WithUnreachableTTL, it only applies when using the
+// default HTTP client and dialer.
+func WithDNSOverHTTPS(url string) Option {
+	return func(o *options) *options {
+		o.dnsOverHTTPSURL = url
+		return o
+	}
+}
+
+// WithUserAgent overrides the User-Agent sent to the server, which defaults to
+// "abc-updater/<lib-version> (<appID>/<version>)".
+func WithUserAgent(userAgent string) Option {
+	return func(o *options) *options {
+		o.userAgent = userAgent
+		return o
+	}
+}
+
+// WithMetricDispositions asks the server what it did with each metric sent,
+// and makes WriteMetric return an error wrapping ErrMetricNotAccepted for
+// metrics it did not record. Useful for checking an integration is configured
+// server-side; not recommended in production.
+func WithMetricDispositions() Option {
+	return func(o *options) *options {
+		o.dispositions = true
+		return o
+	}
+}
+
+// WithProtobufEncoding sends requests encoded as protobuf rather than JSON,
+// which is smaller and stricter. The server must support protobuf requests;
+// older servers reject them.
+func WithProtobufEncoding() Option {
+	return func(o *options) *options {
+		o.protobuf = true
+		return o
+	}
+}
+
+// WithRequestObserver calls fn after each request to the metrics server,
+// including retries against fallback servers, so apps can meter and debug the
+// library's network use.
+func WithRequestObserver(fn observe.Func) Option {
+	return func(o *options) *options {
+		o.requestObserver = fn
+		return o
+	}
+}
+
 // MetricWriter is a client for reporting metrics about an application's usage.
 type MetricWriter interface {
 	WriteMetric(ctx context.Context, name string, count int64) error
 }
 
+// LifecycleWriter is a MetricWriter which also knows about the app's install
+// on this machine, and reports changes to it. The MetricWriters returned by
+// New and NoopWriter implement it.
+type LifecycleWriter interface {
+	MetricWriter
+
+	// InstallAge returns the time since the install ID was generated. Returns
+	// false if install time is unknown or metrics are opted out.
+	InstallAge() (time.Duration, bool)
+
+	// UpgradedFrom returns the version of the app run previously on this
+	// machine, if the app has since been upgraded.
+	UpgradedFrom() (string, bool)
+
+	// ReportUpgrade sends the upgrade metric, recording fromVersion as the
+	// version upgraded from.
+	ReportUpgrade(ctx context.Context, fromVersion string) error
+
+	// DowngradedFrom returns the version of the app run previously on this
+	// machine, if the app has since been downgraded, e.g. a user pinning an
+	// older version.
+	DowngradedFrom() (string, bool)
+
+	// ReportDowngrade sends the downgrade metric, recording fromVersion as the
+	// version downgraded from.
+	ReportDowngrade(ctx context.Context, fromVersion string) error
+
+	// ReportUninstall sends the uninstall metric, as a final ping before the
+	// app is removed. Call it before localstore.PurgeLocalData, which removes
+	// the install ID.
+	ReportUninstall(ctx context.Context) error
+}
+
 type client struct {
 	AppID      string
 	AppVersion string
 	InstallID  string
-	HTTPClient *http.Client
-	OptOut     bool
-	Config     *metricsConfig
+	// InstallTime in UTC epoch seconds. Zero if unknown.
+	InstallTime int64
+	// PreviousVersion is the version run before an upgrade. Empty if the app
+	// was not upgraded since the last run.
+	PreviousVersion string
+	// DowngradedFromVersion is the version run before a downgrade. Empty if
+	// the app was not downgraded since the last run.
+	DowngradedFromVersion string
+	HTTPClient            *http.Client
+	OptOut                bool
+	Config                *metricsConfig
+	// FlushInterval is how often counter totals are sent. Zero uses the
+	// default.
+	FlushInterval time.Duration
+	// UserAgent overrides the default User-Agent if set.
+	UserAgent string
+	// Dispositions requests per-metric dispositions from the server, and
+	// returns an error for metrics which were not accepted.
+	Dispositions bool
+	// Protobuf sends requests encoded as protobuf rather than JSON.
+	Protobuf bool
+	// Tracker backs off servers which recently failed, or asked clients to
+	// wait. Nil if the install ID path is unknown.
+	Tracker *failover.Tracker
+	// Budget limits the requests sent. Nil if unlimited.
+	Budget *budget
+	// Circuit stops requests while the server is failing. Nil if disabled.
+	Circuit *circuit
+	// Redactors sanitize each request before it is sent.
+	Redactors []Redactor
+	// BuildInfo is sent with each request if set.
+	BuildInfo *BuildInfo
+	// Sequence numbers each request sent. Nil if disabled.
+	Sequence *sequence
+	// SampleRate is the fraction of requests sent, from the app's
+	// ClientConfig. Zero sends all of them.
+	SampleRate float64
+
+	pending   pendingWrites
+	coalesced coalescer
+	counters  aggregator
+	// now returns the current time. Nil uses time.Now.
+	now func() time.Time
 }
 
-// New provides a MetricWriter based on provided values and options.
-// Upon error recommended to use NoopWriter().
+// New provides a MetricWriter based on provided values and options. It also
+// implements AsyncMetricWriter, LifecycleWriter, and CounterWriter. Upon error
+// recommended to use NoopWriter().
 func New(ctx context.Context, appID, version string, opt ...Option) (MetricWriter, error) {
 	if len(appID) == 0 {
 		return nil, fmt.Errorf("appID cannot be empty")
 	}
 
-	opts := &options{}
-
-	for _, o := range opt {
-		opts = o(opts)
-	}
-
-	// Default to the environment loader.
-	if opts.lookuper == nil {
-		opts.lookuper = envconfig.OsLookuper()
-		opts.lookuper = envconfig.PrefixLookuper(strings.ToUpper(appID)+"_", opts.lookuper)
+	opts, c, err := loadConfig(ctx, appID, opt)
+	if err != nil {
+		return nil, err
 	}
 
-	var c metricsConfig
-	if err := envconfig.ProcessWith(ctx, &envconfig.Config{
-		Target:   &c,
-		Lookuper: opts.lookuper,
-	}); err != nil {
-		return nil, fmt.Errorf("failed to process envconfig: %w", err)
+	// Short Circuit if user opted out of all metrics.
+	if c.OptOutAllMetrics() {
+		return NoopWriter(), nil
 	}
-
-	// Short Circuit if user opted out of metrics.
-	if c.NoMetrics {
+	serverConfig := loadClientConfig(ctx, appID, version, opts.installIDFileOverride)
+	if serverConfig != nil && serverConfig.DisableMetrics {
+		logging.FromContext(ctx).DebugContext(ctx, "metrics disabled by server config", "app_id", appID)
 		return NoopWriter(), nil
 	}
 
 	// Default to 1 second timeout httpClient.
-	if opts.httpClient == nil {
+	defaultHTTPClient := opts.httpClient == nil
+	if defaultHTTPClient {
 		opts.httpClient = &http.Client{Timeout: 1 * time.Second}
 	}
 
-	// Use ParseRequestURI over Parse because Parse validation is more loose and will accept
-	// things such as relative paths without a host.
-	if _, err := url.ParseRequestURI(c.ServerURL); err != nil {
+	serverURLs, err := serverurl.NormalizeList(c.ServerURL, &serverurl.Options{
+		AllowInsecureLocalhost: opts.allowInsecureLocalhost,
+		AllowUnixSocket:        true,
+	})
+	if err != nil {
 		return nil, fmt.Errorf("failed to parse server URL: %w", err)
 	}
-
-	storedID, err := loadInstallID(appID, opts.installIDFileOverride)
-	var installID string
-	if err != nil || storedID == nil {
-		installID, err = generateInstallID()
-		if err != nil {
+	c.ServerURL = serverURLs[0]
+	if len(serverURLs) > 1 {
+		c.FallbackURLs = serverURLs[1:]
+	}
+	var resolver *doh.Resolver
+	if opts.dnsOverHTTPSURL != "" {
+		if resolver, err = doh.New(opts.dnsOverHTTPSURL); err != nil {
 			return nil, err
 		}
+	}
+	socketPath, ok := serverurl.SocketPath(c.ServerURL)
+	if ok {
+		c.ServerURL = unixSocketServerURL
+	}
+	dial := opts.dialContext
+	if dial == nil && socketPath == "" && defaultHTTPClient {
+		// Skip servers which recently could not be reached. Without a path,
+		// they are only skipped by this process.
+		var checkerPath string
+		if path, err := installIDPath(appID, opts.installIDFileOverride); err == nil {
+			checkerPath = filepath.Join(filepath.Dir(path), unreachableServersFileName)
+		}
+		checker := reachability.NewChecker(checkerPath, opts.unreachableTTL)
+		if resolver != nil {
+			checker = checker.WithFallbackResolver(resolver)
+		}
+		dial = checker.DialContext
+	}
+	opts.httpClient = withDialer(opts.httpClient, dial, socketPath)
+	opts.httpClient = observe.Client(opts.httpClient, opts.requestObserver)
+
+	// Failures of each server are backed off across runs, and servers which
+	// send Retry-After are not contacted again until it elapses.
+	var tracker *failover.Tracker
+	if path, err := installIDPath(appID, opts.installIDFileOverride); err == nil {
+		tracker = failover.NewTracker(filepath.Join(filepath.Dir(path), serverBackoffFileName))
+	}
+
+	if !opts.budgetSet {
+		opts.maxRequestsPerProcess = defaultMaxRequestsPerProcess
+		opts.maxRequestsPerDay = defaultMaxRequestsPerDay
+	}
+	// Without a path, only the per-process budget applies.
+	var budgetPath string
+	if path, err := installIDPath(appID, opts.installIDFileOverride); err == nil {
+		budgetPath = filepath.Join(filepath.Dir(path), budgetFileName)
+	}
+
+	idStore := newInstallIDStore(appID, opts.installIDFileOverride, opts.keychain)
+	idStore.now = opts.now
+	installData, err := loadOrCreateInstallID(ctx, idStore)
+	if err != nil {
+		return nil, err
+	}
+	previousVersion, downgradedFrom := recordVersion(ctx, idStore, installData, version)
+
+	var buildInfo *BuildInfo
+	if opts.buildInfo {
+		buildInfo = readBuildInfo()
+	}
+
+	requestBudget := newBudget(budgetPath, opts.maxRequestsPerProcess, opts.maxRequestsPerDay)
+	if requestBudget != nil {
+		requestBudget.now = opts.now
+	}
+
+	if !opts.circuitSet {
+		opts.circuitFailures = defaultCircuitFailures
+		opts.circuitOpenFor = defaultCircuitOpenFor
+	}
+	// Without a path, the breaker only applies to this process.
+	var circuitPath string
+	if path, err := installIDPath(appID, opts.installIDFileOverride); err == nil {
+		circuitPath = filepath.Join(filepath.Dir(path), circuitFileName)
+	}
+	breaker := newCircuit(circuitPath, opts.circuitFailures, opts.circuitOpenFor)
+	if breaker != nil {
+		breaker.now = opts.now
+	}
 
-		if err = storeInstallID(appID, opts.installIDFileOverride, &InstallIDData{
-			InstallID: installID,
-		}); err != nil {
-			logging.FromContext(ctx).DebugContext(ctx, "error storing InstallID", "error", err.Error())
+	var seq *sequence
+	if opts.sequenceNumbers {
+		// Without a path, the sequence restarts with each process.
+		var seqPath string
+		if path, err := installIDPath(appID, opts.installIDFileOverride); err == nil {
+			seqPath = filepath.Join(filepath.Dir(path), sequenceFileName)
 		}
-	} else {
-		installID = storedID.InstallID
+		seq = newSequence(seqPath, installData.InstallID)
+	}
+
+	var sampleRate float64
+	if serverConfig != nil {
+		sampleRate = serverConfig.MetricsSampleRate
 	}
 
 	return &client{
-		AppID:      appID,
-		AppVersion: version,
-		InstallID:  installID,
-		HTTPClient: opts.httpClient,
-		Config:     &c,
+		AppID:                 appID,
+		AppVersion:            version,
+		InstallID:             installData.InstallID,
+		InstallTime:           installData.InstallTime,
+		PreviousVersion:       previousVersion,
+		DowngradedFromVersion: downgradedFrom,
+		HTTPClient:            opts.httpClient,
+		Config:                c,
+		FlushInterval:         opts.flushInterval,
+		UserAgent:             opts.userAgent,
+		Dispositions:          opts.dispositions,
+		Protobuf:              opts.protobuf,
+		Tracker:               tracker,
+		Budget:                requestBudget,
+		Circuit:               breaker,
+		Redactors:             opts.redactors,
+		BuildInfo:             buildInfo,
+		Sequence:              seq,
+		SampleRate:            sampleRate,
+		counters:              aggregator{now: opts.now},
+		now:                   opts.now,
 	}, nil
 }
 
-type SendMetricRequest struct {
-	// The ID of the application to check.
-	AppID string `json:"appId"`
+// loadConfig applies opt and loads metricsConfig, defaulting to environment
+// variables prefixed with toUpper(appID).
+func loadConfig(ctx context.Context, appID string, opt []Option) (*options, *metricsConfig, error) {
+	opts := &options{}
 
-	// The version of the app to check for updates.
-	// Should be of form vMAJOR[.MINOR[.PATCH[-PRERELEASE][+BUILD]]] (e.g., v1.0.1)
-	AppVersion string `json:"appVersion"`
+	for _, o := range opt {
+		opts = o(opts)
+	}
 
-	// Only single item is used now, map used for flexibility in the future.
-	Metrics map[string]int64 `json:"metrics"`
+	// Default to the environment loader.
+	if opts.lookuper == nil {
+		opts.lookuper = optout.DefaultLookuper(appID)
+	}
+	if opts.now == nil {
+		opts.now = time.Now
+	}
 
-	// InstallID. Expected to be a random base64 value.
-	InstallID string `json:"installId"`
+	var c metricsConfig
+	if err := envconfig.ProcessWith(ctx, &envconfig.Config{
+		Target:   &c,
+		Lookuper: opts.lookuper,
+	}); err != nil {
+		return nil, nil, fmt.Errorf("failed to process envconfig: %w", err)
+	}
+	return opts, &c, nil
 }
 
+// SendMetricRequest is the body of a request to the metrics server.
+type SendMetricRequest = api.SendMetricRequest
+
+// SendMetricResponse is the body of a successful response from the metrics
+// server.
+type SendMetricResponse = api.SendMetricResponse
+
+// MetricDisposition is what the server did with a single metric.
+type MetricDisposition = api.MetricDisposition
+
 // WriteMetric sends information about application usage. Noop if metrics
-// are opted out.
+// are opted out, or the user opted out of the named metric.
 // Accepts a context for cancellation.
 func (c *client) WriteMetric(ctx context.Context, name string, count int64) error {
-	if c.OptOut {
+	if c.OptOut || c.Config.MetricOptedOut(name) {
+		return nil
+	}
+
+	return c.send(ctx, &SendMetricRequest{
+		AppID:         c.AppID,
+		AppVersion:    c.AppVersion,
+		Metrics:       map[string]int64{name: count},
+		InstallID:     c.InstallID,
+		InstallCohort: CohortForInstallTime(c.InstallTime),
+	})
+}
+
+// send posts a request to the metrics server, within the client's budget.
+func (c *client) send(ctx context.Context, sendReq *SendMetricRequest) error {
+	return c.sendWithin(ctx, sendReq, c.Budget)
+}
+
+// sendWithin posts a request to the metrics server, within budget b. A nil b
+// is unlimited.
+func (c *client) sendWithin(ctx context.Context, sendReq *SendMetricRequest, b *budget) error {
+	if !sampled(c.SampleRate) {
 		return nil
 	}
+	sendReq.IncludeDispositions = c.Dispositions
+	if sendReq.EventTime != 0 {
+		// Lets the server correct EventTime for this machine's clock skew.
+		sendReq.SentTime = c.currentTime().UnixMilli()
+	}
+	if c.BuildInfo != nil {
+		// Copied, so redactors cannot change it for later requests.
+		buildInfo := *c.BuildInfo
+		sendReq.BuildInfo = &buildInfo
+	}
+
+	if err := c.Circuit.allow(); err != nil {
+		return fmt.Errorf("failed to send %d metric(s): %w", len(sendReq.Metrics), err)
+	}
+
+	ok, dropped := b.reserve()
+	if !ok {
+		b.drop(int64(len(sendReq.Metrics)))
+		return fmt.Errorf("failed to send %d metric(s): %w", len(sendReq.Metrics), ErrOverBudget)
+	}
+	sendReq.Dropped = dropped
+
+	if sendReq = redact(sendReq, c.Redactors); sendReq == nil {
+		b.unreport(dropped)
+		return nil
+	}
+	sendReq.Sequence = c.Sequence.next()
+
+	buf, contentType, err := encodeRequest(sendReq, c.Protobuf)
+	if err != nil {
+		b.unreport(dropped)
+		return err
+	}
+
+	body, compressed, err := maybeCompress(buf)
+	if err != nil {
+		b.unreport(dropped)
+		return err
+	}
+
+	var sendResp *SendMetricResponse
+	urls := append([]string{c.Config.ServerURL}, c.Config.FallbackURLs...)
+	err = c.Tracker.Do(ctx, urls, func(ctx context.Context, serverURL string) error {
+		var err error
+		sendResp, err = c.post(ctx, serverURL, body.Bytes(), contentType, compressed)
+		return err
+	})
+	c.Circuit.record(ctx, err)
+	logger := logging.FromContext(ctx)
+	if apierror.HasCode(err, apierror.CodeMetricsRetired) {
+		logger.DebugContext(ctx, "metrics server retired metrics, stop sending them",
+			"metrics", metricNames(sendReq.Metrics))
+	}
+	if apierror.HasCode(err, apierror.CodeVersionDisabled) {
+		logger.DebugContext(ctx, "metrics server disabled metrics from this version",
+			"version", sendReq.AppVersion)
+	}
+	if err != nil {
+		b.unreport(dropped)
+		return err
+	}
+
+	for _, w := range sendResp.Warnings {
+		logger.DebugContext(ctx, "metrics server returned warning", "warning", w)
+	}
+	if c.Dispositions {
+		return notAccepted(sendReq.Metrics, sendResp.Dispositions)
+	}
+	return nil
+}
+
+// encodeRequest encodes sendReq as JSON, or as protobuf if protobuf is true,
+// returning the encoded body and its content type.
+func encodeRequest(sendReq *SendMetricRequest, protobuf bool) (*bytes.Buffer, string, error) {
+	if protobuf {
+		b, err := proto.Marshal(apipb.FromSendMetricRequest(sendReq))
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to marshal metrics as protobuf: %w", err)
+		}
+		return bytes.NewBuffer(b), apipb.ContentType, nil
+	}
 
 	var buf bytes.Buffer
-	if err := json.NewEncoder(&buf).Encode(&SendMetricRequest{
-		AppID:      c.AppID,
-		AppVersion: c.AppVersion,
-		Metrics:    map[string]int64{name: count},
-		InstallID:  c.InstallID,
-	}); err != nil {
-		return fmt.Errorf("failed to marshal metrics as json: %w", err)
+	if err := json.NewEncoder(&buf).Encode(sendReq); err != nil {
+		return nil, "", fmt.Errorf("failed to marshal metrics as json: %w", err)
 	}
+	return &buf, "application/json", nil
+}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf(c.Config.ServerURL+"/sendMetrics"), &buf)
+// post posts an encoded request to a single metrics server.
+func (c *client) post(ctx context.Context, serverURL string, body []byte, contentType string, compressed bool) (*SendMetricResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, serverURL+"/sendMetrics", bytes.NewReader(body))
 	if err != nil {
-		return fmt.Errorf("failed to create http request: %w", err)
+		return nil, fmt.Errorf("failed to create http request: %w", err)
+	}
+	userAgent := c.UserAgent
+	if userAgent == "" {
+		userAgent = useragent.Format(c.AppID, c.AppVersion)
 	}
-	req.Header.Set("User-Agent", "github.com/abcxyz/abc-updater")
-	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Content-Type", contentType)
 	req.Header.Set("Accept", "application/json")
+	if compressed {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	compat.SetVersionHeader(req.Header)
 
 	resp, err := c.HTTPClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to make http request: %w", err)
+		return nil, fmt.Errorf("failed to make http request: %w", err)
 	}
 	defer resp.Body.Close()
+	compat.WarnIfUnsupported(ctx, resp.Header)
 
 	// Future releases may be more strict.
 	if resp.StatusCode >= 300 || resp.StatusCode <= 199 {
-		b, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorResponseBytes))
-		if err != nil {
-			return fmt.Errorf("received %d response, unable to read response body", resp.StatusCode)
+		return nil, apierror.FromResponse(resp)
+	}
+	return decodeResponse(resp.Body), nil
+}
+
+// decodeResponse decodes a successful response body. The body is optional, so
+// a missing or malformed one results in an empty response.
+func decodeResponse(body io.Reader) *SendMetricResponse {
+	var sendResp SendMetricResponse
+	if err := json.NewDecoder(io.LimitReader(body, maxResponseBytes)).Decode(&sendResp); err != nil {
+		return &SendMetricResponse{}
+	}
+	return &sendResp
+}
+
+// notAccepted returns an error wrapping ErrMetricNotAccepted for each metric
+// the server reported it did not accept. Metrics without a disposition, e.g.
+// from servers which predate them, are assumed accepted.
+func notAccepted(sent map[string]int64, dispositions map[string]MetricDisposition) error {
+	var errs []error
+	for _, name := range metricNames(sent) {
+		if d, ok := dispositions[name]; ok && d != api.DispositionAccepted {
+			errs = append(errs, fmt.Errorf("%w: %q (%s)", ErrMetricNotAccepted, name, d))
 		}
-		return fmt.Errorf("received %d response: %s", resp.StatusCode, string(b))
 	}
+	return errors.Join(errs...)
+}
+
+// metricNames returns the names of the metrics in sent, sorted.
+func metricNames(sent map[string]int64) []string {
+	names := make([]string, 0, len(sent))
+	for name := range sent {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
 
-	// For now, ignore response body for happy responses.
-	// Future versions may parse warnings for debug logging.
-	return nil
+// InstallAge returns the time since the install ID was generated. Returns false
+// if install time is unknown or metrics are opted out.
+func (c *client) InstallAge() (time.Duration, bool) {
+	if c.OptOut || c.InstallTime <= 0 {
+		return 0, false
+	}
+	return c.currentTime().Sub(time.Unix(c.InstallTime, 0)), true
+}
+
+// currentTime returns the time from c.now, or time.Now if it is nil.
+func (c *client) currentTime() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+// UpgradedFrom returns the version of the app run previously on this machine,
+// if the app has since been upgraded.
+func (c *client) UpgradedFrom() (string, bool) {
+	return c.PreviousVersion, c.PreviousVersion != ""
+}
+
+// ReportUpgrade sends the upgrade metric, recording fromVersion as the version
+// upgraded from. Noop if metrics are opted out or the upgrade metric is opted
+// out.
+func (c *client) ReportUpgrade(ctx context.Context, fromVersion string) error {
+	if c.OptOut || c.Config.MetricOptedOut(UpgradeMetric) {
+		return nil
+	}
+	return c.send(ctx, &SendMetricRequest{
+		AppID:         c.AppID,
+		AppVersion:    c.AppVersion,
+		Metrics:       map[string]int64{UpgradeMetric: 1},
+		InstallID:     c.InstallID,
+		InstallCohort: CohortForInstallTime(c.InstallTime),
+		UpgradedFrom:  fromVersion,
+	})
+}
+
+// DowngradedFrom returns the version of the app run previously on this
+// machine, if the app has since been downgraded.
+func (c *client) DowngradedFrom() (string, bool) {
+	return c.DowngradedFromVersion, c.DowngradedFromVersion != ""
+}
+
+// ReportDowngrade sends the downgrade metric, recording fromVersion as the
+// version downgraded from. Noop if metrics are opted out or the downgrade
+// metric is opted out.
+func (c *client) ReportDowngrade(ctx context.Context, fromVersion string) error {
+	if c.OptOut || c.Config.MetricOptedOut(DowngradeMetric) {
+		return nil
+	}
+	return c.send(ctx, &SendMetricRequest{
+		AppID:          c.AppID,
+		AppVersion:     c.AppVersion,
+		Metrics:        map[string]int64{DowngradeMetric: 1},
+		InstallID:      c.InstallID,
+		InstallCohort:  CohortForInstallTime(c.InstallTime),
+		DowngradedFrom: fromVersion,
+	})
+}
+
+// ReportUninstall sends the uninstall metric.
+func (c *client) ReportUninstall(ctx context.Context) error {
+	if c.OptOut || c.Config.MetricOptedOut(UninstallMetric) {
+		return nil
+	}
+	return c.send(ctx, &SendMetricRequest{
+		AppID:         c.AppID,
+		AppVersion:    c.AppVersion,
+		Metrics:       map[string]int64{UninstallMetric: 1},
+		InstallID:     c.InstallID,
+		InstallCohort: CohortForInstallTime(c.InstallTime),
+	})
+}
+
+// maybeCompress gzip compresses buf if it is at least
+// compressionThresholdBytes long. Small payloads are sent as is, as
+// compression overhead outweighs the benefit.
+func maybeCompress(buf *bytes.Buffer) (*bytes.Buffer, bool, error) {
+	if buf.Len() < compressionThresholdBytes {
+		return buf, false, nil
+	}
+
+	var out bytes.Buffer
+	gz := gzip.NewWriter(&out)
+	if _, err := buf.WriteTo(gz); err != nil {
+		return nil, false, fmt.Errorf("failed to compress request body: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, false, fmt.Errorf("failed to compress request body: %w", err)
+	}
+	return &out, true, nil
 }
 
 // NoopWriter returns a MetricWriter which is opted-out and will not send metrics.
+// Like the MetricWriters returned by New, it implements AsyncMetricWriter,
+// LifecycleWriter, and CounterWriter.
 func NoopWriter() MetricWriter {
 	return &client{OptOut: true}
 }
@@ -16,21 +16,33 @@ package metrics
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/sethvargo/go-envconfig"
+	"github.com/thejerf/slogassert"
+	"google.golang.org/protobuf/proto"
 
+	"github.com/abcxyz/abc-updater/pkg/api/apipb"
+	"github.com/abcxyz/abc-updater/pkg/apierror"
+	"github.com/abcxyz/abc-updater/pkg/optout"
+	"github.com/abcxyz/abc-updater/pkg/useragent"
+	"github.com/abcxyz/pkg/logging"
 	"github.com/abcxyz/pkg/testutil"
 )
 
@@ -50,7 +62,6 @@ func defaultClient() *client {
 		OptOut:     false,
 		Config: &metricsConfig{
 			ServerURL: testServerURL,
-			NoMetrics: false,
 		},
 	}
 }
@@ -95,7 +106,7 @@ func TestNew(t *testing.T) {
 
 				installPath := t.TempDir() + "/" + installIDFileName
 				if tc.installID != "" {
-					if err := storeInstallID(testAppID, installPath, &InstallIDData{tc.installID}); err != nil {
+					if err := storeInstallID(&installIDStore{path: installPath}, &InstallIDData{InstallID: tc.installID}); err != nil {
 						t.Fatalf("test setup failed: %s", err.Error())
 					}
 				}
@@ -120,7 +131,7 @@ func TestNew(t *testing.T) {
 					t.Fatal("Expected New to return client, but cast failed.")
 				}
 
-				storedID, err := loadInstallID(testAppID, installPath)
+				storedID, err := loadInstallID(&installIDStore{path: installPath})
 				if err != nil {
 					t.Fatalf("could not load install ID for checking side effects")
 				}
@@ -135,11 +146,46 @@ func TestNew(t *testing.T) {
 					tc.want.InstallID = got.InstallID
 				}
 
+				if got.InstallTime == 0 {
+					t.Errorf("install time not set")
+				}
+				if diff := cmp.Diff(got.InstallTime, storedID.InstallTime); diff != "" {
+					t.Errorf("install time in client does not match stored. Diff (-client +stored): %s", diff)
+				}
+				// Generated or backfilled from file, so copy from got to want.
+				tc.want.InstallTime = got.InstallTime
+
 				if diff := cmp.Diff(got.InstallID, storedID.InstallID); diff != "" {
 					t.Errorf("install id in client does not match stored. Diff (-client +stored): %s", diff)
 				}
 
-				if diff := cmp.Diff(got, tc.want); diff != "" {
+				// The default client's transport skips unreachable servers.
+				if tc.client == nil && got.HTTPClient.Transport == nil {
+					t.Errorf("expected default client to have a transport")
+				}
+				// The default budget is persisted next to the install ID.
+				if got.Budget == nil ||
+					got.Budget.path != filepath.Join(filepath.Dir(installPath), budgetFileName) ||
+					got.Budget.maxPerProcess != defaultMaxRequestsPerProcess ||
+					got.Budget.maxPerDay != defaultMaxRequestsPerDay {
+					t.Errorf("unexpected default budget %#v", got.Budget)
+				}
+				// Server backoff is persisted next to the install ID.
+				if got.Tracker == nil {
+					t.Errorf("expected server backoff tracker")
+				}
+				// So is the default circuit breaker.
+				if got.Circuit == nil ||
+					got.Circuit.path != filepath.Join(filepath.Dir(installPath), circuitFileName) ||
+					got.Circuit.threshold != defaultCircuitFailures ||
+					got.Circuit.openFor != defaultCircuitOpenFor {
+					t.Errorf("unexpected default circuit breaker %#v", got.Circuit)
+				}
+				if diff := cmp.Diff(got, tc.want,
+					cmpopts.IgnoreUnexported(client{}, optout.Config{}),
+					cmpopts.IgnoreFields(http.Client{}, "Transport"),
+					cmpopts.IgnoreFields(client{}, "Budget", "Tracker", "Circuit"),
+				); diff != "" {
 					t.Errorf("unexpected client fields. Diff (-got +want): %s", diff)
 				}
 			})
@@ -156,6 +202,7 @@ func TestNew(t *testing.T) {
 			env       map[string]string
 			want      *client
 			wantError string
+			wantErrIs error
 		}{
 			{
 				name:      "empty_app_id_fails",
@@ -169,11 +216,26 @@ func TestNew(t *testing.T) {
 				want:      NoopWriter().(*client),
 				wantError: "",
 			},
+			{
+				name:      "opt_out_all_env_noop_no_err",
+				appID:     testAppID,
+				env:       map[string]string{"NO_METRICS": "all"},
+				want:      NoopWriter().(*client),
+				wantError: "",
+			},
 			{
 				name:      "bad_url_noop",
 				appID:     testAppID,
 				env:       map[string]string{"METRICS_URL": "htttpq://%foo*(*fg.com4/\\"},
 				wantError: "failed to parse server URL",
+				wantErrIs: ErrInvalidServerURL,
+			},
+			{
+				name:      "insecure_url_fails",
+				appID:     testAppID,
+				env:       map[string]string{"METRICS_URL": "http://example.com"},
+				wantError: "must use https",
+				wantErrIs: ErrInvalidServerURL,
 			},
 		}
 
@@ -191,18 +253,81 @@ func TestNew(t *testing.T) {
 					if !ok {
 						t.Fatal("Expected New to return client, but cast failed.")
 					}
-					if diff := cmp.Diff(gotV, tc.want); diff != "" {
+					if diff := cmp.Diff(gotV, tc.want, cmpopts.IgnoreUnexported(client{}, optout.Config{})); diff != "" {
 						t.Errorf("unexpected metricWriter value. Diff (-got +want): %s", diff)
 					}
 				}
 				if diff := testutil.DiffErrString(err, tc.wantError); diff != "" {
 					t.Errorf("unexpected error: %s", diff)
 				}
+				if tc.wantErrIs != nil && !errors.Is(err, tc.wantErrIs) {
+					t.Errorf("expected error to wrap %v, got %v", tc.wantErrIs, err)
+				}
 			})
 		}
 	})
 }
 
+func TestNew_WithDNSOverHTTPS(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name      string
+		url       string
+		wantError string
+	}{
+		{
+			name: "valid",
+			url:  "https://1.1.1.1/dns-query",
+		},
+		{
+			name:      "not_https",
+			url:       "http://1.1.1.1/dns-query",
+			wantError: "must be an https URL",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := New(context.Background(), testAppID, testVersion,
+				WithLookuper(envconfig.MapLookuper(map[string]string{"METRICS_URL": testServerURL})),
+				WithInstallIDFileOverride(filepath.Join(t.TempDir(), installIDFileName)),
+				WithDNSOverHTTPS(tc.url))
+			if diff := testutil.DiffErrString(err, tc.wantError); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestNew_WithNowFunc(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+	w, err := New(context.Background(), testAppID, testVersion,
+		WithLookuper(envconfig.MapLookuper(map[string]string{"METRICS_URL": testServerURL})),
+		WithInstallIDFileOverride(filepath.Join(t.TempDir(), installIDFileName)),
+		WithNowFunc(func() time.Time { return now }))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	got, ok := w.(*client)
+	if !ok {
+		t.Fatal("Expected New to return client, but cast failed.")
+	}
+	if got, want := got.InstallTime, now.Unix(); got != want {
+		t.Errorf("unexpected install time. got %d want %d", got, want)
+	}
+	if age, ok := got.InstallAge(); !ok || age != 0 {
+		t.Errorf("unexpected install age. got %s, %t want 0s, true", age, ok)
+	}
+	if got, want := got.Budget.now(), now; !got.Equal(want) {
+		t.Errorf("unexpected budget time. got %s want %s", got, want)
+	}
+}
+
 func TestWriteMetric(t *testing.T) {
 	t.Parallel()
 
@@ -270,6 +395,23 @@ func TestWriteMetric(t *testing.T) {
 				InstallID:  testInstallID,
 			},
 		},
+		{
+			name:   "metric_includes_install_cohort",
+			metric: "foo",
+			count:  1,
+			client: func() *client {
+				c := defaultClient()
+				c.InstallTime = time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC).Unix()
+				return c
+			}(),
+			wantRequest: &SendMetricRequest{
+				AppID:         testAppID,
+				AppVersion:    testVersion,
+				Metrics:       map[string]int64{"foo": 1},
+				InstallID:     testInstallID,
+				InstallCohort: "2024-W05",
+			},
+		},
 		{
 			name:   "metric_opt_out_noop",
 			metric: "foo",
@@ -281,6 +423,33 @@ func TestWriteMetric(t *testing.T) {
 			}(),
 			wantRequest: nil,
 		},
+		{
+			name:   "metric_individually_opted_out_noop",
+			metric: "foo",
+			count:  1,
+			client: func() *client {
+				c := defaultClient()
+				c.Config.NoMetrics = []string{"bar", "foo"}
+				return c
+			}(),
+			wantRequest: nil,
+		},
+		{
+			name:   "metric_other_opted_out_sends",
+			metric: "foo",
+			count:  1,
+			client: func() *client {
+				c := defaultClient()
+				c.Config.NoMetrics = []string{"bar"}
+				return c
+			}(),
+			wantRequest: &SendMetricRequest{
+				AppID:      testAppID,
+				AppVersion: testVersion,
+				Metrics:    map[string]int64{"foo": 1},
+				InstallID:  testInstallID,
+			},
+		},
 		{
 			name:                 "metric_4xx_returns_error",
 			metric:               "foo",
@@ -353,3 +522,488 @@ func TestWriteMetric(t *testing.T) {
 		})
 	}
 }
+
+func TestMaybeCompress(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name           string
+		body           string
+		wantCompressed bool
+	}{
+		{
+			name:           "small_body_uncompressed",
+			body:           `{"appId":"foo"}`,
+			wantCompressed: false,
+		},
+		{
+			name:           "large_body_compressed",
+			body:           strings.Repeat("a", compressionThresholdBytes),
+			wantCompressed: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, compressed, err := maybeCompress(bytes.NewBufferString(tc.body))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if compressed != tc.wantCompressed {
+				t.Errorf("unexpected compressed value. got %t want %t", compressed, tc.wantCompressed)
+			}
+
+			var r io.Reader = got
+			if compressed {
+				gz, err := gzip.NewReader(got)
+				if err != nil {
+					t.Fatalf("failed to read gzip body: %s", err.Error())
+				}
+				r = gz
+			}
+			b, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("failed to read body: %s", err.Error())
+			}
+			if diff := cmp.Diff(string(b), tc.body); diff != "" {
+				t.Errorf("unexpected body. Diff (-got +want): %s", diff)
+			}
+		})
+	}
+}
+
+func TestInstallAge(t *testing.T) {
+	t.Parallel()
+
+	c := defaultClient()
+	if _, ok := c.InstallAge(); ok {
+		t.Errorf("expected unknown install age when install time is unset")
+	}
+
+	c.InstallTime = time.Now().Add(-48 * time.Hour).Unix()
+	got, ok := c.InstallAge()
+	if !ok {
+		t.Fatalf("expected known install age")
+	}
+	if got < 47*time.Hour || got > 49*time.Hour {
+		t.Errorf("unexpected install age %s, want approximately 48h", got)
+	}
+
+	if _, ok := NoopWriter().(LifecycleWriter).InstallAge(); ok {
+		t.Errorf("expected unknown install age for noop writer")
+	}
+}
+
+func TestReportUpgrade(t *testing.T) {
+	t.Parallel()
+
+	var got SendMetricRequest
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("error reading request to test server: %s", err.Error())
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(ts.Close)
+
+	c := defaultClient()
+	c.Config.ServerURL = ts.URL
+	c.PreviousVersion = "0.9.0"
+
+	from, ok := c.UpgradedFrom()
+	if !ok {
+		t.Fatalf("expected client to report upgrade")
+	}
+	if err := c.ReportUpgrade(context.Background(), from); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	want := SendMetricRequest{
+		AppID:        testAppID,
+		AppVersion:   testVersion,
+		Metrics:      map[string]int64{UpgradeMetric: 1},
+		InstallID:    testInstallID,
+		UpgradedFrom: "0.9.0",
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("unexpected request body. Diff (-got +want): %s", diff)
+	}
+}
+
+func TestReportDowngrade(t *testing.T) {
+	t.Parallel()
+
+	var got SendMetricRequest
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("error reading request to test server: %s", err.Error())
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(ts.Close)
+
+	c := defaultClient()
+	c.Config.ServerURL = ts.URL
+	c.DowngradedFromVersion = "1.1.0"
+
+	from, ok := c.DowngradedFrom()
+	if !ok {
+		t.Fatalf("expected client to report downgrade")
+	}
+	if err := c.ReportDowngrade(context.Background(), from); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	want := SendMetricRequest{
+		AppID:          testAppID,
+		AppVersion:     testVersion,
+		Metrics:        map[string]int64{DowngradeMetric: 1},
+		InstallID:      testInstallID,
+		DowngradedFrom: "1.1.0",
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("unexpected request body. Diff (-got +want): %s", diff)
+	}
+}
+
+func TestReportUninstall(t *testing.T) {
+	t.Parallel()
+
+	var got SendMetricRequest
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("error reading request to test server: %s", err.Error())
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(ts.Close)
+
+	c := defaultClient()
+	c.Config.ServerURL = ts.URL
+
+	if err := c.ReportUninstall(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	want := SendMetricRequest{
+		AppID:      testAppID,
+		AppVersion: testVersion,
+		Metrics:    map[string]int64{UninstallMetric: 1},
+		InstallID:  testInstallID,
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("unexpected request body. Diff (-got +want): %s", diff)
+	}
+}
+
+func TestWriteMetric_UserAgent(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name      string
+		userAgent string
+		want      string
+	}{
+		{
+			name: "default",
+			want: "abc-updater/" + useragent.LibraryVersion() + " (" + testAppID + "/" + testVersion + ")",
+		},
+		{
+			name:      "override",
+			userAgent: "my-tool/1.0",
+			want:      "my-tool/1.0",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var got string
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.Header.Get("User-Agent")
+				w.WriteHeader(http.StatusAccepted)
+			}))
+			t.Cleanup(ts.Close)
+
+			c := defaultClient()
+			c.Config.ServerURL = ts.URL
+			c.UserAgent = tc.userAgent
+			if err := c.WriteMetric(context.Background(), "foo", 1); err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if got != tc.want {
+				t.Errorf("unexpected User-Agent. got %q want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWriteMetric_Warnings(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprint(w, `{"message":"ok","warnings":["metric \"foo\" not allowed"]}`)
+	}))
+	t.Cleanup(ts.Close)
+
+	logHandler := slogassert.New(t, slog.LevelDebug, nil)
+	ctx := logging.WithLogger(context.Background(), slog.New(logHandler))
+
+	c := defaultClient()
+	c.Config.ServerURL = ts.URL
+	if err := c.WriteMetric(ctx, "foo", 1); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	logHandler.AssertPrecise(slogassert.LogMessageMatch{
+		Message:       "metrics server returned warning",
+		Level:         slog.LevelDebug,
+		Attrs:         map[string]any{"warning": `metric "foo" not allowed`},
+		AllAttrsMatch: true,
+	})
+}
+
+func TestWriteMetric_Retired(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+		fmt.Fprint(w, `{"code":"METRICS_RETIRED","message":"all metrics in the request are retired"}`)
+	}))
+	t.Cleanup(ts.Close)
+
+	logHandler := slogassert.New(t, slog.LevelDebug, nil)
+	ctx := logging.WithLogger(context.Background(), slog.New(logHandler))
+
+	c := defaultClient()
+	c.Config.ServerURL = ts.URL
+	err := c.WriteMetric(ctx, "foo", 1)
+	if !apierror.HasCode(err, apierror.CodeMetricsRetired) {
+		t.Errorf("got error %v want code %s", err, apierror.CodeMetricsRetired)
+	}
+	logHandler.AssertPrecise(slogassert.LogMessageMatch{
+		Message:       "metrics server retired metrics, stop sending them",
+		Level:         slog.LevelDebug,
+		Attrs:         map[string]any{"metrics": []string{"foo"}},
+		AllAttrsMatch: true,
+	})
+}
+
+func TestWriteMetric_VersionDisabled(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+		fmt.Fprint(w, `{"code":"VERSION_DISABLED","message":"metrics from version are disabled"}`)
+	}))
+	t.Cleanup(ts.Close)
+
+	logHandler := slogassert.New(t, slog.LevelDebug, nil)
+	ctx := logging.WithLogger(context.Background(), slog.New(logHandler))
+
+	c := defaultClient()
+	c.Config.ServerURL = ts.URL
+	err := c.WriteMetric(ctx, "foo", 1)
+	if !apierror.HasCode(err, apierror.CodeVersionDisabled) {
+		t.Errorf("got error %v want code %s", err, apierror.CodeVersionDisabled)
+	}
+	logHandler.AssertPrecise(slogassert.LogMessageMatch{
+		Message:       "metrics server disabled metrics from this version",
+		Level:         slog.LevelDebug,
+		Attrs:         map[string]any{"version": testVersion},
+		AllAttrsMatch: true,
+	})
+}
+
+func TestWriteMetric_Dispositions(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name         string
+		dispositions bool
+		response     string
+		wantRequest  bool
+		wantErr      string
+	}{
+		{
+			name:         "accepted",
+			dispositions: true,
+			response:     `{"message":"ok","dispositions":{"foo":"accepted"}}`,
+			wantRequest:  true,
+		},
+		{
+			name:         "unknown_metric",
+			dispositions: true,
+			response:     `{"message":"ok","dispositions":{"foo":"unknown_metric"}}`,
+			wantRequest:  true,
+			wantErr:      `metric not accepted by server: "foo" (unknown_metric)`,
+		},
+		{
+			name:         "server_without_dispositions",
+			dispositions: true,
+			response:     `{"message":"ok"}`,
+			wantRequest:  true,
+		},
+		{
+			name:     "disabled",
+			response: `{"message":"ok","dispositions":{"foo":"unknown_metric"}}`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var gotRequest bool
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var req SendMetricRequest
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					t.Errorf("failed to decode request: %s", err.Error())
+				}
+				gotRequest = req.IncludeDispositions
+				w.WriteHeader(http.StatusAccepted)
+				fmt.Fprint(w, tc.response)
+			}))
+			t.Cleanup(ts.Close)
+
+			c := defaultClient()
+			c.Config.ServerURL = ts.URL
+			c.Dispositions = tc.dispositions
+			err := c.WriteMetric(context.Background(), "foo", 1)
+			if diff := testutil.DiffErrString(err, tc.wantErr); diff != "" {
+				t.Error(diff)
+			}
+			if tc.wantErr != "" && !errors.Is(err, ErrMetricNotAccepted) {
+				t.Errorf("expected error to wrap ErrMetricNotAccepted, got %v", err)
+			}
+			if got, want := gotRequest, tc.wantRequest; got != want {
+				t.Errorf("unexpected includeDispositions. got %t want %t", got, want)
+			}
+		})
+	}
+}
+
+func TestWriteMetric_Protobuf(t *testing.T) {
+	t.Parallel()
+
+	var gotContentType string
+	var got apipb.SendMetricRequest
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotContentType = r.Header.Get("Content-Type")
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("failed to read request: %s", err.Error())
+		}
+		if err := proto.Unmarshal(b, &got); err != nil {
+			t.Errorf("failed to decode request: %s", err.Error())
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(ts.Close)
+
+	c := defaultClient()
+	c.Config.ServerURL = ts.URL
+	c.Protobuf = true
+	if err := c.WriteMetric(context.Background(), "foo", 2); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if got, want := gotContentType, apipb.ContentType; got != want {
+		t.Errorf("unexpected Content-Type. got %q want %q", got, want)
+	}
+	if diff := cmp.Diff(got.ToAPI(), &SendMetricRequest{
+		AppID:         testAppID,
+		AppVersion:    testVersion,
+		Metrics:       map[string]int64{"foo": 2},
+		InstallID:     c.InstallID,
+		InstallCohort: CohortForInstallTime(c.InstallTime),
+	}); diff != "" {
+		t.Errorf("unexpected request (-got,+want): %s", diff)
+	}
+}
+
+func TestWriteMetric_FallbackServers(t *testing.T) {
+	t.Parallel()
+
+	var primaryCalls int
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		primaryCalls++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	t.Cleanup(primary.Close)
+
+	var mirrorMetrics []string
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req SendMetricRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("failed to decode request: %s", err.Error())
+		}
+		for name := range req.Metrics {
+			mirrorMetrics = append(mirrorMetrics, name)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(mirror.Close)
+
+	dir := t.TempDir()
+	mw, err := New(context.Background(), testAppID, testVersion,
+		WithLookuper(envconfig.MapLookuper(map[string]string{"METRICS_URL": primary.URL + "," + mirror.URL})),
+		WithInstallIDFileOverride(filepath.Join(dir, installIDFileName)),
+		WithAllowInsecureLocalhost())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	for _, name := range []string{"foo", "bar"} {
+		if err := mw.WriteMetric(context.Background(), name, 1); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+	}
+
+	if diff := cmp.Diff(mirrorMetrics, []string{"foo", "bar"}); diff != "" {
+		t.Errorf("unexpected metrics sent to mirror (-got,+want): %s", diff)
+	}
+	// The failed primary is backed off after the first metric.
+	if primaryCalls != 1 {
+		t.Errorf("unexpected number of calls to primary. got %d want 1", primaryCalls)
+	}
+}
+
+func TestWriteMetric_RequestObserver(t *testing.T) {
+	t.Parallel()
+
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	t.Cleanup(primary.Close)
+
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(mirror.Close)
+
+	var got []string
+	mw, err := New(context.Background(), testAppID, testVersion,
+		WithLookuper(envconfig.MapLookuper(map[string]string{"METRICS_URL": primary.URL + "," + mirror.URL})),
+		WithInstallIDFileOverride(filepath.Join(t.TempDir(), installIDFileName)),
+		WithAllowInsecureLocalhost(),
+		WithRequestObserver(func(method, url string, status int, dur time.Duration) {
+			got = append(got, fmt.Sprintf("%s %s %d", method, url, status))
+		}))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if err := mw.WriteMetric(context.Background(), "foo", 1); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	want := []string{
+		"POST " + primary.URL + "/sendMetrics 502",
+		"POST " + mirror.URL + "/sendMetrics 202",
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("unexpected observed requests (-got,+want): %s", diff)
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+)
+
+const (
+	defaultRequestMetric       = "http_requests"
+	defaultErrorMetric         = "http_errors"
+	defaultLatencyMetricPrefix = "http_latency"
+)
+
+// defaultLatencyBuckets are the upper bounds used when none are configured.
+var defaultLatencyBuckets = []time.Duration{
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	1 * time.Second,
+	5 * time.Second,
+}
+
+// HTTPMiddlewareOptions configures HTTPMiddleware. Every metric name produced
+// must be in the app's allowlist on the metrics server, or it will be dropped.
+type HTTPMiddlewareOptions struct {
+	// RequestMetric is incremented once per request. Defaults to
+	// "http_requests".
+	RequestMetric string
+
+	// ErrorMetric is incremented once per request which results in a 5xx
+	// response. Defaults to "http_errors".
+	ErrorMetric string
+
+	// LatencyMetricPrefix is the prefix for latency bucket metrics. Each
+	// request increments exactly one of "<prefix>_le_<bucket>" or
+	// "<prefix>_gt_<largest bucket>". Defaults to "http_latency".
+	LatencyMetricPrefix string
+
+	// LatencyBuckets are the upper bounds of latency buckets. Defaults to
+	// 100ms, 500ms, 1s, and 5s.
+	LatencyBuckets []time.Duration
+}
+
+// HTTPMiddleware returns a function which wraps an http.Handler, reporting
+// request counts, server errors, and latency buckets to the given
+// MetricWriter. If mw is a CounterWriter, such as the client returned by New,
+// metrics are counted with its Counters and sent together every flush
+// interval. Otherwise, if mw is an AsyncMetricWriter, metrics are written with
+// WriteMetricAsync. Either way, callers should Close mw on shutdown. A nil
+// opts uses defaults.
+func HTTPMiddleware(mw MetricWriter, opts *HTTPMiddlewareOptions) func(http.Handler) http.Handler {
+	o := HTTPMiddlewareOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.RequestMetric == "" {
+		o.RequestMetric = defaultRequestMetric
+	}
+	if o.ErrorMetric == "" {
+		o.ErrorMetric = defaultErrorMetric
+	}
+	if o.LatencyMetricPrefix == "" {
+		o.LatencyMetricPrefix = defaultLatencyMetricPrefix
+	}
+	buckets := slices.Clone(o.LatencyBuckets)
+	if len(buckets) == 0 {
+		buckets = slices.Clone(defaultLatencyBuckets)
+	}
+	slices.Sort(buckets)
+
+	count := func(ctx context.Context, name string) {
+		writeMetricAsync(ctx, mw, name, 1)
+	}
+	if cw, ok := mw.(CounterWriter); ok {
+		names := []string{o.RequestMetric, o.ErrorMetric, latencyOverflowMetric(o.LatencyMetricPrefix, buckets)}
+		for _, b := range buckets {
+			names = append(names, latencyBucketMetric(o.LatencyMetricPrefix, buckets, b))
+		}
+		counters := make(map[string]*Counter, len(names))
+		for _, name := range names {
+			counters[name] = cw.Counter(name)
+		}
+		count = func(_ context.Context, name string) {
+			counters[name].Inc()
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			sw := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(sw, r)
+			elapsed := time.Since(start)
+
+			// Request context may be canceled once the handler returns.
+			ctx := context.WithoutCancel(r.Context())
+			count(ctx, o.RequestMetric)
+			if sw.status >= http.StatusInternalServerError {
+				count(ctx, o.ErrorMetric)
+			}
+			count(ctx, latencyBucketMetric(o.LatencyMetricPrefix, buckets, elapsed))
+		})
+	}
+}
+
+// latencyBucketMetric returns the metric name for the smallest bucket which
+// contains d. buckets must be sorted.
+func latencyBucketMetric(prefix string, buckets []time.Duration, d time.Duration) string {
+	for _, b := range buckets {
+		if d <= b {
+			return fmt.Sprintf("%s_le_%s", prefix, b)
+		}
+	}
+	return latencyOverflowMetric(prefix, buckets)
+}
+
+// latencyOverflowMetric returns the metric name for durations beyond the
+// largest bucket. buckets must be sorted.
+func latencyOverflowMetric(prefix string, buckets []time.Duration) string {
+	return fmt.Sprintf("%s_gt_%s", prefix, buckets[len(buckets)-1])
+}
+
+// statusRecorder records the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if !s.wroteHeader {
+		s.wroteHeader = true
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
@@ -0,0 +1,202 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var (
+	_ MetricWriter      = (*recordingWriter)(nil)
+	_ AsyncMetricWriter = (*asyncRecordingWriter)(nil)
+)
+
+// recordingWriter is a MetricWriter which records metric names written.
+type recordingWriter struct {
+	mu    sync.Mutex
+	names []string
+}
+
+func (w *recordingWriter) WriteMetric(ctx context.Context, name string, count int64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.names = append(w.names, name)
+	return nil
+}
+
+// asyncRecordingWriter is an AsyncMetricWriter which records metric names
+// written, and whether they were written async.
+type asyncRecordingWriter struct {
+	recordingWriter
+	async int
+}
+
+func (w *asyncRecordingWriter) WriteMetricAsync(ctx context.Context, name string, count int64) {
+	w.mu.Lock()
+	w.async++
+	w.mu.Unlock()
+	_ = w.WriteMetric(ctx, name, count)
+}
+
+func (w *asyncRecordingWriter) Flush(ctx context.Context) error {
+	return nil
+}
+
+func (w *asyncRecordingWriter) Close(ctx context.Context) error {
+	return nil
+}
+
+func TestHTTPMiddleware(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		opts   *HTTPMiddlewareOptions
+		status int
+		delay  time.Duration
+		want   []string
+	}{
+		{
+			name:   "defaults_ok_response",
+			status: http.StatusOK,
+			want:   []string{"http_latency_le_100ms", "http_requests"},
+		},
+		{
+			name:   "defaults_server_error",
+			status: http.StatusInternalServerError,
+			want:   []string{"http_errors", "http_latency_le_100ms", "http_requests"},
+		},
+		{
+			name:   "client_error_not_counted",
+			status: http.StatusNotFound,
+			want:   []string{"http_latency_le_100ms", "http_requests"},
+		},
+		{
+			name: "custom_names_and_overflow_bucket",
+			opts: &HTTPMiddlewareOptions{
+				RequestMetric:       "req",
+				ErrorMetric:         "err",
+				LatencyMetricPrefix: "lat",
+				LatencyBuckets:      []time.Duration{time.Millisecond},
+			},
+			status: http.StatusBadGateway,
+			delay:  5 * time.Millisecond,
+			want:   []string{"err", "lat_gt_1ms", "req"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			rw := &asyncRecordingWriter{}
+			h := HTTPMiddleware(rw, tc.opts)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(tc.delay)
+				w.WriteHeader(tc.status)
+			}))
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+			sort.Strings(rw.names)
+			if diff := cmp.Diff(rw.names, tc.want); diff != "" {
+				t.Errorf("unexpected metrics. Diff (-got +want): %s", diff)
+			}
+			if got, want := rw.async, len(tc.want); got != want {
+				t.Errorf("got %d async writes, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestHTTPMiddleware_SyncWriter(t *testing.T) {
+	t.Parallel()
+
+	rw := &recordingWriter{}
+	h := HTTPMiddleware(rw, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	sort.Strings(rw.names)
+	if diff := cmp.Diff(rw.names, []string{"http_latency_le_100ms", "http_requests"}); diff != "" {
+		t.Errorf("unexpected metrics. Diff (-got +want): %s", diff)
+	}
+}
+
+func TestHTTPMiddleware_Counters(t *testing.T) {
+	t.Parallel()
+
+	rec := &metricsRecorder{}
+	ts := httptest.NewServer(rec)
+	t.Cleanup(ts.Close)
+
+	c := defaultClient()
+	c.Config.ServerURL = ts.URL
+
+	h := HTTPMiddleware(c, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	for i := 0; i < 20; i++ {
+		path := "/"
+		if i%4 == 0 {
+			path = "/fail"
+		}
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	ctx := context.Background()
+	if got := len(rec.requests()); got != 0 {
+		t.Errorf("got %d requests before flush, want 0", got)
+	}
+	if err := c.Close(ctx); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	want := []map[string]int64{{"http_requests": 20, "http_errors": 5, "http_latency_le_100ms": 20}}
+	if diff := cmp.Diff(rec.requests(), want); diff != "" {
+		t.Errorf("unexpected requests (-got,+want): %s", diff)
+	}
+}
+
+func TestLatencyBucketMetric(t *testing.T) {
+	t.Parallel()
+
+	buckets := []time.Duration{100 * time.Millisecond, time.Second}
+	cases := []struct {
+		name string
+		d    time.Duration
+		want string
+	}{
+		{name: "first_bucket", d: 10 * time.Millisecond, want: "p_le_100ms"},
+		{name: "inclusive_bound", d: 100 * time.Millisecond, want: "p_le_100ms"},
+		{name: "second_bucket", d: 200 * time.Millisecond, want: "p_le_1s"},
+		{name: "overflow", d: 2 * time.Second, want: "p_gt_1s"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			if got := latencyBucketMetric("p", buckets, tc.d); got != tc.want {
+				t.Errorf("unexpected metric name. got %q want %q", got, tc.want)
+			}
+		})
+	}
+}
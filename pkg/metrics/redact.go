@@ -0,0 +1,93 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"strings"
+)
+
+// Redactor sanitizes a request before it is sent to the metrics server. It may
+// modify and return req, or return a new request. Returning nil suppresses the
+// request entirely, without error.
+//
+// Redactors run on every request, after all other fields are set, so they are
+// a single enforcement point for what leaves the machine.
+type Redactor func(req *SendMetricRequest) *SendMetricRequest
+
+// WithRedactor registers a Redactor to run before each request is sent.
+// Redactors run in the order registered, each receiving the output of the
+// previous. FieldsSent applies them to its example request too.
+func WithRedactor(r Redactor) Option {
+	return func(o *options) *options {
+		o.redactors = append(o.redactors, r)
+		return o
+	}
+}
+
+// StripVersionMetadata is a Redactor which removes prerelease and build
+// metadata from AppVersion and UpgradedFrom, e.g. "1.2.3-rc.1+abc123" becomes
+// "1.2.3", so internal build identifiers are not sent.
+func StripVersionMetadata(req *SendMetricRequest) *SendMetricRequest {
+	req.AppVersion = stripVersionMetadata(req.AppVersion)
+	req.UpgradedFrom = stripVersionMetadata(req.UpgradedFrom)
+	return req
+}
+
+// stripVersionMetadata returns v up to the first prerelease or build
+// separator.
+func stripVersionMetadata(v string) string {
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		return v[:i]
+	}
+	return v
+}
+
+// CoarsenCounts returns a Redactor which rounds the magnitude of each metric
+// count up to a multiple of step, so exact counts are not sent. Non-zero
+// counts are never rounded to zero. A step less than 2 leaves counts
+// unchanged.
+func CoarsenCounts(step int64) Redactor {
+	return func(req *SendMetricRequest) *SendMetricRequest {
+		if step < 2 {
+			return req
+		}
+		for name, count := range req.Metrics {
+			req.Metrics[name] = coarsen(count, step)
+		}
+		return req
+	}
+}
+
+// coarsen rounds the magnitude of count up to a multiple of step.
+func coarsen(count, step int64) int64 {
+	if count < 0 {
+		return -coarsen(-count, step)
+	}
+	if rem := count % step; rem != 0 {
+		return count - rem + step
+	}
+	return count
+}
+
+// redact applies redactors to req in order, returning nil if any suppressed
+// it.
+func redact(req *SendMetricRequest, redactors []Redactor) *SendMetricRequest {
+	for _, r := range redactors {
+		if req = r(req); req == nil {
+			return nil
+		}
+	}
+	return req
+}
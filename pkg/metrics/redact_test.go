@@ -0,0 +1,155 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/sethvargo/go-envconfig"
+)
+
+func TestStripVersionMetadata(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		req  *SendMetricRequest
+		want *SendMetricRequest
+	}{
+		{
+			name: "release",
+			req:  &SendMetricRequest{AppVersion: "1.2.3", UpgradedFrom: "v1.2.2"},
+			want: &SendMetricRequest{AppVersion: "1.2.3", UpgradedFrom: "v1.2.2"},
+		},
+		{
+			name: "prerelease_and_build",
+			req:  &SendMetricRequest{AppVersion: "1.2.3-rc.1+abc123", UpgradedFrom: "1.2.2+dirty"},
+			want: &SendMetricRequest{AppVersion: "1.2.3", UpgradedFrom: "1.2.2"},
+		},
+		{
+			name: "empty",
+			req:  &SendMetricRequest{},
+			want: &SendMetricRequest{},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if diff := cmp.Diff(StripVersionMetadata(tc.req), tc.want); diff != "" {
+				t.Errorf("unexpected request (-got,+want): %s", diff)
+			}
+		})
+	}
+}
+
+func TestCoarsenCounts(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		step    int64
+		metrics map[string]int64
+		want    map[string]int64
+	}{
+		{
+			name:    "rounds_up",
+			step:    10,
+			metrics: map[string]int64{"a": 1, "b": 10, "c": 11, "d": 0},
+			want:    map[string]int64{"a": 10, "b": 10, "c": 20, "d": 0},
+		},
+		{
+			name:    "negative",
+			step:    10,
+			metrics: map[string]int64{"a": -1, "b": -20},
+			want:    map[string]int64{"a": -10, "b": -20},
+		},
+		{
+			name:    "step_one_unchanged",
+			step:    1,
+			metrics: map[string]int64{"a": 7},
+			want:    map[string]int64{"a": 7},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := CoarsenCounts(tc.step)(&SendMetricRequest{Metrics: tc.metrics})
+			if diff := cmp.Diff(got.Metrics, tc.want); diff != "" {
+				t.Errorf("unexpected metrics (-got,+want): %s", diff)
+			}
+		})
+	}
+}
+
+func TestWriteMetric_Redactors(t *testing.T) {
+	t.Parallel()
+
+	var got []*SendMetricRequest
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req SendMetricRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("failed to decode request: %s", err.Error())
+		}
+		got = append(got, &req)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(ts.Close)
+
+	mw, err := New(context.Background(), testAppID, "1.0.0-beta+abc",
+		WithLookuper(envconfig.MapLookuper(map[string]string{"METRICS_URL": ts.URL})),
+		WithInstallIDFileOverride(filepath.Join(t.TempDir(), installIDFileName)),
+		WithAllowInsecureLocalhost(),
+		WithRedactor(StripVersionMetadata),
+		WithRedactor(CoarsenCounts(5)),
+		WithRedactor(func(req *SendMetricRequest) *SendMetricRequest {
+			if _, ok := req.Metrics["secret"]; ok {
+				return nil
+			}
+			return req
+		}))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	for _, name := range []string{"foo", "secret"} {
+		if err := mw.WriteMetric(context.Background(), name, 3); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+	}
+
+	if len(got) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(got))
+	}
+	if diff := cmp.Diff(got[0].AppVersion, "1.0.0"); diff != "" {
+		t.Errorf("unexpected app version (-got,+want): %s", diff)
+	}
+	if diff := cmp.Diff(got[0].Metrics, map[string]int64{"foo": 5}); diff != "" {
+		t.Errorf("unexpected metrics (-got,+want): %s", diff)
+	}
+}
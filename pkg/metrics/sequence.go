@@ -0,0 +1,84 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"sync"
+
+	"github.com/abcxyz/abc-updater/pkg/localstore"
+)
+
+const sequenceFileName = "sequence.json"
+
+// WithSequenceNumbers numbers the requests sent by this install, starting at
+// 1, across all runs on the machine. The server counts gaps in the numbers,
+// so owners can estimate what fraction of requests are lost in the field.
+// Requests dropped over budget or suppressed by a Redactor are not numbered,
+// since they are already accounted for.
+func WithSequenceNumbers() Option {
+	return func(o *options) *options {
+		o.sequenceNumbers = true
+		return o
+	}
+}
+
+// sequenceState defines the json file that persists the sequence number.
+type sequenceState struct {
+	// InstallID the sequence is for. The sequence restarts if it changes.
+	InstallID string `json:"installId"`
+	// Last is the last sequence number used.
+	Last int64 `json:"last"`
+}
+
+// sequence numbers the requests of an install. A nil *sequence numbers
+// nothing.
+type sequence struct {
+	// path persists the sequence number. If empty, the sequence restarts with
+	// each process.
+	path      string
+	installID string
+
+	mu   sync.Mutex
+	last int64
+}
+
+// newSequence returns a sequence for installID, persisted at path.
+func newSequence(path, installID string) *sequence {
+	return &sequence{path: path, installID: installID}
+}
+
+// next returns the next sequence number, or 0 if s is nil. Persistence is
+// best effort, so concurrent processes may occasionally reuse a number.
+func (s *sequence) next() int64 {
+	if s == nil {
+		return 0
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.path == "" {
+		s.last++
+		return s.last
+	}
+
+	var st sequenceState
+	if err := localstore.LoadJSONFile(s.path, &st); err != nil || st.InstallID != s.installID {
+		st = sequenceState{InstallID: s.installID}
+	}
+	st.Last++
+	_ = localstore.StoreJSONFile(s.path, &st)
+	return st.Last
+}
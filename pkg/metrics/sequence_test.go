@@ -0,0 +1,96 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/sethvargo/go-envconfig"
+)
+
+func TestSequence_Next(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), sequenceFileName)
+	var got []int64
+	// Each sequence is as if from a separate run.
+	for _, s := range []*sequence{
+		newSequence(path, "a"),
+		newSequence(path, "a"),
+		newSequence(path, "b"),
+		newSequence("", "b"),
+		nil,
+	} {
+		got = append(got, s.next(), s.next())
+	}
+
+	want := []int64{1, 2, 3, 4, 1, 2, 1, 2, 0, 0}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("unexpected sequence numbers (-got,+want): %s", diff)
+	}
+}
+
+func TestWriteMetric_Sequence(t *testing.T) {
+	t.Parallel()
+
+	var got []int64
+	fail := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req SendMetricRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("failed to decode request: %s", err.Error())
+		}
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		got = append(got, req.Sequence)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(ts.Close)
+
+	mw, err := New(context.Background(), testAppID, testVersion,
+		WithLookuper(envconfig.MapLookuper(map[string]string{"METRICS_URL": ts.URL})),
+		WithInstallIDFileOverride(filepath.Join(t.TempDir(), installIDFileName)),
+		WithAllowInsecureLocalhost(),
+		WithSequenceNumbers())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	ctx := context.Background()
+	if err := mw.WriteMetric(ctx, "foo", 1); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	// A lost request leaves a gap in the sequence.
+	fail = true
+	if err := mw.WriteMetric(ctx, "foo", 1); err == nil {
+		t.Fatal("expected error from failing server")
+	}
+	fail = false
+	if err := mw.WriteMetric(ctx, "foo", 1); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	if diff := cmp.Diff(got, []int64{1, 3}); diff != "" {
+		t.Errorf("unexpected sequence numbers (-got,+want): %s", diff)
+	}
+}
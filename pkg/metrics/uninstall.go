@@ -0,0 +1,81 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/abcxyz/abc-updater/pkg/localstore"
+	"github.com/abcxyz/pkg/logging"
+)
+
+// RunUninstallHook sends the uninstall metric and then removes all local data
+// for the app with localstore.PurgeLocalData. It is intended to be run by a
+// package manager's removal hook, e.g. a hidden "yourtool uninstall-hook"
+// command invoked from a Debian prerm script. The metric is only sent if an
+// install ID is stored, so the hook never creates one. The data is purged even
+// if the metric cannot be sent, in which case the error is returned too.
+func RunUninstallHook(ctx context.Context, appID, version string, opt ...Option) error {
+	var merr error
+	if installIDStored(ctx, appID, opt) {
+		if err := reportUninstall(ctx, appID, version, opt); err != nil {
+			merr = errors.Join(merr, err)
+		}
+	} else {
+		logging.FromContext(ctx).DebugContext(ctx, "no InstallID stored, skipping uninstall metric",
+			"app_id", appID)
+	}
+
+	if err := localstore.PurgeLocalData(appID); err != nil {
+		merr = errors.Join(merr, err)
+	}
+	if merr != nil {
+		return fmt.Errorf("uninstall hook failed: %w", merr)
+	}
+	return nil
+}
+
+// installIDStored returns true if an install ID is stored for appID, without
+// creating one.
+func installIDStored(ctx context.Context, appID string, opt []Option) bool {
+	opts := &options{}
+	for _, o := range opt {
+		opts = o(opts)
+	}
+	_, err := newInstallIDStore(appID, opts.installIDFileOverride, opts.keychain).load(ctx)
+	return err == nil
+}
+
+// reportUninstall sends the uninstall metric with a client created by New.
+func reportUninstall(ctx context.Context, appID, version string, opt []Option) error {
+	mw, err := New(ctx, appID, version, opt...)
+	if err != nil {
+		return err
+	}
+	var merr error
+	if lw, ok := mw.(LifecycleWriter); ok {
+		if err := lw.ReportUninstall(ctx); err != nil {
+			merr = errors.Join(merr, err)
+		}
+	}
+	if aw, ok := mw.(AsyncMetricWriter); ok {
+		if err := aw.Close(ctx); err != nil {
+			merr = errors.Join(merr, err)
+		}
+	}
+	return merr
+}
@@ -0,0 +1,98 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/sethvargo/go-envconfig"
+
+	"github.com/abcxyz/abc-updater/pkg/localstore"
+)
+
+// Not parallel, as it sets HOME, and PATH so the OS keychain isn't used.
+func TestRunUninstallHook(t *testing.T) {
+	cases := []struct {
+		name        string
+		installed   bool
+		wantMetrics []map[string]int64
+	}{
+		{
+			name: "no_install_id",
+		},
+		{
+			name:        "reports_uninstall",
+			installed:   true,
+			wantMetrics: []map[string]int64{{UninstallMetric: 1}},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("HOME", t.TempDir())
+			t.Setenv("PATH", t.TempDir())
+
+			var mu sync.Mutex
+			var got []map[string]int64
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var req SendMetricRequest
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					t.Errorf("error reading request to test server: %s", err.Error())
+				}
+				mu.Lock()
+				got = append(got, req.Metrics)
+				mu.Unlock()
+				w.WriteHeader(http.StatusAccepted)
+			}))
+			t.Cleanup(ts.Close)
+
+			ctx := context.Background()
+			opts := []Option{
+				WithLookuper(envconfig.MapLookuper(map[string]string{"METRICS_URL": ts.URL})),
+				WithAllowInsecureLocalhost(),
+			}
+			if tc.installed {
+				if _, err := New(ctx, testAppID, testVersion, opts...); err != nil {
+					t.Fatalf("test setup failed: %s", err.Error())
+				}
+			}
+
+			if err := RunUninstallHook(ctx, testAppID, testVersion, opts...); err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if diff := cmp.Diff(got, tc.wantMetrics); diff != "" {
+				t.Errorf("unexpected metrics sent (-got,+want): %s", diff)
+			}
+			dir, err := localstore.DefaultDir(testAppID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(dir); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("expected %s to be removed, got err: %v", dir, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package observe reports the outbound requests made by abc-updater clients
+// to the embedding app, so it can meter and debug them.
+package observe
+
+import (
+	"net/http"
+	"time"
+)
+
+// Func is called after each request with its method and URL, the response
+// status code, and how long the request took. status is 0 if no response was
+// received, e.g. because the server could not be reached.
+type Func func(method, url string, status int, dur time.Duration)
+
+// Transport returns a RoundTripper which makes requests with base, or
+// http.DefaultTransport if nil, and reports each one to observe. base is
+// returned unmodified if observe is nil.
+func Transport(base http.RoundTripper, observe Func) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if observe == nil {
+		return base
+	}
+	return &transport{base: base, observe: observe}
+}
+
+type transport struct {
+	base    http.RoundTripper
+	observe Func
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	var status int
+	if err == nil {
+		status = resp.StatusCode
+	}
+	t.observe(req.Method, req.URL.String(), status, time.Since(start))
+	return resp, err
+}
+
+// Client returns a copy of client which reports each request to observe.
+// client is returned unmodified if observe is nil.
+func Client(client *http.Client, observe Func) *http.Client {
+	if observe == nil {
+		return client
+	}
+	wrapped := *client
+	wrapped.Transport = Transport(client.Transport, observe)
+	return &wrapped
+}
@@ -0,0 +1,76 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package observe
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type observation struct {
+	Method string
+	URL    string
+	Status int
+}
+
+func TestClient(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	// Closed, so requests to it cannot connect.
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+	t.Cleanup(ts.Close)
+
+	var got []observation
+	client := Client(&http.Client{}, func(method, url string, status int, dur time.Duration) {
+		if dur < 0 {
+			t.Errorf("negative duration %s", dur)
+		}
+		got = append(got, observation{method, url, status})
+	})
+
+	resp, err := client.Get(ts.URL + "/data.json")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	resp.Body.Close()
+	if _, err := client.Get(unreachable.URL + "/data.json"); err == nil {
+		t.Errorf("expected error from closed server")
+	}
+
+	want := []observation{
+		{http.MethodGet, ts.URL + "/data.json", http.StatusTeapot},
+		{http.MethodGet, unreachable.URL + "/data.json", 0},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("unexpected observations (-got,+want): %s", diff)
+	}
+}
+
+func TestClient_NilObserver(t *testing.T) {
+	t.Parallel()
+
+	client := &http.Client{}
+	if got := Client(client, nil); got != client {
+		t.Errorf("expected client to be returned unmodified")
+	}
+}
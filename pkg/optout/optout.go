@@ -0,0 +1,162 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package optout loads and evaluates a user's opt-out settings for both update
+// notifications and metrics.
+package optout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/go-version"
+	"github.com/sethvargo/go-envconfig"
+)
+
+const (
+	// IgnoreVersionsEnvVar is the env var (without app prefix) for ignoring
+	// update notifications.
+	IgnoreVersionsEnvVar = "IGNORE_VERSIONS"
+
+	// IgnoreSecurityEnvVar is the env var (without app prefix) for allowing
+	// IgnoreVersionsEnvVar to ignore critical security releases.
+	IgnoreSecurityEnvVar = "IGNORE_SECURITY"
+
+	// NoMetricsEnvVar is the env var (without app prefix) for opting out of
+	// metrics.
+	NoMetricsEnvVar = "NO_METRICS"
+)
+
+// Config is a user's opt-out settings for an app. It may be embedded in other
+// envconfig structs, or loaded directly with Load.
+type Config struct {
+	// IgnoreVersions is a list of version constraints for which update
+	// notifications are suppressed, or "all".
+	IgnoreVersions []string `env:"IGNORE_VERSIONS"`
+
+	// IgnoreSecurity allows IgnoreVersions constraints, including "all", to
+	// also suppress notifications for critical security releases.
+	IgnoreSecurity bool `env:"IGNORE_SECURITY"`
+
+	// NoMetrics is a list of metric names to opt out of, or "all" to opt out
+	// of all metrics. For backwards compatibility, any true value accepted by
+	// strconv.ParseBool, e.g. "true", "T", or "1", also opts out of all.
+	NoMetrics []string `env:"NO_METRICS"`
+
+	// IgnoreVersions constraints are parsed once, on first use.
+	parseOnce   sync.Once
+	constraints []version.Constraints
+	parseErr    error
+}
+
+// DefaultLookuper returns a Lookuper for environment variables prefixed with
+// toUpper(appID) + "_".
+func DefaultLookuper(appID string) envconfig.Lookuper {
+	return envconfig.PrefixLookuper(strings.ToUpper(appID)+"_", envconfig.OsLookuper())
+}
+
+// Load loads opt-out settings using lookuper. If lookuper is nil,
+// DefaultLookuper(appID) is used.
+func Load(ctx context.Context, appID string, lookuper envconfig.Lookuper) (*Config, error) {
+	if lookuper == nil {
+		lookuper = DefaultLookuper(appID)
+	}
+
+	var c Config
+	if err := envconfig.ProcessWith(ctx, &envconfig.Config{
+		Target:   &c,
+		Lookuper: lookuper,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to process envconfig: %w", err)
+	}
+	return &c, nil
+}
+
+// IgnoreAllVersions returns true if the user opted out of all update
+// notifications.
+func (c *Config) IgnoreAllVersions() bool {
+	for _, v := range c.IgnoreVersions {
+		if strings.ToLower(v) == "all" {
+			return true
+		}
+	}
+	return false
+}
+
+// IsVersionIgnored returns true if update notifications for checkVersion
+// should be suppressed. Malformed constraints are skipped and reported in the
+// returned error.
+func (c *Config) IsVersionIgnored(checkVersion string) (bool, error) {
+	v, err := version.NewVersion(checkVersion)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse version: %w", err)
+	}
+
+	if c.IgnoreAllVersions() {
+		return true, nil
+	}
+
+	c.parseOnce.Do(c.parseConstraints)
+	for _, constraint := range c.constraints {
+		// Constraint checks without pre-releases will only match versions without pre-release.
+		// https://github.com/hashicorp/go-version/issues/130
+		if constraint.Check(v) {
+			return true, nil
+		}
+	}
+
+	return false, c.parseErr
+}
+
+func (c *Config) parseConstraints() {
+	for _, ignoredVersion := range c.IgnoreVersions {
+		constraint, err := version.NewConstraint(ignoredVersion)
+		if err != nil {
+			c.parseErr = errors.Join(c.parseErr, err)
+			continue
+		}
+		c.constraints = append(c.constraints, constraint)
+	}
+}
+
+// OptOutAllMetrics returns true if the user opted out of all metrics.
+func (c *Config) OptOutAllMetrics() bool {
+	for _, v := range c.NoMetrics {
+		v = strings.TrimSpace(v)
+		if strings.EqualFold(v, "all") {
+			return true
+		}
+		if optOut, err := strconv.ParseBool(v); err == nil && optOut {
+			return true
+		}
+	}
+	return false
+}
+
+// MetricOptedOut returns true if the user opted out of the named metric.
+func (c *Config) MetricOptedOut(name string) bool {
+	if c.OptOutAllMetrics() {
+		return true
+	}
+	for _, v := range c.NoMetrics {
+		if strings.TrimSpace(v) == name {
+			return true
+		}
+	}
+	return false
+}
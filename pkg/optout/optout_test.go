@@ -0,0 +1,266 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package optout
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/sethvargo/go-envconfig"
+
+	"github.com/abcxyz/pkg/testutil"
+)
+
+func TestLoad(t *testing.T) {
+	t.Parallel()
+
+	c, err := Load(context.Background(), "foo", envconfig.MapLookuper(map[string]string{
+		"IGNORE_VERSIONS": "<2.0.0,2.1.0",
+		"NO_METRICS":      "bar,baz",
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if diff := cmp.Diff(c.IgnoreVersions, []string{"<2.0.0", "2.1.0"}); diff != "" {
+		t.Errorf("unexpected IgnoreVersions. Diff (-got +want): %s", diff)
+	}
+	if diff := cmp.Diff(c.NoMetrics, []string{"bar", "baz"}); diff != "" {
+		t.Errorf("unexpected NoMetrics. Diff (-got +want): %s", diff)
+	}
+}
+
+func TestConfig_IsVersionIgnored(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		version string
+		config  *Config
+		want    bool
+		wantErr string
+	}{
+		{
+			name:    "nothing_ignored",
+			version: "1.0.0",
+			config: &Config{
+				IgnoreVersions: nil,
+			},
+			want: false,
+		},
+		{
+			name:    "all_ignored",
+			version: "1.0.0",
+			config: &Config{
+				IgnoreVersions: []string{"all"},
+			},
+			want: true,
+		},
+		{
+			name:    "all_ignored_other_info",
+			version: "1.0.0",
+			config: &Config{
+				IgnoreVersions: []string{"1.0.1", "<1.0.0", "all", ">1.0.0"},
+			},
+			want: true,
+		},
+		{
+			name:    "version_no_match",
+			version: "1.0.0",
+			config: &Config{
+				IgnoreVersions: []string{"1.0.1", "<1.0.0", ">1.0.0"},
+			},
+			want: false,
+		},
+		{
+			name:    "version_match_last",
+			version: "1.0.0",
+			config: &Config{
+				IgnoreVersions: []string{"1.0.1", "<1.0.0", ">1.0.0", "1.0.0"},
+			},
+			want: true,
+		},
+		{
+			name:    "version_exact_match",
+			version: "1.0.0",
+			config: &Config{
+				IgnoreVersions: []string{"1.0.0"},
+			},
+			want: true,
+		},
+		{
+			name:    "version_constraint_lt",
+			version: "1.0.0",
+			config: &Config{
+				IgnoreVersions: []string{"<1.0.1"},
+			},
+			want: true,
+		},
+		{
+			name:    "version_constraint_gt",
+			version: "1.0.0",
+			config: &Config{
+				IgnoreVersions: []string{">0.0.1"},
+			},
+			want: true,
+		},
+		{
+			name:    "version_constraint_lte",
+			version: "1.0.0",
+			config: &Config{
+				IgnoreVersions: []string{"<=1.0.0"},
+			},
+			want: true,
+		},
+		{
+			name:    "version_constraint_gte",
+			version: "1.0.0",
+			config: &Config{
+				IgnoreVersions: []string{">=1.0.0"},
+			},
+			want: true,
+		},
+		{
+			name:    "version_prerelease",
+			version: "1.1.0-alpha",
+			config: &Config{
+				IgnoreVersions: []string{"1.1.0-alpha"},
+			},
+			want: true,
+		},
+		{
+			name:    "version_broken",
+			version: "abcd",
+			config: &Config{
+				IgnoreVersions: []string{"1.1.0-alpha"},
+			},
+			want:    false,
+			wantErr: "failed to parse version",
+		},
+		{
+			name:    "constraint_broken",
+			version: "1.0.0",
+			config: &Config{
+				IgnoreVersions: []string{"alsdkfas"},
+			},
+			want:    false,
+			wantErr: "Malformed constraint",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := tc.config.IsVersionIgnored(tc.version)
+
+			if diff := testutil.DiffErrString(err, tc.wantErr); diff != "" {
+				t.Error(diff)
+			}
+
+			if want := tc.want; got != want {
+				t.Errorf("incorrect IsIgnored got=%t, want=%t", got, want)
+			}
+		})
+	}
+}
+
+func TestConfig_IgnoreAllVersions(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		config *Config
+		want   bool
+	}{
+		{
+			name: "no_versions_not_ignored",
+			config: &Config{
+				IgnoreVersions: nil,
+			},
+			want: false,
+		},
+		{
+			name: "only_all_ignored",
+			config: &Config{
+				IgnoreVersions: []string{"all"},
+			},
+			want: true,
+		},
+		{
+			name: "concrete_list_not_ignored",
+			config: &Config{
+				IgnoreVersions: []string{"1.0.0", "3.0.2"},
+			},
+			want: false,
+		},
+		{
+			name: "concrete_list_with_all_ignored",
+			config: &Config{
+				IgnoreVersions: []string{"1.0.0", "3.0.2", "all"},
+			},
+			want: true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := tc.config.IgnoreAllVersions()
+
+			if want := tc.want; got != want {
+				t.Errorf("incorrect allVersionUpdatesIgnored got=%t, want=%t", got, want)
+			}
+		})
+	}
+}
+
+func TestConfig_MetricOptedOut(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name      string
+		noMetrics []string
+		metric    string
+		want      bool
+	}{
+		{name: "unset", metric: "foo", want: false},
+		{name: "all", noMetrics: []string{"ALL"}, metric: "foo", want: true},
+		{name: "legacy_true", noMetrics: []string{"true"}, metric: "foo", want: true},
+		{name: "legacy_t", noMetrics: []string{"t"}, metric: "foo", want: true},
+		{name: "legacy_T", noMetrics: []string{"T"}, metric: "foo", want: true},
+		{name: "legacy_TRUE", noMetrics: []string{"TRUE"}, metric: "foo", want: true},
+		{name: "legacy_True", noMetrics: []string{"True"}, metric: "foo", want: true},
+		{name: "legacy_1", noMetrics: []string{"1"}, metric: "foo", want: true},
+		{name: "legacy_false", noMetrics: []string{"false"}, metric: "foo", want: false},
+		{name: "listed", noMetrics: []string{"bar", " foo"}, metric: "foo", want: true},
+		{name: "not_listed", noMetrics: []string{"bar"}, metric: "foo", want: false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			c := &Config{NoMetrics: tc.noMetrics}
+			if got := c.MetricOptedOut(tc.metric); got != tc.want {
+				t.Errorf("unexpected MetricOptedOut(%q). got %t want %t", tc.metric, got, tc.want)
+			}
+		})
+	}
+}
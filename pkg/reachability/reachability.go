@@ -0,0 +1,424 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package reachability dials abc-updater servers with short DNS and connect
+// timeouts, and remembers servers which repeatedly could not be reached so
+// later requests fail immediately. This keeps users on restricted networks, where
+// servers are blocked or only IPv6 is routable, from waiting on timeouts for
+// every command.
+package reachability
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abcxyz/abc-updater/pkg/localstore"
+)
+
+const (
+	// DefaultTTL is how long an unreachable server is skipped by default.
+	DefaultTTL = 5 * time.Minute
+
+	// failureThreshold is how many consecutive failed dials, each within the
+	// TTL of the one before, mark a server unreachable. A single failure, e.g.
+	// from a network change, does not.
+	failureThreshold = 3
+
+	// dnsTimeout bounds name resolution, separately from the connection.
+	dnsTimeout = time.Second
+	// fallbackDNSTimeout bounds resolution with the fallback resolver, which
+	// is usually slower, e.g. a request to a DNS over HTTPS server.
+	fallbackDNSTimeout = 3 * time.Second
+	// connectTimeout bounds each connection attempt.
+	connectTimeout = 2 * time.Second
+	// fallbackDelay is how long to wait for the preferred address family
+	// before also trying the other, as in RFC 8305 ("Happy Eyeballs").
+	fallbackDelay = 300 * time.Millisecond
+)
+
+// ErrUnreachable is returned (wrapped) when dialing a server which recently
+// could not be reached.
+var ErrUnreachable = errors.New("server recently unreachable")
+
+// skipList maps host:port to the time until which it is skipped, and to its
+// consecutive failed dials.
+type skipList struct {
+	mu       sync.Mutex
+	until    map[string]time.Time
+	failures map[string]failures
+}
+
+func newSkipList() *skipList {
+	return &skipList{
+		until:    make(map[string]time.Time),
+		failures: make(map[string]failures),
+	}
+}
+
+// process is shared by all Checkers, so a server found unreachable by one
+// client is skipped by the rest of the process.
+var process = newSkipList()
+
+// failures counts the consecutive failed dials of a server.
+type failures struct {
+	Count int `json:"count"`
+	// Last is the time of the latest failure, in UTC epoch seconds.
+	Last int64 `json:"last"`
+}
+
+// state defines the json file that persists unreachable servers.
+type state struct {
+	// Hosts maps host:port to the time until which it is skipped, in UTC
+	// epoch seconds.
+	Hosts map[string]int64 `json:"hosts"`
+	// Failures maps host:port to its consecutive failed dials, so failures
+	// in separate runs of a CLI count towards skipping it.
+	Failures map[string]failures `json:"failures,omitempty"`
+}
+
+// Resolver looks up the addresses of a host. *net.Resolver satisfies it.
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// Checker dials servers, skipping those which recently could not be reached.
+type Checker struct {
+	path     string
+	ttl      time.Duration
+	now      func() time.Time
+	resolver Resolver
+	fallback Resolver
+	dialer   *net.Dialer
+	skipped  *skipList
+}
+
+// NewChecker returns a Checker which skips a server for ttl, or DefaultTTL if
+// ttl is not positive, after several consecutive dials fail. Failures are also
+// persisted to the JSON file at path, if not empty, so they are counted across
+// runs of a CLI and later runs skip unreachable servers too.
+func NewChecker(path string, ttl time.Duration) *Checker {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return &Checker{
+		path:     path,
+		ttl:      ttl,
+		now:      time.Now,
+		resolver: net.DefaultResolver,
+		dialer:   &net.Dialer{Timeout: connectTimeout},
+		skipped:  process,
+	}
+}
+
+// WithFallbackResolver makes c resolve hosts with r when the system resolver
+// fails, e.g. on networks where DNS for the server is blocked but HTTPS
+// egress is allowed. Each fallback lookup is bounded to a few seconds. It
+// returns c.
+func (c *Checker) WithFallbackResolver(r Resolver) *Checker {
+	c.fallback = r
+	return c
+}
+
+// Transport returns a clone of http.DefaultTransport which dials with c.
+func (c *Checker) Transport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone() //nolint:forcetypeassert // Always *http.Transport.
+	t.DialContext = c.DialContext
+	return t
+}
+
+// DialContext dials address, with the same semantics as
+// net.Dialer.DialContext. It returns an error wrapping ErrUnreachable, without
+// dialing, if address recently could not be reached several times in a row.
+// Loopback addresses are never skipped.
+func (c *Checker) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse address %q: %w", address, err)
+	}
+
+	cache := !isLoopback(host)
+	if cache {
+		if until, ok := c.unreachableUntil(address); ok {
+			return nil, fmt.Errorf("%w: %s, skipping until %s", ErrUnreachable, address, until.UTC().Format(time.RFC3339))
+		}
+	}
+
+	conn, err := c.dial(ctx, network, host, port)
+	if err != nil {
+		// A canceled request says nothing about the server.
+		if cache && ctx.Err() == nil {
+			c.recordFailure(address)
+		}
+		return nil, err
+	}
+	if cache {
+		c.recordSuccess(address)
+	}
+	return conn, nil
+}
+
+// dial resolves host with a short timeout and connects to its addresses,
+// racing the two address families.
+func (c *Checker) dial(ctx context.Context, network, host, port string) (net.Conn, error) {
+	if net.ParseIP(host) != nil {
+		return c.dialer.DialContext(ctx, network, net.JoinHostPort(host, port))
+	}
+
+	addrs, err := c.lookup(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+
+	primaries, fallbacks := partition(network, addrs)
+	if len(primaries) == 0 {
+		return nil, fmt.Errorf("no %s addresses for %s", network, host)
+	}
+	return dialParallel(ctx, c.dialer, network, port, primaries, fallbacks)
+}
+
+// lookup resolves host with a short timeout, then with the fallback resolver,
+// if any, should that fail.
+func (c *Checker) lookup(ctx context.Context, host string) ([]net.IPAddr, error) {
+	dnsCtx, cancel := context.WithTimeout(ctx, dnsTimeout)
+	addrs, err := c.resolver.LookupIPAddr(dnsCtx, host)
+	cancel()
+	if err == nil || c.fallback == nil || ctx.Err() != nil {
+		return addrs, err
+	}
+
+	fallbackCtx, cancel := context.WithTimeout(ctx, fallbackDNSTimeout)
+	defer cancel()
+	addrs, fallbackErr := c.fallback.LookupIPAddr(fallbackCtx, host)
+	if fallbackErr != nil {
+		return nil, errors.Join(err, fmt.Errorf("fallback resolver: %w", fallbackErr))
+	}
+	return addrs, nil
+}
+
+// partition splits the addresses usable for network into those of the same
+// family as the first, and the rest.
+func partition(network string, addrs []net.IPAddr) (primaries, fallbacks []net.IP) {
+	for _, addr := range addrs {
+		isV4 := addr.IP.To4() != nil
+		if (network == "tcp4" && !isV4) || (network == "tcp6" && isV4) {
+			continue
+		}
+		if len(primaries) == 0 || (primaries[0].To4() != nil) == isV4 {
+			primaries = append(primaries, addr.IP)
+			continue
+		}
+		fallbacks = append(fallbacks, addr.IP)
+	}
+	return primaries, fallbacks
+}
+
+// dialParallel dials primaries, and fallbacks if primaries have not connected
+// within fallbackDelay or have all failed, returning the first connection.
+func dialParallel(ctx context.Context, d *net.Dialer, network, port string, primaries, fallbacks []net.IP) (net.Conn, error) {
+	if len(fallbacks) == 0 {
+		return dialSerial(ctx, d, network, port, primaries)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result, 2)
+	start := func(ips []net.IP) {
+		go func() {
+			conn, err := dialSerial(ctx, d, network, port, ips)
+			results <- result{conn: conn, err: err}
+		}()
+	}
+
+	start(primaries)
+	pending, fallbackStarted := 1, false
+	timer := time.NewTimer(fallbackDelay)
+	defer timer.Stop()
+
+	var merr error
+	for {
+		select {
+		case <-timer.C:
+			if !fallbackStarted {
+				start(fallbacks)
+				pending, fallbackStarted = pending+1, true
+			}
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				if pending > 0 {
+					// Close the losing connection, if it connects before
+					// being canceled.
+					go func() {
+						if r := <-results; r.conn != nil {
+							r.conn.Close()
+						}
+					}()
+				}
+				return r.conn, nil
+			}
+			merr = errors.Join(merr, r.err)
+			if !fallbackStarted {
+				start(fallbacks)
+				pending, fallbackStarted = pending+1, true
+				continue
+			}
+			if pending == 0 {
+				return nil, merr
+			}
+		}
+	}
+}
+
+// dialSerial dials ips in turn, returning the first connection.
+func dialSerial(ctx context.Context, d *net.Dialer, network, port string, ips []net.IP) (net.Conn, error) {
+	var merr error
+	for _, ip := range ips {
+		conn, err := d.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		merr = errors.Join(merr, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, merr
+}
+
+// unreachableUntil returns the time until which address is skipped, if it is
+// currently skipped by this process or a persisted earlier run.
+func (c *Checker) unreachableUntil(address string) (time.Time, bool) {
+	now := c.now()
+
+	c.skipped.mu.Lock()
+	defer c.skipped.mu.Unlock()
+
+	if until, ok := c.skipped.until[address]; ok && until.After(now) {
+		return until, true
+	}
+	if c.path == "" {
+		return time.Time{}, false
+	}
+	if until := time.Unix(c.load().Hosts[address], 0); until.After(now) {
+		c.skipped.until[address] = until
+		return until, true
+	}
+	return time.Time{}, false
+}
+
+// recordFailure counts a failed dial of address, and skips it for c.ttl once
+// failureThreshold dials in a row have failed. Failures more than c.ttl apart
+// are not consecutive.
+func (c *Checker) recordFailure(address string) {
+	now := c.now()
+	stale := now.Add(-c.ttl).Unix()
+
+	c.skipped.mu.Lock()
+	defer c.skipped.mu.Unlock()
+
+	var s *state
+	f := c.skipped.failures[address]
+	if c.path != "" {
+		s = c.load()
+		// Another process may have recorded later failures.
+		if pf, ok := s.Failures[address]; ok && pf.Last > f.Last {
+			f = pf
+		}
+	}
+	if f.Last < stale {
+		f.Count = 0
+	}
+	f.Count++
+	f.Last = now.Unix()
+	c.skipped.failures[address] = f
+
+	var until time.Time
+	if f.Count >= failureThreshold {
+		until = now.Add(c.ttl)
+		c.skipped.until[address] = until
+	}
+	if s == nil {
+		return
+	}
+
+	for host, t := range s.Hosts {
+		if !time.Unix(t, 0).After(now) {
+			delete(s.Hosts, host)
+		}
+	}
+	for host, hf := range s.Failures {
+		if hf.Last < stale {
+			delete(s.Failures, host)
+		}
+	}
+	s.Failures[address] = f
+	if !until.IsZero() {
+		s.Hosts[address] = until.Unix()
+	}
+	// Persisting is best effort; the process still counts the failure.
+	_ = localstore.StoreJSONFile(c.path, s)
+}
+
+// recordSuccess resets the failures of address.
+func (c *Checker) recordSuccess(address string) {
+	c.skipped.mu.Lock()
+	defer c.skipped.mu.Unlock()
+
+	delete(c.skipped.failures, address)
+	if c.path == "" {
+		return
+	}
+	s := c.load()
+	if _, ok := s.Failures[address]; !ok {
+		return
+	}
+	delete(s.Failures, address)
+	_ = localstore.StoreJSONFile(c.path, s)
+}
+
+// load returns the persisted state, or an empty state if it cannot be loaded.
+func (c *Checker) load() *state {
+	var s state
+	if err := localstore.LoadJSONFile(c.path, &s); err != nil {
+		s = state{}
+	}
+	if s.Hosts == nil {
+		s.Hosts = make(map[string]int64)
+	}
+	if s.Failures == nil {
+		s.Failures = make(map[string]failures)
+	}
+	return &s
+}
+
+// isLoopback returns true if host is a loopback address or "localhost", which
+// fail fast anyway and are used by local development servers.
+func isLoopback(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
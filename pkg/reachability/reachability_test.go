@@ -0,0 +1,313 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reachability
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/abcxyz/abc-updater/pkg/localstore"
+)
+
+// newBlockedChecker returns a Checker whose DNS lookups always fail, with
+// skipped servers shared by the returned checkers rather than the process.
+func newBlockedChecker(path string, skipped *skipList) *Checker {
+	c := NewChecker(path, time.Hour)
+	c.skipped = skipped
+	c.resolver = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return nil, errors.New("dns blocked")
+		},
+	}
+	return c
+}
+
+func TestChecker_DialContext_SkipsUnreachable(t *testing.T) {
+	t.Parallel()
+
+	const address = "unreachable.example.test:443"
+	path := filepath.Join(t.TempDir(), "unreachable.json")
+	c := newBlockedChecker(path, newSkipList())
+
+	for i := 0; i < failureThreshold; i++ {
+		_, err := c.DialContext(context.Background(), "tcp", address)
+		if err == nil || errors.Is(err, ErrUnreachable) {
+			t.Fatalf("dial %d: expected dial error, got %v", i, err)
+		}
+	}
+
+	_, err := c.DialContext(context.Background(), "tcp", address)
+	if !errors.Is(err, ErrUnreachable) {
+		t.Errorf("expected ErrUnreachable after %d failures, got %v", failureThreshold, err)
+	}
+
+	var s state
+	if err := localstore.LoadJSONFile(path, &s); err != nil {
+		t.Fatalf("failed to load persisted state: %v", err)
+	}
+	if _, ok := s.Hosts[address]; !ok {
+		t.Errorf("expected %s to be persisted, got %v", address, s.Hosts)
+	}
+}
+
+func TestChecker_DialContext_TransientFailure(t *testing.T) {
+	t.Parallel()
+
+	const address = "unreachable.example.test:443"
+	path := filepath.Join(t.TempDir(), "unreachable.json")
+
+	// Each run of a CLI has its own process skip list.
+	for run := 0; run < failureThreshold; run++ {
+		c := newBlockedChecker(path, newSkipList())
+		_, err := c.DialContext(context.Background(), "tcp", address)
+		if err == nil || errors.Is(err, ErrUnreachable) {
+			t.Fatalf("run %d: expected dial error, got %v", run, err)
+		}
+	}
+
+	c := newBlockedChecker(path, newSkipList())
+	if _, err := c.DialContext(context.Background(), "tcp", address); !errors.Is(err, ErrUnreachable) {
+		t.Errorf("expected ErrUnreachable after a failure in each of %d runs, got %v", failureThreshold, err)
+	}
+}
+
+func TestChecker_DialContext_SuccessResetsFailures(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	_, port, err := net.SplitHostPort(ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := net.JoinHostPort("flaky.example.test", port)
+	path := filepath.Join(t.TempDir(), "unreachable.json")
+
+	fail := func(t *testing.T) {
+		t.Helper()
+
+		c := newBlockedChecker(path, newSkipList())
+		_, err := c.DialContext(context.Background(), "tcp", address)
+		if err == nil || errors.Is(err, ErrUnreachable) {
+			t.Fatalf("expected dial error, got %v", err)
+		}
+	}
+
+	for i := 0; i < failureThreshold-1; i++ {
+		fail(t)
+	}
+
+	// The next run connects, e.g. once a VPN reconnects.
+	c := newBlockedChecker(path, newSkipList()).
+		WithFallbackResolver(staticResolver{{IP: net.IPv4(127, 0, 0, 1)}})
+	conn, err := c.DialContext(context.Background(), "tcp", address)
+	if err != nil {
+		t.Fatalf("expected dial to succeed, got %v", err)
+	}
+	conn.Close()
+
+	// Earlier failures no longer count.
+	for i := 0; i < failureThreshold-1; i++ {
+		fail(t)
+	}
+}
+
+func TestChecker_DialContext_FailuresExpire(t *testing.T) {
+	t.Parallel()
+
+	const address = "unreachable.example.test:443"
+	now := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+	c := newBlockedChecker("", newSkipList())
+
+	// Failures further apart than the TTL are not consecutive.
+	for i := 0; i < failureThreshold+1; i++ {
+		c.now = func() time.Time { return now.Add(time.Duration(i) * 2 * c.ttl) }
+		_, err := c.DialContext(context.Background(), "tcp", address)
+		if err == nil || errors.Is(err, ErrUnreachable) {
+			t.Fatalf("dial %d: expected dial error, got %v", i, err)
+		}
+	}
+}
+
+func TestChecker_DialContext_Persisted(t *testing.T) {
+	t.Parallel()
+
+	const address = "unreachable.example.test:443"
+	now := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), "unreachable.json")
+	if err := localstore.StoreJSONFile(path, &state{Hosts: map[string]int64{
+		address: now.Add(time.Minute).Unix(),
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Skipped, as if found unreachable by an earlier run.
+	c := newBlockedChecker(path, newSkipList())
+	c.now = func() time.Time { return now }
+	if _, err := c.DialContext(context.Background(), "tcp", address); !errors.Is(err, ErrUnreachable) {
+		t.Errorf("expected ErrUnreachable, got %v", err)
+	}
+
+	// Dialed again once the skip expires.
+	c = newBlockedChecker(path, newSkipList())
+	c.now = func() time.Time { return now.Add(2 * time.Minute) }
+	_, err := c.DialContext(context.Background(), "tcp", address)
+	if err == nil || errors.Is(err, ErrUnreachable) {
+		t.Errorf("expected dial error after expiry, got %v", err)
+	}
+}
+
+func TestChecker_DialContext_LoopbackNotSkipped(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := ln.Addr().String()
+	ln.Close()
+
+	c := NewChecker(filepath.Join(t.TempDir(), "unreachable.json"), time.Hour)
+	for i := 0; i < 2; i++ {
+		_, err := c.DialContext(context.Background(), "tcp", address)
+		if err == nil || errors.Is(err, ErrUnreachable) {
+			t.Errorf("dial %d: expected connection error, got %v", i, err)
+		}
+	}
+}
+
+// staticResolver resolves every host to its addresses.
+type staticResolver []net.IPAddr
+
+func (r staticResolver) LookupIPAddr(context.Context, string) ([]net.IPAddr, error) {
+	return r, nil
+}
+
+func TestChecker_DialContext_FallbackResolver(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	_, port, err := net.SplitHostPort(ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := newBlockedChecker("", newSkipList()).
+		WithFallbackResolver(staticResolver{{IP: net.IPv4(127, 0, 0, 1)}})
+	conn, err := c.DialContext(context.Background(), "tcp", net.JoinHostPort("blocked.example.test", port))
+	if err != nil {
+		t.Fatalf("expected dial with fallback resolver to succeed, got %v", err)
+	}
+	conn.Close()
+}
+
+func TestDialParallel_FallsBack(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	_, port, err := net.SplitHostPort(ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing listens on the IPv6 loopback (or IPv6 is unavailable), so the
+	// IPv4 fallback connects.
+	conn, err := dialParallel(context.Background(), &net.Dialer{Timeout: connectTimeout}, "tcp", port,
+		[]net.IP{net.IPv6loopback}, []net.IP{net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conn.Close()
+}
+
+func TestPartition(t *testing.T) {
+	t.Parallel()
+
+	v4a, v4b := net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2")
+	v6a, v6b := net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2")
+
+	cases := []struct {
+		name          string
+		network       string
+		addrs         []net.IP
+		wantPrimaries []net.IP
+		wantFallbacks []net.IP
+	}{
+		{
+			name:          "ipv6_first",
+			network:       "tcp",
+			addrs:         []net.IP{v6a, v4a, v6b, v4b},
+			wantPrimaries: []net.IP{v6a, v6b},
+			wantFallbacks: []net.IP{v4a, v4b},
+		},
+		{
+			name:          "ipv4_first",
+			network:       "tcp",
+			addrs:         []net.IP{v4a, v6a},
+			wantPrimaries: []net.IP{v4a},
+			wantFallbacks: []net.IP{v6a},
+		},
+		{
+			name:          "ipv6_only",
+			network:       "tcp",
+			addrs:         []net.IP{v6a},
+			wantPrimaries: []net.IP{v6a},
+		},
+		{
+			name:          "tcp4_filters_ipv6",
+			network:       "tcp4",
+			addrs:         []net.IP{v6a, v4a},
+			wantPrimaries: []net.IP{v4a},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			addrs := make([]net.IPAddr, 0, len(tc.addrs))
+			for _, ip := range tc.addrs {
+				addrs = append(addrs, net.IPAddr{IP: ip})
+			}
+			primaries, fallbacks := partition(tc.network, addrs)
+			if diff := cmp.Diff(primaries, tc.wantPrimaries); diff != "" {
+				t.Errorf("unexpected primaries (-got,+want): %s", diff)
+			}
+			if diff := cmp.Diff(fallbacks, tc.wantFallbacks); diff != "" {
+				t.Errorf("unexpected fallbacks (-got,+want): %s", diff)
+			}
+		})
+	}
+}
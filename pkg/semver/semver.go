@@ -0,0 +1,76 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package semver compares app versions the way abc-updater does, so tools
+// embedding the library agree with it on which versions are newer, ignored, or
+// affected by an advisory.
+//
+// Versions follow go-version rules: a leading "v" is allowed, missing minor
+// and patch numbers are zero, and prereleases sort before their release.
+// Constraints that do not mention a prerelease never match one, so ">= 1.0.0"
+// does not match "1.1.0-rc.1" (https://github.com/hashicorp/go-version/issues/130).
+package semver
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/go-version"
+)
+
+// Normalize returns the canonical form of v, e.g. "1.2.0" for "v1.2", or an
+// error if v is not a valid version.
+func Normalize(v string) (string, error) {
+	parsed, err := version.NewVersion(v)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse version %q: %w", v, err)
+	}
+	return parsed.String(), nil
+}
+
+// Compare returns -1, 0, or 1 if a is older than, the same as, or newer than
+// b.
+func Compare(a, b string) (int, error) {
+	va, err := version.NewVersion(a)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse version %q: %w", a, err)
+	}
+	vb, err := version.NewVersion(b)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse version %q: %w", b, err)
+	}
+	return va.Compare(vb), nil
+}
+
+// IsNewer returns true if version a is newer than version b.
+func IsNewer(a, b string) (bool, error) {
+	c, err := Compare(a, b)
+	if err != nil {
+		return false, err
+	}
+	return c > 0, nil
+}
+
+// Satisfies returns true if v matches constraint, e.g. ">= 1.2.0, < 2.0.0" or
+// "~> 1.2".
+func Satisfies(v, constraint string) (bool, error) {
+	parsed, err := version.NewVersion(v)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse version %q: %w", v, err)
+	}
+	c, err := version.NewConstraint(constraint)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse constraint %q: %w", constraint, err)
+	}
+	return c.Check(parsed), nil
+}
@@ -0,0 +1,120 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package semver
+
+import (
+	"testing"
+
+	"github.com/abcxyz/pkg/testutil"
+)
+
+func TestNormalize(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		v       string
+		want    string
+		wantErr string
+	}{
+		{name: "canonical", v: "1.2.3", want: "1.2.3"},
+		{name: "v_prefix", v: "v1.2", want: "1.2.0"},
+		{name: "prerelease", v: "1.0.0-rc.1", want: "1.0.0-rc.1"},
+		{name: "invalid", v: "not-a-version", wantErr: `failed to parse version "not-a-version"`},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := Normalize(tc.v)
+			if diff := testutil.DiffErrString(err, tc.wantErr); diff != "" {
+				t.Fatal(diff)
+			}
+			if got != tc.want {
+				t.Errorf("unexpected version. got %q want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestIsNewer(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		a       string
+		b       string
+		want    bool
+		wantErr string
+	}{
+		{name: "newer", a: "1.1.0", b: "1.0.0", want: true},
+		{name: "older", a: "1.0.0", b: "1.1.0"},
+		{name: "equal_forms", a: "v1.0", b: "1.0.0"},
+		{name: "numeric_not_lexical", a: "1.10.0", b: "1.9.0", want: true},
+		{name: "release_after_prerelease", a: "1.0.0", b: "1.0.0-rc.1", want: true},
+		{name: "prerelease_before_release", a: "1.0.0-rc.1", b: "1.0.0"},
+		{name: "invalid", a: "1.0.0", b: "latest", wantErr: `failed to parse version "latest"`},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := IsNewer(tc.a, tc.b)
+			if diff := testutil.DiffErrString(err, tc.wantErr); diff != "" {
+				t.Fatal(diff)
+			}
+			if got != tc.want {
+				t.Errorf("IsNewer(%q, %q) = %t, want %t", tc.a, tc.b, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSatisfies(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name       string
+		v          string
+		constraint string
+		want       bool
+		wantErr    string
+	}{
+		{name: "range", v: "1.5.0", constraint: ">= 1.2.0, < 2.0.0", want: true},
+		{name: "outside_range", v: "2.0.0", constraint: ">= 1.2.0, < 2.0.0"},
+		{name: "pessimistic", v: "1.2.9", constraint: "~> 1.2.0", want: true},
+		{name: "exact", v: "v1.2", constraint: "1.2.0", want: true},
+		{name: "prerelease_not_matched", v: "1.3.0-rc.1", constraint: ">= 1.0.0"},
+		{name: "prerelease_matched", v: "1.3.0-rc.1", constraint: ">= 1.3.0-rc.0", want: true},
+		{name: "invalid_version", v: "x", constraint: ">= 1.0.0", wantErr: `failed to parse version "x"`},
+		{name: "invalid_constraint", v: "1.0.0", constraint: ">> 1", wantErr: `failed to parse constraint ">> 1"`},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := Satisfies(tc.v, tc.constraint)
+			if diff := testutil.DiffErrString(err, tc.wantErr); diff != "" {
+				t.Fatal(diff)
+			}
+			if got != tc.want {
+				t.Errorf("Satisfies(%q, %q) = %t, want %t", tc.v, tc.constraint, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/abcxyz/abc-updater/pkg/apierror"
+)
+
+// RequireAdminToken wraps next so it is only served to requests bearing token
+// in an "Authorization: Bearer" header. If token is empty, all requests are
+// rejected, so admin endpoints are disabled unless explicitly configured.
+func RequireAdminToken(h JSONRenderer, token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			h.RenderJSON(w, http.StatusNotFound, apierror.New(apierror.CodeNotFound, "admin endpoints are disabled"))
+			return
+		}
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			h.RenderJSON(w, http.StatusUnauthorized, apierror.New(apierror.CodeUnauthorized, "missing or invalid admin token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// AppLister lists the apps currently loaded, for admin inspection.
+type AppLister interface {
+	ListApps() []string
+	GetAllowedMetrics(appID string) (*AppMetrics, error)
+	GetAppData(appID string) (*AppData, error)
+}
+
+// AppInfo describes the definitions loaded for a single app.
+type AppInfo struct {
+	AppID string `json:"appId"`
+	// Owner is the name of the app's owner in the manifest, if any.
+	Owner string `json:"owner,omitempty"`
+	// Metrics are the exact metric names allowed, sorted.
+	Metrics []string `json:"metrics"`
+	// Patterns are the allowlist entries containing wildcards.
+	Patterns   []string `json:"patterns,omitempty"`
+	Level      string   `json:"level"`
+	Sink       string   `json:"sink,omitempty"`
+	SampleRate float64  `json:"sampleRate,omitempty"`
+	// CurrentVersion is from the app's version data, if any.
+	CurrentVersion string `json:"currentVersion,omitempty"`
+}
+
+// AdminAppsResponse is rendered by HandleAdminApps.
+type AdminAppsResponse struct {
+	LastRefresh time.Time  `json:"lastRefresh"`
+	Apps        []*AppInfo `json:"apps"`
+}
+
+// AdminAppResponse is rendered by HandleAdminApp.
+type AdminAppResponse struct {
+	LastRefresh time.Time `json:"lastRefresh"`
+	App         *AppInfo  `json:"app"`
+}
+
+// HandleAdminApps returns a handler which renders every app currently loaded,
+// with the time of the last successful refresh. It should be registered
+// behind RequireAdminToken or RequireScope; with an owner's scope, only that
+// owner's apps are rendered.
+func HandleAdminApps(h JSONRenderer, db AppLister, r *Refresher) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		scope := ScopeFromContext(req.Context())
+		ids := db.ListApps()
+		apps := make([]*AppInfo, 0, len(ids))
+		for _, id := range ids {
+			info := appInfo(db, id)
+			if info == nil || (scope != nil && !scope.Allows(info.Owner)) {
+				continue
+			}
+			apps = append(apps, info)
+		}
+		h.RenderJSON(w, http.StatusOK, &AdminAppsResponse{
+			LastRefresh: r.Status().LastSuccess,
+			Apps:        apps,
+		})
+	})
+}
+
+// HandleAdminApp returns a handler which renders the app in the "id" path
+// value, with the time of the last successful refresh. It should be
+// registered behind RequireAdminToken or RequireScope.
+func HandleAdminApp(h JSONRenderer, db AppLister, r *Refresher) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		appID := req.PathValue("id")
+		info := appInfo(db, appID)
+		if info == nil {
+			h.RenderJSON(w, http.StatusNotFound, apierror.New(apierror.CodeUnknownApp, "unknown app %q", appID))
+			return
+		}
+		h.RenderJSON(w, http.StatusOK, &AdminAppResponse{
+			LastRefresh: r.Status().LastSuccess,
+			App:         info,
+		})
+	})
+}
+
+// appInfo returns the loaded definitions for appID, or nil if the app is not
+// loaded.
+func appInfo(db AppLister, appID string) *AppInfo {
+	m, metricsErr := db.GetAllowedMetrics(appID)
+	data, dataErr := db.GetAppData(appID)
+	if metricsErr != nil && dataErr != nil {
+		return nil
+	}
+
+	info := &AppInfo{AppID: appID, Metrics: []string{}}
+	if metricsErr == nil {
+		for name := range m.Allowed {
+			info.Metrics = append(info.Metrics, name)
+		}
+		slices.Sort(info.Metrics)
+		for _, p := range m.Patterns {
+			info.Patterns = append(info.Patterns, p.Pattern)
+		}
+		info.Owner = m.Owner
+		info.Level = m.Level.String()
+		info.Sink = m.Sink
+		info.SampleRate = m.SampleRate
+	}
+	if dataErr == nil && data.Data != nil {
+		info.CurrentVersion = data.Data.CurrentVersion
+	}
+	return info
+}
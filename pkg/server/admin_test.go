@@ -0,0 +1,210 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+	"github.com/abcxyz/pkg/renderer"
+)
+
+func TestRequireAdminToken(t *testing.T) {
+	t.Parallel()
+
+	h, err := renderer.New(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("failed to setup test: %s", err.Error())
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	cases := []struct {
+		name       string
+		token      string
+		authHeader string
+		wantStatus int
+	}{
+		{
+			name:       "disabled_without_token",
+			authHeader: "Bearer ",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "missing_header",
+			token:      "secret",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "wrong_token",
+			token:      "secret",
+			authHeader: "Bearer nope",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "valid_token",
+			token:      "secret",
+			authHeader: "Bearer secret",
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(http.MethodPost, "/admin/refresh", nil)
+			if tc.authHeader != "" {
+				req.Header.Set("Authorization", tc.authHeader)
+			}
+			w := httptest.NewRecorder()
+			RequireAdminToken(h, tc.token, ok).ServeHTTP(w, req)
+			if got, want := w.Code, tc.wantStatus; got != want {
+				t.Errorf("unexpected response code. got %d want %d", got, want)
+			}
+		})
+	}
+}
+
+func testAdminDB(tb testing.TB) *MetricsDB {
+	tb.Helper()
+
+	p, err := compileMetricPattern("command.*")
+	if err != nil {
+		tb.Fatalf("failed to compile pattern: %s", err.Error())
+	}
+	return &MetricsDB{
+		apps: map[string]*AppMetrics{
+			"foo": {
+				AppID:    "foo",
+				Allowed:  map[string]interface{}{"run": struct{}{}, "init": struct{}{}},
+				Patterns: []*MetricPattern{p},
+				Level:    slog.LevelDebug,
+				Sink:     "high_volume",
+				Owner:    "team-a",
+			},
+			"bar": {
+				AppID:   "bar",
+				Allowed: map[string]interface{}{},
+				Level:   slog.LevelInfo,
+			},
+		},
+		data: map[string]*AppData{
+			"foo": {AppID: "foo", Data: &api.AppResponse{AppID: "foo", CurrentVersion: "1.2.3"}},
+		},
+	}
+}
+
+func TestHandleAdminApps(t *testing.T) {
+	t.Parallel()
+
+	h, err := renderer.New(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("failed to setup test: %s", err.Error())
+	}
+	db := testAdminDB(t)
+	r := NewRefresher(db, &MetricsLoadParams{}, time.Minute)
+
+	w := httptest.NewRecorder()
+	HandleAdminApps(h, db, r).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/apps", nil))
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Errorf("unexpected response code. got %d want %d", got, want)
+	}
+	var got AdminAppsResponse
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode response: %s", err.Error())
+	}
+	want := AdminAppsResponse{
+		Apps: []*AppInfo{
+			{AppID: "bar", Metrics: []string{}, Level: "INFO"},
+			{
+				AppID:          "foo",
+				Owner:          "team-a",
+				Metrics:        []string{"init", "run"},
+				Patterns:       []string{"command.*"},
+				Level:          "DEBUG",
+				Sink:           "high_volume",
+				CurrentVersion: "1.2.3",
+			},
+		},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("unexpected response (-got,+want): %s", diff)
+	}
+}
+
+func TestHandleAdminApp(t *testing.T) {
+	t.Parallel()
+
+	h, err := renderer.New(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("failed to setup test: %s", err.Error())
+	}
+	db := testAdminDB(t)
+	r := NewRefresher(db, &MetricsLoadParams{}, time.Minute)
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /admin/apps/{id}", HandleAdminApp(h, db, r))
+
+	cases := []struct {
+		name       string
+		appID      string
+		wantStatus int
+		want       *AppInfo
+	}{
+		{
+			name:       "found",
+			appID:      "bar",
+			wantStatus: http.StatusOK,
+			want:       &AppInfo{AppID: "bar", Metrics: []string{}, Level: "INFO"},
+		},
+		{
+			name:       "unknown",
+			appID:      "baz",
+			wantStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/apps/"+tc.appID, nil))
+			if got, want := w.Code, tc.wantStatus; got != want {
+				t.Errorf("unexpected response code. got %d want %d", got, want)
+			}
+			if tc.want == nil {
+				return
+			}
+			var got AdminAppResponse
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode response: %s", err.Error())
+			}
+			if diff := cmp.Diff(got.App, tc.want); diff != "" {
+				t.Errorf("unexpected app (-got,+want): %s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,263 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abcxyz/abc-updater/pkg/apierror"
+	"github.com/abcxyz/pkg/logging"
+)
+
+const (
+	// DefaultAnomalyMaxVersions is the most distinct app versions an install
+	// can report in a day before AnomalySink flags it.
+	DefaultAnomalyMaxVersions = 5
+	// DefaultAnomalyMaxPlatforms is the most distinct platforms, e.g.
+	// "linux/amd64", an install can report in a day before AnomalySink flags
+	// it.
+	DefaultAnomalyMaxPlatforms = 2
+	// AnomalyDays is how many days of install activity AnomalySink keeps.
+	AnomalyDays = 7
+)
+
+// Assert AnomalySink satisfies MetricSink.
+var _ MetricSink = (*AnomalySink)(nil)
+
+// AnomalySink writes each metric to Next and tracks the distinct app versions
+// and platforms reported by each install ID on each UTC day. A real install
+// only runs a few versions a day on one machine, so installs over MaxVersions
+// or MaxPlatforms are flagged as likely bots, or install IDs shared by copying
+// a home directory or container image. Owners can exclude them from adoption
+// stats using the report from HandleAnomalyReport.
+//
+// Platforms are only known for apps which allow build info. Activity is kept
+// in memory for AnomalyDays, per replica.
+type AnomalySink struct {
+	Next MetricSink
+
+	// MaxVersions and MaxPlatforms default to DefaultAnomalyMaxVersions and
+	// DefaultAnomalyMaxPlatforms if zero.
+	MaxVersions  int
+	MaxPlatforms int
+
+	mu sync.Mutex
+	// days holds activity by UTC day, then app and install ID.
+	days map[string]map[installKey]*installActivity
+
+	// now is used in tests. Defaults to time.Now.
+	now func() time.Time
+}
+
+// installKey identifies an install of an app.
+type installKey struct {
+	appID     string
+	installID string
+}
+
+// installActivity is what a single install reported on one day. Only one more
+// value than the limits is kept, which is enough to flag it.
+type installActivity struct {
+	versions  []string
+	platforms []string
+	metrics   int64
+	flagged   bool
+}
+
+// AnomalousInstall is an install flagged by AnomalySink.
+type AnomalousInstall struct {
+	InstallID string `json:"installId"`
+	// Versions and Platforms are those reported, up to one more than the
+	// limit.
+	Versions  []string `json:"versions"`
+	Platforms []string `json:"platforms,omitempty"`
+	// Metrics is the number of metrics the install sent on the day.
+	Metrics int64 `json:"metrics"`
+}
+
+// AnomalyReportResponse is rendered by HandleAnomalyReport.
+type AnomalyReportResponse struct {
+	AppID string `json:"appId"`
+	// Day is the UTC day reported, e.g. "2024-01-02".
+	Day      string              `json:"day"`
+	Installs []*AnomalousInstall `json:"installs"`
+}
+
+// WriteMetric writes m to Next and records its install's activity. Installs
+// are logged once per day when they are first flagged.
+func (s *AnomalySink) WriteMetric(ctx context.Context, m *MetricRecord) error {
+	if err := s.Next.WriteMetric(ctx, m); err != nil {
+		return err //nolint:wrapcheck // Want passthrough error.
+	}
+	if m.InstallID == "" || isLegacyInstallID(m.InstallID) {
+		return nil
+	}
+
+	if a := s.record(m); a != nil {
+		logging.FromContext(ctx).WarnContext(ctx, "install flagged as anomalous",
+			"app_id", m.AppID,
+			"install_id", m.InstallID,
+			"versions", a.versions,
+			"platforms", a.platforms)
+	}
+	return nil
+}
+
+// record adds m to its install's activity for today, returning a copy of the
+// activity if this flagged the install.
+func (s *AnomalySink) record(m *MetricRecord) *installActivity {
+	maxVersions, maxPlatforms := s.limits()
+	day := statsDay(s.clock())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.days == nil {
+		s.days = make(map[string]map[installKey]*installActivity)
+	}
+	installs := s.days[day]
+	if installs == nil {
+		installs = make(map[installKey]*installActivity)
+		s.days[day] = installs
+		if t, err := time.Parse(time.DateOnly, day); err == nil {
+			oldest := statsDay(t.AddDate(0, 0, -AnomalyDays+1))
+			for d := range s.days {
+				if d < oldest {
+					delete(s.days, d)
+				}
+			}
+		}
+	}
+	key := installKey{appID: m.AppID, installID: m.InstallID}
+	a := installs[key]
+	if a == nil {
+		a = &installActivity{}
+		installs[key] = a
+	}
+
+	a.metrics++
+	a.versions = addDistinct(a.versions, m.AppVersion, maxVersions+1)
+	a.platforms = addDistinct(a.platforms, metricPlatform(m), maxPlatforms+1)
+	if a.flagged || (len(a.versions) <= maxVersions && len(a.platforms) <= maxPlatforms) {
+		return nil
+	}
+	a.flagged = true
+	return &installActivity{versions: slices.Clone(a.versions), platforms: slices.Clone(a.platforms)}
+}
+
+// Report returns the installs of appID flagged on day, sorted by install ID.
+func (s *AnomalySink) Report(appID, day string) []*AnomalousInstall {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]*AnomalousInstall, 0)
+	for key, a := range s.days[day] {
+		if key.appID != appID || !a.flagged {
+			continue
+		}
+		out = append(out, &AnomalousInstall{
+			InstallID: key.installID,
+			Versions:  sortedCopy(a.versions),
+			Platforms: sortedCopy(a.platforms),
+			Metrics:   a.metrics,
+		})
+	}
+	slices.SortFunc(out, func(a, b *AnomalousInstall) int {
+		return strings.Compare(a.InstallID, b.InstallID)
+	})
+	return out
+}
+
+// sortedCopy returns a sorted copy of values.
+func sortedCopy(values []string) []string {
+	out := slices.Clone(values)
+	slices.Sort(out)
+	return out
+}
+
+// limits returns the configured limits, or their defaults.
+func (s *AnomalySink) limits() (int, int) {
+	maxVersions, maxPlatforms := s.MaxVersions, s.MaxPlatforms
+	if maxVersions <= 0 {
+		maxVersions = DefaultAnomalyMaxVersions
+	}
+	if maxPlatforms <= 0 {
+		maxPlatforms = DefaultAnomalyMaxPlatforms
+	}
+	return maxVersions, maxPlatforms
+}
+
+// clock returns the current time.
+func (s *AnomalySink) clock() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
+
+// addDistinct appends v to values if it is non-empty, not already present,
+// and values has fewer than limit entries.
+func addDistinct(values []string, v string, limit int) []string {
+	if v == "" || len(values) >= limit || slices.Contains(values, v) {
+		return values
+	}
+	return append(values, v)
+}
+
+// metricPlatform returns the platform m's app was built for, e.g.
+// "linux/amd64", from its build info, or "" if unknown.
+func metricPlatform(m *MetricRecord) string {
+	if m.BuildInfo == nil {
+		return ""
+	}
+	// Builder is formatted as "<compiler> <GOOS>/<GOARCH>".
+	_, platform, _ := strings.Cut(m.BuildInfo.Builder, " ")
+	return platform
+}
+
+// HandleAnomalyReport returns a handler which renders the installs of the app
+// in the "id" path value flagged by s on the UTC day in the "day" query
+// parameter, e.g. "2024-01-02". The day defaults to yesterday, the most recent
+// complete day. It should be registered behind RequireAppToken.
+func HandleAnomalyReport(h JSONRenderer, db AppLister, s *AnomalySink) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		appID := r.PathValue("id")
+		if appInfo(db, appID) == nil {
+			h.RenderJSON(w, http.StatusNotFound, apierror.New(apierror.CodeUnknownApp, "unknown app %q", appID))
+			return
+		}
+
+		day := r.URL.Query().Get("day")
+		if day == "" {
+			day = statsDay(s.clock().AddDate(0, 0, -1))
+		}
+		if _, err := time.Parse(time.DateOnly, day); err != nil {
+			h.RenderJSON(w, http.StatusBadRequest, apierror.New(apierror.CodeMalformedRequest,
+				"invalid day %q: must be a date like 2024-01-02", day))
+			return
+		}
+
+		h.RenderJSON(w, http.StatusOK, &AnomalyReportResponse{
+			AppID:    appID,
+			Day:      day,
+			Installs: s.Report(appID, day),
+		})
+	})
+}
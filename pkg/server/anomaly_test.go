@@ -0,0 +1,172 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+	"github.com/abcxyz/pkg/renderer"
+	"github.com/abcxyz/pkg/testutil"
+)
+
+func TestAnomalySink(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	now := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+	s := &AnomalySink{Next: &testSink{}, MaxVersions: 2, MaxPlatforms: 1, now: func() time.Time { return now }}
+
+	built := func(platform string) *api.BuildInfo { return &api.BuildInfo{Builder: "gc " + platform} }
+	for _, m := range []*MetricRecord{
+		// A normal install, upgraded once.
+		{AppID: "foo", InstallID: "normal", AppVersion: "1.0.0"},
+		{AppID: "foo", InstallID: "normal", AppVersion: "1.1.0"},
+		{AppID: "foo", InstallID: "normal", AppVersion: "1.1.0"},
+		// A bot cycling through versions.
+		{AppID: "foo", InstallID: "versions", AppVersion: "1.0.0"},
+		{AppID: "foo", InstallID: "versions", AppVersion: "1.1.0"},
+		{AppID: "foo", InstallID: "versions", AppVersion: "1.2.0"},
+		{AppID: "foo", InstallID: "versions", AppVersion: "1.3.0"},
+		// An install ID copied to machines of different platforms.
+		{AppID: "foo", InstallID: "platforms", AppVersion: "1.0.0", BuildInfo: built("linux/amd64")},
+		{AppID: "foo", InstallID: "platforms", AppVersion: "1.0.0", BuildInfo: built("darwin/arm64")},
+		// The same install ID in another app is tracked separately.
+		{AppID: "bar", InstallID: "versions", AppVersion: "1.0.0"},
+		// Older clients installed at the same time share a legacy ID.
+		{AppID: "foo", InstallID: "legacy-1706702400", AppVersion: "0.9.0"},
+		{AppID: "foo", InstallID: "legacy-1706702400", AppVersion: "0.9.1"},
+		{AppID: "foo", InstallID: "legacy-1706702400", AppVersion: "0.9.2"},
+	} {
+		if err := s.WriteMetric(ctx, m); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+	}
+
+	want := []*AnomalousInstall{
+		{InstallID: "platforms", Versions: []string{"1.0.0"}, Platforms: []string{"darwin/arm64", "linux/amd64"}, Metrics: 2},
+		{InstallID: "versions", Versions: []string{"1.0.0", "1.1.0", "1.2.0"}, Metrics: 4},
+	}
+	if diff := cmp.Diff(s.Report("foo", "2024-01-02"), want); diff != "" {
+		t.Errorf("unexpected report (-got,+want): %s", diff)
+	}
+	if diff := cmp.Diff(s.Report("bar", "2024-01-02"), []*AnomalousInstall{}); diff != "" {
+		t.Errorf("unexpected report for other app (-got,+want): %s", diff)
+	}
+
+	// Activity is counted per day, and old days are dropped.
+	now = now.AddDate(0, 0, AnomalyDays)
+	if err := s.WriteMetric(ctx, &MetricRecord{AppID: "foo", InstallID: "versions", AppVersion: "1.4.0"}); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if diff := cmp.Diff(s.Report("foo", statsDay(now)), []*AnomalousInstall{}); diff != "" {
+		t.Errorf("unexpected report for new day (-got,+want): %s", diff)
+	}
+	if diff := cmp.Diff(s.Report("foo", "2024-01-02"), []*AnomalousInstall{}); diff != "" {
+		t.Errorf("expected old day to be dropped (-got,+want): %s", diff)
+	}
+
+	// Metrics rejected by the next sink are not recorded.
+	s.Next = &testSink{err: fmt.Errorf("sink down")}
+	err := s.WriteMetric(ctx, &MetricRecord{AppID: "foo", InstallID: "x", AppVersion: "1.0.0"})
+	if diff := testutil.DiffErrString(err, "sink down"); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestHandleAnomalyReport(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	h, err := renderer.New(ctx, nil)
+	if err != nil {
+		t.Fatalf("failed to setup test: %s", err.Error())
+	}
+	now := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+	s := &AnomalySink{Next: &testSink{}, MaxVersions: 1, now: func() time.Time { return now.AddDate(0, 0, -1) }}
+	for _, v := range []string{"1.0.0", "1.1.0"} {
+		if err := s.WriteMetric(ctx, &MetricRecord{AppID: "foo", InstallID: "bot", AppVersion: v}); err != nil {
+			t.Fatalf("failed to setup test: %s", err.Error())
+		}
+	}
+	s.now = func() time.Time { return now }
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /v1/apps/{id}/anomalies", HandleAnomalyReport(h, testAdminDB(t), s))
+
+	cases := []struct {
+		name       string
+		path       string
+		wantStatus int
+		want       *AnomalyReportResponse
+	}{
+		{
+			name:       "default_yesterday",
+			path:       "/v1/apps/foo/anomalies",
+			wantStatus: http.StatusOK,
+			want: &AnomalyReportResponse{
+				AppID:    "foo",
+				Day:      "2024-01-01",
+				Installs: []*AnomalousInstall{{InstallID: "bot", Versions: []string{"1.0.0", "1.1.0"}, Metrics: 2}},
+			},
+		},
+		{
+			name:       "day",
+			path:       "/v1/apps/foo/anomalies?day=2024-01-02",
+			wantStatus: http.StatusOK,
+			want:       &AnomalyReportResponse{AppID: "foo", Day: "2024-01-02", Installs: []*AnomalousInstall{}},
+		},
+		{
+			name:       "unknown_app",
+			path:       "/v1/apps/baz/anomalies",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "invalid_day",
+			path:       "/v1/apps/foo/anomalies?day=yesterday",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.path, nil))
+			if got, want := w.Code, tc.wantStatus; got != want {
+				t.Errorf("unexpected response code. got %d want %d", got, want)
+			}
+			if tc.want == nil {
+				return
+			}
+			var got AnomalyReportResponse
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode response: %s", err.Error())
+			}
+			if diff := cmp.Diff(&got, tc.want); diff != "" {
+				t.Errorf("unexpected response (-got,+want): %s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+	"github.com/abcxyz/abc-updater/pkg/apierror"
+	"github.com/abcxyz/pkg/logging"
+)
+
+// AppData is the version data for an app, as fetched from the metadata
+// source.
+type AppData struct {
+	AppID string
+	Data  *api.AppResponse
+
+	// content is the data.json body exactly as fetched, so it is served
+	// byte-for-byte.
+	content *cachedContent
+}
+
+// AppDataLookuper looks up version data for an app.
+type AppDataLookuper interface {
+	GetAppData(appID string) (*AppData, error)
+}
+
+// HandleAppData returns a http.Handler which serves the data.json version data
+// for the app in the "id" path value, so a single deployment can serve both
+// version checks and metrics. HEAD and conditional requests are supported.
+func HandleAppData(h JSONRenderer, db AppDataLookuper) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		appID := r.PathValue("id")
+		data, err := db.GetAppData(appID)
+		if err != nil {
+			logging.FromContext(r.Context()).DebugContext(r.Context(), "received version data request for unknown app",
+				"app_id", appID)
+			h.RenderJSON(w, http.StatusNotFound, apierror.New(apierror.CodeUnknownApp, "unknown app %q", appID))
+			return
+		}
+		if data.Data != nil && data.Data.Retired.Dropped(time.Now()) {
+			h.RenderJSON(w, http.StatusGone, apierror.New(apierror.CodeAppRetired, "app %q is retired", appID))
+			return
+		}
+		data.content.serve(w, r, defaultCacheMaxAge)
+	})
+}
+
+// withRetirement returns a copy of d announcing r to clients. The served body
+// is re-encoded, so it no longer matches the fetched data.json byte-for-byte.
+func (d *AppData) withRetirement(r *api.Retirement) *AppData {
+	data := *d.Data
+	data.Retired = r
+	b, err := json.Marshal(&data)
+	if err != nil {
+		// Not possible for AppResponse; serve the data unchanged.
+		return d
+	}
+	return &AppData{
+		AppID:   d.AppID,
+		Data:    &data,
+		content: newCachedContent(b, d.content.contentType, d.content.fetched),
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+	"github.com/abcxyz/pkg/renderer"
+)
+
+func TestHandleAppData(t *testing.T) {
+	t.Parallel()
+
+	body := `{"appId":"foo","currentVersion":"1.0.0"}`
+	foo := &AppData{
+		AppID:   "foo",
+		Data:    &api.AppResponse{AppID: "foo", CurrentVersion: "1.0.0"},
+		content: newCachedContent([]byte(body), "application/json", time.Now()),
+	}
+	db := &MetricsDB{data: map[string]*AppData{
+		"foo":  foo,
+		"old":  foo.withRetirement(&api.Retirement{DropAfter: time.Date(3000, 1, 1, 0, 0, 0, 0, time.UTC), Message: "Use foo."}),
+		"gone": foo.withRetirement(&api.Retirement{DropAfter: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)}),
+	}}
+
+	cases := []struct {
+		name       string
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "known_app",
+			path:       "/apps/foo/data.json",
+			wantStatus: http.StatusOK,
+			wantBody:   body,
+		},
+		{
+			name:       "retired_app",
+			path:       "/apps/old/data.json",
+			wantStatus: http.StatusOK,
+			wantBody:   `{"appId":"foo","appName":"","appRepoUrl":"","currentVersion":"1.0.0","retired":{"dropAfter":"3000-01-01T00:00:00Z","message":"Use foo."}}`,
+		},
+		{
+			name:       "dropped_app",
+			path:       "/apps/gone/data.json",
+			wantStatus: http.StatusGone,
+			wantBody:   `{"code":"APP_RETIRED","message":"app \"gone\" is retired"}`,
+		},
+		{
+			name:       "unknown_app",
+			path:       "/apps/bar/data.json",
+			wantStatus: http.StatusNotFound,
+			wantBody:   `{"code":"UNKNOWN_APP","message":"unknown app \"bar\""}`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			h, err := renderer.New(context.Background(), nil)
+			if err != nil {
+				t.Fatalf("failed to setup test: %s", err.Error())
+			}
+			mux := http.NewServeMux()
+			mux.Handle("GET /apps/{id}/data.json", HandleAppData(h, db))
+
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.path, nil))
+			resp := w.Result()
+			defer resp.Body.Close()
+
+			if got, want := resp.StatusCode, tc.wantStatus; got != want {
+				t.Errorf("unexpected status. got %d want %d", got, want)
+			}
+			b, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("failed to read body: %s", err.Error())
+			}
+			if got, want := string(b), tc.wantBody; got != want && got != want+"\n" {
+				t.Errorf("unexpected body. got %q want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+	"github.com/abcxyz/abc-updater/pkg/apierror"
+	"github.com/abcxyz/pkg/logging"
+)
+
+const (
+	// BulkContentType is the Content-Type of bulk metric requests.
+	BulkContentType = "application/x-ndjson"
+
+	// maxBulkLines is the most requests in a single bulk request.
+	maxBulkLines = 1000
+)
+
+// bulkLine is a non-empty line of a bulk request.
+type bulkLine struct {
+	num  int
+	data []byte
+}
+
+// HandleBulkMetrics returns a handler for POST requests with a body of
+// newline delimited SendMetricRequests, e.g. from forwarders draining a queue.
+// Each line is handled as if sent to HandleMetricWithSink on its own, and the
+// response has a result for each line. The request itself is only rejected if
+// its body cannot be read.
+func HandleBulkMetrics(h JSONRenderer, db MetricsLookuper, sink MetricSink) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger := logging.FromContext(r.Context())
+		logger.InfoContext(r.Context(), "handling bulk request")
+
+		lines, err := readBulkLines(w, r, h)
+		if err != nil {
+			// Error response already handled by readBulkLines.
+			return
+		}
+
+		resp := &api.BulkSendMetricResponse{Results: make([]*api.BulkSendMetricResult, 0, len(lines))}
+		for _, line := range lines {
+			result := &api.BulkSendMetricResult{Line: line.num}
+			req, status, apiErr := decodeBulkLine(line.data)
+			if apiErr == nil {
+				var sendResp *api.SendMetricResponse
+				sendResp, status, apiErr = recordMetrics(r.Context(), db, sink, req)
+				if sendResp != nil {
+					result.Warnings = sendResp.Warnings
+					result.Dispositions = sendResp.Dispositions
+				}
+			}
+			result.Status = status
+			if apiErr != nil {
+				result.Code = string(apiErr.Code)
+				result.Message = apiErr.Message
+				resp.Rejected++
+			} else {
+				resp.Accepted++
+			}
+			resp.Results = append(resp.Results, result)
+		}
+		h.RenderJSON(w, http.StatusOK, resp)
+	})
+}
+
+// readBulkLines reads the non-empty lines of a bulk request's body. Errors are
+// written to w.
+func readBulkLines(w http.ResponseWriter, r *http.Request, h JSONRenderer) ([]*bulkLine, error) {
+	if t := r.Header.Get("content-type"); !strings.HasPrefix(t, BulkContentType) {
+		err := fmt.Errorf("invalid content type: content-type %q is not %q", t, BulkContentType)
+		return nil, rejectRequest(w, h, http.StatusUnsupportedMediaType, apierror.CodeUnsupportedMediaType, err)
+	}
+
+	defer r.Body.Close()
+	body, closeBody, err := requestBody(w, r, h)
+	if err != nil {
+		return nil, err
+	}
+	defer closeBody()
+
+	var lines []*bulkLine
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(nil, maxRequestBodyBytes)
+	for num := 1; scanner.Scan(); num++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		if len(lines) == maxBulkLines {
+			err := fmt.Errorf("request contains more than %d lines", maxBulkLines)
+			return nil, rejectRequest(w, h, http.StatusRequestEntityTooLarge, apierror.CodeRequestTooLarge, err)
+		}
+		lines = append(lines, &bulkLine{num: num, data: bytes.Clone(data)})
+	}
+	switch err := scanner.Err(); {
+	case err == nil:
+	case bodyTooLarge(err), errors.Is(err, bufio.ErrTooLong):
+		err = fmt.Errorf("request body too large")
+		return nil, rejectRequest(w, h, http.StatusRequestEntityTooLarge, apierror.CodeRequestTooLarge, err)
+	case errors.Is(err, gzip.ErrChecksum), errors.Is(err, gzip.ErrHeader), errors.Is(err, io.ErrUnexpectedEOF):
+		err = fmt.Errorf("malformed gzip body")
+		return nil, rejectRequest(w, h, http.StatusBadRequest, apierror.CodeMalformedGzip, err)
+	default:
+		err = fmt.Errorf("failed to read request body: %w", err)
+		return nil, rejectRequest(w, h, http.StatusBadRequest, apierror.CodeMalformedRequest, err)
+	}
+
+	if len(lines) == 0 {
+		err := fmt.Errorf("body must not be empty")
+		return nil, rejectRequest(w, h, http.StatusBadRequest, apierror.CodeEmptyBody, err)
+	}
+	return lines, nil
+}
+
+// decodeBulkLine decodes a single line of a bulk request. If it cannot be
+// decoded, the status and error it is rejected with are returned.
+func decodeBulkLine(data []byte) (*metricRequest, int, *apierror.Response) {
+	var req metricRequest
+	d := json.NewDecoder(bytes.NewReader(data))
+	if err := d.Decode(&req); err != nil {
+		status, code, err := decodeError(err)
+		return nil, status, apierror.New(code, "%s", err)
+	}
+	if d.More() {
+		return nil, http.StatusBadRequest, apierror.New(apierror.CodeTrailingData, "line contained more than one json object")
+	}
+	return &req, 0, nil
+}
@@ -0,0 +1,165 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+	"github.com/abcxyz/abc-updater/pkg/apierror"
+	"github.com/abcxyz/pkg/renderer"
+)
+
+func TestHandleBulkMetrics(t *testing.T) {
+	t.Parallel()
+
+	db := &testMetricsDB{apps: map[string]*AppMetrics{"test": {
+		AppID:   "test",
+		Allowed: map[string]interface{}{"foo": struct{}{}},
+	}}}
+
+	cases := []struct {
+		name            string
+		body            io.Reader
+		contentType     string
+		contentEncoding string
+		wantStatus      int
+		wantCode        apierror.Code
+		want            *api.BulkSendMetricResponse
+		wantWritten     int
+	}{
+		{
+			name: "mixed_lines",
+			body: strings.NewReader(strings.Join([]string{
+				`{"appId":"test","appVersion":"1.0","metrics":{"foo":1},"installId":"a"}`,
+				``,
+				`{"appId":"other","appVersion":"1.0","metrics":{"foo":1},"installId":"a"}`,
+				`{"appId":`,
+				`{"appId":"test","appVersion":"1.0","metrics":{"foo":2,"bar":1},"installId":"b"}`,
+			}, "\n")),
+			wantStatus: http.StatusOK,
+			want: &api.BulkSendMetricResponse{
+				Accepted: 2,
+				Rejected: 2,
+				Results: []*api.BulkSendMetricResult{
+					{Line: 1, Status: http.StatusAccepted},
+					{Line: 3, Status: http.StatusNotFound, Code: string(apierror.CodeUnknownApp), Message: `unknown app "other"`},
+					{Line: 4, Status: http.StatusBadRequest, Code: string(apierror.CodeMalformedJSON), Message: "malformed json"},
+					{
+						Line:     5,
+						Status:   http.StatusAccepted,
+						Warnings: []string{`metric "bar" not allowed`},
+					},
+				},
+			},
+			wantWritten: 2,
+		},
+		{
+			name: "gzip_body",
+			body: gzipReader(t, strings.NewReader(
+				`{"appId":"test","appVersion":"1.0","metrics":{"foo":1},"installId":"a"}`+"\n")),
+			contentEncoding: "gzip",
+			wantStatus:      http.StatusOK,
+			want: &api.BulkSendMetricResponse{
+				Accepted: 1,
+				Results:  []*api.BulkSendMetricResult{{Line: 1, Status: http.StatusAccepted}},
+			},
+			wantWritten: 1,
+		},
+		{
+			name:        "wrong_content_type",
+			body:        strings.NewReader(`{"appId":"test"}`),
+			contentType: "application/json",
+			wantStatus:  http.StatusUnsupportedMediaType,
+			wantCode:    apierror.CodeUnsupportedMediaType,
+		},
+		{
+			name:       "empty_body",
+			body:       strings.NewReader("\n\n"),
+			wantStatus: http.StatusBadRequest,
+			wantCode:   apierror.CodeEmptyBody,
+		},
+		{
+			name:       "too_many_lines",
+			body:       strings.NewReader(strings.Repeat("{}\n", maxBulkLines+1)),
+			wantStatus: http.StatusRequestEntityTooLarge,
+			wantCode:   apierror.CodeRequestTooLarge,
+		},
+		{
+			name:            "malformed_gzip",
+			body:            strings.NewReader("not gzip"),
+			contentEncoding: "gzip",
+			wantStatus:      http.StatusBadRequest,
+			wantCode:        apierror.CodeMalformedGzip,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			h, err := renderer.New(context.Background(), nil)
+			if err != nil {
+				t.Fatalf("failed to setup test: %s", err.Error())
+			}
+			sink := &testSink{}
+
+			req := httptest.NewRequest(http.MethodPost, "/sendMetrics/bulk", tc.body)
+			contentType := BulkContentType
+			if tc.contentType != "" {
+				contentType = tc.contentType
+			}
+			req.Header.Set("Content-Type", contentType)
+			if tc.contentEncoding != "" {
+				req.Header.Set("Content-Encoding", tc.contentEncoding)
+			}
+			w := httptest.NewRecorder()
+			HandleBulkMetrics(h, db, sink).ServeHTTP(w, req)
+
+			if got, want := w.Code, tc.wantStatus; got != want {
+				t.Errorf("unexpected response code. got %d want %d", got, want)
+			}
+			if tc.wantCode != "" {
+				var body apierror.Response
+				if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+					t.Fatalf("failed to decode error response: %s", err.Error())
+				}
+				if got, want := body.Code, tc.wantCode; got != want {
+					t.Errorf("unexpected error code. got %q want %q", got, want)
+				}
+			}
+			if tc.want != nil {
+				var got api.BulkSendMetricResponse
+				if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+					t.Fatalf("failed to decode response: %s", err.Error())
+				}
+				if diff := cmp.Diff(&got, tc.want); diff != "" {
+					t.Errorf("unexpected response (-got,+want): %s", diff)
+				}
+			}
+			if got, want := len(sink.written), tc.wantWritten; got != want {
+				t.Errorf("unexpected number of metrics written. got %d want %d", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultCacheMaxAge is how long downstream caches may serve cached content
+// without revalidating.
+const defaultCacheMaxAge = 5 * time.Minute
+
+// cachedContent is a response body which is served with HTTP caching headers,
+// so CDNs in front of the server can cache it effectively.
+type cachedContent struct {
+	body        []byte
+	contentType string
+	etag        string
+	// fetched is when the content was fetched from its origin. It is used to
+	// compute the Age header.
+	fetched time.Time
+}
+
+// newCachedContent creates cachedContent with a strong ETag derived from body.
+func newCachedContent(body []byte, contentType string, fetched time.Time) *cachedContent {
+	sum := sha256.Sum256(body)
+	return &cachedContent{
+		body:        body,
+		contentType: contentType,
+		etag:        `"` + hex.EncodeToString(sum[:16]) + `"`,
+		fetched:     fetched,
+	}
+}
+
+// serve writes the content with Cache-Control, ETag, and Age headers. It
+// responds 304 if the request's If-None-Match matches, and omits the body for
+// HEAD requests.
+func (c *cachedContent) serve(w http.ResponseWriter, r *http.Request, maxAge time.Duration) {
+	hdr := w.Header()
+	hdr.Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int64(maxAge.Seconds())))
+	hdr.Set("ETag", c.etag)
+	age := time.Since(c.fetched)
+	if age < 0 {
+		age = 0
+	}
+	hdr.Set("Age", strconv.FormatInt(int64(age.Seconds()), 10))
+
+	if etagMatches(r.Header.Get("If-None-Match"), c.etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	hdr.Set("Content-Type", c.contentType)
+	hdr.Set("Content-Length", strconv.Itoa(len(c.body)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write(c.body) //nolint:errcheck // Nothing to do if the client went away.
+}
+
+// etagMatches reports whether an If-None-Match header value matches etag,
+// using the weak comparison required by RFC 9110.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, v := range strings.Split(ifNoneMatch, ",") {
+		v = strings.TrimSpace(v)
+		if v == "*" || strings.TrimPrefix(v, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCachedContentServe(t *testing.T) {
+	t.Parallel()
+
+	content := newCachedContent([]byte(`{"appId":"foo"}`), "application/json", time.Now().Add(-30*time.Second))
+
+	cases := []struct {
+		name        string
+		method      string
+		ifNoneMatch string
+		wantStatus  int
+		wantBody    string
+	}{
+		{
+			name:       "get",
+			method:     http.MethodGet,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"appId":"foo"}`,
+		},
+		{
+			name:       "head_has_no_body",
+			method:     http.MethodHead,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:        "matching_etag",
+			method:      http.MethodGet,
+			ifNoneMatch: content.etag,
+			wantStatus:  http.StatusNotModified,
+		},
+		{
+			name:        "matching_weak_etag_in_list",
+			method:      http.MethodGet,
+			ifNoneMatch: `"other", W/` + content.etag,
+			wantStatus:  http.StatusNotModified,
+		},
+		{
+			name:        "wildcard",
+			method:      http.MethodHead,
+			ifNoneMatch: "*",
+			wantStatus:  http.StatusNotModified,
+		},
+		{
+			name:        "stale_etag",
+			method:      http.MethodGet,
+			ifNoneMatch: `"other"`,
+			wantStatus:  http.StatusOK,
+			wantBody:    `{"appId":"foo"}`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(tc.method, "/apps/foo/data.json", nil)
+			if tc.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", tc.ifNoneMatch)
+			}
+			w := httptest.NewRecorder()
+			content.serve(w, req, defaultCacheMaxAge)
+			resp := w.Result()
+			defer resp.Body.Close()
+
+			if got, want := resp.StatusCode, tc.wantStatus; got != want {
+				t.Errorf("unexpected status. got %d want %d", got, want)
+			}
+			for k, want := range map[string]string{
+				"Cache-Control": "public, max-age=300",
+				"ETag":          content.etag,
+				"Age":           "30",
+			} {
+				if got := resp.Header.Get(k); got != want {
+					t.Errorf("unexpected %s header. got %q want %q", k, got, want)
+				}
+			}
+			if tc.wantStatus == http.StatusOK {
+				if got, want := resp.Header.Get("Content-Length"), "15"; got != want {
+					t.Errorf("unexpected Content-Length header. got %q want %q", got, want)
+				}
+			}
+			b, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("failed to read body: %s", err.Error())
+			}
+			if got, want := string(b), tc.wantBody; got != want {
+				t.Errorf("unexpected body. got %q want %q", got, want)
+			}
+		})
+	}
+}
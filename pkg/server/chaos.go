@@ -0,0 +1,157 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/abcxyz/pkg/logging"
+)
+
+// Chaos injects faults into responses, so tests can check that clients
+// handle misbehaving servers: time out, retry or fail over, and never block
+// the app. It must never be enabled in production.
+type Chaos struct {
+	// ErrorRate is the fraction of requests answered with a 500 instead of
+	// being served.
+	ErrorRate float64
+
+	// DelayRate is the fraction of requests delayed by Delay before being
+	// served.
+	DelayRate float64
+	Delay     time.Duration
+
+	// TruncateRate is the fraction of responses cut off halfway through the
+	// body, after the full Content-Length was sent.
+	TruncateRate float64
+
+	// rand is used in tests. Defaults to rand.Float64.
+	rand func() float64
+}
+
+// ParseChaos returns a Chaos from settings, which are "error_rate",
+// "delay_rate", "truncate_rate", each a fraction in [0, 1], and "delay", a
+// duration. Returns nil if settings is empty.
+func ParseChaos(settings map[string]string) (*Chaos, error) {
+	if len(settings) == 0 {
+		return nil, nil
+	}
+	c := &Chaos{}
+	for k, v := range settings {
+		var rate *float64
+		switch k {
+		case "error_rate":
+			rate = &c.ErrorRate
+		case "delay_rate":
+			rate = &c.DelayRate
+		case "truncate_rate":
+			rate = &c.TruncateRate
+		case "delay":
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 0 {
+				return nil, fmt.Errorf("chaos delay %q must be a non-negative duration", v)
+			}
+			c.Delay = d
+			continue
+		default:
+			return nil, fmt.Errorf("unknown chaos setting %q", k)
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 || f > 1 {
+			return nil, fmt.Errorf("chaos %s %q must be between 0 and 1", k, v)
+		}
+		*rate = f
+	}
+	return c, nil
+}
+
+// Wrap returns next with faults injected. Each fault is chosen independently
+// for each request. A nil Chaos returns next.
+func (c *Chaos) Wrap(next http.Handler) http.Handler {
+	if c == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := logging.FromContext(ctx)
+
+		if c.roll(c.DelayRate) {
+			logger.DebugContext(ctx, "chaos: delaying response", "delay", c.Delay.String())
+			t := time.NewTimer(c.Delay)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				return
+			}
+		}
+		if c.roll(c.ErrorRate) {
+			logger.DebugContext(ctx, "chaos: failing request")
+			// A bare 500, as from a crashing server or a proxy.
+			http.Error(w, "injected fault", http.StatusInternalServerError)
+			return
+		}
+		if !c.roll(c.TruncateRate) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		logger.DebugContext(ctx, "chaos: truncating response")
+		rec := &bufferedResponse{header: make(http.Header), status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		for k, v := range rec.header {
+			w.Header()[k] = v
+		}
+		body := rec.body.Bytes()
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(rec.status)
+		// The server closes the connection when the handler returns without
+		// writing the full Content-Length, so clients see an unexpected EOF.
+		w.Write(body[:len(body)/2]) //nolint:errcheck // Nothing to do if the client went away.
+	})
+}
+
+// roll returns true with probability rate.
+func (c *Chaos) roll(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	f := rand.Float64 //nolint:gosec // Fault injection does not need a secure source.
+	if c.rand != nil {
+		f = c.rand
+	}
+	return f() < rate
+}
+
+// bufferedResponse is an http.ResponseWriter which keeps the response in
+// memory.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header { return b.header }
+
+func (b *bufferedResponse) WriteHeader(status int) { b.status = status }
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	return b.body.Write(p) //nolint:wrapcheck // Never fails.
+}
@@ -0,0 +1,241 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/sethvargo/go-envconfig"
+
+	"github.com/abcxyz/abc-updater/pkg/metrics"
+	"github.com/abcxyz/pkg/renderer"
+	"github.com/abcxyz/pkg/testutil"
+)
+
+func TestParseChaos(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		settings map[string]string
+		want     *Chaos
+		wantErr  string
+	}{
+		{
+			name: "disabled",
+		},
+		{
+			name:     "all",
+			settings: map[string]string{"error_rate": "0.1", "delay": "2s", "delay_rate": "0.5", "truncate_rate": "1"},
+			want:     &Chaos{ErrorRate: 0.1, Delay: 2 * time.Second, DelayRate: 0.5, TruncateRate: 1},
+		},
+		{
+			name:     "rate_out_of_range",
+			settings: map[string]string{"error_rate": "1.5"},
+			wantErr:  "must be between 0 and 1",
+		},
+		{
+			name:     "invalid_delay",
+			settings: map[string]string{"delay": "soon"},
+			wantErr:  "must be a non-negative duration",
+		},
+		{
+			name:     "unknown",
+			settings: map[string]string{"drop_rate": "0.1"},
+			wantErr:  `unknown chaos setting "drop_rate"`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := ParseChaos(tc.settings)
+			if diff := testutil.DiffErrString(err, tc.wantErr); diff != "" {
+				t.Fatal(diff)
+			}
+			if diff := cmp.Diff(got, tc.want, cmp.AllowUnexported(Chaos{})); diff != "" {
+				t.Errorf("unexpected chaos (-got,+want): %s", diff)
+			}
+		})
+	}
+}
+
+func TestChaos_Wrap(t *testing.T) {
+	t.Parallel()
+
+	always := func() float64 { return 0 }
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "0123456789") //nolint:errcheck
+	})
+
+	cases := []struct {
+		name       string
+		chaos      *Chaos
+		wantStatus int
+		wantBody   string
+		wantErr    error
+		minLatency time.Duration
+	}{
+		{
+			name:       "nil",
+			wantStatus: http.StatusOK,
+			wantBody:   "0123456789",
+		},
+		{
+			name:       "no_faults",
+			chaos:      &Chaos{rand: always},
+			wantStatus: http.StatusOK,
+			wantBody:   "0123456789",
+		},
+		{
+			name:       "error",
+			chaos:      &Chaos{ErrorRate: 1, rand: always},
+			wantStatus: http.StatusInternalServerError,
+			wantBody:   "injected fault\n",
+		},
+		{
+			name:       "delay",
+			chaos:      &Chaos{DelayRate: 1, Delay: 50 * time.Millisecond, rand: always},
+			wantStatus: http.StatusOK,
+			wantBody:   "0123456789",
+			minLatency: 50 * time.Millisecond,
+		},
+		{
+			name:       "truncate",
+			chaos:      &Chaos{TruncateRate: 1, rand: always},
+			wantStatus: http.StatusOK,
+			wantBody:   "01234",
+			wantErr:    io.ErrUnexpectedEOF,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ts := httptest.NewServer(tc.chaos.Wrap(ok))
+			t.Cleanup(ts.Close)
+
+			start := time.Now()
+			resp, err := http.Get(ts.URL)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("unexpected error reading body. got %v want %v", err, tc.wantErr)
+			}
+			if got := time.Since(start); got < tc.minLatency {
+				t.Errorf("expected response to take at least %s, took %s", tc.minLatency, got)
+			}
+			if got, want := resp.StatusCode, tc.wantStatus; got != want {
+				t.Errorf("unexpected status. got %d want %d", got, want)
+			}
+			if got, want := string(body), tc.wantBody; got != want {
+				t.Errorf("unexpected body. got %q want %q", got, want)
+			}
+		})
+	}
+}
+
+// TestChaos_MetricsClient checks that the metrics client gives up on a
+// misbehaving server, rather than waiting for it, and fails over to a healthy
+// one.
+//
+// Not parallel: slow responses would hold up parallel tests which check the
+// clock.
+func TestChaos_MetricsClient(t *testing.T) { //nolint:paralleltest // See above.
+	h, err := renderer.New(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("failed to setup test: %s", err.Error())
+	}
+	db := &testMetricsDB{apps: map[string]*AppMetrics{"test": {
+		AppID:   "test",
+		Allowed: map[string]interface{}{"foo": struct{}{}},
+	}}}
+	always := func() float64 { return 0 }
+	healthy := httptest.NewServer(HandleMetric(h, db))
+	t.Cleanup(healthy.Close)
+
+	cases := []struct {
+		name     string
+		chaos    *Chaos
+		fallback bool
+		wantErr  bool
+	}{
+		{
+			name:    "error",
+			chaos:   &Chaos{ErrorRate: 1, rand: always},
+			wantErr: true,
+		},
+		{
+			// Longer than the client's timeout. The server isn't told the
+			// client gave up until it reads the body, so keep it short.
+			name:    "slow",
+			chaos:   &Chaos{DelayRate: 1, Delay: 500 * time.Millisecond, rand: always},
+			wantErr: true,
+		},
+		{
+			// The metric was accepted, so the body is not needed.
+			name:  "truncated",
+			chaos: &Chaos{TruncateRate: 1, rand: always},
+		},
+		{
+			name:     "error_fails_over",
+			chaos:    &Chaos{ErrorRate: 1, rand: always},
+			fallback: true,
+		},
+		{
+			name:     "slow_fails_over",
+			chaos:    &Chaos{DelayRate: 1, Delay: 500 * time.Millisecond, rand: always},
+			fallback: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := httptest.NewServer(tc.chaos.Wrap(HandleMetric(h, db)))
+			t.Cleanup(ts.Close)
+
+			serverURL := ts.URL
+			if tc.fallback {
+				serverURL += "," + healthy.URL
+			}
+			mw, err := metrics.New(context.Background(), "test", "1.0.0",
+				metrics.WithLookuper(envconfig.MapLookuper(map[string]string{"METRICS_URL": serverURL})),
+				metrics.WithInstallIDFileOverride(filepath.Join(t.TempDir(), "id.json")),
+				metrics.WithAllowInsecureLocalhost(),
+				metrics.WithHTTPClient(&http.Client{Timeout: 100 * time.Millisecond}))
+			if err != nil {
+				t.Fatalf("failed to setup test: %s", err.Error())
+			}
+
+			err = mw.WriteMetric(context.Background(), "foo", 1)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Errorf("unexpected error. got %v want error %t", err, tc.wantErr)
+			}
+		})
+	}
+}
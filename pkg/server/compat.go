@@ -0,0 +1,51 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/go-version"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+)
+
+// RequireMinClientVersion wraps next so responses to clients reporting a
+// library version older than minVersion carry headers telling them so. If
+// sunset is non-zero it is sent as the date after which older clients are no
+// longer served. Requests are always passed to next; enforcement is left to
+// the operator. If minVersion is empty, next is returned unchanged.
+func RequireMinClientVersion(minVersion string, sunset time.Time, next http.Handler) (http.Handler, error) {
+	if minVersion == "" {
+		return next, nil
+	}
+	minimum, err := version.NewVersion(minVersion)
+	if err != nil {
+		return nil, fmt.Errorf("invalid minimum client version %q: %w", minVersion, err)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Clients that don't report a version, or report an unparseable one
+		// such as a development build, are left alone.
+		if v, err := version.NewVersion(r.Header.Get(api.HeaderClientVersion)); err == nil && v.LessThan(minimum) {
+			w.Header().Set(api.HeaderMinClientVersion, minimum.String())
+			if !sunset.IsZero() {
+				w.Header().Set(api.HeaderSunset, sunset.UTC().Format(http.TimeFormat))
+			}
+		}
+		next.ServeHTTP(w, r)
+	}), nil
+}
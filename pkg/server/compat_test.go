@@ -0,0 +1,108 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequireMinClientVersion(t *testing.T) {
+	t.Parallel()
+
+	sunset := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name          string
+		minVersion    string
+		sunset        time.Time
+		clientVersion string
+		wantMin       string
+		wantSunset    string
+	}{
+		{
+			name:          "disabled",
+			clientVersion: "0.1.0",
+		},
+		{
+			name:          "supported",
+			minVersion:    "0.2.0",
+			clientVersion: "0.2.0",
+		},
+		{
+			name:          "unsupported",
+			minVersion:    "0.2.0",
+			clientVersion: "0.1.0",
+			wantMin:       "0.2.0",
+		},
+		{
+			name:          "unsupported_with_sunset",
+			minVersion:    "0.2.0",
+			sunset:        sunset,
+			clientVersion: "0.1.0",
+			wantMin:       "0.2.0",
+			wantSunset:    "Sat, 01 Mar 2025 00:00:00 GMT",
+		},
+		{
+			name:       "no_client_version",
+			minVersion: "0.2.0",
+		},
+		{
+			name:          "devel_client",
+			minVersion:    "0.2.0",
+			clientVersion: "devel",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			handler, err := RequireMinClientVersion(tc.minVersion, tc.sunset, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "/apps/sample_app_1/data.json", nil)
+			if tc.clientVersion != "" {
+				r.Header.Set("Abc-Updater-Version", tc.clientVersion)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if got, want := w.Code, http.StatusOK; got != want {
+				t.Errorf("unexpected status. got %d want %d", got, want)
+			}
+			if got := w.Header().Get("Abc-Updater-Min-Version"); got != tc.wantMin {
+				t.Errorf("unexpected min version header. got %q want %q", got, tc.wantMin)
+			}
+			if got := w.Header().Get("Sunset"); got != tc.wantSunset {
+				t.Errorf("unexpected sunset header. got %q want %q", got, tc.wantSunset)
+			}
+		})
+	}
+}
+
+func TestRequireMinClientVersion_Invalid(t *testing.T) {
+	t.Parallel()
+
+	if _, err := RequireMinClientVersion("latest", time.Time{}, http.NotFoundHandler()); err == nil {
+		t.Errorf("expected error for invalid minimum version")
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+)
+
+const (
+	// corsMaxAgeSeconds is how long browsers may cache a preflight response.
+	corsMaxAgeSeconds = 600
+
+	// corsAllowedMethods are the methods browsers may use cross-origin.
+	corsAllowedMethods = "GET, POST"
+)
+
+// corsAllowedHeaders are the request headers browsers may send cross-origin,
+// in canonical form.
+var corsAllowedHeaders = []string{"Content-Type", "Content-Encoding", "Accept"}
+
+// corsExposedHeaders are the response headers readable by browser scripts.
+var corsExposedHeaders = strings.Join([]string{api.HeaderMinClientVersion, api.HeaderSunset, "Retry-After"}, ", ")
+
+// CORSHandler wraps next so browsers on allowedOrigins can call it, e.g. from
+// web-based tools or docs sites. An origin of "*" allows any origin.
+// Preflight OPTIONS requests are answered directly, so next must also be
+// registered for OPTIONS. If allowedOrigins is empty, next is returned
+// unchanged.
+func CORSHandler(allowedOrigins []string, next http.Handler) http.Handler {
+	if len(allowedOrigins) == 0 {
+		return next
+	}
+	anyOrigin := slices.Contains(allowedOrigins, "*")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		if !anyOrigin && !slices.Contains(allowedOrigins, origin) {
+			if preflight {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			// Serve the request without CORS headers, so the browser hides the
+			// response from the page.
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if anyOrigin {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		if !preflight {
+			w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		for _, h := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+			h = strings.TrimSpace(h)
+			if h != "" && !slices.Contains(corsAllowedHeaders, http.CanonicalHeaderKey(h)) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+		}
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+		w.Header().Set("Access-Control-Allow-Methods", corsAllowedMethods)
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(corsAllowedHeaders, ", "))
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(corsMaxAgeSeconds))
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
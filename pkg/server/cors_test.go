@@ -0,0 +1,133 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORSHandler(t *testing.T) {
+	t.Parallel()
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	cases := []struct {
+		name             string
+		origins          []string
+		method           string
+		headers          map[string]string
+		wantStatus       int
+		wantOrigin       string
+		wantAllowMethods string
+	}{
+		{
+			name:       "no_origin",
+			origins:    []string{"https://docs.example.com"},
+			method:     http.MethodPost,
+			wantStatus: http.StatusAccepted,
+		},
+		{
+			name:       "allowed_origin",
+			origins:    []string{"https://docs.example.com"},
+			method:     http.MethodPost,
+			headers:    map[string]string{"Origin": "https://docs.example.com"},
+			wantStatus: http.StatusAccepted,
+			wantOrigin: "https://docs.example.com",
+		},
+		{
+			name:       "any_origin",
+			origins:    []string{"*"},
+			method:     http.MethodPost,
+			headers:    map[string]string{"Origin": "https://other.example.com"},
+			wantStatus: http.StatusAccepted,
+			wantOrigin: "*",
+		},
+		{
+			name:       "other_origin",
+			origins:    []string{"https://docs.example.com"},
+			method:     http.MethodPost,
+			headers:    map[string]string{"Origin": "https://evil.example.com"},
+			wantStatus: http.StatusAccepted,
+		},
+		{
+			name:    "preflight",
+			origins: []string{"https://docs.example.com"},
+			method:  http.MethodOptions,
+			headers: map[string]string{
+				"Origin":                         "https://docs.example.com",
+				"Access-Control-Request-Method":  "POST",
+				"Access-Control-Request-Headers": "content-type, content-encoding",
+			},
+			wantStatus:       http.StatusNoContent,
+			wantOrigin:       "https://docs.example.com",
+			wantAllowMethods: corsAllowedMethods,
+		},
+		{
+			name:    "preflight_other_origin",
+			origins: []string{"https://docs.example.com"},
+			method:  http.MethodOptions,
+			headers: map[string]string{
+				"Origin":                        "https://evil.example.com",
+				"Access-Control-Request-Method": "POST",
+			},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:    "preflight_disallowed_header",
+			origins: []string{"https://docs.example.com"},
+			method:  http.MethodOptions,
+			headers: map[string]string{
+				"Origin":                         "https://docs.example.com",
+				"Access-Control-Request-Method":  "POST",
+				"Access-Control-Request-Headers": "authorization",
+			},
+			wantStatus: http.StatusForbidden,
+			wantOrigin: "https://docs.example.com",
+		},
+		{
+			name:       "disabled",
+			method:     http.MethodPost,
+			headers:    map[string]string{"Origin": "https://docs.example.com"},
+			wantStatus: http.StatusAccepted,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(tc.method, "/sendMetrics", nil)
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			CORSHandler(tc.origins, ok).ServeHTTP(w, req)
+
+			if got, want := w.Code, tc.wantStatus; got != want {
+				t.Errorf("unexpected response code. got %d want %d", got, want)
+			}
+			if got, want := w.Header().Get("Access-Control-Allow-Origin"), tc.wantOrigin; got != want {
+				t.Errorf("unexpected allowed origin. got %q want %q", got, want)
+			}
+			if got, want := w.Header().Get("Access-Control-Allow-Methods"), tc.wantAllowMethods; got != want {
+				t.Errorf("unexpected allowed methods. got %q want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"cmp"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/abcxyz/pkg/logging"
+	"github.com/abcxyz/pkg/renderer"
+)
+
+const (
+	// DashboardTemplate is the template HandleDashboard renders.
+	DashboardTemplate = "index.html"
+
+	// dashboardWindow is the number of days of stats shown on the dashboard.
+	dashboardWindow = 7
+)
+
+// Dashboard is the data rendered by HandleDashboard. A nil *Dashboard renders
+// the homepage without any stats.
+type Dashboard struct {
+	// WindowDays is the number of days the volumes cover, ending today.
+	WindowDays int
+	Refresh    *RefreshStatus
+	Apps       []*DashboardApp
+}
+
+// DashboardApp summarizes a single app on the dashboard.
+type DashboardApp struct {
+	AppID          string
+	CurrentVersion string
+	// Volume is the total count of all metrics.
+	Volume int64
+	// Versions are the app versions which sent metrics, by descending volume.
+	Versions []*DashboardVersion
+}
+
+// DashboardVersion is the metric volume from a single app version.
+type DashboardVersion struct {
+	Version string
+	Volume  int64
+}
+
+// HandleDashboard returns a handler which renders DashboardTemplate with a
+// summary of each loaded app's metric volume and active versions over the last
+// week, and the status of the last metadata refresh. If stats is nil, the
+// template is rendered without a Dashboard, so the homepage can be served
+// without exposing stats.
+func HandleDashboard(h *renderer.Renderer, db AppLister, r *Refresher, stats StatsStore) http.Handler {
+	return handleDashboard(h, db, r, stats, time.Now)
+}
+
+// handleDashboard implements HandleDashboard with a configurable clock.
+func handleDashboard(h *renderer.Renderer, db AppLister, r *Refresher, stats StatsStore, now func() time.Time) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if stats == nil {
+			h.RenderHTML(w, DashboardTemplate, nil)
+			return
+		}
+
+		days := statsDays(now(), dashboardWindow)
+		d := &Dashboard{WindowDays: dashboardWindow, Refresh: r.Status()}
+		for _, id := range db.ListApps() {
+			info := appInfo(db, id)
+			if info == nil {
+				continue
+			}
+			app := &DashboardApp{AppID: id, CurrentVersion: info.CurrentVersion}
+			appStats, err := stats.LoadStats(req.Context(), id, days)
+			if err != nil {
+				// Still show the app, so one failure doesn't hide the page.
+				logging.FromContext(req.Context()).WarnContext(req.Context(), "failed to load stats for dashboard",
+					"app_id", id,
+					"error", err.Error())
+			}
+			volumes := make(map[string]int64)
+			for _, day := range appStats {
+				for version, names := range day {
+					for _, count := range names {
+						volumes[version] += count
+						app.Volume += count
+					}
+				}
+			}
+			for version, volume := range volumes {
+				app.Versions = append(app.Versions, &DashboardVersion{Version: version, Volume: volume})
+			}
+			slices.SortFunc(app.Versions, func(a, b *DashboardVersion) int {
+				if c := cmp.Compare(b.Volume, a.Volume); c != 0 {
+					return c
+				}
+				return cmp.Compare(a.Version, b.Version)
+			})
+			d.Apps = append(d.Apps, app)
+		}
+		h.RenderHTML(w, DashboardTemplate, d)
+	})
+}
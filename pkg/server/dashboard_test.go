@@ -0,0 +1,96 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abcxyz/pkg/renderer"
+)
+
+func TestHandleDashboard(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	// Render the real homepage, so template errors are caught.
+	h := renderer.NewTesting(ctx, t, os.DirFS("../../static"))
+	now := time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)
+	db := testAdminDB(t)
+	r := NewRefresher(db, &MetricsLoadParams{}, time.Minute)
+
+	stats := NewMemoryStats()
+	for _, m := range []*MetricRecord{
+		{AppID: "foo", AppVersion: "1.2.3", Name: "run", Count: 5},
+		{AppID: "foo", AppVersion: "1.2.2", Name: "run", Count: 2},
+	} {
+		if err := stats.IncrementStats(ctx, "2024-01-30", m); err != nil {
+			t.Fatalf("failed to setup test: %s", err.Error())
+		}
+	}
+
+	cases := []struct {
+		name        string
+		stats       StatsStore
+		wantContain []string
+		wantOmit    []string
+	}{
+		{
+			name:  "dashboard",
+			stats: stats,
+			wantContain: []string{
+				"Last refresh: never",
+				"<td>foo</td>",
+				"<td>bar</td>",
+				"1.2.3 (5), 1.2.2 (2)",
+				`<td class="num">7</td>`,
+			},
+		},
+		{
+			name:        "disabled",
+			wantContain: []string{"ABC Updater Metrics Server"},
+			wantOmit:    []string{"<table>", "foo"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			w := httptest.NewRecorder()
+			handleDashboard(h, db, r, tc.stats, func() time.Time { return now }).
+				ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+			if got, want := w.Code, http.StatusOK; got != want {
+				t.Errorf("unexpected response code. got %d want %d", got, want)
+			}
+			body := w.Body.String()
+			for _, s := range tc.wantContain {
+				if !strings.Contains(body, s) {
+					t.Errorf("expected body to contain %q, got:\n%s", s, body)
+				}
+			}
+			for _, s := range tc.wantOmit {
+				if strings.Contains(body, s) {
+					t.Errorf("expected body not to contain %q, got:\n%s", s, body)
+				}
+			}
+		})
+	}
+}
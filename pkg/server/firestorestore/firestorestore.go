@@ -0,0 +1,121 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package firestorestore implements a server.DefinitionStore backed by
+// Firestore.
+package firestorestore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/abcxyz/abc-updater/pkg/server"
+)
+
+// Assert Store satisfies server.DefinitionStore.
+var _ server.DefinitionStore = (*Store)(nil)
+
+// document is the shape of the stored Firestore document. Definitions are
+// stored as a JSON string so the wire format is preserved exactly.
+type document struct {
+	Definitions string `firestore:"definitions"`
+}
+
+// Store stores all definitions in a single Firestore document, so saves are
+// atomic and every replica reads a consistent set.
+type Store struct {
+	client *firestore.Client
+	doc    *firestore.DocumentRef
+}
+
+// New creates a Store which keeps definitions in the document at path, e.g.
+// "abc-updater/definitions", using client.
+func New(client *firestore.Client, path string) (*Store, error) {
+	doc := client.Doc(path)
+	if doc == nil {
+		return nil, fmt.Errorf("invalid document path %q", path)
+	}
+	return &Store{client: client, doc: doc}, nil
+}
+
+// NewFromURL creates a Store from a URL of the form
+// "firestore://<project>/<collection>/<document>". Close the returned Store
+// when done.
+func NewFromURL(ctx context.Context, url string) (*Store, error) {
+	rest, ok := strings.CutPrefix(url, "firestore://")
+	if !ok {
+		return nil, fmt.Errorf("firestore url %q must start with firestore://", url)
+	}
+	project, path, ok := strings.Cut(rest, "/")
+	if !ok || project == "" {
+		return nil, fmt.Errorf("firestore url %q must be of the form firestore://<project>/<collection>/<document>", url)
+	}
+	client, err := firestore.NewClient(ctx, project)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create firestore client: %w", err)
+	}
+	s, err := New(client, path)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// LoadDefinitions returns all stored definitions, or an empty map if none have
+// been saved.
+func (s *Store) LoadDefinitions(ctx context.Context) (map[string]*server.AllowedMetricsResponse, error) {
+	snap, err := s.doc.Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return map[string]*server.AllowedMetricsResponse{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get definitions from firestore: %w", err)
+	}
+	var d document
+	if err := snap.DataTo(&d); err != nil {
+		return nil, fmt.Errorf("failed to read definitions document: %w", err)
+	}
+	var defs map[string]*server.AllowedMetricsResponse
+	if err := json.Unmarshal([]byte(d.Definitions), &defs); err != nil {
+		return nil, fmt.Errorf("failed to decode stored definitions: %w", err)
+	}
+	return defs, nil
+}
+
+// SaveDefinitions replaces all stored definitions.
+func (s *Store) SaveDefinitions(ctx context.Context, defs map[string]*server.AllowedMetricsResponse) error {
+	b, err := json.Marshal(defs)
+	if err != nil {
+		return fmt.Errorf("failed to encode definitions: %w", err)
+	}
+	if _, err := s.doc.Set(ctx, &document{Definitions: string(b)}); err != nil {
+		return fmt.Errorf("failed to set definitions in firestore: %w", err)
+	}
+	return nil
+}
+
+// Close closes the underlying client.
+func (s *Store) Close() error {
+	if err := s.client.Close(); err != nil {
+		return fmt.Errorf("failed to close firestore client: %w", err)
+	}
+	return nil
+}
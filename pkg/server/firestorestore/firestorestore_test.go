@@ -0,0 +1,93 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firestorestore
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/abcxyz/abc-updater/pkg/server"
+	"github.com/abcxyz/pkg/testutil"
+)
+
+func TestNewFromURL_Invalid(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		url     string
+		wantErr string
+	}{
+		{
+			name:    "wrong_scheme",
+			url:     "redis://localhost:6379",
+			wantErr: "must start with firestore://",
+		},
+		{
+			name:    "no_path",
+			url:     "firestore://my-project",
+			wantErr: "must be of the form",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := NewFromURL(context.Background(), tc.url)
+			if diff := testutil.DiffErrString(err, tc.wantErr); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+// TestStore runs against the Firestore emulator, if FIRESTORE_EMULATOR_HOST is
+// set.
+func TestStore(t *testing.T) {
+	t.Parallel()
+
+	if os.Getenv("FIRESTORE_EMULATOR_HOST") == "" {
+		t.Skip("FIRESTORE_EMULATOR_HOST is not set")
+	}
+
+	ctx := context.Background()
+	s, err := NewFromURL(ctx, "firestore://test-project/abc-updater/"+t.Name())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	t.Cleanup(func() {
+		if err := s.Close(); err != nil {
+			t.Errorf("failed to close store: %s", err.Error())
+		}
+	})
+
+	want := map[string]*server.AllowedMetricsResponse{
+		"foo": {Metrics: []string{"run", "command.*"}},
+	}
+	if err := s.SaveDefinitions(ctx, want); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	got, err := s.LoadDefinitions(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("unexpected definitions (-got,+want): %s", diff)
+	}
+}
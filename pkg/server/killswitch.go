@@ -0,0 +1,75 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"github.com/hashicorp/go-version"
+)
+
+// killedVersions parses the kill switches in data. Invalid constraints are
+// skipped; they are reported by validateAppData.
+func killedVersions(data *AppData) []version.Constraints {
+	if data == nil || data.Data == nil {
+		return nil
+	}
+	var out []version.Constraints
+	for _, k := range data.Data.KillSwitches {
+		if k == nil {
+			continue
+		}
+		c, err := version.NewConstraint(k.Versions)
+		if err != nil {
+			continue
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// withKillSwitches returns defs with the kill switches in each app's version
+// data. AppMetrics are copied rather than changed, since handlers may be
+// reading them.
+func withKillSwitches(defs map[string]*AppMetrics, data map[string]*AppData) map[string]*AppMetrics {
+	out := make(map[string]*AppMetrics, len(defs))
+	for app, m := range defs {
+		killed := killedVersions(data[app])
+		if m == nil || (len(killed) == 0 && len(m.KilledVersions) == 0) {
+			out[app] = m
+			continue
+		}
+		withKilled := *m
+		withKilled.KilledVersions = killed
+		out[app] = &withKilled
+	}
+	return out
+}
+
+// VersionKilled returns true if a kill switch in the app's version data
+// matches app version v. Invalid versions are never killed.
+func (m *AppMetrics) VersionKilled(v string) bool {
+	if m == nil || len(m.KilledVersions) == 0 {
+		return false
+	}
+	parsed, err := version.NewVersion(v)
+	if err != nil {
+		return false
+	}
+	for _, c := range m.KilledVersions {
+		if c.Check(parsed) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,101 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/abcxyz/abc-updater/pkg/apierror"
+	"github.com/abcxyz/abc-updater/pkg/metrics"
+	"github.com/abcxyz/pkg/renderer"
+)
+
+func TestMetricsDB_KillSwitches(t *testing.T) {
+	t.Parallel()
+
+	var killSwitches atomic.Value
+	killSwitches.Store(`[{"versions":"= 1.3.0","message":"1.3.0 double counts builds."},{"versions":"newest"}]`)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/manifest.json":
+			fmt.Fprint(w, `{"metricsApps":["foo"]}`)
+		case "/foo/metrics.json":
+			fmt.Fprint(w, `{"metrics":["metric1"]}`)
+		case "/foo/data.json":
+			fmt.Fprintf(w, `{"appId":"foo","currentVersion":"1.3.1","killSwitches":%s}`, killSwitches.Load())
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	ctx := context.Background()
+	h, err := renderer.New(ctx, nil)
+	if err != nil {
+		t.Fatalf("failed to setup test: %s", err.Error())
+	}
+	db := &MetricsDB{}
+	params := &MetricsLoadParams{ServerURL: ts.URL, Client: http.DefaultClient}
+	if err := db.Update(ctx, params); err != nil {
+		t.Fatalf("unexpected error updating db: %s", err.Error())
+	}
+
+	send := func(appVersion string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/sendMetrics", marshalRequest(t, &metrics.SendMetricRequest{
+			AppID:      "foo",
+			AppVersion: appVersion,
+			Metrics:    map[string]int64{"metric1": 1},
+			InstallID:  "asdf",
+		}))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		HandleMetricWithSink(h, db, &testSink{}).ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("1.3.0")
+	if got, want := w.Code, http.StatusGone; got != want {
+		t.Errorf("unexpected response code for killed version. got %d want %d", got, want)
+	}
+	if got, want := w.Body.String(), string(apierror.CodeVersionDisabled); !strings.Contains(got, want) {
+		t.Errorf("expected response %q to contain %q", got, want)
+	}
+	if got, want := send("1.3.1").Code, http.StatusAccepted; got != want {
+		t.Errorf("unexpected response code for other version. got %d want %d", got, want)
+	}
+
+	var problems []string
+	for _, p := range db.problems {
+		problems = append(problems, p.Message)
+	}
+	if want := `killSwitches[1] has invalid versions "newest"`; !strings.Contains(strings.Join(problems, "\n"), want) {
+		t.Errorf("expected problems %q to contain %q", problems, want)
+	}
+
+	// Removing the kill switch lets the version's metrics through again.
+	killSwitches.Store(`[]`)
+	if err := db.Update(ctx, params); err != nil {
+		t.Fatalf("unexpected error updating db: %s", err.Error())
+	}
+	if got, want := send("1.3.0").Code, http.StatusAccepted; got != want {
+		t.Errorf("unexpected response code after removing kill switch. got %d want %d", got, want)
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/abcxyz/abc-updater/pkg/apierror"
+)
+
+// shedRetryAfterSeconds is the Retry-After sent with shed responses.
+const shedRetryAfterSeconds = 1
+
+// shedBody is the precomputed response body for shed requests, so the shed
+// path does not allocate.
+var shedBody = func() []byte {
+	b, err := json.Marshal(apierror.New(apierror.CodeOverloaded, "server is overloaded, try again later"))
+	if err != nil {
+		panic(err)
+	}
+	return b
+}()
+
+// LoadShedder limits the number of concurrent in-flight requests. Requests
+// over the limit are rejected immediately with a 503 rather than queued, so
+// a traffic spike degrades gracefully instead of exhausting memory.
+type LoadShedder struct {
+	// slots has one entry per in-flight request. Nil if there is no limit.
+	slots chan struct{}
+	shed  atomic.Int64
+}
+
+// LoadStatus describes the state of a LoadShedder.
+type LoadStatus struct {
+	MaxInFlight int   `json:"maxInFlight"`
+	InFlight    int   `json:"inFlight"`
+	Shed        int64 `json:"shed"`
+}
+
+// NewLoadShedder creates a LoadShedder allowing at most maxInFlight
+// concurrent requests. If maxInFlight is zero or less there is no limit.
+func NewLoadShedder(maxInFlight int) *LoadShedder {
+	l := &LoadShedder{}
+	if maxInFlight > 0 {
+		l.slots = make(chan struct{}, maxInFlight)
+	}
+	return l
+}
+
+// Wrap returns next wrapped so it is only served while under the limit.
+func (l *LoadShedder) Wrap(next http.Handler) http.Handler {
+	if l.slots == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			l.shed.Add(1)
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Retry-After", strconv.Itoa(shedRetryAfterSeconds))
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write(shedBody) //nolint:errcheck // Nothing to do if the client went away.
+			return
+		}
+		defer func() { <-l.slots }()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Status returns the current in-flight and total shed request counts.
+func (l *LoadShedder) Status() *LoadStatus {
+	return &LoadStatus{
+		MaxInFlight: cap(l.slots),
+		InFlight:    len(l.slots),
+		Shed:        l.shed.Load(),
+	}
+}
+
+// HandleLoadStatus returns a handler which renders the LoadShedder's status.
+func HandleLoadStatus(h JSONRenderer, l *LoadShedder) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.RenderJSON(w, http.StatusOK, l.Status())
+	})
+}
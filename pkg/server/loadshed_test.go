@@ -0,0 +1,92 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/abcxyz/abc-updater/pkg/apierror"
+)
+
+func TestLoadShedder(t *testing.T) {
+	t.Parallel()
+
+	l := NewLoadShedder(1)
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	blocking := l.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+		w.WriteHeader(http.StatusAccepted)
+	}))
+
+	// Occupy the only slot.
+	done := make(chan int)
+	go func() {
+		w := httptest.NewRecorder()
+		blocking.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/sendMetrics", nil))
+		done <- w.Code
+	}()
+	<-entered
+
+	w := httptest.NewRecorder()
+	blocking.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/sendMetrics", nil))
+	if got, want := w.Code, http.StatusServiceUnavailable; got != want {
+		t.Errorf("unexpected status for shed request. got %d want %d", got, want)
+	}
+	if got, want := w.Header().Get("Retry-After"), "1"; got != want {
+		t.Errorf("unexpected Retry-After. got %q want %q", got, want)
+	}
+	var body apierror.Response
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("failed to decode shed body: %s", err.Error())
+	}
+	if got, want := body.Code, apierror.CodeOverloaded; got != want {
+		t.Errorf("unexpected error code. got %q want %q", got, want)
+	}
+
+	if diff := cmp.Diff(l.Status(), &LoadStatus{MaxInFlight: 1, InFlight: 1, Shed: 1}); diff != "" {
+		t.Errorf("unexpected status while full (-got,+want): %s", diff)
+	}
+
+	close(release)
+	if got, want := <-done, http.StatusAccepted; got != want {
+		t.Errorf("unexpected status for admitted request. got %d want %d", got, want)
+	}
+	if diff := cmp.Diff(l.Status(), &LoadStatus{MaxInFlight: 1, InFlight: 0, Shed: 1}); diff != "" {
+		t.Errorf("unexpected status after release (-got,+want): %s", diff)
+	}
+}
+
+func TestLoadShedder_Unlimited(t *testing.T) {
+	t.Parallel()
+
+	l := NewLoadShedder(0)
+	w := httptest.NewRecorder()
+	l.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/sendMetrics", nil))
+	if got, want := w.Code, http.StatusAccepted; got != want {
+		t.Errorf("unexpected status. got %d want %d", got, want)
+	}
+	if diff := cmp.Diff(l.Status(), &LoadStatus{}); diff != "" {
+		t.Errorf("unexpected status (-got,+want): %s", diff)
+	}
+}
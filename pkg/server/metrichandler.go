@@ -15,52 +15,190 @@
 package server
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
 	"net/http"
+	"slices"
+	"time"
 
-	"github.com/abcxyz/abc-updater/pkg/metrics"
+	"github.com/abcxyz/abc-updater/pkg/api"
+	"github.com/abcxyz/abc-updater/pkg/apierror"
 	"github.com/abcxyz/pkg/logging"
-	"github.com/abcxyz/pkg/renderer"
 )
 
 // HandleMetric returns a http.Handler for processing POST requests for sending
-// metrics.
-func HandleMetric(h *renderer.Renderer, db MetricsLookuper) http.Handler {
+// metrics. Accepted metrics are written to a LogSink.
+func HandleMetric(h JSONRenderer, db MetricsLookuper) http.Handler {
+	return HandleMetricWithSink(h, db, &LogSink{})
+}
+
+// HandleMetricWithSink is like HandleMetric, but writes accepted metrics to
+// sink.
+func HandleMetricWithSink(h JSONRenderer, db MetricsLookuper, sink MetricSink) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		logger := logging.FromContext(r.Context())
-		metricLogger := logger.WithGroup("metric")
 		logger.InfoContext(r.Context(), "handling request")
 
-		metrics, err := DecodeRequest[metrics.SendMetricRequest](r.Context(), w, r, h)
+		req, err := decodeMetricRequest(w, r, h)
 		if err != nil {
-			// Error response already handled by pkg.DecodeRequest.
+			// Error response already handled by decodeMetricRequest.
 			return
 		}
-
-		allowedMetrics, err := db.GetAllowedMetrics(metrics.AppID)
-		if err != nil {
-			h.RenderJSON(w, http.StatusNotFound, err)
-			logger.WarnContext(r.Context(), "received metric request for unknown app")
+		resp, status, apiErr := recordMetrics(r.Context(), db, sink, req)
+		if apiErr != nil {
+			h.RenderJSON(w, status, apiErr)
 			return
 		}
+		h.RenderJSON(w, status, resp)
+	})
+}
 
-		// Currently we only expose an API for a single metric on the client,
-		// but I suspect multiple metrics will be added later on, and effort is
-		// about the same to support both.
-		for name, count := range metrics.Metrics {
-			if allowedMetrics.MetricAllowed(name) {
-				metricLogger.InfoContext(r.Context(), "metric received",
-					"app_id", metrics.AppID,
-					"app_version", metrics.AppVersion,
-					"install_id", metrics.InstallID,
-					"name", name,
-					"count", count)
-			} else {
-				// TODO: do we want to return a warning to client or fail silently?
-				logger.WarnContext(r.Context(), "received unknown metric for app", "app_id", metrics.AppID)
+// recordMetrics validates req and writes its allowed metrics to sink,
+// returning the response and its status. If req is rejected, the status and
+// error to respond with are returned instead.
+func recordMetrics(ctx context.Context, db MetricsLookuper, sink MetricSink, req *metricRequest) (*api.SendMetricResponse, int, *apierror.Response) {
+	logger := logging.FromContext(ctx)
+	receivedAt := time.Now().UTC()
+	metrics := req.normalize()
+	if apiErr := validateMetricRequest(metrics); apiErr != nil {
+		logger.WarnContext(ctx, "rejected invalid metric request", "code", apiErr.Code)
+		return nil, http.StatusBadRequest, apiErr
+	}
+	occurredAt, apiErr := eventTime(metrics, receivedAt)
+	if apiErr != nil {
+		logger.WarnContext(ctx, "rejected invalid metric request", "code", apiErr.Code)
+		return nil, http.StatusBadRequest, apiErr
+	}
+
+	allowedMetrics, err := db.GetAllowedMetrics(metrics.AppID)
+	if err != nil {
+		logger.WarnContext(ctx, "received metric request for unknown app", "cause", err.Error())
+		return nil, http.StatusNotFound, apierror.New(apierror.CodeUnknownApp, "unknown app %q", metrics.AppID)
+	}
+	if allowedMetrics.Retired.Dropped(time.Now()) {
+		logger.DebugContext(ctx, "received metric request for retired app", "app_id", metrics.AppID)
+		return nil, http.StatusGone, apierror.New(apierror.CodeAppRetired, "app %q is retired", metrics.AppID)
+	}
+	if allowedMetrics.VersionKilled(metrics.AppVersion) {
+		logger.DebugContext(ctx, "received metric request from disabled version",
+			"app_id", metrics.AppID,
+			"app_version", metrics.AppVersion)
+		return nil, http.StatusGone, apierror.New(apierror.CodeVersionDisabled, "metrics from %s version %q are disabled, upgrade to a newer version", metrics.AppID, metrics.AppVersion)
+	}
+	if allRetired(allowedMetrics, metrics.Metrics, receivedAt) {
+		logger.DebugContext(ctx, "received metric request with only retired metrics", "app_id", metrics.AppID)
+		return nil, http.StatusGone, apierror.New(apierror.CodeMetricsRetired, "all metrics in the request are retired")
+	}
+	if metrics.Sequence > 0 && metrics.InstallID != "" {
+		sequences.observe(metrics.AppID, metrics.InstallID, metrics.Sequence)
+	}
+	if metrics.Dropped > 0 {
+		logger.WarnContext(ctx, "client dropped metrics over budget",
+			"app_id", metrics.AppID,
+			"dropped", metrics.Dropped)
+	}
+
+	// Currently we only expose an API for a single metric on the client,
+	// but I suspect multiple metrics will be added later on, and effort is
+	// about the same to support both.
+	var metricWarnings []string
+	dispositions := make(map[string]api.MetricDisposition, len(metrics.Metrics))
+	for name, count := range metrics.Metrics {
+		retired := allowedMetrics.RetiredMetrics[name]
+		if retired.Dropped(receivedAt) {
+			dispositions[name] = api.DispositionRetired
+			metricWarnings = append(metricWarnings, fmt.Sprintf("metric %q is retired", name))
+			continue
+		}
+		if retired != nil {
+			metricWarnings = append(metricWarnings, retiredMetricWarning(name, retired))
+		}
+		if retired != nil || allowedMetrics.MetricAllowed(name) {
+			dispositions[name] = api.DispositionAccepted
+			if !sampled(allowedMetrics.SampleRate) {
+				continue
+			}
+			if err := sink.WriteMetric(ctx, &MetricRecord{
+				AppID:          metrics.AppID,
+				Owner:          allowedMetrics.Owner,
+				Retired:        allowedMetrics.Retired != nil,
+				AppVersion:     metrics.AppVersion,
+				InstallID:      metrics.InstallID,
+				InstallCohort:  metrics.InstallCohort,
+				UpgradedFrom:   metrics.UpgradedFrom,
+				DowngradedFrom: metrics.DowngradedFrom,
+				Name:           name,
+				Count:          count,
+				Sink:           allowedMetrics.Sink,
+				SampleRate:     allowedMetrics.SampleRate,
+				Level:          allowedMetrics.Level,
+				BuildInfo:      allowedBuildInfo(allowedMetrics, metrics.BuildInfo),
+				Sequence:       metrics.Sequence,
+				ReceivedAt:     receivedAt,
+				EventTime:      occurredAt,
+			}); errors.Is(err, ErrSensitiveData) {
+				dispositions[name] = api.DispositionSensitiveData
+				metricWarnings = append(metricWarnings, fmt.Sprintf("metric %q rejected, it contains sensitive data", name))
+			} else if err != nil {
+				logger.WarnContext(ctx, "failed to write metric", "app_id", metrics.AppID, "error", err.Error())
 			}
+		} else {
+			dispositions[name] = api.DispositionUnknownMetric
+			metricWarnings = append(metricWarnings, fmt.Sprintf("metric %q not allowed", name))
+			logger.WarnContext(ctx, "received unknown metric for app", "app_id", metrics.AppID)
 		}
+	}
+	// Map iteration order is random, keep responses stable.
+	slices.Sort(metricWarnings)
+	warnings := append(req.deprecationWarnings(), metricWarnings...)
+	if ret := allowedMetrics.Retired; ret != nil {
+		warnings = append(warnings, fmt.Sprintf("app %q is retired, metrics will be rejected after %s",
+			metrics.AppID, ret.DropAfter.UTC().Format(time.RFC3339)))
+	}
 
-		// Client does not currently read body, future changes are acceptable.
-		h.RenderJSON(w, http.StatusAccepted, map[string]string{"message": "ok"})
-	})
+	resp := &api.SendMetricResponse{Message: "ok", Warnings: warnings}
+	if metrics.IncludeDispositions {
+		resp.Dispositions = dispositions
+	}
+	return resp, http.StatusAccepted, nil
+}
+
+// allRetired returns true if every metric in sent has been dropped by app's
+// retired metrics at now.
+func allRetired(app *AppMetrics, sent map[string]int64, now time.Time) bool {
+	if len(app.RetiredMetrics) == 0 || len(sent) == 0 {
+		return false
+	}
+	for name := range sent {
+		if !app.RetiredMetrics[name].Dropped(now) {
+			return false
+		}
+	}
+	return true
+}
+
+// retiredMetricWarning returns the warning for a metric which is retired but
+// still accepted.
+func retiredMetricWarning(name string, r *api.Retirement) string {
+	w := fmt.Sprintf("metric %q is retired, it will be rejected after %s", name, r.DropAfter.UTC().Format(time.RFC3339))
+	if r.Message != "" {
+		w += ": " + r.Message
+	}
+	return w
+}
+
+// allowedBuildInfo returns b if app allows build info to be recorded.
+func allowedBuildInfo(app *AppMetrics, b *api.BuildInfo) *api.BuildInfo {
+	if !app.AllowBuildInfo {
+		return nil
+	}
+	return b
+}
+
+// sampled reports whether a metric should be logged given an app's sample
+// rate. A rate of zero means all metrics are logged.
+func sampled(rate float64) bool {
+	return rate <= 0 || rand.Float64() < rate //nolint:gosec // Sampling does not need a secure source.
 }
@@ -16,6 +16,7 @@ package server
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -23,11 +24,18 @@ import (
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/google/go-cmp/cmp"
 	"github.com/thejerf/slogassert"
+	"google.golang.org/protobuf/proto"
 
+	"github.com/abcxyz/abc-updater/pkg/api"
+	"github.com/abcxyz/abc-updater/pkg/api/apipb"
+	"github.com/abcxyz/abc-updater/pkg/apierror"
 	"github.com/abcxyz/abc-updater/pkg/metrics"
 	"github.com/abcxyz/pkg/logging"
 	"github.com/abcxyz/pkg/renderer"
@@ -65,14 +73,41 @@ func marshalRequest(tb testing.TB, req *metrics.SendMetricRequest) io.Reader {
 	return bytes.NewReader(b)
 }
 
+func marshalProtoRequest(tb testing.TB, req *metrics.SendMetricRequest) io.Reader {
+	tb.Helper()
+	b, err := proto.Marshal(apipb.FromSendMetricRequest(req))
+	if err != nil {
+		tb.Fatalf("could not marshal protobuf: %s", err.Error())
+	}
+	return bytes.NewReader(b)
+}
+
+func gzipReader(tb testing.TB, r io.Reader) io.Reader {
+	tb.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := io.Copy(gz, r); err != nil {
+		tb.Fatalf("could not compress body: %s", err.Error())
+	}
+	if err := gz.Close(); err != nil {
+		tb.Fatalf("could not compress body: %s", err.Error())
+	}
+	return &buf
+}
+
 func TestHandleMetric(t *testing.T) {
 	t.Parallel()
 	cases := []struct {
-		name       string
-		db         MetricsLookuper
-		body       io.Reader
-		wantStatus int
-		wantLogs   map[*slogassert.LogMessageMatch]int
+		name             string
+		db               MetricsLookuper
+		body             io.Reader
+		contentType      string
+		contentEncoding  string
+		wantStatus       int
+		wantCode         apierror.Code
+		wantWarnings     []string
+		wantDispositions map[string]api.MetricDisposition
+		wantLogs         map[*slogassert.LogMessageMatch]int
 	}{
 		{
 			name: "happy_single_metric",
@@ -103,6 +138,46 @@ func TestHandleMetric(t *testing.T) {
 				AllAttrsMatch: false,
 			}: 1},
 		},
+		{
+			name: "app_logging_level_and_sink",
+			db: &testMetricsDB{apps: map[string]*AppMetrics{"test": {
+				AppID:   "test",
+				Allowed: map[string]interface{}{"foo": struct{}{}},
+				Level:   slog.LevelWarn,
+				Sink:    "high_volume",
+			}}},
+			body: marshalRequest(t, &metrics.SendMetricRequest{
+				AppID:      "test",
+				AppVersion: "1.0",
+				Metrics:    map[string]int64{"foo": 1},
+				InstallID:  "asdf",
+			}),
+			wantStatus: 202,
+			wantLogs: map[*slogassert.LogMessageMatch]int{{
+				Message: "metric received",
+				Level:   slog.LevelWarn,
+				Attrs: map[string]any{
+					"metric.name": "foo",
+					"metric.sink": "high_volume",
+				},
+				AllAttrsMatch: false,
+			}: 1},
+		},
+		{
+			name: "app_sampled_out",
+			db: &testMetricsDB{apps: map[string]*AppMetrics{"test": {
+				AppID:      "test",
+				Allowed:    map[string]interface{}{"foo": struct{}{}},
+				SampleRate: 1e-12,
+			}}},
+			body: marshalRequest(t, &metrics.SendMetricRequest{
+				AppID:      "test",
+				AppVersion: "1.0",
+				Metrics:    map[string]int64{"foo": 1},
+				InstallID:  "asdf",
+			}),
+			wantStatus: 202,
+		},
 		{
 			name: "happy_multi_metric",
 			db: &testMetricsDB{apps: map[string]*AppMetrics{"test": {
@@ -167,7 +242,8 @@ func TestHandleMetric(t *testing.T) {
 				},
 				InstallID: "asdf",
 			}),
-			wantStatus: 202,
+			wantStatus:   202,
+			wantWarnings: []string{`metric "unknown" not allowed`},
 			wantLogs: map[*slogassert.LogMessageMatch]int{{
 				Message: "metric received",
 				Level:   slog.LevelInfo,
@@ -181,6 +257,144 @@ func TestHandleMetric(t *testing.T) {
 				AllAttrsMatch: false,
 			}: 1},
 		},
+		{
+			name: "happy_dispositions",
+			db: &testMetricsDB{apps: map[string]*AppMetrics{"test": {
+				AppID:   "test",
+				Allowed: map[string]interface{}{"foo": struct{}{}},
+			}}},
+			body: marshalRequest(t, &metrics.SendMetricRequest{
+				AppID:      "test",
+				AppVersion: "1.0",
+				Metrics: map[string]int64{
+					"foo":     1,
+					"unknown": 2,
+				},
+				InstallID:           "asdf",
+				IncludeDispositions: true,
+			}),
+			wantStatus:   202,
+			wantWarnings: []string{`metric "unknown" not allowed`},
+			wantDispositions: map[string]api.MetricDisposition{
+				"foo":     api.DispositionAccepted,
+				"unknown": api.DispositionUnknownMetric,
+			},
+		},
+		{
+			name: "happy_legacy_wire_format",
+			db: &testMetricsDB{apps: map[string]*AppMetrics{"test": {
+				AppID:   "test",
+				Allowed: map[string]interface{}{"foo": struct{}{}},
+			}}},
+			body:       strings.NewReader(`{"appId":"test","version":"0.9","installTime":1706702400,"metrics":{"foo":1}}`),
+			wantStatus: 202,
+			wantWarnings: []string{
+				"deprecated field version, use appVersion",
+				"deprecated field installTime, use installId",
+			},
+			wantLogs: map[*slogassert.LogMessageMatch]int{{
+				Message: "metric received",
+				Level:   slog.LevelInfo,
+				Attrs: map[string]any{
+					"metric.app_id":         "test",
+					"metric.app_version":    "0.9",
+					"metric.install_id":     "legacy-1706702400",
+					"metric.install_cohort": "2024-W05",
+					"metric.name":           "foo",
+				},
+				AllAttrsMatch: false,
+			}: 1},
+		},
+		{
+			name: "happy_gzip_body",
+			db: &testMetricsDB{apps: map[string]*AppMetrics{"test": {
+				AppID: "test",
+				Allowed: map[string]interface{}{
+					"foo": struct{}{},
+				},
+			}}},
+			body: gzipReader(t, marshalRequest(t, &metrics.SendMetricRequest{
+				AppID:      "test",
+				AppVersion: "1.0",
+				Metrics:    map[string]int64{"foo": 1},
+				InstallID:  "asdf",
+			})),
+			contentEncoding: "gzip",
+			wantStatus:      202,
+			wantLogs: map[*slogassert.LogMessageMatch]int{{
+				Message: "metric received",
+				Level:   slog.LevelInfo,
+				Attrs: map[string]any{
+					"metric.app_id": "test",
+					"metric.name":   "foo",
+				},
+				AllAttrsMatch: false,
+			}: 1},
+		},
+		{
+			name: "happy_protobuf",
+			db: &testMetricsDB{apps: map[string]*AppMetrics{"test": {
+				AppID:   "test",
+				Allowed: map[string]interface{}{"foo": struct{}{}},
+			}}},
+			body: gzipReader(t, marshalProtoRequest(t, &metrics.SendMetricRequest{
+				AppID:               "test",
+				AppVersion:          "1.0",
+				Metrics:             map[string]int64{"foo": 1, "bar": 1},
+				InstallID:           "asdf",
+				IncludeDispositions: true,
+			})),
+			contentType:     apipb.ContentType,
+			contentEncoding: "gzip",
+			wantStatus:      202,
+			wantWarnings:    []string{`metric "bar" not allowed`},
+			wantDispositions: map[string]api.MetricDisposition{
+				"foo": api.DispositionAccepted,
+				"bar": api.DispositionUnknownMetric,
+			},
+			wantLogs: map[*slogassert.LogMessageMatch]int{{
+				Message: "metric received",
+				Level:   slog.LevelInfo,
+				Attrs: map[string]any{
+					"metric.app_id": "test",
+					"metric.name":   "foo",
+				},
+				AllAttrsMatch: false,
+			}: 1},
+		},
+		{
+			name: "malformed_protobuf_returns_400",
+			db: &testMetricsDB{apps: map[string]*AppMetrics{"test": {
+				AppID:   "test",
+				Allowed: map[string]interface{}{"foo": struct{}{}},
+			}}},
+			body:        strings.NewReader(`{"appId":"test"}`),
+			contentType: apipb.ContentType,
+			wantStatus:  400,
+			wantCode:    apierror.CodeMalformedProtobuf,
+		},
+		{
+			name: "malformed_gzip_body_returns_400",
+			db: &testMetricsDB{apps: map[string]*AppMetrics{"test": {
+				AppID:   "test",
+				Allowed: map[string]interface{}{"foo": struct{}{}},
+			}}},
+			body:            strings.NewReader("not gzip"),
+			contentEncoding: "gzip",
+			wantStatus:      400,
+			wantCode:        apierror.CodeMalformedGzip,
+		},
+		{
+			name: "unsupported_encoding_returns_415",
+			db: &testMetricsDB{apps: map[string]*AppMetrics{"test": {
+				AppID:   "test",
+				Allowed: map[string]interface{}{"foo": struct{}{}},
+			}}},
+			body:            strings.NewReader("{}"),
+			contentEncoding: "br",
+			wantStatus:      415,
+			wantCode:        apierror.CodeUnsupportedEncoding,
+		},
 		{
 			name: "unknown_app_returns_404",
 			db: &testMetricsDB{apps: map[string]*AppMetrics{"test": {
@@ -200,6 +414,22 @@ func TestHandleMetric(t *testing.T) {
 				InstallID: "asdf",
 			}),
 			wantStatus: 404,
+			wantCode:   apierror.CodeUnknownApp,
+		},
+		{
+			name: "metric_name_too_long_returns_400",
+			db: &testMetricsDB{apps: map[string]*AppMetrics{"test": {
+				AppID:   "test",
+				Allowed: map[string]interface{}{"foo": struct{}{}},
+			}}},
+			body: marshalRequest(t, &metrics.SendMetricRequest{
+				AppID:      "test",
+				AppVersion: "1.0",
+				Metrics:    map[string]int64{strings.Repeat("a", maxNameLength+1): 1},
+				InstallID:  "asdf",
+			}),
+			wantStatus: 400,
+			wantCode:   apierror.CodeMetricNameTooLong,
 		},
 		{
 			name: "malformed_request_returns_400",
@@ -212,6 +442,7 @@ func TestHandleMetric(t *testing.T) {
 			}}},
 			body:       strings.NewReader("40t9u2rgo2gh09joqijgo0194u0{{{{}}}}{+{}{}"),
 			wantStatus: 400,
+			wantCode:   apierror.CodeInvalidFieldType,
 		},
 	}
 	for _, tc := range cases {
@@ -227,8 +458,15 @@ func TestHandleMetric(t *testing.T) {
 			}
 			req := httptest.NewRequest(http.MethodPost, "/sendMetrics", tc.body)
 			req.Header.Set("User-Agent", "github.com/abcxyz/abc-updater")
-			req.Header.Set("Content-Type", "application/json")
+			contentType := "application/json"
+			if tc.contentType != "" {
+				contentType = tc.contentType
+			}
+			req.Header.Set("Content-Type", contentType)
 			req.Header.Set("Accept", "application/json")
+			if tc.contentEncoding != "" {
+				req.Header.Set("Content-Encoding", tc.contentEncoding)
+			}
 			logHandler := slogassert.New(t, slog.LevelInfo, nil)
 			req = req.WithContext(logging.WithLogger(req.Context(), slog.New(logHandler)))
 
@@ -240,6 +478,27 @@ func TestHandleMetric(t *testing.T) {
 			if got, want := response.StatusCode, tc.wantStatus; got != want {
 				t.Errorf("unexpected response code. got %d want %d", got, want)
 			}
+			if tc.wantStatus == http.StatusAccepted {
+				var body api.SendMetricResponse
+				if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+					t.Fatalf("failed to decode response: %s", err.Error())
+				}
+				if diff := cmp.Diff(body.Warnings, tc.wantWarnings); diff != "" {
+					t.Errorf("unexpected warnings (-got,+want): %s", diff)
+				}
+				if diff := cmp.Diff(body.Dispositions, tc.wantDispositions); diff != "" {
+					t.Errorf("unexpected dispositions (-got,+want): %s", diff)
+				}
+			}
+			if tc.wantCode != "" {
+				var body apierror.Response
+				if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+					t.Fatalf("failed to decode error response: %s", err.Error())
+				}
+				if got, want := body.Code, tc.wantCode; got != want {
+					t.Errorf("unexpected error code. got %q want %q", got, want)
+				}
+			}
 
 			// Normally we wouldn't test log messages, but as that is the way metrics
 			// are being exported, it seems important to do so here.
@@ -255,3 +514,341 @@ func TestHandleMetric(t *testing.T) {
 		})
 	}
 }
+
+func TestSampled(t *testing.T) {
+	t.Parallel()
+
+	for _, rate := range []float64{0, 1} {
+		if !sampled(rate) {
+			t.Errorf("sampled(%g) = false, want true", rate)
+		}
+	}
+
+	const n = 10000
+	var got int
+	for i := 0; i < n; i++ {
+		if sampled(0.1) {
+			got++
+		}
+	}
+	// Bounds are wide enough to essentially never flake.
+	if got < n/20 || got > n/5 {
+		t.Errorf("sampled(0.1) returned true %d of %d times, want about %d", got, n, n/10)
+	}
+}
+
+func TestHandleMetricWithSink(t *testing.T) {
+	t.Parallel()
+
+	h, err := renderer.New(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("failed to setup test: %s", err.Error())
+	}
+	db := &testMetricsDB{apps: map[string]*AppMetrics{"test": {
+		AppID:   "test",
+		Allowed: map[string]interface{}{"foo": struct{}{}},
+		Sink:    "high_volume",
+	}}}
+	sink := &testSink{err: fmt.Errorf("sink down")}
+
+	req := httptest.NewRequest(http.MethodPost, "/sendMetrics", marshalRequest(t, &metrics.SendMetricRequest{
+		AppID:      "test",
+		AppVersion: "1.0",
+		Metrics:    map[string]int64{"foo": 1, "unknown": 2},
+		InstallID:  "asdf",
+	}))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	HandleMetricWithSink(h, db, sink).ServeHTTP(w, req)
+
+	// Sink errors are logged, not returned to the client.
+	if got, want := w.Code, http.StatusAccepted; got != want {
+		t.Errorf("unexpected response code. got %d want %d", got, want)
+	}
+	want := []*MetricRecord{{
+		AppID:      "test",
+		AppVersion: "1.0",
+		InstallID:  "asdf",
+		Name:       "foo",
+		Count:      1,
+		Sink:       "high_volume",
+	}}
+	if diff := cmp.Diff(sink.written, want, ignoreRecordTimes); diff != "" {
+		t.Errorf("unexpected metrics written (-got,+want): %s", diff)
+	}
+}
+
+func TestHandleMetricWithSink_Retired(t *testing.T) {
+	t.Parallel()
+
+	h, err := renderer.New(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("failed to setup test: %s", err.Error())
+	}
+	dropAfter := time.Date(3000, 1, 1, 0, 0, 0, 0, time.UTC)
+	db := &testMetricsDB{apps: map[string]*AppMetrics{
+		"old": {
+			AppID:   "old",
+			Allowed: map[string]interface{}{"foo": struct{}{}},
+			Retired: &api.Retirement{DropAfter: dropAfter},
+		},
+		"gone": {
+			AppID:   "gone",
+			Allowed: map[string]interface{}{"foo": struct{}{}},
+			Retired: &api.Retirement{DropAfter: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)},
+		},
+	}}
+
+	cases := []struct {
+		name         string
+		appID        string
+		wantStatus   int
+		wantWritten  []*MetricRecord
+		wantWarnings []string
+	}{
+		{
+			name:       "grace_period",
+			appID:      "old",
+			wantStatus: http.StatusAccepted,
+			wantWritten: []*MetricRecord{{
+				AppID:      "old",
+				AppVersion: "1.0",
+				InstallID:  "asdf",
+				Name:       "foo",
+				Count:      1,
+				Retired:    true,
+			}},
+			wantWarnings: []string{`app "old" is retired, metrics will be rejected after 3000-01-01T00:00:00Z`},
+		},
+		{
+			name:       "dropped",
+			appID:      "gone",
+			wantStatus: http.StatusGone,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			sink := &testSink{}
+			req := httptest.NewRequest(http.MethodPost, "/sendMetrics", marshalRequest(t, &metrics.SendMetricRequest{
+				AppID:      tc.appID,
+				AppVersion: "1.0",
+				Metrics:    map[string]int64{"foo": 1},
+				InstallID:  "asdf",
+			}))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			HandleMetricWithSink(h, db, sink).ServeHTTP(w, req)
+
+			if got, want := w.Code, tc.wantStatus; got != want {
+				t.Errorf("unexpected response code. got %d want %d", got, want)
+			}
+			if diff := cmp.Diff(sink.written, tc.wantWritten, ignoreRecordTimes); diff != "" {
+				t.Errorf("unexpected metrics written (-got,+want): %s", diff)
+			}
+			if tc.wantStatus != http.StatusAccepted {
+				return
+			}
+			var resp api.SendMetricResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode response: %s", err.Error())
+			}
+			if diff := cmp.Diff(resp.Warnings, tc.wantWarnings); diff != "" {
+				t.Errorf("unexpected warnings (-got,+want): %s", diff)
+			}
+		})
+	}
+}
+
+func TestHandleMetricWithSink_RetiredMetrics(t *testing.T) {
+	t.Parallel()
+
+	h, err := renderer.New(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("failed to setup test: %s", err.Error())
+	}
+	db := &testMetricsDB{apps: map[string]*AppMetrics{
+		"foo": {
+			AppID:   "foo",
+			Allowed: map[string]interface{}{"current": struct{}{}},
+			RetiredMetrics: map[string]*api.Retirement{
+				"old":  {DropAfter: time.Date(3000, 1, 1, 0, 0, 0, 0, time.UTC), Message: "Use current instead."},
+				"gone": {DropAfter: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)},
+			},
+		},
+	}}
+
+	cases := []struct {
+		name             string
+		metrics          map[string]int64
+		wantStatus       int
+		wantWritten      []string
+		wantWarnings     []string
+		wantDispositions map[string]api.MetricDisposition
+	}{
+		{
+			name:        "grace_period",
+			metrics:     map[string]int64{"old": 1, "current": 1},
+			wantStatus:  http.StatusAccepted,
+			wantWritten: []string{"current", "old"},
+			wantWarnings: []string{
+				`metric "old" is retired, it will be rejected after 3000-01-01T00:00:00Z: Use current instead.`,
+			},
+			wantDispositions: map[string]api.MetricDisposition{
+				"old":     api.DispositionAccepted,
+				"current": api.DispositionAccepted,
+			},
+		},
+		{
+			name:         "some_dropped",
+			metrics:      map[string]int64{"gone": 1, "current": 1},
+			wantStatus:   http.StatusAccepted,
+			wantWritten:  []string{"current"},
+			wantWarnings: []string{`metric "gone" is retired`},
+			wantDispositions: map[string]api.MetricDisposition{
+				"gone":    api.DispositionRetired,
+				"current": api.DispositionAccepted,
+			},
+		},
+		{
+			name:       "all_dropped",
+			metrics:    map[string]int64{"gone": 1},
+			wantStatus: http.StatusGone,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			sink := &testSink{}
+			req := httptest.NewRequest(http.MethodPost, "/sendMetrics", marshalRequest(t, &metrics.SendMetricRequest{
+				AppID:               "foo",
+				AppVersion:          "1.0",
+				Metrics:             tc.metrics,
+				InstallID:           "asdf",
+				IncludeDispositions: true,
+			}))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			HandleMetricWithSink(h, db, sink).ServeHTTP(w, req)
+
+			if got, want := w.Code, tc.wantStatus; got != want {
+				t.Errorf("unexpected response code. got %d want %d", got, want)
+			}
+			var written []string
+			for _, r := range sink.written {
+				written = append(written, r.Name)
+			}
+			slices.Sort(written)
+			if diff := cmp.Diff(written, tc.wantWritten); diff != "" {
+				t.Errorf("unexpected metrics written (-got,+want): %s", diff)
+			}
+			if tc.wantStatus != http.StatusAccepted {
+				if got, want := w.Body.String(), string(apierror.CodeMetricsRetired); !strings.Contains(got, want) {
+					t.Errorf("expected response %q to contain %q", got, want)
+				}
+				return
+			}
+			var resp api.SendMetricResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode response: %s", err.Error())
+			}
+			if diff := cmp.Diff(resp.Warnings, tc.wantWarnings); diff != "" {
+				t.Errorf("unexpected warnings (-got,+want): %s", diff)
+			}
+			if diff := cmp.Diff(resp.Dispositions, tc.wantDispositions); diff != "" {
+				t.Errorf("unexpected dispositions (-got,+want): %s", diff)
+			}
+		})
+	}
+}
+
+func TestHandleMetricWithSink_BuildInfo(t *testing.T) {
+	t.Parallel()
+
+	h, err := renderer.New(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("failed to setup test: %s", err.Error())
+	}
+	db := &testMetricsDB{apps: map[string]*AppMetrics{
+		"allowed": {
+			AppID:          "allowed",
+			Allowed:        map[string]interface{}{"foo": struct{}{}},
+			AllowBuildInfo: true,
+		},
+		"not_allowed": {
+			AppID:   "not_allowed",
+			Allowed: map[string]interface{}{"foo": struct{}{}},
+		},
+	}}
+	buildInfo := &api.BuildInfo{
+		Commit:    "0123abcd",
+		Date:      "2024-05-01T12:00:00Z",
+		GoVersion: "go1.22.1",
+		Builder:   "gc linux/amd64",
+	}
+
+	cases := []struct {
+		name          string
+		appID         string
+		protobuf      bool
+		wantBuildInfo *api.BuildInfo
+	}{
+		{
+			name:          "allowed",
+			appID:         "allowed",
+			wantBuildInfo: buildInfo,
+		},
+		{
+			name:          "allowed_protobuf",
+			appID:         "allowed",
+			protobuf:      true,
+			wantBuildInfo: buildInfo,
+		},
+		{
+			name:  "not_allowed",
+			appID: "not_allowed",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			sendReq := &metrics.SendMetricRequest{
+				AppID:      tc.appID,
+				AppVersion: "1.0",
+				Metrics:    map[string]int64{"foo": 1},
+				InstallID:  "asdf",
+				BuildInfo:  buildInfo,
+			}
+			body, contentType := marshalRequest(t, sendReq), "application/json"
+			if tc.protobuf {
+				body, contentType = marshalProtoRequest(t, sendReq), apipb.ContentType
+			}
+			sink := &testSink{}
+			req := httptest.NewRequest(http.MethodPost, "/sendMetrics", body)
+			req.Header.Set("Content-Type", contentType)
+			w := httptest.NewRecorder()
+			HandleMetricWithSink(h, db, sink).ServeHTTP(w, req)
+
+			if got, want := w.Code, http.StatusAccepted; got != want {
+				t.Fatalf("unexpected response code. got %d want %d", got, want)
+			}
+			want := []*MetricRecord{{
+				AppID:      tc.appID,
+				AppVersion: "1.0",
+				InstallID:  "asdf",
+				Name:       "foo",
+				Count:      1,
+				BuildInfo:  tc.wantBuildInfo,
+			}}
+			if diff := cmp.Diff(sink.written, want, ignoreRecordTimes); diff != "" {
+				t.Errorf("unexpected metrics written (-got,+want): %s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// maxPatternCardinality is the maximum number of distinct metric names a
+// single pattern will accept. Further names are rejected to keep a
+// misbehaving client from flooding logs with unique metric names.
+const maxPatternCardinality = 100
+
+// wildcardSegment is what a wildcard may match: a single, non-empty namespace
+// segment. Wildcards never match "." or "/", so "command.*" does not match
+// "command.a.b".
+const wildcardSegment = `[A-Za-z0-9_-]+`
+
+// MetricPattern is an allowlist entry containing wildcards. "*" and "{name}"
+// placeholders each match a single namespace segment, e.g. "command.*" matches
+// "command.init" and "render/{step}" matches "render/plan".
+type MetricPattern struct {
+	Pattern string
+
+	re *regexp.Regexp
+
+	mu   sync.Mutex
+	seen map[string]struct{}
+}
+
+// isMetricPattern returns true if the allowlist entry contains wildcards.
+func isMetricPattern(s string) bool {
+	return strings.ContainsAny(s, "*{}")
+}
+
+// compileMetricPattern compiles an allowlist entry containing wildcards.
+// Patterns are translated to anchored RE2 regular expressions, so matching is
+// linear time regardless of input.
+func compileMetricPattern(pattern string) (*MetricPattern, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	rest := pattern
+	for rest != "" {
+		i := strings.IndexAny(rest, "*{}")
+		if i < 0 {
+			b.WriteString(regexp.QuoteMeta(rest))
+			break
+		}
+		b.WriteString(regexp.QuoteMeta(rest[:i]))
+		switch rest[i] {
+		case '*':
+			rest = rest[i+1:]
+		case '{':
+			end := strings.IndexByte(rest[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("pattern %q has unclosed placeholder", pattern)
+			}
+			name := rest[i+1 : i+end]
+			if name == "" || strings.ContainsAny(name, "*{") {
+				return nil, fmt.Errorf("pattern %q has invalid placeholder %q", pattern, name)
+			}
+			rest = rest[i+end+1:]
+		case '}':
+			return nil, fmt.Errorf("pattern %q has unopened placeholder", pattern)
+		}
+		if strings.HasSuffix(b.String(), wildcardSegment) {
+			return nil, fmt.Errorf("pattern %q has adjacent wildcards", pattern)
+		}
+		b.WriteString(wildcardSegment)
+	}
+	b.WriteString("$")
+
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return nil, fmt.Errorf("pattern %q is invalid: %w", pattern, err)
+	}
+	return &MetricPattern{
+		Pattern: pattern,
+		re:      re,
+		seen:    make(map[string]struct{}),
+	}, nil
+}
+
+// Match returns true if metric matches the pattern and accepting it would not
+// exceed the pattern's cardinality limit.
+func (p *MetricPattern) Match(metric string) bool {
+	if p == nil || p.re == nil || !p.re.MatchString(metric) {
+		return false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.seen[metric]; ok {
+		return true
+	}
+	if len(p.seen) >= maxPatternCardinality {
+		return false
+	}
+	p.seen[metric] = struct{}{}
+	return true
+}
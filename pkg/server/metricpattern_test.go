@@ -0,0 +1,159 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+	"github.com/abcxyz/pkg/testutil"
+)
+
+func TestCompileMetricPattern(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name      string
+		pattern   string
+		match     []string
+		noMatch   []string
+		wantError string
+	}{
+		{
+			name:    "trailing_star",
+			pattern: "command.*",
+			match:   []string{"command.init", "command.render-plan"},
+			noMatch: []string{"command.", "command.a.b", "command/a", "commandXinit", "other.init"},
+		},
+		{
+			name:    "named_placeholder",
+			pattern: "render/{step}",
+			match:   []string{"render/plan", "render/apply_2"},
+			noMatch: []string{"render/", "render/a/b", "render/a.b"},
+		},
+		{
+			name:    "middle_wildcard",
+			pattern: "cmd.*.count",
+			match:   []string{"cmd.init.count"},
+			noMatch: []string{"cmd.init.total", "cmd..count"},
+		},
+		{
+			name:    "regex_metacharacters_are_literal",
+			pattern: "a+b.(*)",
+			match:   []string{"a+b.(x)"},
+			noMatch: []string{"aab.(x)", "a+bX(x)"},
+		},
+		{
+			name:      "unclosed_placeholder",
+			pattern:   "render/{step",
+			wantError: "unclosed placeholder",
+		},
+		{
+			name:      "unopened_placeholder",
+			pattern:   "render/step}",
+			wantError: "unopened placeholder",
+		},
+		{
+			name:      "empty_placeholder",
+			pattern:   "render/{}",
+			wantError: "invalid placeholder",
+		},
+		{
+			name:      "adjacent_wildcards",
+			pattern:   "render/**",
+			wantError: "adjacent wildcards",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			p, err := compileMetricPattern(tc.pattern)
+			if diff := testutil.DiffErrString(err, tc.wantError); diff != "" {
+				t.Fatal(diff)
+			}
+			for _, m := range tc.match {
+				if !p.Match(m) {
+					t.Errorf("expected %q to match %q", m, tc.pattern)
+				}
+			}
+			for _, m := range tc.noMatch {
+				if p.Match(m) {
+					t.Errorf("expected %q not to match %q", m, tc.pattern)
+				}
+			}
+		})
+	}
+}
+
+func TestMetricPattern_Cardinality(t *testing.T) {
+	t.Parallel()
+
+	p, err := compileMetricPattern("command.*")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	for i := 0; i < maxPatternCardinality; i++ {
+		if !p.Match(fmt.Sprintf("command.c%d", i)) {
+			t.Fatalf("expected metric %d to be accepted", i)
+		}
+	}
+	if p.Match("command.overflow") {
+		t.Errorf("expected new metric beyond cardinality limit to be rejected")
+	}
+	if !p.Match("command.c0") {
+		t.Errorf("expected previously seen metric to still be accepted")
+	}
+}
+
+func TestAppMetrics_MetricAllowed(t *testing.T) {
+	t.Parallel()
+
+	p, err := compileMetricPattern("command.*")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	m := &AppMetrics{
+		AppID:    "foo",
+		Allowed:  map[string]interface{}{"exact": struct{}{}},
+		Patterns: []*MetricPattern{p},
+	}
+
+	cases := []struct {
+		metric string
+		want   bool
+	}{
+		{metric: "exact", want: true},
+		{metric: "command.init", want: true},
+		{metric: "command." + strings.Repeat("a", maxNameLength), want: false},
+		{metric: "unknown", want: false},
+		{metric: api.UpgradeMetric, want: true},
+		{metric: api.DowngradeMetric, want: true},
+		{metric: api.UninstallMetric, want: true},
+	}
+	for _, tc := range cases {
+		if got := m.MetricAllowed(tc.metric); got != tc.want {
+			t.Errorf("MetricAllowed(%q) got %t want %t", tc.metric, got, tc.want)
+		}
+	}
+
+	var nilMetrics *AppMetrics
+	if nilMetrics.MetricAllowed("exact") || nilMetrics.MetricAllowed(api.UpgradeMetric) {
+		t.Errorf("expected nil AppMetrics to allow nothing")
+	}
+}
@@ -0,0 +1,262 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+	"github.com/abcxyz/abc-updater/pkg/api/apipb"
+	"github.com/abcxyz/abc-updater/pkg/apierror"
+)
+
+const (
+	// maxMetricsPerRequest is the maximum number of metrics in one request.
+	maxMetricsPerRequest = 100
+
+	// maxMetricCount is the maximum magnitude of a single metric count.
+	maxMetricCount = 1_000_000
+
+	// maxFieldLength is the maximum length of other string fields, such as
+	// app version and install ID.
+	maxFieldLength = 256
+
+	// maxEventAge is how long before it is received a metric may have
+	// occurred.
+	maxEventAge = 7 * 24 * time.Hour
+
+	// legacyInstallIDPrefix prefixes the install IDs derived from the install
+	// time sent by older clients, so they cannot collide with real install
+	// IDs.
+	legacyInstallIDPrefix = "legacy-"
+)
+
+// metricRequest is the wire format accepted by HandleMetric. It is a superset
+// of api.SendMetricRequest which also accepts field names sent by older
+// clients, so mixed client versions in the field are all recorded.
+type metricRequest struct {
+	api.SendMetricRequest
+
+	// Version is the legacy name for AppVersion.
+	Version string `json:"version"`
+
+	// InstallTime is sent by older clients to identify an install in place of
+	// InstallID, in UTC epoch seconds.
+	InstallTime int64 `json:"installTime"`
+}
+
+// decodeMetricRequest decodes r's body as a metricRequest, from protobuf if
+// its Content-Type is apipb.ContentType, and otherwise from JSON. Errors are
+// written to w.
+func decodeMetricRequest(w http.ResponseWriter, r *http.Request, h JSONRenderer) (*metricRequest, error) {
+	if !strings.HasPrefix(r.Header.Get("content-type"), apipb.ContentType) {
+		return DecodeRequest[metricRequest](r.Context(), w, r, h)
+	}
+
+	defer r.Body.Close()
+	body, closeBody, err := requestBody(w, r, h)
+	if err != nil {
+		return nil, err
+	}
+	defer closeBody()
+
+	b, err := io.ReadAll(body)
+	switch {
+	case err != nil && bodyTooLarge(err):
+		err = fmt.Errorf("request body too large")
+		return nil, rejectRequest(w, h, http.StatusRequestEntityTooLarge, apierror.CodeRequestTooLarge, err)
+	case errors.Is(err, gzip.ErrChecksum), errors.Is(err, gzip.ErrHeader), errors.Is(err, io.ErrUnexpectedEOF):
+		err = fmt.Errorf("malformed gzip body")
+		return nil, rejectRequest(w, h, http.StatusBadRequest, apierror.CodeMalformedGzip, err)
+	case err != nil:
+		err = fmt.Errorf("failed to read request body: %w", err)
+		return nil, rejectRequest(w, h, http.StatusBadRequest, apierror.CodeMalformedRequest, err)
+	case len(b) == 0:
+		err = fmt.Errorf("body must not be empty")
+		return nil, rejectRequest(w, h, http.StatusBadRequest, apierror.CodeEmptyBody, err)
+	}
+
+	var pb apipb.SendMetricRequest
+	if err := proto.Unmarshal(b, &pb); err != nil {
+		err = fmt.Errorf("malformed protobuf body")
+		return nil, rejectRequest(w, h, http.StatusBadRequest, apierror.CodeMalformedProtobuf, err)
+	}
+	return &metricRequest{SendMetricRequest: *pb.ToAPI()}, nil
+}
+
+// normalize converts the request into the current wire format. Current field
+// names take precedence over legacy ones when both are present.
+func (r *metricRequest) normalize() *api.SendMetricRequest {
+	out := r.SendMetricRequest
+	if out.AppVersion == "" {
+		out.AppVersion = r.Version
+	}
+	if r.InstallTime > 0 {
+		if out.InstallID == "" {
+			out.InstallID = legacyInstallIDPrefix + strconv.FormatInt(r.InstallTime, 10)
+		}
+		if out.InstallCohort == "" {
+			out.InstallCohort = api.CohortForInstallTime(r.InstallTime)
+		}
+	}
+	return &out
+}
+
+// isLegacyInstallID returns true if id was derived from the install time sent
+// by an older client. Installs at the same second share such an ID, so it is
+// not tracked per install.
+func isLegacyInstallID(id string) bool {
+	return strings.HasPrefix(id, legacyInstallIDPrefix)
+}
+
+// deprecationWarnings returns a warning for each legacy field set in the
+// request.
+func (r *metricRequest) deprecationWarnings() []string {
+	var warnings []string
+	if r.Version != "" {
+		warnings = append(warnings, "deprecated field version, use appVersion")
+	}
+	if r.InstallTime != 0 {
+		warnings = append(warnings, "deprecated field installTime, use installId")
+	}
+	return warnings
+}
+
+// validateMetricRequest enforces limits on a normalized request, so a single
+// request cannot flood logs with garbage. Returns nil if the request is valid.
+func validateMetricRequest(r *api.SendMetricRequest) *apierror.Response {
+	if n := len(r.Metrics); n > maxMetricsPerRequest {
+		return &apierror.Response{
+			Code:    apierror.CodeTooManyMetrics,
+			Message: fmt.Sprintf("request contains %d metrics, maximum is %d", n, maxMetricsPerRequest),
+		}
+	}
+
+	fields := map[string]string{
+		"appId":          r.AppID,
+		"appVersion":     r.AppVersion,
+		"installId":      r.InstallID,
+		"installCohort":  r.InstallCohort,
+		"upgradedFrom":   r.UpgradedFrom,
+		"downgradedFrom": r.DowngradedFrom,
+	}
+	if b := r.BuildInfo; b != nil {
+		fields["buildInfo.commit"] = b.Commit
+		fields["buildInfo.date"] = b.Date
+		fields["buildInfo.goVersion"] = b.GoVersion
+		fields["buildInfo.builder"] = b.Builder
+	}
+	for field, v := range fields {
+		if len(v) > maxFieldLength || !validString(v) {
+			return &apierror.Response{
+				Code:    apierror.CodeInvalidString,
+				Message: fmt.Sprintf("field %q is too long or contains invalid characters", field),
+			}
+		}
+	}
+
+	for name, count := range r.Metrics {
+		if len(name) > maxNameLength {
+			return &apierror.Response{
+				Code:    apierror.CodeMetricNameTooLong,
+				Message: fmt.Sprintf("metric names must be at most %d bytes", maxNameLength),
+			}
+		}
+		if name == "" || !validString(name) {
+			return &apierror.Response{
+				Code:    apierror.CodeInvalidString,
+				Message: "metric names must be non-empty and contain only printable characters",
+			}
+		}
+		if count > maxMetricCount || count < -maxMetricCount {
+			return &apierror.Response{
+				Code:    apierror.CodeCountOutOfRange,
+				Message: fmt.Sprintf("metric counts must be between %d and %d", -maxMetricCount, maxMetricCount),
+			}
+		}
+	}
+
+	if r.Dropped < 0 {
+		return &apierror.Response{
+			Code:    apierror.CodeCountOutOfRange,
+			Message: "dropped count must not be negative",
+		}
+	}
+	if r.Sequence < 0 {
+		return &apierror.Response{
+			Code:    apierror.CodeCountOutOfRange,
+			Message: "sequence must not be negative",
+		}
+	}
+	if r.EventTime < 0 || r.SentTime < 0 {
+		return &apierror.Response{
+			Code:    apierror.CodeInvalidEventTime,
+			Message: "event and sent times must not be negative",
+		}
+	}
+	return nil
+}
+
+// eventTime returns when r's metrics occurred: r.EventTime, corrected for the
+// client's clock skew by the difference between r.SentTime and receivedAt, or
+// receivedAt if r has no event time. Event times after receipt are clamped to
+// receivedAt. Returns an error if the event time is more than maxEventAge
+// before receipt.
+func eventTime(r *api.SendMetricRequest, receivedAt time.Time) (time.Time, *apierror.Response) {
+	if r.EventTime == 0 {
+		return receivedAt, nil
+	}
+	t := time.UnixMilli(r.EventTime)
+	if r.SentTime != 0 {
+		// Includes network latency, which is small next to buffering.
+		t = t.Add(receivedAt.Sub(time.UnixMilli(r.SentTime)))
+	}
+	if t.After(receivedAt) {
+		return receivedAt, nil
+	}
+	if receivedAt.Sub(t) > maxEventAge {
+		return time.Time{}, &apierror.Response{
+			Code:    apierror.CodeInvalidEventTime,
+			Message: fmt.Sprintf("event time must be within %s of receipt", maxEventAge),
+		}
+	}
+	return t.UTC(), nil
+}
+
+// validString returns true if s is valid UTF-8 with no control characters.
+// encoding/json replaces invalid UTF-8 with the replacement character, so that
+// is rejected too.
+func validString(s string) bool {
+	if !utf8.ValidString(s) {
+		return false
+	}
+	for _, r := range s {
+		if r == utf8.RuneError || unicode.IsControl(r) {
+			return false
+		}
+	}
+	return true
+}
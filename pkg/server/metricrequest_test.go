@@ -0,0 +1,272 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+	"github.com/abcxyz/abc-updater/pkg/apierror"
+	"github.com/abcxyz/abc-updater/pkg/metrics"
+)
+
+func TestMetricRequest_normalize(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		body string
+		want *metrics.SendMetricRequest
+	}{
+		{
+			name: "current_format",
+			body: `{"appId":"a","appVersion":"1.0","installId":"id","installCohort":"2024-W01","metrics":{"m":1}}`,
+			want: &metrics.SendMetricRequest{
+				AppID:         "a",
+				AppVersion:    "1.0",
+				InstallID:     "id",
+				InstallCohort: "2024-W01",
+				Metrics:       map[string]int64{"m": 1},
+			},
+		},
+		{
+			name: "legacy_version_and_install_time",
+			body: `{"appId":"a","version":"0.9","installTime":1706702400,"metrics":{"m":1}}`,
+			want: &metrics.SendMetricRequest{
+				AppID:         "a",
+				AppVersion:    "0.9",
+				InstallID:     "legacy-1706702400",
+				InstallCohort: "2024-W05",
+				Metrics:       map[string]int64{"m": 1},
+			},
+		},
+		{
+			name: "current_fields_take_precedence",
+			body: `{"appId":"a","appVersion":"1.0","version":"0.9","installId":"id","installTime":1706702400,"metrics":{"m":1}}`,
+			want: &metrics.SendMetricRequest{
+				AppID:         "a",
+				AppVersion:    "1.0",
+				InstallID:     "id",
+				InstallCohort: "2024-W05",
+				Metrics:       map[string]int64{"m": 1},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var req metricRequest
+			if err := json.Unmarshal([]byte(tc.body), &req); err != nil {
+				t.Fatalf("failed to unmarshal: %s", err.Error())
+			}
+			if diff := cmp.Diff(req.normalize(), tc.want); diff != "" {
+				t.Errorf("unexpected normalized request. Diff (-got +want): %s", diff)
+			}
+		})
+	}
+}
+
+func TestValidateMetricRequest(t *testing.T) {
+	t.Parallel()
+
+	tooMany := make(map[string]int64, maxMetricsPerRequest+1)
+	for i := 0; i <= maxMetricsPerRequest; i++ {
+		tooMany[fmt.Sprintf("m%d", i)] = 1
+	}
+
+	cases := []struct {
+		name     string
+		req      *metrics.SendMetricRequest
+		wantCode apierror.Code
+	}{
+		{
+			name: "valid",
+			req: &metrics.SendMetricRequest{
+				AppID:      "a",
+				AppVersion: "1.0",
+				InstallID:  "id",
+				Metrics:    map[string]int64{"m": 1},
+			},
+		},
+		{
+			name:     "too_many_metrics",
+			req:      &metrics.SendMetricRequest{AppID: "a", Metrics: tooMany},
+			wantCode: apierror.CodeTooManyMetrics,
+		},
+		{
+			name:     "metric_name_too_long",
+			req:      &metrics.SendMetricRequest{AppID: "a", Metrics: map[string]int64{strings.Repeat("m", maxNameLength+1): 1}},
+			wantCode: apierror.CodeMetricNameTooLong,
+		},
+		{
+			name:     "empty_metric_name",
+			req:      &metrics.SendMetricRequest{AppID: "a", Metrics: map[string]int64{"": 1}},
+			wantCode: apierror.CodeInvalidString,
+		},
+		{
+			name:     "control_character_in_name",
+			req:      &metrics.SendMetricRequest{AppID: "a", Metrics: map[string]int64{"m\nfake log line": 1}},
+			wantCode: apierror.CodeInvalidString,
+		},
+		{
+			name:     "replacement_character_in_app_version",
+			req:      &metrics.SendMetricRequest{AppID: "a", AppVersion: "1.0�", Metrics: map[string]int64{"m": 1}},
+			wantCode: apierror.CodeInvalidString,
+		},
+		{
+			name:     "long_install_id",
+			req:      &metrics.SendMetricRequest{AppID: "a", InstallID: strings.Repeat("i", maxFieldLength+1), Metrics: map[string]int64{"m": 1}},
+			wantCode: apierror.CodeInvalidString,
+		},
+		{
+			name:     "count_too_large",
+			req:      &metrics.SendMetricRequest{AppID: "a", Metrics: map[string]int64{"m": maxMetricCount + 1}},
+			wantCode: apierror.CodeCountOutOfRange,
+		},
+		{
+			name:     "count_too_small",
+			req:      &metrics.SendMetricRequest{AppID: "a", Metrics: map[string]int64{"m": -maxMetricCount - 1}},
+			wantCode: apierror.CodeCountOutOfRange,
+		},
+		{
+			name:     "negative_dropped",
+			req:      &metrics.SendMetricRequest{AppID: "a", Metrics: map[string]int64{"m": 1}, Dropped: -1},
+			wantCode: apierror.CodeCountOutOfRange,
+		},
+		{
+			name:     "negative_event_time",
+			req:      &metrics.SendMetricRequest{AppID: "a", Metrics: map[string]int64{"m": 1}, EventTime: -1},
+			wantCode: apierror.CodeInvalidEventTime,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := validateMetricRequest(tc.req)
+			var gotCode apierror.Code
+			if got != nil {
+				gotCode = got.Code
+			}
+			if gotCode != tc.wantCode {
+				t.Errorf("unexpected error code. got %q want %q", gotCode, tc.wantCode)
+			}
+		})
+	}
+}
+
+func TestEventTime(t *testing.T) {
+	t.Parallel()
+
+	received := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+	ms := func(d time.Duration) int64 { return received.Add(d).UnixMilli() }
+
+	cases := []struct {
+		name      string
+		eventTime int64
+		sentTime  int64
+		want      time.Time
+		wantCode  apierror.Code
+	}{
+		{
+			name: "unset",
+			want: received,
+		},
+		{
+			name:      "no_sent_time",
+			eventTime: ms(-time.Hour),
+			want:      received.Add(-time.Hour),
+		},
+		{
+			name:      "clock_behind",
+			eventTime: ms(-3 * time.Hour),
+			sentTime:  ms(-2 * time.Hour),
+			want:      received.Add(-time.Hour),
+		},
+		{
+			name:      "clock_ahead",
+			eventTime: ms(time.Hour),
+			sentTime:  ms(2 * time.Hour),
+			want:      received.Add(-time.Hour),
+		},
+		{
+			name:      "future_clamped",
+			eventTime: ms(time.Hour),
+			want:      received,
+		},
+		{
+			name:      "too_old",
+			eventTime: ms(-maxEventAge - time.Minute),
+			sentTime:  ms(0),
+			wantCode:  apierror.CodeInvalidEventTime,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, apiErr := eventTime(&api.SendMetricRequest{EventTime: tc.eventTime, SentTime: tc.sentTime}, received)
+			var gotCode apierror.Code
+			if apiErr != nil {
+				gotCode = apiErr.Code
+			}
+			if gotCode != tc.wantCode {
+				t.Errorf("unexpected error code. got %q want %q", gotCode, tc.wantCode)
+			}
+			if !got.Equal(tc.want) {
+				t.Errorf("unexpected event time. got %s want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func FuzzMetricRequest(f *testing.F) {
+	f.Add([]byte(`{"appId":"a","appVersion":"1.0","installId":"id","metrics":{"m":1}}`))
+	f.Add([]byte(`{"appId":"a","version":"0.9","installTime":1706702400,"metrics":{"m":1}}`))
+	f.Add([]byte(`{"metrics":{"\u0000":-9223372036854775808}}`))
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		var req metricRequest
+		if err := json.Unmarshal(b, &req); err != nil {
+			return
+		}
+		normalized := req.normalize()
+		if validateMetricRequest(normalized) != nil {
+			return
+		}
+		// Anything accepted must be within limits.
+		if len(normalized.Metrics) > maxMetricsPerRequest {
+			t.Errorf("accepted %d metrics", len(normalized.Metrics))
+		}
+		for name, count := range normalized.Metrics {
+			if len(name) > maxNameLength || !validString(name) {
+				t.Errorf("accepted invalid metric name %q", name)
+			}
+			if count > maxMetricCount || count < -maxMetricCount {
+				t.Errorf("accepted out of range count %d", count)
+			}
+		}
+	})
+}
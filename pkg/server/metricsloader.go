@@ -17,33 +17,51 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"slices"
 	"sync"
+	"time"
 
+	"github.com/hashicorp/go-version"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
 	"github.com/abcxyz/pkg/logging"
 )
 
 const (
 	manifestURLFormat     = "%s/manifest.json"
 	appMetricsURLFormat   = "%s/%s/metrics.json"
+	appDataURLFormat      = "%s/%s/data.json"
 	maxErrorResponseBytes = 2048
+	maxAppDataBytes       = 1 << 20 // 1MiB
+
+	// DefaultUpdateParallelism is the most apps fetched at once during Update
+	// if MetricsLoadParams.Parallelism is unset.
+	DefaultUpdateParallelism = 8
 )
 
 // Assert MetricsDB satisfies MetricsLookuper.
-var _ MetricsLookuper = (*MetricsDB)(nil)
+var (
+	_ MetricsLookuper       = (*MetricsDB)(nil)
+	_ MetadataProblemLister = (*MetricsDB)(nil)
+	_ AppDataLookuper       = (*MetricsDB)(nil)
+	_ AppLister             = (*MetricsDB)(nil)
+)
 
 // ManifestResponse is the json file served to list all apps which have metrics.
-type ManifestResponse struct {
-	MetricsApps []string `json:"metricsApps"`
-}
+type ManifestResponse = api.ManifestResponse
 
 // AllowedMetricsResponse is the per-app metrics.json file which lists the metrics
 // which can be recorded.
-type AllowedMetricsResponse struct {
-	Metrics []string `json:"metrics"`
-}
+type AllowedMetricsResponse = api.AllowedMetricsResponse
+
+// MetricLogging configures how an app's metrics are emitted.
+type MetricLogging = api.MetricLogging
 
 type MetricsLookuper interface {
 	Update(ctx context.Context, params *MetricsLoadParams) error
@@ -51,53 +69,381 @@ type MetricsLookuper interface {
 }
 
 type MetricsDB struct {
-	apps map[string]*AppMetrics
-	mu   sync.RWMutex
+	// Store optionally persists definitions, so replicas share one consistent
+	// set. Definitions are saved after each update, and replicas which fail
+	// to fetch a definition use the stored one. If nil, each replica falls
+	// back to its own previously loaded definitions.
+	Store DefinitionStore
+
+	// OnVersionChange is optionally called after an update with the version
+	// data of each app whose current version changed, including apps loaded
+	// for the first time. See Publisher.Observe.
+	OnVersionChange func(ctx context.Context, data *api.AppResponse)
+
+	apps     map[string]*AppMetrics
+	data     map[string]*AppData
+	problems []*MetadataProblem
+	// manifest is the last manifest loaded, so stored definitions keep their
+	// owners and retirements if the manifest cannot be fetched.
+	manifest *ManifestResponse
+	// fetched are the files last fetched for each app, reused while the
+	// manifest shows they are unchanged.
+	fetched map[string]*appFetch
+	// generation is the Generation of the last manifest loaded.
+	generation string
+	mu         sync.RWMutex
+
+	// subs are the channels returned by Subscribe.
+	subMu sync.Mutex
+	subs  map[chan map[string][]string]struct{}
 }
 
 func (db *MetricsDB) Update(ctx context.Context, params *MetricsLoadParams) error {
-	manifest, err := getManifest(ctx, params)
+	start := time.Now()
+	db.mu.RLock()
+	prev, generation := db.fetched, db.generation
+	db.mu.RUnlock()
+	var since string
+	if params.ChangesSince {
+		since = generation
+	}
+
+	manifest, err := getManifest(ctx, params, since)
 	if err != nil {
+		if db.Store != nil {
+			db.useStoredDefinitions(ctx)
+		}
 		return fmt.Errorf("could not load manifest: %w", err)
 	}
 
 	newDefs := make(map[string]*AppMetrics, len(manifest.MetricsApps))
+	newData := make(map[string]*AppData, len(manifest.MetricsApps))
+	problems := validateManifest(manifest)
+	// defs are the raw definitions in newDefs, for saving to Store.
+	defs := make(map[string]*AllowedMetricsResponse, len(manifest.MetricsApps))
+	// stored is loaded from Store on the first failed fetch.
+	var stored map[string]*AllowedMetricsResponse
+	// fetchErrs are the apps whose definitions could not be fetched.
+	var fetchErrs []error
+	// newFetched are the apps whose files were all fetched, for reuse by
+	// the next update.
+	newFetched := make(map[string]*appFetch, len(manifest.MetricsApps))
 
-	// Could do these in parallel if performance is ever a concern.
-	for _, app := range manifest.MetricsApps {
-		def, err := getMetricsDefinition(ctx, app, params)
+	fetched, refetched := fetchApps(ctx, manifest, prev, params)
+	for i, app := range manifest.MetricsApps {
+		def, err := fetched[i].def, fetched[i].defErr
+		if err == nil && fetched[i].dataErr == nil {
+			newFetched[app] = fetched[i]
+		}
 		if err != nil {
-			logger := logging.FromContext(ctx)
-			logger.WarnContext(ctx, "Error looking up metrics definitions for application in manifest. Will use cached definition if available.",
-				"app_id", app,
-				"cause", err.Error())
+			fetchErrs = append(fetchErrs, fmt.Errorf("%s: %w", app, err))
+			if db.Store != nil {
+				if stored == nil {
+					stored = db.loadStoredDefinitions(ctx)
+				}
+				def = stored[app]
+			}
+		}
+		if def == nil {
 			// Technically a race as we could squash changes created by another update
 			// but not a big deal if that happens.
 			if metrics, err := db.GetAllowedMetrics(app); err != nil {
-				logger.WarnContext(ctx, "No cached definition available for application metrics definition.",
+				logging.FromContext(ctx).WarnContext(ctx, "No cached definition available for application metrics definition.",
 					"app_id", app,
 					"cause", err.Error())
 			} else {
 				newDefs[app] = metrics
 			}
 			continue
-		} else {
-			metricSet := make(map[string]interface{}, len(def.Metrics))
-			for _, v := range def.Metrics {
-				metricSet[v] = struct{}{}
+		}
+		appMetrics, appProblems := db.newAppMetrics(app, def, manifest)
+		problems = append(problems, appProblems...)
+		newDefs[app] = appMetrics
+		defs[app] = def
+
+		// Version data is optional for metrics apps, so only validate if present.
+		data, err := fetched[i].data, fetched[i].dataErr
+		if err != nil {
+			logging.FromContext(ctx).DebugContext(ctx, "Error looking up version data for application in manifest.",
+				"app_id", app,
+				"cause", err.Error())
+			if old, err := db.GetAppData(app); err == nil {
+				newData[app] = old
 			}
-			newDefs[app] = &AppMetrics{
-				AppID:   app,
-				Allowed: metricSet,
+		} else if data != nil {
+			problems = append(problems, validateAppData(app, data.Data)...)
+			if r := manifest.Retired[app]; r != nil {
+				data = data.withRetirement(r)
 			}
+			newData[app] = data
+		}
+	}
+
+	if len(fetchErrs) > 0 {
+		logging.FromContext(ctx).WarnContext(ctx, "Error looking up metrics definitions for applications in manifest. Will use stored or cached definitions if available.",
+			"failed", len(fetchErrs),
+			"total", len(manifest.MetricsApps),
+			"cause", errors.Join(fetchErrs...).Error())
+	}
+	if db.Store != nil {
+		if err := db.Store.SaveDefinitions(ctx, defs); err != nil {
+			logging.FromContext(ctx).WarnContext(ctx, "Error saving metrics definitions to store.",
+				"cause", err.Error())
 		}
 	}
 	db.mu.Lock()
-	defer db.mu.Unlock()
+	db.manifest = manifest
+	db.fetched = newFetched
+	db.generation = manifest.Generation
+	db.mu.Unlock()
+	db.replace(ctx, newDefs, newData, problems)
+	logging.FromContext(ctx).DebugContext(ctx, "Updated metrics definitions.",
+		"apps", len(manifest.MetricsApps),
+		"refetched", refetched,
+		"failed", len(fetchErrs),
+		"duration", time.Since(start).String())
+	return nil
+}
+
+// appFetch is the result of fetching one app's files during Update.
+type appFetch struct {
+	// hash is the app's hash in the manifest when its files were fetched.
+	hash    string
+	def     *AllowedMetricsResponse
+	defErr  error
+	data    *AppData
+	dataErr error
+}
+
+// fetchApps fetches the definition and version data of each app in manifest,
+// at most params.Parallelism apps at a time, returning the results in the same
+// order as manifest.MetricsApps and the number of apps fetched. Apps in prev
+// which the manifest shows are unchanged are reused instead.
+func fetchApps(ctx context.Context, manifest *ManifestResponse, prev map[string]*appFetch, params *MetricsLoadParams) ([]*appFetch, int) {
+	parallelism := params.Parallelism
+	if parallelism <= 0 {
+		parallelism = DefaultUpdateParallelism
+	}
+
+	apps := manifest.MetricsApps
+	out := make([]*appFetch, len(apps))
+	var refetched int
+	sem := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i, app := range apps {
+		if p := prev[app]; p != nil && unchanged(manifest, app, p) {
+			out[i] = p
+			continue
+		}
+		refetched++
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, app string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			f := &appFetch{hash: manifest.Hashes[app]}
+			f.def, f.defErr = getMetricsDefinition(ctx, app, params)
+			f.data, f.dataErr = getAppData(ctx, app, params)
+			out[i] = f
+		}(i, app)
+	}
+	wg.Wait()
+	return out, refetched
+}
+
+// unchanged returns true if manifest shows app's files are the same as when
+// prev was fetched, either because it is a delta which does not list app, or
+// because app's hash is the same.
+func unchanged(manifest *ManifestResponse, app string, prev *appFetch) bool {
+	if manifest.Delta {
+		return !slices.Contains(manifest.Changed, app)
+	}
+	h := manifest.Hashes[app]
+	return h != "" && h == prev.hash
+}
+
+// newAppMetrics converts a fetched definition into AppMetrics, with the app's
+// owner and retirement from manifest, if any, returning any problems found.
+func (db *MetricsDB) newAppMetrics(app string, def *AllowedMetricsResponse, manifest *ManifestResponse) (*AppMetrics, []*MetadataProblem) {
+	problems := validateMetricsDefinition(app, def)
+	// Reuse existing patterns so cardinality limits persist across updates.
+	var oldPatterns []*MetricPattern
+	if old, err := db.GetAllowedMetrics(app); err == nil {
+		oldPatterns = old.Patterns
+	}
+	metricSet := make(map[string]interface{}, len(def.Metrics))
+	var patterns []*MetricPattern
+	for _, v := range def.Metrics {
+		if !isMetricPattern(v) {
+			metricSet[v] = struct{}{}
+			continue
+		}
+		p, err := reusePattern(oldPatterns, v)
+		if err != nil {
+			problems = append(problems, &MetadataProblem{AppID: app, Message: err.Error()})
+			continue
+		}
+		patterns = append(patterns, p)
+	}
+	for metric, bounds := range def.Latency {
+		// Problems are reported by validateMetricsDefinition.
+		if metric == "" || isMetricPattern(metric) {
+			continue
+		}
+		buckets, err := api.ParseLatencyBuckets(bounds)
+		if err != nil {
+			continue
+		}
+		for _, le := range buckets {
+			metricSet[api.LatencyBucketMetric(metric, le)] = struct{}{}
+		}
+		metricSet[api.LatencyBucketMetric(metric, 0)] = struct{}{}
+	}
+	var owners map[string]*api.Owner
+	var retired *api.Retirement
+	if manifest != nil {
+		owners = manifest.Owners
+		retired = manifest.Retired[app]
+	}
+	owner, ownerDef := findOwner(owners, app)
+	appMetrics := &AppMetrics{
+		AppID:          app,
+		Allowed:        metricSet,
+		Patterns:       patterns,
+		Owner:          owner,
+		Retired:        retired,
+		RetiredMetrics: def.Retired,
+		AllowBuildInfo: def.AllowBuildInfo,
+	}
+	var ownerLogging *MetricLogging
+	if ownerDef != nil {
+		ownerLogging = ownerDef.Logging
+	}
+	if l := mergeLogging(def.Logging, ownerLogging); l != nil {
+		appMetrics.Level, appMetrics.Sink, appMetrics.SampleRate = resolveLogging(l)
+	}
+	return appMetrics, problems
+}
+
+// findOwner returns the name and definition of app's owner in owners, or
+// nothing if it has none. If the manifest lists the app under several owners,
+// the first by name is used.
+func findOwner(owners map[string]*api.Owner, app string) (string, *api.Owner) {
+	names := make([]string, 0, len(owners))
+	for name := range owners {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		if o := owners[name]; o != nil && slices.Contains(o.Apps, app) {
+			return name, o
+		}
+	}
+	return "", nil
+}
+
+// mergeLogging returns app's logging config with unset fields taken from
+// owner's, or nil if neither is set.
+func mergeLogging(app, owner *MetricLogging) *MetricLogging {
+	if owner == nil {
+		return app
+	}
+	if app == nil {
+		return owner
+	}
+	merged := *app
+	if merged.Level == "" {
+		merged.Level = owner.Level
+	}
+	if merged.Sink == "" {
+		merged.Sink = owner.Sink
+	}
+	if merged.SampleRate == 0 {
+		merged.SampleRate = owner.SampleRate
+	}
+	return &merged
+}
+
+// loadStoredDefinitions loads definitions from Store, logging and returning
+// an empty map on error.
+func (db *MetricsDB) loadStoredDefinitions(ctx context.Context) map[string]*AllowedMetricsResponse {
+	stored, err := db.Store.LoadDefinitions(ctx)
+	if err != nil {
+		logging.FromContext(ctx).WarnContext(ctx, "Error loading metrics definitions from store.",
+			"cause", err.Error())
+		return map[string]*AllowedMetricsResponse{}
+	}
+	return stored
+}
+
+// useStoredDefinitions replaces the loaded definitions with those in Store,
+// so a replica which cannot reach the metadata source still serves the same
+// definitions as its peers. Version data is left unchanged. Noop if Store has
+// no definitions.
+func (db *MetricsDB) useStoredDefinitions(ctx context.Context) {
+	stored := db.loadStoredDefinitions(ctx)
+	if len(stored) == 0 {
+		return
+	}
+	logging.FromContext(ctx).WarnContext(ctx, "Using stored metrics definitions.")
+
+	db.mu.RLock()
+	manifest := db.manifest
+	db.mu.RUnlock()
+
+	newDefs := make(map[string]*AppMetrics, len(stored))
+	var problems []*MetadataProblem
+	for app, def := range stored {
+		appMetrics, appProblems := db.newAppMetrics(app, def, manifest)
+		problems = append(problems, appProblems...)
+		newDefs[app] = appMetrics
+	}
+	db.mu.RLock()
+	data := db.data
+	db.mu.RUnlock()
+	db.replace(ctx, newDefs, data, problems)
+}
+
+// replace swaps in new definitions, version data, and problems, and notifies
+// subscribers if the allowlists changed.
+func (db *MetricsDB) replace(ctx context.Context, newDefs map[string]*AppMetrics, newData map[string]*AppData, problems []*MetadataProblem) {
+	newDefs = withKillSwitches(newDefs, newData)
+	newSnap := snapshotApps(newDefs)
+	db.mu.Lock()
 	oldDefs := db.apps
+	changed := changedVersions(db.data, newData)
 	db.apps = newDefs
+	db.data = newData
+	db.problems = problems
 	diffApps(ctx, oldDefs, newDefs)
-	return nil
+	oldSnap := snapshotApps(oldDefs)
+	db.mu.Unlock()
+
+	if !snapshotsEqual(oldSnap, newSnap) {
+		db.notify(newSnap)
+	}
+	if db.OnVersionChange != nil {
+		for _, data := range changed {
+			db.OnVersionChange(ctx, data)
+		}
+	}
+}
+
+// changedVersions returns the version data in newData whose current version
+// differs from oldData.
+func changedVersions(oldData, newData map[string]*AppData) []*api.AppResponse {
+	var changed []*api.AppResponse
+	for id, d := range newData {
+		if d.Data == nil {
+			continue
+		}
+		if old, ok := oldData[id]; ok && old.Data != nil && old.Data.CurrentVersion == d.Data.CurrentVersion {
+			continue
+		}
+		changed = append(changed, d.Data)
+	}
+	return changed
 }
 
 // Log any changes in application lists. Individual metric names changes not
@@ -138,16 +484,66 @@ func (db *MetricsDB) GetAllowedMetrics(appID string) (*AppMetrics, error) {
 	return v, nil
 }
 
+// GetAppData returns the version data for a given AppID. An error is returned
+// if that AppID has no version data.
+func (db *MetricsDB) GetAppData(appID string) (*AppData, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	v, ok := db.data[appID]
+	if !ok {
+		return nil, fmt.Errorf("no version data found for app %s", appID)
+	}
+	return v, nil
+}
+
+// ListApps returns the IDs of all apps with metrics definitions or version
+// data loaded, sorted.
+func (db *MetricsDB) ListApps() []string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	ids := make([]string, 0, len(db.apps))
+	for id := range db.apps {
+		ids = append(ids, id)
+	}
+	for id := range db.data {
+		if _, ok := db.apps[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// MetadataProblems returns problems found in app metadata during the most
+// recent successful update.
+func (db *MetricsDB) MetadataProblems() []*MetadataProblem {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.problems
+}
+
 // MetricsLoadParams are the parameters for looking up metrics information.
 // TODO: load from config and parse/validate url on startup.
 type MetricsLoadParams struct {
 	ServerURL string
 	Client    *http.Client
+	// Parallelism is the most apps whose files are fetched at once during
+	// Update. Zero uses DefaultUpdateParallelism.
+	Parallelism int
+	// ChangesSince requests the manifest with a "changes-since" query
+	// parameter set to the generation of the last manifest loaded, so
+	// metadata sources which support it can list only the apps changed.
+	ChangesSince bool
 }
 
-// getManifest fetches manifest definition from remote server.
-func getManifest(ctx context.Context, params *MetricsLoadParams) (*ManifestResponse, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf(manifestURLFormat, params.ServerURL), nil)
+// getManifest fetches manifest definition from remote server. If since is not
+// empty, only changes since that generation are requested.
+func getManifest(ctx context.Context, params *MetricsLoadParams, since string) (*ManifestResponse, error) {
+	manifestURL := fmt.Sprintf(manifestURLFormat, params.ServerURL)
+	if since != "" {
+		manifestURL += "?changes-since=" + url.QueryEscape(since)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, manifestURL, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create manifest request: %w", err)
 	}
@@ -199,16 +595,128 @@ func getMetricsDefinition(ctx context.Context, appID string, params *MetricsLoad
 	return &m, nil
 }
 
+// getAppData fetches the version data for an app. Returns nil without error if
+// the app has no version data.
+func getAppData(ctx context.Context, appID string, params *MetricsLoadParams) (*AppData, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf(appDataURLFormat, params.ServerURL, appID), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create app data request: %w", err)
+	}
+	resp, err := params.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make app data request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil //nolint:nilnil // Missing data is expected for metrics-only apps.
+	}
+	if resp.StatusCode != http.StatusOK {
+		b, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorResponseBytes))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read response body")
+		}
+		return nil, fmt.Errorf("not a 200 response: %s", string(b))
+	}
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxAppDataBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	var m api.AppResponse
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("failed to decode response body: %w", err)
+	}
+	return &AppData{
+		AppID:   appID,
+		Data:    &m,
+		content: newCachedContent(b, "application/json", time.Now()),
+	}, nil
+}
+
+// resolveLogging returns the level, sink, and sample rate for l, using defaults
+// for invalid values. Invalid values are reported by validateMetricsDefinition.
+func resolveLogging(l *MetricLogging) (slog.Level, string, float64) {
+	level := slog.LevelInfo
+	if l.Level != "" {
+		if err := level.UnmarshalText([]byte(l.Level)); err != nil {
+			level = slog.LevelInfo
+		}
+	}
+	rate := l.SampleRate
+	if rate <= 0 || rate >= 1 {
+		rate = 0
+	}
+	return level, l.Sink, rate
+}
+
+// reusePattern returns the pattern from old with the same pattern string, or
+// compiles a new one.
+func reusePattern(old []*MetricPattern, pattern string) (*MetricPattern, error) {
+	for _, p := range old {
+		if p.Pattern == pattern {
+			return p, nil
+		}
+	}
+	return compileMetricPattern(pattern)
+}
+
+// lifecycleMetrics are sent by the client library itself, to distinguish
+// upgrades, users pinning older versions, and uninstalls from churn, so they
+// do not need to be in each app's allowlist.
+var lifecycleMetrics = map[string]struct{}{
+	api.UpgradeMetric:   {},
+	api.DowngradeMetric: {},
+	api.UninstallMetric: {},
+}
+
 type AppMetrics struct {
 	AppID   string
 	Allowed map[string]interface{}
+	// Patterns are allowlist entries containing wildcards.
+	Patterns []*MetricPattern
+
+	// Level is the level metrics are logged at.
+	Level slog.Level
+	// Sink is logged with each metric if non-empty.
+	Sink string
+	// SampleRate is the fraction of metrics logged. Zero means all metrics
+	// are logged.
+	SampleRate float64
+	// Owner is the name of the app's owner in the manifest, if any.
+	Owner string
+	// Retired is set if the manifest marks the app as retired.
+	Retired *api.Retirement
+	// RetiredMetrics are the metrics metrics.json marks as retired.
+	RetiredMetrics map[string]*api.Retirement
+	// KilledVersions are the kill switches in the app's data.json. Metrics
+	// from matching versions are rejected.
+	KilledVersions []version.Constraints
+	// AllowBuildInfo is set if the app's BuildInfo is recorded.
+	AllowBuildInfo bool
 }
 
 // MetricAllowed is a helper for looking up a particular metric for an app.
+// Lifecycle metrics sent by the client library are allowed for every app.
+// Otherwise exact matches are checked first, then wildcard patterns.
 func (m *AppMetrics) MetricAllowed(metric string) bool {
-	if m != nil && m.Allowed != nil {
-		_, ok := m.Allowed[metric]
-		return ok
+	if m == nil {
+		return false
+	}
+	if _, ok := lifecycleMetrics[metric]; ok {
+		return true
+	}
+	if m.Allowed != nil {
+		if _, ok := m.Allowed[metric]; ok {
+			return true
+		}
+	}
+	if len(metric) > maxNameLength {
+		return false
+	}
+	for _, p := range m.Patterns {
+		if p.Match(metric) {
+			return true
+		}
 	}
 	return false
 }
@@ -17,13 +17,20 @@ package server
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 
+	"github.com/abcxyz/abc-updater/pkg/api"
+	"github.com/abcxyz/abc-updater/pkg/updater"
 	"github.com/abcxyz/pkg/renderer"
 	"github.com/abcxyz/pkg/testutil"
 )
@@ -48,7 +55,7 @@ func setupTestServer(tb testing.TB, allowed map[string]*AllowedMetricsResponse,
 			for k := range allowed {
 				appList = append(appList, k)
 			}
-			response := ManifestResponse{appList}
+			response := ManifestResponse{MetricsApps: appList}
 			ren.RenderJSON(w, http.StatusOK, &response)
 			return
 
@@ -181,6 +188,46 @@ func TestMetricsDB_Update(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "latency_buckets",
+			serverMap: map[string]*AllowedMetricsResponse{
+				"foo": {
+					Metrics: []string{"metric1"},
+					Latency: map[string][]string{
+						"build":   {"100ms", "1s"},
+						"fetch":   {"1s", "100ms"},
+						"render*": nil,
+					},
+				},
+			},
+			want: map[string]*AppMetrics{
+				"foo": {
+					AppID: "foo",
+					Allowed: map[string]interface{}{
+						"metric1":         struct{}{},
+						"build.le_100ms":  struct{}{},
+						"build.le_1000ms": struct{}{},
+						"build.le_inf":    struct{}{},
+					},
+				},
+			},
+		},
+		{
+			name: "retired_metrics",
+			serverMap: map[string]*AllowedMetricsResponse{
+				"foo": {
+					Metrics: []string{"metric1"},
+					Retired: map[string]*api.Retirement{"metric2": {DropAfter: time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)}},
+				},
+			},
+			want: map[string]*AppMetrics{
+				"foo": {
+					AppID:          "foo",
+					Allowed:        map[string]interface{}{"metric1": struct{}{}},
+					RetiredMetrics: map[string]*api.Retirement{"metric2": {DropAfter: time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)}},
+				},
+			},
+		},
 		{
 			name: "happy_successive_update",
 			before: map[string]*AppMetrics{
@@ -262,6 +309,48 @@ func TestMetricsDB_Update(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "happy_wildcard_patterns",
+			serverMap: map[string]*AllowedMetricsResponse{
+				"foo": {Metrics: []string{"metric1", "command.*", "bad{"}},
+			},
+			want: map[string]*AppMetrics{
+				"foo": {
+					AppID: "foo",
+					Allowed: map[string]interface{}{
+						"metric1": struct{}{},
+					},
+					Patterns: []*MetricPattern{{Pattern: "command.*"}},
+				},
+			},
+		},
+		{
+			name: "happy_logging_settings",
+			serverMap: map[string]*AllowedMetricsResponse{
+				"foo": {
+					Metrics: []string{"metric1"},
+					Logging: &MetricLogging{Level: "DEBUG", Sink: "high_volume", SampleRate: 0.25},
+				},
+				"bar": {
+					Metrics: []string{"metric1"},
+					Logging: &MetricLogging{Level: "LOUD", SampleRate: 2},
+				},
+			},
+			want: map[string]*AppMetrics{
+				"foo": {
+					AppID:      "foo",
+					Allowed:    map[string]interface{}{"metric1": struct{}{}},
+					Level:      slog.LevelDebug,
+					Sink:       "high_volume",
+					SampleRate: 0.25,
+				},
+				"bar": {
+					AppID:   "bar",
+					Allowed: map[string]interface{}{"metric1": struct{}{}},
+					Level:   slog.LevelInfo,
+				},
+			},
+		},
 		{
 			name: "unhappy_cannot_load_manifest_noop_returns_error",
 			before: map[string]*AppMetrics{
@@ -319,9 +408,243 @@ func TestMetricsDB_Update(t *testing.T) {
 				t.Error(diff)
 			}
 
-			if diff := cmp.Diff(db.apps, tc.want); diff != "" {
+			if diff := cmp.Diff(db.apps, tc.want, cmpopts.IgnoreUnexported(MetricPattern{})); diff != "" {
 				t.Errorf("unexpected end state. Diff: (-got +want): %s", diff)
 			}
 		})
 	}
 }
+
+func TestMetricsDB_UpdateAppData(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/manifest.json":
+			fmt.Fprint(w, `{"metricsApps":["foo","bar"]}`)
+		case "/foo/metrics.json", "/bar/metrics.json":
+			fmt.Fprint(w, `{"metrics":["metric1"]}`)
+		case "/foo/data.json":
+			fmt.Fprint(w, `{"appId":"foo","appName":"Foo","appRepoUrl":"https://github.com/abcxyz/foo","currentVersion":"1.0.0"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	db := &MetricsDB{}
+	if err := db.Update(context.Background(), &MetricsLoadParams{ServerURL: ts.URL, Client: http.DefaultClient}); err != nil {
+		t.Fatalf("unexpected error updating db: %s", err.Error())
+	}
+
+	got, err := db.GetAppData("foo")
+	if err != nil {
+		t.Fatalf("unexpected error getting app data: %s", err.Error())
+	}
+	want := &AppData{
+		AppID: "foo",
+		Data: &updater.AppResponse{
+			AppID:          "foo",
+			AppName:        "Foo",
+			AppRepoURL:     "https://github.com/abcxyz/foo",
+			CurrentVersion: "1.0.0",
+		},
+	}
+	if diff := cmp.Diff(got, want, cmpopts.IgnoreUnexported(AppData{})); diff != "" {
+		t.Errorf("unexpected app data (-got +want): %s", diff)
+	}
+
+	if _, err := db.GetAppData("bar"); err == nil {
+		t.Errorf("expected error getting app data for app without data.json")
+	}
+}
+
+func TestMetricsDB_UpdateOwners(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/manifest.json":
+			fmt.Fprint(w, `{"metricsApps":["foo","bar","baz"],"owners":{"team-a":{"apps":["foo","bar"],"logging":{"level":"DEBUG","sink":"team_a"}}}}`)
+		case "/foo/metrics.json", "/baz/metrics.json":
+			fmt.Fprint(w, `{"metrics":["metric1"]}`)
+		case "/bar/metrics.json":
+			fmt.Fprint(w, `{"metrics":["metric1"],"logging":{"sink":"bar_only"}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	db := &MetricsDB{}
+	if err := db.Update(context.Background(), &MetricsLoadParams{ServerURL: ts.URL, Client: http.DefaultClient}); err != nil {
+		t.Fatalf("unexpected error updating db: %s", err.Error())
+	}
+
+	cases := []struct {
+		appID     string
+		wantOwner string
+		wantLevel slog.Level
+		wantSink  string
+	}{
+		{appID: "foo", wantOwner: "team-a", wantLevel: slog.LevelDebug, wantSink: "team_a"},
+		{appID: "bar", wantOwner: "team-a", wantLevel: slog.LevelDebug, wantSink: "bar_only"},
+		{appID: "baz", wantLevel: slog.LevelInfo},
+	}
+	for _, tc := range cases {
+		got, err := db.GetAllowedMetrics(tc.appID)
+		if err != nil {
+			t.Fatalf("unexpected error getting %s: %s", tc.appID, err.Error())
+		}
+		if got.Owner != tc.wantOwner || got.Level != tc.wantLevel || got.Sink != tc.wantSink {
+			t.Errorf("%s: got owner %q level %s sink %q, want owner %q level %s sink %q",
+				tc.appID, got.Owner, got.Level, got.Sink, tc.wantOwner, tc.wantLevel, tc.wantSink)
+		}
+	}
+}
+
+func TestMetricsDB_UpdateParallelism(t *testing.T) {
+	t.Parallel()
+
+	const parallelism = 3
+	allowed := make(map[string]*AllowedMetricsResponse)
+	for i := 0; i < 20; i++ {
+		allowed[fmt.Sprintf("app%d", i)] = &AllowedMetricsResponse{Metrics: []string{"metric1"}}
+	}
+	ts := setupTestServer(t, allowed, 0)
+
+	var mu sync.Mutex
+	var inFlight, maxInFlight int
+	client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if !strings.HasSuffix(r.URL.Path, "/metrics.json") {
+			return http.DefaultTransport.RoundTrip(r) //nolint:wrapcheck
+		}
+		mu.Lock()
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}()
+		time.Sleep(5 * time.Millisecond)
+		return http.DefaultTransport.RoundTrip(r) //nolint:wrapcheck
+	})}
+
+	db := &MetricsDB{}
+	if err := db.Update(context.Background(), &MetricsLoadParams{ServerURL: ts.URL, Client: client, Parallelism: parallelism}); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if got, want := len(db.ListApps()), len(allowed); got != want {
+		t.Errorf("unexpected number of apps loaded. got %d want %d", got, want)
+	}
+	if maxInFlight > parallelism {
+		t.Errorf("fetched %d apps at once, want at most %d", maxInFlight, parallelism)
+	}
+	if maxInFlight < 2 {
+		t.Errorf("expected apps to be fetched in parallel, got at most %d at once", maxInFlight)
+	}
+}
+
+// roundTripFunc adapts a function to http.RoundTripper.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestMetricsDB_UpdateIncremental(t *testing.T) {
+	t.Parallel()
+
+	ren, err := renderer.New(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("failed to setup test: %s", err.Error())
+	}
+
+	var mu sync.Mutex
+	manifest := &ManifestResponse{
+		MetricsApps: []string{"foo", "bar"},
+		Hashes:      map[string]string{"foo": "foo1", "bar": "bar1"},
+		Generation:  "1",
+	}
+	allowed := map[string]*AllowedMetricsResponse{
+		"foo": {Metrics: []string{"metric1"}},
+		"bar": {Metrics: []string{"metric1"}},
+	}
+	var fetches []string
+	var gotSince []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path == "/manifest.json" {
+			gotSince = append(gotSince, r.URL.Query().Get("changes-since"))
+			ren.RenderJSON(w, http.StatusOK, manifest)
+			return
+		}
+		app, file, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		if file != "metrics.json" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fetches = append(fetches, app)
+		ren.RenderJSON(w, http.StatusOK, allowed[app])
+	}))
+	t.Cleanup(ts.Close)
+
+	// update runs Update and returns the apps fetched, sorted.
+	db := &MetricsDB{}
+	params := &MetricsLoadParams{ServerURL: ts.URL, Client: http.DefaultClient, ChangesSince: true}
+	update := func() []string {
+		t.Helper()
+		if err := db.Update(context.Background(), params); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		got := fetches
+		fetches = nil
+		slices.Sort(got)
+		return got
+	}
+
+	if diff := cmp.Diff(update(), []string{"bar", "foo"}); diff != "" {
+		t.Errorf("unexpected fetches on first update (-got,+want): %s", diff)
+	}
+	if diff := cmp.Diff(update(), []string(nil)); diff != "" {
+		t.Errorf("unexpected fetches with unchanged hashes (-got,+want): %s", diff)
+	}
+
+	mu.Lock()
+	manifest.Hashes["foo"] = "foo2"
+	allowed["foo"] = &AllowedMetricsResponse{Metrics: []string{"metric1", "metric2"}}
+	mu.Unlock()
+	if diff := cmp.Diff(update(), []string{"foo"}); diff != "" {
+		t.Errorf("unexpected fetches with changed hash (-got,+want): %s", diff)
+	}
+	if _, ok := db.apps["foo"].Allowed["metric2"]; !ok {
+		t.Errorf("expected changed definition to be loaded, got %v", db.apps["foo"].Allowed)
+	}
+	if _, ok := db.apps["bar"]; !ok {
+		t.Errorf("expected unchanged app to still be loaded")
+	}
+
+	// A delta manifest lists the changed apps, and hashes are not needed.
+	mu.Lock()
+	manifest = &ManifestResponse{
+		MetricsApps: []string{"foo", "bar"},
+		Generation:  "2",
+		Delta:       true,
+		Changed:     []string{"bar"},
+	}
+	mu.Unlock()
+	if diff := cmp.Diff(update(), []string{"bar"}); diff != "" {
+		t.Errorf("unexpected fetches with delta manifest (-got,+want): %s", diff)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if diff := cmp.Diff(gotSince, []string{"", "1", "1", "1"}); diff != "" {
+		t.Errorf("unexpected changes-since queries (-got,+want): %s", diff)
+	}
+}
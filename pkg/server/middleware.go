@@ -0,0 +1,191 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/abcxyz/pkg/logging"
+
+	"github.com/abcxyz/abc-updater/pkg/apierror"
+)
+
+// RequestIDHeader carries the ID of a request. An ID sent by the client, e.g.
+// by a load balancer, is kept; otherwise one is generated. Either way it is
+// echoed in the response.
+const RequestIDHeader = "X-Request-Id"
+
+// maxRequestIDLength is the longest client request ID which is kept.
+const maxRequestIDLength = 128
+
+// handlerPanics counts panics recovered by Recover.
+var handlerPanics = DefaultRegistry.NewCounter("abc_updater_handler_panics_total",
+	"Panics in request handlers, which were answered with a 500.")
+
+// Middleware wraps a handler, e.g. with logging or authentication.
+type Middleware func(next http.Handler) http.Handler
+
+// Chain returns h wrapped by mws. The first middleware is the outermost, so
+// it sees requests first and responses last.
+func Chain(h http.Handler, mws ...Middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// requestIDKey is the context key for the request ID.
+type requestIDKey struct{}
+
+// RequestIDFromContext returns the ID set by RequestID, or "" if there is none.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// RequestID is a Middleware which assigns each request an ID, available from
+// RequestIDFromContext and added as "request_id" to the context's logger, so
+// every log for the request can be found together.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		ctx = logging.WithLogger(ctx, logging.FromContext(ctx).With("request_id", id))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// validRequestID returns true if id is short, printable ASCII, so it is safe
+// to log and echo.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < '!' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns a random 128-bit ID in hex.
+func newRequestID() string {
+	var b [16]byte
+	// crypto/rand.Read never returns an error on supported platforms.
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// AccessLog is a Middleware which logs each request once it is served, with
+// its status, response size, and latency.
+func AccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rw, r)
+
+		ctx := r.Context()
+		logging.FromContext(ctx).InfoContext(ctx, "request served",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rw.statusCode(),
+			"bytes", rw.bytes,
+			"latency_ms", time.Since(start).Milliseconds(),
+			"user_agent", r.UserAgent())
+	})
+}
+
+// Recover returns a Middleware which recovers from panics in handlers, logs
+// them with their stack trace, and answers with a 500 JSON error rendered by
+// h, if the response has not started. http.ErrAbortHandler is passed on, as
+// it is used to deliberately abort a response.
+func Recover(h JSONRenderer) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rw := &statusRecorder{ResponseWriter: w}
+			defer func() {
+				p := recover()
+				if p == nil {
+					return
+				}
+				if p == http.ErrAbortHandler { //nolint:errorlint,err113 // Sentinel passed to panic as-is.
+					panic(p)
+				}
+				handlerPanics.Inc()
+				ctx := r.Context()
+				logging.FromContext(ctx).ErrorContext(ctx, "handler panicked",
+					"method", r.Method,
+					"path", r.URL.Path,
+					"panic", fmt.Sprint(p),
+					"stack", string(debug.Stack()))
+				if rw.status != 0 {
+					// Too late to change the response.
+					return
+				}
+				h.RenderJSON(rw, http.StatusInternalServerError, apierror.New(apierror.CodeUnknown, "internal error"))
+			}()
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
+
+// statusRecorder records the status and size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += int64(n)
+	return n, err //nolint:wrapcheck // Want passthrough error.
+}
+
+// Unwrap returns the underlying ResponseWriter, for http.ResponseController.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// statusCode returns the status of the response, which is 200 if the handler
+// wrote nothing.
+func (s *statusRecorder) statusCode() int {
+	if s.status == 0 {
+		return http.StatusOK
+	}
+	return s.status
+}
@@ -0,0 +1,168 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/abcxyz/abc-updater/pkg/apierror"
+)
+
+func TestChain(t *testing.T) {
+	t.Parallel()
+
+	var got []string
+	mw := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = append(got, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	h := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, "handler")
+	}), mw("outer"), mw("inner"))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if diff := cmp.Diff(got, []string{"outer", "inner", "handler"}); diff != "" {
+		t.Errorf("unexpected order (-got,+want): %s", diff)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		clientID string
+		wantKept bool
+	}{
+		{name: "generated"},
+		{name: "kept", clientID: "lb-1234", wantKept: true},
+		{name: "unprintable", clientID: "a\x00b"},
+		{name: "spaces", clientID: "a b"},
+		{name: "too_long", clientID: strings.Repeat("a", maxRequestIDLength+1)},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var ctxID string
+			h := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ctxID = RequestIDFromContext(r.Context())
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.clientID != "" {
+				req.Header.Set(RequestIDHeader, tc.clientID)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			got := w.Header().Get(RequestIDHeader)
+			if got != ctxID {
+				t.Errorf("response ID %q does not match context ID %q", got, ctxID)
+			}
+			if tc.wantKept {
+				if got != tc.clientID {
+					t.Errorf("unexpected request ID. got %q want %q", got, tc.clientID)
+				}
+			} else if len(got) != 32 {
+				t.Errorf("expected a generated request ID, got %q", got)
+			}
+		})
+	}
+}
+
+func TestRecover(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name       string
+		handler    http.HandlerFunc
+		wantStatus int
+		wantCode   apierror.Code
+		wantPanic  bool
+	}{
+		{
+			name:       "no_panic",
+			handler:    func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusAccepted) },
+			wantStatus: http.StatusAccepted,
+		},
+		{
+			name:       "panic",
+			handler:    func(w http.ResponseWriter, r *http.Request) { panic("boom") },
+			wantStatus: http.StatusInternalServerError,
+			wantCode:   apierror.CodeUnknown,
+			wantPanic:  true,
+		},
+		{
+			name: "panic_after_response_started",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+				panic("boom")
+			},
+			wantStatus: http.StatusOK,
+			wantPanic:  true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			before := handlerPanics.Value()
+			w := httptest.NewRecorder()
+			Recover(&JSONResponder{})(tc.handler).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got, want := w.Code, tc.wantStatus; got != want {
+				t.Errorf("unexpected status. got %d want %d", got, want)
+			}
+			if tc.wantCode != "" {
+				var body apierror.Response
+				if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+					t.Fatalf("failed to decode error response: %s", err.Error())
+				}
+				if got, want := body.Code, tc.wantCode; got != want {
+					t.Errorf("unexpected error code. got %q want %q", got, want)
+				}
+			}
+			if tc.wantPanic && handlerPanics.Value() <= before {
+				t.Errorf("expected panic to be counted")
+			}
+		})
+	}
+}
+
+func TestRecover_AbortHandler(t *testing.T) {
+	t.Parallel()
+
+	h := Recover(&JSONResponder{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	defer func() {
+		if p := recover(); p != http.ErrAbortHandler { //nolint:errorlint // Sentinel passed to panic as-is.
+			t.Errorf("expected http.ErrAbortHandler to be re-panicked, got %v", p)
+		}
+	}()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
@@ -0,0 +1,154 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"io/fs"
+	"net/http"
+
+	"github.com/abcxyz/pkg/renderer"
+)
+
+// MuxConfig is the configuration for NewMux. DB, Refresher, and Sink are
+// required; routes whose other fields are unset are not served.
+type MuxConfig struct {
+	// DB holds the loaded app metadata, kept current by Refresher.
+	DB        *MetricsDB
+	Refresher *Refresher
+
+	// Sink receives accepted metrics, already wrapped as needed, e.g. by
+	// ScrubSink and StatsSink.
+	Sink MetricSink
+
+	// Optional JSON renders the API responses. Defaults to a JSONResponder.
+	JSON JSONRenderer
+
+	// Optional Pages renders the homepage from DashboardTemplate, and
+	// Static serves its assets. The homepage is not served if Pages is nil.
+	Pages  *renderer.Renderer
+	Static fs.FS
+
+	// Optional Stats serves the stats API. If Dashboard is also true, the
+	// homepage shows the stats.
+	Stats     StatsStore
+	Dashboard bool
+
+	// Optional Anomalies, Shadow, and Publisher serve the anomaly report, the
+	// shadow sink status, and version publishing.
+	Anomalies *AnomalySink
+	Shadow    *ShadowSink
+	Publisher *Publisher
+
+	// Optional LoadShedder and RollcallLimiter limit requests.
+	LoadShedder     *LoadShedder
+	RollcallLimiter *RateLimiter
+
+	// CORSOrigins, AdminToken, OwnerTokens, and StatsTokens are as
+	// ABC_UPDATER_METRICS_CORS_ORIGINS etc. in cmd/main.go.
+	CORSOrigins []string
+	AdminToken  string
+	OwnerTokens map[string]string
+	StatsTokens map[string]string
+
+	// Optional Middleware wraps the routes, inside the standard middleware,
+	// with the first the outermost.
+	Middleware []Middleware
+}
+
+// NewMux returns a handler serving the metrics server's routes: metrics,
+// app data, health checks, debug and admin endpoints, and the homepage. It
+// lets other services mount the server, e.g. under a path prefix with
+// http.StripPrefix, rather than running cmd/main.go.
+//
+// Every request is given an ID, logged once served, and recovered from
+// panics with a 500; see RequestID, AccessLog, and Recover. Client version
+// checks and fault injection are not included; wrap the handler with
+// RequireMinClientVersion and Chaos.Wrap as needed.
+func NewMux(cfg *MuxConfig) http.Handler {
+	db, refresher, sink := cfg.DB, cfg.Refresher, cfg.Sink
+	h := cfg.JSON
+	if h == nil {
+		h = &JSONResponder{}
+	}
+	shedder := cfg.LoadShedder
+	if shedder == nil {
+		shedder = NewLoadShedder(0)
+	}
+	rollcallLimiter := cfg.RollcallLimiter
+	if rollcallLimiter == nil {
+		rollcallLimiter = NewRateLimiter(0, 0)
+	}
+
+	mux := http.NewServeMux()
+	sendMetrics := CORSHandler(cfg.CORSOrigins, shedder.Wrap(HandleMetricWithSink(h, db, sink)))
+	mux.Handle("POST /sendMetrics", sendMetrics)
+	if len(cfg.CORSOrigins) > 0 {
+		mux.Handle("OPTIONS /sendMetrics", sendMetrics)
+	}
+	mux.Handle("POST /sendMetrics/bulk", shedder.Wrap(HandleBulkMetrics(h, db, sink)))
+	mux.Handle("POST /rollcall", rollcallLimiter.Wrap(shedder.Wrap(HandleRollcall(h, db, sink))))
+	mux.Handle("GET /apps/{id}/data.json", shedder.Wrap(GzipHandler(HandleAppData(h, db))))
+	mux.Handle("GET /apps/{id}/templates/freshness", shedder.Wrap(HandleTemplateFreshness(h, db)))
+	mux.Handle("GET /healthz", HandleHealth(h))
+	schema := HandleSchema(h)
+	mux.Handle("GET /schema/{$}", schema)
+	mux.Handle("GET /schema/{name}", schema)
+	mux.Handle("GET /debug/metadata", HandleDebugMetadata(h, db))
+	mux.Handle("GET /debug/load", HandleLoadStatus(h, shedder))
+	if cfg.Shadow != nil {
+		mux.Handle("GET /debug/sinks", HandleShadowStatus(h, cfg.Shadow))
+	}
+	mux.Handle("GET /metrics", HandlePrometheus(DefaultRegistry))
+
+	mux.Handle("POST /admin/refresh", RequireAdminToken(h, cfg.AdminToken, HandleRefresh(h, refresher)))
+	mux.Handle("GET /admin/refresh", RequireAdminToken(h, cfg.AdminToken, HandleRefreshStatus(h, refresher)))
+	auth := &TenantAuth{AdminToken: cfg.AdminToken, OwnerTokens: cfg.OwnerTokens}
+	mux.Handle("GET /admin/apps", RequireScope(h, auth, db, HandleAdminApps(h, db, refresher)))
+	mux.Handle("GET /admin/apps/{id}", RequireScope(h, auth, db, HandleAdminApp(h, db, refresher)))
+	if cfg.Publisher != nil {
+		mux.Handle("POST /admin/apps/{id}/version", RequireScope(h, auth, db, HandlePublishVersion(h, db, cfg.Publisher)))
+	}
+	if cfg.Stats != nil {
+		mux.Handle("GET /v1/apps/{id}/stats", RequireAppToken(h, auth, db, cfg.StatsTokens, HandleAppStats(h, db, cfg.Stats)))
+	}
+	if cfg.Anomalies != nil {
+		mux.Handle("GET /v1/apps/{id}/anomalies", RequireAppToken(h, auth, db, cfg.StatsTokens, HandleAnomalyReport(h, db, cfg.Anomalies)))
+	}
+
+	if cfg.Pages != nil {
+		var dashboardStats StatsStore
+		if cfg.Dashboard {
+			dashboardStats = cfg.Stats
+		}
+		homepage := GzipHandler(HandleDashboard(cfg.Pages, db, refresher, dashboardStats))
+		// Homepage. Don't handle /* as we want 405 rather than 404 on POST
+		// /sendMetrics and would rather not implement ourselves.
+		mux.Handle("GET /{$}", homepage)
+		mux.Handle("GET /index.html", homepage)
+		if cfg.Static != nil {
+			mux.Handle("/assets/", GzipHandler(http.FileServerFS(cfg.Static)))
+		}
+	}
+
+	mws := append([]Middleware{RequestID, AccessLog, Recover(h)}, cfg.Middleware...)
+	return Chain(mux, mws...)
+}
+
+// HandleHealth returns a handler which reports that the server is serving.
+func HandleHealth(h JSONRenderer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.RenderJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+}
@@ -0,0 +1,133 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewMux(t *testing.T) {
+	t.Parallel()
+
+	db := testAdminDB(t)
+	sink := &testSink{}
+	mux := NewMux(&MuxConfig{
+		DB:         db,
+		Refresher:  NewRefresher(db, &MetricsLoadParams{}, time.Minute),
+		Sink:       sink,
+		AdminToken: "secret",
+	})
+	// Mounted under a prefix, as by an embedding service.
+	outer := http.NewServeMux()
+	outer.Handle("/updater/", http.StripPrefix("/updater", mux))
+
+	cases := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		token      string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "health",
+			method:     http.MethodGet,
+			path:       "/updater/healthz",
+			wantStatus: http.StatusOK,
+			wantBody:   `{"status":"ok"}` + "\n",
+		},
+		{
+			name:       "send_metrics",
+			method:     http.MethodPost,
+			path:       "/updater/sendMetrics",
+			body:       `{"appId":"foo","appVersion":"1.0","installId":"i","metrics":{"run":1}}`,
+			wantStatus: http.StatusAccepted,
+		},
+		{
+			name:       "send_metrics_wrong_method",
+			method:     http.MethodGet,
+			path:       "/updater/sendMetrics",
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:       "schema",
+			method:     http.MethodGet,
+			path:       "/updater/schema/data.json",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "app_data_unknown_app",
+			method:     http.MethodGet,
+			path:       "/updater/apps/baz/data.json",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "admin_without_token",
+			method:     http.MethodGet,
+			path:       "/updater/admin/apps",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "admin",
+			method:     http.MethodGet,
+			path:       "/updater/admin/apps/foo",
+			token:      "secret",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "stats_not_configured",
+			method:     http.MethodGet,
+			path:       "/updater/v1/apps/foo/stats",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "homepage_not_configured",
+			method:     http.MethodGet,
+			path:       "/updater/",
+			wantStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			w := httptest.NewRecorder()
+			outer.ServeHTTP(w, req)
+
+			if got, want := w.Code, tc.wantStatus; got != want {
+				t.Errorf("unexpected status. got %d want %d: %s", got, want, w.Body.String())
+			}
+			if w.Header().Get(RequestIDHeader) == "" {
+				t.Errorf("expected %s header", RequestIDHeader)
+			}
+			if tc.wantBody != "" {
+				if got, want := w.Body.String(), tc.wantBody; got != want {
+					t.Errorf("unexpected body. got %q want %q", got, want)
+				}
+			}
+		})
+	}
+}
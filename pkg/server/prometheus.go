@@ -0,0 +1,157 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/abcxyz/pkg/logging"
+)
+
+// prometheusContentType is the content type of the Prometheus text format.
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultRegistry holds the server's own counters, such as rejected requests.
+var DefaultRegistry = &Registry{}
+
+// Registry holds counters exposed by HandlePrometheus.
+type Registry struct {
+	mu       sync.Mutex
+	counters []*Counter
+}
+
+// NewCounter registers and returns a counter with the given name, help text,
+// and label names. Every call to Add must give a value for each label.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]int64),
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.counters = append(r.counters, c)
+	return c
+}
+
+// WriteText writes every counter to w in the Prometheus text format.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	counters := slices.Clone(r.counters)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, c := range counters {
+		c.writeText(bw)
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	return nil
+}
+
+// Counter is a monotonically increasing count for each combination of label
+// values.
+type Counter struct {
+	name   string
+	help   string
+	labels []string
+
+	mu sync.Mutex
+	// values are keyed by label values joined with labelSeparator.
+	values map[string]int64
+}
+
+// labelSeparator joins label values in Counter keys. Label values may not
+// contain it.
+const labelSeparator = "\xff"
+
+// labelEscaper escapes label values for the Prometheus text format.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Inc adds one to the count for labelValues.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds n to the count for labelValues, which must be given in the same
+// order as the counter's label names.
+func (c *Counter) Add(n int64, labelValues ...string) {
+	key := strings.Join(labelValues, labelSeparator)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[key] += n
+}
+
+// Value returns the count for labelValues.
+func (c *Counter) Value(labelValues ...string) int64 {
+	key := strings.Join(labelValues, labelSeparator)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[key]
+}
+
+// writeText writes the counter's help, type, and values, sorted by label
+// values so output is stable.
+func (c *Counter) writeText(w *bufio.Writer) {
+	c.mu.Lock()
+	values := make(map[string]int64, len(c.values))
+	keys := make([]string, 0, len(c.values))
+	for k, v := range c.values {
+		values[k] = v
+		keys = append(keys, k)
+	}
+	c.mu.Unlock()
+	slices.Sort(keys)
+
+	fmt.Fprintf(w, "# HELP %s %s\n", c.name, c.help)
+	fmt.Fprintf(w, "# TYPE %s counter\n", c.name)
+	for _, k := range keys {
+		w.WriteString(c.name)
+		if len(c.labels) > 0 {
+			w.WriteByte('{')
+			for i, v := range strings.Split(k, labelSeparator) {
+				if i >= len(c.labels) {
+					break
+				}
+				if i > 0 {
+					w.WriteByte(',')
+				}
+				fmt.Fprintf(w, "%s=\"%s\"", c.labels[i], labelEscaper.Replace(v))
+			}
+			w.WriteByte('}')
+		}
+		fmt.Fprintf(w, " %d\n", values[k])
+	}
+}
+
+// HandlePrometheus returns a handler which renders the counters in r in the
+// Prometheus text format, for scraping.
+func HandlePrometheus(r *Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", prometheusContentType)
+		if err := r.WriteText(w); err != nil {
+			logging.FromContext(req.Context()).WarnContext(req.Context(), "failed to write prometheus metrics",
+				"error", err.Error())
+		}
+	})
+}
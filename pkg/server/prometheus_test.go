@@ -0,0 +1,77 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/abcxyz/pkg/renderer"
+)
+
+func TestHandlePrometheus(t *testing.T) {
+	t.Parallel()
+
+	r := &Registry{}
+	rejected := r.NewCounter("test_rejections_total", "Rejected requests.", "status", "code")
+	rejected.Inc("400", "MALFORMED_JSON")
+	rejected.Add(2, "415", `UNSUPPORTED "x"`)
+	rejected.Inc("400", "MALFORMED_JSON")
+	r.NewCounter("test_empty_total", "Never incremented.")
+
+	w := httptest.NewRecorder()
+	HandlePrometheus(r).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if got, want := w.Header().Get("Content-Type"), prometheusContentType; got != want {
+		t.Errorf("unexpected content type. got %q want %q", got, want)
+	}
+	want := `# HELP test_rejections_total Rejected requests.
+# TYPE test_rejections_total counter
+test_rejections_total{status="400",code="MALFORMED_JSON"} 2
+test_rejections_total{status="415",code="UNSUPPORTED \"x\""} 2
+# HELP test_empty_total Never incremented.
+# TYPE test_empty_total counter
+`
+	if diff := cmp.Diff(w.Body.String(), want); diff != "" {
+		t.Errorf("unexpected body (-got,+want): %s", diff)
+	}
+}
+
+func TestDecodeRequest_CountsRejections(t *testing.T) {
+	t.Parallel()
+
+	h, err := renderer.New(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("failed to setup test: %s", err.Error())
+	}
+	before := decodeRejections.Value("400", "TRAILING_DATA")
+
+	req := httptest.NewRequest(http.MethodPost, "/sendMetrics", strings.NewReader(`{}{}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	if _, err := DecodeRequest[struct{}](context.Background(), w, req, h); err == nil {
+		t.Fatal("expected error for trailing data")
+	}
+	if got, want := w.Code, http.StatusBadRequest; got != want {
+		t.Errorf("unexpected response code. got %d want %d", got, want)
+	}
+	if got, want := decodeRejections.Value("400", "TRAILING_DATA"), before+1; got != want {
+		t.Errorf("unexpected rejection count. got %d want %d", got, want)
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/go-version"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+	"github.com/abcxyz/abc-updater/pkg/apierror"
+	"github.com/abcxyz/pkg/logging"
+)
+
+// slackWebhookPrefix marks a webhook as Slack incoming webhook in
+// ParseWebhook.
+const slackWebhookPrefix = "slack:"
+
+// WebhookFormat is the payload format sent to a webhook.
+type WebhookFormat string
+
+const (
+	// WebhookFormatJSON sends a Release as JSON.
+	WebhookFormatJSON WebhookFormat = "json"
+
+	// WebhookFormatSlack sends a Slack incoming webhook message.
+	WebhookFormatSlack WebhookFormat = "slack"
+)
+
+// Webhook is a URL notified of new releases.
+type Webhook struct {
+	URL    string
+	Format WebhookFormat
+}
+
+// ParseWebhook parses a webhook URL. URLs prefixed with "slack:" are Slack
+// incoming webhooks; others receive a Release as JSON.
+func ParseWebhook(s string) (*Webhook, error) {
+	format := WebhookFormatJSON
+	if rest, ok := strings.CutPrefix(s, slackWebhookPrefix); ok {
+		format, s = WebhookFormatSlack, rest
+	}
+	if !strings.HasPrefix(s, "https://") && !strings.HasPrefix(s, "http://") {
+		return nil, fmt.Errorf("webhook %q must be an http or https url", s)
+	}
+	return &Webhook{URL: s, Format: format}, nil
+}
+
+// Release announces a new version of an app.
+type Release struct {
+	AppID           string `json:"appId"`
+	AppName         string `json:"appName,omitempty"`
+	AppRepoURL      string `json:"appRepoUrl,omitempty"`
+	Version         string `json:"version"`
+	PreviousVersion string `json:"previousVersion,omitempty"`
+}
+
+// Publisher announces new app versions to webhooks. Versions are tracked in
+// memory, so each replica announces independently.
+type Publisher struct {
+	Webhooks []*Webhook
+
+	// Optional client used to make requests. Defaults to http.DefaultClient.
+	Client *http.Client
+
+	mu       sync.Mutex
+	versions map[string]string
+}
+
+// Observe records the current version of an app, as loaded from metadata, and
+// announces it in the background if it changed since the last observed or
+// published version. The first version observed for an app is not
+// announced, so restarts don't re-announce every app.
+func (p *Publisher) Observe(ctx context.Context, data *api.AppResponse) {
+	release, ok := p.record(data, data.CurrentVersion)
+	if !ok || release.PreviousVersion == "" {
+		return
+	}
+	// Don't let the end of a refresh request cancel delivery.
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		if err := p.announce(ctx, release); err != nil {
+			logging.FromContext(ctx).WarnContext(ctx, "failed to announce release",
+				"app_id", release.AppID,
+				"version", release.Version,
+				"error", err.Error())
+		}
+	}()
+}
+
+// Publish announces newVersion of an app, if it differs from the last
+// observed or published version. data provides app details and may be
+// nil. Returns true if the release was announced.
+func (p *Publisher) Publish(ctx context.Context, appID string, data *api.AppResponse, newVersion string) (bool, error) {
+	if data == nil {
+		data = &api.AppResponse{}
+	}
+	info := *data
+	info.AppID = appID
+	release, ok := p.record(&info, newVersion)
+	if !ok {
+		return false, nil
+	}
+	return true, p.announce(ctx, release)
+}
+
+// record updates the known version of an app, returning the Release if it
+// changed.
+func (p *Publisher) record(data *api.AppResponse, newVersion string) (*Release, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.versions == nil {
+		p.versions = make(map[string]string)
+	}
+	old := p.versions[data.AppID]
+	if old == newVersion {
+		return nil, false
+	}
+	p.versions[data.AppID] = newVersion
+	return &Release{
+		AppID:           data.AppID,
+		AppName:         data.AppName,
+		AppRepoURL:      data.AppRepoURL,
+		Version:         newVersion,
+		PreviousVersion: old,
+	}, true
+}
+
+// announce sends release to every webhook, returning all errors.
+func (p *Publisher) announce(ctx context.Context, release *Release) error {
+	var errs []error
+	for _, wh := range p.Webhooks {
+		if err := p.send(ctx, wh, release); err != nil {
+			errs = append(errs, fmt.Errorf("webhook %s: %w", wh.URL, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// send posts release to a single webhook.
+func (p *Publisher) send(ctx context.Context, wh *Webhook, release *Release) error {
+	var payload any = release
+	if wh.Format == WebhookFormatSlack {
+		payload = map[string]string{"text": slackText(release)}
+	}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wh.URL, bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorResponseBytes))
+		return fmt.Errorf("not a 2xx response: %d %s", resp.StatusCode, string(b))
+	}
+	return nil
+}
+
+// slackText formats release as a Slack message.
+func slackText(r *Release) string {
+	name := r.AppName
+	if name == "" {
+		name = r.AppID
+	}
+	text := fmt.Sprintf("%s %s has been released", name, r.Version)
+	if r.PreviousVersion != "" {
+		text += fmt.Sprintf(" (previously %s)", r.PreviousVersion)
+	}
+	if r.AppRepoURL != "" {
+		text += ": " + r.AppRepoURL
+	}
+	return text
+}
+
+// publishVersionRequest is the body of a request to HandlePublishVersion.
+type publishVersionRequest struct {
+	CurrentVersion string `json:"currentVersion"`
+}
+
+// PublishVersionResponse is rendered by HandlePublishVersion.
+type PublishVersionResponse struct {
+	// Announced is false if the version was already known.
+	Announced bool   `json:"announced"`
+	Error     string `json:"error,omitempty"`
+}
+
+// HandlePublishVersion returns a handler which announces the version in the
+// request body for the app in the "id" path value, e.g. from a release
+// pipeline, without waiting for the next metadata refresh. It should be
+// registered behind RequireAdminToken or RequireScope.
+func HandlePublishVersion(h JSONRenderer, db AppDataLookuper, p *Publisher) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, err := DecodeRequest[publishVersionRequest](r.Context(), w, r, h)
+		if err != nil {
+			// Error response already handled by DecodeRequest.
+			return
+		}
+		if _, err := version.NewVersion(req.CurrentVersion); err != nil {
+			h.RenderJSON(w, http.StatusBadRequest, apierror.New(apierror.CodeMalformedRequest, "invalid currentVersion %q", req.CurrentVersion))
+			return
+		}
+
+		appID := r.PathValue("id")
+		data, err := db.GetAppData(appID)
+		if err != nil {
+			h.RenderJSON(w, http.StatusNotFound, apierror.New(apierror.CodeUnknownApp, "unknown app %q", appID))
+			return
+		}
+
+		announced, err := p.Publish(r.Context(), appID, data.Data, req.CurrentVersion)
+		if err != nil {
+			logging.FromContext(r.Context()).WarnContext(r.Context(), "failed to announce release",
+				"app_id", appID,
+				"version", req.CurrentVersion,
+				"error", err.Error())
+			h.RenderJSON(w, http.StatusBadGateway, &PublishVersionResponse{Announced: announced, Error: err.Error()})
+			return
+		}
+		h.RenderJSON(w, http.StatusOK, &PublishVersionResponse{Announced: announced})
+	})
+}
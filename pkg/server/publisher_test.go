@@ -0,0 +1,258 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+	"github.com/abcxyz/pkg/renderer"
+	"github.com/abcxyz/pkg/testutil"
+)
+
+// webhookServer returns a server which sends each request body it receives on
+// the returned channel.
+func webhookServer(tb testing.TB, status int) (*httptest.Server, <-chan map[string]any) {
+	tb.Helper()
+
+	bodies := make(chan map[string]any, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			tb.Errorf("failed to decode webhook body: %s", err.Error())
+		}
+		bodies <- body
+		w.WriteHeader(status)
+	}))
+	tb.Cleanup(ts.Close)
+	return ts, bodies
+}
+
+func TestParseWebhook(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		in      string
+		want    *Webhook
+		wantErr string
+	}{
+		{
+			name: "generic",
+			in:   "https://example.com/hook",
+			want: &Webhook{URL: "https://example.com/hook", Format: WebhookFormatJSON},
+		},
+		{
+			name: "slack",
+			in:   "slack:https://hooks.slack.com/services/T/B/X",
+			want: &Webhook{URL: "https://hooks.slack.com/services/T/B/X", Format: WebhookFormatSlack},
+		},
+		{
+			name:    "invalid",
+			in:      "slack:hooks.slack.com",
+			wantErr: "must be an http or https url",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := ParseWebhook(tc.in)
+			if diff := testutil.DiffErrString(err, tc.wantErr); diff != "" {
+				t.Error(diff)
+			}
+			if diff := cmp.Diff(got, tc.want); diff != "" {
+				t.Errorf("unexpected webhook (-got,+want): %s", diff)
+			}
+		})
+	}
+}
+
+func TestPublisher_Observe(t *testing.T) {
+	t.Parallel()
+
+	ts, bodies := webhookServer(t, http.StatusOK)
+	p := &Publisher{Webhooks: []*Webhook{{URL: ts.URL, Format: WebhookFormatJSON}}}
+	ctx := context.Background()
+
+	// The first observation is not announced.
+	p.Observe(ctx, &api.AppResponse{AppID: "foo", CurrentVersion: "1.0.0"})
+	p.Observe(ctx, &api.AppResponse{AppID: "foo", AppName: "Foo", CurrentVersion: "1.1.0"})
+
+	select {
+	case got := <-bodies:
+		want := map[string]any{
+			"appId":           "foo",
+			"appName":         "Foo",
+			"version":         "1.1.0",
+			"previousVersion": "1.0.0",
+		}
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("unexpected webhook body (-got,+want): %s", diff)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for webhook")
+	}
+
+	select {
+	case got := <-bodies:
+		t.Errorf("unexpected extra webhook: %v", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestPublisher_Publish(t *testing.T) {
+	t.Parallel()
+
+	ts, bodies := webhookServer(t, http.StatusOK)
+	p := &Publisher{Webhooks: []*Webhook{{URL: ts.URL, Format: WebhookFormatSlack}}}
+	ctx := context.Background()
+	data := &api.AppResponse{AppName: "Foo", AppRepoURL: "https://github.com/abcxyz/foo"}
+
+	announced, err := p.Publish(ctx, "foo", data, "2.0.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if !announced {
+		t.Errorf("expected release to be announced")
+	}
+	if diff := cmp.Diff(<-bodies, map[string]any{"text": "Foo 2.0.0 has been released: https://github.com/abcxyz/foo"}); diff != "" {
+		t.Errorf("unexpected webhook body (-got,+want): %s", diff)
+	}
+
+	// Publishing the same version again is a noop.
+	announced, err = p.Publish(ctx, "foo", data, "2.0.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if announced {
+		t.Errorf("expected repeated release not to be announced")
+	}
+}
+
+func TestPublisher_PublishError(t *testing.T) {
+	t.Parallel()
+
+	ts, _ := webhookServer(t, http.StatusInternalServerError)
+	p := &Publisher{Webhooks: []*Webhook{{URL: ts.URL, Format: WebhookFormatJSON}}}
+
+	_, err := p.Publish(context.Background(), "foo", nil, "1.0.0")
+	if diff := testutil.DiffErrString(err, "not a 2xx response: 500"); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestHandlePublishVersion(t *testing.T) {
+	t.Parallel()
+
+	h, err := renderer.New(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("failed to setup test: %s", err.Error())
+	}
+	ts, bodies := webhookServer(t, http.StatusOK)
+	db := &MetricsDB{data: map[string]*AppData{
+		"foo": {AppID: "foo", Data: &api.AppResponse{AppID: "foo", CurrentVersion: "1.0.0"}},
+	}}
+	p := &Publisher{Webhooks: []*Webhook{{URL: ts.URL, Format: WebhookFormatJSON}}}
+	mux := http.NewServeMux()
+	mux.Handle("POST /admin/apps/{id}/version", HandlePublishVersion(h, db, p))
+
+	cases := []struct {
+		name          string
+		appID         string
+		body          string
+		wantStatus    int
+		wantAnnounced bool
+	}{
+		{
+			name:          "announced",
+			appID:         "foo",
+			body:          `{"currentVersion":"1.1.0"}`,
+			wantStatus:    http.StatusOK,
+			wantAnnounced: true,
+		},
+		{
+			name:       "invalid_version",
+			appID:      "foo",
+			body:       `{"currentVersion":"latest"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "unknown_app",
+			appID:      "bar",
+			body:       `{"currentVersion":"1.1.0"}`,
+			wantStatus: http.StatusNotFound,
+		},
+	}
+
+	// Cases are not parallel, as they share the publisher.
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/admin/apps/"+tc.appID+"/version", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+
+			if got, want := w.Code, tc.wantStatus; got != want {
+				t.Errorf("unexpected response code. got %d want %d", got, want)
+			}
+			if !tc.wantAnnounced {
+				return
+			}
+			var got PublishVersionResponse
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode response: %s", err.Error())
+			}
+			if !got.Announced {
+				t.Errorf("expected release to be announced")
+			}
+			if got, want := (<-bodies)["version"], "1.1.0"; got != want {
+				t.Errorf("unexpected announced version. got %v want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestChangedVersions(t *testing.T) {
+	t.Parallel()
+
+	oldData := map[string]*AppData{
+		"same":    {Data: &api.AppResponse{AppID: "same", CurrentVersion: "1.0.0"}},
+		"changed": {Data: &api.AppResponse{AppID: "changed", CurrentVersion: "1.0.0"}},
+	}
+	newData := map[string]*AppData{
+		"same":    {Data: &api.AppResponse{AppID: "same", CurrentVersion: "1.0.0"}},
+		"changed": {Data: &api.AppResponse{AppID: "changed", CurrentVersion: "1.1.0"}},
+		"new":     {Data: &api.AppResponse{AppID: "new", CurrentVersion: "0.1.0"}},
+	}
+
+	got := make(map[string]string)
+	for _, d := range changedVersions(oldData, newData) {
+		got[d.AppID] = d.CurrentVersion
+	}
+	want := map[string]string{"changed": "1.1.0", "new": "0.1.0"}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("unexpected changes (-got,+want): %s", diff)
+	}
+}
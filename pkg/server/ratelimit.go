@@ -0,0 +1,79 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"golang.org/x/time/rate"
+
+	"github.com/abcxyz/abc-updater/pkg/apierror"
+)
+
+// rateLimitRetryAfterSeconds is the Retry-After sent with rate limited
+// responses.
+const rateLimitRetryAfterSeconds = 1
+
+// rateLimitBody is the precomputed response body for rate limited requests.
+var rateLimitBody = func() []byte {
+	b, err := json.Marshal(apierror.New(apierror.CodeRateLimited, "too many requests, try again later"))
+	if err != nil {
+		panic(err)
+	}
+	return b
+}()
+
+// rateLimited counts requests rejected by a RateLimiter.
+var rateLimited = DefaultRegistry.NewCounter("abc_updater_rate_limited_total",
+	"Requests rejected because they exceeded a rate limit.")
+
+// RateLimiter limits the rate of requests across all clients. Unlike
+// LoadShedder, which bounds concurrency, it bounds requests per second, so
+// cheap but high-volume endpoints cannot flood the metric sink.
+type RateLimiter struct {
+	// limiter is nil if there is no limit.
+	limiter *rate.Limiter
+}
+
+// NewRateLimiter creates a RateLimiter allowing perSecond requests per second
+// on average, with bursts of up to burst requests. If perSecond is zero or
+// less there is no limit.
+func NewRateLimiter(perSecond float64, burst int) *RateLimiter {
+	l := &RateLimiter{}
+	if perSecond > 0 {
+		l.limiter = rate.NewLimiter(rate.Limit(perSecond), max(burst, 1))
+	}
+	return l
+}
+
+// Wrap returns next wrapped so requests over the rate are rejected with a 429.
+func (l *RateLimiter) Wrap(next http.Handler) http.Handler {
+	if l.limiter == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !l.limiter.Allow() {
+			rateLimited.Inc()
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Retry-After", strconv.Itoa(rateLimitRetryAfterSeconds))
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write(rateLimitBody) //nolint:errcheck // Nothing to do if the client went away.
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,107 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redisstore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/go-cmp/cmp"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+	"github.com/abcxyz/abc-updater/pkg/server"
+)
+
+func TestStore(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	s := New(redis.NewClient(&redis.Options{Addr: mr.Addr()}), "")
+	t.Cleanup(func() {
+		if err := s.Close(); err != nil {
+			t.Errorf("failed to close store: %s", err.Error())
+		}
+	})
+
+	got, err := s.LoadDefinitions(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if diff := cmp.Diff(got, map[string]*server.AllowedMetricsResponse{}); diff != "" {
+		t.Errorf("unexpected definitions before save (-got,+want): %s", diff)
+	}
+
+	want := map[string]*server.AllowedMetricsResponse{
+		"foo": {Metrics: []string{"run", "command.*"}},
+		"bar": {Metrics: []string{"init"}, Logging: &api.MetricLogging{Level: "DEBUG"}},
+	}
+	if err := s.SaveDefinitions(ctx, want); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	got, err = s.LoadDefinitions(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("unexpected definitions after save (-got,+want): %s", diff)
+	}
+	if !mr.Exists(DefaultKey) {
+		t.Errorf("expected definitions under %q", DefaultKey)
+	}
+}
+
+func TestStats(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	s := NewStats(redis.NewClient(&redis.Options{Addr: mr.Addr()}), "")
+	t.Cleanup(func() {
+		if err := s.Close(); err != nil {
+			t.Errorf("failed to close stats: %s", err.Error())
+		}
+	})
+
+	for _, m := range []*server.MetricRecord{
+		{AppID: "foo", AppVersion: "1.0.0", Name: "run", Count: 1},
+		{AppID: "foo", AppVersion: "1.0.0", Name: "run", Count: 2},
+		{AppID: "foo", AppVersion: "1.1.0", Name: "init", Count: 1},
+		{AppID: "bar", AppVersion: "1.0.0", Name: "run", Count: 1},
+	} {
+		if err := s.IncrementStats(ctx, "2024-01-02", m); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+	}
+
+	got, err := s.LoadStats(ctx, "foo", []string{"2024-01-01", "2024-01-02"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	want := map[string]server.DayStats{
+		"2024-01-02": {
+			"1.0.0": {"run": 3},
+			"1.1.0": {"init": 1},
+		},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("unexpected stats (-got,+want): %s", diff)
+	}
+	if ttl := mr.TTL(DefaultStatsPrefix + ":foo:2024-01-02"); ttl <= 0 {
+		t.Errorf("expected stats to expire, got ttl %s", ttl)
+	}
+}
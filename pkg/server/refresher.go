@@ -0,0 +1,275 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/abcxyz/pkg/logging"
+)
+
+// defaultRefreshJitter is the fraction by which each refresh interval is
+// randomly lengthened or shortened, so replicas don't refresh in lockstep.
+const defaultRefreshJitter = 0.1
+
+// Refresher periodically updates a MetricsLookuper. Requests made during
+// refresh are conditional on the ETag of the previous response, so unchanged
+// files are not re-downloaded.
+type Refresher struct {
+	db        MetricsLookuper
+	params    *MetricsLoadParams
+	transport *etagTransport
+	interval  time.Duration
+	jitter    float64
+
+	// refreshMu serializes refreshes.
+	refreshMu sync.Mutex
+
+	mu           sync.RWMutex
+	lastAttempt  time.Time
+	lastSuccess  time.Time
+	lastDuration time.Duration
+	lastErr      error
+}
+
+// RefreshStatus describes the health of a Refresher.
+type RefreshStatus struct {
+	LastAttempt time.Time `json:"lastAttempt"`
+	LastSuccess time.Time `json:"lastSuccess"`
+	// LastDuration is how long the last attempt took, e.g. "1.5s".
+	LastDuration string `json:"lastDuration,omitempty"`
+	LastError    string `json:"lastError,omitempty"`
+}
+
+// NewRefresher creates a Refresher which updates db every interval, plus or
+// minus 10% jitter. The HTTP client in params is wrapped to make conditional
+// requests; params itself is not modified.
+func NewRefresher(db MetricsLookuper, params *MetricsLoadParams, interval time.Duration) *Refresher {
+	client := http.DefaultClient
+	if params.Client != nil {
+		client = params.Client
+	}
+	transport := &etagTransport{base: client.Transport}
+	wrapped := *client
+	wrapped.Transport = transport
+
+	return &Refresher{
+		db:        db,
+		transport: transport,
+		params: &MetricsLoadParams{
+			ServerURL:    params.ServerURL,
+			Client:       &wrapped,
+			Parallelism:  params.Parallelism,
+			ChangesSince: params.ChangesSince,
+		},
+		interval: interval,
+		jitter:   defaultRefreshJitter,
+	}
+}
+
+// Refresh updates the db immediately, blocking until done. Concurrent calls
+// are serialized.
+func (r *Refresher) Refresh(ctx context.Context) error {
+	r.refreshMu.Lock()
+	defer r.refreshMu.Unlock()
+
+	now := time.Now()
+	r.transport.startRound()
+	err := r.db.Update(ctx, r.params)
+	duration := time.Since(now)
+	if err == nil {
+		// Files not requested by a complete refresh, e.g. those of removed
+		// apps, or changes since an older generation, won't be requested
+		// again.
+		r.transport.prune()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastAttempt = now
+	r.lastDuration = duration
+	r.lastErr = err
+	if err == nil {
+		r.lastSuccess = now
+	}
+	if err != nil {
+		return fmt.Errorf("failed to refresh metrics definitions: %w", err)
+	}
+	return nil
+}
+
+// Run refreshes the db on a jittered schedule until ctx is done.
+func (r *Refresher) Run(ctx context.Context) {
+	logger := logging.FromContext(ctx)
+	timer := time.NewTimer(r.nextInterval())
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			logger.DebugContext(ctx, "Updating metrics definitions.")
+			if err := r.Refresh(ctx); err != nil {
+				logger.WarnContext(ctx, "Error updating metrics definitions, will use cached definition if available.", "err", err.Error())
+			}
+			timer.Reset(r.nextInterval())
+		}
+	}
+}
+
+func (r *Refresher) nextInterval() time.Duration {
+	// Uniform in [interval*(1-jitter), interval*(1+jitter)).
+	f := 1 + r.jitter*(2*rand.Float64()-1) //nolint:gosec // Jitter need not be cryptographically secure.
+	return time.Duration(float64(r.interval) * f)
+}
+
+// Status returns the health of the Refresher.
+func (r *Refresher) Status() *RefreshStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	s := &RefreshStatus{
+		LastAttempt: r.lastAttempt,
+		LastSuccess: r.lastSuccess,
+	}
+	if !r.lastAttempt.IsZero() {
+		s.LastDuration = r.lastDuration.String()
+	}
+	if r.lastErr != nil {
+		s.LastError = r.lastErr.Error()
+	}
+	return s
+}
+
+// HandleRefresh returns a handler which triggers an immediate refresh and
+// renders the resulting status. It should be registered behind
+// RequireAdminToken.
+func HandleRefresh(h JSONRenderer, r *Refresher) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if err := r.Refresh(req.Context()); err != nil {
+			logging.FromContext(req.Context()).WarnContext(req.Context(), "manual refresh failed", "error", err.Error())
+			h.RenderJSON(w, http.StatusBadGateway, r.Status())
+			return
+		}
+		h.RenderJSON(w, http.StatusOK, r.Status())
+	})
+}
+
+// HandleRefreshStatus returns a handler which renders the refresh status.
+func HandleRefreshStatus(h JSONRenderer, r *Refresher) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		h.RenderJSON(w, http.StatusOK, r.Status())
+	})
+}
+
+// etagTransport makes GET requests conditional on the ETag of the last
+// successful response for the same URL. A 304 Not Modified response is
+// replaced with a 200 containing the cached body, so callers need not be aware
+// of caching.
+type etagTransport struct {
+	base http.RoundTripper
+
+	mu      sync.Mutex
+	entries map[string]*etagEntry
+	// round counts calls to startRound.
+	round uint64
+}
+
+type etagEntry struct {
+	etag string
+	body []byte
+	// round is the last round in which the entry was requested.
+	round uint64
+}
+
+// startRound begins a round of requests, such as a refresh.
+func (t *etagTransport) startRound() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.round++
+}
+
+// prune removes entries not requested since startRound was last called.
+func (t *etagTransport) prune() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key, entry := range t.entries {
+		if entry.round != t.round {
+			delete(t.entries, key)
+		}
+	}
+}
+
+func (t *etagTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if req.Method != http.MethodGet {
+		return base.RoundTrip(req) //nolint:wrapcheck // Want passthrough error.
+	}
+
+	key := req.URL.String()
+	t.mu.Lock()
+	entry := t.entries[key]
+	if entry != nil {
+		entry.round = t.round
+	}
+	t.mu.Unlock()
+
+	if entry != nil {
+		req = req.Clone(req.Context())
+		req.Header.Set("If-None-Match", entry.etag)
+	}
+
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		return nil, err //nolint:wrapcheck // Want passthrough error.
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && entry != nil:
+		resp.Body.Close()
+		resp.StatusCode = http.StatusOK
+		resp.Status = fmt.Sprintf("%d %s", http.StatusOK, http.StatusText(http.StatusOK))
+		resp.Body = io.NopCloser(bytes.NewReader(entry.body))
+		resp.ContentLength = int64(len(entry.body))
+		return resp, nil
+
+	case resp.StatusCode == http.StatusOK && resp.Header.Get("ETag") != "":
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read response body: %w", err)
+		}
+		t.mu.Lock()
+		if t.entries == nil {
+			t.entries = make(map[string]*etagEntry)
+		}
+		t.entries[key] = &etagEntry{etag: resp.Header.Get("ETag"), body: body, round: t.round}
+		t.mu.Unlock()
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		return resp, nil
+
+	default:
+		return resp, nil
+	}
+}
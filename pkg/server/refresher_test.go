@@ -0,0 +1,167 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/abcxyz/pkg/testutil"
+)
+
+func TestEtagTransport(t *testing.T) {
+	t.Parallel()
+
+	var full, notModified atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full.Add(1)
+		fmt.Fprint(w, `{"metricsApps":["foo"]}`)
+	}))
+	t.Cleanup(ts.Close)
+
+	client := &http.Client{Transport: &etagTransport{}}
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(ts.URL + "/manifest.json")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		b, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		if got, want := resp.StatusCode, http.StatusOK; got != want {
+			t.Errorf("request %d: unexpected status. got %d want %d", i, got, want)
+		}
+		if got, want := resp.Status, "200 OK"; got != want {
+			t.Errorf("request %d: unexpected status text. got %q want %q", i, got, want)
+		}
+		if got, want := string(b), `{"metricsApps":["foo"]}`; got != want {
+			t.Errorf("request %d: unexpected body. got %q want %q", i, got, want)
+		}
+	}
+
+	if got, want := full.Load(), int64(1); got != want {
+		t.Errorf("unexpected number of full responses. got %d want %d", got, want)
+	}
+	if got, want := notModified.Load(), int64(2); got != want {
+		t.Errorf("unexpected number of not modified responses. got %d want %d", got, want)
+	}
+}
+
+func TestEtagTransport_Prune(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprint(w, `{}`)
+	}))
+	t.Cleanup(ts.Close)
+
+	transport := &etagTransport{}
+	client := &http.Client{Transport: transport}
+	get := func(path string) {
+		t.Helper()
+		resp, err := client.Get(ts.URL + path)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		resp.Body.Close()
+	}
+
+	transport.startRound()
+	get("/manifest.json")
+	get("/removed/metrics.json")
+	get("/changes?changes-since=1")
+	transport.prune()
+
+	transport.startRound()
+	get("/manifest.json")
+	get("/changes?changes-since=2")
+	transport.prune()
+
+	var got []string
+	for key := range transport.entries {
+		got = append(got, strings.TrimPrefix(key, ts.URL))
+	}
+	sort.Strings(got)
+	if diff := cmp.Diff(got, []string{"/changes?changes-since=2", "/manifest.json"}); diff != "" {
+		t.Errorf("unexpected cached entries (-got,+want): %s", diff)
+	}
+}
+
+func TestRefresher_Refresh(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	ts := setupTestServer(t, map[string]*AllowedMetricsResponse{
+		"foo": {Metrics: []string{"metric1"}},
+	}, 0)
+
+	db := &MetricsDB{}
+	r := NewRefresher(db, &MetricsLoadParams{ServerURL: ts.URL, Client: http.DefaultClient}, time.Minute)
+	if err := r.Refresh(ctx); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if _, err := db.GetAllowedMetrics("foo"); err != nil {
+		t.Errorf("expected app to be loaded: %s", err.Error())
+	}
+	status := r.Status()
+	if status.LastSuccess.IsZero() || status.LastDuration == "" || status.LastError != "" {
+		t.Errorf("unexpected status after success: %+v", status)
+	}
+
+	failing := setupTestServer(t, nil, http.StatusInternalServerError)
+	r.params.ServerURL = failing.URL
+	err := r.Refresh(ctx)
+	if diff := testutil.DiffErrString(err, "could not load manifest"); diff != "" {
+		t.Error(diff)
+	}
+	after := r.Status()
+	if !after.LastSuccess.Equal(status.LastSuccess) {
+		t.Errorf("last success changed after failed refresh")
+	}
+	if after.LastError == "" {
+		t.Errorf("expected last error to be set after failed refresh")
+	}
+}
+
+func TestRefresher_nextInterval(t *testing.T) {
+	t.Parallel()
+
+	r := NewRefresher(&MetricsDB{}, &MetricsLoadParams{}, time.Minute)
+	for i := 0; i < 100; i++ {
+		got := r.nextInterval()
+		if got < 54*time.Second || got > 66*time.Second {
+			t.Fatalf("interval %s outside of jitter bounds", got)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// JSONRenderer writes JSON responses for the handlers in this package.
+// *renderer.Renderer from github.com/abcxyz/pkg satisfies it, and
+// JSONResponder is a minimal implementation for servers which do not need
+// HTML templates.
+type JSONRenderer interface {
+	RenderJSON(w http.ResponseWriter, code int, data any)
+}
+
+// JSONResponder is a JSONRenderer without any template machinery. The zero
+// value is ready to use.
+type JSONResponder struct {
+	// Optional OnError is called when a response cannot be encoded or
+	// written.
+	OnError func(err error)
+}
+
+// RenderJSON writes data as JSON with the status code. As with
+// renderer.Renderer, nil data gives an empty body for 2xx codes and
+// {"errors":["<status text>"]} otherwise, and errors are written as
+// {"errors":["<message>"]}. If data cannot be encoded, a generic 500 response
+// is written instead.
+func (j *JSONResponder) RenderJSON(w http.ResponseWriter, code int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if data == nil {
+		w.WriteHeader(code)
+		if code < http.StatusOK || code >= http.StatusMultipleChoices {
+			j.write(w, jsonErrors(strings.ToLower(http.StatusText(code))))
+		}
+		return
+	}
+	if err, ok := data.(error); ok {
+		data = &errorsBody{Errors: []string{err.Error()}}
+	}
+
+	b, err := json.Marshal(data)
+	if err != nil {
+		j.onError(fmt.Errorf("failed to marshal json: %w", err))
+		w.WriteHeader(http.StatusInternalServerError)
+		j.write(w, jsonErrors("An internal error occurred."))
+		return
+	}
+	w.WriteHeader(code)
+	j.write(w, append(b, '\n'))
+}
+
+// write writes b to w, reporting any error.
+func (j *JSONResponder) write(w http.ResponseWriter, b []byte) {
+	if _, err := w.Write(b); err != nil {
+		j.onError(fmt.Errorf("failed to write json response: %w", err))
+	}
+}
+
+// onError calls j.OnError, if set.
+func (j *JSONResponder) onError(err error) {
+	if j != nil && j.OnError != nil {
+		j.OnError(err)
+	}
+}
+
+// errorsBody is the response body for errors which are not API errors.
+type errorsBody struct {
+	Errors []string `json:"errors"`
+}
+
+// jsonErrors returns the JSON encoding of an errorsBody with msg.
+func jsonErrors(msg string) []byte {
+	// Encoding a struct of strings cannot fail.
+	b, _ := json.Marshal(&errorsBody{Errors: []string{msg}})
+	return b
+}
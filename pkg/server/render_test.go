@@ -0,0 +1,111 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/abcxyz/pkg/renderer"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/abcxyz/abc-updater/pkg/apierror"
+)
+
+func TestJSONResponder_RenderJSON(t *testing.T) {
+	t.Parallel()
+
+	h, err := renderer.New(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("failed to setup test: %s", err.Error())
+	}
+
+	cases := []struct {
+		name     string
+		code     int
+		data     any
+		wantBody string
+		wantErr  bool
+	}{
+		{
+			name:     "value",
+			code:     http.StatusOK,
+			data:     map[string]string{"currentVersion": "1.0.0"},
+			wantBody: `{"currentVersion":"1.0.0"}` + "\n",
+		},
+		{
+			name:     "api_error",
+			code:     http.StatusNotFound,
+			data:     apierror.New(apierror.CodeUnknownApp, "unknown app %q", "a"),
+			wantBody: `{"code":"UNKNOWN_APP","message":"unknown app \"a\""}` + "\n",
+		},
+		{
+			name:     "go_error",
+			code:     http.StatusBadRequest,
+			data:     errors.New("bad"),
+			wantBody: `{"errors":["bad"]}` + "\n",
+		},
+		{
+			name: "nil_ok",
+			code: http.StatusNoContent,
+		},
+		{
+			name:     "nil_error",
+			code:     http.StatusTooManyRequests,
+			wantBody: `{"errors":["too many requests"]}`,
+		},
+		{
+			name:     "unencodable",
+			code:     http.StatusOK,
+			data:     map[string]any{"f": func() {}},
+			wantBody: `{"errors":["An internal error occurred."]}`,
+			wantErr:  true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var gotErr error
+			j := &JSONResponder{OnError: func(err error) { gotErr = err }}
+			got := httptest.NewRecorder()
+			j.RenderJSON(got, tc.code, tc.data)
+
+			if diff := cmp.Diff(got.Body.String(), tc.wantBody); diff != "" {
+				t.Errorf("unexpected body (-got,+want): %s", diff)
+			}
+			if ct := got.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("unexpected content type %q", ct)
+			}
+			if (gotErr != nil) != tc.wantErr {
+				t.Errorf("unexpected OnError call: %v", gotErr)
+			}
+
+			// Responses match renderer.Renderer.
+			want := httptest.NewRecorder()
+			h.RenderJSON(want, tc.code, tc.data)
+			if got, want := got.Code, want.Code; got != want {
+				t.Errorf("unexpected status. got %d want %d", got, want)
+			}
+			if diff := cmp.Diff(got.Body.String(), want.Body.String()); diff != "" {
+				t.Errorf("body differs from renderer (-got,+want): %s", diff)
+			}
+		})
+	}
+}
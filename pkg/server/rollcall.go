@@ -0,0 +1,100 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+	"github.com/abcxyz/abc-updater/pkg/apierror"
+	"github.com/abcxyz/pkg/logging"
+)
+
+// RollcallMetricName is the metric name rollcalls are written to sinks with.
+const RollcallMetricName = "rollcall"
+
+// cohortPattern matches an ISO week, as produced by
+// metrics.CohortForInstallTime.
+var cohortPattern = regexp.MustCompile(`^\d{4}-W\d{2}$`)
+
+// HandleRollcall returns a handler for POST requests with a RollcallRequest
+// body, counting an active install of an app. Unlike HandleMetricWithSink, the
+// app does not need to allow any metric, only to be in the manifest, and each
+// rollcall is written to sink unsampled as a single RollcallMetricName metric.
+// Rollcalls carry no install ID, so they cannot be joined with other metrics.
+func HandleRollcall(h JSONRenderer, db MetricsLookuper, sink MetricSink) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := logging.FromContext(ctx)
+
+		req, err := DecodeRequest[api.RollcallRequest](ctx, w, r, h)
+		if err != nil {
+			// Error response already handled by DecodeRequest.
+			return
+		}
+		if apiErr := validateRollcall(req); apiErr != nil {
+			logger.WarnContext(ctx, "rejected invalid rollcall", "code", apiErr.Code)
+			h.RenderJSON(w, http.StatusBadRequest, apiErr)
+			return
+		}
+
+		now := time.Now().UTC()
+		app, err := db.GetAllowedMetrics(req.AppID)
+		if err != nil {
+			h.RenderJSON(w, http.StatusNotFound, apierror.New(apierror.CodeUnknownApp, "unknown app %q", req.AppID))
+			return
+		}
+		if app.Retired.Dropped(now) {
+			h.RenderJSON(w, http.StatusGone, apierror.New(apierror.CodeAppRetired, "app %q is retired", req.AppID))
+			return
+		}
+
+		if err := sink.WriteMetric(ctx, &MetricRecord{
+			AppID:         req.AppID,
+			Owner:         app.Owner,
+			Retired:       app.Retired != nil,
+			AppVersion:    req.AppVersion,
+			InstallCohort: req.InstallCohort,
+			Name:          RollcallMetricName,
+			Count:         1,
+			Sink:          app.Sink,
+			Level:         app.Level,
+			ReceivedAt:    now,
+			EventTime:     now,
+		}); err != nil {
+			logger.WarnContext(ctx, "failed to write rollcall", "app_id", req.AppID, "error", err.Error())
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// validateRollcall checks the fields of a RollcallRequest, returning the error
+// to reject it with, or nil if valid.
+func validateRollcall(r *api.RollcallRequest) *apierror.Response {
+	for field, v := range map[string]string{
+		"appId":      r.AppID,
+		"appVersion": r.AppVersion,
+	} {
+		if v == "" || len(v) > maxFieldLength || !validString(v) {
+			return apierror.New(apierror.CodeInvalidString, "field %q is empty, too long, or contains invalid characters", field)
+		}
+	}
+	if r.InstallCohort != "" && !cohortPattern.MatchString(r.InstallCohort) {
+		return apierror.New(apierror.CodeInvalidString, "field %q must be an ISO week, e.g. 2024-W05", "installCohort")
+	}
+	return nil
+}
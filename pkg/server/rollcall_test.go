@@ -0,0 +1,179 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+	"github.com/abcxyz/abc-updater/pkg/apierror"
+	"github.com/abcxyz/pkg/renderer"
+)
+
+func TestHandleRollcall(t *testing.T) {
+	t.Parallel()
+
+	db := &testMetricsDB{apps: map[string]*AppMetrics{
+		// No metrics need to be allowed for rollcalls.
+		"test": {AppID: "test", Owner: "team-a"},
+		"retired": {
+			AppID:   "retired",
+			Retired: &api.Retirement{DropAfter: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+		},
+	}}
+
+	cases := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantCode   apierror.Code
+		want       []*MetricRecord
+	}{
+		{
+			name:       "happy",
+			body:       `{"appId":"test","appVersion":"1.0.0","installCohort":"2024-W05"}`,
+			wantStatus: http.StatusNoContent,
+			want: []*MetricRecord{{
+				AppID:         "test",
+				Owner:         "team-a",
+				AppVersion:    "1.0.0",
+				InstallCohort: "2024-W05",
+				Name:          RollcallMetricName,
+				Count:         1,
+			}},
+		},
+		{
+			name:       "install_id_ignored",
+			body:       `{"appId":"test","appVersion":"1.0.0","installId":"asdf"}`,
+			wantStatus: http.StatusNoContent,
+			want: []*MetricRecord{{
+				AppID:      "test",
+				Owner:      "team-a",
+				AppVersion: "1.0.0",
+				Name:       RollcallMetricName,
+				Count:      1,
+			}},
+		},
+		{
+			name:       "missing_version",
+			body:       `{"appId":"test"}`,
+			wantStatus: http.StatusBadRequest,
+			wantCode:   apierror.CodeInvalidString,
+		},
+		{
+			name:       "invalid_cohort",
+			body:       `{"appId":"test","appVersion":"1.0.0","installCohort":"2024-01-05"}`,
+			wantStatus: http.StatusBadRequest,
+			wantCode:   apierror.CodeInvalidString,
+		},
+		{
+			name:       "unknown_app",
+			body:       `{"appId":"other","appVersion":"1.0.0"}`,
+			wantStatus: http.StatusNotFound,
+			wantCode:   apierror.CodeUnknownApp,
+		},
+		{
+			name:       "retired_app",
+			body:       `{"appId":"retired","appVersion":"1.0.0"}`,
+			wantStatus: http.StatusGone,
+			wantCode:   apierror.CodeAppRetired,
+		},
+		{
+			name:       "malformed_json",
+			body:       `{"appId":`,
+			wantStatus: http.StatusBadRequest,
+			wantCode:   apierror.CodeMalformedJSON,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			h, err := renderer.New(context.Background(), nil)
+			if err != nil {
+				t.Fatalf("failed to setup test: %s", err.Error())
+			}
+			sink := &testSink{}
+
+			req := httptest.NewRequest(http.MethodPost, "/rollcall", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			HandleRollcall(h, db, sink).ServeHTTP(w, req)
+
+			if got, want := w.Code, tc.wantStatus; got != want {
+				t.Errorf("unexpected response code. got %d want %d", got, want)
+			}
+			if tc.wantCode != "" {
+				var body apierror.Response
+				if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+					t.Fatalf("failed to decode error response: %s", err.Error())
+				}
+				if got, want := body.Code, tc.wantCode; got != want {
+					t.Errorf("unexpected error code. got %q want %q", got, want)
+				}
+			}
+			if diff := cmp.Diff(sink.written, tc.want, ignoreRecordTimes); diff != "" {
+				t.Errorf("unexpected metrics written (-got,+want): %s", diff)
+			}
+		})
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	t.Parallel()
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	// A tiny rate, so the bucket does not refill during the test.
+	limited := NewRateLimiter(0.001, 2).Wrap(ok)
+	var codes []int
+	for range 3 {
+		w := httptest.NewRecorder()
+		limited.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/rollcall", nil))
+		codes = append(codes, w.Code)
+		if w.Code == http.StatusTooManyRequests {
+			if got, want := w.Header().Get("Retry-After"), "1"; got != want {
+				t.Errorf("unexpected Retry-After. got %q want %q", got, want)
+			}
+			var body apierror.Response
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("failed to decode response: %s", err.Error())
+			}
+			if got, want := body.Code, apierror.CodeRateLimited; got != want {
+				t.Errorf("unexpected error code. got %q want %q", got, want)
+			}
+		}
+	}
+	if diff := cmp.Diff(codes, []int{http.StatusNoContent, http.StatusNoContent, http.StatusTooManyRequests}); diff != "" {
+		t.Errorf("unexpected response codes (-got,+want): %s", diff)
+	}
+
+	w := httptest.NewRecorder()
+	NewRateLimiter(0, 0).Wrap(ok).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/rollcall", nil))
+	if got, want := w.Code, http.StatusNoContent; got != want {
+		t.Errorf("unexpected status without limit. got %d want %d", got, want)
+	}
+}
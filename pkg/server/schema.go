@@ -0,0 +1,68 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+	"github.com/abcxyz/abc-updater/pkg/apierror"
+)
+
+// schemaContentType is the media type of JSON Schema documents.
+const schemaContentType = "application/schema+json"
+
+// SchemaIndexResponse lists the schemas served by HandleSchema.
+type SchemaIndexResponse struct {
+	Schemas []string `json:"schemas"`
+}
+
+// HandleSchema returns a http.Handler which serves the JSON Schemas from
+// api.JSONSchemas, named by the "name" path value, so clients in other
+// languages can validate their payloads. With no name it lists the schemas.
+// The schemas only change with the server binary, so they are encoded once
+// and served with caching headers.
+func HandleSchema(h JSONRenderer) http.Handler {
+	schemas := make(map[string]*cachedContent)
+	var index SchemaIndexResponse
+	now := time.Now()
+	for name, s := range api.JSONSchemas() {
+		b, err := json.MarshalIndent(s, "", "  ")
+		if err != nil {
+			// Not possible for JSONSchema.
+			panic(err)
+		}
+		schemas[name] = newCachedContent(b, schemaContentType, now)
+		index.Schemas = append(index.Schemas, name)
+	}
+	sort.Strings(index.Schemas)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		if name == "" {
+			h.RenderJSON(w, http.StatusOK, &index)
+			return
+		}
+		c, ok := schemas[name]
+		if !ok {
+			h.RenderJSON(w, http.StatusNotFound, apierror.New(apierror.CodeNotFound, "unknown schema %q", name))
+			return
+		}
+		c.serve(w, r, defaultCacheMaxAge)
+	})
+}
@@ -0,0 +1,108 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+)
+
+func TestHandleSchema(t *testing.T) {
+	t.Parallel()
+
+	mux := http.NewServeMux()
+	schema := HandleSchema(&JSONResponder{})
+	mux.Handle("GET /schema/{$}", schema)
+	mux.Handle("GET /schema/{name}", schema)
+
+	cases := []struct {
+		name            string
+		path            string
+		wantStatus      int
+		wantContentType string
+		wantTitle       string
+	}{
+		{
+			name:            "send_metrics",
+			path:            "/schema/sendMetrics.json",
+			wantStatus:      http.StatusOK,
+			wantContentType: schemaContentType,
+			wantTitle:       "SendMetricRequest",
+		},
+		{
+			name:            "manifest",
+			path:            "/schema/manifest.json",
+			wantStatus:      http.StatusOK,
+			wantContentType: schemaContentType,
+			wantTitle:       "ManifestResponse",
+		},
+		{
+			name:            "unknown",
+			path:            "/schema/other.json",
+			wantStatus:      http.StatusNotFound,
+			wantContentType: "application/json",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.path, nil))
+
+			if got, want := w.Code, tc.wantStatus; got != want {
+				t.Errorf("unexpected status. got %d want %d: %s", got, want, w.Body.String())
+			}
+			if got, want := w.Header().Get("Content-Type"), tc.wantContentType; got != want {
+				t.Errorf("unexpected content type. got %q want %q", got, want)
+			}
+			if tc.wantTitle == "" {
+				return
+			}
+			var got api.JSONSchema
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatalf("failed to decode schema: %s", err.Error())
+			}
+			if got, want := got.Title, tc.wantTitle; got != want {
+				t.Errorf("unexpected title. got %q want %q", got, want)
+			}
+			if w.Header().Get("ETag") == "" {
+				t.Errorf("expected ETag header")
+			}
+		})
+	}
+
+	t.Run("index", func(t *testing.T) {
+		t.Parallel()
+
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/schema/", nil))
+		var got SchemaIndexResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatalf("failed to decode index: %s", err.Error())
+		}
+		want := SchemaIndexResponse{Schemas: []string{"data.json", "manifest.json", "metrics.json", "sendMetrics.json"}}
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("unexpected index (-got,+want): %s", diff)
+		}
+	})
+}
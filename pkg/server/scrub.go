@@ -0,0 +1,156 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/abcxyz/pkg/logging"
+)
+
+// ScrubAction is what ScrubSink does with a metric matching a ScrubRule.
+type ScrubAction string
+
+const (
+	// ScrubMask replaces each match with ScrubMaskText and records the metric.
+	ScrubMask ScrubAction = "mask"
+	// ScrubReject drops the metric, reported to the client as
+	// api.DispositionSensitiveData.
+	ScrubReject ScrubAction = "reject"
+)
+
+// ScrubMaskText replaces text matched by a ScrubMask rule.
+const ScrubMaskText = "[REDACTED]"
+
+// ErrSensitiveData is returned by ScrubSink for metrics matching a ScrubReject
+// rule. It never includes the matched text.
+var ErrSensitiveData = errors.New("metric matched a scrub rule")
+
+// scrubPatterns are the built-in patterns ScrubRules can refer to by name.
+var scrubPatterns = map[string]*regexp.Regexp{
+	"email": regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`),
+	"ip":    regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b|\b(?:[0-9A-Fa-f]{1,4}:){2,7}[0-9A-Fa-f]{1,4}\b`),
+	// Home directories, which usually contain a user name.
+	"path": regexp.MustCompile(`(?:/home/|/Users/|[A-Za-z]:\\Users\\)[^/\\\s]+`),
+}
+
+// scrubbedMetrics counts metrics matching a scrub rule.
+var scrubbedMetrics = DefaultRegistry.NewCounter("abc_updater_scrubbed_metrics_total",
+	"Metrics which matched a scrub rule, by rule and action.",
+	"rule", "action")
+
+// ScrubRule matches sensitive data, such as email addresses or file paths, in
+// metrics.
+type ScrubRule struct {
+	// Name identifies the rule in logs and counters.
+	Name    string
+	Pattern *regexp.Regexp
+	Action  ScrubAction
+}
+
+// ParseScrubRule parses a rule of the form "<action>:<pattern>", where action
+// is "mask" or "reject" and pattern is a built-in pattern, one of "email",
+// "ip", or "path", or a regular expression prefixed with "re:", e.g.
+// "reject:re:secret-[0-9]+".
+func ParseScrubRule(s string) (*ScrubRule, error) {
+	action, pattern, ok := strings.Cut(s, ":")
+	if !ok {
+		return nil, fmt.Errorf("scrub rule %q must be of the form <action>:<pattern>", s)
+	}
+	if a := ScrubAction(action); a != ScrubMask && a != ScrubReject {
+		return nil, fmt.Errorf("scrub rule %q has unknown action %q, must be %q or %q", s, action, ScrubMask, ScrubReject)
+	}
+	if expr, ok := strings.CutPrefix(pattern, "re:"); ok {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("scrub rule %q has invalid pattern: %w", s, err)
+		}
+		return &ScrubRule{Name: pattern, Pattern: re, Action: ScrubAction(action)}, nil
+	}
+	re, ok := scrubPatterns[pattern]
+	if !ok {
+		return nil, fmt.Errorf("scrub rule %q has unknown pattern %q, must be \"email\", \"ip\", \"path\", or \"re:<regexp>\"", s, pattern)
+	}
+	return &ScrubRule{Name: pattern, Pattern: re, Action: ScrubAction(action)}, nil
+}
+
+// Assert ScrubSink satisfies MetricSink.
+var _ MetricSink = (*ScrubSink)(nil)
+
+// ScrubSink checks the name and label values of each metric against Rules
+// before writing it to Next, so sensitive data a client sent by mistake is
+// never logged or stored. Unlike other sink wrappers it must run before Next,
+// so it should be the outermost sink.
+//
+// A metric matching any ScrubReject rule is dropped and ErrSensitiveData is
+// returned. Otherwise, matches of ScrubMask rules are replaced with
+// ScrubMaskText.
+type ScrubSink struct {
+	Next  MetricSink
+	Rules []*ScrubRule
+}
+
+// WriteMetric writes m to Next, masked, unless it matches a reject rule.
+func (s *ScrubSink) WriteMetric(ctx context.Context, m *MetricRecord) error {
+	scrubbed := *m
+	fields := []*string{
+		&scrubbed.Name,
+		&scrubbed.AppVersion,
+		&scrubbed.InstallCohort,
+		&scrubbed.UpgradedFrom,
+		&scrubbed.DowngradedFrom,
+	}
+	if m.BuildInfo != nil {
+		bi := *m.BuildInfo
+		scrubbed.BuildInfo = &bi
+		fields = append(fields, &bi.Commit, &bi.Date, &bi.GoVersion, &bi.Builder)
+	}
+
+	// Check every reject rule first, so masking can't hide a match.
+	for _, r := range s.Rules {
+		if r.Action != ScrubReject || !anyMatch(r.Pattern, fields) {
+			continue
+		}
+		scrubbedMetrics.Inc(r.Name, string(r.Action))
+		logging.FromContext(ctx).WarnContext(ctx, "rejected metric with sensitive data",
+			"app_id", m.AppID,
+			"rule", r.Name)
+		return ErrSensitiveData
+	}
+	for _, r := range s.Rules {
+		if r.Action != ScrubMask || !anyMatch(r.Pattern, fields) {
+			continue
+		}
+		scrubbedMetrics.Inc(r.Name, string(r.Action))
+		for _, f := range fields {
+			*f = r.Pattern.ReplaceAllLiteralString(*f, ScrubMaskText)
+		}
+	}
+	return s.Next.WriteMetric(ctx, &scrubbed) //nolint:wrapcheck // Want passthrough error.
+}
+
+// anyMatch returns true if re matches any of fields.
+func anyMatch(re *regexp.Regexp, fields []*string) bool {
+	for _, f := range fields {
+		if re.MatchString(*f) {
+			return true
+		}
+	}
+	return false
+}
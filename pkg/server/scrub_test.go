@@ -0,0 +1,193 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+	"github.com/abcxyz/abc-updater/pkg/metrics"
+	"github.com/abcxyz/pkg/renderer"
+	"github.com/abcxyz/pkg/testutil"
+)
+
+func TestParseScrubRule(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name       string
+		rule       string
+		wantName   string
+		wantAction ScrubAction
+		wantErr    string
+	}{
+		{name: "builtin", rule: "mask:email", wantName: "email", wantAction: ScrubMask},
+		{name: "regexp", rule: "reject:re:secret-[0-9]+", wantName: "re:secret-[0-9]+", wantAction: ScrubReject},
+		{name: "no_action", rule: "email", wantErr: "must be of the form"},
+		{name: "unknown_action", rule: "drop:email", wantErr: `unknown action "drop"`},
+		{name: "unknown_pattern", rule: "mask:phone", wantErr: `unknown pattern "phone"`},
+		{name: "invalid_regexp", rule: "mask:re:(", wantErr: "invalid pattern"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := ParseScrubRule(tc.rule)
+			if diff := testutil.DiffErrString(err, tc.wantErr); diff != "" {
+				t.Fatal(diff)
+			}
+			if err != nil {
+				return
+			}
+			if got.Name != tc.wantName || got.Action != tc.wantAction {
+				t.Errorf("unexpected rule. got %q %q want %q %q", got.Name, got.Action, tc.wantName, tc.wantAction)
+			}
+		})
+	}
+}
+
+func TestScrubSink(t *testing.T) {
+	t.Parallel()
+
+	rules := func(t *testing.T, specs ...string) []*ScrubRule {
+		t.Helper()
+		var out []*ScrubRule
+		for _, s := range specs {
+			r, err := ParseScrubRule(s)
+			if err != nil {
+				t.Fatalf("failed to setup test: %s", err.Error())
+			}
+			out = append(out, r)
+		}
+		return out
+	}
+
+	cases := []struct {
+		name    string
+		rules   []string
+		record  *MetricRecord
+		want    []*MetricRecord
+		wantErr error
+	}{
+		{
+			name:   "clean",
+			rules:  []string{"mask:email", "mask:path"},
+			record: &MetricRecord{AppID: "test", AppVersion: "1.2.3", Name: "foo"},
+			want:   []*MetricRecord{{AppID: "test", AppVersion: "1.2.3", Name: "foo"}},
+		},
+		{
+			name:  "mask",
+			rules: []string{"mask:email", "mask:path"},
+			record: &MetricRecord{
+				AppID:        "test",
+				AppVersion:   "1.2.3+alice@example.com",
+				UpgradedFrom: "/home/alice/bin",
+				Name:         "foo",
+				BuildInfo:    &api.BuildInfo{Builder: `gc C:\Users\alice\go`},
+			},
+			want: []*MetricRecord{{
+				AppID:        "test",
+				AppVersion:   "[REDACTED]",
+				UpgradedFrom: "[REDACTED]/bin",
+				Name:         "foo",
+				BuildInfo:    &api.BuildInfo{Builder: `gc [REDACTED]\go`},
+			}},
+		},
+		{
+			name:    "reject",
+			rules:   []string{"mask:email", "reject:ip"},
+			record:  &MetricRecord{AppID: "test", AppVersion: "1.2.3", Name: "from-10.0.0.1"},
+			wantErr: ErrSensitiveData,
+		},
+		{
+			name:    "reject_before_mask",
+			rules:   []string{"mask:email", "reject:re:@example\\.com"},
+			record:  &MetricRecord{AppID: "test", AppVersion: "alice@example.com", Name: "foo"},
+			wantErr: ErrSensitiveData,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			next := &testSink{}
+			sink := &ScrubSink{Next: next, Rules: rules(t, tc.rules...)}
+			if err := sink.WriteMetric(context.Background(), tc.record); !errors.Is(err, tc.wantErr) {
+				t.Errorf("unexpected error. got %v want %v", err, tc.wantErr)
+			}
+			if diff := cmp.Diff(next.written, tc.want); diff != "" {
+				t.Errorf("unexpected records (-got,+want): %s", diff)
+			}
+		})
+	}
+}
+
+func TestHandleMetric_SensitiveData(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	h, err := renderer.New(ctx, nil)
+	if err != nil {
+		t.Fatalf("failed to setup test: %s", err.Error())
+	}
+	db := &testMetricsDB{apps: map[string]*AppMetrics{"test": {
+		AppID:   "test",
+		Allowed: map[string]interface{}{"foo": struct{}{}},
+	}}}
+	next := &testSink{}
+	sink := &ScrubSink{Next: next, Rules: []*ScrubRule{{
+		Name:    "email",
+		Pattern: regexp.MustCompile(`@example\.com`),
+		Action:  ScrubReject,
+	}}}
+
+	req := httptest.NewRequest(http.MethodPost, "/sendMetrics", marshalRequest(t, &metrics.SendMetricRequest{
+		AppID:               "test",
+		AppVersion:          "1.0+alice@example.com",
+		Metrics:             map[string]int64{"foo": 1},
+		InstallID:           "asdf",
+		IncludeDispositions: true,
+	}))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	HandleMetricWithSink(h, db, sink).ServeHTTP(w, req)
+
+	if got, want := w.Code, http.StatusAccepted; got != want {
+		t.Errorf("unexpected response code. got %d want %d", got, want)
+	}
+	var body api.SendMetricResponse
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("failed to decode response: %s", err.Error())
+	}
+	if diff := cmp.Diff(body.Dispositions, map[string]api.MetricDisposition{"foo": api.DispositionSensitiveData}); diff != "" {
+		t.Errorf("unexpected dispositions (-got,+want): %s", diff)
+	}
+	if diff := cmp.Diff(body.Warnings, []string{`metric "foo" rejected, it contains sensitive data`}); diff != "" {
+		t.Errorf("unexpected warnings (-got,+want): %s", diff)
+	}
+	if len(next.written) != 0 {
+		t.Errorf("expected no metrics written, got %d", len(next.written))
+	}
+}
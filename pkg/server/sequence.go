@@ -0,0 +1,102 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"sync"
+)
+
+// maxSequenceInstalls bounds the installs whose last sequence number is kept.
+// When reached, tracking starts over, and the next request of each install
+// is treated as its first.
+const maxSequenceInstalls = 100_000
+
+// maxSequenceGap is the most requests counted missing between two requests of
+// an install. Sequence numbers are chosen by clients, so a larger jump is
+// treated as the sequence starting over rather than counted.
+const maxSequenceGap = 1000
+
+// Counters of sequenced metric requests, from clients which number their
+// requests. The fraction of requests lost is approximately
+// (missing - late) / (sequenced + missing - late).
+var (
+	sequencedRequests = DefaultRegistry.NewCounter("abc_updater_sequenced_requests_total",
+		"Metric requests with a sequence number, by app.",
+		"app_id")
+	missingRequests = DefaultRegistry.NewCounter("abc_updater_missing_requests_total",
+		"Gaps in the sequence numbers of metric requests from each install, by app.",
+		"app_id")
+	lateRequests = DefaultRegistry.NewCounter("abc_updater_late_requests_total",
+		"Sequenced metric requests which arrived after a later request, filling a gap, by app.",
+		"app_id")
+)
+
+// sequences tracks the sequence numbers of metric requests received by this
+// replica.
+var sequences = &sequenceTracker{}
+
+// sequenceTracker keeps the last sequence number received from each install,
+// in memory, to detect gaps.
+type sequenceTracker struct {
+	mu   sync.Mutex
+	last map[installKey]int64
+}
+
+// observe records a request numbered seq from an install, and counts it and
+// any gap before it. Legacy install IDs are ignored.
+func (t *sequenceTracker) observe(appID, installID string, seq int64) {
+	if isLegacyInstallID(installID) {
+		return
+	}
+	missing, late := t.record(installKey{appID: appID, installID: installID}, seq)
+	sequencedRequests.Inc(appID)
+	if missing > 0 {
+		missingRequests.Add(missing, appID)
+	}
+	if late {
+		lateRequests.Inc(appID)
+	}
+}
+
+// record updates the last sequence number of k, returning the number of
+// requests skipped since the last one, and whether seq arrived late. Repeats
+// of the last number, e.g. from retries, are neither. A sequence which starts
+// over at 1, as from a client which cannot persist it, is not late, and nor is
+// a jump of more than maxSequenceGap a gap.
+func (t *sequenceTracker) record(k installKey, seq int64) (int64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.last == nil || len(t.last) >= maxSequenceInstalls {
+		t.last = make(map[installKey]int64)
+	}
+	last, ok := t.last[k]
+	switch {
+	case !ok || seq == 1:
+		// The first request seen from an install may follow others sent
+		// before this replica started, so gaps are only counted after it.
+		t.last[k] = seq
+		return 0, false
+	case seq > last:
+		t.last[k] = seq
+		if gap := seq - last - 1; gap <= maxSequenceGap {
+			return gap, false
+		}
+		return 0, false
+	case seq < last:
+		return 0, true
+	}
+	return 0, false
+}
@@ -0,0 +1,113 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSequenceTracker_Record(t *testing.T) {
+	t.Parallel()
+
+	type result struct {
+		Missing int64
+		Late    bool
+	}
+
+	cases := []struct {
+		name string
+		seqs []int64
+		want []result
+	}{
+		{
+			name: "in_order",
+			seqs: []int64{5, 6, 7},
+			want: []result{{}, {}, {}},
+		},
+		{
+			name: "gap",
+			seqs: []int64{5, 8},
+			want: []result{{}, {Missing: 2}},
+		},
+		{
+			name: "late",
+			seqs: []int64{5, 7, 6},
+			want: []result{{}, {Missing: 1}, {Late: true}},
+		},
+		{
+			name: "retry",
+			seqs: []int64{5, 5},
+			want: []result{{}, {}},
+		},
+		{
+			name: "largest_gap",
+			seqs: []int64{5, 5 + maxSequenceGap + 1},
+			want: []result{{}, {Missing: maxSequenceGap}},
+		},
+		{
+			name: "jump_too_large",
+			seqs: []int64{5, 1 << 62, 1<<62 + 2},
+			want: []result{{}, {}, {Missing: 1}},
+		},
+		{
+			name: "restarted",
+			seqs: []int64{5, 1, 2},
+			want: []result{{}, {}, {}},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var tr sequenceTracker
+			k := installKey{appID: "foo", installID: "a"}
+			got := make([]result, 0, len(tc.seqs))
+			for _, seq := range tc.seqs {
+				missing, late := tr.record(k, seq)
+				got = append(got, result{Missing: missing, Late: late})
+			}
+			if diff := cmp.Diff(got, tc.want); diff != "" {
+				t.Errorf("unexpected results (-got,+want): %s", diff)
+			}
+		})
+	}
+}
+
+func TestSequenceTracker_Observe(t *testing.T) {
+	t.Parallel()
+
+	// Counters are shared, so use an app no other test sends.
+	const appID = "sequence_test"
+	var tr sequenceTracker
+	for _, seq := range []int64{1, 4, 3} {
+		tr.observe(appID, "a", seq)
+	}
+	tr.observe(appID, "b", 10)
+	// Installs sharing a legacy ID are not sequenced.
+	tr.observe(appID, "legacy-1706702400", 5)
+
+	if got, want := sequencedRequests.Value(appID), int64(4); got != want {
+		t.Errorf("unexpected sequenced requests. got %d want %d", got, want)
+	}
+	if got, want := missingRequests.Value(appID), int64(2); got != want {
+		t.Errorf("unexpected missing requests. got %d want %d", got, want)
+	}
+	if got, want := lateRequests.Value(appID), int64(1); got != want {
+		t.Errorf("unexpected late requests. got %d want %d", got, want)
+	}
+}
@@ -0,0 +1,317 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+	"github.com/abcxyz/pkg/logging"
+)
+
+// Assert sinks satisfy MetricSink.
+var (
+	_ MetricSink = (*LogSink)(nil)
+	_ MetricSink = (*HTTPSink)(nil)
+	_ MetricSink = (*ShadowSink)(nil)
+)
+
+// MetricRecord is a single accepted metric.
+type MetricRecord struct {
+	AppID          string  `json:"appId"`
+	Owner          string  `json:"owner,omitempty"`
+	AppVersion     string  `json:"appVersion"`
+	InstallID      string  `json:"installId"`
+	InstallCohort  string  `json:"installCohort,omitempty"`
+	UpgradedFrom   string  `json:"upgradedFrom,omitempty"`
+	DowngradedFrom string  `json:"downgradedFrom,omitempty"`
+	Name           string  `json:"name"`
+	Count          int64   `json:"count"`
+	Sink           string  `json:"sink,omitempty"`
+	SampleRate     float64 `json:"sampleRate,omitempty"`
+	Retired        bool    `json:"retired,omitempty"`
+
+	// BuildInfo is only set for apps which allow it.
+	BuildInfo *api.BuildInfo `json:"buildInfo,omitempty"`
+
+	// Sequence numbers the client's request, if it numbers them.
+	Sequence int64 `json:"sequence,omitempty"`
+
+	// ReceivedAt is when the server received the metric.
+	ReceivedAt time.Time `json:"receivedAt"`
+
+	// EventTime is when the metric occurred, from the client's event time
+	// corrected for clock skew. The same as ReceivedAt if the client did not
+	// send an event time.
+	EventTime time.Time `json:"eventTime"`
+
+	// Level is the app's configured log level for metrics.
+	Level slog.Level `json:"-"`
+}
+
+// MetricSink is where accepted metrics are written.
+type MetricSink interface {
+	WriteMetric(ctx context.Context, m *MetricRecord) error
+}
+
+// LogSink writes metrics as structured log entries, using the logger in the
+// context. This is the default sink.
+type LogSink struct{}
+
+// WriteMetric logs m at the app's configured level.
+func (s *LogSink) WriteMetric(ctx context.Context, m *MetricRecord) error {
+	attrs := []any{
+		"app_id", m.AppID,
+		"app_version", m.AppVersion,
+		"install_id", m.InstallID,
+		"install_cohort", m.InstallCohort,
+		"upgraded_from", m.UpgradedFrom,
+		"name", m.Name,
+		"count", m.Count,
+	}
+	if m.Owner != "" {
+		attrs = append(attrs, "owner", m.Owner)
+	}
+	if m.Retired {
+		attrs = append(attrs, "retired", true)
+	}
+	if m.DowngradedFrom != "" {
+		attrs = append(attrs, "downgraded_from", m.DowngradedFrom)
+	}
+	if b := m.BuildInfo; b != nil {
+		attrs = append(attrs,
+			"build_commit", b.Commit,
+			"build_date", b.Date,
+			"go_version", b.GoVersion,
+			"builder", b.Builder)
+	}
+	if m.Sink != "" {
+		attrs = append(attrs, "sink", m.Sink)
+	}
+	if m.SampleRate > 0 {
+		// Allows downstream aggregation to scale counts back up.
+		attrs = append(attrs, "sample_rate", m.SampleRate)
+	}
+	if m.Sequence > 0 {
+		attrs = append(attrs, "sequence", m.Sequence)
+	}
+	if !m.EventTime.Equal(m.ReceivedAt) {
+		// The log entry's own timestamp is when the metric was received.
+		attrs = append(attrs, "event_time", m.EventTime)
+	}
+	logging.FromContext(ctx).WithGroup("metric").Log(ctx, m.Level, "metric received", attrs...)
+	return nil
+}
+
+// HTTPSink POSTs each metric as JSON to a URL, such as a collector for a new
+// ingestion pipeline.
+type HTTPSink struct {
+	URL string
+
+	// Optional client used to make requests. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// WriteMetric posts m to the sink's URL. Any non-2xx response is an error.
+func (s *HTTPSink) WriteMetric(ctx context.Context, m *MetricRecord) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metric: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorResponseBytes))
+		return fmt.Errorf("not a 2xx response: %d %s", resp.StatusCode, string(b))
+	}
+	return nil
+}
+
+// defaultShadowQueueSize is how many metrics may wait for the shadow sink if
+// ShadowSink.QueueSize is not set.
+const defaultShadowQueueSize = 1000
+
+// ShadowSink writes each metric to a primary and a shadow sink, for migrating
+// to a new sink without gaps in data. Only primary errors are returned; shadow
+// errors are logged and counted, so the shadow can never affect the primary.
+//
+// Metrics are written to the shadow sink in the background, in order, so a
+// slow shadow does not delay requests. Metrics which arrive while the queue is
+// full are dropped and counted. Call Close to write queued metrics before
+// exiting.
+type ShadowSink struct {
+	Primary MetricSink
+	Shadow  MetricSink
+
+	// QueueSize is how many metrics may wait to be written to the shadow
+	// sink. Defaults to 1000.
+	QueueSize int
+
+	startOnce sync.Once
+	mu        sync.Mutex
+	closed    bool
+	queue     chan *shadowWrite
+	done      chan struct{}
+
+	primaryErrors atomic.Int64
+	shadowErrors  atomic.Int64
+	writes        atomic.Int64
+	mismatches    atomic.Int64
+	dropped       atomic.Int64
+}
+
+// shadowWrite is a metric queued for the shadow sink.
+type shadowWrite struct {
+	ctx           context.Context //nolint:containedctx // Carries the request's logger to the background write.
+	m             *MetricRecord
+	primaryFailed bool
+}
+
+// ShadowStatus compares the results of writes to each sink of a ShadowSink.
+type ShadowStatus struct {
+	Writes        int64 `json:"writes"`
+	PrimaryErrors int64 `json:"primaryErrors"`
+	ShadowErrors  int64 `json:"shadowErrors"`
+	// Mismatches counts writes which succeeded in exactly one sink.
+	Mismatches int64 `json:"mismatches"`
+	// Dropped counts metrics not written to the shadow sink because its queue
+	// was full, or the ShadowSink was closed.
+	Dropped int64 `json:"dropped"`
+}
+
+// WriteMetric writes m to the primary sink, and queues it for the shadow sink.
+func (s *ShadowSink) WriteMetric(ctx context.Context, m *MetricRecord) error {
+	s.writes.Add(1)
+	primaryErr := s.Primary.WriteMetric(ctx, m)
+	if primaryErr != nil {
+		s.primaryErrors.Add(1)
+	}
+	// Copied, and not canceled with the request, as the write happens later.
+	shadowM := *m
+	s.enqueue(&shadowWrite{
+		ctx:           context.WithoutCancel(ctx),
+		m:             &shadowM,
+		primaryFailed: primaryErr != nil,
+	})
+	return primaryErr
+}
+
+// Close writes the queued metrics to the shadow sink, and waits for them.
+// Metrics written to s after Close are only written to the primary sink.
+func (s *ShadowSink) Close() {
+	s.startOnce.Do(s.start)
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.mu.Unlock()
+	<-s.done
+}
+
+// start creates the queue and starts writing it to the shadow sink.
+func (s *ShadowSink) start() {
+	size := s.QueueSize
+	if size <= 0 {
+		size = defaultShadowQueueSize
+	}
+	s.queue = make(chan *shadowWrite, size)
+	s.done = make(chan struct{})
+	go s.run()
+}
+
+// enqueue queues w for the shadow sink, or drops it if the queue is full.
+func (s *ShadowSink) enqueue(w *shadowWrite) {
+	s.startOnce.Do(s.start)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		s.dropped.Add(1)
+		return
+	}
+	select {
+	case s.queue <- w:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// run writes queued metrics to the shadow sink until the queue is closed.
+func (s *ShadowSink) run() {
+	defer close(s.done)
+	for w := range s.queue {
+		shadowErr := s.writeShadow(w.ctx, w.m)
+		if shadowErr != nil {
+			s.shadowErrors.Add(1)
+			logging.FromContext(w.ctx).WarnContext(w.ctx, "failed to write metric to shadow sink",
+				"app_id", w.m.AppID,
+				"name", w.m.Name,
+				"error", shadowErr.Error())
+		}
+		if w.primaryFailed != (shadowErr != nil) {
+			s.mismatches.Add(1)
+		}
+	}
+}
+
+// writeShadow writes to the shadow sink, converting a panic to an error.
+func (s *ShadowSink) writeShadow(ctx context.Context, m *MetricRecord) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("shadow sink panicked: %v", r)
+		}
+	}()
+	return s.Shadow.WriteMetric(ctx, m) //nolint:wrapcheck // Want passthrough error.
+}
+
+// Status returns counts of writes and errors for each sink.
+func (s *ShadowSink) Status() *ShadowStatus {
+	return &ShadowStatus{
+		Writes:        s.writes.Load(),
+		PrimaryErrors: s.primaryErrors.Load(),
+		ShadowErrors:  s.shadowErrors.Load(),
+		Mismatches:    s.mismatches.Load(),
+		Dropped:       s.dropped.Load(),
+	}
+}
+
+// HandleShadowStatus returns a handler which renders the ShadowSink's status.
+func HandleShadowStatus(h JSONRenderer, s *ShadowSink) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.RenderJSON(w, http.StatusOK, s.Status())
+	})
+}
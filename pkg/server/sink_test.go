@@ -0,0 +1,194 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/abcxyz/pkg/testutil"
+)
+
+// ignoreRecordTimes ignores the receipt and event times of MetricRecords,
+// which are set from the clock.
+var ignoreRecordTimes = cmpopts.IgnoreFields(MetricRecord{}, "ReceivedAt", "EventTime")
+
+// testSink records metrics written to it, and returns err if set.
+type testSink struct {
+	mu      sync.Mutex
+	written []*MetricRecord
+	err     error
+	panics  bool
+}
+
+func (s *testSink) WriteMetric(ctx context.Context, m *MetricRecord) error {
+	if s.panics {
+		panic("boom")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.written = append(s.written, m)
+	return s.err
+}
+
+func TestShadowSink(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name       string
+		primary    *testSink
+		shadow     *testSink
+		wantErr    string
+		wantStatus *ShadowStatus
+	}{
+		{
+			name:       "both_succeed",
+			primary:    &testSink{},
+			shadow:     &testSink{},
+			wantStatus: &ShadowStatus{Writes: 1},
+		},
+		{
+			name:       "shadow_fails",
+			primary:    &testSink{},
+			shadow:     &testSink{err: fmt.Errorf("shadow down")},
+			wantStatus: &ShadowStatus{Writes: 1, ShadowErrors: 1, Mismatches: 1},
+		},
+		{
+			name:       "shadow_panics",
+			primary:    &testSink{},
+			shadow:     &testSink{panics: true},
+			wantStatus: &ShadowStatus{Writes: 1, ShadowErrors: 1, Mismatches: 1},
+		},
+		{
+			name:       "primary_fails",
+			primary:    &testSink{err: fmt.Errorf("primary down")},
+			shadow:     &testSink{},
+			wantErr:    "primary down",
+			wantStatus: &ShadowStatus{Writes: 1, PrimaryErrors: 1, Mismatches: 1},
+		},
+		{
+			name:       "both_fail",
+			primary:    &testSink{err: fmt.Errorf("primary down")},
+			shadow:     &testSink{err: fmt.Errorf("shadow down")},
+			wantErr:    "primary down",
+			wantStatus: &ShadowStatus{Writes: 1, PrimaryErrors: 1, ShadowErrors: 1},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			s := &ShadowSink{Primary: tc.primary, Shadow: tc.shadow}
+			err := s.WriteMetric(context.Background(), &MetricRecord{AppID: "foo", Name: "run", Count: 1})
+			if diff := testutil.DiffErrString(err, tc.wantErr); diff != "" {
+				t.Error(diff)
+			}
+			s.Close()
+			if got, want := len(tc.primary.written), 1; got != want {
+				t.Errorf("unexpected primary writes. got %d want %d", got, want)
+			}
+			if diff := cmp.Diff(s.Status(), tc.wantStatus); diff != "" {
+				t.Errorf("unexpected status (-got,+want): %s", diff)
+			}
+		})
+	}
+}
+
+// blockingSink blocks writes until release is closed.
+type blockingSink struct {
+	release chan struct{}
+	written atomic.Int64
+}
+
+func (s *blockingSink) WriteMetric(ctx context.Context, m *MetricRecord) error {
+	<-s.release
+	s.written.Add(1)
+	return nil
+}
+
+func TestShadowSink_SlowShadow(t *testing.T) {
+	t.Parallel()
+
+	primary := &testSink{}
+	shadow := &blockingSink{release: make(chan struct{})}
+	s := &ShadowSink{Primary: primary, Shadow: shadow, QueueSize: 1}
+
+	const writes = 5
+	start := time.Now()
+	for i := 0; i < writes; i++ {
+		if err := s.WriteMetric(context.Background(), &MetricRecord{AppID: "foo", Name: "run", Count: 1}); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("primary writes took %s with a blocked shadow sink", elapsed)
+	}
+	if got := len(primary.written); got != writes {
+		t.Errorf("unexpected primary writes. got %d want %d", got, writes)
+	}
+
+	close(shadow.release)
+	s.Close()
+
+	// One metric may be in flight and one queued; the rest are dropped.
+	status := s.Status()
+	if status.Dropped < writes-2 {
+		t.Errorf("expected at least %d dropped, got %d", writes-2, status.Dropped)
+	}
+	if got, want := shadow.written.Load()+status.Dropped, int64(writes); got != want {
+		t.Errorf("unexpected shadow writes plus drops. got %d want %d", got, want)
+	}
+}
+
+func TestHTTPSink(t *testing.T) {
+	t.Parallel()
+
+	var got MetricRecord
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			http.Error(w, "nope", http.StatusInternalServerError)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("failed to decode request: %s", err.Error())
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(ts.Close)
+
+	want := MetricRecord{AppID: "foo", AppVersion: "1.0", InstallID: "asdf", Name: "run", Count: 2}
+	if err := (&HTTPSink{URL: ts.URL}).WriteMetric(context.Background(), &want); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("unexpected metric (-got,+want): %s", diff)
+	}
+
+	err := (&HTTPSink{URL: ts.URL + "/fail"}).WriteMetric(context.Background(), &want)
+	if diff := testutil.DiffErrString(err, "not a 2xx response: 500"); diff != "" {
+		t.Error(diff)
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"maps"
+	"slices"
+)
+
+// Snapshot returns each app with metrics definitions loaded, mapped to its
+// sorted allowlist entries, including wildcard patterns. The result is a copy
+// and may be modified by the caller.
+func (db *MetricsDB) Snapshot() map[string][]string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return snapshotApps(db.apps)
+}
+
+// Subscribe returns a channel which receives a new Snapshot each time an
+// Update changes the set of apps or their allowlists, and a function which
+// unsubscribes and closes the channel. Programs embedding the server can use
+// it to react to definition changes, e.g. by provisioning storage per app.
+//
+// Only the most recent snapshot is buffered, so a slow subscriber skips
+// intermediate changes rather than blocking Update.
+func (db *MetricsDB) Subscribe() (<-chan map[string][]string, func()) {
+	ch := make(chan map[string][]string, 1)
+
+	db.subMu.Lock()
+	defer db.subMu.Unlock()
+	if db.subs == nil {
+		db.subs = make(map[chan map[string][]string]struct{})
+	}
+	db.subs[ch] = struct{}{}
+
+	return ch, func() {
+		db.subMu.Lock()
+		defer db.subMu.Unlock()
+		if _, ok := db.subs[ch]; ok {
+			delete(db.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// notify sends snap to all subscribers, replacing any snapshot they have not
+// yet received.
+func (db *MetricsDB) notify(snap map[string][]string) {
+	db.subMu.Lock()
+	defer db.subMu.Unlock()
+	for ch := range db.subs {
+		select {
+		case ch <- cloneSnapshot(snap):
+		default:
+			// Drop the stale snapshot. Sends only happen under subMu, so there
+			// is room for the new one afterwards.
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- cloneSnapshot(snap)
+		}
+	}
+}
+
+// snapshotApps converts loaded definitions into a Snapshot.
+func snapshotApps(apps map[string]*AppMetrics) map[string][]string {
+	snap := make(map[string][]string, len(apps))
+	for id, m := range apps {
+		entries := make([]string, 0, len(m.Allowed)+len(m.Patterns))
+		for name := range m.Allowed {
+			entries = append(entries, name)
+		}
+		for _, p := range m.Patterns {
+			entries = append(entries, p.Pattern)
+		}
+		slices.Sort(entries)
+		snap[id] = entries
+	}
+	return snap
+}
+
+// cloneSnapshot returns a deep copy of snap, so subscribers may modify what
+// they receive.
+func cloneSnapshot(snap map[string][]string) map[string][]string {
+	out := make(map[string][]string, len(snap))
+	for id, entries := range snap {
+		out[id] = slices.Clone(entries)
+	}
+	return out
+}
+
+// snapshotsEqual returns true if a and b contain the same apps and entries.
+func snapshotsEqual(a, b map[string][]string) bool {
+	return maps.EqualFunc(a, b, slices.Equal[[]string])
+}
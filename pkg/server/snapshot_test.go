@@ -0,0 +1,84 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMetricsDB_Subscribe(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	db := &MetricsDB{}
+	ch, unsubscribe := db.Subscribe()
+
+	ts := setupTestServer(t, map[string]*AllowedMetricsResponse{
+		"foo": {Metrics: []string{"run", "command.*"}},
+	}, 0)
+	params := &MetricsLoadParams{ServerURL: ts.URL, Client: http.DefaultClient}
+	if err := db.Update(ctx, params); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	want := map[string][]string{"foo": {"command.*", "run"}}
+	select {
+	case got := <-ch:
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("unexpected snapshot (-got,+want): %s", diff)
+		}
+	default:
+		t.Fatalf("expected a snapshot after the first update")
+	}
+	if diff := cmp.Diff(db.Snapshot(), want); diff != "" {
+		t.Errorf("unexpected Snapshot (-got,+want): %s", diff)
+	}
+
+	// An update without changes does not notify.
+	if err := db.Update(ctx, params); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	select {
+	case got := <-ch:
+		t.Errorf("unexpected snapshot after unchanged update: %v", got)
+	default:
+	}
+
+	unsubscribe()
+	if _, ok := <-ch; ok {
+		t.Errorf("expected channel to be closed after unsubscribe")
+	}
+	// Unsubscribing twice is safe.
+	unsubscribe()
+}
+
+func TestMetricsDB_notify_LatestWins(t *testing.T) {
+	t.Parallel()
+
+	db := &MetricsDB{}
+	ch, unsubscribe := db.Subscribe()
+	t.Cleanup(unsubscribe)
+
+	db.notify(map[string][]string{"foo": {"a"}})
+	db.notify(map[string][]string{"foo": {"b"}})
+
+	if diff := cmp.Diff(<-ch, map[string][]string{"foo": {"b"}}); diff != "" {
+		t.Errorf("unexpected snapshot (-got,+want): %s", diff)
+	}
+}
@@ -15,74 +15,227 @@
 package server
 
 import (
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
 
-	"github.com/abcxyz/pkg/renderer"
+	"github.com/abcxyz/abc-updater/pkg/apierror"
 )
 
+const (
+	// maxRequestBodyBytes is the maximum size of a request body as sent on the
+	// wire.
+	maxRequestBodyBytes = 2 << 20 // 2MiB
+
+	// maxDecompressedBodyBytes is the maximum size of a request body after
+	// decompression. Guards against compression bombs.
+	maxDecompressedBodyBytes = 8 << 20 // 8MiB
+)
+
+var errDecompressedBodyTooLarge = errors.New("decompressed request body too large")
+
 // DecodeRequest provides a common implementation of JSON unmarshaling with
 // well-defined error handling.
 //
 // Errors will be written to the provided response writer, with an error returned to the caller to alert them
 // no further processing should happen on the request.
 //
+// Request bodies with a Content-Encoding of gzip are transparently
+// decompressed.
+//
 // It automatically closes the request body to prevent leaking.
 // TODO: move this to abcxyz/pkg.
-func DecodeRequest[T any](ctx context.Context, w http.ResponseWriter, r *http.Request, h *renderer.Renderer) (*T, error) {
+func DecodeRequest[T any](ctx context.Context, w http.ResponseWriter, r *http.Request, h JSONRenderer) (*T, error) {
 	req := new(T)
 
 	t := r.Header.Get("content-type")
 	if exp := "application/json"; len(t) < 16 || t[:16] != exp {
 		err := fmt.Errorf("invalid content type: content-type %q is not %q", t, exp)
-		h.RenderJSON(w, http.StatusUnsupportedMediaType, err)
-		return nil, err
+		return nil, rejectRequest(w, h, http.StatusUnsupportedMediaType, apierror.CodeUnsupportedMediaType, err)
 	}
 
 	defer r.Body.Close()
-	body := http.MaxBytesReader(w, r.Body, 2<<20) // 2MiB
+	body, closeBody, err := requestBody(w, r, h)
+	if err != nil {
+		return nil, err
+	}
+	defer closeBody()
 
 	d := json.NewDecoder(body)
 
 	if err := d.Decode(&req); err != nil {
-		var syntaxErr *json.SyntaxError
-		var unmarshalError *json.UnmarshalTypeError
-		switch {
-		case errors.As(err, &syntaxErr):
-			err = fmt.Errorf("malformed json at position %d", syntaxErr.Offset)
-			h.RenderJSON(w, http.StatusBadRequest, err)
-			return nil, err
-		case errors.Is(err, io.ErrUnexpectedEOF):
-			err = fmt.Errorf("malformed json")
-			h.RenderJSON(w, http.StatusBadRequest, err)
-			return nil, err
-		case errors.As(err, &unmarshalError):
-			err = fmt.Errorf("invalid value for %q at position %d (expected %s, got %s)",
-				unmarshalError.Field, unmarshalError.Offset, unmarshalError.Type, unmarshalError.Value)
-			h.RenderJSON(w, http.StatusBadRequest, err)
-			return nil, err
-		case errors.Is(err, io.EOF):
-			err = fmt.Errorf("body must not be empty")
-			h.RenderJSON(w, http.StatusBadRequest, err)
-			return nil, err
-		case err.Error() == "http: request body too large":
-			err = fmt.Errorf("request body too large")
-			h.RenderJSON(w, http.StatusRequestEntityTooLarge, err)
-			return nil, err
-		default:
-			err = fmt.Errorf("failed to decode request as json: %w", err)
-			h.RenderJSON(w, http.StatusBadRequest, err)
-			return nil, err
-		}
+		status, code, err := decodeError(err)
+		return nil, rejectRequest(w, h, status, code, err)
 	}
 	if d.More() {
 		err := fmt.Errorf("body contained more than one json object")
-		h.RenderJSON(w, http.StatusBadRequest, err)
-		return nil, err
+		return nil, rejectRequest(w, h, http.StatusBadRequest, apierror.CodeTrailingData, err)
 	}
 	return req, nil
 }
+
+// decodeError converts an error from decoding a JSON body returned by
+// requestBody into the status, error code, and message to respond with.
+func decodeError(err error) (int, apierror.Code, error) {
+	var syntaxErr *json.SyntaxError
+	var unmarshalError *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return http.StatusBadRequest, apierror.CodeMalformedJSON, fmt.Errorf("malformed json at position %d", syntaxErr.Offset)
+	case errors.Is(err, gzip.ErrChecksum), errors.Is(err, gzip.ErrHeader):
+		return http.StatusBadRequest, apierror.CodeMalformedGzip, fmt.Errorf("malformed gzip body")
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return http.StatusBadRequest, apierror.CodeMalformedJSON, fmt.Errorf("malformed json")
+	case errors.As(err, &unmarshalError):
+		return http.StatusBadRequest, apierror.CodeInvalidFieldType, fmt.Errorf("invalid value for %q at position %d (expected %s, got %s)",
+			unmarshalError.Field, unmarshalError.Offset, unmarshalError.Type, unmarshalError.Value)
+	case errors.Is(err, io.EOF):
+		return http.StatusBadRequest, apierror.CodeEmptyBody, fmt.Errorf("body must not be empty")
+	case bodyTooLarge(err):
+		return http.StatusRequestEntityTooLarge, apierror.CodeRequestTooLarge, fmt.Errorf("request body too large")
+	default:
+		return http.StatusBadRequest, apierror.CodeMalformedRequest, fmt.Errorf("failed to decode request as json: %w", err)
+	}
+}
+
+// requestBody returns r's body, limited in size and decompressed according to
+// its Content-Encoding, and a function to call when done reading it. Errors
+// are written to w.
+func requestBody(w http.ResponseWriter, r *http.Request, h JSONRenderer) (io.Reader, func(), error) {
+	var body io.Reader = http.MaxBytesReader(w, r.Body, maxRequestBodyBytes)
+
+	switch enc := strings.ToLower(strings.TrimSpace(r.Header.Get("content-encoding"))); enc {
+	case "", "identity":
+		return body, func() {}, nil
+	case "gzip":
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			err = fmt.Errorf("malformed gzip body")
+			return nil, nil, rejectRequest(w, h, http.StatusBadRequest, apierror.CodeMalformedGzip, err)
+		}
+		return &limitedReader{r: gz, n: maxDecompressedBodyBytes}, func() { gz.Close() }, nil
+	default:
+		err := fmt.Errorf("unsupported content encoding %q", enc)
+		return nil, nil, rejectRequest(w, h, http.StatusUnsupportedMediaType, apierror.CodeUnsupportedEncoding, err)
+	}
+}
+
+// bodyTooLarge returns true if err is from reading a body returned by
+// requestBody past its limit.
+func bodyTooLarge(err error) bool {
+	return err.Error() == "http: request body too large" || errors.Is(err, errDecompressedBodyTooLarge)
+}
+
+// decodeRejections counts requests rejected by DecodeRequest, by status and
+// error code, so operators can see how much traffic is malformed.
+var decodeRejections = DefaultRegistry.NewCounter("abc_updater_decode_rejections_total",
+	"Requests rejected because their body could not be decoded, by status and error code.",
+	"status", "code")
+
+// rejectRequest renders an error response for a request DecodeRequest could
+// not decode, counts it, and returns err.
+func rejectRequest(w http.ResponseWriter, h JSONRenderer, status int, code apierror.Code, err error) error {
+	decodeRejections.Inc(strconv.Itoa(status), string(code))
+	h.RenderJSON(w, status, apierror.New(code, "%s", err))
+	return err
+}
+
+// limitedReader is like io.LimitedReader, but returns
+// errDecompressedBodyTooLarge rather than io.EOF once the limit is exceeded so
+// that truncated bodies are not mistaken for complete ones.
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		return 0, errDecompressedBodyTooLarge
+	}
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	return n, err //nolint:wrapcheck // Must not wrap io.EOF.
+}
+
+// GzipHandler wraps the provided handler, compressing responses with gzip
+// when the client indicates support via the Accept-Encoding header.
+func GzipHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r) || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, v := range strings.Split(r.Header.Get("accept-encoding"), ",") {
+		enc, _, _ := strings.Cut(strings.TrimSpace(v), ";")
+		if strings.EqualFold(enc, "gzip") {
+			return true
+		}
+	}
+	return false
+}
+
+// gzipResponseWriter compresses the response body. The gzip stream is only
+// started once a body is written, so bodyless responses (e.g. 304) are left
+// untouched.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+	compress    bool
+}
+
+func (g *gzipResponseWriter) WriteHeader(code int) {
+	if g.wroteHeader {
+		return
+	}
+	g.wroteHeader = true
+	if code != http.StatusNoContent && code != http.StatusNotModified &&
+		g.Header().Get("Content-Encoding") == "" {
+		g.compress = true
+		g.Header().Del("Content-Length")
+		g.Header().Set("Content-Encoding", "gzip")
+	}
+	g.ResponseWriter.WriteHeader(code)
+}
+
+func (g *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !g.wroteHeader {
+		g.WriteHeader(http.StatusOK)
+	}
+	if !g.compress {
+		return g.ResponseWriter.Write(b) //nolint:wrapcheck // Want passthrough error.
+	}
+	if g.gz == nil {
+		g.gz = gzip.NewWriter(g.ResponseWriter)
+	}
+	return g.gz.Write(b) //nolint:wrapcheck // Want passthrough error.
+}
+
+func (g *gzipResponseWriter) close() {
+	if !g.compress {
+		return
+	}
+	if g.gz == nil {
+		// Headers promised gzip, so emit a valid empty stream.
+		g.gz = gzip.NewWriter(g.ResponseWriter)
+	}
+	g.gz.Close()
+}
@@ -0,0 +1,94 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGzipHandler(t *testing.T) {
+	t.Parallel()
+
+	const body = `{"appId":"foo"}`
+
+	cases := []struct {
+		name           string
+		acceptEncoding string
+		wantEncoding   string
+	}{
+		{
+			name:           "gzip_accepted",
+			acceptEncoding: "gzip, deflate",
+			wantEncoding:   "gzip",
+		},
+		{
+			name:           "gzip_accepted_with_quality",
+			acceptEncoding: "br;q=1.0, gzip;q=0.8",
+			wantEncoding:   "gzip",
+		},
+		{
+			name:         "no_accept_encoding",
+			wantEncoding: "",
+		},
+		{
+			name:           "gzip_not_accepted",
+			acceptEncoding: "br",
+			wantEncoding:   "",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			h := GzipHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, body)
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/foo/data.json", nil)
+			if tc.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tc.acceptEncoding)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			resp := w.Result()
+			defer resp.Body.Close()
+
+			if got, want := resp.Header.Get("Content-Encoding"), tc.wantEncoding; got != want {
+				t.Errorf("unexpected content encoding. got %q want %q", got, want)
+			}
+
+			var r io.Reader = resp.Body
+			if tc.wantEncoding == "gzip" {
+				gz, err := gzip.NewReader(resp.Body)
+				if err != nil {
+					t.Fatalf("failed to read gzip body: %s", err.Error())
+				}
+				r = gz
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("failed to read body: %s", err.Error())
+			}
+			if string(got) != body {
+				t.Errorf("unexpected body. got %q want %q", string(got), body)
+			}
+		})
+	}
+}
@@ -0,0 +1,278 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abcxyz/abc-updater/pkg/apierror"
+	"github.com/abcxyz/pkg/logging"
+)
+
+const (
+	// DefaultStatsWindow is the number of days reported if no window is
+	// requested.
+	DefaultStatsWindow = 30
+	// MaxStatsWindow is the most days which can be requested, and how long
+	// MemoryStats keeps counts.
+	MaxStatsWindow = 90
+)
+
+// Assert types satisfy their interfaces.
+var (
+	_ MetricSink = (*StatsSink)(nil)
+	_ StatsStore = (*MemoryStats)(nil)
+)
+
+// DayStats are the counts for an app on a single UTC day, by app version and
+// then metric name.
+type DayStats map[string]map[string]int64
+
+// add adds count to the total for version and name.
+func (d DayStats) add(version, name string, count int64) {
+	if d[version] == nil {
+		d[version] = make(map[string]int64)
+	}
+	d[version][name] += count
+}
+
+// StatsStore aggregates accepted metrics into daily counts, for app owners to
+// read with HandleAppStats. Days are UTC dates, e.g. "2024-01-02".
+type StatsStore interface {
+	// IncrementStats adds m's count to its app's totals for day.
+	IncrementStats(ctx context.Context, day string, m *MetricRecord) error
+	// LoadStats returns appID's totals for each of days which has any.
+	LoadStats(ctx context.Context, appID string, days []string) (map[string]DayStats, error)
+}
+
+// StatsSink writes each metric to Next and adds it to Store. Only Next's
+// errors are returned; failing to aggregate is logged, so stats can never
+// affect ingestion.
+type StatsSink struct {
+	Next  MetricSink
+	Store StatsStore
+
+	// now is used in tests. Defaults to time.Now.
+	now func() time.Time
+}
+
+// WriteMetric writes m to Next and aggregates it. Counts from sampled apps are
+// scaled up by the sample rate, so totals estimate all metrics sent.
+func (s *StatsSink) WriteMetric(ctx context.Context, m *MetricRecord) error {
+	if err := s.Next.WriteMetric(ctx, m); err != nil {
+		return err //nolint:wrapcheck // Want passthrough error.
+	}
+
+	now := time.Now
+	if s.now != nil {
+		now = s.now
+	}
+	scaled := *m
+	if m.SampleRate > 0 && m.SampleRate < 1 {
+		scaled.Count = int64(math.Round(float64(m.Count) / m.SampleRate))
+	}
+	// Buffered metrics count toward the day they occurred.
+	day := m.EventTime
+	if day.IsZero() {
+		day = now()
+	}
+	if err := s.Store.IncrementStats(ctx, statsDay(day), &scaled); err != nil {
+		logging.FromContext(ctx).WarnContext(ctx, "failed to aggregate metric stats",
+			"app_id", m.AppID,
+			"name", m.Name,
+			"error", err.Error())
+	}
+	return nil
+}
+
+// MemoryStats is a StatsStore holding counts in memory, for the last
+// MaxStatsWindow days. Counts are per replica and lost on restart, so
+// deployments with several replicas should use a shared store.
+type MemoryStats struct {
+	mu   sync.Mutex
+	apps map[string]map[string]DayStats
+}
+
+// NewMemoryStats returns an empty MemoryStats.
+func NewMemoryStats() *MemoryStats {
+	return &MemoryStats{apps: make(map[string]map[string]DayStats)}
+}
+
+// IncrementStats adds m's count to its app's totals for day, dropping days
+// older than MaxStatsWindow.
+func (s *MemoryStats) IncrementStats(ctx context.Context, day string, m *MetricRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	days := s.apps[m.AppID]
+	if days == nil {
+		days = make(map[string]DayStats)
+		s.apps[m.AppID] = days
+	}
+	if days[day] == nil {
+		days[day] = make(DayStats)
+		if t, err := time.Parse(time.DateOnly, day); err == nil {
+			oldest := statsDay(t.AddDate(0, 0, -MaxStatsWindow))
+			for d := range days {
+				if d < oldest {
+					delete(days, d)
+				}
+			}
+		}
+	}
+	days[day].add(m.AppVersion, m.Name, m.Count)
+	return nil
+}
+
+// LoadStats returns appID's totals for each of days which has any.
+func (s *MemoryStats) LoadStats(ctx context.Context, appID string, days []string) (map[string]DayStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make(map[string]DayStats)
+	for _, day := range days {
+		d, ok := s.apps[appID][day]
+		if !ok {
+			continue
+		}
+		cp := make(DayStats, len(d))
+		for version, names := range d {
+			for name, count := range names {
+				cp.add(version, name, count)
+			}
+		}
+		out[day] = cp
+	}
+	return out, nil
+}
+
+// AppStatsResponse is rendered by HandleAppStats.
+type AppStatsResponse struct {
+	AppID string `json:"appId"`
+	// Window is the requested window, e.g. "30d".
+	Window string `json:"window"`
+	// Since is the first UTC day included. The window ends with today, which
+	// is incomplete.
+	Since string `json:"since"`
+	// Metrics are the total counts by metric name.
+	Metrics map[string]int64 `json:"metrics"`
+	// Versions are the total counts by app version, then metric name.
+	Versions map[string]map[string]int64 `json:"versions"`
+}
+
+// RequireAppToken is like RequireScope, but also accepts the app owner's
+// token from appTokens, keyed by the "id" path value, which grants access to
+// only that app. Empty tokens never match.
+func RequireAppToken(h JSONRenderer, auth *TenantAuth, db AppLister, appTokens map[string]string, next http.Handler) http.Handler {
+	scoped := RequireScope(h, auth, db, next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, want := bearerToken(r), appTokens[r.PathValue("id")]
+		if got != "" && want != "" && subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		scoped.ServeHTTP(w, r)
+	})
+}
+
+// HandleAppStats returns a handler which renders the aggregated counts for the
+// app in the "id" path value, over the number of days in the "window" query
+// parameter, e.g. "7d". It should be registered behind RequireAppToken.
+func HandleAppStats(h JSONRenderer, db AppLister, store StatsStore) http.Handler {
+	return handleAppStats(h, db, store, time.Now)
+}
+
+// handleAppStats implements HandleAppStats with a configurable clock.
+func handleAppStats(h JSONRenderer, db AppLister, store StatsStore, now func() time.Time) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		appID := r.PathValue("id")
+		if appInfo(db, appID) == nil {
+			h.RenderJSON(w, http.StatusNotFound, apierror.New(apierror.CodeUnknownApp, "unknown app %q", appID))
+			return
+		}
+
+		window := r.URL.Query().Get("window")
+		if window == "" {
+			window = fmt.Sprintf("%dd", DefaultStatsWindow)
+		}
+		n, err := parseStatsWindow(window)
+		if err != nil {
+			h.RenderJSON(w, http.StatusBadRequest, apierror.New(apierror.CodeMalformedRequest, "%s", err.Error()))
+			return
+		}
+
+		days := statsDays(now(), n)
+		stats, err := store.LoadStats(r.Context(), appID, days)
+		if err != nil {
+			logging.FromContext(r.Context()).ErrorContext(r.Context(), "failed to load stats",
+				"app_id", appID,
+				"error", err.Error())
+			h.RenderJSON(w, http.StatusInternalServerError, apierror.New(apierror.CodeUnknown, "failed to load stats"))
+			return
+		}
+
+		resp := &AppStatsResponse{
+			AppID:    appID,
+			Window:   window,
+			Since:    days[0],
+			Metrics:  make(map[string]int64),
+			Versions: make(map[string]map[string]int64),
+		}
+		for _, d := range stats {
+			for version, names := range d {
+				if resp.Versions[version] == nil {
+					resp.Versions[version] = make(map[string]int64)
+				}
+				for name, count := range names {
+					resp.Metrics[name] += count
+					resp.Versions[version][name] += count
+				}
+			}
+		}
+		h.RenderJSON(w, http.StatusOK, resp)
+	})
+}
+
+// parseStatsWindow parses a window of whole days, e.g. "30d".
+func parseStatsWindow(window string) (int, error) {
+	s, ok := strings.CutSuffix(window, "d")
+	n, err := strconv.Atoi(s)
+	if !ok || err != nil || n < 1 || n > MaxStatsWindow {
+		return 0, fmt.Errorf("invalid window %q: must be between 1d and %dd", window, MaxStatsWindow)
+	}
+	return n, nil
+}
+
+// statsDays returns the n UTC dates ending with t's, oldest first.
+func statsDays(t time.Time, n int) []string {
+	days := make([]string, 0, n)
+	for i := n - 1; i >= 0; i-- {
+		days = append(days, statsDay(t.AddDate(0, 0, -i)))
+	}
+	return days
+}
+
+// statsDay returns the UTC date of t, e.g. "2024-01-02".
+func statsDay(t time.Time) string {
+	return t.UTC().Format(time.DateOnly)
+}
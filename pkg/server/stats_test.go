@@ -0,0 +1,294 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/abcxyz/pkg/renderer"
+	"github.com/abcxyz/pkg/testutil"
+)
+
+func TestStatsSink(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	now := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+	store := NewMemoryStats()
+	s := &StatsSink{Next: &testSink{}, Store: store, now: func() time.Time { return now }}
+
+	for _, m := range []*MetricRecord{
+		{AppID: "foo", AppVersion: "1.0.0", Name: "run", Count: 1},
+		{AppID: "foo", AppVersion: "1.0.0", Name: "run", Count: 2},
+		{AppID: "foo", AppVersion: "1.1.0", Name: "run", Count: 1, SampleRate: 0.25},
+		{AppID: "bar", AppVersion: "1.0.0", Name: "init", Count: 1},
+	} {
+		if err := s.WriteMetric(ctx, m); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+	}
+
+	got, err := store.LoadStats(ctx, "foo", []string{"2024-01-01", "2024-01-02"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	want := map[string]DayStats{
+		"2024-01-02": {
+			"1.0.0": {"run": 3},
+			"1.1.0": {"run": 4},
+		},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("unexpected stats (-got,+want): %s", diff)
+	}
+
+	// Metrics rejected by the next sink are not counted.
+	s.Next = &testSink{err: fmt.Errorf("sink down")}
+	err = s.WriteMetric(ctx, &MetricRecord{AppID: "foo", AppVersion: "1.0.0", Name: "run", Count: 1})
+	if diff := testutil.DiffErrString(err, "sink down"); diff != "" {
+		t.Error(diff)
+	}
+	got, err = store.LoadStats(ctx, "foo", []string{"2024-01-02"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("unexpected stats after failed write (-got,+want): %s", diff)
+	}
+}
+
+func TestMemoryStats_Prunes(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	s := NewMemoryStats()
+	m := &MetricRecord{AppID: "foo", AppVersion: "1.0.0", Name: "run", Count: 1}
+	if err := s.IncrementStats(ctx, "2024-01-01", m); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if err := s.IncrementStats(ctx, "2024-06-01", m); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	got, err := s.LoadStats(ctx, "foo", []string{"2024-01-01", "2024-06-01"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if _, ok := got["2024-01-01"]; ok {
+		t.Errorf("expected old day to be pruned, got %v", got)
+	}
+	if _, ok := got["2024-06-01"]; !ok {
+		t.Errorf("expected new day to be kept, got %v", got)
+	}
+}
+
+func TestRequireAppToken(t *testing.T) {
+	t.Parallel()
+
+	h, err := renderer.New(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("failed to setup test: %s", err.Error())
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux := http.NewServeMux()
+	auth := &TenantAuth{AdminToken: "admin", OwnerTokens: map[string]string{"team-a": "team-a-token"}}
+	mux.Handle("GET /v1/apps/{id}/stats", RequireAppToken(h, auth, testAdminDB(t), map[string]string{"foo": "foo-token"}, ok))
+
+	cases := []struct {
+		name       string
+		appID      string
+		authHeader string
+		wantStatus int
+	}{
+		{
+			name:       "missing_header",
+			appID:      "foo",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "empty_token",
+			appID:      "bar",
+			authHeader: "Bearer ",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "admin_token",
+			appID:      "bar",
+			authHeader: "Bearer admin",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "app_token",
+			appID:      "foo",
+			authHeader: "Bearer foo-token",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "other_app_token",
+			appID:      "bar",
+			authHeader: "Bearer foo-token",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "owner_token",
+			appID:      "foo",
+			authHeader: "Bearer team-a-token",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "other_owner_app",
+			appID:      "bar",
+			authHeader: "Bearer team-a-token",
+			wantStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/apps/"+tc.appID+"/stats", nil)
+			if tc.authHeader != "" {
+				req.Header.Set("Authorization", tc.authHeader)
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+			if got, want := w.Code, tc.wantStatus; got != want {
+				t.Errorf("unexpected response code. got %d want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestHandleAppStats(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	h, err := renderer.New(ctx, nil)
+	if err != nil {
+		t.Fatalf("failed to setup test: %s", err.Error())
+	}
+	now := time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)
+	store := NewMemoryStats()
+	for day, m := range map[string]*MetricRecord{
+		"2024-01-31": {AppID: "foo", AppVersion: "1.2.3", Name: "run", Count: 2},
+		"2024-01-25": {AppID: "foo", AppVersion: "1.2.2", Name: "init", Count: 1},
+		"2024-01-01": {AppID: "foo", AppVersion: "1.2.2", Name: "run", Count: 5},
+		"2023-12-01": {AppID: "foo", AppVersion: "1.0.0", Name: "run", Count: 7},
+	} {
+		if err := store.IncrementStats(ctx, day, m); err != nil {
+			t.Fatalf("failed to setup test: %s", err.Error())
+		}
+	}
+	mux := http.NewServeMux()
+	mux.Handle("GET /v1/apps/{id}/stats", handleAppStats(h, testAdminDB(t), store, func() time.Time { return now }))
+
+	cases := []struct {
+		name       string
+		path       string
+		wantStatus int
+		want       *AppStatsResponse
+	}{
+		{
+			name:       "default_window",
+			path:       "/v1/apps/foo/stats",
+			wantStatus: http.StatusOK,
+			want: &AppStatsResponse{
+				AppID:   "foo",
+				Window:  "30d",
+				Since:   "2024-01-02",
+				Metrics: map[string]int64{"run": 2, "init": 1},
+				Versions: map[string]map[string]int64{
+					"1.2.3": {"run": 2},
+					"1.2.2": {"init": 1},
+				},
+			},
+		},
+		{
+			name:       "longer_window",
+			path:       "/v1/apps/foo/stats?window=31d",
+			wantStatus: http.StatusOK,
+			want: &AppStatsResponse{
+				AppID:   "foo",
+				Window:  "31d",
+				Since:   "2024-01-01",
+				Metrics: map[string]int64{"run": 7, "init": 1},
+				Versions: map[string]map[string]int64{
+					"1.2.3": {"run": 2},
+					"1.2.2": {"init": 1, "run": 5},
+				},
+			},
+		},
+		{
+			name:       "no_stats",
+			path:       "/v1/apps/bar/stats?window=1d",
+			wantStatus: http.StatusOK,
+			want: &AppStatsResponse{
+				AppID:    "bar",
+				Window:   "1d",
+				Since:    "2024-01-31",
+				Metrics:  map[string]int64{},
+				Versions: map[string]map[string]int64{},
+			},
+		},
+		{
+			name:       "unknown_app",
+			path:       "/v1/apps/baz/stats",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "invalid_window",
+			path:       "/v1/apps/foo/stats?window=30",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "window_too_long",
+			path:       "/v1/apps/foo/stats?window=365d",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.path, nil))
+			if got, want := w.Code, tc.wantStatus; got != want {
+				t.Errorf("unexpected response code. got %d want %d", got, want)
+			}
+			if tc.want == nil {
+				return
+			}
+			var got AppStatsResponse
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode response: %s", err.Error())
+			}
+			if diff := cmp.Diff(&got, tc.want); diff != "" {
+				t.Errorf("unexpected response (-got,+want): %s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"maps"
+	"sync"
+)
+
+// Assert MemoryStore satisfies DefinitionStore.
+var _ DefinitionStore = (*MemoryStore)(nil)
+
+// DefinitionStore persists app metrics definitions shared by all replicas of
+// the server. See the firestorestore and redisstore packages for
+// implementations.
+type DefinitionStore interface {
+	// LoadDefinitions returns all stored definitions, keyed by app ID. Returns
+	// an empty map if nothing has been stored.
+	LoadDefinitions(ctx context.Context) (map[string]*AllowedMetricsResponse, error)
+
+	// SaveDefinitions atomically replaces all stored definitions.
+	SaveDefinitions(ctx context.Context, defs map[string]*AllowedMetricsResponse) error
+}
+
+// MemoryStore is a DefinitionStore which keeps definitions in memory. It is
+// only shared within a process, so is mostly useful for testing.
+type MemoryStore struct {
+	mu   sync.RWMutex
+	defs map[string]*AllowedMetricsResponse
+}
+
+// LoadDefinitions returns the definitions last saved.
+func (s *MemoryStore) LoadDefinitions(ctx context.Context) (map[string]*AllowedMetricsResponse, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.defs == nil {
+		return map[string]*AllowedMetricsResponse{}, nil
+	}
+	return maps.Clone(s.defs), nil
+}
+
+// SaveDefinitions replaces the stored definitions.
+func (s *MemoryStore) SaveDefinitions(ctx context.Context, defs map[string]*AllowedMetricsResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.defs = maps.Clone(defs)
+	return nil
+}
@@ -0,0 +1,98 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/abcxyz/pkg/testutil"
+)
+
+func TestMetricsDB_UpdateWithStore(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := &MemoryStore{}
+
+	// The first replica loads definitions and saves them.
+	ts := setupTestServer(t, map[string]*AllowedMetricsResponse{
+		"foo": {Metrics: []string{"run"}},
+	}, 0)
+	first := &MetricsDB{Store: store}
+	if err := first.Update(ctx, &MetricsLoadParams{ServerURL: ts.URL, Client: http.DefaultClient}); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	stored, err := store.LoadDefinitions(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if diff := cmp.Diff(stored, map[string]*AllowedMetricsResponse{"foo": {Metrics: []string{"run"}}}); diff != "" {
+		t.Errorf("unexpected stored definitions (-got,+want): %s", diff)
+	}
+
+	// A second replica which cannot reach the metadata source uses the stored
+	// definitions, but still reports the failure.
+	failing := setupTestServer(t, nil, http.StatusInternalServerError)
+	second := &MetricsDB{Store: store}
+	err = second.Update(ctx, &MetricsLoadParams{ServerURL: failing.URL, Client: http.DefaultClient})
+	if diff := testutil.DiffErrString(err, "could not load manifest"); diff != "" {
+		t.Error(diff)
+	}
+	if diff := cmp.Diff(second.Snapshot(), map[string][]string{"foo": {"run"}}); diff != "" {
+		t.Errorf("unexpected definitions (-got,+want): %s", diff)
+	}
+}
+
+func TestMetricsDB_UpdateWithStore_AppFallback(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := &MemoryStore{}
+	if err := store.SaveDefinitions(ctx, map[string]*AllowedMetricsResponse{
+		"bar": {Metrics: []string{"stored"}},
+	}); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	// "bar" is in the manifest, but its definition cannot be fetched.
+	ts := setupTestServer(t, map[string]*AllowedMetricsResponse{
+		"foo": {Metrics: []string{"run"}},
+		"bar": nil,
+	}, 0)
+	db := &MetricsDB{Store: store}
+	if err := db.Update(ctx, &MetricsLoadParams{ServerURL: ts.URL, Client: http.DefaultClient}); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	want := map[string][]string{
+		"foo": {"run"},
+		"bar": {"stored"},
+	}
+	if diff := cmp.Diff(db.Snapshot(), want); diff != "" {
+		t.Errorf("unexpected definitions (-got,+want): %s", diff)
+	}
+
+	// The stored fallback is saved back, so it is not lost.
+	stored, err := store.LoadDefinitions(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if got, want := len(stored), 2; got != want {
+		t.Errorf("unexpected number of stored definitions. got %d want %d", got, want)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-version"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+	"github.com/abcxyz/abc-updater/pkg/apierror"
+	"github.com/abcxyz/pkg/logging"
+)
+
+// HandleTemplateFreshness returns a http.Handler which reports whether a newer
+// tagged release exists of the template in the "source" query parameter than
+// the "ref" it is pinned to. Templates are matched by repo URL against the
+// components of the app in the "id" path value.
+func HandleTemplateFreshness(h JSONRenderer, db AppDataLookuper) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		appID := r.PathValue("id")
+		source, ref := r.URL.Query().Get("source"), r.URL.Query().Get("ref")
+		if source == "" || ref == "" {
+			h.RenderJSON(w, http.StatusBadRequest, apierror.New(apierror.CodeMalformedRequest, "source and ref are required"))
+			return
+		}
+		pinned, err := version.NewVersion(ref)
+		if err != nil {
+			h.RenderJSON(w, http.StatusBadRequest, apierror.New(apierror.CodeInvalidVersion, "ref %q is not a tagged release", ref))
+			return
+		}
+
+		data, err := db.GetAppData(appID)
+		if err != nil || data.Data == nil {
+			logging.FromContext(r.Context()).DebugContext(r.Context(), "received template freshness request for unknown app",
+				"app_id", appID)
+			h.RenderJSON(w, http.StatusNotFound, apierror.New(apierror.CodeUnknownApp, "unknown app %q", appID))
+			return
+		}
+		if data.Data.Retired.Dropped(time.Now()) {
+			h.RenderJSON(w, http.StatusGone, apierror.New(apierror.CodeAppRetired, "app %q is retired", appID))
+			return
+		}
+
+		comp := findTemplate(data.Data.Components, source)
+		if comp == nil {
+			h.RenderJSON(w, http.StatusNotFound, apierror.New(apierror.CodeUnknownTemplate, "unknown template %q", source))
+			return
+		}
+		latest, err := version.NewVersion(comp.CurrentVersion)
+		if err != nil {
+			// Reported by validateAppData; the template has no known release.
+			h.RenderJSON(w, http.StatusNotFound, apierror.New(apierror.CodeUnknownTemplate, "unknown template %q", source))
+			return
+		}
+
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int64(defaultCacheMaxAge.Seconds())))
+		h.RenderJSON(w, http.StatusOK, &api.TemplateFreshnessResponse{
+			Source:          source,
+			Ref:             ref,
+			ComponentID:     comp.ID,
+			LatestVersion:   comp.CurrentVersion,
+			UpdateAvailable: pinned.LessThan(latest),
+			Severity:        comp.Severity,
+		})
+	})
+}
+
+// findTemplate returns the component whose repo URL is source, or nil if
+// there is none.
+func findTemplate(components []*api.Component, source string) *api.Component {
+	want := normalizeSource(source)
+	for _, c := range components {
+		if c != nil && c.RepoURL != "" && normalizeSource(c.RepoURL) == want {
+			return c
+		}
+	}
+	return nil
+}
+
+// normalizeSource returns a template source URL in a canonical form, so
+// "https://github.com/abcxyz/abc.git/t/foo/" and "github.com/abcxyz/abc/t/foo"
+// match.
+func normalizeSource(source string) string {
+	s := strings.ToLower(strings.TrimSpace(source))
+	if _, rest, ok := strings.Cut(s, "://"); ok {
+		s = rest
+	}
+	s = strings.TrimSuffix(s, "/")
+	s = strings.Replace(s, ".git/", "/", 1)
+	return strings.TrimSuffix(s, ".git")
+}
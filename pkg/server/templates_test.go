@@ -0,0 +1,144 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+	"github.com/abcxyz/pkg/renderer"
+)
+
+func TestHandleTemplateFreshness(t *testing.T) {
+	t.Parallel()
+
+	abc := &AppData{
+		AppID: "abc",
+		Data: &api.AppResponse{AppID: "abc", CurrentVersion: "1.0.0", Components: []*api.Component{
+			{ID: "rest_server", RepoURL: "https://github.com/abcxyz/abc.git/t/rest_server", CurrentVersion: "v1.2.0"},
+			{ID: "broken", RepoURL: "github.com/abcxyz/abc/t/broken", CurrentVersion: "main"},
+		}},
+	}
+	db := &MetricsDB{data: map[string]*AppData{
+		"abc":  abc,
+		"gone": {AppID: "gone", Data: &api.AppResponse{Retired: &api.Retirement{DropAfter: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)}}},
+	}}
+
+	cases := []struct {
+		name       string
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "outdated",
+			path:       "/apps/abc/templates/freshness?source=github.com/abcxyz/abc/t/rest_server&ref=v1.0.0",
+			wantStatus: http.StatusOK,
+			wantBody:   `{"source":"github.com/abcxyz/abc/t/rest_server","ref":"v1.0.0","componentId":"rest_server","latestVersion":"v1.2.0","updateAvailable":true}`,
+		},
+		{
+			name:       "up_to_date",
+			path:       "/apps/abc/templates/freshness?source=https://github.com/abcxyz/abc/t/rest_server/&ref=1.2.0",
+			wantStatus: http.StatusOK,
+			wantBody:   `{"source":"https://github.com/abcxyz/abc/t/rest_server/","ref":"1.2.0","componentId":"rest_server","latestVersion":"v1.2.0","updateAvailable":false}`,
+		},
+		{
+			name:       "missing_ref",
+			path:       "/apps/abc/templates/freshness?source=github.com/abcxyz/abc/t/rest_server",
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `{"code":"MALFORMED_REQUEST","message":"source and ref are required"}`,
+		},
+		{
+			name:       "ref_not_a_release",
+			path:       "/apps/abc/templates/freshness?source=github.com/abcxyz/abc/t/rest_server&ref=deadbeef",
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `{"code":"INVALID_VERSION","message":"ref \"deadbeef\" is not a tagged release"}`,
+		},
+		{
+			name:       "unknown_template",
+			path:       "/apps/abc/templates/freshness?source=github.com/abcxyz/abc/t/other&ref=v1.0.0",
+			wantStatus: http.StatusNotFound,
+			wantBody:   `{"code":"UNKNOWN_TEMPLATE","message":"unknown template \"github.com/abcxyz/abc/t/other\""}`,
+		},
+		{
+			name:       "invalid_latest_version",
+			path:       "/apps/abc/templates/freshness?source=github.com/abcxyz/abc/t/broken&ref=v1.0.0",
+			wantStatus: http.StatusNotFound,
+			wantBody:   `{"code":"UNKNOWN_TEMPLATE","message":"unknown template \"github.com/abcxyz/abc/t/broken\""}`,
+		},
+		{
+			name:       "unknown_app",
+			path:       "/apps/bar/templates/freshness?source=github.com/abcxyz/abc/t/rest_server&ref=v1.0.0",
+			wantStatus: http.StatusNotFound,
+			wantBody:   `{"code":"UNKNOWN_APP","message":"unknown app \"bar\""}`,
+		},
+		{
+			name:       "dropped_app",
+			path:       "/apps/gone/templates/freshness?source=github.com/abcxyz/abc/t/rest_server&ref=v1.0.0",
+			wantStatus: http.StatusGone,
+			wantBody:   `{"code":"APP_RETIRED","message":"app \"gone\" is retired"}`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			h, err := renderer.New(context.Background(), nil)
+			if err != nil {
+				t.Fatalf("failed to setup test: %s", err.Error())
+			}
+			mux := http.NewServeMux()
+			mux.Handle("GET /apps/{id}/templates/freshness", HandleTemplateFreshness(h, db))
+
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.path, nil))
+			resp := w.Result()
+			defer resp.Body.Close()
+
+			if got, want := resp.StatusCode, tc.wantStatus; got != want {
+				t.Errorf("unexpected status. got %d want %d", got, want)
+			}
+			b, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("failed to read body: %s", err.Error())
+			}
+			if got, want := string(b), tc.wantBody; got != want && got != want+"\n" {
+				t.Errorf("unexpected body. got %q want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestNormalizeSource(t *testing.T) {
+	t.Parallel()
+
+	want := "github.com/abcxyz/abc/t/rest_server"
+	for _, source := range []string{
+		"github.com/abcxyz/abc/t/rest_server",
+		"https://github.com/abcxyz/abc/t/rest_server/",
+		"https://github.com/abcxyz/abc.git/t/rest_server",
+		"GitHub.com/abcxyz/abc/t/rest_server",
+	} {
+		if got := normalizeSource(source); got != want {
+			t.Errorf("normalizeSource(%q) = %q, want %q", source, got, want)
+		}
+	}
+}
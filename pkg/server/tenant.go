@@ -0,0 +1,134 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/abcxyz/abc-updater/pkg/apierror"
+)
+
+// scopeKey is the context key for a request's Scope.
+type scopeKey struct{}
+
+// Scope is the set of apps an authenticated admin request may access.
+type Scope struct {
+	// Admin is true for the admin token, which may access every app.
+	Admin bool
+	// Owner is the owner whose token was used, if not Admin.
+	Owner string
+}
+
+// Allows returns true if the scope may access apps owned by owner.
+func (s *Scope) Allows(owner string) bool {
+	if s == nil {
+		return false
+	}
+	return s.Admin || (s.Owner != "" && s.Owner == owner)
+}
+
+// ScopeFromContext returns the Scope set by RequireScope, or nil if there is
+// none.
+func ScopeFromContext(ctx context.Context) *Scope {
+	s, _ := ctx.Value(scopeKey{}).(*Scope)
+	return s
+}
+
+// TenantAuth holds the tokens accepted by RequireScope.
+type TenantAuth struct {
+	// AdminToken may access every app.
+	AdminToken string
+	// OwnerTokens map owner names from the manifest to a token which may
+	// access only that owner's apps.
+	OwnerTokens map[string]string
+}
+
+// scope returns the Scope for token, or nil if it matches no configured
+// token.
+func (a *TenantAuth) scope(token string) *Scope {
+	if token == "" {
+		return nil
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(a.AdminToken)) == 1 {
+		return &Scope{Admin: true}
+	}
+	var match *Scope
+	for owner, t := range a.OwnerTokens {
+		// Check every token, so timing does not reveal which owner matched.
+		if t != "" && subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			match = &Scope{Owner: owner}
+		}
+	}
+	return match
+}
+
+// enabled returns true if any token is configured.
+func (a *TenantAuth) enabled() bool {
+	if a.AdminToken != "" {
+		return true
+	}
+	for _, t := range a.OwnerTokens {
+		if t != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireScope wraps next so it is only served to requests bearing the admin
+// token or an owner token in an "Authorization: Bearer" header, adding the
+// request's Scope to its context. If the route has an "id" path value, owner
+// tokens are only accepted for that owner's apps. If no tokens are
+// configured, all requests are rejected, as with RequireAdminToken.
+func RequireScope(h JSONRenderer, auth *TenantAuth, db AppLister, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !auth.enabled() {
+			h.RenderJSON(w, http.StatusNotFound, apierror.New(apierror.CodeNotFound, "admin endpoints are disabled"))
+			return
+		}
+		scope := auth.scope(bearerToken(r))
+		if scope == nil {
+			h.RenderJSON(w, http.StatusUnauthorized, apierror.New(apierror.CodeUnauthorized, "missing or invalid token"))
+			return
+		}
+		if appID := r.PathValue("id"); appID != "" && !scope.Allows(appOwner(db, appID)) {
+			h.RenderJSON(w, http.StatusForbidden, apierror.New(apierror.CodeForbidden, "token may not access app %q", appID))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), scopeKey{}, scope)))
+	})
+}
+
+// bearerToken returns the token in r's "Authorization: Bearer" header, or ""
+// if there is none.
+func bearerToken(r *http.Request) string {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return ""
+	}
+	return token
+}
+
+// appOwner returns the owner of appID, or "" if it has none or is unknown.
+func appOwner(db AppLister, appID string) string {
+	m, err := db.GetAllowedMetrics(appID)
+	if err != nil {
+		return ""
+	}
+	return m.Owner
+}
@@ -0,0 +1,172 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/abcxyz/pkg/renderer"
+)
+
+func TestRequireScope(t *testing.T) {
+	t.Parallel()
+
+	h, err := renderer.New(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("failed to setup test: %s", err.Error())
+	}
+	db := testAdminDB(t)
+	auth := &TenantAuth{
+		AdminToken:  "admin",
+		OwnerTokens: map[string]string{"team-a": "team-a-token", "team-b": "team-b-token"},
+	}
+	// Renders the request's scope.
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.RenderJSON(w, http.StatusOK, ScopeFromContext(r.Context()))
+	})
+	mux := http.NewServeMux()
+	mux.Handle("GET /admin/apps", RequireScope(h, auth, db, next))
+	mux.Handle("GET /admin/apps/{id}", RequireScope(h, auth, db, next))
+	disabled := RequireScope(h, &TenantAuth{}, db, next)
+
+	cases := []struct {
+		name       string
+		handler    http.Handler
+		path       string
+		authHeader string
+		wantStatus int
+		wantScope  *Scope
+	}{
+		{
+			name:       "disabled_without_tokens",
+			handler:    disabled,
+			path:       "/admin/apps",
+			authHeader: "Bearer ",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "missing_header",
+			path:       "/admin/apps",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "not_bearer",
+			path:       "/admin/apps",
+			authHeader: "admin",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "admin_token",
+			path:       "/admin/apps/bar",
+			authHeader: "Bearer admin",
+			wantStatus: http.StatusOK,
+			wantScope:  &Scope{Admin: true},
+		},
+		{
+			name:       "owner_token_list",
+			path:       "/admin/apps",
+			authHeader: "Bearer team-b-token",
+			wantStatus: http.StatusOK,
+			wantScope:  &Scope{Owner: "team-b"},
+		},
+		{
+			name:       "owner_token_own_app",
+			path:       "/admin/apps/foo",
+			authHeader: "Bearer team-a-token",
+			wantStatus: http.StatusOK,
+			wantScope:  &Scope{Owner: "team-a"},
+		},
+		{
+			name:       "owner_token_other_app",
+			path:       "/admin/apps/foo",
+			authHeader: "Bearer team-b-token",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "owner_token_unowned_app",
+			path:       "/admin/apps/bar",
+			authHeader: "Bearer team-a-token",
+			wantStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			handler := tc.handler
+			if handler == nil {
+				handler = mux
+			}
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			if tc.authHeader != "" {
+				req.Header.Set("Authorization", tc.authHeader)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if got, want := w.Code, tc.wantStatus; got != want {
+				t.Errorf("unexpected response code. got %d want %d", got, want)
+			}
+			if tc.wantScope == nil {
+				return
+			}
+			var got Scope
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode response: %s", err.Error())
+			}
+			if diff := cmp.Diff(&got, tc.wantScope); diff != "" {
+				t.Errorf("unexpected scope (-got,+want): %s", diff)
+			}
+		})
+	}
+}
+
+func TestHandleAdminApps_OwnerScope(t *testing.T) {
+	t.Parallel()
+
+	h, err := renderer.New(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("failed to setup test: %s", err.Error())
+	}
+	db := testAdminDB(t)
+	r := NewRefresher(db, &MetricsLoadParams{}, time.Minute)
+	auth := &TenantAuth{OwnerTokens: map[string]string{"team-a": "team-a-token"}}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/apps", nil)
+	req.Header.Set("Authorization", "Bearer team-a-token")
+	w := httptest.NewRecorder()
+	RequireScope(h, auth, db, HandleAdminApps(h, db, r)).ServeHTTP(w, req)
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Errorf("unexpected response code. got %d want %d", got, want)
+	}
+	var got AdminAppsResponse
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode response: %s", err.Error())
+	}
+	ids := make([]string, 0, len(got.Apps))
+	for _, app := range got.Apps {
+		ids = append(ids, app.AppID)
+	}
+	if diff := cmp.Diff(ids, []string{"foo"}); diff != "" {
+		t.Errorf("unexpected apps (-got,+want): %s", diff)
+	}
+}
@@ -0,0 +1,289 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/hashicorp/go-version"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+)
+
+// maxNameLength is the longest app ID or metric name considered valid.
+const maxNameLength = 128
+
+// MetadataProblem describes an issue found while loading app metadata. Problems
+// do not prevent metadata from loading, but likely result in confusing client
+// behavior.
+type MetadataProblem struct {
+	// AppID is empty for problems with the manifest itself.
+	AppID   string `json:"appId,omitempty"`
+	Message string `json:"message"`
+}
+
+// MetadataProblemLister lists problems found during the most recent update.
+type MetadataProblemLister interface {
+	MetadataProblems() []*MetadataProblem
+}
+
+func validateManifest(m *ManifestResponse) []*MetadataProblem {
+	var problems []*MetadataProblem
+	seen := make(map[string]struct{}, len(m.MetricsApps))
+	for _, app := range m.MetricsApps {
+		if app == "" {
+			problems = append(problems, &MetadataProblem{Message: "manifest contains an empty app ID"})
+			continue
+		}
+		if _, ok := seen[app]; ok {
+			problems = append(problems, &MetadataProblem{AppID: app, Message: "app is listed more than once in manifest"})
+		}
+		seen[app] = struct{}{}
+		if len(app) > maxNameLength {
+			problems = append(problems, &MetadataProblem{AppID: app, Message: fmt.Sprintf("app ID is longer than %d characters", maxNameLength)})
+		} else if !api.ValidAppID(app) {
+			problems = append(problems, &MetadataProblem{AppID: app, Message: "app ID must only contain letters, digits, '_', '-', and '.', and must not start with '.' or '-'"})
+		}
+	}
+
+	owned := make(map[string]string)
+	names := make([]string, 0, len(m.Owners))
+	for name := range m.Owners {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		if name == "" {
+			problems = append(problems, &MetadataProblem{Message: "manifest contains an empty owner name"})
+		}
+		o := m.Owners[name]
+		if o == nil {
+			continue
+		}
+		for _, p := range validateLogging("", o.Logging) {
+			p.Message = fmt.Sprintf("owner %q: %s", name, p.Message)
+			problems = append(problems, p)
+		}
+		for _, app := range o.Apps {
+			if _, ok := seen[app]; !ok {
+				problems = append(problems, &MetadataProblem{AppID: app, Message: fmt.Sprintf("owner %q lists an app which is not in metricsApps", name)})
+			}
+			if other, ok := owned[app]; ok {
+				problems = append(problems, &MetadataProblem{AppID: app, Message: fmt.Sprintf("app is owned by both %q and %q, using %q", other, name, other)})
+				continue
+			}
+			owned[app] = name
+		}
+	}
+
+	retired := make([]string, 0, len(m.Retired))
+	for app := range m.Retired {
+		retired = append(retired, app)
+	}
+	slices.Sort(retired)
+	for _, app := range retired {
+		if _, ok := seen[app]; !ok {
+			problems = append(problems, &MetadataProblem{AppID: app, Message: "retired app is not in metricsApps"})
+		}
+		if r := m.Retired[app]; r != nil && r.DropAfter.IsZero() {
+			problems = append(problems, &MetadataProblem{AppID: app, Message: "retired app has no dropAfter, dropping immediately"})
+		}
+	}
+	return problems
+}
+
+func validateMetricsDefinition(appID string, def *AllowedMetricsResponse) []*MetadataProblem {
+	if len(def.Metrics) == 0 && len(def.Latency) == 0 && len(def.Retired) == 0 {
+		return []*MetadataProblem{{AppID: appID, Message: "metrics definition is empty"}}
+	}
+
+	var problems []*MetadataProblem
+	seen := make(map[string]struct{}, len(def.Metrics))
+	for _, name := range def.Metrics {
+		if name == "" {
+			problems = append(problems, &MetadataProblem{AppID: appID, Message: "metrics definition contains an empty metric name"})
+			continue
+		}
+		if _, ok := seen[name]; ok {
+			problems = append(problems, &MetadataProblem{AppID: appID, Message: fmt.Sprintf("metric %q is listed more than once", name)})
+		}
+		seen[name] = struct{}{}
+		if len(name) > maxNameLength {
+			problems = append(problems, &MetadataProblem{AppID: appID, Message: fmt.Sprintf("metric %q is longer than %d characters", name, maxNameLength)})
+		}
+	}
+
+	problems = append(problems, validateLatency(appID, def.Latency)...)
+	problems = append(problems, validateRetiredMetrics(appID, def.Retired)...)
+	return append(problems, validateLogging(appID, def.Logging)...)
+}
+
+// validateLatency returns problems with an app's latency metrics.
+func validateLatency(appID string, latency map[string][]string) []*MetadataProblem {
+	metrics := make([]string, 0, len(latency))
+	for metric := range latency {
+		metrics = append(metrics, metric)
+	}
+	slices.Sort(metrics)
+
+	var problems []*MetadataProblem
+	for _, metric := range metrics {
+		if metric == "" || isMetricPattern(metric) {
+			problems = append(problems, &MetadataProblem{AppID: appID, Message: fmt.Sprintf("invalid latency metric name %q", metric)})
+			continue
+		}
+		buckets, err := api.ParseLatencyBuckets(latency[metric])
+		if err != nil {
+			problems = append(problems, &MetadataProblem{AppID: appID, Message: fmt.Sprintf("latency metric %q ignored: %s", metric, err)})
+			continue
+		}
+		// The longest bucket name is the last bound's or the overflow's.
+		for _, name := range []string{api.LatencyBucketMetric(metric, buckets[len(buckets)-1]), api.LatencyBucketMetric(metric, 0)} {
+			if len(name) > maxNameLength {
+				problems = append(problems, &MetadataProblem{AppID: appID, Message: fmt.Sprintf("latency bucket %q is longer than %d characters", name, maxNameLength)})
+				break
+			}
+		}
+	}
+	return problems
+}
+
+// validateRetiredMetrics returns problems with an app's retired metrics.
+func validateRetiredMetrics(appID string, retired map[string]*api.Retirement) []*MetadataProblem {
+	metrics := make([]string, 0, len(retired))
+	for metric := range retired {
+		metrics = append(metrics, metric)
+	}
+	slices.Sort(metrics)
+
+	var problems []*MetadataProblem
+	for _, metric := range metrics {
+		if r := retired[metric]; r == nil || r.DropAfter.IsZero() {
+			problems = append(problems, &MetadataProblem{AppID: appID, Message: fmt.Sprintf("retired metric %q has no dropAfter, dropping immediately", metric)})
+		}
+	}
+	return problems
+}
+
+// validateLogging returns problems with an app's or owner's logging config.
+func validateLogging(appID string, l *MetricLogging) []*MetadataProblem {
+	if l == nil {
+		return nil
+	}
+	var problems []*MetadataProblem
+	if l.Level != "" {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(l.Level)); err != nil {
+			problems = append(problems, &MetadataProblem{AppID: appID, Message: fmt.Sprintf("invalid logging level %q, using INFO", l.Level)})
+		}
+	}
+	if len(l.Sink) > maxNameLength {
+		problems = append(problems, &MetadataProblem{AppID: appID, Message: fmt.Sprintf("logging sink is longer than %d characters", maxNameLength)})
+	}
+	if l.SampleRate < 0 || l.SampleRate > 1 {
+		problems = append(problems, &MetadataProblem{AppID: appID, Message: fmt.Sprintf("logging sampleRate %g is not between 0 and 1, logging all metrics", l.SampleRate)})
+	}
+	return problems
+}
+
+func validateAppData(appID string, data *api.AppResponse) []*MetadataProblem {
+	var problems []*MetadataProblem
+	if data.AppID != "" && data.AppID != appID {
+		problems = append(problems, &MetadataProblem{AppID: appID, Message: fmt.Sprintf("data.json has mismatched appId %q", data.AppID)})
+	}
+	if _, err := version.NewVersion(data.CurrentVersion); err != nil {
+		problems = append(problems, &MetadataProblem{AppID: appID, Message: fmt.Sprintf("invalid currentVersion %q: %s", data.CurrentVersion, err)})
+	}
+	if !data.Severity.Valid() {
+		problems = append(problems, &MetadataProblem{AppID: appID, Message: fmt.Sprintf("unknown severity %q, clients treat it as info", data.Severity)})
+	}
+	for i, a := range data.Advisories {
+		if a == nil || a.ID == "" {
+			problems = append(problems, &MetadataProblem{AppID: appID, Message: fmt.Sprintf("advisories[%d] has no id", i)})
+			continue
+		}
+		if _, err := version.NewConstraint(a.AffectedVersions); err != nil {
+			problems = append(problems, &MetadataProblem{AppID: appID, Message: fmt.Sprintf("advisory %q has invalid affectedVersions %q, clients skip it: %s", a.ID, a.AffectedVersions, err)})
+		}
+		if !a.Severity.Valid() {
+			problems = append(problems, &MetadataProblem{AppID: appID, Message: fmt.Sprintf("advisory %q has unknown severity %q", a.ID, a.Severity)})
+		}
+	}
+	components := make(map[string]struct{}, len(data.Components))
+	for i, c := range data.Components {
+		if c == nil || c.ID == "" {
+			problems = append(problems, &MetadataProblem{AppID: appID, Message: fmt.Sprintf("components[%d] has no id", i)})
+			continue
+		}
+		if _, ok := components[c.ID]; ok {
+			problems = append(problems, &MetadataProblem{AppID: appID, Message: fmt.Sprintf("duplicate component %q", c.ID)})
+		}
+		components[c.ID] = struct{}{}
+		if _, err := version.NewVersion(c.CurrentVersion); err != nil {
+			problems = append(problems, &MetadataProblem{AppID: appID, Message: fmt.Sprintf("component %q has invalid currentVersion %q, clients skip it: %s", c.ID, c.CurrentVersion, err)})
+		}
+		if !c.Severity.Valid() {
+			problems = append(problems, &MetadataProblem{AppID: appID, Message: fmt.Sprintf("component %q has unknown severity %q", c.ID, c.Severity)})
+		}
+	}
+	for i, k := range data.KillSwitches {
+		if k == nil {
+			problems = append(problems, &MetadataProblem{AppID: appID, Message: fmt.Sprintf("killSwitches[%d] is empty", i)})
+			continue
+		}
+		if _, err := version.NewConstraint(k.Versions); err != nil {
+			problems = append(problems, &MetadataProblem{AppID: appID, Message: fmt.Sprintf("killSwitches[%d] has invalid versions %q, it is ignored: %s", i, k.Versions, err)})
+		}
+	}
+	return append(problems, validateClientConfig(appID, data.ClientConfig)...)
+}
+
+// validateClientConfig returns problems with an app's client config.
+func validateClientConfig(appID string, c *api.ClientConfig) []*MetadataProblem {
+	if c == nil {
+		return nil
+	}
+	var problems []*MetadataProblem
+	if c.Versions != "" {
+		if _, err := version.NewConstraint(c.Versions); err != nil {
+			problems = append(problems, &MetadataProblem{AppID: appID, Message: fmt.Sprintf("clientConfig has invalid versions %q, clients ignore it: %s", c.Versions, err)})
+		}
+	}
+	if c.CheckInterval != "" {
+		if d, err := time.ParseDuration(c.CheckInterval); err != nil || d <= 0 {
+			problems = append(problems, &MetadataProblem{AppID: appID, Message: fmt.Sprintf("clientConfig has invalid checkInterval %q, clients check daily", c.CheckInterval)})
+		}
+	}
+	if c.MetricsSampleRate < 0 || c.MetricsSampleRate > 1 {
+		problems = append(problems, &MetadataProblem{AppID: appID, Message: fmt.Sprintf("clientConfig metricsSampleRate %g is not between 0 and 1, clients send all metrics", c.MetricsSampleRate)})
+	}
+	return problems
+}
+
+// HandleDebugMetadata returns a handler which renders problems found in app
+// metadata during the most recent update.
+func HandleDebugMetadata(h JSONRenderer, db MetadataProblemLister) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		problems := db.MetadataProblems()
+		if problems == nil {
+			problems = []*MetadataProblem{}
+		}
+		h.RenderJSON(w, http.StatusOK, map[string]any{"problems": problems})
+	})
+}
@@ -0,0 +1,295 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+	"github.com/abcxyz/abc-updater/pkg/updater"
+	"github.com/abcxyz/pkg/renderer"
+)
+
+func TestValidateManifest(t *testing.T) {
+	t.Parallel()
+
+	long := strings.Repeat("a", maxNameLength+1)
+	cases := []struct {
+		name     string
+		manifest *ManifestResponse
+		want     []*MetadataProblem
+	}{
+		{
+			name:     "happy",
+			manifest: &ManifestResponse{MetricsApps: []string{"foo", "bar"}},
+		},
+		{
+			name:     "duplicate_app",
+			manifest: &ManifestResponse{MetricsApps: []string{"foo", "foo"}},
+			want:     []*MetadataProblem{{AppID: "foo", Message: "app is listed more than once in manifest"}},
+		},
+		{
+			name:     "empty_and_long_app",
+			manifest: &ManifestResponse{MetricsApps: []string{"", long}},
+			want: []*MetadataProblem{
+				{Message: "manifest contains an empty app ID"},
+				{AppID: long, Message: "app ID is longer than 128 characters"},
+			},
+		},
+		{
+			name:     "invalid_app_ids",
+			manifest: &ManifestResponse{MetricsApps: []string{"..", "a/b", "v1.0_app-x"}},
+			want: []*MetadataProblem{
+				{AppID: "..", Message: "app ID must only contain letters, digits, '_', '-', and '.', and must not start with '.' or '-'"},
+				{AppID: "a/b", Message: "app ID must only contain letters, digits, '_', '-', and '.', and must not start with '.' or '-'"},
+			},
+		},
+		{
+			name: "owners",
+			manifest: &ManifestResponse{
+				MetricsApps: []string{"foo", "bar"},
+				Owners: map[string]*api.Owner{
+					"team-a": {Apps: []string{"foo", "baz"}},
+					"team-b": {Apps: []string{"foo", "bar"}, Logging: &api.MetricLogging{Level: "LOUD"}},
+				},
+			},
+			want: []*MetadataProblem{
+				{AppID: "baz", Message: `owner "team-a" lists an app which is not in metricsApps`},
+				{Message: `owner "team-b": invalid logging level "LOUD", using INFO`},
+				{AppID: "foo", Message: `app is owned by both "team-a" and "team-b", using "team-a"`},
+			},
+		},
+		{
+			name: "retired",
+			manifest: &ManifestResponse{
+				MetricsApps: []string{"foo", "bar"},
+				Retired: map[string]*api.Retirement{
+					"foo": {DropAfter: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+					"bar": {},
+					"baz": {DropAfter: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+				},
+			},
+			want: []*MetadataProblem{
+				{AppID: "bar", Message: "retired app has no dropAfter, dropping immediately"},
+				{AppID: "baz", Message: "retired app is not in metricsApps"},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			if diff := cmp.Diff(validateManifest(tc.manifest), tc.want); diff != "" {
+				t.Errorf("unexpected problems. Diff (-got +want): %s", diff)
+			}
+		})
+	}
+}
+
+func TestValidateMetricsDefinition(t *testing.T) {
+	t.Parallel()
+
+	long := strings.Repeat("m", maxNameLength+1)
+	cases := []struct {
+		name string
+		def  *AllowedMetricsResponse
+		want []*MetadataProblem
+	}{
+		{
+			name: "happy",
+			def:  &AllowedMetricsResponse{Metrics: []string{"a", "b"}},
+		},
+		{
+			name: "empty_list",
+			def:  &AllowedMetricsResponse{},
+			want: []*MetadataProblem{{AppID: "foo", Message: "metrics definition is empty"}},
+		},
+		{
+			name: "duplicate_empty_and_long",
+			def:  &AllowedMetricsResponse{Metrics: []string{"a", "a", "", long}},
+			want: []*MetadataProblem{
+				{AppID: "foo", Message: `metric "a" is listed more than once`},
+				{AppID: "foo", Message: "metrics definition contains an empty metric name"},
+				{AppID: "foo", Message: `metric "` + long + `" is longer than 128 characters`},
+			},
+		},
+		{
+			name: "latency_only",
+			def:  &AllowedMetricsResponse{Latency: map[string][]string{"build": nil}},
+		},
+		{
+			name: "invalid_latency",
+			def: &AllowedMetricsResponse{
+				Metrics: []string{"a"},
+				Latency: map[string][]string{
+					"build":   {"1s", "100ms"},
+					"fetch":   {"soon"},
+					"render*": nil,
+					long:      nil,
+				},
+			},
+			want: []*MetadataProblem{
+				{AppID: "foo", Message: `latency metric "build" ignored: bucket "100ms" is not greater than the previous bucket`},
+				{AppID: "foo", Message: `latency metric "fetch" ignored: invalid bucket "soon": time: invalid duration "soon"`},
+				{AppID: "foo", Message: `latency bucket "` + long + `.le_60000ms" is longer than 128 characters`},
+				{AppID: "foo", Message: `invalid latency metric name "render*"`},
+			},
+		},
+		{
+			name: "retired_metrics",
+			def: &AllowedMetricsResponse{
+				Retired: map[string]*api.Retirement{
+					"old":  {DropAfter: time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)},
+					"gone": {},
+				},
+			},
+			want: []*MetadataProblem{
+				{AppID: "foo", Message: `retired metric "gone" has no dropAfter, dropping immediately`},
+			},
+		},
+		{
+			name: "valid_logging",
+			def: &AllowedMetricsResponse{
+				Metrics: []string{"a"},
+				Logging: &MetricLogging{Level: "DEBUG", Sink: "high_volume", SampleRate: 0.1},
+			},
+		},
+		{
+			name: "invalid_logging",
+			def: &AllowedMetricsResponse{
+				Metrics: []string{"a"},
+				Logging: &MetricLogging{Level: "LOUD", Sink: long, SampleRate: 2},
+			},
+			want: []*MetadataProblem{
+				{AppID: "foo", Message: `invalid logging level "LOUD", using INFO`},
+				{AppID: "foo", Message: "logging sink is longer than 128 characters"},
+				{AppID: "foo", Message: "logging sampleRate 2 is not between 0 and 1, logging all metrics"},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			if diff := cmp.Diff(validateMetricsDefinition("foo", tc.def), tc.want); diff != "" {
+				t.Errorf("unexpected problems. Diff (-got +want): %s", diff)
+			}
+		})
+	}
+}
+
+func TestValidateAppData(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name       string
+		data       *updater.AppResponse
+		wantPrefix []string
+	}{
+		{
+			name: "happy",
+			data: &updater.AppResponse{AppID: "foo", CurrentVersion: "1.0.0"},
+		},
+		{
+			name:       "invalid_version",
+			data:       &updater.AppResponse{AppID: "foo", CurrentVersion: "latest"},
+			wantPrefix: []string{`invalid currentVersion "latest"`},
+		},
+		{
+			name:       "mismatched_app_id",
+			data:       &updater.AppResponse{AppID: "bar", CurrentVersion: "1.0.0"},
+			wantPrefix: []string{`data.json has mismatched appId "bar"`},
+		},
+		{
+			name: "invalid_components",
+			data: &updater.AppResponse{AppID: "foo", CurrentVersion: "1.0.0", Components: []*api.Component{
+				{ID: "tmpl", CurrentVersion: "1.0.0"},
+				{ID: "tmpl", CurrentVersion: "1.1.0"},
+				{ID: "plugin", CurrentVersion: "next"},
+				{CurrentVersion: "1.0.0"},
+			}},
+			wantPrefix: []string{
+				`duplicate component "tmpl"`,
+				`component "plugin" has invalid currentVersion "next"`,
+				`components[3] has no id`,
+			},
+		},
+		{
+			name: "invalid_client_config",
+			data: &updater.AppResponse{AppID: "foo", CurrentVersion: "1.0.0", ClientConfig: &api.ClientConfig{
+				Versions:          "newer",
+				CheckInterval:     "weekly",
+				MetricsSampleRate: 2,
+			}},
+			wantPrefix: []string{
+				`clientConfig has invalid versions "newer"`,
+				`clientConfig has invalid checkInterval "weekly"`,
+				`clientConfig metricsSampleRate 2 is not between 0 and 1`,
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			got := validateAppData("foo", tc.data)
+			if len(got) != len(tc.wantPrefix) {
+				t.Fatalf("unexpected number of problems. got %v want %v", got, tc.wantPrefix)
+			}
+			for i, p := range got {
+				if !strings.HasPrefix(p.Message, tc.wantPrefix[i]) {
+					t.Errorf("unexpected problem. got %q want prefix %q", p.Message, tc.wantPrefix[i])
+				}
+			}
+		})
+	}
+}
+
+func TestHandleDebugMetadata(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	h, err := renderer.New(ctx, nil)
+	if err != nil {
+		t.Fatalf("failed to setup test: %s", err.Error())
+	}
+	db := &MetricsDB{problems: []*MetadataProblem{{AppID: "foo", Message: "metrics definition is empty"}}}
+
+	w := httptest.NewRecorder()
+	HandleDebugMetadata(h, db).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/metadata", nil))
+	resp := w.Result()
+	defer resp.Body.Close()
+
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		t.Errorf("unexpected response code. got %d want %d", got, want)
+	}
+	var got struct {
+		Problems []*MetadataProblem `json:"problems"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode response: %s", err.Error())
+	}
+	if diff := cmp.Diff(got.Problems, db.problems); diff != "" {
+		t.Errorf("unexpected problems. Diff (-got +want): %s", diff)
+	}
+}
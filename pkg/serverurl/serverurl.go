@@ -0,0 +1,123 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package serverurl validates and normalizes the base URLs clients use to
+// reach abc-updater servers.
+package serverurl
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// ErrInvalid is returned (wrapped) for any server URL which fails validation.
+var ErrInvalid = errors.New("invalid server URL")
+
+// Options configure Normalize.
+type Options struct {
+	// AllowInsecureLocalhost permits http:// URLs whose host is a loopback
+	// address or "localhost". Intended for local development and tests.
+	AllowInsecureLocalhost bool
+
+	// AllowUnixSocket permits unix:///path/to/socket URLs, for talking to an
+	// on-host forwarder.
+	AllowUnixSocket bool
+}
+
+// unixScheme is the URL scheme for unix domain sockets.
+const unixScheme = "unix"
+
+// Normalize validates raw and returns it in canonical form, without a trailing
+// slash, so paths can be appended directly. URLs must be absolute https URLs
+// with a host and no query, fragment, or user info.
+func Normalize(raw string, opts *Options) (string, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", fmt.Errorf("%w %q: %w", ErrInvalid, raw, err)
+	}
+	if u.Scheme == unixScheme {
+		if !opts.AllowUnixSocket {
+			return "", fmt.Errorf("%w %q: unix sockets are not supported", ErrInvalid, raw)
+		}
+		if u.Host != "" || u.Path == "" || u.RawQuery != "" || u.Fragment != "" {
+			return "", fmt.Errorf("%w %q: must be of the form unix:///path/to/socket", ErrInvalid, raw)
+		}
+		return u.String(), nil
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("%w %q: must include a scheme and host", ErrInvalid, raw)
+	}
+
+	switch u.Scheme {
+	case "https":
+	case "http":
+		if !opts.AllowInsecureLocalhost || !isLocalhost(u.Hostname()) {
+			return "", fmt.Errorf("%w %q: must use https", ErrInvalid, raw)
+		}
+	default:
+		return "", fmt.Errorf("%w %q: unsupported scheme %q", ErrInvalid, raw, u.Scheme)
+	}
+
+	if u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("%w %q: must not include user info, query, or fragment", ErrInvalid, raw)
+	}
+
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = ""
+	return u.String(), nil
+}
+
+// NormalizeList normalizes a comma-separated list of server URLs, in order of
+// preference, as accepted by UPDATER_URL and METRICS_URL. Unix socket URLs
+// cannot be combined with other URLs.
+func NormalizeList(raw string, opts *Options) ([]string, error) {
+	parts := strings.Split(raw, ",")
+	urls := make([]string, 0, len(parts))
+	for _, part := range parts {
+		u, err := Normalize(part, opts)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := SocketPath(u); ok && len(parts) > 1 {
+			return nil, fmt.Errorf("%w %q: unix sockets cannot be combined with other servers", ErrInvalid, raw)
+		}
+		urls = append(urls, u)
+	}
+	return urls, nil
+}
+
+func isLocalhost(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// SocketPath returns the socket path of a normalized unix:// URL. Returns false
+// if u is not a unix socket URL.
+func SocketPath(u string) (string, bool) {
+	path, ok := strings.CutPrefix(u, unixScheme+"://")
+	if !ok {
+		return "", false
+	}
+	return path, true
+}
@@ -0,0 +1,75 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package updater
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/go-version"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+	"github.com/abcxyz/pkg/logging"
+)
+
+// Advisory is a security advisory affecting some versions of an app.
+type Advisory = api.Advisory
+
+// CheckAdvisories fetches the app's security advisories and returns those
+// affecting params.Version, in the order the server lists them. It always
+// fetches, bypassing the local cache, and returns no advisories if the user
+// opted out of all update checks.
+//
+// Advisories with a malformed AffectedVersions constraint are skipped and
+// logged as WARN.
+func CheckAdvisories(ctx context.Context, params *CheckVersionParams) ([]*Advisory, error) {
+	c, err := loadConfig(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	if c.IgnoreAllVersions() {
+		return nil, nil
+	}
+
+	runningVersion, err := version.NewVersion(params.Version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse check version %q: %w", params.Version, err)
+	}
+
+	data, err := params.fetcher(c).FetchAppResponse(ctx, params.AppID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check for advisories: %w", err)
+	}
+
+	logger := logging.FromContext(ctx)
+
+	var matches []*Advisory
+	for _, a := range data.Advisories {
+		if a == nil {
+			continue
+		}
+		affected, err := version.NewConstraint(a.AffectedVersions)
+		if err != nil {
+			logger.WarnContext(ctx, "skipping advisory with invalid affected versions",
+				"advisory", a.ID,
+				"error", err)
+			continue
+		}
+		if affected.Check(runningVersion) {
+			matches = append(matches, a)
+		}
+	}
+	return matches, nil
+}
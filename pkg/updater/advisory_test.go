@@ -0,0 +1,126 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package updater
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/sethvargo/go-envconfig"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+	"github.com/abcxyz/abc-updater/pkg/optout"
+	"github.com/abcxyz/pkg/testutil"
+)
+
+func TestCheckAdvisories(t *testing.T) {
+	t.Parallel()
+
+	oldBug := &Advisory{
+		ID:               "GHSA-0001",
+		Summary:          "old bug",
+		AffectedVersions: "< 1.0.0",
+		FixedVersion:     "1.0.0",
+	}
+	newBug := &Advisory{
+		ID:               "CVE-2024-0002",
+		Summary:          "new bug",
+		Severity:         api.SeverityCritical,
+		AffectedVersions: ">= 1.0.0, < 1.2.0",
+		FixedVersion:     "1.2.0",
+	}
+	malformed := &Advisory{
+		ID:               "GHSA-0003",
+		AffectedVersions: "not a constraint",
+	}
+	data := &AppResponse{
+		AppID:          "sample_app_1",
+		CurrentVersion: "1.2.0",
+		Advisories:     []*Advisory{oldBug, newBug, malformed, nil},
+	}
+
+	cases := []struct {
+		name    string
+		version string
+		env     map[string]string
+		fetcher *staticFetcher
+		want    []*Advisory
+		wantErr string
+	}{
+		{
+			name:    "affected",
+			version: "1.1.0",
+			fetcher: &staticFetcher{data: data},
+			want:    []*Advisory{newBug},
+		},
+		{
+			name:    "affected_by_several",
+			version: "0.9.0",
+			fetcher: &staticFetcher{data: &AppResponse{
+				AppID:      "sample_app_1",
+				Advisories: []*Advisory{oldBug, {ID: "GHSA-0004", AffectedVersions: "<= 0.9.0"}},
+			}},
+			want: []*Advisory{oldBug, {ID: "GHSA-0004", AffectedVersions: "<= 0.9.0"}},
+		},
+		{
+			name:    "not_affected",
+			version: "1.2.0",
+			fetcher: &staticFetcher{data: data},
+		},
+		{
+			name:    "opted_out",
+			version: "1.1.0",
+			env:     map[string]string{optout.IgnoreVersionsEnvVar: "all"},
+			fetcher: &staticFetcher{data: data},
+		},
+		{
+			name:    "invalid_version",
+			version: "one",
+			fetcher: &staticFetcher{data: data},
+			wantErr: `failed to parse check version "one"`,
+		},
+		{
+			name:    "fetch_error",
+			version: "1.1.0",
+			fetcher: &staticFetcher{err: fmt.Errorf("connection refused")},
+			wantErr: "failed to check for advisories: connection refused",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := CheckAdvisories(context.Background(), &CheckVersionParams{
+				AppID:             "sample_app_1",
+				Version:           tc.version,
+				Lookuper:          envconfig.MapLookuper(tc.env),
+				CacheFileOverride: filepath.Join(t.TempDir(), "data.json"),
+				Fetcher:           tc.fetcher,
+			})
+			if diff := testutil.DiffErrString(err, tc.wantErr); diff != "" {
+				t.Error(diff)
+			}
+			if diff := cmp.Diff(got, tc.want); diff != "" {
+				t.Errorf("unexpected advisories (-got,+want): %s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package updater
+
+import (
+	"time"
+
+	"github.com/hashicorp/go-version"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+)
+
+const (
+	// defaultCheckInterval is how long version data is used before it is
+	// fetched again, unless the app's ClientConfig changes it.
+	defaultCheckInterval = 24 * time.Hour
+
+	// minServerCheckInterval bounds how often a ClientConfig can make the
+	// updater check.
+	minServerCheckInterval = time.Hour
+)
+
+// clientConfig returns the ClientConfig in data if it applies to version v.
+// Configs with invalid version constraints are ignored.
+func clientConfig(data *AppResponse, v *version.Version) *api.ClientConfig {
+	c := data.ClientConfig
+	if c == nil || c.Versions == "" {
+		return c
+	}
+	constraint, err := version.NewConstraint(c.Versions)
+	if err != nil || !constraint.Check(v) {
+		return nil
+	}
+	return c
+}
+
+// killSwitch returns the first kill switch in data which matches version v, or
+// nil if v is not killed. Kill switches with invalid version constraints are
+// ignored.
+func killSwitch(data *AppResponse, v *version.Version) *api.KillSwitch {
+	for _, k := range data.KillSwitches {
+		if k == nil {
+			continue
+		}
+		constraint, err := version.NewConstraint(k.Versions)
+		if err == nil && constraint.Check(v) {
+			return k
+		}
+	}
+	return nil
+}
+
+// checkInterval returns how long version data is used before it is fetched
+// again, for version v.
+func checkInterval(data *AppResponse, v *version.Version) time.Duration {
+	c := clientConfig(data, v)
+	if c == nil || c.CheckInterval == "" {
+		return defaultCheckInterval
+	}
+	d, err := time.ParseDuration(c.CheckInterval)
+	if err != nil || d <= 0 {
+		return defaultCheckInterval
+	}
+	return max(d, minServerCheckInterval)
+}
+
+// storedClientConfig returns the ClientConfig in data which applies to
+// version v, to store for the metrics client along with v. If v is killed,
+// metrics are disabled regardless of the config. If no config applies, the
+// stored config is empty, so it replaces any stored for an earlier check.
+func storedClientConfig(data *AppResponse, v *version.Version) *api.StoredClientConfig {
+	stored := &api.StoredClientConfig{AppVersion: v.String()}
+	if c := clientConfig(data, v); c != nil {
+		stored.ClientConfig = *c
+	}
+	if killSwitch(data, v) != nil {
+		stored.DisableMetrics = true
+	}
+	return stored
+}
@@ -0,0 +1,241 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package updater
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/hashicorp/go-version"
+	"github.com/sethvargo/go-envconfig"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+	"github.com/abcxyz/abc-updater/pkg/localstore"
+)
+
+func TestCheck_ClientConfig(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name      string
+		config    *api.ClientConfig
+		kills     []*api.KillSwitch
+		elapsed   time.Duration
+		wantCalls int
+		wantSaved *api.ClientConfig
+	}{
+		{
+			name:      "none",
+			elapsed:   25 * time.Hour,
+			wantCalls: 2,
+		},
+		{
+			name:      "longer_interval",
+			config:    &api.ClientConfig{CheckInterval: "72h", DisableMetrics: true},
+			elapsed:   25 * time.Hour,
+			wantCalls: 1,
+			wantSaved: &api.ClientConfig{CheckInterval: "72h", DisableMetrics: true},
+		},
+		{
+			name:      "interval_floor",
+			config:    &api.ClientConfig{CheckInterval: "1m"},
+			elapsed:   30 * time.Minute,
+			wantCalls: 1,
+			wantSaved: &api.ClientConfig{CheckInterval: "1m"},
+		},
+		{
+			name:      "matching_version",
+			config:    &api.ClientConfig{Versions: "< 1.0.0", MetricsSampleRate: 0.1},
+			elapsed:   25 * time.Hour,
+			wantCalls: 2,
+			wantSaved: &api.ClientConfig{Versions: "< 1.0.0", MetricsSampleRate: 0.1},
+		},
+		{
+			name:      "other_version",
+			config:    &api.ClientConfig{Versions: ">= 1.0.0", CheckInterval: "72h", DisableMetrics: true},
+			elapsed:   25 * time.Hour,
+			wantCalls: 2,
+		},
+		{
+			name:      "invalid_interval",
+			config:    &api.ClientConfig{CheckInterval: "weekly"},
+			elapsed:   25 * time.Hour,
+			wantCalls: 2,
+			wantSaved: &api.ClientConfig{CheckInterval: "weekly"},
+		},
+		{
+			name:      "killed_version",
+			config:    &api.ClientConfig{MetricsSampleRate: 0.1},
+			kills:     []*api.KillSwitch{{Versions: "invalid"}, {Versions: "< 0.1.0"}},
+			elapsed:   25 * time.Hour,
+			wantCalls: 2,
+			wantSaved: &api.ClientConfig{DisableMetrics: true, MetricsSampleRate: 0.1},
+		},
+		{
+			name:      "killed_version_without_config",
+			kills:     []*api.KillSwitch{{Versions: "= 0.0.1"}},
+			elapsed:   25 * time.Hour,
+			wantCalls: 2,
+			wantSaved: &api.ClientConfig{DisableMetrics: true},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			fetcher := &staticFetcher{data: &AppResponse{
+				AppID:          "sample_app_1",
+				AppName:        "Sample App 1",
+				CurrentVersion: "1.0.0",
+				ClientConfig:   tc.config,
+				KillSwitches:   tc.kills,
+			}}
+			now := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+			params := &CheckVersionParams{
+				AppID:             "sample_app_1",
+				Version:           "0.0.1",
+				Lookuper:          envconfig.MapLookuper(nil),
+				CacheFileOverride: filepath.Join(dir, "data.json"),
+				Fetcher:           fetcher,
+				Now:               func() time.Time { return now },
+			}
+
+			start := now
+			for _, elapsed := range []time.Duration{0, tc.elapsed} {
+				now = start.Add(elapsed)
+				if _, err := Check(context.Background(), params); err != nil {
+					t.Fatalf("unexpected error: %s", err.Error())
+				}
+			}
+			if got, want := fetcher.calls, tc.wantCalls; got != want {
+				t.Errorf("unexpected number of fetches. got %d want %d", got, want)
+			}
+
+			var saved *api.StoredClientConfig
+			if err := localstore.LoadJSONFile(filepath.Join(dir, api.ClientConfigFileName), &saved); err != nil && !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("failed to load client config: %s", err.Error())
+			}
+			// Without a config, an empty one replaces any stored earlier.
+			want := &api.StoredClientConfig{AppVersion: "0.0.1"}
+			if tc.wantSaved != nil {
+				want.ClientConfig = *tc.wantSaved
+			}
+			if diff := cmp.Diff(saved, want); diff != "" {
+				t.Errorf("unexpected stored client config (-got,+want): %s", diff)
+			}
+		})
+	}
+}
+
+func TestCheck_InterruptedStore(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		// stagedDir is where the interrupted transaction left its files.
+		stagedDir     string
+		wantCalls     int
+		wantKilled    bool
+		wantLeftovers bool
+	}{
+		{
+			name:       "after_commit_point",
+			stagedDir:  ".txn-commit",
+			wantCalls:  0,
+			wantKilled: true,
+		},
+		{
+			name:          "before_commit_point",
+			stagedDir:     ".txn-staging-123",
+			wantCalls:     1,
+			wantLeftovers: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			now := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+			response := &AppResponse{
+				AppID:          "sample_app_1",
+				AppName:        "Sample App 1",
+				CurrentVersion: "1.0.0",
+			}
+			newParams := func(dir string, fetcher MetadataFetcher) *CheckVersionParams {
+				return &CheckVersionParams{
+					AppID:             "sample_app_1",
+					Version:           "0.0.1",
+					Lookuper:          envconfig.MapLookuper(nil),
+					CacheFileOverride: filepath.Join(dir, "data.json"),
+					Fetcher:           fetcher,
+					Now:               func() time.Time { return now },
+				}
+			}
+			running, err := version.NewVersion("0.0.1")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Stale version data, checked two days ago, without a kill switch.
+			dir := t.TempDir()
+			stale := &LocalVersionData{LastCheckTimestamp: now.Add(-48 * time.Hour).Unix(), AppResponse: *response}
+			if err := storeFetchedData(newParams(dir, nil), defaultCacheKey, stale, running); err != nil {
+				t.Fatal(err)
+			}
+
+			// A later check found a kill switch, and was interrupted after
+			// staging its writes.
+			killed := *response
+			killed.KillSwitches = []*api.KillSwitch{{Versions: "= 0.0.1"}}
+			stagedFrom := t.TempDir()
+			fresh := &LocalVersionData{LastCheckTimestamp: now.Unix(), AppResponse: killed}
+			if err := storeFetchedData(newParams(stagedFrom, nil), defaultCacheKey, fresh, running); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Rename(stagedFrom, filepath.Join(dir, tc.stagedDir)); err != nil {
+				t.Fatal(err)
+			}
+
+			fetcher := &staticFetcher{data: response}
+			if _, err := Check(context.Background(), newParams(dir, fetcher)); err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if got, want := fetcher.calls, tc.wantCalls; got != want {
+				t.Errorf("unexpected number of fetches. got %d want %d", got, want)
+			}
+
+			var saved api.StoredClientConfig
+			if err := localstore.LoadJSONFile(filepath.Join(dir, api.ClientConfigFileName), &saved); err != nil {
+				t.Fatalf("failed to load client config: %s", err.Error())
+			}
+			if got, want := saved.DisableMetrics, tc.wantKilled; got != want {
+				t.Errorf("unexpected stored DisableMetrics. got %t want %t", got, want)
+			}
+
+			_, err = os.Stat(filepath.Join(dir, tc.stagedDir))
+			if got, want := err == nil, tc.wantLeftovers; got != want {
+				t.Errorf("unexpected staged directory left over. got %t want %t (stat error %v)", got, want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package updater
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/hashicorp/go-version"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+	"github.com/abcxyz/pkg/logging"
+)
+
+// Component is a separately versioned part of an app's ecosystem, such as a
+// plugin or template.
+type Component = api.Component
+
+// ComponentAdvice is the update advice for one installed component.
+type ComponentAdvice struct {
+	ID               string
+	Name             string
+	InstalledVersion string
+	// LatestVersion is empty if the app does not list the component.
+	LatestVersion string
+	RepoURL       string
+	Severity      api.Severity
+	// UpdateAvailable is true if LatestVersion is newer than
+	// InstalledVersion. Prereleases only count with IncludePrereleases.
+	UpdateAvailable bool
+}
+
+// CheckComponents fetches the components listed in the app's data.json and
+// returns advice for each installed component, a map of component ID to
+// installed version, sorted by ID. Components the app does not list are
+// returned without a LatestVersion. Like CheckAdvisories, it always fetches,
+// bypassing the local cache, and returns no advice if the user opted out of
+// all update checks.
+//
+// Listed components with a malformed CurrentVersion are treated as unlisted
+// and logged as WARN.
+func CheckComponents(ctx context.Context, params *CheckVersionParams, installed map[string]string) ([]*ComponentAdvice, error) {
+	c, err := loadConfig(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	if c.IgnoreAllVersions() {
+		return nil, nil
+	}
+
+	installedVersions := make(map[string]*version.Version, len(installed))
+	for id, v := range installed {
+		parsed, err := version.NewVersion(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse installed version %q of component %q: %w", v, id, err)
+		}
+		installedVersions[id] = parsed
+	}
+
+	data, err := params.fetcher(c).FetchAppResponse(ctx, params.AppID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check for component updates: %w", err)
+	}
+
+	logger := logging.FromContext(ctx)
+
+	listed := make(map[string]*Component, len(data.Components))
+	for _, comp := range data.Components {
+		if comp != nil {
+			listed[comp.ID] = comp
+		}
+	}
+
+	advice := make([]*ComponentAdvice, 0, len(installed))
+	for id, installedVersion := range installedVersions {
+		a := &ComponentAdvice{
+			ID:               id,
+			Name:             id,
+			InstalledVersion: installedVersion.String(),
+		}
+		advice = append(advice, a)
+
+		comp, ok := listed[id]
+		if !ok {
+			continue
+		}
+		latest, err := version.NewVersion(comp.CurrentVersion)
+		if err != nil {
+			logger.WarnContext(ctx, "skipping component with invalid current version",
+				"component", id,
+				"error", err)
+			continue
+		}
+		if comp.Name != "" {
+			a.Name = comp.Name
+		}
+		a.LatestVersion = latest.String()
+		a.RepoURL = comp.RepoURL
+		a.Severity = comp.Severity
+		a.UpdateAvailable = installedVersion.LessThan(latest) && !c.skipPrerelease(latest)
+	}
+	sort.Slice(advice, func(i, j int) bool {
+		return advice[i].ID < advice[j].ID
+	})
+	return advice, nil
+}
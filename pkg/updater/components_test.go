@@ -0,0 +1,164 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package updater
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/sethvargo/go-envconfig"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+	"github.com/abcxyz/abc-updater/pkg/optout"
+	"github.com/abcxyz/pkg/testutil"
+)
+
+func TestCheckComponents(t *testing.T) {
+	t.Parallel()
+
+	data := &AppResponse{
+		AppID:          "sample_app_1",
+		CurrentVersion: "1.0.0",
+		Components: []*Component{
+			{
+				ID:             "gcs_bucket",
+				Name:           "GCS Bucket",
+				RepoURL:        "https://github.com/abcxyz/templates",
+				CurrentVersion: "1.2.0",
+				Severity:       api.SeveritySecurity,
+			},
+			{ID: "cloud_run", CurrentVersion: "2.0.0"},
+			{ID: "next", CurrentVersion: "3.0.0-rc.1"},
+			{ID: "malformed", CurrentVersion: "latest"},
+			nil,
+		},
+	}
+
+	cases := []struct {
+		name      string
+		installed map[string]string
+		env       map[string]string
+		fetcher   *staticFetcher
+		want      []*ComponentAdvice
+		wantErr   string
+	}{
+		{
+			name: "advice",
+			installed: map[string]string{
+				"gcs_bucket": "1.0.0",
+				"cloud_run":  "v2.0",
+				"unlisted":   "0.1.0",
+			},
+			fetcher: &staticFetcher{data: data},
+			want: []*ComponentAdvice{
+				{
+					ID:               "cloud_run",
+					Name:             "cloud_run",
+					InstalledVersion: "2.0.0",
+					LatestVersion:    "2.0.0",
+				},
+				{
+					ID:               "gcs_bucket",
+					Name:             "GCS Bucket",
+					InstalledVersion: "1.0.0",
+					LatestVersion:    "1.2.0",
+					RepoURL:          "https://github.com/abcxyz/templates",
+					Severity:         api.SeveritySecurity,
+					UpdateAvailable:  true,
+				},
+				{
+					ID:               "unlisted",
+					Name:             "unlisted",
+					InstalledVersion: "0.1.0",
+				},
+			},
+		},
+		{
+			name:      "prerelease_not_available",
+			installed: map[string]string{"next": "2.0.0"},
+			fetcher:   &staticFetcher{data: data},
+			want: []*ComponentAdvice{{
+				ID:               "next",
+				Name:             "next",
+				InstalledVersion: "2.0.0",
+				LatestVersion:    "3.0.0-rc.1",
+			}},
+		},
+		{
+			name:      "prerelease_opt_in",
+			installed: map[string]string{"next": "2.0.0"},
+			env:       map[string]string{"INCLUDE_PRERELEASES": "true"},
+			fetcher:   &staticFetcher{data: data},
+			want: []*ComponentAdvice{{
+				ID:               "next",
+				Name:             "next",
+				InstalledVersion: "2.0.0",
+				LatestVersion:    "3.0.0-rc.1",
+				UpdateAvailable:  true,
+			}},
+		},
+		{
+			name:      "malformed_latest_version",
+			installed: map[string]string{"malformed": "1.0.0"},
+			fetcher:   &staticFetcher{data: data},
+			want: []*ComponentAdvice{{
+				ID:               "malformed",
+				Name:             "malformed",
+				InstalledVersion: "1.0.0",
+			}},
+		},
+		{
+			name:      "opted_out",
+			installed: map[string]string{"gcs_bucket": "1.0.0"},
+			env:       map[string]string{optout.IgnoreVersionsEnvVar: "all"},
+			fetcher:   &staticFetcher{data: data},
+		},
+		{
+			name:      "invalid_installed_version",
+			installed: map[string]string{"gcs_bucket": "main"},
+			fetcher:   &staticFetcher{data: data},
+			wantErr:   `failed to parse installed version "main" of component "gcs_bucket"`,
+		},
+		{
+			name:      "fetch_error",
+			installed: map[string]string{"gcs_bucket": "1.0.0"},
+			fetcher:   &staticFetcher{err: fmt.Errorf("connection refused")},
+			wantErr:   "failed to check for component updates: connection refused",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := CheckComponents(context.Background(), &CheckVersionParams{
+				AppID:             "sample_app_1",
+				Version:           "1.0.0",
+				Lookuper:          envconfig.MapLookuper(tc.env),
+				CacheFileOverride: filepath.Join(t.TempDir(), "data.json"),
+				Fetcher:           tc.fetcher,
+			}, tc.installed)
+			if diff := testutil.DiffErrString(err, tc.wantErr); diff != "" {
+				t.Error(diff)
+			}
+			if diff := cmp.Diff(got, tc.want); diff != "" {
+				t.Errorf("unexpected advice (-got,+want): %s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package updater
+
+import (
+	"context"
+	"sync"
+)
+
+// defaultResult is the check made by DefaultCheck.
+var defaultResult = &onceCheck{}
+
+// DefaultCheck returns the result of a process-wide Check, made with params
+// on the first call. Later calls return the same result and error, and ignore
+// their params, so small tools can ask whether an update is available wherever
+// they need to, e.g. in a version command and in an exit hook, without
+// checking twice or passing the result around. It is safe for concurrent use;
+// concurrent callers wait for the first check.
+func DefaultCheck(ctx context.Context, params *CheckVersionParams) (*CheckResult, error) {
+	return defaultResult.get(func() (*CheckResult, error) {
+		return Check(ctx, params)
+	})
+}
+
+// onceCheck makes a check on first use.
+type onceCheck struct {
+	once   sync.Once
+	result *CheckResult
+	err    error
+}
+
+// get returns the result of check, calling it if it is the first call.
+func (o *onceCheck) get(check func() (*CheckResult, error)) (*CheckResult, error) {
+	o.once.Do(func() {
+		o.result, o.err = check()
+	})
+	return o.result, o.err
+}
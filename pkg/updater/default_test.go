@@ -0,0 +1,89 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package updater
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/sethvargo/go-envconfig"
+)
+
+func TestOnceCheck(t *testing.T) {
+	t.Parallel()
+
+	var o onceCheck
+	var checks atomic.Int32
+	wantErr := errors.New("offline")
+
+	var wg sync.WaitGroup
+	errs := make([]error, 10)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = o.get(func() (*CheckResult, error) {
+				checks.Add(1)
+				return nil, wantErr
+			})
+		}()
+	}
+	wg.Wait()
+
+	if got, want := checks.Load(), int32(1); got != want {
+		t.Errorf("unexpected number of checks. got %d want %d", got, want)
+	}
+	for i, err := range errs {
+		if !errors.Is(err, wantErr) {
+			t.Errorf("call %d: expected the first check's error, got %v", i, err)
+		}
+	}
+}
+
+func TestDefaultCheck(t *testing.T) {
+	t.Parallel()
+
+	params := &CheckVersionParams{
+		AppID:             "sample_app_1",
+		Version:           "1.0.0",
+		Lookuper:          envconfig.MapLookuper(nil),
+		CacheFileOverride: filepath.Join(t.TempDir(), "data.json"),
+		Fetcher: &staticFetcher{data: &AppResponse{
+			AppID:          "sample_app_1",
+			AppName:        "Sample App 1",
+			AppRepoURL:     "https://github.com/abcxyz/sample_app_1",
+			CurrentVersion: "1.1.0",
+		}},
+	}
+	first, err := DefaultCheck(context.Background(), params)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if first == nil || !first.UpdateAvailable {
+		t.Fatalf("expected an update to be available, got %#v", first)
+	}
+
+	got, err := DefaultCheck(context.Background(), &CheckVersionParams{AppID: "other_app"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if got != first {
+		t.Errorf("expected later calls to return the first result")
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package updater
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/abcxyz/abc-updater/pkg/apierror"
+	"github.com/abcxyz/abc-updater/pkg/compat"
+	"github.com/abcxyz/abc-updater/pkg/failover"
+	"github.com/abcxyz/abc-updater/pkg/useragent"
+)
+
+// Assert HTTPFetcher implements MetadataFetcher and TemplateFreshnessFetcher.
+var (
+	_ MetadataFetcher          = (*HTTPFetcher)(nil)
+	_ TemplateFreshnessFetcher = (*HTTPFetcher)(nil)
+)
+
+// MetadataFetcher fetches the version data for an app. Implementations allow
+// version data to come from somewhere other than an HTTP server, e.g. an
+// internal RPC service or metadata embedded in the binary.
+type MetadataFetcher interface {
+	FetchAppResponse(ctx context.Context, appID string) (*AppResponse, error)
+}
+
+// HTTPFetcher is the default MetadataFetcher. It fetches
+// <ServerURL>/<appID>/data.json.
+type HTTPFetcher struct {
+	// ServerURL is the base URL of the server, without a trailing slash.
+	ServerURL string
+
+	// Optional servers to try, in order, if ServerURL is unavailable.
+	FallbackURLs []string
+
+	// Optional backoff state for ServerURL and FallbackURLs, so servers which
+	// recently failed are tried last. If nil, servers are tried in order.
+	Tracker *failover.Tracker
+
+	// Optional client used to make requests. Defaults to a new http.Client.
+	Client *http.Client
+
+	// Optional User-Agent for requests. Defaults to
+	// "abc-updater/<lib-version> (<appID>)".
+	UserAgent string
+}
+
+// FetchAppResponse fetches the version data for an app from the server, or
+// the first available fallback.
+func (f *HTTPFetcher) FetchAppResponse(ctx context.Context, appID string) (*AppResponse, error) {
+	client := f.Client
+	if client == nil {
+		client = &http.Client{}
+	}
+
+	var result *AppResponse
+	urls := append([]string{f.ServerURL}, f.FallbackURLs...)
+	if err := f.Tracker.Do(ctx, urls, func(ctx context.Context, serverURL string) error {
+		var err error
+		result, err = f.fetch(ctx, client, serverURL, appID)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// fetch fetches the version data for an app from a single server.
+func (f *HTTPFetcher) fetch(ctx context.Context, client *http.Client, serverURL, appID string) (*AppResponse, error) {
+	var result AppResponse
+	if err := f.getJSON(ctx, client, fmt.Sprintf(appDataURLFormat, serverURL, appID), appID, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// FetchTemplateFreshness asks the server, or the first available fallback,
+// whether a newer release of the template at source than ref is listed by the
+// app. Only the metrics server supports this; static file hosts do not.
+func (f *HTTPFetcher) FetchTemplateFreshness(ctx context.Context, appID, source, ref string) (*TemplateFreshness, error) {
+	client := f.Client
+	if client == nil {
+		client = &http.Client{}
+	}
+	query := url.Values{"source": {source}, "ref": {ref}}.Encode()
+
+	var result TemplateFreshness
+	urls := append([]string{f.ServerURL}, f.FallbackURLs...)
+	if err := f.Tracker.Do(ctx, urls, func(ctx context.Context, serverURL string) error {
+		u := fmt.Sprintf(templateFreshnessURLFormat, serverURL, appID) + "?" + query
+		return f.getJSON(ctx, client, u, appID, &result)
+	}); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// getJSON fetches u and decodes the JSON response body into result.
+func (f *HTTPFetcher) getJSON(ctx context.Context, client *http.Client, u, appID string, result any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	userAgent := f.UserAgent
+	if userAgent == "" {
+		userAgent = useragent.Format(appID, "")
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "application/json")
+	compat.SetVersionHeader(req.Header)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+	compat.WarnIfUnsupported(ctx, resp.Header)
+
+	if resp.StatusCode != http.StatusOK {
+		return apierror.FromResponse(resp)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("failed to decode response body: %w", err)
+	}
+	return nil
+}
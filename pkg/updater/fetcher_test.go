@@ -0,0 +1,230 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/sethvargo/go-envconfig"
+
+	"github.com/abcxyz/abc-updater/pkg/useragent"
+)
+
+// staticFetcher is a MetadataFetcher which returns fixed results.
+type staticFetcher struct {
+	data  *AppResponse
+	err   error
+	calls int
+}
+
+func (f *staticFetcher) FetchAppResponse(ctx context.Context, appID string) (*AppResponse, error) {
+	f.calls++
+	return f.data, f.err
+}
+
+func TestCheckAppVersionSync_CustomFetcher(t *testing.T) {
+	t.Parallel()
+
+	fetcher := &staticFetcher{data: &AppResponse{
+		AppID:          "embedded_app",
+		AppName:        "Embedded App",
+		AppRepoURL:     "https://github.com/abcxyz/embedded_app",
+		CurrentVersion: "2.0.0",
+	}}
+
+	got, err := CheckAppVersionSync(context.Background(), &CheckVersionParams{
+		AppID:             "embedded_app",
+		Version:           "1.0.0",
+		Lookuper:          envconfig.MapLookuper(nil),
+		CacheFileOverride: filepath.Join(t.TempDir(), "data.json"),
+		Fetcher:           fetcher,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	want := `Embedded App version 2.0.0 is available at [https://github.com/abcxyz/embedded_app]. Use EMBEDDED_APP_IGNORE_VERSIONS="2.0.0" (or "all") to ignore.`
+	if got != want {
+		t.Errorf("unexpected output. got %q want %q", got, want)
+	}
+	if fetcher.calls != 1 {
+		t.Errorf("unexpected number of fetches. got %d want 1", fetcher.calls)
+	}
+}
+
+func TestCheckAppVersionSync_UserAgent(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name      string
+		userAgent string
+		want      string
+	}{
+		{
+			name: "default",
+			want: "abc-updater/" + useragent.LibraryVersion() + " (sample_app_1/1.0.0)",
+		},
+		{
+			name:      "override",
+			userAgent: "my-tool/1.0",
+			want:      "my-tool/1.0",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var got string
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.Header.Get("User-Agent")
+				fmt.Fprint(w, `{"appId":"sample_app_1","currentVersion":"1.0.0"}`)
+			}))
+			t.Cleanup(ts.Close)
+
+			if _, err := CheckAppVersionSync(context.Background(), &CheckVersionParams{
+				AppID:                  "sample_app_1",
+				Version:                "1.0.0",
+				Lookuper:               envconfig.MapLookuper(map[string]string{"UPDATER_URL": ts.URL}),
+				CacheFileOverride:      filepath.Join(t.TempDir(), "data.json"),
+				AllowInsecureLocalhost: true,
+				UserAgent:              tc.userAgent,
+			}); err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if got != tc.want {
+				t.Errorf("unexpected User-Agent. got %q want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCheckAppVersionSync_FallbackServers(t *testing.T) {
+	t.Parallel()
+
+	var primaryCalls int
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		primaryCalls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(primary.Close)
+
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"appId":"sample_app_1","appName":"Sample App 1","currentVersion":"2.0.0"}`)
+	}))
+	t.Cleanup(mirror.Close)
+
+	dir := t.TempDir()
+	params := &CheckVersionParams{
+		AppID:                  "sample_app_1",
+		Version:                "1.0.0",
+		Lookuper:               envconfig.MapLookuper(map[string]string{"UPDATER_URL": primary.URL + "," + mirror.URL}),
+		CacheFileOverride:      filepath.Join(dir, "data.json"),
+		AllowInsecureLocalhost: true,
+	}
+
+	for i := 0; i < 2; i++ {
+		// Expire the cache, so each call fetches.
+		if err := setLocalCachedData(params, defaultCacheKey, &LocalVersionData{}); err != nil {
+			t.Fatal(err)
+		}
+		got, err := CheckAppVersionSync(context.Background(), params)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		if !strings.Contains(got, "version 2.0.0 is available") {
+			t.Errorf("expected update from mirror, got %q", got)
+		}
+	}
+
+	// The failed primary is backed off, so the second call goes to the mirror
+	// first.
+	if primaryCalls != 1 {
+		t.Errorf("unexpected number of calls to primary. got %d want 1", primaryCalls)
+	}
+	if _, err := os.Stat(filepath.Join(dir, serverBackoffFileName)); err != nil {
+		t.Errorf("expected backoff state to be persisted: %v", err)
+	}
+}
+
+func TestCheckAppVersionSync_RequestObserver(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"appId":"sample_app_1","currentVersion":"1.0.0"}`)
+	}))
+	t.Cleanup(ts.Close)
+
+	var got []string
+	if _, err := CheckAppVersionSync(context.Background(), &CheckVersionParams{
+		AppID:                  "sample_app_1",
+		Version:                "1.0.0",
+		Lookuper:               envconfig.MapLookuper(map[string]string{"UPDATER_URL": ts.URL}),
+		CacheFileOverride:      filepath.Join(t.TempDir(), "data.json"),
+		AllowInsecureLocalhost: true,
+		RequestObserver: func(method, url string, status int, dur time.Duration) {
+			got = append(got, fmt.Sprintf("%s %s %d", method, url, status))
+		},
+	}); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	want := []string{"GET " + ts.URL + "/sample_app_1/data.json 200"}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("unexpected observed requests (-got,+want): %s", diff)
+	}
+}
+
+func TestCheckAppVersionSync_RetryAfter(t *testing.T) {
+	t.Parallel()
+
+	var calls int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	t.Cleanup(ts.Close)
+
+	params := &CheckVersionParams{
+		AppID:                  "sample_app_1",
+		Version:                "1.0.0",
+		Lookuper:               envconfig.MapLookuper(map[string]string{"UPDATER_URL": ts.URL}),
+		CacheFileOverride:      filepath.Join(t.TempDir(), "data.json"),
+		AllowInsecureLocalhost: true,
+	}
+	if _, err := CheckAppVersionSync(context.Background(), params); err == nil {
+		t.Fatalf("expected error from rate limited server")
+	}
+
+	// The server is not sent another request until the Retry-After elapses.
+	_, err := CheckAppVersionSync(context.Background(), params)
+	if !errors.Is(err, ErrServerCoolingDown) {
+		t.Errorf("expected ErrServerCoolingDown, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("unexpected number of requests. got %d want 1", calls)
+	}
+}
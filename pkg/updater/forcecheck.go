@@ -0,0 +1,143 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package updater
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/hashicorp/go-version"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+)
+
+// CheckResult is the detailed result of Check and ForceCheck.
+type CheckResult struct {
+	AppID   string `json:"appId"`
+	AppName string `json:"appName"`
+	// RunningVersion is the version being checked.
+	RunningVersion string `json:"runningVersion"`
+	// LatestVersion is the newest available version.
+	LatestVersion   string `json:"latestVersion"`
+	AppRepoURL      string `json:"appRepoUrl"`
+	UpdateAvailable bool   `json:"updateAvailable"`
+	// Severity is the importance of upgrading to LatestVersion. Empty means
+	// api.SeverityInfo.
+	Severity api.Severity `json:"severity,omitempty"`
+	// Ignored is true if an update is available, but the user opted out of
+	// notifications for it.
+	Ignored bool `json:"ignored"`
+	// Message is the notice CheckAppVersion would show, or empty if there is
+	// nothing to show. Only set by Check.
+	Message string `json:"message,omitempty"`
+}
+
+// ForceCheck checks for a newer version of an app immediately, bypassing the
+// local cache, and writes progress and errors to w. It is intended for an
+// explicit user command such as "yourtool version --check"; use
+// CheckAppVersion for checks at startup.
+//
+// Opt-out settings do not prevent the check, but are reported in the result.
+// The local cache is updated with the fetched data.
+func ForceCheck(ctx context.Context, params *CheckVersionParams, w io.Writer) (*CheckResult, error) {
+	result, err := forceCheck(ctx, params, w)
+	if err != nil {
+		fmt.Fprintf(w, "Error: %s\n", err)
+		return nil, err
+	}
+	return result, nil
+}
+
+func forceCheck(ctx context.Context, params *CheckVersionParams, w io.Writer) (*CheckResult, error) {
+	c, err := loadConfig(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	runningVersion, err := version.NewVersion(params.Version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse check version %q: %w", params.Version, err)
+	}
+
+	if params.Fetcher == nil {
+		servers := strings.Join(append([]string{c.ServerURL}, c.FallbackURLs...), ", ")
+		fmt.Fprintf(w, "Checking %s for updates to %s...\n", servers, params.AppID)
+	} else {
+		fmt.Fprintf(w, "Checking for updates to %s...\n", params.AppID)
+	}
+	data, err := params.fetcher(c).FetchAppResponse(ctx, params.AppID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check for updates: %w", err)
+	}
+
+	cached := &LocalVersionData{
+		LastCheckTimestamp: params.now().Unix(),
+		AppResponse:        *data,
+	}
+	if prev, err := loadLocalCachedData(params, c.cacheKey()); err == nil {
+		cached.keepNotified(prev)
+	}
+	_ = storeFetchedData(params, c.cacheKey(), cached, runningVersion)
+
+	result, err := checkResult(c, params.AppID, runningVersion, data)
+	if err != nil {
+		return nil, err
+	}
+	if !result.UpdateAvailable {
+		fmt.Fprintf(w, "%s is up to date (version %s).\n", data.AppName, result.RunningVersion)
+		return result, nil
+	}
+
+	if label := defaultMessages.severityLabel(data.Severity); label != "" {
+		fmt.Fprintf(w, "%s: ", label)
+	}
+	fmt.Fprintf(w, "%s version %s is available at [%s] (running %s).\n",
+		data.AppName, result.LatestVersion, data.AppRepoURL, result.RunningVersion)
+	if result.Ignored {
+		fmt.Fprintf(w, "Notifications for this version are ignored by your opt-out settings.\n")
+	}
+	return result, nil
+}
+
+// checkResult compares runningVersion of app appID with the latest version in
+// data.
+func checkResult(c *versionConfig, appID string, runningVersion *version.Version, data *AppResponse) (*CheckResult, error) {
+	latestVersion, err := version.NewVersion(data.CurrentVersion)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse current version %q: %w", data.CurrentVersion, err)
+	}
+
+	result := &CheckResult{
+		AppID:           appID,
+		AppName:         data.AppName,
+		RunningVersion:  runningVersion.String(),
+		LatestVersion:   latestVersion.String(),
+		AppRepoURL:      data.AppRepoURL,
+		UpdateAvailable: runningVersion.LessThan(latestVersion) && !c.skipPrerelease(latestVersion),
+		Severity:        data.Severity,
+	}
+	if !result.UpdateAvailable {
+		return result, nil
+	}
+
+	ignored, err := isIgnored(c, data)
+	if err != nil {
+		return nil, fmt.Errorf("error checking optout: %w", err)
+	}
+	result.Ignored = ignored
+	return result, nil
+}
@@ -0,0 +1,183 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package updater
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/sethvargo/go-envconfig"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+	"github.com/abcxyz/abc-updater/pkg/optout"
+	"github.com/abcxyz/pkg/testutil"
+)
+
+func TestForceCheck(t *testing.T) {
+	t.Parallel()
+
+	data := &AppResponse{
+		AppID:          "sample_app_1",
+		AppName:        "Sample App 1",
+		AppRepoURL:     "https://github.com/abcxyz/sample_app_1",
+		CurrentVersion: "1.0.0",
+	}
+
+	cases := []struct {
+		name       string
+		version    string
+		env        map[string]string
+		fetcher    *staticFetcher
+		want       *CheckResult
+		wantOutput string
+		wantErr    string
+	}{
+		{
+			name:    "update_available",
+			version: "0.1.0",
+			fetcher: &staticFetcher{data: data},
+			want: &CheckResult{
+				AppID:           "sample_app_1",
+				AppName:         "Sample App 1",
+				RunningVersion:  "0.1.0",
+				LatestVersion:   "1.0.0",
+				AppRepoURL:      "https://github.com/abcxyz/sample_app_1",
+				UpdateAvailable: true,
+			},
+			wantOutput: "Checking for updates to sample_app_1...\n" +
+				"Sample App 1 version 1.0.0 is available at [https://github.com/abcxyz/sample_app_1] (running 0.1.0).\n",
+		},
+		{
+			name:    "up_to_date",
+			version: "1.0.0",
+			fetcher: &staticFetcher{data: data},
+			want: &CheckResult{
+				AppID:          "sample_app_1",
+				AppName:        "Sample App 1",
+				RunningVersion: "1.0.0",
+				LatestVersion:  "1.0.0",
+				AppRepoURL:     "https://github.com/abcxyz/sample_app_1",
+			},
+			wantOutput: "Checking for updates to sample_app_1...\n" +
+				"Sample App 1 is up to date (version 1.0.0).\n",
+		},
+		{
+			name:    "prerelease_not_available",
+			version: "1.0.0",
+			fetcher: &staticFetcher{data: &AppResponse{
+				AppID:          "sample_app_1",
+				AppName:        "Sample App 1",
+				AppRepoURL:     "https://github.com/abcxyz/sample_app_1",
+				CurrentVersion: "1.1.0-rc.1",
+			}},
+			want: &CheckResult{
+				AppID:          "sample_app_1",
+				AppName:        "Sample App 1",
+				RunningVersion: "1.0.0",
+				LatestVersion:  "1.1.0-rc.1",
+				AppRepoURL:     "https://github.com/abcxyz/sample_app_1",
+			},
+			wantOutput: "Checking for updates to sample_app_1...\n" +
+				"Sample App 1 is up to date (version 1.0.0).\n",
+		},
+		{
+			name:    "ignored_still_checks",
+			version: "0.1.0",
+			env:     map[string]string{optout.IgnoreVersionsEnvVar: "all"},
+			fetcher: &staticFetcher{data: data},
+			want: &CheckResult{
+				AppID:           "sample_app_1",
+				AppName:         "Sample App 1",
+				RunningVersion:  "0.1.0",
+				LatestVersion:   "1.0.0",
+				AppRepoURL:      "https://github.com/abcxyz/sample_app_1",
+				UpdateAvailable: true,
+				Ignored:         true,
+			},
+			wantOutput: "Checking for updates to sample_app_1...\n" +
+				"Sample App 1 version 1.0.0 is available at [https://github.com/abcxyz/sample_app_1] (running 0.1.0).\n" +
+				"Notifications for this version are ignored by your opt-out settings.\n",
+		},
+		{
+			name:    "critical_not_ignored_by_constraint",
+			version: "0.1.0",
+			env:     map[string]string{optout.IgnoreVersionsEnvVar: "1.0.0"},
+			fetcher: &staticFetcher{data: &AppResponse{
+				AppID:          "sample_app_1",
+				AppName:        "Sample App 1",
+				AppRepoURL:     "https://github.com/abcxyz/sample_app_1",
+				CurrentVersion: "1.0.0",
+				Severity:       api.SeverityCritical,
+			}},
+			want: &CheckResult{
+				AppID:           "sample_app_1",
+				AppName:         "Sample App 1",
+				RunningVersion:  "0.1.0",
+				LatestVersion:   "1.0.0",
+				AppRepoURL:      "https://github.com/abcxyz/sample_app_1",
+				UpdateAvailable: true,
+				Severity:        api.SeverityCritical,
+			},
+			wantOutput: "Checking for updates to sample_app_1...\n" +
+				"CRITICAL security update: Sample App 1 version 1.0.0 is available at [https://github.com/abcxyz/sample_app_1] (running 0.1.0).\n",
+		},
+		{
+			name:    "fetch_error_printed",
+			version: "0.1.0",
+			fetcher: &staticFetcher{err: fmt.Errorf("connection refused")},
+			wantOutput: "Checking for updates to sample_app_1...\n" +
+				"Error: failed to check for updates: connection refused\n",
+			wantErr: "connection refused",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			params := &CheckVersionParams{
+				AppID:             "sample_app_1",
+				Version:           tc.version,
+				Lookuper:          envconfig.MapLookuper(tc.env),
+				CacheFileOverride: filepath.Join(t.TempDir(), "data.json"),
+				Fetcher:           tc.fetcher,
+			}
+			// A fresh cache entry must not prevent the check.
+			if err := setLocalCachedData(params, defaultCacheKey, &LocalVersionData{LastCheckTimestamp: time.Now().Unix()}); err != nil {
+				t.Fatalf("failed to set up cache: %s", err.Error())
+			}
+
+			var out bytes.Buffer
+			got, err := ForceCheck(context.Background(), params, &out)
+			if diff := testutil.DiffErrString(err, tc.wantErr); diff != "" {
+				t.Error(diff)
+			}
+			if diff := cmp.Diff(got, tc.want); diff != "" {
+				t.Errorf("unexpected result (-got,+want): %s", diff)
+			}
+			if diff := cmp.Diff(out.String(), tc.wantOutput); diff != "" {
+				t.Errorf("unexpected output (-got,+want): %s", diff)
+			}
+			if tc.fetcher.calls != 1 {
+				t.Errorf("unexpected number of fetches. got %d want 1", tc.fetcher.calls)
+			}
+		})
+	}
+}
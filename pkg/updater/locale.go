@@ -0,0 +1,216 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package updater
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"text/template"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+)
+
+// Messages are the text/templates for the update notice in one language.
+// Templates are given the fields of versionUpdateDetails: AppName,
+// RemoteVersion, AppRepoURL, OptOutEnvVar, and StaleSince. Empty fields use
+// the English default.
+type Messages struct {
+	// Update announces the new version, e.g.
+	// "{{.AppName}} version {{.RemoteVersion}} is available at [{{.AppRepoURL}}]."
+	Update string `json:"update,omitempty"`
+
+	// OptOut tells the user how to ignore the version, e.g.
+	// `Use {{.OptOutEnvVar}}="{{.RemoteVersion}}" (or "all") to ignore.`
+	OptOut string `json:"optOut,omitempty"`
+
+	// Stale is appended when the notice is based on cached version data.
+	Stale string `json:"stale,omitempty"`
+
+	// SecurityLabel and CriticalLabel prefix notices for security releases.
+	// They are plain text, not templates.
+	SecurityLabel string `json:"securityLabel,omitempty"`
+	CriticalLabel string `json:"criticalLabel,omitempty"`
+}
+
+// defaultMessages are the built-in English messages.
+var defaultMessages = &Messages{
+	Update:        `{{.AppName}} version {{.RemoteVersion}} is available at [{{.AppRepoURL}}].`,
+	OptOut:        `Use {{.OptOutEnvVar}}="{{.RemoteVersion}}" (or "all") to ignore.`,
+	Stale:         `(Could not check for updates; based on version data from {{.StaleSince}}.)`,
+	SecurityLabel: "Security update",
+	CriticalLabel: "CRITICAL security update",
+}
+
+// Catalog maps locales, e.g. "de" or "pt-BR", to the messages for them.
+type Catalog map[string]*Messages
+
+// LoadCatalog reads a Catalog from the JSON files in the root of fsys, each
+// a Messages object named for its locale, e.g. "de.json" or "pt-BR.json".
+// Apps can embed their catalog with go:embed. Every template is checked, so
+// mistakes are found when the catalog is loaded rather than when a notice is
+// shown.
+func LoadCatalog(fsys fs.FS) (Catalog, error) {
+	names, err := fs.Glob(fsys, "*.json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list message catalog: %w", err)
+	}
+	c := make(Catalog, len(names))
+	for _, name := range names {
+		b, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read messages %q: %w", name, err)
+		}
+		var m Messages
+		if err := json.Unmarshal(b, &m); err != nil {
+			return nil, fmt.Errorf("failed to parse messages %q: %w", name, err)
+		}
+		for _, tmpl := range []string{m.Update, m.OptOut, m.Stale} {
+			if _, err := template.New(name).Parse(tmpl); err != nil {
+				return nil, fmt.Errorf("invalid template in messages %q: %w", name, err)
+			}
+		}
+		c[normalizeLocale(strings.TrimSuffix(path.Base(name), ".json"))] = &m
+	}
+	return c, nil
+}
+
+// messages returns the messages for locale, falling back to its language
+// without a region, e.g. "pt" for "pt-BR", and then to English. Fields the
+// matched messages leave empty are filled in from English.
+func (c Catalog) messages(locale string) *Messages {
+	locale = normalizeLocale(locale)
+	m, ok := c[locale]
+	if !ok {
+		lang, _, _ := strings.Cut(locale, "-")
+		m, ok = c[lang]
+	}
+	if !ok {
+		return defaultMessages
+	}
+
+	out := *m
+	fill := func(dst *string, def string) {
+		if *dst == "" {
+			*dst = def
+		}
+	}
+	fill(&out.Update, defaultMessages.Update)
+	fill(&out.OptOut, defaultMessages.OptOut)
+	fill(&out.Stale, defaultMessages.Stale)
+	fill(&out.SecurityLabel, defaultMessages.SecurityLabel)
+	fill(&out.CriticalLabel, defaultMessages.CriticalLabel)
+	return &out
+}
+
+// severityLabel returns the prefix for update messages with severity s, or an
+// empty string for routine and unknown severities.
+func (m *Messages) severityLabel(s api.Severity) string {
+	switch s {
+	case api.SeveritySecurity:
+		return m.SecurityLabel
+	case api.SeverityCritical:
+		return m.CriticalLabel
+	}
+	return ""
+}
+
+// render returns the lines of the notice for d: the update, with any
+// severity label, the opt-out instructions, and a stale data warning if
+// d.StaleSince is set.
+func (m *Messages) render(d *versionUpdateDetails) ([]string, error) {
+	update, err := executeTemplate(m.Update, d)
+	if err != nil {
+		return nil, err
+	}
+	if d.SeverityLabel != "" {
+		update = d.SeverityLabel + ": " + update
+	}
+	optOut, err := executeTemplate(m.OptOut, d)
+	if err != nil {
+		return nil, err
+	}
+	lines := []string{update, optOut}
+	if d.StaleSince != "" {
+		stale, err := executeTemplate(m.Stale, d)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, stale)
+	}
+	return lines, nil
+}
+
+// executeTemplate renders the template text with d.
+func executeTemplate(text string, d *versionUpdateDetails) (string, error) {
+	tmpl, err := template.New("version_update_template").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("failed to create output text template: %w", err)
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, d); err != nil {
+		return "", fmt.Errorf("failed to execute template: %w", err)
+	}
+	return b.String(), nil
+}
+
+// localeEnvVars are checked in order for the user's locale, as by gettext.
+var localeEnvVars = []string{"LC_ALL", "LC_MESSAGES", "LANG"}
+
+// detectLocale returns the user's locale from the environment, or "" if it
+// is unset or the POSIX default.
+func detectLocale(getenv func(string) string) string {
+	for _, key := range localeEnvVars {
+		v := getenv(key)
+		if v == "" {
+			continue
+		}
+		if v == "C" || v == "POSIX" {
+			return ""
+		}
+		return normalizeLocale(v)
+	}
+	return ""
+}
+
+// normalizeLocale converts a POSIX locale such as "pt_BR.UTF-8" to a tag such
+// as "pt-BR".
+func normalizeLocale(locale string) string {
+	locale, _, _ = strings.Cut(locale, ".")
+	locale, _, _ = strings.Cut(locale, "@")
+	lang, region, ok := strings.Cut(strings.ReplaceAll(locale, "_", "-"), "-")
+	if !ok {
+		return strings.ToLower(lang)
+	}
+	return strings.ToLower(lang) + "-" + strings.ToUpper(region)
+}
+
+// messages returns the messages for p's locale.
+func (p *CheckVersionParams) messages() *Messages {
+	return localeMessages(p.Locale, p.Catalog)
+}
+
+// localeMessages returns the messages in c for locale, or for the user's
+// locale if it is empty.
+func localeMessages(locale string, c Catalog) *Messages {
+	if locale == "" {
+		locale = detectLocale(os.Getenv)
+	}
+	return c.messages(locale)
+}
@@ -0,0 +1,189 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package updater
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/sethvargo/go-envconfig"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+	"github.com/abcxyz/pkg/testutil"
+)
+
+var testCatalogFS = fstest.MapFS{
+	"de.json": &fstest.MapFile{Data: []byte(`{
+		"update": "{{.AppName}} Version {{.RemoteVersion}} ist verfügbar unter [{{.AppRepoURL}}].",
+		"optOut": "Mit {{.OptOutEnvVar}}=\"{{.RemoteVersion}}\" (oder \"all\") ignorieren.",
+		"securityLabel": "Sicherheitsupdate"
+	}`)},
+	"pt_BR.json": &fstest.MapFile{Data: []byte(`{"securityLabel": "Atualização de segurança"}`)},
+	"README.md":  &fstest.MapFile{Data: []byte("not a catalog")},
+}
+
+func TestLoadCatalog(t *testing.T) {
+	t.Parallel()
+
+	c, err := LoadCatalog(testCatalogFS)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	cases := []struct {
+		name       string
+		locale     string
+		wantUpdate string
+		wantLabel  string
+	}{
+		{
+			name:       "exact",
+			locale:     "de",
+			wantUpdate: "{{.AppName}} Version {{.RemoteVersion}} ist verfügbar unter [{{.AppRepoURL}}].",
+			wantLabel:  "Sicherheitsupdate",
+		},
+		{
+			name:       "language_fallback",
+			locale:     "de_AT.UTF-8",
+			wantUpdate: "{{.AppName}} Version {{.RemoteVersion}} ist verfügbar unter [{{.AppRepoURL}}].",
+			wantLabel:  "Sicherheitsupdate",
+		},
+		{
+			name:       "missing_fields_in_english",
+			locale:     "pt-BR",
+			wantUpdate: defaultMessages.Update,
+			wantLabel:  "Atualização de segurança",
+		},
+		{
+			name:       "unknown_locale",
+			locale:     "fr",
+			wantUpdate: defaultMessages.Update,
+			wantLabel:  defaultMessages.SecurityLabel,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			m := c.messages(tc.locale)
+			if got, want := m.Update, tc.wantUpdate; got != want {
+				t.Errorf("unexpected update template. got %q want %q", got, want)
+			}
+			if got, want := m.severityLabel(api.SeveritySecurity), tc.wantLabel; got != want {
+				t.Errorf("unexpected security label. got %q want %q", got, want)
+			}
+			if m.OptOut == "" {
+				t.Errorf("expected opt-out template to be filled in")
+			}
+		})
+	}
+}
+
+func TestLoadCatalog_Errors(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		fsys    fstest.MapFS
+		wantErr string
+	}{
+		{
+			name:    "invalid_json",
+			fsys:    fstest.MapFS{"de.json": &fstest.MapFile{Data: []byte(`{`)}},
+			wantErr: `failed to parse messages "de.json"`,
+		},
+		{
+			name:    "invalid_template",
+			fsys:    fstest.MapFS{"de.json": &fstest.MapFile{Data: []byte(`{"update": "{{.AppName"}`)}},
+			wantErr: `invalid template in messages "de.json"`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := LoadCatalog(tc.fsys)
+			if diff := testutil.DiffErrString(err, tc.wantErr); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestDetectLocale(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{name: "unset"},
+		{name: "lang", env: map[string]string{"LANG": "de_DE.UTF-8"}, want: "de-DE"},
+		{name: "lc_all_wins", env: map[string]string{"LC_ALL": "ja_JP", "LANG": "de_DE.UTF-8"}, want: "ja-JP"},
+		{name: "lc_messages", env: map[string]string{"LC_MESSAGES": "fr", "LANG": "de_DE"}, want: "fr"},
+		{name: "posix", env: map[string]string{"LANG": "C"}},
+		{name: "modifier", env: map[string]string{"LANG": "sr_RS@latin"}, want: "sr-RS"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := detectLocale(func(key string) string { return tc.env[key] })
+			if got != tc.want {
+				t.Errorf("unexpected locale. got %q want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCheckAppVersionSync_Locale(t *testing.T) {
+	t.Parallel()
+
+	catalog, err := LoadCatalog(testCatalogFS)
+	if err != nil {
+		t.Fatalf("failed to setup test: %s", err.Error())
+	}
+
+	got, err := CheckAppVersionSync(context.Background(), &CheckVersionParams{
+		AppID:             "sample_app_1",
+		Version:           "0.0.1",
+		Lookuper:          envconfig.MapLookuper(nil),
+		CacheFileOverride: filepath.Join(t.TempDir(), "data.json"),
+		Fetcher: &staticFetcher{data: &AppResponse{
+			AppID:          "sample_app_1",
+			AppName:        "Sample App 1",
+			AppRepoURL:     "https://github.com/abcxyz/sample_app_1",
+			CurrentVersion: "1.0.0",
+			Severity:       api.SeveritySecurity,
+		}},
+		Locale:  "de-DE",
+		Catalog: catalog,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	want := `Sicherheitsupdate: Sample App 1 Version 1.0.0 ist verfügbar unter [https://github.com/abcxyz/sample_app_1]. ` +
+		`Mit SAMPLE_APP_1_IGNORE_VERSIONS="1.0.0" (oder "all") ignorieren.`
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("unexpected output (-got,+want): %s", diff)
+	}
+}
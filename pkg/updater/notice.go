@@ -0,0 +1,195 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package updater
+
+import (
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sethvargo/go-envconfig"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+	"github.com/abcxyz/abc-updater/pkg/optout"
+)
+
+// ANSI escape sequences used by ColorNoticeStyle.
+const (
+	ansiReset  = "\x1b[0m"
+	ansiBold   = "\x1b[1m"
+	ansiRed    = "\x1b[31m"
+	ansiYellow = "\x1b[33m"
+	ansiCyan   = "\x1b[36m"
+)
+
+// ansiPattern matches ANSI SGR escape sequences, which take no space on a
+// terminal.
+var ansiPattern = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// NoticeStyle customizes how FormatNotice renders an update notice, so CLIs
+// can match their own output without rewriting the plain message. Each hook
+// is given plain text and returns it styled. Nil hooks leave text unchanged.
+type NoticeStyle struct {
+	// Severity styles the label of security releases, e.g. "Security update".
+	Severity func(s api.Severity, label string) string
+
+	// Emphasis styles the app name and versions.
+	Emphasis func(text string) string
+
+	// Link styles the app's repo URL.
+	Link func(url string) string
+
+	// Frame arranges the lines of the notice, e.g. in a box. If nil, the lines
+	// are joined with spaces into a single line.
+	Frame func(lines []string) []string
+
+	// Output is where the notice will be written. If it is not a terminal,
+	// the notice is rendered as plain text. If nil, it is assumed to be a
+	// terminal.
+	Output io.Writer
+
+	// Optional Lookuper for NO_COLOR and TERM. Defaults to the OS environment.
+	Lookuper envconfig.Lookuper
+
+	// Locale and Catalog select the language of the notice, as in
+	// CheckVersionParams.
+	Locale  string
+	Catalog Catalog
+}
+
+// ColorNoticeStyle returns a NoticeStyle with colored, boxed output, written
+// to w.
+func ColorNoticeStyle(w io.Writer) *NoticeStyle {
+	return &NoticeStyle{
+		Severity: func(s api.Severity, label string) string {
+			if s == api.SeverityCritical {
+				return ansiBold + ansiRed + label + ansiReset
+			}
+			return ansiBold + ansiYellow + label + ansiReset
+		},
+		Emphasis: func(text string) string { return ansiBold + text + ansiReset },
+		Link:     func(url string) string { return ansiCyan + url + ansiReset },
+		Frame:    BoxFrame,
+		Output:   w,
+	}
+}
+
+// BoxFrame is a NoticeStyle.Frame which draws a border around lines.
+func BoxFrame(lines []string) []string {
+	width := 0
+	for _, l := range lines {
+		width = max(width, visibleWidth(l))
+	}
+	out := make([]string, 0, len(lines)+2)
+	out = append(out, "┌"+strings.Repeat("─", width+2)+"┐")
+	for _, l := range lines {
+		out = append(out, "│ "+l+strings.Repeat(" ", width-visibleWidth(l))+" │")
+	}
+	out = append(out, "└"+strings.Repeat("─", width+2)+"┘")
+	return out
+}
+
+// visibleWidth returns the number of characters s takes on a terminal,
+// ignoring ANSI escape sequences.
+func visibleWidth(s string) int {
+	return utf8.RuneCountInString(ansiPattern.ReplaceAllString(s, ""))
+}
+
+// FormatNotice renders the update notice for result, as shown by
+// CheckAppVersion, using style. It returns an empty string if no update is
+// available or the user opted out of it.
+//
+// Styling is downgraded automatically: if style's Output is not a terminal or
+// TERM is "dumb", the notice is plain text on a single line, and if NO_COLOR
+// is set the notice is framed but not colored. A nil style always renders
+// plain text.
+func FormatNotice(result *CheckResult, style *NoticeStyle) string {
+	if result == nil || !result.UpdateAvailable || result.Ignored {
+		return ""
+	}
+	msgs := style.messages()
+	style = style.effective()
+
+	label := msgs.severityLabel(result.Severity)
+	if label != "" && style.Severity != nil {
+		label = style.Severity(result.Severity, label)
+	}
+	lines, err := msgs.render(&versionUpdateDetails{
+		SeverityLabel: label,
+		AppName:       applyHook(style.Emphasis, result.AppName),
+		AppRepoURL:    applyHook(style.Link, result.AppRepoURL),
+		RemoteVersion: applyHook(style.Emphasis, result.LatestVersion),
+		OptOutEnvVar:  strings.ToUpper(result.AppID) + "_" + optout.IgnoreVersionsEnvVar,
+	})
+	if err != nil {
+		// Only possible with a catalog not checked by LoadCatalog.
+		return ""
+	}
+	if style.Frame == nil {
+		return strings.Join(lines, " ")
+	}
+	return strings.Join(style.Frame(lines), "\n")
+}
+
+// applyHook returns hook(s), or s if hook is nil.
+func applyHook(hook func(string) string, s string) string {
+	if hook == nil {
+		return s
+	}
+	return hook(s)
+}
+
+// effective returns the hooks of s which apply to its output and environment.
+func (s *NoticeStyle) effective() *NoticeStyle {
+	if s == nil || !isTerminal(s.Output) || s.lookup("TERM") == "dumb" {
+		return &NoticeStyle{}
+	}
+	if s.lookup("NO_COLOR") != "" {
+		return &NoticeStyle{Frame: s.Frame}
+	}
+	return s
+}
+
+// messages returns the messages for s's locale.
+func (s *NoticeStyle) messages() *Messages {
+	if s == nil {
+		return localeMessages("", nil)
+	}
+	return localeMessages(s.Locale, s.Catalog)
+}
+
+// lookup returns the value of the environment variable key, or "" if unset.
+func (s *NoticeStyle) lookup(key string) string {
+	if s.Lookuper == nil {
+		return os.Getenv(key)
+	}
+	v, _ := s.Lookuper.Lookup(key)
+	return v
+}
+
+// isTerminal returns true if w is nil or a terminal.
+func isTerminal(w io.Writer) bool {
+	if w == nil {
+		return true
+	}
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
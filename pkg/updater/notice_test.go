@@ -0,0 +1,137 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package updater
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/sethvargo/go-envconfig"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+)
+
+func TestFormatNotice(t *testing.T) {
+	t.Parallel()
+
+	result := &CheckResult{
+		AppID:           "sample_app_1",
+		AppName:         "Sample App 1",
+		RunningVersion:  "1.0.0",
+		LatestVersion:   "1.1.0",
+		AppRepoURL:      "https://github.com/abcxyz/abc-updater",
+		UpdateAvailable: true,
+	}
+	brackets := &NoticeStyle{
+		Severity: func(s api.Severity, label string) string { return "<" + string(s) + ":" + label + ">" },
+		Emphasis: func(text string) string { return "*" + text + "*" },
+		Link:     func(url string) string { return "_" + url + "_" },
+		Lookuper: envconfig.MapLookuper(nil),
+	}
+
+	cases := []struct {
+		name   string
+		result *CheckResult
+		style  *NoticeStyle
+		want   string
+	}{
+		{
+			name:   "plain",
+			result: result,
+			want: `Sample App 1 version 1.1.0 is available at [https://github.com/abcxyz/abc-updater]. ` +
+				`Use SAMPLE_APP_1_IGNORE_VERSIONS="1.1.0" (or "all") to ignore.`,
+		},
+		{
+			name:   "hooks",
+			result: &CheckResult{AppID: "a", AppName: "A", LatestVersion: "2.0.0", AppRepoURL: "u", UpdateAvailable: true, Severity: api.SeveritySecurity},
+			style:  brackets,
+			want:   `<security:Security update>: *A* version *2.0.0* is available at [_u_]. Use A_IGNORE_VERSIONS="*2.0.0*" (or "all") to ignore.`,
+		},
+		{
+			name:   "boxed",
+			result: &CheckResult{AppID: "a", AppName: "A", LatestVersion: "2.0.0", AppRepoURL: "u", UpdateAvailable: true},
+			style:  &NoticeStyle{Frame: BoxFrame, Lookuper: envconfig.MapLookuper(nil)},
+			want: strings.Join([]string{
+				`┌─────────────────────────────────────────────────────┐`,
+				`│ A version 2.0.0 is available at [u].                │`,
+				`│ Use A_IGNORE_VERSIONS="2.0.0" (or "all") to ignore. │`,
+				`└─────────────────────────────────────────────────────┘`,
+			}, "\n"),
+		},
+		{
+			name:   "not_terminal",
+			result: &CheckResult{AppID: "a", AppName: "A", LatestVersion: "2.0.0", AppRepoURL: "u", UpdateAvailable: true},
+			style:  ColorNoticeStyle(&bytes.Buffer{}),
+			want:   `A version 2.0.0 is available at [u]. Use A_IGNORE_VERSIONS="2.0.0" (or "all") to ignore.`,
+		},
+		{
+			name:   "no_color",
+			result: &CheckResult{AppID: "a", AppName: "A", LatestVersion: "2.0.0", AppRepoURL: "u", UpdateAvailable: true},
+			style: &NoticeStyle{
+				Emphasis: brackets.Emphasis,
+				Frame:    func(lines []string) []string { return append([]string{"--"}, lines...) },
+				Lookuper: envconfig.MapLookuper(map[string]string{"NO_COLOR": "1"}),
+			},
+			want: "--\nA version 2.0.0 is available at [u].\nUse A_IGNORE_VERSIONS=\"2.0.0\" (or \"all\") to ignore.",
+		},
+		{
+			name:   "dumb_terminal",
+			result: &CheckResult{AppID: "a", AppName: "A", LatestVersion: "2.0.0", AppRepoURL: "u", UpdateAvailable: true},
+			style: &NoticeStyle{
+				Emphasis: brackets.Emphasis,
+				Lookuper: envconfig.MapLookuper(map[string]string{"TERM": "dumb"}),
+			},
+			want: `A version 2.0.0 is available at [u]. Use A_IGNORE_VERSIONS="2.0.0" (or "all") to ignore.`,
+		},
+		{
+			name:   "no_update",
+			result: &CheckResult{AppID: "a", LatestVersion: "1.0.0"},
+			style:  brackets,
+		},
+		{
+			name:   "ignored",
+			result: &CheckResult{AppID: "a", LatestVersion: "2.0.0", UpdateAvailable: true, Ignored: true},
+			style:  brackets,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := FormatNotice(tc.result, tc.style)
+			if diff := cmp.Diff(got, tc.want); diff != "" {
+				t.Errorf("unexpected notice (-got,+want): %s", diff)
+			}
+		})
+	}
+}
+
+func TestBoxFrame_IgnoresEscapes(t *testing.T) {
+	t.Parallel()
+
+	got := BoxFrame([]string{ansiBold + "ab" + ansiReset, "abc"})
+	want := []string{
+		"┌─────┐",
+		"│ " + ansiBold + "ab" + ansiReset + "  │",
+		"│ abc │",
+		"└─────┘",
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("unexpected frame (-got,+want): %s", diff)
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package updater
+
+import (
+	"context"
+	"io"
+	"math/rand/v2"
+	"time"
+
+	"github.com/abcxyz/pkg/logging"
+)
+
+const (
+	// minCheckInterval bounds how often a single process may hit the server.
+	minCheckInterval = time.Minute
+	// periodicCheckTimeout bounds each individual periodic check.
+	periodicCheckTimeout = 30 * time.Second
+)
+
+// StartPeriodicCheck checks for a newer version of an app every interval
+// (minimum one minute) in a background goroutine, for long-running processes
+// which would otherwise only check at startup. Each check, including the
+// first, is delayed by a random jitter of up to 10% of interval so a fleet of
+// processes started together does not check in lockstep.
+//
+// onUpdate is called from the background goroutine when an update is
+// available that the user has not opted out of. It is called at most once per
+// version. Opt-out settings are reloaded for each check, so changes take
+// effect without a restart. Errors are logged as WARN and retried at the next
+// interval.
+//
+// The returned function stops the checks and waits for any in-progress check
+// to finish. Checks also stop when ctx is canceled.
+func StartPeriodicCheck(ctx context.Context, params *CheckVersionParams, interval time.Duration, onUpdate func(*CheckResult)) func() {
+	interval = max(interval, minCheckInterval)
+	return startPeriodicCheck(ctx, params, interval, interval/10, onUpdate)
+}
+
+// startPeriodicCheck implements StartPeriodicCheck without the minimum
+// interval, for testing.
+func startPeriodicCheck(ctx context.Context, params *CheckVersionParams, interval, maxJitter time.Duration, onUpdate func(*CheckResult)) func() {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		timer := time.NewTimer(jitter(maxJitter))
+		defer timer.Stop()
+
+		var reported string
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+			}
+
+			if result := periodicCheck(ctx, params); result != nil && result.LatestVersion != reported {
+				reported = result.LatestVersion
+				onUpdate(result)
+			}
+			timer.Reset(interval + jitter(maxJitter))
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// periodicCheck runs a single check, returning the result only if there is an
+// update to report.
+func periodicCheck(ctx context.Context, params *CheckVersionParams) *CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, periodicCheckTimeout)
+	defer cancel()
+
+	logger := logging.FromContext(ctx)
+
+	c, err := loadConfig(ctx, params)
+	if err != nil {
+		logger.WarnContext(ctx, "failed to check for new versions", "error", err)
+		return nil
+	}
+	// Skip the request entirely, as CheckAppVersion does.
+	if c.checksDisabled() {
+		return nil
+	}
+
+	result, err := forceCheck(ctx, params, io.Discard)
+	if err != nil {
+		logFailedCheck(ctx, err)
+		return nil
+	}
+	if !result.UpdateAvailable || result.Ignored {
+		return nil
+	}
+	return result
+}
+
+// jitter returns a random duration in [0, maxJitter].
+func jitter(maxJitter time.Duration) time.Duration {
+	if maxJitter <= 0 {
+		return 0
+	}
+	return rand.N(maxJitter + 1)
+}
@@ -0,0 +1,151 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package updater
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// countingFetcher is a MetadataFetcher which signals each call on a channel.
+type countingFetcher struct {
+	data  *AppResponse
+	calls chan struct{}
+}
+
+func (f *countingFetcher) FetchAppResponse(ctx context.Context, appID string) (*AppResponse, error) {
+	f.calls <- struct{}{}
+	return f.data, nil
+}
+
+// mutableLookuper is an envconfig.Lookuper whose values can change while it
+// is in use.
+type mutableLookuper struct {
+	mu sync.Mutex
+	m  map[string]string
+}
+
+func (l *mutableLookuper) Lookup(key string) (string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	v, ok := l.m[key]
+	return v, ok
+}
+
+func (l *mutableLookuper) set(key, value string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.m[key] = value
+}
+
+func TestStartPeriodicCheck(t *testing.T) {
+	t.Parallel()
+
+	fetcher := &countingFetcher{
+		data: &AppResponse{
+			AppID:          "sample_app_1",
+			AppName:        "Sample App 1",
+			AppRepoURL:     "https://github.com/abcxyz/sample_app_1",
+			CurrentVersion: "1.0.0",
+		},
+		calls: make(chan struct{}),
+	}
+	lookuper := &mutableLookuper{m: map[string]string{}}
+
+	var mu sync.Mutex
+	var got []*CheckResult
+	stop := startPeriodicCheck(context.Background(), &CheckVersionParams{
+		AppID:             "sample_app_1",
+		Version:           "0.1.0",
+		Lookuper:          lookuper,
+		CacheFileOverride: filepath.Join(t.TempDir(), "data.json"),
+		Fetcher:           fetcher,
+	}, time.Millisecond, time.Millisecond, func(r *CheckResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, r)
+	})
+
+	// Several checks, but the same version is only reported once.
+	for range 3 {
+		<-fetcher.calls
+	}
+
+	// Opting out at runtime, including of security releases, stops further
+	// requests.
+	lookuper.set("IGNORE_SECURITY", "true")
+	lookuper.set("IGNORE_VERSIONS", "all")
+	// Allow for one check which already loaded its config.
+	var extra int
+	timeout := time.After(100 * time.Millisecond)
+drain:
+	for {
+		select {
+		case <-fetcher.calls:
+			extra++
+		case <-timeout:
+			break drain
+		}
+	}
+	if extra > 1 {
+		t.Errorf("got %d fetches after opting out, want at most 1", extra)
+	}
+
+	stop()
+	stop() // Stopping twice is harmless.
+
+	want := []*CheckResult{{
+		AppID:           "sample_app_1",
+		AppName:         "Sample App 1",
+		RunningVersion:  "0.1.0",
+		LatestVersion:   "1.0.0",
+		AppRepoURL:      "https://github.com/abcxyz/sample_app_1",
+		UpdateAvailable: true,
+	}}
+	mu.Lock()
+	defer mu.Unlock()
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("unexpected results (-got,+want): %s", diff)
+	}
+}
+
+func TestStartPeriodicCheck_ContextCanceled(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stop := StartPeriodicCheck(ctx, &CheckVersionParams{AppID: "sample_app_1"}, time.Hour, func(*CheckResult) {
+		t.Errorf("unexpected update")
+	})
+	cancel()
+	stop()
+}
+
+func TestJitter(t *testing.T) {
+	t.Parallel()
+
+	if got := jitter(0); got != 0 {
+		t.Errorf("jitter(0) = %s, want 0", got)
+	}
+	for range 100 {
+		if got := jitter(time.Second); got < 0 || got > time.Second {
+			t.Errorf("jitter(1s) = %s, want [0, 1s]", got)
+		}
+	}
+}
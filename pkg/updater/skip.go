@@ -0,0 +1,81 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package updater
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/abcxyz/abc-updater/pkg/localstore"
+	"github.com/abcxyz/abc-updater/pkg/semver"
+)
+
+const skippedVersionsFileName = "skipped_versions.json"
+
+// skippedVersions defines the json file that persists versions the user chose
+// to skip.
+type skippedVersions struct {
+	Versions []string `json:"versions"`
+}
+
+// SkipVersion persists that the user does not want to be notified about
+// version of an app, for example from a --skip-version flag or an interactive
+// prompt. Skipped versions are ignored in addition to those in the
+// IGNORE_VERSIONS env var.
+func SkipVersion(appID, v string) error {
+	dir, err := localstore.DefaultDir(appID)
+	if err != nil {
+		return fmt.Errorf("could not calculate skip list path: %w", err)
+	}
+	return skipVersion(filepath.Join(dir, skippedVersionsFileName), v)
+}
+
+// skipVersion implements SkipVersion for the skip list file at path.
+func skipVersion(path, v string) error {
+	normalized, err := semver.Normalize(v)
+	if err != nil {
+		return err
+	}
+
+	skipped, err := loadSkippedVersions(path)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(skipped, normalized) {
+		return nil
+	}
+
+	data := &skippedVersions{Versions: append(skipped, normalized)}
+	if err := localstore.StoreJSONFile(path, data); err != nil {
+		return fmt.Errorf("could not save skipped versions: %w", err)
+	}
+	return nil
+}
+
+// loadSkippedVersions returns the versions in the skip list file at path, or
+// none if it does not exist.
+func loadSkippedVersions(path string) ([]string, error) {
+	var data skippedVersions
+	if err := localstore.LoadJSONFile(path, &data); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not load skipped versions: %w", err)
+	}
+	return data.Versions, nil
+}
@@ -0,0 +1,116 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package updater
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/sethvargo/go-envconfig"
+
+	"github.com/abcxyz/pkg/testutil"
+)
+
+func TestSkipVersion(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		versions []string
+		want     []string
+		wantErr  string
+	}{
+		{
+			name:     "single",
+			versions: []string{"1.2.0"},
+			want:     []string{"1.2.0"},
+		},
+		{
+			name:     "appends",
+			versions: []string{"1.2.0", "1.3.0"},
+			want:     []string{"1.2.0", "1.3.0"},
+		},
+		{
+			name:     "normalizes_and_dedupes",
+			versions: []string{"v1.2.0", "1.2.0"},
+			want:     []string{"1.2.0"},
+		},
+		{
+			name:     "invalid_version",
+			versions: []string{"not-a-version"},
+			wantErr:  `failed to parse version "not-a-version"`,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(t.TempDir(), skippedVersionsFileName)
+
+			var err error
+			for _, v := range tc.versions {
+				if err = skipVersion(path, v); err != nil {
+					break
+				}
+			}
+			if diff := testutil.DiffErrString(err, tc.wantErr); diff != "" {
+				t.Error(diff)
+			}
+
+			got, err := loadSkippedVersions(path)
+			if err != nil {
+				t.Fatalf("unexpected error loading skipped versions: %v", err)
+			}
+			if diff := cmp.Diff(got, tc.want); diff != "" {
+				t.Errorf("skipped versions were not as expected (-got,+want): %s", diff)
+			}
+		})
+	}
+}
+
+func TestCheckAppVersionSync_SkippedVersion(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	if err := skipVersion(filepath.Join(dir, skippedVersionsFileName), "2.0.0"); err != nil {
+		t.Fatal(err)
+	}
+
+	fetcher := &staticFetcher{data: &AppResponse{
+		AppID:          "sample_app_1",
+		AppName:        "Sample App 1",
+		AppRepoURL:     "https://github.com/abcxyz/sample_app_1",
+		CurrentVersion: "2.0.0",
+	}}
+
+	got, err := CheckAppVersionSync(context.Background(), &CheckVersionParams{
+		AppID:             "sample_app_1",
+		Version:           "1.0.0",
+		Lookuper:          envconfig.MapLookuper(map[string]string{"IGNORE_VERSIONS": "1.5.0"}),
+		CacheFileOverride: filepath.Join(dir, "data.json"),
+		Fetcher:           fetcher,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if got != "" {
+		t.Errorf("expected skipped version not to be notified, got %q", got)
+	}
+}
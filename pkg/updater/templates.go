@@ -0,0 +1,69 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/go-version"
+
+	"github.com/abcxyz/abc-updater/pkg/api"
+)
+
+// TemplateFreshness reports whether a newer release of a template exists than
+// the ref it is pinned to.
+type TemplateFreshness = api.TemplateFreshnessResponse
+
+// TemplateFreshnessFetcher is implemented by MetadataFetchers which can check
+// whether templates are up to date. HTTPFetcher implements it using the
+// metrics server's GET /apps/<app>/templates/freshness endpoint.
+type TemplateFreshnessFetcher interface {
+	FetchTemplateFreshness(ctx context.Context, appID, source, ref string) (*TemplateFreshness, error)
+}
+
+// ErrTemplateFreshnessUnsupported is returned (wrapped) by
+// CheckTemplateFreshness if params.Fetcher cannot check templates.
+var ErrTemplateFreshnessUnsupported = errors.New("fetcher does not support template freshness checks")
+
+// CheckTemplateFreshness reports whether a newer tagged release exists of the
+// template at source, e.g. "github.com/abcxyz/abc/t/rest_server", than the
+// pinned ref, e.g. "v1.2.0". The template must be listed as a component of
+// params.AppID, with source as its repo URL. Like CheckAdvisories, it always
+// fetches, bypassing the local cache, and returns nil if the user opted out of
+// all update checks. Newer prereleases only count with IncludePrereleases.
+func CheckTemplateFreshness(ctx context.Context, params *CheckVersionParams, source, ref string) (*TemplateFreshness, error) {
+	c, err := loadConfig(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	if c.IgnoreAllVersions() {
+		return nil, nil
+	}
+
+	f, ok := params.fetcher(c).(TemplateFreshnessFetcher)
+	if !ok {
+		return nil, ErrTemplateFreshnessUnsupported
+	}
+	result, err := f.FetchTemplateFreshness(ctx, params.AppID, source, ref)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check template freshness: %w", err)
+	}
+	if latest, err := version.NewVersion(result.LatestVersion); err == nil && c.skipPrerelease(latest) {
+		result.UpdateAvailable = false
+	}
+	return result, nil
+}
@@ -0,0 +1,134 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/sethvargo/go-envconfig"
+
+	"github.com/abcxyz/abc-updater/pkg/optout"
+)
+
+func TestCheckTemplateFreshness(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		env      map[string]string
+		response string
+		want     *TemplateFreshness
+	}{
+		{
+			name:     "update_available",
+			response: `{"source":"github.com/abcxyz/abc/t/rest_server","ref":"v1.0.0","componentId":"rest_server","latestVersion":"1.1.0","updateAvailable":true}`,
+			want: &TemplateFreshness{
+				Source:          "github.com/abcxyz/abc/t/rest_server",
+				Ref:             "v1.0.0",
+				ComponentID:     "rest_server",
+				LatestVersion:   "1.1.0",
+				UpdateAvailable: true,
+			},
+		},
+		{
+			name:     "prerelease_hidden",
+			response: `{"source":"github.com/abcxyz/abc/t/rest_server","ref":"v1.0.0","componentId":"rest_server","latestVersion":"1.1.0-rc.1","updateAvailable":true}`,
+			want: &TemplateFreshness{
+				Source:        "github.com/abcxyz/abc/t/rest_server",
+				Ref:           "v1.0.0",
+				ComponentID:   "rest_server",
+				LatestVersion: "1.1.0-rc.1",
+			},
+		},
+		{
+			name:     "prerelease_included",
+			env:      map[string]string{"INCLUDE_PRERELEASES": "true"},
+			response: `{"source":"github.com/abcxyz/abc/t/rest_server","ref":"v1.0.0","componentId":"rest_server","latestVersion":"1.1.0-rc.1","updateAvailable":true}`,
+			want: &TemplateFreshness{
+				Source:          "github.com/abcxyz/abc/t/rest_server",
+				Ref:             "v1.0.0",
+				ComponentID:     "rest_server",
+				LatestVersion:   "1.1.0-rc.1",
+				UpdateAvailable: true,
+			},
+		},
+		{
+			name: "opted_out",
+			env:  map[string]string{optout.IgnoreVersionsEnvVar: "all"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var gotPath, gotQuery string
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotPath, gotQuery = r.URL.Path, r.URL.RawQuery
+				fmt.Fprint(w, tc.response)
+			}))
+			t.Cleanup(ts.Close)
+
+			env := map[string]string{"UPDATER_URL": ts.URL}
+			for k, v := range tc.env {
+				env[k] = v
+			}
+			got, err := CheckTemplateFreshness(context.Background(), &CheckVersionParams{
+				AppID:                  "sample_app_1",
+				Version:                "1.0.0",
+				Lookuper:               envconfig.MapLookuper(env),
+				CacheFileOverride:      filepath.Join(t.TempDir(), "data.json"),
+				AllowInsecureLocalhost: true,
+			}, "github.com/abcxyz/abc/t/rest_server", "v1.0.0")
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if diff := cmp.Diff(got, tc.want); diff != "" {
+				t.Errorf("unexpected result (-got,+want): %s", diff)
+			}
+			if tc.want == nil {
+				return
+			}
+			if got, want := gotPath, "/sample_app_1/templates/freshness"; got != want {
+				t.Errorf("unexpected request path. got %q want %q", got, want)
+			}
+			if got, want := gotQuery, "ref=v1.0.0&source=github.com%2Fabcxyz%2Fabc%2Ft%2Frest_server"; got != want {
+				t.Errorf("unexpected request query. got %q want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestCheckTemplateFreshness_Unsupported(t *testing.T) {
+	t.Parallel()
+
+	_, err := CheckTemplateFreshness(context.Background(), &CheckVersionParams{
+		AppID:             "sample_app_1",
+		Version:           "1.0.0",
+		Lookuper:          envconfig.MapLookuper(nil),
+		CacheFileOverride: filepath.Join(t.TempDir(), "data.json"),
+		Fetcher:           &staticFetcher{data: &AppResponse{AppID: "sample_app_1"}},
+	}, "github.com/abcxyz/abc/t/rest_server", "v1.0.0")
+	if !errors.Is(err, ErrTemplateFreshnessUnsupported) {
+		t.Errorf("expected ErrTemplateFreshnessUnsupported, got %v", err)
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package updater
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-version"
+
+	"github.com/abcxyz/abc-updater/pkg/compat"
+	"github.com/abcxyz/abc-updater/pkg/observe"
+)
+
+// CheckStatus is the outcome of a single VerifyServer check.
+type CheckStatus string
+
+const (
+	CheckPassed  CheckStatus = "passed"
+	CheckFailed  CheckStatus = "failed"
+	CheckSkipped CheckStatus = "skipped"
+)
+
+// VerifyCheck is the result of a single step of VerifyServer.
+type VerifyCheck struct {
+	Name     string        `json:"name"`
+	Status   CheckStatus   `json:"status"`
+	Detail   string        `json:"detail,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// VerifyResult is a structured diagnostic of the update path, suitable for
+// printing from a "doctor" style command.
+type VerifyResult struct {
+	ServerURL string         `json:"serverUrl"`
+	Checks    []*VerifyCheck `json:"checks"`
+}
+
+// OK returns true if no check failed.
+func (r *VerifyResult) OK() bool {
+	for _, c := range r.Checks {
+		if c.Status == CheckFailed {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns a human readable, multi-line summary of the result.
+func (r *VerifyResult) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "update server: %s\n", r.ServerURL)
+	for _, c := range r.Checks {
+		fmt.Fprintf(&b, "  [%s] %s", c.Status, c.Name)
+		if c.Detail != "" {
+			fmt.Fprintf(&b, ": %s", c.Detail)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// VerifyServer performs an end-to-end check of the update path for the app in
+// params: loading config, fetching app data, and validating its schema. App
+// data is fetched with params.Fetcher if set. Unlike
+// CheckAppVersionSync, it ignores opt-out settings and the local cache, and
+// does not write to the cache.
+//
+// An error is only returned if params are invalid; failures of the update path
+// itself are reported in the result.
+func VerifyServer(ctx context.Context, params *CheckVersionParams) (*VerifyResult, error) {
+	if params == nil || params.AppID == "" {
+		return nil, fmt.Errorf("params must include an AppID")
+	}
+
+	result := &VerifyResult{}
+	run := func(name string, fn func() (CheckStatus, string)) bool {
+		start := time.Now()
+		status, detail := fn()
+		result.Checks = append(result.Checks, &VerifyCheck{
+			Name:     name,
+			Status:   status,
+			Detail:   detail,
+			Duration: time.Since(start),
+		})
+		return status != CheckFailed
+	}
+
+	var serverURL string
+	if !run("config", func() (CheckStatus, string) {
+		c, err := loadConfig(ctx, params)
+		if err != nil {
+			return CheckFailed, err.Error()
+		}
+		serverURL = c.ServerURL
+		result.ServerURL = c.ServerURL
+		if len(c.FallbackURLs) > 0 {
+			// Only the first server is verified.
+			return CheckPassed, "fallback servers (not verified): " + strings.Join(c.FallbackURLs, ", ")
+		}
+		return CheckPassed, ""
+	}) {
+		return result, nil
+	}
+
+	var body []byte
+	var data *AppResponse
+	if params.Fetcher != nil {
+		if !run("fetch", func() (CheckStatus, string) {
+			var err error
+			if data, err = params.Fetcher.FetchAppResponse(ctx, params.AppID); err != nil {
+				return CheckFailed, fmt.Sprintf("%T failed: %s", params.Fetcher, err)
+			}
+			return CheckPassed, fmt.Sprintf("fetched with %T", params.Fetcher)
+		}) {
+			return result, nil
+		}
+	} else if !run("fetch", func() (CheckStatus, string) {
+		u := fmt.Sprintf(appDataURLFormat, serverURL, params.AppID)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return CheckFailed, fmt.Sprintf("failed to create request: %s", err)
+		}
+		req.Header.Set("User-Agent", params.userAgent())
+		req.Header.Set("Accept", "application/json")
+		compat.SetVersionHeader(req.Header)
+
+		client := &http.Client{Transport: observe.Transport(nil, params.RequestObserver)}
+		resp, err := client.Do(req)
+		if err != nil {
+			return CheckFailed, fmt.Sprintf("failed to make request: %s", err)
+		}
+		defer resp.Body.Close()
+		compat.WarnIfUnsupported(ctx, resp.Header)
+
+		if resp.StatusCode != http.StatusOK {
+			b, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorResponseBytes))
+			return CheckFailed, fmt.Sprintf("GET %s returned %d: %s", u, resp.StatusCode, strings.TrimSpace(string(b)))
+		}
+		if body, err = io.ReadAll(resp.Body); err != nil {
+			return CheckFailed, fmt.Sprintf("failed to read response body: %s", err)
+		}
+		return CheckPassed, fmt.Sprintf("GET %s returned %d", u, resp.StatusCode)
+	}) {
+		return result, nil
+	}
+
+	run("schema", func() (CheckStatus, string) {
+		if data == nil {
+			data = &AppResponse{}
+			if err := json.Unmarshal(body, data); err != nil {
+				return CheckFailed, fmt.Sprintf("failed to decode response body: %s", err)
+			}
+		}
+		var problems []string
+		if data.AppID != params.AppID {
+			problems = append(problems, fmt.Sprintf("appId is %q, expected %q", data.AppID, params.AppID))
+		}
+		if _, err := version.NewVersion(data.CurrentVersion); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid currentVersion %q", data.CurrentVersion))
+		}
+		if !data.Severity.Valid() {
+			problems = append(problems, fmt.Sprintf("unknown severity %q", data.Severity))
+		}
+		if len(problems) > 0 {
+			return CheckFailed, strings.Join(problems, "; ")
+		}
+		return CheckPassed, fmt.Sprintf("current version is %s", data.CurrentVersion)
+	})
+
+	run("signature", func() (CheckStatus, string) {
+		return CheckSkipped, "app data is not signed"
+	})
+
+	return result, nil
+}
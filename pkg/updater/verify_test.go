@@ -0,0 +1,166 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package updater
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/sethvargo/go-envconfig"
+
+	"github.com/abcxyz/pkg/testutil"
+)
+
+func TestVerifyServer(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/good_app/data.json"):
+			fmt.Fprintln(w, `{"appId":"good_app","currentVersion":"1.0.0"}`)
+		case strings.HasSuffix(r.URL.Path, "/bad_schema/data.json"):
+			fmt.Fprintln(w, `{"appId":"other","currentVersion":"latest"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintln(w, http.StatusText(http.StatusNotFound))
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	cases := []struct {
+		name       string
+		appID      string
+		env        map[string]string
+		fetcher    MetadataFetcher
+		wantOK     bool
+		wantStatus map[string]CheckStatus
+		wantErr    string
+	}{
+		{
+			name:   "happy",
+			appID:  "good_app",
+			env:    map[string]string{"UPDATER_URL": ts.URL},
+			wantOK: true,
+			wantStatus: map[string]CheckStatus{
+				"config":    CheckPassed,
+				"fetch":     CheckPassed,
+				"schema":    CheckPassed,
+				"signature": CheckSkipped,
+			},
+		},
+		{
+			name:   "ignores_opt_out",
+			appID:  "good_app",
+			env:    map[string]string{"UPDATER_URL": ts.URL, "IGNORE_VERSIONS": "all"},
+			wantOK: true,
+			wantStatus: map[string]CheckStatus{
+				"config":    CheckPassed,
+				"fetch":     CheckPassed,
+				"schema":    CheckPassed,
+				"signature": CheckSkipped,
+			},
+		},
+		{
+			name:  "bad_schema",
+			appID: "bad_schema",
+			env:   map[string]string{"UPDATER_URL": ts.URL},
+			wantStatus: map[string]CheckStatus{
+				"config":    CheckPassed,
+				"fetch":     CheckPassed,
+				"schema":    CheckFailed,
+				"signature": CheckSkipped,
+			},
+		},
+		{
+			name:  "not_found_stops_early",
+			appID: "missing_app",
+			env:   map[string]string{"UPDATER_URL": ts.URL},
+			wantStatus: map[string]CheckStatus{
+				"config": CheckPassed,
+				"fetch":  CheckFailed,
+			},
+		},
+		{
+			name:  "bad_url",
+			appID: "good_app",
+			env:   map[string]string{"UPDATER_URL": "not a url"},
+			wantStatus: map[string]CheckStatus{
+				"config": CheckFailed,
+			},
+		},
+		{
+			name:    "custom_fetcher",
+			appID:   "embedded_app",
+			fetcher: &staticFetcher{data: &AppResponse{AppID: "embedded_app", CurrentVersion: "1.2.3"}},
+			wantOK:  true,
+			wantStatus: map[string]CheckStatus{
+				"config":    CheckPassed,
+				"fetch":     CheckPassed,
+				"schema":    CheckPassed,
+				"signature": CheckSkipped,
+			},
+		},
+		{
+			name:    "custom_fetcher_fails",
+			appID:   "embedded_app",
+			fetcher: &staticFetcher{err: fmt.Errorf("no data")},
+			wantStatus: map[string]CheckStatus{
+				"config": CheckPassed,
+				"fetch":  CheckFailed,
+			},
+		},
+		{
+			name:    "missing_app_id",
+			wantErr: "params must include an AppID",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := VerifyServer(context.Background(), &CheckVersionParams{
+				AppID:    tc.appID,
+				Version:  "1.0.0",
+				Lookuper: envconfig.MapLookuper(tc.env),
+				Fetcher:  tc.fetcher,
+
+				AllowInsecureLocalhost: true,
+			})
+			if diff := testutil.DiffErrString(err, tc.wantErr); diff != "" {
+				t.Fatal(diff)
+			}
+			if err != nil {
+				return
+			}
+
+			if got.OK() != tc.wantOK {
+				t.Errorf("unexpected OK(). got %t want %t. Result:\n%s", got.OK(), tc.wantOK, got)
+			}
+			gotStatus := make(map[string]CheckStatus, len(got.Checks))
+			for _, c := range got.Checks {
+				gotStatus[c.Name] = c.Status
+			}
+			if diff := cmp.Diff(gotStatus, tc.wantStatus); diff != "" {
+				t.Errorf("unexpected check statuses. Diff (-got +want): %s", diff)
+			}
+		})
+	}
+}
@@ -15,23 +15,28 @@
 package updater
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
-	"net/url"
+	"os"
 	"path/filepath"
 	"strings"
-	"text/template"
 	"time"
 
 	"github.com/hashicorp/go-version"
 	"github.com/sethvargo/go-envconfig"
 
+	"github.com/abcxyz/abc-updater/pkg/api"
+	"github.com/abcxyz/abc-updater/pkg/doh"
+	"github.com/abcxyz/abc-updater/pkg/failover"
 	"github.com/abcxyz/abc-updater/pkg/localstore"
+	"github.com/abcxyz/abc-updater/pkg/observe"
+	"github.com/abcxyz/abc-updater/pkg/optout"
+	"github.com/abcxyz/abc-updater/pkg/reachability"
+	"github.com/abcxyz/abc-updater/pkg/serverurl"
+	"github.com/abcxyz/abc-updater/pkg/useragent"
 	"github.com/abcxyz/pkg/logging"
 )
 
@@ -49,62 +54,97 @@ type CheckVersionParams struct {
 	Lookuper envconfig.Lookuper
 
 	// Optional override for cached file location. Mostly intended for testing.
-	// If empty uses default location.
+	// If empty uses default location. Versions skipped with SkipVersion are
+	// read from the same directory.
 	CacheFileOverride string
-}
-
-const ignoreVersionsEnvVar = "IGNORE_VERSIONS"
 
-// AppResponse is the response object for an app version request.
-// It contains information about the most recent version for a given app.
-type AppResponse struct {
-	AppID          string `json:"appId"`
-	AppName        string `json:"appName"`
-	AppRepoURL     string `json:"appRepoUrl"`
-	CurrentVersion string `json:"currentVersion"`
+	// AllowInsecureLocalhost permits an UPDATER_URL of http://localhost, or
+	// another loopback address, for local development. Other http URLs are
+	// always rejected.
+	AllowInsecureLocalhost bool
+
+	// Optional source of version data. Defaults to an HTTPFetcher for
+	// UPDATER_URL.
+	Fetcher MetadataFetcher
+
+	// Optional User-Agent for requests to the server. Defaults to
+	// "abc-updater/<lib-version> (<AppID>/<Version>)".
+	UserAgent string
+
+	// UnreachableTTL is how long to skip the server after several
+	// consecutive attempts cannot reach it, so commands on restricted
+	// networks do not wait on timeouts. Defaults to five minutes.
+	UnreachableTTL time.Duration
+
+	// DNSOverHTTPSURL optionally resolves the server's host name with the
+	// DNS over HTTPS server at this URL, e.g. "https://1.1.1.1/dns-query",
+	// when the system resolver fails. This is for networks where DNS for the
+	// server is blocked but HTTPS egress is allowed. Not used with a custom
+	// Fetcher.
+	DNSOverHTTPSURL string
+
+	// RemindEvery optionally repeats the notification for a version the user
+	// has already been notified about, at most this often. By default each
+	// version is only notified once.
+	RemindEvery time.Duration
+
+	// Now optionally overrides the current time, so tests can simulate cache
+	// expiry and reminders without sleeping. Defaults to time.Now.
+	Now func() time.Time
+
+	// Locale optionally selects the language of the update notice, e.g. "de"
+	// or "pt-BR". Defaults to the locale in LC_ALL, LC_MESSAGES, or LANG.
+	Locale string
+
+	// Catalog optionally provides the update notice in other languages, e.g.
+	// from LoadCatalog. Locales not in the catalog get English.
+	Catalog Catalog
+
+	// IncludePrereleases notifies about prerelease versions, e.g. "1.2.0-rc.1",
+	// for users who run prereleases. By default only releases are notified.
+	// Users can also opt in with the INCLUDE_PRERELEASES env var.
+	IncludePrereleases bool
+
+	// CacheOnly refreshes the local version cache, if due, without returning
+	// a notice. A notice found by the refresh is held in the cache, and
+	// returned by the next check without CacheOnly, so tools can fetch during
+	// a long operation and show the notice when it is done.
+	CacheOnly bool
+
+	// RequestObserver is optionally called after each request to the server,
+	// including retries against fallback servers, so apps can meter and debug
+	// the library's network use. Not used with a custom Fetcher.
+	RequestObserver observe.Func
 }
 
-type versionConfig struct {
-	ServerURL      string   `env:"UPDATER_URL,default=https://abc-updater.tycho.joonix.net"`
-	IgnoreVersions []string `env:"IGNORE_VERSIONS"`
-}
+// ErrInvalidServerURL is returned (wrapped) if UPDATER_URL is not a valid
+// server URL.
+var ErrInvalidServerURL = serverurl.ErrInvalid
 
-func (c *versionConfig) ignoreAll() bool {
-	for _, version := range c.IgnoreVersions {
-		if strings.ToLower(version) == "all" {
-			return true
-		}
-	}
-	return false
-}
+// ErrServerUnreachable is returned (wrapped) if the server was recently found
+// unreachable, and was skipped. See CheckVersionParams.UnreachableTTL.
+var ErrServerUnreachable = reachability.ErrUnreachable
 
-// IsIgnored returns true if the version specified should be ignored.
-func (c *versionConfig) isIgnored(checkVersion string) (bool, error) {
-	v, err := version.NewVersion(checkVersion)
-	if err != nil {
-		return false, fmt.Errorf("failed to parse version: %w", err)
-	}
+// ErrServerCoolingDown is returned (wrapped) if the server asked clients to
+// wait, with a Retry-After header, and the check was skipped.
+var ErrServerCoolingDown = failover.ErrCoolingDown
 
-	if c.ignoreAll() {
-		return true, nil
-	}
+// AppResponse is the response object for an app version request.
+// It contains information about the most recent version for a given app.
+type AppResponse = api.AppResponse
 
-	var cumulativeErr error
-	for _, ignoredVersion := range c.IgnoreVersions {
-		c, err := version.NewConstraint(ignoredVersion)
-		if err != nil {
-			cumulativeErr = errors.Join(cumulativeErr, err)
-			continue
-		}
+type versionConfig struct {
+	// ServerURL is a comma-separated list of servers, in order of preference.
+	// After loadConfig, it is the first normalized server.
+	ServerURL string `env:"UPDATER_URL,default=https://abc-updater.tycho.joonix.net"`
+	optout.Config
 
-		// Constraint checks without pre-releases will only match versions without pre-release.
-		// https://github.com/hashicorp/go-version/issues/130
-		if c.Check(v) {
-			return true, nil
-		}
-	}
+	// IncludePrereleases opts in to notifications about prerelease versions.
+	// After loadConfig, it is also set by CheckVersionParams.
+	IncludePrereleases bool `env:"INCLUDE_PRERELEASES"`
 
-	return false, cumulativeErr
+	// FallbackURLs are the normalized servers to try if ServerURL fails.
+	FallbackURLs []string
 }
 
 // LocalVersionData defines the json file that caches version lookup data.
@@ -112,23 +152,61 @@ func (c *versionConfig) isIgnored(checkVersion string) (bool, error) {
 type LocalVersionData struct {
 	// Last time version information was checked, in UTC epoch seconds.
 	LastCheckTimestamp int64 `json:"lastCheckTimestamp"`
+	// The remote version the user was last notified about, and when, in UTC
+	// epoch seconds.
+	NotifiedVersion   string `json:"notifiedVersion,omitempty"`
+	NotifiedTimestamp int64  `json:"notifiedTimestamp,omitempty"`
+	// Pending is true if a CacheOnly check found a notice which has not been
+	// shown yet.
+	Pending bool `json:"pending,omitempty"`
 	// Currently unused
 	AppResponse
 }
 
-// versionUpdateDetails is used for filling outputTemplate.
+// shouldNotify reports whether the user should be notified about remote
+// version v, given when they were last notified. Each version is notified
+// once, and then again every remindEvery if it is non-zero.
+func (d *LocalVersionData) shouldNotify(v string, remindEvery time.Duration, now time.Time) bool {
+	if d.NotifiedVersion != v {
+		return true
+	}
+	if remindEvery <= 0 {
+		return false
+	}
+	return now.Sub(time.Unix(d.NotifiedTimestamp, 0)) >= remindEvery
+}
+
+// keepNotified copies the notification state from prev, if any, so rewriting
+// the cache does not cause a repeat notification.
+func (d *LocalVersionData) keepNotified(prev *LocalVersionData) {
+	if prev == nil {
+		return
+	}
+	d.NotifiedVersion = prev.NotifiedVersion
+	d.NotifiedTimestamp = prev.NotifiedTimestamp
+}
+
+// versionUpdateDetails is used for filling the templates in Messages.
 type versionUpdateDetails struct {
+	// SeverityLabel prefixes the message for security releases.
+	SeverityLabel string
 	AppName       string
 	AppRepoURL    string
 	RemoteVersion string
 	OptOutEnvVar  string
+	// StaleSince is set when the update is based on cached version data, to
+	// the date it was cached.
+	StaleSince string
 }
 
 const (
 	localVersionFileName  = "data.json"
-	appDataURLFormat      = "%s/%s/data.json"
-	outputTemplate        = `{{.AppName}} version {{.RemoteVersion}} is available at [{{.AppRepoURL}}]. Use {{.OptOutEnvVar}}="{{.RemoteVersion}}" (or "all") to ignore.`
-	maxErrorResponseBytes = 2048
+	serverBackoffFileName = "server_backoff.json"
+	// unreachableServersFileName persists servers which could not be reached.
+	unreachableServersFileName = "unreachable_servers.json"
+	appDataURLFormat           = "%s/%s/data.json"
+	templateFreshnessURLFormat = "%s/%s/templates/freshness"
+	maxErrorResponseBytes      = 2048
 )
 
 // CheckAppVersion calls CheckAppVersionSync in a go routine. It returns a closure
@@ -155,81 +233,157 @@ func CheckAppVersion(ctx context.Context, params *CheckVersionParams, out func(s
 
 // CheckAppVersionSync checks if a newer version of an app is available. Any relevant update info will be
 // returned as a string. Accepts a context for cancellation.
+//
+// Each newer version is only returned once, unless params.RemindEvery is set.
 func CheckAppVersionSync(ctx context.Context, params *CheckVersionParams) (string, error) {
-	lookuper := params.Lookuper
-	if lookuper == nil {
-		lookuper = envconfig.OsLookuper()
-		lookuper = envconfig.PrefixLookuper(strings.ToUpper(params.AppID)+"_", lookuper)
+	result, err := Check(ctx, params)
+	if err != nil || result == nil {
+		return "", err
 	}
+	return result.Message, nil
+}
 
-	var c versionConfig
-	if err := envconfig.ProcessWith(ctx, &envconfig.Config{
-		Target:   &c,
-		Lookuper: lookuper,
-	}); err != nil {
-		return "", fmt.Errorf("failed to process envconfig: %w", err)
+// Check is CheckAppVersionSync, but returns the details of the check along
+// with the notice, so callers need not parse the message. The result's
+// Message is what CheckAppVersionSync returns.
+//
+// Returns nil if the user opted out of all update checks, with IGNORE_VERSIONS
+// "all" and IGNORE_SECURITY. With "all" alone, versions are still checked so
+// critical security releases are notified. If the version data
+// was checked less than a day ago, the result is based on the cached data and
+// has no Message, unless a notice is pending from a CacheOnly check or the
+// cached version is a critical security release, which is notified on every
+// check.
+func Check(ctx context.Context, params *CheckVersionParams) (*CheckResult, error) {
+	c, err := loadConfig(ctx, params)
+	if err != nil {
+		return nil, err
 	}
 
-	if c.ignoreAll() {
-		return "", nil
+	if c.checksDisabled() {
+		return nil, nil
+	}
+
+	checkVersion, err := version.NewVersion(params.Version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse check version %q: %w", params.Version, err)
 	}
 
+	key := c.cacheKey()
 	fetchNewData := true
-	cachedData, err := loadLocalCachedData(params)
+	cachedData, err := loadLocalCachedData(params, key)
 	if err == nil && cachedData != nil {
-		oneDayAgo := time.Now().Add(-24 * time.Hour)
-		fetchNewData = oneDayAgo.Unix() >= cachedData.LastCheckTimestamp
-	}
-	if !fetchNewData {
-		return "", nil
+		lastDue := params.now().Add(-checkInterval(&cachedData.AppResponse, checkVersion))
+		fetchNewData = lastDue.Unix() >= cachedData.LastCheckTimestamp
 	}
 
-	// Use ParseRequestURI over Parse because Parse validation is more loose and will accept
-	// things such as relative paths without a host.
-	if _, err := url.ParseRequestURI(c.ServerURL); err != nil {
-		return "", fmt.Errorf("failed to parse server url: %w", err)
+	if !fetchNewData {
+		cached, err := checkResult(c, params.AppID, checkVersion, &cachedData.AppResponse)
+		notify := cachedData.Pending || cachedData.Severity == api.SeverityCritical
+		if err != nil || !notify || params.CacheOnly {
+			return cached, err
+		}
+		output, err := updateMessage(c, params.messages(), checkVersion, &cachedData.AppResponse, time.Time{})
+		if err != nil {
+			return nil, err
+		}
+		cachedData.Pending = false
+		if output == "" {
+			_ = setLocalCachedData(params, key, cachedData)
+			return cached, nil
+		}
+		cached.Message = notifyOnce(params, key, cachedData, output)
+		return cached, nil
 	}
 
-	checkVersion, err := version.NewVersion(params.Version)
+	result, err := params.fetcher(c).FetchAppResponse(ctx, params.AppID)
 	if err != nil {
-		return "", fmt.Errorf("failed to parse check version %q: %w", params.Version, err)
+		if cachedData == nil || params.CacheOnly {
+			return nil, err
+		}
+		// Prefer stale advice to none at all on flaky connections. The cache
+		// timestamp is not updated, so the next check retries the fetch.
+		staleSince := time.Unix(cachedData.LastCheckTimestamp, 0)
+		output, staleErr := updateMessage(c, params.messages(), checkVersion, &cachedData.AppResponse, staleSince)
+		if staleErr != nil || output == "" {
+			return nil, err
+		}
+		stale, staleErr := checkResult(c, params.AppID, checkVersion, &cachedData.AppResponse)
+		if staleErr != nil {
+			return nil, err
+		}
+		logging.FromContext(ctx).WarnContext(ctx, "failed to check for new versions, using cached version data",
+			"error", err)
+		cachedData.Pending = false
+		stale.Message = notifyOnce(params, key, cachedData, output)
+		return stale, nil
 	}
 
-	client := &http.Client{}
+	data := &LocalVersionData{
+		LastCheckTimestamp: params.now().Unix(),
+		AppResponse:        *result,
+	}
+	data.keepNotified(cachedData)
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf(appDataURLFormat, c.ServerURL, params.AppID), nil)
+	output, err := updateMessage(c, params.messages(), checkVersion, result, time.Time{})
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		_ = storeFetchedData(params, key, data, checkVersion)
+		return nil, err
 	}
-	req.Header.Set("User-Agent", "github.com/abcxyz/abc-updater")
-	req.Header.Set("Accept", "application/json")
-
-	resp, err := client.Do(req)
+	checked, err := checkResult(c, params.AppID, checkVersion, result)
 	if err != nil {
-		return "", fmt.Errorf("failed to make request: %w", err)
+		_ = storeFetchedData(params, key, data, checkVersion)
+		return nil, err
 	}
-	defer resp.Body.Close()
+	if output == "" || params.CacheOnly {
+		data.Pending = output != ""
+		_ = storeFetchedData(params, key, data, checkVersion)
+		return checked, nil
+	}
+	checked.Message = notify(params, data, output)
+	_ = storeFetchedData(params, key, data, checkVersion)
+	return checked, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		b, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorResponseBytes))
-		if err != nil {
-			return "", fmt.Errorf("unable to read response body")
-		}
+// notifyOnce is notify, storing data as the cache entry with key.
+func notifyOnce(params *CheckVersionParams, key string, data *LocalVersionData, output string) string {
+	output = notify(params, data, output)
+	_ = setLocalCachedData(params, key, data)
+	return output
+}
 
-		return "", fmt.Errorf("not a 200 response: %s", string(b))
+// notify returns output unless the user was already notified about the
+// version in data, recording the notification in data. Critical security
+// releases are notified every time, so a user who missed the first notice
+// still sees it.
+func notify(params *CheckVersionParams, data *LocalVersionData, output string) string {
+	now := params.now()
+	critical := data.Severity == api.SeverityCritical
+	if !critical && !data.shouldNotify(data.CurrentVersion, params.RemindEvery, now) {
+		return ""
 	}
+	data.NotifiedVersion = data.CurrentVersion
+	data.NotifiedTimestamp = now.Unix()
+	return output
+}
 
-	var result AppResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", fmt.Errorf("failed to decode response body: %w", err)
+// updateMessage returns the message to show for result, in the language of
+// msgs, or an empty string if there is no update or it is ignored. A non-zero
+// staleSince flags the message as based on version data cached at that time.
+// Retired apps always get a deprecation notice, and killed versions an upgrade
+// notice, which version constraints do not silence.
+func updateMessage(c *versionConfig, msgs *Messages, checkVersion *version.Version, result *AppResponse, staleSince time.Time) (string, error) {
+	if c.IgnoreAllVersions() && !c.bypassesIgnore(result) {
+		return "", nil
+	}
+	if r := result.Retired; r != nil {
+		return retiredMessage(result.AppName, r), nil
+	}
+	if k := killSwitch(result, checkVersion); k != nil {
+		return killedMessage(result, checkVersion, k), nil
 	}
 
-	_ = setLocalCachedData(params, &LocalVersionData{
-		LastCheckTimestamp: time.Now().Unix(),
-		AppResponse:        result,
-	})
-
-	ignore, err := c.isIgnored(result.CurrentVersion)
+	ignore, err := isIgnored(c, result)
 	if err != nil {
 		return "", fmt.Errorf("error checking optout: %w", err)
 	}
@@ -239,23 +393,176 @@ func CheckAppVersionSync(ctx context.Context, params *CheckVersionParams) (strin
 
 	remoteVersion, err := version.NewVersion(result.CurrentVersion)
 	if err != nil {
-		return "", fmt.Errorf("failed to parse current version %q: %w", params.Version, err)
+		return "", fmt.Errorf("failed to parse current version %q: %w", result.CurrentVersion, err)
 	}
 
-	if checkVersion.LessThan(remoteVersion) {
-		output, err := updateVersionOutput(&versionUpdateDetails{
-			AppName:       result.AppName,
-			RemoteVersion: remoteVersion.String(),
-			AppRepoURL:    result.AppRepoURL,
-			OptOutEnvVar:  strings.ToUpper(result.AppID) + "_" + ignoreVersionsEnvVar,
-		})
-		if err != nil {
-			return "", fmt.Errorf("failed to generate version check output: %w", err)
+	if !checkVersion.LessThan(remoteVersion) || c.skipPrerelease(remoteVersion) {
+		return "", nil
+	}
+
+	details := &versionUpdateDetails{
+		SeverityLabel: msgs.severityLabel(result.Severity),
+		AppName:       result.AppName,
+		RemoteVersion: remoteVersion.String(),
+		AppRepoURL:    result.AppRepoURL,
+		OptOutEnvVar:  strings.ToUpper(result.AppID) + "_" + optout.IgnoreVersionsEnvVar,
+	}
+	if !staleSince.IsZero() {
+		details.StaleSince = staleSince.UTC().Format(time.DateOnly)
+	}
+	lines, err := msgs.render(details)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate version check output: %w", err)
+	}
+	return strings.Join(lines, " "), nil
+}
+
+// skipPrerelease returns true if remote version v is a prerelease the user did
+// not opt in to. This is checked explicitly, rather than with a constraint,
+// because go-version constraints never match prereleases.
+func (c *versionConfig) skipPrerelease(v *version.Version) bool {
+	return v.Prerelease() != "" && !c.IncludePrereleases
+}
+
+// retiredMessage returns the deprecation notice for a retired app.
+func retiredMessage(appName string, r *api.Retirement) string {
+	msg := fmt.Sprintf("%s is deprecated and will stop receiving updates after %s.", appName, r.DropAfter.UTC().Format(time.DateOnly))
+	if r.Message != "" {
+		msg += " " + r.Message
+	}
+	return msg
+}
+
+// killedMessage returns the upgrade notice for running version v of the app
+// in result, which kill switch k matched.
+func killedMessage(result *AppResponse, v *version.Version, k *api.KillSwitch) string {
+	msg := fmt.Sprintf("%s version %s has a known problem and its telemetry is disabled. Please upgrade to version %s at [%s].",
+		result.AppName, v.String(), result.CurrentVersion, result.AppRepoURL)
+	if k.Message != "" {
+		msg += " " + k.Message
+	}
+	return msg
+}
+
+// isIgnored returns true if the user opted out of notifications for result.
+// Neither version constraints nor "all" silence critical security releases
+// unless IGNORE_SECURITY is set.
+func isIgnored(c *versionConfig, result *AppResponse) (bool, error) {
+	ignore, err := c.IsVersionIgnored(result.CurrentVersion)
+	if ignore && c.bypassesIgnore(result) {
+		return false, err
+	}
+	return ignore, err
+}
+
+// bypassesIgnore returns true if result is a critical security release, which
+// is notified despite IGNORE_VERSIONS unless IGNORE_SECURITY is set.
+func (c *versionConfig) bypassesIgnore(result *AppResponse) bool {
+	return result.Severity == api.SeverityCritical && !c.IgnoreSecurity
+}
+
+// checksDisabled returns true if the user opted out of all update checks.
+// IGNORE_VERSIONS "all" alone still checks for critical security releases.
+func (c *versionConfig) checksDisabled() bool {
+	return c.IgnoreAllVersions() && c.IgnoreSecurity
+}
+
+// now returns the current time from p.Now, or time.Now if unset.
+func (p *CheckVersionParams) now() time.Time {
+	if p.Now != nil {
+		return p.Now()
+	}
+	return time.Now()
+}
+
+// fetcher returns params.Fetcher, or an HTTPFetcher for the servers in c.
+// Servers which repeatedly cannot be reached are skipped for
+// p.UnreachableTTL, failures of each server are backed off across runs, and
+// servers which send Retry-After are not contacted again until it elapses.
+func (p *CheckVersionParams) fetcher(c *versionConfig) MetadataFetcher {
+	if p.Fetcher != nil {
+		return p.Fetcher
+	}
+	// Without a path, unreachable servers are only skipped by this process.
+	checkerPath, _ := p.storePath(unreachableServersFileName)
+	checker := reachability.NewChecker(checkerPath, p.UnreachableTTL)
+	if p.DNSOverHTTPSURL != "" {
+		// Already checked by loadConfig.
+		if resolver, err := doh.New(p.DNSOverHTTPSURL); err == nil {
+			checker = checker.WithFallbackResolver(resolver)
+		}
+	}
+	f := &HTTPFetcher{
+		ServerURL:    c.ServerURL,
+		FallbackURLs: c.FallbackURLs,
+		Client: &http.Client{
+			Transport: observe.Transport(checker.Transport(), p.RequestObserver),
+		},
+		UserAgent: p.userAgent(),
+	}
+	if path, err := p.storePath(serverBackoffFileName); err == nil {
+		f.Tracker = failover.NewTracker(path)
+	}
+	return f
+}
+
+// userAgent returns the User-Agent for requests to the server.
+func (p *CheckVersionParams) userAgent() string {
+	if p.UserAgent != "" {
+		return p.UserAgent
+	}
+	return useragent.Format(p.AppID, p.Version)
+}
+
+// loadConfig loads versionConfig using the lookuper in params, defaulting to
+// environment variables prefixed with toUpper(AppID). Versions skipped with
+// SkipVersion are added to IgnoreVersions.
+func loadConfig(ctx context.Context, params *CheckVersionParams) (*versionConfig, error) {
+	lookuper := params.Lookuper
+	if lookuper == nil {
+		lookuper = optout.DefaultLookuper(params.AppID)
+	}
+
+	var c versionConfig
+	if err := envconfig.ProcessWith(ctx, &envconfig.Config{
+		Target:   &c,
+		Lookuper: lookuper,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to process envconfig: %w", err)
+	}
+
+	serverURLs, err := serverurl.NormalizeList(c.ServerURL, &serverurl.Options{
+		AllowInsecureLocalhost: params.AllowInsecureLocalhost,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse server url: %w", err)
+	}
+	if params.DNSOverHTTPSURL != "" {
+		if _, err := doh.New(params.DNSOverHTTPSURL); err != nil {
+			return nil, err
 		}
-		return output, nil
+	}
+	c.IncludePrereleases = c.IncludePrereleases || params.IncludePrereleases
+	c.ServerURL = serverURLs[0]
+	if len(serverURLs) > 1 {
+		c.FallbackURLs = serverURLs[1:]
 	}
 
-	return "", nil
+	// The skip list is best effort, like the version cache.
+	path, err := params.storePath(skippedVersionsFileName)
+	if err == nil {
+		// Finish any interrupted transaction, so local files are consistent.
+		if err := localstore.Recover(filepath.Dir(path)); err != nil {
+			logging.FromContext(ctx).WarnContext(ctx, "failed to recover local files", "error", err)
+		}
+		var skipped []string
+		skipped, err = loadSkippedVersions(path)
+		c.IgnoreVersions = append(c.IgnoreVersions, skipped...)
+	}
+	if err != nil {
+		logging.FromContext(ctx).WarnContext(ctx, "failed to load skipped versions", "error", err)
+	}
+	return &c, nil
 }
 
 // asyncFunctionCall handles the async part of CheckAppVersion, but accepts
@@ -267,8 +574,7 @@ func asyncFunctionCall(ctx context.Context, funcToCall func() (string, error), o
 		defer close(updatesCh)
 		message, err := funcToCall()
 		if err != nil {
-			logging.FromContext(ctx).WarnContext(ctx, "failed to check for new versions",
-				"error", err)
+			logFailedCheck(ctx, err)
 			return
 		}
 		updatesCh <- message
@@ -286,49 +592,156 @@ func asyncFunctionCall(ctx context.Context, funcToCall func() (string, error), o
 	}
 }
 
-func updateVersionOutput(updateDetails *versionUpdateDetails) (string, error) {
-	tmpl, err := template.New("version_update_template").Parse(outputTemplate)
-	if err != nil {
-		return "", fmt.Errorf("failed to create output text template: %w", err)
+// logFailedCheck logs a failed background check as WARN, or as DEBUG if the
+// server was skipped as unreachable, which was already logged when found.
+func logFailedCheck(ctx context.Context, err error) {
+	logger := logging.FromContext(ctx)
+	if errors.Is(err, ErrServerUnreachable) || errors.Is(err, ErrServerCoolingDown) {
+		logger.DebugContext(ctx, "skipped check for new versions", "error", err)
+		return
 	}
+	logger.WarnContext(ctx, "failed to check for new versions", "error", err)
+}
 
-	var b bytes.Buffer
-	err = tmpl.Execute(&b, updateDetails)
-	if err != nil {
-		return "", fmt.Errorf("failed to execute template: %w", err)
+const (
+	// defaultCacheKey is the version cache entry for checks notifying only
+	// about releases.
+	defaultCacheKey = "default"
+
+	// prereleaseCacheKey is the version cache entry for checks which also
+	// notify about prereleases.
+	prereleaseCacheKey = "prerelease"
+)
+
+// cacheKey returns the version cache entry for checks with c.
+func (c *versionConfig) cacheKey() string {
+	if c.IncludePrereleases {
+		return prereleaseCacheKey
 	}
+	return defaultCacheKey
+}
 
-	return b.String(), nil
+// localVersionCache is the stored version cache. Entries are keyed by release
+// channel, i.e. whether prereleases are included, so switching channels keeps
+// the version data and notification history of each rather than overwriting
+// them.
+type localVersionCache struct {
+	Entries map[string]*LocalVersionData `json:"entries"`
 }
 
-func loadLocalCachedData(c *CheckVersionParams) (*LocalVersionData, error) {
-	path := c.CacheFileOverride
-	if path == "" {
-		dir, err := localstore.DefaultDir(c.AppID)
-		if err != nil {
-			return nil, fmt.Errorf("could not calculate cache path: %w", err)
-		}
-		path = filepath.Join(dir, localVersionFileName)
+// versionCacheSchema versions the stored localVersionCache. Register a
+// migration here when changing its format.
+var versionCacheSchema = localstore.NewSchema(2).
+	// Version 0 predates schema versioning, and has the same fields.
+	Register(0, func(map[string]json.RawMessage) error { return nil }).
+	// Version 1 stored a single LocalVersionData, for the default channel.
+	Register(1, migrateSingleEntryCache)
+
+// migrateSingleEntryCache moves the fields of a single-entry cache into the
+// default entry.
+func migrateSingleEntryCache(fields map[string]json.RawMessage) error {
+	delete(fields, "schemaVersion")
+	b, err := json.Marshal(map[string]map[string]json.RawMessage{defaultCacheKey: fields})
+	if err != nil {
+		return fmt.Errorf("failed to marshal cache entry: %w", err)
 	}
-	var cached LocalVersionData
-	err := localstore.LoadJSONFile(path, &cached)
+	clear(fields)
+	fields["entries"] = b
+	return nil
+}
+
+// loadLocalCachedData loads the cache entry with key.
+func loadLocalCachedData(c *CheckVersionParams, key string) (*LocalVersionData, error) {
+	path, err := c.cachePath()
 	if err != nil {
+		return nil, err
+	}
+	var cache localVersionCache
+	if err := versionCacheSchema.LoadJSONFile(path, &cache); err != nil {
 		return nil, fmt.Errorf("could not load cached data: %w", err)
 	}
-	return &cached, nil
+	data, ok := cache.Entries[key]
+	if !ok || data == nil {
+		return nil, fmt.Errorf("could not load cached data: %w", os.ErrNotExist)
+	}
+	return data, nil
 }
 
-func setLocalCachedData(c *CheckVersionParams, data *LocalVersionData) error {
-	path := c.CacheFileOverride
-	if path == "" {
-		dir, err := localstore.DefaultDir(c.AppID)
-		if err != nil {
-			return fmt.Errorf("could not calculate cache path: %w", err)
-		}
-		path = filepath.Join(dir, localVersionFileName)
+// setLocalCachedData stores data as the cache entry with key, keeping the
+// other entries.
+func setLocalCachedData(c *CheckVersionParams, key string, data *LocalVersionData) error {
+	path, err := c.cachePath()
+	if err != nil {
+		return err
+	}
+	cache := loadVersionCache(path)
+	cache.Entries[key] = data
+	if err := versionCacheSchema.StoreJSONFile(path, cache); err != nil {
+		return fmt.Errorf("could not cache version: %w", err)
+	}
+	return nil
+}
+
+// storeFetchedData stores data, freshly fetched for running version v, as the
+// cache entry with key, and the ClientConfig in it for the metrics client. They
+// are written in one transaction, so a crash cannot leave fresh version data,
+// which is not fetched again for a day, with a stale config, e.g. without a
+// kill switch.
+func storeFetchedData(c *CheckVersionParams, key string, data *LocalVersionData, v *version.Version) error {
+	path, err := c.cachePath()
+	if err != nil {
+		return err
 	}
-	if err := localstore.StoreJSONFile(path, data); err != nil {
+	cache := loadVersionCache(path)
+	cache.Entries[key] = data
+
+	t := localstore.NewTransaction(filepath.Dir(path))
+	if err := t.StoreVersionedJSONFile(filepath.Base(path), versionCacheSchema, cache); err != nil {
+		return fmt.Errorf("could not cache version: %w", err)
+	}
+	if err := t.StoreJSONFile(api.ClientConfigFileName, storedClientConfig(&data.AppResponse, v)); err != nil {
+		return fmt.Errorf("could not store client config: %w", err)
+	}
+	if err := t.Commit(); err != nil {
 		return fmt.Errorf("could not cache version: %w", err)
 	}
 	return nil
 }
+
+// loadVersionCache loads the version cache at path. A missing or unreadable
+// cache is replaced with an empty one.
+func loadVersionCache(path string) *localVersionCache {
+	var cache localVersionCache
+	if err := versionCacheSchema.LoadJSONFile(path, &cache); err != nil {
+		cache = localVersionCache{}
+	}
+	if cache.Entries == nil {
+		cache.Entries = make(map[string]*LocalVersionData, 1)
+	}
+	return &cache
+}
+
+// cachePath returns the path of the version cache for p.
+func (p *CheckVersionParams) cachePath() (string, error) {
+	if p.CacheFileOverride != "" {
+		return p.CacheFileOverride, nil
+	}
+	dir, err := localstore.DefaultDir(p.AppID)
+	if err != nil {
+		return "", fmt.Errorf("could not calculate cache path: %w", err)
+	}
+	return filepath.Join(dir, localVersionFileName), nil
+}
+
+// storePath returns the path of the named local file for params. Files are
+// kept next to the version cache, so CacheFileOverride moves them all.
+func (p *CheckVersionParams) storePath(name string) (string, error) {
+	if p.CacheFileOverride != "" {
+		return filepath.Join(filepath.Dir(p.CacheFileOverride), name), nil
+	}
+	dir, err := localstore.DefaultDir(p.AppID)
+	if err != nil {
+		return "", fmt.Errorf("could not calculate path for %s: %w", name, err)
+	}
+	return filepath.Join(dir, name), nil
+}
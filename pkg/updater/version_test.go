@@ -19,8 +19,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"path/filepath"
 	"strings"
 	"testing"
@@ -28,7 +30,11 @@ import (
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/sethvargo/go-envconfig"
+	"github.com/thejerf/slogassert"
 
+	"github.com/abcxyz/abc-updater/pkg/api"
+	"github.com/abcxyz/abc-updater/pkg/optout"
+	"github.com/abcxyz/pkg/logging"
 	"github.com/abcxyz/pkg/testutil"
 )
 
@@ -81,6 +87,8 @@ func TestCheckAppVersionSync(t *testing.T) {
 		want    string
 		wantErr string
 		cached  *LocalVersionData
+		remind  time.Duration
+		doh     string
 	}{
 		{
 			name:    "outdated_version",
@@ -126,6 +134,83 @@ func TestCheckAppVersionSync(t *testing.T) {
 				AppResponse:        testAppResponse,
 			},
 		},
+		{
+			name:    "already_notified",
+			appID:   "sample_app_1",
+			version: "0.0.1",
+			env: map[string]string{
+				"UPDATER_URL": ts.URL,
+			},
+			want: "",
+			cached: &LocalVersionData{
+				LastCheckTimestamp: time.Now().Add(-25 * time.Hour).Unix(),
+				NotifiedVersion:    "1.0.0",
+				NotifiedTimestamp:  time.Now().Add(-25 * time.Hour).Unix(),
+				AppResponse:        testAppResponse,
+			},
+		},
+		{
+			name:    "notified_about_older_version",
+			appID:   "sample_app_1",
+			version: "0.0.1",
+			env: map[string]string{
+				"UPDATER_URL": ts.URL,
+			},
+			want: `Sample App 1 version 1.0.0 is available at [https://github.com/abcxyz/sample_app_1]. Use SAMPLE_APP_1_IGNORE_VERSIONS="1.0.0" (or "all") to ignore.`,
+			cached: &LocalVersionData{
+				LastCheckTimestamp: time.Now().Add(-25 * time.Hour).Unix(),
+				NotifiedVersion:    "0.9.0",
+				NotifiedTimestamp:  time.Now().Add(-25 * time.Hour).Unix(),
+				AppResponse:        testAppResponse,
+			},
+		},
+		{
+			name:    "already_notified_remind_due",
+			appID:   "sample_app_1",
+			version: "0.0.1",
+			env: map[string]string{
+				"UPDATER_URL": ts.URL,
+			},
+			want: `Sample App 1 version 1.0.0 is available at [https://github.com/abcxyz/sample_app_1]. Use SAMPLE_APP_1_IGNORE_VERSIONS="1.0.0" (or "all") to ignore.`,
+			cached: &LocalVersionData{
+				LastCheckTimestamp: time.Now().Add(-25 * time.Hour).Unix(),
+				NotifiedVersion:    "1.0.0",
+				NotifiedTimestamp:  time.Now().Add(-8 * 24 * time.Hour).Unix(),
+				AppResponse:        testAppResponse,
+			},
+			remind: 7 * 24 * time.Hour,
+		},
+		{
+			name:    "already_notified_remind_not_due",
+			appID:   "sample_app_1",
+			version: "0.0.1",
+			env: map[string]string{
+				"UPDATER_URL": ts.URL,
+			},
+			want: "",
+			cached: &LocalVersionData{
+				LastCheckTimestamp: time.Now().Add(-25 * time.Hour).Unix(),
+				NotifiedVersion:    "1.0.0",
+				NotifiedTimestamp:  time.Now().Add(-2 * 24 * time.Hour).Unix(),
+				AppResponse:        testAppResponse,
+			},
+			remind: 7 * 24 * time.Hour,
+		},
+		{
+			name:    "fetch_fails_stale_cache_already_notified",
+			appID:   "bad_app",
+			version: "0.0.1",
+			env: map[string]string{
+				"UPDATER_URL": ts.URL,
+			},
+			want: "",
+			cached: &LocalVersionData{
+				LastCheckTimestamp: time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC).Unix(),
+				NotifiedVersion:    "1.0.0",
+				NotifiedTimestamp:  time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC).Unix(),
+				AppResponse:        testAppResponse,
+			},
+		},
 		{
 			name:    "invalid_app_id",
 			appID:   "bad_app",
@@ -136,6 +221,32 @@ func TestCheckAppVersionSync(t *testing.T) {
 			want:    "",
 			wantErr: http.StatusText(http.StatusNotFound),
 		},
+		{
+			name:    "fetch_fails_uses_stale_cache",
+			appID:   "bad_app",
+			version: "0.0.1",
+			env: map[string]string{
+				"UPDATER_URL": ts.URL,
+			},
+			want: `Sample App 1 version 1.0.0 is available at [https://github.com/abcxyz/sample_app_1]. Use SAMPLE_APP_1_IGNORE_VERSIONS="1.0.0" (or "all") to ignore. (Could not check for updates; based on version data from 2024-01-02.)`,
+			cached: &LocalVersionData{
+				LastCheckTimestamp: time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC).Unix(),
+				AppResponse:        testAppResponse,
+			},
+		},
+		{
+			name:    "fetch_fails_stale_cache_not_newer",
+			appID:   "bad_app",
+			version: "1.0.0",
+			env: map[string]string{
+				"UPDATER_URL": ts.URL,
+			},
+			wantErr: http.StatusText(http.StatusNotFound),
+			cached: &LocalVersionData{
+				LastCheckTimestamp: time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC).Unix(),
+				AppResponse:        testAppResponse,
+			},
+		},
 		{
 			name:    "invalid_version",
 			appID:   "sample_app_1",
@@ -146,13 +257,33 @@ func TestCheckAppVersionSync(t *testing.T) {
 			want:    "",
 			wantErr: "failed to parse check version \"vab1.0.0.12.2\"",
 		},
+		{
+			name:    "dns_over_https",
+			appID:   "sample_app_1",
+			version: "v0.0.1",
+			env: map[string]string{
+				"UPDATER_URL": ts.URL,
+			},
+			doh:  "https://1.1.1.1/dns-query",
+			want: `Sample App 1 version 1.0.0 is available at [https://github.com/abcxyz/sample_app_1]. Use SAMPLE_APP_1_IGNORE_VERSIONS="1.0.0" (or "all") to ignore.`,
+		},
+		{
+			name:    "invalid_dns_over_https",
+			appID:   "sample_app_1",
+			version: "v0.0.1",
+			env: map[string]string{
+				"UPDATER_URL": ts.URL,
+			},
+			doh:     "http://1.1.1.1/dns-query",
+			wantErr: "must be an https URL",
+		},
 		{
 			name:    "opt_out_ignore_all",
 			appID:   "sample_app_1",
 			version: "v0.1.0",
 			env: map[string]string{
-				"UPDATER_URL":        ts.URL,
-				ignoreVersionsEnvVar: "all",
+				"UPDATER_URL":               ts.URL,
+				optout.IgnoreVersionsEnvVar: "all",
 			},
 			want: "",
 		},
@@ -161,8 +292,8 @@ func TestCheckAppVersionSync(t *testing.T) {
 			appID:   "sample_app_1",
 			version: "v0.1.0",
 			env: map[string]string{
-				"UPDATER_URL":        ts.URL,
-				ignoreVersionsEnvVar: "1.0.0",
+				"UPDATER_URL":               ts.URL,
+				optout.IgnoreVersionsEnvVar: "1.0.0",
 			},
 			want: "",
 		},
@@ -171,8 +302,8 @@ func TestCheckAppVersionSync(t *testing.T) {
 			appID:   "sample_app_1",
 			version: "v0.0.1",
 			env: map[string]string{
-				"UPDATER_URL":        ts.URL,
-				ignoreVersionsEnvVar: "0.0.2",
+				"UPDATER_URL":               ts.URL,
+				optout.IgnoreVersionsEnvVar: "0.0.2",
 			},
 			want: `Sample App 1 version 1.0.0 is available at [https://github.com/abcxyz/sample_app_1]. Use SAMPLE_APP_1_IGNORE_VERSIONS="1.0.0" (or "all") to ignore.`,
 		},
@@ -191,10 +322,14 @@ func TestCheckAppVersionSync(t *testing.T) {
 				Version:           tc.version,
 				Lookuper:          envconfig.MapLookuper(tc.env),
 				CacheFileOverride: cacheFile,
+				RemindEvery:       tc.remind,
+				DNSOverHTTPSURL:   tc.doh,
+
+				AllowInsecureLocalhost: true,
 			}
 
 			if tc.cached != nil {
-				if err := setLocalCachedData(params, tc.cached); err != nil {
+				if err := setLocalCachedData(params, defaultCacheKey, tc.cached); err != nil {
 					t.Errorf("unexpected error setting up test cache file: %v", err)
 				}
 			}
@@ -211,6 +346,613 @@ func TestCheckAppVersionSync(t *testing.T) {
 	}
 }
 
+func TestCheckAppVersionSync_NotifiesOncePerVersion(t *testing.T) {
+	t.Parallel()
+
+	fetcher := &staticFetcher{data: &AppResponse{
+		AppID:          "sample_app_1",
+		AppName:        "Sample App 1",
+		AppRepoURL:     "https://github.com/abcxyz/sample_app_1",
+		CurrentVersion: "1.0.0",
+	}}
+	params := &CheckVersionParams{
+		AppID:             "sample_app_1",
+		Version:           "0.0.1",
+		Lookuper:          envconfig.MapLookuper(nil),
+		CacheFileOverride: filepath.Join(t.TempDir(), "data.json"),
+		Fetcher:           fetcher,
+	}
+
+	// check expires the cache, so each call fetches, and returns the output.
+	check := func() string {
+		t.Helper()
+		if cached, err := loadLocalCachedData(params, defaultCacheKey); err == nil {
+			cached.LastCheckTimestamp = time.Now().Add(-25 * time.Hour).Unix()
+			if err := setLocalCachedData(params, defaultCacheKey, cached); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got, err := CheckAppVersionSync(context.Background(), params)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		return got
+	}
+
+	if got := check(); got == "" {
+		t.Errorf("expected notification for first check")
+	}
+	if got := check(); got != "" {
+		t.Errorf("expected no repeat notification, got %q", got)
+	}
+
+	fetcher.data.CurrentVersion = "1.1.0"
+	if got := check(); got == "" {
+		t.Errorf("expected notification for new version")
+	}
+	if got := check(); got != "" {
+		t.Errorf("expected no repeat notification, got %q", got)
+	}
+
+	if fetcher.calls != 4 {
+		t.Errorf("unexpected number of fetches. got %d want 4", fetcher.calls)
+	}
+}
+
+func TestCheckAppVersionSync_RepeatsCritical(t *testing.T) {
+	t.Parallel()
+
+	fetcher := &staticFetcher{data: &AppResponse{
+		AppID:          "sample_app_1",
+		AppName:        "Sample App 1",
+		AppRepoURL:     "https://github.com/abcxyz/sample_app_1",
+		CurrentVersion: "1.0.0",
+		Severity:       api.SeverityCritical,
+	}}
+	params := &CheckVersionParams{
+		AppID:             "sample_app_1",
+		Version:           "0.0.1",
+		Lookuper:          envconfig.MapLookuper(nil),
+		CacheFileOverride: filepath.Join(t.TempDir(), "data.json"),
+		Fetcher:           fetcher,
+	}
+
+	// The first check fetches, and later ones are answered from the cache,
+	// each repeating the notice.
+	for i := 0; i < 3; i++ {
+		got, err := CheckAppVersionSync(context.Background(), params)
+		if err != nil {
+			t.Fatalf("check %d: unexpected error: %s", i, err.Error())
+		}
+		if !strings.HasPrefix(got, "CRITICAL security update: ") {
+			t.Errorf("check %d: expected critical notification, got %q", i, got)
+		}
+	}
+	if fetcher.calls != 1 {
+		t.Errorf("unexpected number of fetches. got %d want 1", fetcher.calls)
+	}
+
+	// Ignoring the version with IGNORE_SECURITY silences it.
+	params.Lookuper = envconfig.MapLookuper(map[string]string{
+		optout.IgnoreVersionsEnvVar: "1.0.0",
+		optout.IgnoreSecurityEnvVar: "true",
+	})
+	got, err := CheckAppVersionSync(context.Background(), params)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if got != "" {
+		t.Errorf("expected no notification when ignored, got %q", got)
+	}
+}
+
+func TestCheckAppVersionSync_Now(t *testing.T) {
+	t.Parallel()
+
+	fetcher := &staticFetcher{data: &AppResponse{
+		AppID:          "sample_app_1",
+		AppName:        "Sample App 1",
+		AppRepoURL:     "https://github.com/abcxyz/sample_app_1",
+		CurrentVersion: "1.0.0",
+	}}
+	now := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+	params := &CheckVersionParams{
+		AppID:             "sample_app_1",
+		Version:           "0.0.1",
+		Lookuper:          envconfig.MapLookuper(nil),
+		CacheFileOverride: filepath.Join(t.TempDir(), "data.json"),
+		Fetcher:           fetcher,
+		RemindEvery:       48 * time.Hour,
+		Now:               func() time.Time { return now },
+	}
+
+	cases := []struct {
+		name       string
+		elapsed    time.Duration
+		wantOutput bool
+		wantCalls  int
+	}{
+		{name: "first_check", wantOutput: true, wantCalls: 1},
+		{name: "cached", elapsed: time.Hour, wantCalls: 1},
+		{name: "cache_expired_reminder_not_due", elapsed: 25 * time.Hour, wantCalls: 2},
+		{name: "reminder_due", elapsed: 50 * time.Hour, wantOutput: true, wantCalls: 3},
+	}
+
+	// Cases share the cache, so run in order.
+	start := now
+	for _, tc := range cases {
+		now = start.Add(tc.elapsed)
+		got, err := CheckAppVersionSync(context.Background(), params)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", tc.name, err.Error())
+		}
+		if (got != "") != tc.wantOutput {
+			t.Errorf("%s: unexpected output %q, want output: %t", tc.name, got, tc.wantOutput)
+		}
+		if fetcher.calls != tc.wantCalls {
+			t.Errorf("%s: unexpected number of fetches. got %d want %d", tc.name, fetcher.calls, tc.wantCalls)
+		}
+	}
+}
+
+func TestCheckAppVersionSync_Severity(t *testing.T) {
+	t.Parallel()
+
+	const message = `Sample App 1 version 1.0.0 is available at [https://github.com/abcxyz/sample_app_1]. Use SAMPLE_APP_1_IGNORE_VERSIONS="1.0.0" (or "all") to ignore.`
+
+	cases := []struct {
+		name     string
+		severity api.Severity
+		env      map[string]string
+		want     string
+	}{
+		{
+			name:     "info",
+			severity: api.SeverityInfo,
+			want:     message,
+		},
+		{
+			name:     "security",
+			severity: api.SeveritySecurity,
+			want:     "Security update: " + message,
+		},
+		{
+			name:     "security_ignored",
+			severity: api.SeveritySecurity,
+			env:      map[string]string{optout.IgnoreVersionsEnvVar: "1.0.0"},
+			want:     "",
+		},
+		{
+			name:     "critical_not_ignored_by_constraint",
+			severity: api.SeverityCritical,
+			env:      map[string]string{optout.IgnoreVersionsEnvVar: "1.0.0"},
+			want:     "CRITICAL security update: " + message,
+		},
+		{
+			name:     "critical_ignored_with_ignore_security",
+			severity: api.SeverityCritical,
+			env: map[string]string{
+				optout.IgnoreVersionsEnvVar: "1.0.0",
+				optout.IgnoreSecurityEnvVar: "true",
+			},
+			want: "",
+		},
+		{
+			name:     "security_ignored_by_all",
+			severity: api.SeveritySecurity,
+			env:      map[string]string{optout.IgnoreVersionsEnvVar: "all"},
+			want:     "",
+		},
+		{
+			name:     "critical_not_ignored_by_all",
+			severity: api.SeverityCritical,
+			env:      map[string]string{optout.IgnoreVersionsEnvVar: "all"},
+			want:     "CRITICAL security update: " + message,
+		},
+		{
+			name:     "critical_ignored_by_all_with_ignore_security",
+			severity: api.SeverityCritical,
+			env: map[string]string{
+				optout.IgnoreVersionsEnvVar: "all",
+				optout.IgnoreSecurityEnvVar: "true",
+			},
+			want: "",
+		},
+		{
+			name:     "unknown_treated_as_info",
+			severity: "urgent",
+			want:     message,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := CheckAppVersionSync(context.Background(), &CheckVersionParams{
+				AppID:             "sample_app_1",
+				Version:           "0.1.0",
+				Lookuper:          envconfig.MapLookuper(tc.env),
+				CacheFileOverride: filepath.Join(t.TempDir(), "data.json"),
+				Fetcher: &staticFetcher{data: &AppResponse{
+					AppID:          "sample_app_1",
+					AppName:        "Sample App 1",
+					AppRepoURL:     "https://github.com/abcxyz/sample_app_1",
+					CurrentVersion: "1.0.0",
+					Severity:       tc.severity,
+				}},
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if diff := cmp.Diff(got, tc.want); diff != "" {
+				t.Errorf("output was not as expected (-got,+want): %s", diff)
+			}
+		})
+	}
+}
+
+func TestCheckAppVersionSync_Prerelease(t *testing.T) {
+	t.Parallel()
+
+	const message = `Sample App 1 version 1.1.0-rc.2 is available at [https://github.com/abcxyz/sample_app_1]. Use SAMPLE_APP_1_IGNORE_VERSIONS="1.1.0-rc.2" (or "all") to ignore.`
+
+	cases := []struct {
+		name          string
+		version       string
+		remoteVersion string
+		include       bool
+		env           map[string]string
+		want          string
+	}{
+		{
+			name:          "prerelease_skipped_by_default",
+			version:       "1.0.0",
+			remoteVersion: "1.1.0-rc.2",
+			want:          "",
+		},
+		{
+			name:          "prerelease_skipped_when_running_prerelease",
+			version:       "1.1.0-rc.1",
+			remoteVersion: "1.1.0-rc.2",
+			want:          "",
+		},
+		{
+			name:          "param_opt_in",
+			version:       "1.1.0-rc.1",
+			remoteVersion: "1.1.0-rc.2",
+			include:       true,
+			want:          message,
+		},
+		{
+			name:          "env_opt_in",
+			version:       "1.0.0",
+			remoteVersion: "1.1.0-rc.2",
+			env:           map[string]string{"INCLUDE_PRERELEASES": "true"},
+			want:          message,
+		},
+		{
+			name:          "opt_in_older_prerelease",
+			version:       "1.1.0",
+			remoteVersion: "1.1.0-rc.2",
+			include:       true,
+			want:          "",
+		},
+		{
+			name:          "release_after_prerelease",
+			version:       "1.1.0-rc.2",
+			remoteVersion: "1.1.0",
+			want:          `Sample App 1 version 1.1.0 is available at [https://github.com/abcxyz/sample_app_1]. Use SAMPLE_APP_1_IGNORE_VERSIONS="1.1.0" (or "all") to ignore.`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := CheckAppVersionSync(context.Background(), &CheckVersionParams{
+				AppID:              "sample_app_1",
+				Version:            tc.version,
+				Lookuper:           envconfig.MapLookuper(tc.env),
+				CacheFileOverride:  filepath.Join(t.TempDir(), "data.json"),
+				IncludePrereleases: tc.include,
+				Fetcher: &staticFetcher{data: &AppResponse{
+					AppID:          "sample_app_1",
+					AppName:        "Sample App 1",
+					AppRepoURL:     "https://github.com/abcxyz/sample_app_1",
+					CurrentVersion: tc.remoteVersion,
+				}},
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if diff := cmp.Diff(got, tc.want); diff != "" {
+				t.Errorf("output was not as expected (-got,+want): %s", diff)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	t.Parallel()
+
+	data := &AppResponse{
+		AppID:          "sample_app_1",
+		AppName:        "Sample App 1",
+		AppRepoURL:     "https://github.com/abcxyz/sample_app_1",
+		CurrentVersion: "1.1.0",
+		Severity:       api.SeveritySecurity,
+	}
+	params := &CheckVersionParams{
+		AppID:             "sample_app_1",
+		Version:           "1.0.0",
+		Lookuper:          envconfig.MapLookuper(nil),
+		CacheFileOverride: filepath.Join(t.TempDir(), "data.json"),
+		Fetcher:           &staticFetcher{data: data},
+	}
+
+	got, err := Check(context.Background(), params)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	want := &CheckResult{
+		AppID:           "sample_app_1",
+		AppName:         "Sample App 1",
+		RunningVersion:  "1.0.0",
+		LatestVersion:   "1.1.0",
+		AppRepoURL:      "https://github.com/abcxyz/sample_app_1",
+		UpdateAvailable: true,
+		Severity:        api.SeveritySecurity,
+		Message: `Security update: Sample App 1 version 1.1.0 is available at [https://github.com/abcxyz/sample_app_1]. ` +
+			`Use SAMPLE_APP_1_IGNORE_VERSIONS="1.1.0" (or "all") to ignore.`,
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("unexpected result (-got,+want): %s", diff)
+	}
+
+	// The second check is answered from the cache, without repeating the
+	// notice.
+	got, err = Check(context.Background(), params)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	want.Message = ""
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("unexpected cached result (-got,+want): %s", diff)
+	}
+
+	// Opting out of all versions, including security releases, skips the
+	// check.
+	optedOut := *params
+	optedOut.Lookuper = envconfig.MapLookuper(map[string]string{
+		optout.IgnoreVersionsEnvVar: "all",
+		optout.IgnoreSecurityEnvVar: "true",
+	})
+	got, err = Check(context.Background(), &optedOut)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if got != nil {
+		t.Errorf("expected no result when opted out, got %+v", got)
+	}
+}
+
+func TestCheckAppVersionSync_CacheOnly(t *testing.T) {
+	t.Parallel()
+
+	fetcher := &staticFetcher{data: &AppResponse{
+		AppID:          "sample_app_1",
+		AppName:        "Sample App 1",
+		AppRepoURL:     "https://github.com/abcxyz/sample_app_1",
+		CurrentVersion: "1.1.0",
+	}}
+	params := &CheckVersionParams{
+		AppID:             "sample_app_1",
+		Version:           "1.0.0",
+		Lookuper:          envconfig.MapLookuper(nil),
+		CacheFileOverride: filepath.Join(t.TempDir(), "data.json"),
+		Fetcher:           fetcher,
+		CacheOnly:         true,
+	}
+	want := `Sample App 1 version 1.1.0 is available at [https://github.com/abcxyz/sample_app_1]. Use SAMPLE_APP_1_IGNORE_VERSIONS="1.1.0" (or "all") to ignore.`
+
+	// The refresh fetches, but holds the notice.
+	got, err := CheckAppVersionSync(context.Background(), params)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if got != "" {
+		t.Errorf("expected no notice from cache only check, got %q", got)
+	}
+
+	// The pending notice is shown from the cache, without fetching again.
+	params.CacheOnly = false
+	for i, want := range []string{want, ""} {
+		got, err := CheckAppVersionSync(context.Background(), params)
+		if err != nil {
+			t.Fatalf("check %d: unexpected error: %s", i, err.Error())
+		}
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("check %d: output was not as expected (-got,+want): %s", i, diff)
+		}
+	}
+	if got, want := fetcher.calls, 1; got != want {
+		t.Errorf("unexpected fetches. got %d want %d", got, want)
+	}
+}
+
+func TestCheckAppVersionSync_Retired(t *testing.T) {
+	t.Parallel()
+
+	dropAfter := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name    string
+		version string
+		env     map[string]string
+		retired *api.Retirement
+		want    string
+	}{
+		{
+			name:    "retired",
+			version: "1.0.0",
+			retired: &api.Retirement{DropAfter: dropAfter, Message: "Use new_app instead."},
+			want:    "Sample App 1 is deprecated and will stop receiving updates after 2025-06-01. Use new_app instead.",
+		},
+		{
+			name:    "retired_not_ignored_by_constraint",
+			version: "0.1.0",
+			env:     map[string]string{optout.IgnoreVersionsEnvVar: "1.0.0"},
+			retired: &api.Retirement{DropAfter: dropAfter},
+			want:    "Sample App 1 is deprecated and will stop receiving updates after 2025-06-01.",
+		},
+		{
+			name:    "retired_ignored_by_all",
+			version: "0.1.0",
+			env:     map[string]string{optout.IgnoreVersionsEnvVar: "all"},
+			retired: &api.Retirement{DropAfter: dropAfter},
+			want:    "",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := CheckAppVersionSync(context.Background(), &CheckVersionParams{
+				AppID:             "sample_app_1",
+				Version:           tc.version,
+				Lookuper:          envconfig.MapLookuper(tc.env),
+				CacheFileOverride: filepath.Join(t.TempDir(), "data.json"),
+				Fetcher: &staticFetcher{data: &AppResponse{
+					AppID:          "sample_app_1",
+					AppName:        "Sample App 1",
+					AppRepoURL:     "https://github.com/abcxyz/sample_app_1",
+					CurrentVersion: "1.0.0",
+					Retired:        tc.retired,
+				}},
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if diff := cmp.Diff(got, tc.want); diff != "" {
+				t.Errorf("output was not as expected (-got,+want): %s", diff)
+			}
+		})
+	}
+}
+
+func TestCheckAppVersionSync_KillSwitch(t *testing.T) {
+	t.Parallel()
+
+	kills := []*api.KillSwitch{
+		{Versions: "= 0.1.0", Message: "It double counts builds."},
+		{Versions: ">= 0.2.0, < 0.3.0"},
+	}
+	cases := []struct {
+		name    string
+		version string
+		env     map[string]string
+		want    string
+	}{
+		{
+			name:    "killed",
+			version: "0.1.0",
+			want: "Sample App 1 version 0.1.0 has a known problem and its telemetry is disabled. " +
+				"Please upgrade to version 1.0.0 at [https://github.com/abcxyz/sample_app_1]. It double counts builds.",
+		},
+		{
+			name:    "killed_not_ignored_by_constraint",
+			version: "0.2.1",
+			env:     map[string]string{optout.IgnoreVersionsEnvVar: "1.0.0"},
+			want: "Sample App 1 version 0.2.1 has a known problem and its telemetry is disabled. " +
+				"Please upgrade to version 1.0.0 at [https://github.com/abcxyz/sample_app_1].",
+		},
+		{
+			name:    "not_killed",
+			version: "0.3.0",
+			want: "Sample App 1 version 1.0.0 is available at [https://github.com/abcxyz/sample_app_1]. " +
+				"Use SAMPLE_APP_1_IGNORE_VERSIONS=\"1.0.0\" (or \"all\") to ignore.",
+		},
+		{
+			name:    "killed_ignored_by_all",
+			version: "0.1.0",
+			env:     map[string]string{optout.IgnoreVersionsEnvVar: "all"},
+			want:    "",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := CheckAppVersionSync(context.Background(), &CheckVersionParams{
+				AppID:             "sample_app_1",
+				Version:           tc.version,
+				Lookuper:          envconfig.MapLookuper(tc.env),
+				CacheFileOverride: filepath.Join(t.TempDir(), "data.json"),
+				Fetcher: &staticFetcher{data: &AppResponse{
+					AppID:          "sample_app_1",
+					AppName:        "Sample App 1",
+					AppRepoURL:     "https://github.com/abcxyz/sample_app_1",
+					CurrentVersion: "1.0.0",
+					KillSwitches:   kills,
+				}},
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if diff := cmp.Diff(got, tc.want); diff != "" {
+				t.Errorf("output was not as expected (-got,+want): %s", diff)
+			}
+		})
+	}
+}
+
+func Test_logFailedCheck(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name      string
+		err       error
+		wantLevel slog.Level
+		wantMsg   string
+	}{
+		{
+			name:      "failure",
+			err:       fmt.Errorf("connection refused"),
+			wantLevel: slog.LevelWarn,
+			wantMsg:   "failed to check for new versions",
+		},
+		{
+			name:      "skipped_unreachable",
+			err:       fmt.Errorf("failed to make request: %w", ErrServerUnreachable),
+			wantLevel: slog.LevelDebug,
+			wantMsg:   "skipped check for new versions",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			logHandler := slogassert.New(t, slog.LevelDebug, nil)
+			ctx := logging.WithLogger(context.Background(), slog.New(logHandler))
+
+			logFailedCheck(ctx, tc.err)
+			logHandler.AssertPrecise(slogassert.LogMessageMatch{
+				Message: tc.wantMsg,
+				Level:   tc.wantLevel,
+			})
+		})
+	}
+}
+
 // Note: These tests rely on timing and could be flaky if breakpoints are used.
 func Test_asyncFunctionCall(t *testing.T) {
 	t.Parallel()
@@ -312,192 +1054,154 @@ func Test_asyncFunctionCallWaitForResultToWrite(t *testing.T) {
 	}
 }
 
-func TestIsIgnored(t *testing.T) {
+func TestLocalCachedData_Migration(t *testing.T) {
 	t.Parallel()
 
+	want := &LocalVersionData{
+		LastCheckTimestamp: 1700000000,
+		NotifiedVersion:    "1.0.0",
+		AppResponse: AppResponse{
+			AppID:          "sample_app_1",
+			CurrentVersion: "1.0.0",
+		},
+	}
+
 	cases := []struct {
-		name    string
-		version string
-		config  *versionConfig
-		want    bool
-		wantErr string
+		name string
+		file string
 	}{
 		{
-			name:    "nothing_ignored",
-			version: "1.0.0",
-			config: &versionConfig{
-				IgnoreVersions: nil,
-			},
-			want: false,
-		},
-		{
-			name:    "all_ignored",
-			version: "1.0.0",
-			config: &versionConfig{
-				IgnoreVersions: []string{"all"},
-			},
-			want: true,
-		},
-		{
-			name:    "all_ignored_other_info",
-			version: "1.0.0",
-			config: &versionConfig{
-				IgnoreVersions: []string{"1.0.1", "<1.0.0", "all", ">1.0.0"},
-			},
-			want: true,
-		},
-		{
-			name:    "version_no_match",
-			version: "1.0.0",
-			config: &versionConfig{
-				IgnoreVersions: []string{"1.0.1", "<1.0.0", ">1.0.0"},
-			},
-			want: false,
+			name: "unversioned",
+			file: `{"lastCheckTimestamp":1700000000,"notifiedVersion":"1.0.0","appId":"sample_app_1","currentVersion":"1.0.0"}`,
 		},
 		{
-			name:    "version_match_last",
-			version: "1.0.0",
-			config: &versionConfig{
-				IgnoreVersions: []string{"1.0.1", "<1.0.0", ">1.0.0", "1.0.0"},
-			},
-			want: true,
+			name: "single_entry",
+			file: `{"schemaVersion":1,"lastCheckTimestamp":1700000000,"notifiedVersion":"1.0.0","appId":"sample_app_1","currentVersion":"1.0.0"}`,
 		},
 		{
-			name:    "version_exact_match",
-			version: "1.0.0",
-			config: &versionConfig{
-				IgnoreVersions: []string{"1.0.0"},
-			},
-			want: true,
-		},
-		{
-			name:    "version_constraint_lt",
-			version: "1.0.0",
-			config: &versionConfig{
-				IgnoreVersions: []string{"<1.0.1"},
-			},
-			want: true,
-		},
-		{
-			name:    "version_constraint_gt",
-			version: "1.0.0",
-			config: &versionConfig{
-				IgnoreVersions: []string{">0.0.1"},
-			},
-			want: true,
-		},
-		{
-			name:    "version_constraint_lte",
-			version: "1.0.0",
-			config: &versionConfig{
-				IgnoreVersions: []string{"<=1.0.0"},
-			},
-			want: true,
-		},
-		{
-			name:    "version_constraint_gte",
-			version: "1.0.0",
-			config: &versionConfig{
-				IgnoreVersions: []string{">=1.0.0"},
-			},
-			want: true,
-		},
-		{
-			name:    "version_prerelease",
-			version: "1.1.0-alpha",
-			config: &versionConfig{
-				IgnoreVersions: []string{"1.1.0-alpha"},
-			},
-			want: true,
-		},
-		{
-			name:    "version_broken",
-			version: "abcd",
-			config: &versionConfig{
-				IgnoreVersions: []string{"1.1.0-alpha"},
-			},
-			want:    false,
-			wantErr: "failed to parse version",
-		},
-		{
-			name:    "constraint_broken",
-			version: "1.0.0",
-			config: &versionConfig{
-				IgnoreVersions: []string{"alsdkfas"},
-			},
-			want:    false,
-			wantErr: "Malformed constraint",
+			name: "current",
+			file: `{"schemaVersion":2,"entries":{"default":{"lastCheckTimestamp":1700000000,"notifiedVersion":"1.0.0","appId":"sample_app_1","currentVersion":"1.0.0"}}}`,
 		},
 	}
 
 	for _, tc := range cases {
-		tc := tc
-
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
 
-			got, err := tc.config.isIgnored(tc.version)
+			params := &CheckVersionParams{CacheFileOverride: filepath.Join(t.TempDir(), "data.json")}
+			if err := os.WriteFile(params.CacheFileOverride, []byte(tc.file), 0o600); err != nil {
+				t.Fatalf("failed to setup test: %s", err.Error())
+			}
 
-			if diff := testutil.DiffErrString(err, tc.wantErr); diff != "" {
-				t.Error(diff)
+			got, err := loadLocalCachedData(params, defaultCacheKey)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if diff := cmp.Diff(got, want); diff != "" {
+				t.Errorf("unexpected cached data (-got,+want): %s", diff)
 			}
 
-			if want := tc.want; got != want {
-				t.Errorf("incorrect IsIgnored got=%t, want=%t", got, want)
+			// The file is rewritten in the current format.
+			b, err := os.ReadFile(params.CacheFileOverride)
+			if err != nil {
+				t.Fatalf("failed to read cache: %s", err.Error())
+			}
+			var stored struct {
+				SchemaVersion int                          `json:"schemaVersion"`
+				Entries       map[string]*LocalVersionData `json:"entries"`
+			}
+			if err := json.Unmarshal(b, &stored); err != nil {
+				t.Fatalf("failed to decode cache: %s", err.Error())
+			}
+			if got, want := stored.SchemaVersion, versionCacheSchema.Version(); got != want {
+				t.Errorf("unexpected schema version. got %d want %d", got, want)
+			}
+			if diff := cmp.Diff(stored.Entries[defaultCacheKey], want); diff != "" {
+				t.Errorf("unexpected stored entry (-got,+want): %s", diff)
 			}
 		})
 	}
 }
 
-func TestIgnoreAll(t *testing.T) {
+func TestCheck_CacheKeyedByChannel(t *testing.T) {
 	t.Parallel()
 
-	cases := []struct {
-		name   string
-		config *versionConfig
-		want   bool
-	}{
-		{
-			name: "no_versions_not_ignored",
-			config: &versionConfig{
-				IgnoreVersions: nil,
-			},
-			want: false,
-		},
-		{
-			name: "only_all_ignored",
-			config: &versionConfig{
-				IgnoreVersions: []string{"all"},
-			},
-			want: true,
-		},
-		{
-			name: "concrete_list_not_ignored",
-			config: &versionConfig{
-				IgnoreVersions: []string{"1.0.0", "3.0.2"},
-			},
-			want: false,
-		},
-		{
-			name: "concrete_list_with_all_ignored",
-			config: &versionConfig{
-				IgnoreVersions: []string{"1.0.0", "3.0.2", "all"},
-			},
-			want: true,
-		},
+	fetcher := &staticFetcher{data: &AppResponse{
+		AppID:          "sample_app_1",
+		AppName:        "Sample App 1",
+		AppRepoURL:     "https://github.com/abcxyz/sample_app_1",
+		CurrentVersion: "1.1.0",
+	}}
+	cacheFile := filepath.Join(t.TempDir(), "data.json")
+	check := func(includePrereleases bool) *CheckResult {
+		t.Helper()
+		got, err := Check(context.Background(), &CheckVersionParams{
+			AppID:              "sample_app_1",
+			Version:            "1.0.0",
+			Lookuper:           envconfig.MapLookuper(nil),
+			CacheFileOverride:  cacheFile,
+			IncludePrereleases: includePrereleases,
+			Fetcher:            fetcher,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		return got
 	}
 
-	for _, tc := range cases {
-		tc := tc
+	if got := check(false); got.Message == "" {
+		t.Errorf("expected a notice for the default channel")
+	}
+	// The prerelease channel has its own entry, so it is fetched and notified
+	// rather than reusing the default channel's.
+	if got := check(true); got.Message == "" {
+		t.Errorf("expected a notice for the prerelease channel")
+	}
+	// Switching back uses the default channel's cached entry.
+	if got := check(false); got.Message != "" {
+		t.Errorf("expected no notice from the cache, got %q", got.Message)
+	}
+	if got, want := fetcher.calls, 2; got != want {
+		t.Errorf("got %d fetches, want %d", got, want)
+	}
 
-		t.Run(tc.name, func(t *testing.T) {
-			t.Parallel()
+	var stored localVersionCache
+	if err := versionCacheSchema.LoadJSONFile(cacheFile, &stored); err != nil {
+		t.Fatalf("failed to read cache: %s", err.Error())
+	}
+	for _, key := range []string{defaultCacheKey, prereleaseCacheKey} {
+		if got := stored.Entries[key]; got == nil || got.NotifiedVersion != "1.1.0" {
+			t.Errorf("expected entry %q notified about 1.1.0, got %+v", key, got)
+		}
+	}
+}
 
-			got := tc.config.ignoreAll()
+func TestSetLocalCachedData_KeepsOtherEntries(t *testing.T) {
+	t.Parallel()
 
-			if want := tc.want; got != want {
-				t.Errorf("incorrect allVersionUpdatesIgnored got=%t, want=%t", got, want)
-			}
-		})
+	params := &CheckVersionParams{CacheFileOverride: filepath.Join(t.TempDir(), "data.json")}
+	other := `{"schemaVersion":2,"entries":{"prerelease":{"lastCheckTimestamp":1,"currentVersion":"2.0.0-rc.1"}}}`
+	if err := os.WriteFile(params.CacheFileOverride, []byte(other), 0o600); err != nil {
+		t.Fatalf("failed to setup test: %s", err.Error())
+	}
+
+	if _, err := loadLocalCachedData(params, defaultCacheKey); err == nil {
+		t.Errorf("expected error loading missing entry")
+	}
+	data := &LocalVersionData{LastCheckTimestamp: 2, AppResponse: AppResponse{CurrentVersion: "1.0.0"}}
+	if err := setLocalCachedData(params, defaultCacheKey, data); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	var stored localVersionCache
+	if err := versionCacheSchema.LoadJSONFile(params.CacheFileOverride, &stored); err != nil {
+		t.Fatalf("failed to read cache: %s", err.Error())
+	}
+	want := map[string]*LocalVersionData{
+		prereleaseCacheKey: {LastCheckTimestamp: 1, AppResponse: AppResponse{CurrentVersion: "2.0.0-rc.1"}},
+		defaultCacheKey:    data,
+	}
+	if diff := cmp.Diff(stored.Entries, want); diff != "" {
+		t.Errorf("unexpected cache entries (-got,+want): %s", diff)
 	}
 }
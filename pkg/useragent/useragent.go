@@ -0,0 +1,76 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package useragent builds the User-Agent header sent by abc-updater clients,
+// so server operators can distinguish traffic by app and library version.
+package useragent
+
+import (
+	"runtime/debug"
+	"strings"
+	"sync"
+)
+
+const (
+	modulePath = "github.com/abcxyz/abc-updater"
+
+	// devVersion is reported when the library version is unknown, e.g. in a
+	// build of this module itself.
+	devVersion = "devel"
+)
+
+// LibraryVersion returns the version of this module linked into the running
+// binary, without a leading "v", or "devel" if it is not known.
+var LibraryVersion = sync.OnceValue(func() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return devVersion
+	}
+	return libraryVersion(info)
+})
+
+func libraryVersion(info *debug.BuildInfo) string {
+	if info.Main.Path == modulePath {
+		return cleanVersion(info.Main.Version)
+	}
+	for _, dep := range info.Deps {
+		if dep.Path != modulePath {
+			continue
+		}
+		// A replacement by a local directory has no version.
+		if dep.Replace != nil {
+			return cleanVersion(dep.Replace.Version)
+		}
+		return cleanVersion(dep.Version)
+	}
+	return devVersion
+}
+
+func cleanVersion(v string) string {
+	if v == "" || v == "(devel)" {
+		return devVersion
+	}
+	return strings.TrimPrefix(v, "v")
+}
+
+// Format returns the default User-Agent for an app:
+// "abc-updater/<lib-version> (<appID>/<appVersion>)". appVersion may be
+// empty.
+func Format(appID, appVersion string) string {
+	app := appID
+	if appVersion != "" {
+		app += "/" + appVersion
+	}
+	return "abc-updater/" + LibraryVersion() + " (" + app + ")"
+}
@@ -0,0 +1,93 @@
+// Copyright 2024 The Authors (see AUTHORS file)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package useragent
+
+import (
+	"runtime/debug"
+	"testing"
+)
+
+func TestLibraryVersion(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		info *debug.BuildInfo
+		want string
+	}{
+		{
+			name: "dependency",
+			info: &debug.BuildInfo{
+				Main: debug.Module{Path: "example.com/tool", Version: "v1.0.0"},
+				Deps: []*debug.Module{
+					{Path: "example.com/other", Version: "v9.9.9"},
+					{Path: modulePath, Version: "v0.1.2"},
+				},
+			},
+			want: "0.1.2",
+		},
+		{
+			name: "replaced_by_version",
+			info: &debug.BuildInfo{
+				Main: debug.Module{Path: "example.com/tool"},
+				Deps: []*debug.Module{
+					{Path: modulePath, Version: "v0.1.2", Replace: &debug.Module{Path: "example.com/fork", Version: "v0.1.3"}},
+				},
+			},
+			want: "0.1.3",
+		},
+		{
+			name: "replaced_by_directory",
+			info: &debug.BuildInfo{
+				Main: debug.Module{Path: "example.com/tool"},
+				Deps: []*debug.Module{
+					{Path: modulePath, Version: "v0.1.2", Replace: &debug.Module{Path: "../abc-updater"}},
+				},
+			},
+			want: "devel",
+		},
+		{
+			name: "main_module",
+			info: &debug.BuildInfo{Main: debug.Module{Path: modulePath, Version: "(devel)"}},
+			want: "devel",
+		},
+		{
+			name: "not_linked",
+			info: &debug.BuildInfo{Main: debug.Module{Path: "example.com/tool"}},
+			want: "devel",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := libraryVersion(tc.info); got != tc.want {
+				t.Errorf("libraryVersion() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestFormat(t *testing.T) {
+	t.Parallel()
+
+	if got, want := Format("my_app", "1.2.3"), "abc-updater/"+LibraryVersion()+" (my_app/1.2.3)"; got != want {
+		t.Errorf("Format() = %q, want %q", got, want)
+	}
+	if got, want := Format("my_app", ""), "abc-updater/"+LibraryVersion()+" (my_app)"; got != want {
+		t.Errorf("Format() = %q, want %q", got, want)
+	}
+}